@@ -0,0 +1,288 @@
+// Package abuse scores the requests with the pluggable policies, like the rate anomalies,
+// the known bad user agents and the path scans, and flags or blocks the suspicious ones
+package abuse
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/middleware"
+	"github.com/tools-go/go-utils/trace"
+)
+
+// Action is what to do with a request
+type Action int
+
+const (
+	// Allow passes the request
+	Allow Action = iota
+	// Flag passes the request, the handlers decide how to treat it by the decision in the context
+	Flag
+	// Block rejects the request
+	Block
+)
+
+func (a Action) String() string {
+	switch a {
+	case Flag:
+		return "flag"
+	case Block:
+		return "block"
+	default:
+		return "allow"
+	}
+}
+
+// Request is the request evaluated by the policies
+type Request struct {
+	*http.Request
+	// IP is the client ip
+	IP string
+	// Time is when the request is evaluated
+	Time time.Time
+}
+
+// Signal is the score given by a policy
+type Signal struct {
+	Policy string  `json:"policy"`
+	Score  float64 `json:"score"`
+	Detail string  `json:"detail"`
+}
+
+// Decision is the result of the evaluation
+type Decision struct {
+	Action  Action   `json:"action"`
+	Score   float64  `json:"score"`
+	Signals []Signal `json:"signals,omitempty"`
+}
+
+// Stats is the counters of the detector
+type Stats struct {
+	Requests int64
+	Flagged  int64
+	Blocked  int64
+	// Signals is the count of the signals by policy
+	Signals map[string]int64
+}
+
+// Observer is called with every decision, it's used to export the metrics or alert
+type Observer func(ctx context.Context, r *Request, d Decision)
+
+// Option configures the Detector
+type Option func(d *Detector)
+
+// WithThresholds sets the scores to flag and to block the requests, the defaults are 1 and 3,
+// a threshold not greater than 0 disables the action
+func WithThresholds(flag, block float64) Option {
+	return func(d *Detector) {
+		d.flag = flag
+		d.block = block
+	}
+}
+
+// WithObserver sets the observer of the decisions
+func WithObserver(o Observer) Option {
+	return func(d *Detector) {
+		d.observer = o
+	}
+}
+
+// WithClientIP sets how to get the client ip, the default is the real ip of the trace middleware,
+// or the remote address. Use IPFilter.ClientIP of the middleware package behind the proxies.
+func WithClientIP(fn func(r *http.Request) string) Option {
+	return func(d *Detector) {
+		d.clientIP = fn
+	}
+}
+
+// WithBlockHandler sets the handler replying the blocked requests, the default replies 403,
+// a honeypot may reply something plausible to waste the time of the scanners instead
+func WithBlockHandler(h http.HandlerFunc) Option {
+	return func(d *Detector) {
+		d.blockHandler = h
+	}
+}
+
+// Detector evaluates the requests with the policies, the score of a request is the sum of the policies
+type Detector struct {
+	policies     []Policy
+	flag, block  float64
+	observer     Observer
+	clientIP     func(r *http.Request) string
+	blockHandler http.HandlerFunc
+	now          func() time.Time
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// New creates a Detector of the policies
+func New(policies []Policy, opts ...Option) *Detector {
+	d := &Detector{
+		policies: policies,
+		flag:     1,
+		block:    3,
+		clientIP: defaultClientIP,
+		now:      time.Now,
+		stats:    Stats{Signals: map[string]int64{}},
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+func defaultClientIP(r *http.Request) string {
+	if ip := trace.GetRealIPFromContext(r.Context()); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Evaluate scores the request and decides the action, the stats and the observer are updated
+func (d *Detector) Evaluate(r *http.Request) (*Request, Decision) {
+	req := &Request{Request: r, IP: d.clientIP(r), Time: d.now()}
+	var dec Decision
+	for _, p := range d.policies {
+		score, detail := p.Evaluate(req)
+		if score == 0 {
+			continue
+		}
+		dec.Score += score
+		dec.Signals = append(dec.Signals, Signal{Policy: p.Name(), Score: score, Detail: detail})
+	}
+	switch {
+	case d.block > 0 && dec.Score >= d.block:
+		dec.Action = Block
+	case d.flag > 0 && dec.Score >= d.flag:
+		dec.Action = Flag
+	}
+
+	d.mu.Lock()
+	d.stats.Requests++
+	switch dec.Action {
+	case Flag:
+		d.stats.Flagged++
+	case Block:
+		d.stats.Blocked++
+	}
+	for _, s := range dec.Signals {
+		d.stats.Signals[s.Policy]++
+	}
+	d.mu.Unlock()
+
+	if d.observer != nil {
+		d.observer(r.Context(), req, dec)
+	}
+	return req, dec
+}
+
+// Learn feeds the response status to the policies learning from the responses
+func (d *Detector) Learn(r *Request, status int) {
+	for _, p := range d.policies {
+		if l, ok := p.(Learner); ok {
+			l.Observe(r, status)
+		}
+	}
+}
+
+// Stats returns a snapshot of the counters
+func (d *Detector) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats := d.stats
+	stats.Signals = make(map[string]int64, len(d.stats.Signals))
+	for k, v := range d.stats.Signals {
+		stats.Signals[k] = v
+	}
+	return stats
+}
+
+type decisionKey struct{}
+
+// WithDecision returns a copy of ctx carrying the decision
+func WithDecision(ctx context.Context, d Decision) context.Context {
+	return context.WithValue(ctx, decisionKey{}, d)
+}
+
+// FromContext returns the decision of the request, ok is false if it's not evaluated
+func FromContext(ctx context.Context) (Decision, bool) {
+	d, ok := ctx.Value(decisionKey{}).(Decision)
+	return d, ok
+}
+
+// Log logs the flagged and blocked requests for the audit
+func (d *Detector) Log(ctx context.Context, r *Request, dec Decision) {
+	if dec.Action == Allow {
+		return
+	}
+	reasons := make([]string, 0, len(dec.Signals))
+	for _, s := range dec.Signals {
+		reasons = append(reasons, s.Policy+": "+s.Detail)
+	}
+	trace.GetTraceFromContext(ctx).Warnf("event=[abuse-%s] ip=[%s] score=[%.2f] reasons=[%s] method=[%s] url=[%s] ua=[%s]",
+		dec.Action, r.IP, dec.Score, strings.Join(reasons, "; "), r.Method, r.URL.String(), r.UserAgent())
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Middleware evaluates the requests, the blocked ones are rejected, and the others are passed
+// with the decision in the context, which can be got by FromContext
+func (d *Detector) Middleware() middleware.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			req, dec := d.Evaluate(r)
+			d.Log(r.Context(), req, dec)
+			r = r.WithContext(WithDecision(r.Context(), dec))
+			if dec.Action == Block {
+				if d.blockHandler != nil {
+					d.blockHandler(w, r)
+					return
+				}
+				e := errors.ErrSwitch(errors.NewForbiddenError("request blocked"))
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(e.Code)
+				json.NewEncoder(w).Encode(e)
+				return
+			}
+			sw := &statusWriter{ResponseWriter: w}
+			next(sw, r)
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			d.Learn(req, sw.status)
+		}
+	}
+}
+
+// BlockHandler returns the handler set by WithBlockHandler, nil if it's not set
+func (d *Detector) BlockHandler() http.HandlerFunc {
+	return d.blockHandler
+}
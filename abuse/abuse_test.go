@@ -0,0 +1,103 @@
+package abuse
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPolicies(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	req := func(path, ua string) *Request {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("User-Agent", ua)
+		return &Request{Request: r, IP: "10.0.0.1", Time: now}
+	}
+
+	rate := RatePolicy(3, time.Minute, 1)
+	for i := 0; i < 3; i++ {
+		if score, _ := rate.Evaluate(req("/", "curl")); score != 0 {
+			t.Fatalf("request %d: unexpected score", i)
+		}
+	}
+	if score, _ := rate.Evaluate(req("/", "curl")); score != 1 {
+		t.Fatal("expect the rate exceeded")
+	}
+	// half of the last window is counted
+	now = now.Add(90 * time.Second)
+	if score, _ := rate.Evaluate(req("/", "curl")); score != 0 {
+		t.Fatal("expect the rate recovered")
+	}
+
+	ua := UserAgentPolicy(2, true)
+	for agent, expect := range map[string]float64{"sqlmap/1.4": 2, "Mozilla/5.0 (compatible; Nmap Scripting Engine)": 2, "": 2, "Mozilla/5.0": 0} {
+		if score, _ := ua.Evaluate(req("/", agent)); score != expect {
+			t.Fatalf("%q: expect %v, got %v", agent, expect, score)
+		}
+	}
+
+	scan := PathScanPolicy(3, 2, time.Minute)
+	for path, expect := range map[string]float64{"/.env": 3, "/.git/config": 3, "/.git": 3, "/wp-admin/setup.php": 3, "/.environment": 0, "/api/users": 0} {
+		if score, _ := scan.Evaluate(req(path, "")); score != expect {
+			t.Fatalf("%s: expect %v, got %v", path, expect, score)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		scan.(Learner).Observe(req("/missing", ""), http.StatusNotFound)
+	}
+	if score, _ := scan.Evaluate(req("/api/users", "")); score != 3 {
+		t.Fatal("expect the 404s scored")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var observed []Decision
+	d := New([]Policy{
+		UserAgentPolicy(1, false, "badbot"),
+		PathScanPolicy(3, 10, time.Minute),
+		PolicyFunc("debug", func(r *Request) (float64, string) {
+			if r.URL.Query().Get("debug") != "" {
+				return 1.5, "debug param"
+			}
+			return 0, ""
+		}),
+	}, WithObserver(func(ctx context.Context, r *Request, d Decision) {
+		observed = append(observed, d)
+	}))
+
+	h := d.Middleware().HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dec, ok := FromContext(r.Context())
+		if !ok {
+			t.Fatal("expect the decision in the context")
+		}
+		w.Header().Set("X-Action", dec.Action.String())
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, c := range []struct {
+		path, ua string
+		status   int
+		action   string
+	}{
+		{"/", "Mozilla/5.0", http.StatusOK, "allow"},
+		{"/", "badbot/2.0", http.StatusOK, "flag"},
+		{"/?debug=1", "badbot/2.0", http.StatusOK, "flag"},
+		{"/.env", "Mozilla/5.0", http.StatusForbidden, ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, c.path, nil)
+		r.Header.Set("User-Agent", c.ua)
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != c.status || w.Header().Get("X-Action") != c.action {
+			t.Fatalf("%+v: unexpected %d %q", c, w.Code, w.Header().Get("X-Action"))
+		}
+	}
+	if len(observed) != 4 || observed[2].Score != 2.5 || len(observed[2].Signals) != 2 {
+		t.Fatalf("unexpected decisions %+v", observed)
+	}
+	stats := d.Stats()
+	if stats.Requests != 4 || stats.Flagged != 2 || stats.Blocked != 1 || stats.Signals["user_agent"] != 2 || stats.Signals["path_scan"] != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
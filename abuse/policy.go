@@ -0,0 +1,204 @@
+package abuse
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tools-go/go-utils/priority"
+)
+
+// Policy scores the suspicious requests, it should be safe for the concurrent use
+type Policy interface {
+	// Name is the name of the signals of the policy
+	Name() string
+	// Evaluate returns the score of the request, 0 if nothing is suspicious, and the detail of the signal
+	Evaluate(r *Request) (score float64, detail string)
+}
+
+// Learner is implemented by the policies learning from the responses, like counting the 404s
+type Learner interface {
+	Observe(r *Request, status int)
+}
+
+// PolicyFunc is an adapter to use a function as a Policy
+func PolicyFunc(name string, fn func(r *Request) (float64, string)) Policy {
+	return &policyFunc{name: name, fn: fn}
+}
+
+type policyFunc struct {
+	name string
+	fn   func(r *Request) (float64, string)
+}
+
+func (p *policyFunc) Name() string {
+	return p.name
+}
+
+func (p *policyFunc) Evaluate(r *Request) (float64, string) {
+	return p.fn(r)
+}
+
+// windowCounter counts the events of the keys in a sliding window, which is approximated by
+// weighting the count of the previous fixed window
+type windowCounter struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	start   time.Time
+	current map[string]int
+	last    map[string]int
+}
+
+func newWindowCounter(window time.Duration) *windowCounter {
+	return &windowCounter{window: window, current: map[string]int{}, last: map[string]int{}}
+}
+
+// add counts an event of key at now, and returns the estimated count in the sliding window
+func (w *windowCounter) add(key string, now time.Time, n int) float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if elapsed := now.Sub(w.start); elapsed >= w.window {
+		// the counts older than a window are dropped with the maps, so the idle keys never leak
+		if elapsed >= 2*w.window {
+			w.last = map[string]int{}
+		} else {
+			w.last = w.current
+		}
+		w.current = map[string]int{}
+		w.start = now.Truncate(w.window)
+	}
+	w.current[key] += n
+	weight := 1 - float64(now.Sub(w.start))/float64(w.window)
+	return float64(w.current[key]) + float64(w.last[key])*weight
+}
+
+type ratePolicy struct {
+	limit   int
+	score   float64
+	counter *windowCounter
+}
+
+// RatePolicy scores the clients sending more than limit requests in the window,
+// the critical requests classified by the priority package are neither counted nor scored
+func RatePolicy(limit int, window time.Duration, score float64) Policy {
+	return &ratePolicy{limit: limit, score: score, counter: newWindowCounter(window)}
+}
+
+func (p *ratePolicy) Name() string {
+	return "rate"
+}
+
+func (p *ratePolicy) Evaluate(r *Request) (float64, string) {
+	if priority.FromContext(r.Context()) == priority.Critical {
+		return 0, ""
+	}
+	if n := p.counter.add(r.IP, r.Time, 1); n > float64(p.limit) {
+		return p.score, fmt.Sprintf("%.0f requests exceed %d in %s", n, p.limit, p.counter.window)
+	}
+	return 0, ""
+}
+
+// DefaultBadUserAgents are the user agents of the common scanners and attack tools
+var DefaultBadUserAgents = []string{
+	"sqlmap", "nikto", "nmap", "masscan", "zgrab", "nuclei", "dirbuster", "gobuster", "wpscan", "acunetix", "netsparker",
+}
+
+type userAgentPolicy struct {
+	patterns []string
+	score    float64
+	empty    bool
+}
+
+// UserAgentPolicy scores the user agents containing any of the patterns case insensitively,
+// DefaultBadUserAgents is used if patterns is empty. The empty user agents are scored too if empty is true.
+func UserAgentPolicy(score float64, empty bool, patterns ...string) Policy {
+	if len(patterns) == 0 {
+		patterns = DefaultBadUserAgents
+	}
+	lower := make([]string, len(patterns))
+	for i, p := range patterns {
+		lower[i] = strings.ToLower(p)
+	}
+	return &userAgentPolicy{patterns: lower, score: score, empty: empty}
+}
+
+func (p *userAgentPolicy) Name() string {
+	return "user_agent"
+}
+
+func (p *userAgentPolicy) Evaluate(r *Request) (float64, string) {
+	ua := strings.ToLower(r.UserAgent())
+	if ua == "" {
+		if p.empty {
+			return p.score, "empty user agent"
+		}
+		return 0, ""
+	}
+	for _, pattern := range p.patterns {
+		if strings.Contains(ua, pattern) {
+			return p.score, "user agent matches " + pattern
+		}
+	}
+	return 0, ""
+}
+
+// DefaultHoneypotPaths are the paths never served, but probed by the scanners
+var DefaultHoneypotPaths = []string{
+	"/.env", "/.git/", "/wp-admin", "/wp-login.php", "/phpmyadmin", "/xmlrpc.php", "/.aws/", "/server-status", "/actuator/",
+}
+
+type pathScanPolicy struct {
+	paths   []string
+	score   float64
+	maxMiss int
+	counter *windowCounter
+}
+
+// PathScanPolicy scores the requests of the honeypot paths, DefaultHoneypotPaths is used if paths is empty,
+// a path ending with "/" matches its subpaths. The clients getting more than maxMiss 404s in the window
+// are scored too, as they are likely enumerating the paths.
+func PathScanPolicy(score float64, maxMiss int, window time.Duration, paths ...string) Policy {
+	if len(paths) == 0 {
+		paths = DefaultHoneypotPaths
+	}
+	return &pathScanPolicy{
+		paths:   paths,
+		score:   score,
+		maxMiss: maxMiss,
+		counter: newWindowCounter(window),
+	}
+}
+
+func (p *pathScanPolicy) Name() string {
+	return "path_scan"
+}
+
+func (p *pathScanPolicy) Evaluate(r *Request) (float64, string) {
+	path := strings.ToLower(r.URL.Path)
+	for _, honeypot := range p.paths {
+		if matchPath(path, honeypot) {
+			return p.score, "honeypot path " + honeypot
+		}
+	}
+	if n := p.counter.add(r.IP, r.Time, 0); n > float64(p.maxMiss) {
+		return p.score, fmt.Sprintf("%.0f not found responses exceed %d", n, p.maxMiss)
+	}
+	return 0, ""
+}
+
+func matchPath(path, honeypot string) bool {
+	if strings.HasSuffix(honeypot, "/") {
+		return path+"/" == honeypot || strings.HasPrefix(path, honeypot)
+	}
+	return path == honeypot || strings.HasPrefix(path, honeypot+"/")
+}
+
+// Observe counts the 404s of the client
+func (p *pathScanPolicy) Observe(r *Request, status int) {
+	if status == http.StatusNotFound {
+		p.counter.add(r.IP, r.Time, 1)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/leopoldxx/go-utils/cache"
+	"github.com/leopoldxx/go-utils/cache/counter"
+)
+
+func main() {
+	maxLen := flag.Int("max", 2, "the max count of the cached keys")
+	ttl := flag.Duration("ttl", 200*time.Millisecond, "the time to keep a key")
+	flag.Parse()
+
+	c := cache.NewCacheWithConfig(cache.Config{
+		MaxLen:    *maxLen,
+		CacheTime: *ttl,
+		Callback: func(key cache.Key, value cache.Value) {
+			log.Printf("evicted %v=%v", key, value)
+		},
+	})
+	defer c.Close()
+	// the hit rate of the last 10 windows
+	stats := counter.New(10)
+
+	get := func(key string) {
+		if v, ok := c.Get(key); ok {
+			stats.Hit()
+			log.Printf("hit %s=%v", key, v)
+			return
+		}
+		stats.Miss()
+		log.Printf("miss %s, loading", key)
+		c.Put(key, "value of "+key)
+	}
+
+	for _, key := range []string{"a", "b", "a", "c", "b"} {
+		get(key)
+	}
+	time.Sleep(*ttl + 50*time.Millisecond)
+	get("a")
+	c.PutWithTimeout("session", "long lived", time.Hour)
+	get("session")
+
+	hit, miss := stats.Value()
+	log.Printf("hit=%d miss=%d len=%d", hit, miss, c.Len())
+}
@@ -0,0 +1,221 @@
+// Package chaos injects the latency, errors and aborted connections into the requests for the resilience testing
+package chaos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/middleware"
+	"github.com/tools-go/go-utils/trace"
+)
+
+// ErrAborted is returned by the client transport when a connection is aborted by a fault
+var ErrAborted = errors.New("chaos: connection aborted")
+
+// Rule is a fault injected into a percentage of the requests
+type Rule struct {
+	// Name selects the rule by the scope header
+	Name string `json:"name" yaml:"name"`
+	// Percent of the requests injected, 0 to 100
+	Percent float64 `json:"percent" yaml:"percent"`
+	// Latency is added before the request is handled or sent
+	Latency time.Duration `json:"latency" yaml:"latency"`
+	// Status replies an error of the status instead of handling or sending the request
+	Status int `json:"status" yaml:"status"`
+	// Abort aborts the connection instead of handling or sending the request
+	Abort bool `json:"abort" yaml:"abort"`
+}
+
+// Config of the Injector
+type Config struct {
+	// Enabled is the feature flag checked for every request, nothing is injected if it's nil or returns false
+	Enabled func() bool
+	// Header scopes the injection, only the requests with the header are injected if it's set,
+	// the value is a comma list of the rule names, or "*" for all the rules
+	Header string
+	// Rules are tried in order, the first hit one is injected
+	Rules []Rule
+}
+
+// Injector injects the faults by the rules
+type Injector struct {
+	cfg Config
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	injected map[string]int64
+}
+
+// New creates an Injector
+func New(cfg Config) *Injector {
+	return &Injector{
+		cfg:      cfg,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		injected: map[string]int64{},
+	}
+}
+
+// Pick returns the rule to inject into the request, nil if nothing should be injected
+func (inj *Injector) Pick(r *http.Request) *Rule {
+	if inj.cfg.Enabled == nil || !inj.cfg.Enabled() {
+		return nil
+	}
+	var scope []string
+	if inj.cfg.Header != "" {
+		value := r.Header.Get(inj.cfg.Header)
+		if value == "" {
+			return nil
+		}
+		if value != "*" {
+			scope = strings.Split(value, ",")
+		}
+	}
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	for i := range inj.cfg.Rules {
+		rule := &inj.cfg.Rules[i]
+		if scope != nil && !contains(scope, rule.Name) {
+			continue
+		}
+		if inj.rand.Float64()*100 < rule.Percent {
+			inj.injected[rule.Name]++
+			return rule
+		}
+	}
+	return nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if strings.TrimSpace(n) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats returns the count of the injected faults by rule name
+func (inj *Injector) Stats() map[string]int64 {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	stats := make(map[string]int64, len(inj.injected))
+	for k, v := range inj.injected {
+		stats[k] = v
+	}
+	return stats
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func errorOf(status int) errors.Error {
+	return errors.Error{Code: status, Msg: fmt.Sprintf("chaos: injected %d", status)}
+}
+
+// Middleware injects the faults into the inbound requests
+func (inj *Injector) Middleware() middleware.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			rule := inj.Pick(r)
+			if rule == nil {
+				next(w, r)
+				return
+			}
+			trace.GetTraceFromRequest(r).Infof("event=[chaos-injected] rule=[%s] latency=[%s] status=[%d] abort=[%v] method=[%s] url=[%s]",
+				rule.Name, rule.Latency, rule.Status, rule.Abort, r.Method, r.URL.String())
+			if err := sleep(r.Context(), rule.Latency); err != nil {
+				return
+			}
+			switch {
+			case rule.Abort:
+				if hj, ok := w.(http.Hijacker); ok {
+					if conn, _, err := hj.Hijack(); err == nil {
+						conn.Close()
+						return
+					}
+				}
+				// the server aborts the connection silently
+				panic(http.ErrAbortHandler)
+			case rule.Status != 0:
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(rule.Status)
+				json.NewEncoder(w).Encode(errorOf(rule.Status))
+			default:
+				next(w, r)
+			}
+		}
+	}
+}
+
+// Transport injects the faults into the outbound requests sent by base, the http.DefaultTransport is used if it's nil
+func (inj *Injector) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{inj: inj, base: base}
+}
+
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+type transport struct {
+	inj  *Injector
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rule := t.inj.Pick(req)
+	if rule == nil {
+		return t.base.RoundTrip(req)
+	}
+	trace.GetTraceFromRequest(req).Infof("event=[chaos-injected] rule=[%s] latency=[%s] status=[%d] abort=[%v] method=[%s] url=[%s]",
+		rule.Name, rule.Latency, rule.Status, rule.Abort, req.Method, req.URL.String())
+	if err := sleep(req.Context(), rule.Latency); err != nil {
+		closeBody(req)
+		return nil, err
+	}
+	switch {
+	case rule.Abort:
+		closeBody(req)
+		return nil, ErrAborted
+	case rule.Status != 0:
+		closeBody(req)
+		body, _ := json.Marshal(errorOf(rule.Status))
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", rule.Status, http.StatusText(rule.Status)),
+			StatusCode:    rule.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"application/json"}},
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	default:
+		return t.base.RoundTrip(req)
+	}
+}
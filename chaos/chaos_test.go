@@ -0,0 +1,93 @@
+package chaos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	var enabled int32 = 1
+	inj := New(Config{
+		Enabled: func() bool { return atomic.LoadInt32(&enabled) == 1 },
+		Header:  "X-Chaos",
+		Rules: []Rule{
+			{Name: "slow", Percent: 100, Latency: 50 * time.Millisecond},
+			{Name: "error", Percent: 100, Status: http.StatusServiceUnavailable},
+			{Name: "abort", Percent: 100, Abort: true},
+			{Name: "never", Percent: 0, Status: http.StatusInternalServerError},
+		},
+	})
+	srv := httptest.NewServer(inj.Middleware().HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	get := func(scope string) (*http.Response, time.Duration, error) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if scope != "" {
+			req.Header.Set("X-Chaos", scope)
+		}
+		start := time.Now()
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, time.Since(start), err
+	}
+
+	if resp, _, err := get(""); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("expect the unscoped requests untouched")
+	}
+	if resp, elapsed, err := get("slow"); err != nil || resp.StatusCode != http.StatusOK || elapsed < 50*time.Millisecond {
+		t.Fatalf("expect the latency injected, got %v %v", elapsed, err)
+	}
+	if resp, _, err := get("never, error"); err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expect the error injected, got %v", err)
+	}
+	if _, _, err := get("abort"); err == nil {
+		t.Fatal("expect the connection aborted")
+	}
+	if resp, _, err := get("never"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("expect nothing injected")
+	}
+	atomic.StoreInt32(&enabled, 0)
+	if resp, _, err := get("error"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("expect nothing injected when disabled")
+	}
+
+	// the client may retry the aborted idempotent request
+	stats := inj.Stats()
+	if stats["slow"] != 1 || stats["error"] != 1 || stats["abort"] < 1 || stats["never"] != 0 {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	inj := New(Config{
+		Enabled: func() bool { return true },
+		Rules:   []Rule{{Name: "error", Percent: 100, Status: http.StatusBadGateway}},
+	})
+	client := &http.Client{Transport: inj.Transport(nil)}
+	resp, err := client.Get(srv.URL)
+	if err != nil || resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expect the error injected, got %v", err)
+	}
+	resp.Body.Close()
+
+	inj = New(Config{
+		Enabled: func() bool { return true },
+		Rules:   []Rule{{Name: "abort", Percent: 100, Abort: true}},
+	})
+	client = &http.Client{Transport: inj.Transport(nil)}
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Fatal("expect the request aborted")
+	}
+}
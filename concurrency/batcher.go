@@ -0,0 +1,198 @@
+package concurrency
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/leopoldxx/go-utils/errors"
+	"github.com/leopoldxx/go-utils/retry"
+	"github.com/leopoldxx/go-utils/trace"
+)
+
+// ErrBatcherClosed is returned when adding to a closed Batcher
+var ErrBatcherClosed = errors.New("batcher closed")
+
+// BatcherConfig is the config of the Batcher
+type BatcherConfig[T any] struct {
+	// Size flushes the items once Size items are accumulated, default is 100
+	Size int
+	// Interval flushes the accumulated items every Interval, default is 1s
+	Interval time.Duration
+	// QueueSize is the buffer size of the added items, Add blocks if it's full, default is 2*Size
+	QueueSize int
+	// Attempts and RetryInterval retry the failed flushes, default is no retry
+	Attempts      int
+	RetryInterval time.Duration
+	// OnError receives the items failed to flush after the retries, they are logged and dropped if it's nil
+	OnError func(items []T, err error)
+}
+
+// Batcher accumulates the items and flushes them in batches by size or interval,
+// the flushes are run one by one in a background goroutine.
+//
+// It's for the general batching of the services, like the writes to the databases or the remote apis.
+// The async backend of dlog, which its kafka backend is built on, keeps its own queue, since the logging
+// path needs what the Batcher doesn't offer: evicting the oldest logs when the queue is full, lingering
+// after the first log of a batch instead of a fixed interval, several writing goroutines and the flush
+// before the FATAL logs.
+type Batcher[T any] struct {
+	flush   func(ctx context.Context, items []T) error
+	cfg     BatcherConfig[T]
+	items   chan T
+	flushCh chan chan struct{}
+	done    chan struct{}
+	mu      sync.RWMutex
+	closed  bool
+}
+
+// NewBatcher creates a Batcher which flushes the items with flush
+func NewBatcher[T any](flush func(ctx context.Context, items []T) error, cfg BatcherConfig[T]) *Batcher[T] {
+	if cfg.Size <= 0 {
+		cfg.Size = 100
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 2 * cfg.Size
+	}
+	if cfg.Attempts <= 0 {
+		cfg.Attempts = 1
+	}
+	b := &Batcher[T]{
+		flush:   flush,
+		cfg:     cfg,
+		items:   make(chan T, cfg.QueueSize),
+		flushCh: make(chan chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Add adds an item, it blocks if the queue is full until ctx is done
+func (b *Batcher[T]) Add(ctx context.Context, item T) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrBatcherClosed
+	}
+	select {
+	case b.items <- item:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TryAdd adds an item without blocking, and returns false if the queue is full or the batcher is closed
+func (b *Batcher[T]) TryAdd(item T) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return false
+	}
+	select {
+	case b.items <- item:
+		return true
+	default:
+		return false
+	}
+}
+
+// Flush flushes the accumulated items and waits for it
+func (b *Batcher[T]) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case b.flushCh <- flushed:
+	case <-b.done:
+		return ErrBatcherClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting items, flushes the remaining ones and waits until ctx is done
+func (b *Batcher[T]) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.items)
+	}
+	b.mu.Unlock()
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Batcher[T]) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.cfg.Interval)
+	defer ticker.Stop()
+	batch := make([]T, 0, b.cfg.Size)
+	for {
+		select {
+		case item, ok := <-b.items:
+			if !ok {
+				b.doFlush(batch)
+				return
+			}
+			batch = append(batch, item)
+			if len(batch) < b.cfg.Size {
+				continue
+			}
+		case <-ticker.C:
+		case flushed := <-b.flushCh:
+			// take the items already queued too
+			for n := len(b.items); n > 0; n-- {
+				item, ok := <-b.items
+				if !ok {
+					break
+				}
+				if batch = append(batch, item); len(batch) >= b.cfg.Size {
+					b.doFlush(batch)
+					batch = make([]T, 0, b.cfg.Size)
+				}
+			}
+			b.doFlush(batch)
+			batch = make([]T, 0, b.cfg.Size)
+			close(flushed)
+			continue
+		}
+		if len(batch) > 0 {
+			b.doFlush(batch)
+			batch = make([]T, 0, b.cfg.Size)
+		}
+	}
+}
+
+func (b *Batcher[T]) doFlush(items []T) {
+	if len(items) == 0 {
+		return
+	}
+	ctx := context.TODO()
+	err := retry.Do(b.cfg.Attempts, func() error {
+		if err := b.flush(ctx, items); err != nil {
+			return retry.NewRetriableError(err.Error())
+		}
+		return nil
+	}, b.cfg.RetryInterval)
+	if err == nil {
+		return
+	}
+	if b.cfg.OnError != nil {
+		b.cfg.OnError(items, err)
+		return
+	}
+	trace.GetTraceFromContext(ctx).Errorf("flush %d items failed, dropped: %s", len(items), err)
+}
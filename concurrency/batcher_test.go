@@ -0,0 +1,92 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatcherSize(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]int
+	)
+	b := NewBatcher(func(ctx context.Context, items []int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, items)
+		return nil
+	}, BatcherConfig[int]{Size: 3, Interval: time.Hour})
+
+	ctx := context.TODO()
+	for i := 0; i < 7; i++ {
+		if err := b.Add(ctx, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(batches) != 3 || len(batches[0]) != 3 || len(batches[1]) != 3 || batches[2][0] != 6 {
+		t.Fatalf("unexpected batches: %v", batches)
+	}
+	mu.Unlock()
+
+	b.Add(ctx, 7)
+	if err := b.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 4 || batches[3][0] != 7 {
+		t.Fatalf("expect the remaining items flushed on close: %v", batches)
+	}
+	if err := b.Add(ctx, 8); err != ErrBatcherClosed || b.TryAdd(8) {
+		t.Fatalf("expect closed, got %v", err)
+	}
+}
+
+func TestBatcherInterval(t *testing.T) {
+	flushed := make(chan []string, 1)
+	b := NewBatcher(func(ctx context.Context, items []string) error {
+		flushed <- items
+		return nil
+	}, BatcherConfig[string]{Size: 100, Interval: 10 * time.Millisecond})
+	defer b.Close(context.TODO())
+
+	b.TryAdd("a")
+	select {
+	case items := <-flushed:
+		if len(items) != 1 || items[0] != "a" {
+			t.Fatalf("unexpected items: %v", items)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect flushed by interval")
+	}
+}
+
+func TestBatcherRetry(t *testing.T) {
+	var (
+		attempts int
+		failed   []int
+		failErr  error
+	)
+	b := NewBatcher(func(ctx context.Context, items []int) error {
+		attempts++
+		return errors.New("unavailable")
+	}, BatcherConfig[int]{
+		Size:          2,
+		Attempts:      3,
+		RetryInterval: time.Millisecond,
+		OnError: func(items []int, err error) {
+			failed, failErr = items, err
+		},
+	})
+	b.Add(context.TODO(), 1)
+	b.Add(context.TODO(), 2)
+	b.Close(context.TODO())
+	if attempts != 3 || len(failed) != 2 || failErr == nil {
+		t.Fatalf("unexpected retry: %d attempts, failed %v, %v", attempts, failed, failErr)
+	}
+}
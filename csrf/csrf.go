@@ -0,0 +1,181 @@
+// Package csrf protects the unsafe requests of the gin services against the cross site request forgery.
+//
+// The synchronizer token mode keeps the token in the session of the sessions package, so the
+// sessions middleware should be chained before. The double submit cookie mode keeps the token in a
+// cookie, and is for the services without sessions. In both modes the token is submitted by the
+// header or the form field, and the SPA mode exposes it in a cookie readable by the scripts,
+// which echo it in the header.
+package csrf
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tools-go/go-utils/dtrace"
+	"github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/ginmiddleware"
+	"github.com/tools-go/go-utils/sessions"
+)
+
+// the gin context key of the token of the request
+const tokenKey = "csrf.token"
+
+// the length of the random tokens in bytes
+const tokenLen = 32
+
+// Mode is where the expected tokens are kept
+type Mode int
+
+const (
+	// SynchronizerToken keeps the token in the session
+	SynchronizerToken Mode = iota
+	// DoubleSubmitCookie keeps the token in a cookie
+	DoubleSubmitCookie
+)
+
+// Config is the config of Protect
+type Config struct {
+	Mode Mode
+	// HeaderName is "X-CSRF-Token" by default
+	HeaderName string
+	// FormField is "csrf_token" by default, it is ignored in the SPA mode
+	FormField string
+	// CookieName is the cookie of the token in the DoubleSubmitCookie or the SPA mode, "csrf_token" by default
+	CookieName   string
+	CookiePath   string
+	CookieDomain string
+	Secure       bool
+	// SPA exposes the token in the cookie readable by the scripts, and accepts the header only
+	SPA bool
+	// Exempt are the routes not protected, like the webhooks authenticated by the signatures,
+	// a route is the full path of gin like "/hooks/:id", or a path prefix ending with "*" like "/hooks/*"
+	Exempt []string
+	// ExemptFunc exempts the requests if it returns true
+	ExemptFunc func(c *gin.Context) bool
+}
+
+// Token returns the token of the request to be embedded in the forms or the pages
+func Token(c *gin.Context) string {
+	return c.GetString(tokenKey)
+}
+
+// Protect verifies the tokens of the unsafe requests, the ones other than GET, HEAD, OPTIONS and TRACE,
+// and replies a 403 of the errors package if the token is missing or mismatched
+func Protect(cfg Config) ginmiddleware.Middleware {
+	if cfg.HeaderName == "" {
+		cfg.HeaderName = "X-CSRF-Token"
+	}
+	if cfg.FormField == "" {
+		cfg.FormField = "csrf_token"
+	}
+	if cfg.CookieName == "" {
+		cfg.CookieName = "csrf_token"
+	}
+	if cfg.CookiePath == "" {
+		cfg.CookiePath = "/"
+	}
+
+	return func(next gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			if exempted(c, &cfg) {
+				next(c)
+				return
+			}
+
+			// expected is called after the verification, so a rejected request never creates a session token
+			var expected func() string
+			var verify func(token string) bool
+			switch cfg.Mode {
+			case SynchronizerToken:
+				s := sessions.Get(c)
+				if s == nil {
+					dtrace.GetTraceFromContext(c).Errorf("csrf: no session, chain the sessions middleware before")
+					c.AbortWithStatusJSON(http.StatusInternalServerError, errors.ErrSwitch(errors.NewServerError("no session")))
+					return
+				}
+				expected, verify = s.CSRFToken, s.VerifyCSRF
+			default:
+				token := cookieToken(c, &cfg)
+				expected = func() string { return token }
+				verify = func(submitted string) bool {
+					return subtle.ConstantTimeCompare([]byte(token), []byte(submitted)) == 1
+				}
+			}
+
+			if !safeMethod(c.Request.Method) {
+				token := c.GetHeader(cfg.HeaderName)
+				if token == "" && !cfg.SPA {
+					token = c.PostForm(cfg.FormField)
+				}
+				if token == "" || !verify(token) {
+					dtrace.GetTraceFromContext(c).Warnf("csrf: reject %s %s", c.Request.Method, c.Request.URL.Path)
+					c.AbortWithStatusJSON(http.StatusForbidden, errors.ErrSwitch(errors.NewForbiddenError("invalid csrf token")))
+					return
+				}
+			}
+
+			token := expected()
+			c.Set(tokenKey, token)
+			if cfg.SPA || cfg.Mode == DoubleSubmitCookie {
+				setCookie(c, &cfg, token)
+			}
+			next(c)
+		}
+	}
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func exempted(c *gin.Context, cfg *Config) bool {
+	if cfg.ExemptFunc != nil && cfg.ExemptFunc(c) {
+		return true
+	}
+	for _, route := range cfg.Exempt {
+		if prefix := strings.TrimSuffix(route, "*"); prefix != route {
+			if strings.HasPrefix(c.Request.URL.Path, prefix) {
+				return true
+			}
+		} else if route == c.FullPath() || route == c.Request.URL.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// cookieToken returns the token of the double submit cookie, a new one is generated if absent
+func cookieToken(c *gin.Context, cfg *Config) string {
+	if cookie, err := c.Request.Cookie(cfg.CookieName); err == nil {
+		if b, err := base64.RawURLEncoding.DecodeString(cookie.Value); err == nil && len(b) == tokenLen {
+			return cookie.Value
+		}
+	}
+	b := make([]byte, tokenLen)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func setCookie(c *gin.Context, cfg *Config, token string) {
+	if cookie, err := c.Request.Cookie(cfg.CookieName); err == nil && cookie.Value == token {
+		return
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:   cfg.CookieName,
+		Value:  token,
+		Path:   cfg.CookiePath,
+		Domain: cfg.CookieDomain,
+		Secure: cfg.Secure,
+		// the scripts read the token in the SPA mode
+		HttpOnly: !cfg.SPA,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,145 @@
+package csrf
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/sessions"
+)
+
+type client struct {
+	handler http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func (cl *client) do(method, path, header string, form url.Values) *httptest.ResponseRecorder {
+	var body *strings.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	} else {
+		body = strings.NewReader("")
+	}
+	req := httptest.NewRequest(method, path, body)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if header != "" {
+		req.Header.Set("X-CSRF-Token", header)
+	}
+	for _, c := range cl.cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	cl.handler.ServeHTTP(w, req)
+	for _, c := range w.Result().Cookies() {
+		cl.cookies[c.Name] = c
+	}
+	return w
+}
+
+func newClient(cfg Config, chain ...func(gin.HandlerFunc) gin.HandlerFunc) *client {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	handler := func(c *gin.Context) {
+		c.String(http.StatusOK, Token(c))
+	}
+	wrap := func(h gin.HandlerFunc) gin.HandlerFunc {
+		h = Protect(cfg).HandlerFunc(h)
+		for _, m := range chain {
+			h = m(h)
+		}
+		return h
+	}
+	r.GET("/form", wrap(handler))
+	r.POST("/submit", wrap(handler))
+	r.POST("/hooks/:id", wrap(handler))
+	r.POST("/public/ping", wrap(handler))
+	return &client{handler: r, cookies: map[string]*http.Cookie{}}
+}
+
+func expectForbidden(t *testing.T, w *httptest.ResponseRecorder) {
+	t.Helper()
+	var e errors.Error
+	if w.Code != http.StatusForbidden || json.Unmarshal(w.Body.Bytes(), &e) != nil || e.Code != http.StatusForbidden {
+		t.Fatalf("expect 403, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSynchronizerToken(t *testing.T) {
+	codec, _ := sessions.NewCodec([]byte("0123456789abcdef"))
+	m := sessions.New(sessions.NewMemoryStore(), codec)
+	cl := newClient(Config{Exempt: []string{"/hooks/:id", "/public/*"}}, m.Middleware())
+
+	token := cl.do(http.MethodGet, "/form", "", nil).Body.String()
+	if token == "" {
+		t.Fatal("expect a token")
+	}
+	if _, ok := cl.cookies["csrf_token"]; ok {
+		t.Fatal("expect no token cookie in the synchronizer mode")
+	}
+	expectForbidden(t, cl.do(http.MethodPost, "/submit", "", nil))
+	expectForbidden(t, cl.do(http.MethodPost, "/submit", "forged", nil))
+	if w := cl.do(http.MethodPost, "/submit", "", url.Values{"csrf_token": {token}}); w.Code != http.StatusOK {
+		t.Fatalf("unexpected %d", w.Code)
+	}
+	if w := cl.do(http.MethodPost, "/submit", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("unexpected %d", w.Code)
+	}
+
+	// another session never accepts the token
+	other := &client{handler: cl.handler, cookies: map[string]*http.Cookie{}}
+	expectForbidden(t, other.do(http.MethodPost, "/submit", token, nil))
+
+	for _, path := range []string{"/hooks/github", "/public/ping"} {
+		if w := other.do(http.MethodPost, path, "", nil); w.Code != http.StatusOK {
+			t.Fatalf("%s: expect exempted, got %d", path, w.Code)
+		}
+	}
+
+	// without the sessions middleware
+	noSession := newClient(Config{})
+	if w := noSession.do(http.MethodGet, "/form", "", nil); w.Code != http.StatusInternalServerError {
+		t.Fatalf("unexpected %d", w.Code)
+	}
+}
+
+func TestDoubleSubmitCookie(t *testing.T) {
+	cl := newClient(Config{Mode: DoubleSubmitCookie})
+	token := cl.do(http.MethodGet, "/form", "", nil).Body.String()
+	cookie := cl.cookies["csrf_token"]
+	if cookie == nil || cookie.Value != token || !cookie.HttpOnly {
+		t.Fatalf("unexpected cookie %+v", cookie)
+	}
+	if w := cl.do(http.MethodGet, "/form", "", nil); w.Body.String() != token || len(w.Result().Cookies()) != 0 {
+		t.Fatal("expect the token kept")
+	}
+	if w := cl.do(http.MethodPost, "/submit", "", url.Values{"csrf_token": {token}}); w.Code != http.StatusOK {
+		t.Fatalf("unexpected %d", w.Code)
+	}
+	expectForbidden(t, cl.do(http.MethodPost, "/submit", "", nil))
+
+	// a forged request carries the cookie, but can not read it
+	expectForbidden(t, cl.do(http.MethodPost, "/submit", strings.Repeat("A", 43), nil))
+	other := &client{handler: cl.handler, cookies: map[string]*http.Cookie{}}
+	expectForbidden(t, other.do(http.MethodPost, "/submit", token, nil))
+}
+
+func TestSPA(t *testing.T) {
+	cl := newClient(Config{Mode: DoubleSubmitCookie, SPA: true, CookieName: "XSRF-TOKEN"})
+	cl.do(http.MethodGet, "/form", "", nil)
+	cookie := cl.cookies["XSRF-TOKEN"]
+	if cookie == nil || cookie.HttpOnly {
+		t.Fatalf("expect a readable cookie, got %+v", cookie)
+	}
+	// the form field is ignored in the SPA mode
+	expectForbidden(t, cl.do(http.MethodPost, "/submit", "", url.Values{"csrf_token": {cookie.Value}}))
+	if w := cl.do(http.MethodPost, "/submit", cookie.Value, nil); w.Code != http.StatusOK {
+		t.Fatalf("unexpected %d", w.Code)
+	}
+}
@@ -0,0 +1,142 @@
+// Package deploy reads the deployment metadata, like the env, the region and the canary flag,
+// so the logs, the metrics and the routing decisions can tell where the process runs
+package deploy
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	"git.xiaojukeji.com/devops/MachineAlliance/self/commons/trace/dlog"
+	"github.com/tools-go/go-utils/dtrace"
+	"github.com/tools-go/go-utils/trace"
+)
+
+// Metadata of the deployment
+type Metadata struct {
+	Env    string `json:"env" yaml:"env"`
+	Region string `json:"region" yaml:"region"`
+	Zone   string `json:"zone" yaml:"zone"`
+	// Color is the color of the blue green deployment
+	Color   string `json:"color" yaml:"color"`
+	Canary  bool   `json:"canary" yaml:"canary"`
+	Version string `json:"version" yaml:"version"`
+	// the pod of kubernetes, by the downward API
+	Pod       string `json:"pod" yaml:"pod"`
+	Namespace string `json:"namespace" yaml:"namespace"`
+	Node      string `json:"node" yaml:"node"`
+}
+
+// FromEnv reads the metadata from the environment variables DEPLOY_ENV, DEPLOY_REGION, DEPLOY_ZONE,
+// DEPLOY_COLOR, DEPLOY_CANARY and DEPLOY_VERSION, and the pod from POD_NAME, POD_NAMESPACE and NODE_NAME
+// set by the downward API, the pod is the hostname in kubernetes if POD_NAME is not set
+func FromEnv() Metadata {
+	canary, _ := strconv.ParseBool(os.Getenv("DEPLOY_CANARY"))
+	m := Metadata{
+		Env:       os.Getenv("DEPLOY_ENV"),
+		Region:    os.Getenv("DEPLOY_REGION"),
+		Zone:      os.Getenv("DEPLOY_ZONE"),
+		Color:     os.Getenv("DEPLOY_COLOR"),
+		Canary:    canary,
+		Version:   os.Getenv("DEPLOY_VERSION"),
+		Pod:       os.Getenv("POD_NAME"),
+		Namespace: os.Getenv("POD_NAMESPACE"),
+		Node:      os.Getenv("NODE_NAME"),
+	}
+	if m.Pod == "" && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		m.Pod, _ = os.Hostname()
+	}
+	return m
+}
+
+// Merge returns m with the empty fields filled by other, e.g. the config file overridden by the env:
+// FromEnv().Merge(cfg.Deploy)
+func (m Metadata) Merge(other Metadata) Metadata {
+	m.Env = or(m.Env, other.Env)
+	m.Region = or(m.Region, other.Region)
+	m.Zone = or(m.Zone, other.Zone)
+	m.Color = or(m.Color, other.Color)
+	m.Version = or(m.Version, other.Version)
+	m.Pod = or(m.Pod, other.Pod)
+	m.Namespace = or(m.Namespace, other.Namespace)
+	m.Node = or(m.Node, other.Node)
+	m.Canary = m.Canary || other.Canary
+	return m
+}
+
+func or(a, b string) string {
+	if a != "" {
+		return a
+	}
+	return b
+}
+
+// Labels returns the metadata as the labels of the metrics, the empty ones are omitted.
+// The pod is not a label, as it's added by the scraping of the pods.
+func (m Metadata) Labels() map[string]string {
+	labels := map[string]string{"canary": strconv.FormatBool(m.Canary)}
+	for k, v := range map[string]string{"env": m.Env, "region": m.Region, "zone": m.Zone, "color": m.Color, "version": m.Version} {
+		if v != "" {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+// fields returns the labels and the pod as the key value pairs in a stable order, with the container
+// detected by dlog.DetectContainer
+func (m Metadata) fields(c dlog.Container) []string {
+	var kvs []string
+	for _, kv := range [][2]string{{"env", m.Env}, {"region", m.Region}, {"zone", m.Zone}, {"color", m.Color}, {"version", m.Version}} {
+		if kv[1] != "" {
+			kvs = append(kvs, kv[0], kv[1])
+		}
+	}
+	if m.Canary {
+		kvs = append(kvs, "canary", "true")
+	}
+	for _, kv := range [][2]string{{"pod", m.Pod}, {"namespace", m.Namespace}, {"node", m.Node}, {"container", c.ShortID()}} {
+		if kv[1] != "" {
+			kvs = append(kvs, kv[0], kv[1])
+		}
+	}
+	return kvs
+}
+
+var current atomic.Value // Metadata
+
+// Init sets the metadata of the process, and logs it with every trace created afterwards, along with
+// the id of the container
+func Init(m Metadata) {
+	current.Store(m)
+	fields := m.fields(dlog.DetectContainer())
+	trace.SetInitialFields(fields...)
+	dtrace.SetInitialFields(fields...)
+}
+
+// Current returns the metadata set by Init
+func Current() Metadata {
+	m, _ := current.Load().(Metadata)
+	return m
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx carrying m, like the metadata of the upstream sending the request
+func WithMetadata(ctx context.Context, m Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, m)
+}
+
+// FromContext returns the metadata in ctx, or the current one if absent
+func FromContext(ctx context.Context) Metadata {
+	if m, ok := ctx.Value(metadataKey{}).(Metadata); ok {
+		return m
+	}
+	return Current()
+}
+
+// IsCanary reports whether the metadata in ctx is a canary
+func IsCanary(ctx context.Context) bool {
+	return FromContext(ctx).Canary
+}
@@ -0,0 +1,55 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.xiaojukeji.com/devops/MachineAlliance/self/commons/trace/dlog"
+	"github.com/tools-go/go-utils/trace"
+)
+
+func TestMetadata(t *testing.T) {
+	os.Setenv("DEPLOY_ENV", "prod")
+	os.Setenv("DEPLOY_ZONE", "us-east-1a")
+	os.Setenv("DEPLOY_CANARY", "true")
+	defer func() {
+		for _, k := range []string{"DEPLOY_ENV", "DEPLOY_ZONE", "DEPLOY_CANARY"} {
+			os.Unsetenv(k)
+		}
+	}()
+
+	m := FromEnv().Merge(Metadata{Env: "test", Region: "us-east-1"})
+	expect := Metadata{Env: "prod", Region: "us-east-1", Zone: "us-east-1a", Canary: true}
+	if m != expect {
+		t.Fatalf("unexpected %+v", m)
+	}
+	if labels := m.Labels(); !reflect.DeepEqual(labels, map[string]string{
+		"env": "prod", "region": "us-east-1", "zone": "us-east-1a", "canary": "true",
+	}) {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+
+	if IsCanary(context.Background()) {
+		t.Fatal("expect no metadata before Init")
+	}
+	Init(m)
+	defer Init(Metadata{})
+	if !IsCanary(context.Background()) {
+		t.Fatal("expect the current metadata")
+	}
+	if IsCanary(WithMetadata(context.Background(), Metadata{Env: "prod"})) {
+		t.Fatal("expect the metadata in the context")
+	}
+	if header := trace.New("test").String(); !strings.Contains(header, "env=[prod] region=[us-east-1] zone=[us-east-1a] canary=[true] ") {
+		t.Fatalf("expect the fields in the trace, got %s", header)
+	}
+
+	m = Metadata{Env: "prod", Pod: "orders-7d9f-x2x", Namespace: "shop"}
+	if fields := strings.Join(m.fields(dlog.Container{Runtime: "containerd", ID: strings.Repeat("ab", 32)}), ","); fields !=
+		"env,prod,pod,orders-7d9f-x2x,namespace,shop,container,abababababab" {
+		t.Fatalf("unexpected fields %s", fields)
+	}
+}
@@ -0,0 +1,102 @@
+// Package diag saves the diagnostic snapshots of the service, like the goroutine stacks and the profiles,
+// to a dir for the later investigation, and captures them automatically for the slow requests
+package diag
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store saves the snapshots to a dir, keeping the latest ones
+type Store struct {
+	dir  string
+	keep int
+
+	mu sync.Mutex
+}
+
+// NewStore creates a Store saving to dir, only the latest keep snapshots are kept, 100 if keep is 0
+func NewStore(dir string, keep int) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if keep <= 0 {
+		keep = 100
+	}
+	return &Store{dir: dir, keep: keep}, nil
+}
+
+// Dir returns the dir of the snapshots
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Save writes data to a file named by the time and name, like 20160711-143010.000-slow-3f2a-goroutine.txt,
+// and removes the oldest snapshots beyond the limit. It returns the path of the file.
+func (s *Store) Save(name string, data []byte) (string, error) {
+	name = time.Now().Format("20060102-150405.000") + "-" + unsafeChars.ReplaceAllString(name, "_")
+	path := filepath.Join(s.dir, name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// written to a temp file first, so a half written snapshot is never seen
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	s.prune()
+	return path, nil
+}
+
+// List returns the paths of the snapshots, the oldest first
+func (s *Store) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names, err := s.names()
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(s.dir, name)
+	}
+	return paths, nil
+}
+
+// names returns the names of the snapshots sorted by the times in them
+func (s *Store) names() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if info.Mode().IsRegular() && !strings.HasSuffix(info.Name(), ".tmp") {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *Store) prune() {
+	names, err := s.names()
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(names)-s.keep; i++ {
+		os.Remove(filepath.Join(s.dir, names[i]))
+	}
+}
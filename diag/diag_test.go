@@ -0,0 +1,77 @@
+package diag
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tools-go/go-utils/trace"
+)
+
+func TestStore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "diag")
+	defer os.RemoveAll(dir)
+	s, err := NewStore(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b/../c", "d"} {
+		if _, err := s.Save(name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	paths, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || !strings.HasSuffix(paths[0], "-b_.._c") || !strings.HasSuffix(paths[1], "-d") {
+		t.Fatalf("expect the latest 2 kept, got %v", paths)
+	}
+}
+
+func TestSlowProfiler(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "diag")
+	defer os.RemoveAll(dir)
+	s, _ := NewStore(dir, 0)
+	p, err := NewSlowProfiler(SlowConfig{Threshold: 20 * time.Millisecond, MinInterval: time.Hour, Store: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := trace.HandleFunc("slow", p.Middleware().HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if paths, _ := s.List(); len(paths) != 0 {
+		t.Fatalf("expect no snapshot of the fast request, got %v", paths)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/slow", nil)
+	req.Header.Set("x-request-id", "3f2a")
+	h(httptest.NewRecorder(), req)
+	paths, _ := s.List()
+	if len(paths) != 1 || !strings.HasSuffix(paths[0], "-slow-3f2a-goroutine.txt") {
+		t.Fatalf("expect the goroutines of the slow request, got %v", paths)
+	}
+	data, _ := ioutil.ReadFile(paths[0])
+	if !strings.HasPrefix(string(data), "trace_id: 3f2a\nrequest: GET /slow\n") || !strings.Contains(string(data), "diag.TestSlowProfiler") {
+		t.Fatalf("unexpected snapshot %s", data)
+	}
+
+	// within MinInterval
+	h(httptest.NewRecorder(), req)
+	if stats := p.Stats(); stats.Captured != 1 || stats.Skipped != 1 || stats.Errors != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if paths, _ := s.List(); len(paths) != 1 || filepath.Dir(paths[0]) != dir {
+		t.Fatalf("expect no more snapshot, got %v", paths)
+	}
+}
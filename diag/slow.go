@@ -0,0 +1,126 @@
+package diag
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/pprof"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tools-go/go-utils/middleware"
+	"github.com/tools-go/go-utils/trace"
+)
+
+// SlowConfig of the SlowProfiler
+type SlowConfig struct {
+	// Threshold of the latency, the snapshots are captured when a request is still running after it
+	Threshold time.Duration
+	// CPUProfile is the duration of the cpu profile captured along with the goroutine stacks, 0 disables it.
+	// Only one cpu profile runs at a time in the process, it's skipped if another one is running.
+	CPUProfile time.Duration
+	// MinInterval between the captures, so a slow dependency doesn't flood the store, the default is 1m
+	MinInterval time.Duration
+	Store       *Store
+}
+
+// SlowStats of the SlowProfiler
+type SlowStats struct {
+	Captured int64
+	Skipped  int64 // the slow requests not captured as the last capture is within MinInterval
+	Errors   int64
+}
+
+// SlowProfiler captures the goroutine stacks, and optionally a cpu profile, of the requests slower than
+// the threshold while they are still running, tagged by the trace ids of them
+type SlowProfiler struct {
+	cfg  SlowConfig
+	last int64 // the unix nano of the last capture
+
+	captured, skipped, errors int64
+}
+
+// NewSlowProfiler creates a SlowProfiler
+func NewSlowProfiler(cfg SlowConfig) (*SlowProfiler, error) {
+	if cfg.Threshold <= 0 {
+		return nil, errors.New("the threshold of the slow requests is required")
+	}
+	if cfg.Store == nil {
+		return nil, errors.New("the store of the snapshots is required")
+	}
+	if cfg.MinInterval <= 0 {
+		cfg.MinInterval = time.Minute
+	}
+	return &SlowProfiler{cfg: cfg}, nil
+}
+
+// Stats returns the counters
+func (p *SlowProfiler) Stats() SlowStats {
+	return SlowStats{
+		Captured: atomic.LoadInt64(&p.captured),
+		Skipped:  atomic.LoadInt64(&p.skipped),
+		Errors:   atomic.LoadInt64(&p.errors),
+	}
+}
+
+// Middleware serves the requests as usual, and captures the snapshots in the background once a request
+// exceeds the threshold. It's used inside the trace middleware, so the snapshots are tagged by the trace ids.
+func (p *SlowProfiler) Middleware() middleware.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			timer := time.AfterFunc(p.cfg.Threshold, func() { p.capture(r, start) })
+			defer timer.Stop()
+			next(w, r)
+		}
+	}
+}
+
+// acquire tells whether a capture is allowed now by MinInterval
+func (p *SlowProfiler) acquire(now time.Time) bool {
+	last := atomic.LoadInt64(&p.last)
+	if last != 0 && now.Sub(time.Unix(0, last)) < p.cfg.MinInterval {
+		return false
+	}
+	return atomic.CompareAndSwapInt64(&p.last, last, now.UnixNano())
+}
+
+func (p *SlowProfiler) capture(r *http.Request, start time.Time) {
+	if !p.acquire(time.Now()) {
+		atomic.AddInt64(&p.skipped, 1)
+		return
+	}
+	atomic.AddInt64(&p.captured, 1)
+	tracer := trace.GetTraceFromRequest(r)
+	id := "notrace"
+	if tracer != nil {
+		id = tracer.ID()
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "trace_id: %s\nrequest: %s %s\nelapsed: %s\n\n", id, r.Method, r.URL.String(), time.Since(start))
+	pprof.Lookup("goroutine").WriteTo(&buf, 2)
+	var paths []string
+	path, err := p.cfg.Store.Save("slow-"+id+"-goroutine.txt", buf.Bytes())
+	if err == nil {
+		paths = append(paths, path)
+	}
+	if err == nil && p.cfg.CPUProfile > 0 {
+		buf.Reset()
+		if err = pprof.StartCPUProfile(&buf); err == nil {
+			time.Sleep(p.cfg.CPUProfile)
+			pprof.StopCPUProfile()
+			if path, err = p.cfg.Store.Save("slow-"+id+"-cpu.pprof", buf.Bytes()); err == nil {
+				paths = append(paths, path)
+			}
+		}
+	}
+	if err != nil {
+		atomic.AddInt64(&p.errors, 1)
+	}
+	if tracer != nil {
+		tracer.Warnf("event=[slow-request] threshold=[%s] snapshots=[%s] err=[%v]", p.cfg.Threshold, strings.Join(paths, ","), err)
+	}
+}
@@ -0,0 +1,272 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/trace"
+)
+
+type consulOptions struct {
+	token  string
+	client *http.Client
+	wait   time.Duration
+}
+
+// ConsulOption for the consul registry
+type ConsulOption func(opts *consulOptions)
+
+// WithConsulToken set the ACL token of the consul requests
+func WithConsulToken(token string) ConsulOption {
+	return func(opts *consulOptions) {
+		opts.token = token
+	}
+}
+
+// WithHTTPClient set the http client of the consul requests
+func WithHTTPClient(client *http.Client) ConsulOption {
+	return func(opts *consulOptions) {
+		opts.client = client
+	}
+}
+
+// WithWatchWait set the max wait time of the blocking queries used by Watch, default is 1 minute
+func WithWatchWait(d time.Duration) ConsulOption {
+	return func(opts *consulOptions) {
+		opts.wait = d
+	}
+}
+
+type consul struct {
+	addr string
+	opts *consulOptions
+}
+
+// NewConsul creates a Registry of the consul agent at addr like "http://127.0.0.1:8500",
+// the registrations use the TTL checks of the agent
+func NewConsul(addr string, ops ...ConsulOption) Registry {
+	opts := &consulOptions{client: http.DefaultClient, wait: time.Minute}
+	for _, op := range ops {
+		op(opts)
+	}
+	return &consul{addr: strings.TrimSuffix(addr, "/"), opts: opts}
+}
+
+// do sends the request, and decodes the response into result if it's not nil
+func (c *consul) do(ctx context.Context, method, path string, query url.Values, body, result interface{}) (http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	api := c.addr + path
+	if len(query) > 0 {
+		api += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, api, reader)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if c.opts.token != "" {
+		req.Header.Set("X-Consul-Token", c.opts.token)
+	}
+	resp, err := c.opts.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errors.NewNotFoundError(path)
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("consul %s %s failed: %s %s", method, path, resp.Status, bytes.TrimSpace(data))
+	}
+	if result != nil {
+		if err := json.Unmarshal(data, result); err != nil {
+			return nil, fmt.Errorf("invalid consul response: %s", err)
+		}
+	}
+	return resp.Header, nil
+}
+
+type consulService struct {
+	ID      string
+	Service string
+	Address string
+	Port    int
+	Tags    []string
+	Meta    map[string]string
+	Weights *struct {
+		Passing int
+		Warning int
+	} `json:",omitempty"`
+}
+
+func (c *consul) register(ctx context.Context, ins Instance, ttl time.Duration) error {
+	host, port := splitAddr(ins.Addr)
+	body := map[string]interface{}{
+		"ID":      ins.ID,
+		"Name":    ins.Name,
+		"Address": host,
+		"Port":    port,
+		"Tags":    ins.Tags,
+		"Meta":    ins.Meta,
+		"Check": map[string]string{
+			"CheckID": "service:" + ins.ID,
+			"TTL":     ttl.String(),
+			// clean up the instances which are crashed without deregistration
+			"DeregisterCriticalServiceAfter": (10 * ttl).String(),
+		},
+	}
+	if ins.Weight > 0 {
+		body["Weights"] = map[string]int{"Passing": ins.Weight, "Warning": 1}
+	}
+	if _, err := c.do(ctx, http.MethodPut, "/v1/agent/service/register", nil, body, nil); err != nil {
+		return err
+	}
+	return c.pass(ctx, ins.ID)
+}
+
+func (c *consul) pass(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodPut, "/v1/agent/check/pass/service:"+url.PathEscape(id), nil, nil, nil)
+	return err
+}
+
+func (c *consul) Register(ctx context.Context, ins Instance, ops ...RegisterOption) (Deregister, error) {
+	if err := ins.Validate(); err != nil {
+		return nil, err
+	}
+	ttl := RegisterTTL(ops)
+	if err := c.register(ctx, ins, ttl); err != nil {
+		return nil, err
+	}
+
+	tracer := trace.GetTraceFromContext(ctx)
+	heartbeatCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-heartbeatCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := c.pass(heartbeatCtx, ins.ID)
+			if errors.IsNotFoundError(err) {
+				// the check is removed, e.g. the agent is restarted
+				tracer.Warnf("instance %s/%s is not found in consul, register it again", ins.Name, ins.ID)
+				err = c.register(heartbeatCtx, ins, ttl)
+			}
+			if err != nil && heartbeatCtx.Err() == nil {
+				tracer.Warnf("heartbeat of instance %s/%s failed: %s", ins.Name, ins.ID, err)
+			}
+		}
+	}()
+
+	return func() error {
+		cancel()
+		<-done
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := c.do(ctx, http.MethodPut, "/v1/agent/service/deregister/"+url.PathEscape(ins.ID), nil, nil, nil)
+		return err
+	}, nil
+}
+
+// health queries the passing instances, index is used for the blocking queries
+func (c *consul) health(ctx context.Context, name string, index uint64) ([]Instance, uint64, error) {
+	query := url.Values{"passing": {"1"}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", c.opts.wait.String())
+	}
+	var entries []struct {
+		Service consulService
+	}
+	header, err := c.do(ctx, http.MethodGet, "/v1/health/service/"+url.PathEscape(name), query, nil, &entries)
+	if err != nil {
+		return nil, 0, err
+	}
+	newIndex, _ := strconv.ParseUint(header.Get("X-Consul-Index"), 10, 64)
+	instances := make([]Instance, 0, len(entries))
+	for _, e := range entries {
+		ins := Instance{
+			ID:   e.Service.ID,
+			Name: e.Service.Service,
+			Addr: fmt.Sprintf("%s:%d", e.Service.Address, e.Service.Port),
+			Tags: e.Service.Tags,
+			Meta: e.Service.Meta,
+		}
+		if e.Service.Weights != nil {
+			ins.Weight = e.Service.Weights.Passing
+		}
+		instances = append(instances, ins)
+	}
+	SortInstances(instances)
+	return instances, newIndex, nil
+}
+
+func (c *consul) Resolve(ctx context.Context, name string) ([]Instance, error) {
+	instances, _, err := c.health(ctx, name, 0)
+	return instances, err
+}
+
+func (c *consul) Watch(ctx context.Context, name string) (<-chan []Instance, error) {
+	instances, index, err := c.health(ctx, name, 0)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []Instance, 1)
+	ch <- instances
+	go func() {
+		defer close(ch)
+		tracer := trace.GetTraceFromContext(ctx)
+		last := instances
+		for ctx.Err() == nil {
+			instances, newIndex, err := c.health(ctx, name, index)
+			if err != nil {
+				if ctx.Err() == nil {
+					tracer.Warnf("watch service %s failed: %s", name, err)
+				}
+				return
+			}
+			// the index may go backwards if the consul state is reset
+			if newIndex < index {
+				newIndex = 0
+			}
+			index = newIndex
+			if reflect.DeepEqual(instances, last) {
+				continue
+			}
+			last = instances
+			select {
+			case ch <- instances:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,169 @@
+// Package discovery registers the service instances with a TTL heartbeat, and resolves and watches
+// the instances of other services, with the consul backend in this package and the etcd backend in discovery/etcd.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/trace"
+)
+
+// DefaultTTL is the default TTL of the registrations, the heartbeat is sent every TTL/3
+const DefaultTTL = 10 * time.Second
+
+// Instance is a running instance of a service
+type Instance struct {
+	ID   string            `json:"id"`
+	Name string            `json:"name"`
+	Addr string            `json:"addr"` // host:port
+	Tags []string          `json:"tags,omitempty"`
+	Meta map[string]string `json:"meta,omitempty"`
+	// Weight is used by the weighted load balancers, 0 is treated as 1
+	Weight int `json:"weight,omitempty"`
+}
+
+// Validate checks the required fields of the instance
+func (ins *Instance) Validate() error {
+	if ins.Name == "" || ins.ID == "" {
+		return errors.NewBadRequestError("name and id of the instance are required")
+	}
+	if _, port, err := net.SplitHostPort(ins.Addr); err != nil || port == "" {
+		return errors.NewBadRequestError(fmt.Sprintf("invalid instance addr %q", ins.Addr))
+	}
+	return nil
+}
+
+// HasTag checks if the instance has the tag
+func (ins *Instance) HasTag(tag string) bool {
+	for _, t := range ins.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func splitAddr(addr string) (string, int) {
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+// Deregister removes the registration and stops the heartbeat
+type Deregister func() error
+
+// Registry is the interface of the service registries
+type Registry interface {
+	// Register registers the instance and keeps it alive with the heartbeat until deregistered,
+	// the instance will be re-registered if it's expired due to the network failures
+	Register(ctx context.Context, ins Instance, ops ...RegisterOption) (Deregister, error)
+	// Resolve returns the healthy instances of the service
+	Resolve(ctx context.Context, name string) ([]Instance, error)
+	// Watch sends the full list of the healthy instances whenever it's changed,
+	// the channel is closed when ctx is done or the watch is broken
+	Watch(ctx context.Context, name string) (<-chan []Instance, error)
+}
+
+type registerOptions struct {
+	ttl time.Duration
+}
+
+// RegisterOption for Register
+type RegisterOption func(opts *registerOptions)
+
+// WithTTL set the TTL of the registration
+func WithTTL(ttl time.Duration) RegisterOption {
+	return func(opts *registerOptions) {
+		opts.ttl = ttl
+	}
+}
+
+// RegisterTTL returns the TTL set by the options, which is used by the Registry implementations
+func RegisterTTL(ops []RegisterOption) time.Duration {
+	opts := &registerOptions{ttl: DefaultTTL}
+	for _, op := range ops {
+		op(opts)
+	}
+	if opts.ttl < time.Second {
+		opts.ttl = time.Second
+	}
+	return opts.ttl
+}
+
+// SortInstances sorts the instances by id, so that the lists can be compared
+func SortInstances(instances []Instance) {
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+}
+
+// Resolver keeps the latest instances of a service by watching the registry
+type Resolver struct {
+	name      string
+	mu        sync.RWMutex
+	instances []Instance
+	callbacks []func([]Instance)
+}
+
+// NewResolver resolves the service and watches the changes until ctx is done,
+// the broken watches are re-established after a second
+func NewResolver(ctx context.Context, r Registry, name string) (*Resolver, error) {
+	instances, err := r.Resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	res := &Resolver{name: name}
+	res.update(instances)
+	go res.watch(ctx, r)
+	return res, nil
+}
+
+func (res *Resolver) watch(ctx context.Context, r Registry) {
+	tracer := trace.GetTraceFromContext(ctx)
+	for {
+		ch, err := r.Watch(ctx, res.name)
+		if err != nil {
+			tracer.Warnf("watch service %s failed: %s", res.name, err)
+		} else {
+			for instances := range ch {
+				res.update(instances)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (res *Resolver) update(instances []Instance) {
+	res.mu.Lock()
+	res.instances = instances
+	callbacks := res.callbacks
+	res.mu.Unlock()
+	for _, fn := range callbacks {
+		fn(instances)
+	}
+}
+
+// Instances returns the latest instances, which should not be modified
+func (res *Resolver) Instances() []Instance {
+	res.mu.RLock()
+	defer res.mu.RUnlock()
+	return res.instances
+}
+
+// OnChange adds a callback of the instance changes, fn is called with the current instances immediately
+func (res *Resolver) OnChange(fn func([]Instance)) {
+	res.mu.Lock()
+	res.callbacks = append(res.callbacks, fn)
+	instances := res.instances
+	res.mu.Unlock()
+	fn(instances)
+}
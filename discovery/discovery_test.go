@@ -0,0 +1,137 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul implements the consul agent apis used by the registry
+type fakeConsul struct {
+	sync.Mutex
+	index    uint64
+	changed  chan struct{}
+	services map[string]map[string]interface{}
+	passes   map[string]int
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{changed: make(chan struct{}), services: map[string]map[string]interface{}{}, passes: map[string]int{}}
+}
+
+func (c *fakeConsul) bump() {
+	c.index++
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+	switch {
+	case r.URL.Path == "/v1/agent/service/register":
+		var svc map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&svc)
+		c.services[svc["ID"].(string)] = svc
+		c.bump()
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+		delete(c.services, strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/"))
+		c.bump()
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/check/pass/service:"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/agent/check/pass/service:")
+		if _, ok := c.services[id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		c.passes[id]++
+	case strings.HasPrefix(r.URL.Path, "/v1/health/service/"):
+		if r.URL.Query().Get("index") == fmt.Sprint(c.index) {
+			changed := c.changed
+			c.Unlock()
+			select {
+			case <-changed:
+			case <-time.After(time.Second):
+			}
+			c.Lock()
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/v1/health/service/")
+		var entries []map[string]interface{}
+		for _, svc := range c.services {
+			if svc["Name"] == name {
+				svc["Service"] = name
+				entries = append(entries, map[string]interface{}{"Service": svc})
+			}
+		}
+		w.Header().Set("X-Consul-Index", fmt.Sprint(c.index))
+		json.NewEncoder(w).Encode(entries)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestConsul(t *testing.T) {
+	fake := newFakeConsul()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	r := NewConsul(server.URL)
+	if _, err := r.Register(ctx, Instance{Name: "order", ID: "o1", Addr: "10.0.0.1"}); err == nil {
+		t.Fatal("expect invalid addr")
+	}
+	deregister, err := r.Register(ctx, Instance{Name: "order", ID: "o1", Addr: "10.0.0.1:8080", Tags: []string{"v1"}, Weight: 5}, WithTTL(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver, err := NewResolver(ctx, r, "order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instances := resolver.Instances()
+	if len(instances) != 1 || instances[0].Addr != "10.0.0.1:8080" || !instances[0].HasTag("v1") || instances[0].Weight != 5 {
+		t.Fatalf("unexpected instances: %+v", instances)
+	}
+
+	changes := make(chan []Instance, 10)
+	resolver.OnChange(func(instances []Instance) { changes <- instances })
+	<-changes // the current instances
+
+	deregister2, err := r.Register(ctx, Instance{Name: "order", ID: "o2", Addr: "10.0.0.2:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer deregister2()
+	select {
+	case instances = <-changes:
+		if len(instances) != 2 || instances[1].ID != "o2" {
+			t.Fatalf("unexpected instances: %+v", instances)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expect the new instance to be watched")
+	}
+
+	// the instance is lost by the agent, it should be registered again by the heartbeat
+	fake.Lock()
+	delete(fake.services, "o1")
+	fake.bump()
+	fake.Unlock()
+	time.Sleep(time.Second)
+	if instances, _ := r.Resolve(ctx, "order"); len(instances) != 2 {
+		t.Fatalf("expect o1 registered again: %+v", instances)
+	}
+
+	if err := deregister(); err != nil {
+		t.Fatal(err)
+	}
+	if instances, _ := r.Resolve(ctx, "order"); len(instances) != 1 || instances[0].ID != "o2" {
+		t.Fatalf("unexpected instances: %+v", instances)
+	}
+}
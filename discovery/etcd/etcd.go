@@ -0,0 +1,190 @@
+// Package etcd implements the discovery.Registry with etcd v3,
+// the instances are stored as json under "<prefix>/<name>/<id>" with a lease of the TTL.
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/tools-go/go-utils/discovery"
+	"github.com/tools-go/go-utils/trace"
+)
+
+// DefaultPrefix is the default key prefix of the services
+const DefaultPrefix = "/services"
+
+type options struct {
+	prefix string
+}
+
+// Option for the etcd registry
+type Option func(opts *options)
+
+// WithPrefix set the key prefix of the services
+func WithPrefix(prefix string) Option {
+	return func(opts *options) {
+		opts.prefix = prefix
+	}
+}
+
+type registry struct {
+	cli  *clientv3.Client
+	opts *options
+}
+
+// New creates a discovery.Registry of etcd
+func New(cli *clientv3.Client, ops ...Option) discovery.Registry {
+	opts := &options{prefix: DefaultPrefix}
+	for _, op := range ops {
+		op(opts)
+	}
+	return &registry{cli: cli, opts: opts}
+}
+
+func (r *registry) serviceKey(name string) string {
+	return path.Join(r.opts.prefix, name) + "/"
+}
+
+// register puts the instance with a new lease, and returns the keepalive channel of the lease
+func (r *registry) register(ctx context.Context, keepaliveCtx context.Context, key, value string, ttl time.Duration) (clientv3.LeaseID, <-chan *clientv3.LeaseKeepAliveResponse, error) {
+	lease, err := r.cli.Grant(ctx, int64((ttl+time.Second-1)/time.Second))
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := r.cli.Put(ctx, key, value, clientv3.WithLease(lease.ID)); err != nil {
+		return 0, nil, err
+	}
+	ch, err := r.cli.KeepAlive(keepaliveCtx, lease.ID)
+	if err != nil {
+		return 0, nil, err
+	}
+	return lease.ID, ch, nil
+}
+
+func (r *registry) Register(ctx context.Context, ins discovery.Instance, ops ...discovery.RegisterOption) (discovery.Deregister, error) {
+	if err := ins.Validate(); err != nil {
+		return nil, err
+	}
+	ttl := discovery.RegisterTTL(ops)
+	data, err := json.Marshal(ins)
+	if err != nil {
+		return nil, err
+	}
+	key := r.serviceKey(ins.Name) + ins.ID
+
+	keepaliveCtx, cancel := context.WithCancel(context.Background())
+	lease, ch, err := r.register(ctx, keepaliveCtx, key, string(data), ttl)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	tracer := trace.GetTraceFromContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			// drain the keepalive responses, the channel is closed if the lease is expired or revoked
+			for range ch {
+			}
+			select {
+			case <-keepaliveCtx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			tracer.Warnf("lease of instance %s/%s is lost, register it again", ins.Name, ins.ID)
+			// keep the old lease until the new one is granted, so Deregister still revokes the last one
+			newLease, newCh, err := r.register(keepaliveCtx, keepaliveCtx, key, string(data), ttl)
+			if err != nil {
+				tracer.Warnf("register instance %s/%s failed: %s", ins.Name, ins.ID, err)
+				closed := make(chan *clientv3.LeaseKeepAliveResponse)
+				close(closed)
+				ch = closed
+				continue
+			}
+			lease, ch = newLease, newCh
+		}
+	}()
+
+	return func() error {
+		cancel()
+		<-done
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := r.cli.Revoke(ctx, lease)
+		return err
+	}, nil
+}
+
+func (r *registry) resolve(ctx context.Context, name string) ([]discovery.Instance, int64, error) {
+	resp, err := r.cli.Get(ctx, r.serviceKey(name), clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+	instances := make([]discovery.Instance, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var ins discovery.Instance
+		if err := json.Unmarshal(kv.Value, &ins); err != nil {
+			trace.GetTraceFromContext(ctx).Warnf("invalid instance %s: %s", kv.Key, err)
+			continue
+		}
+		instances = append(instances, ins)
+	}
+	discovery.SortInstances(instances)
+	return instances, resp.Header.Revision, nil
+}
+
+func (r *registry) Resolve(ctx context.Context, name string) ([]discovery.Instance, error) {
+	instances, _, err := r.resolve(ctx, name)
+	return instances, err
+}
+
+func (r *registry) Watch(ctx context.Context, name string) (<-chan []discovery.Instance, error) {
+	instances, rev, err := r.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []discovery.Instance, 1)
+	ch <- instances
+	go func() {
+		defer close(ch)
+		watchCh := r.cli.Watch(ctx, r.serviceKey(name), clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+		for resp := range watchCh {
+			if err := resp.Err(); err != nil {
+				trace.GetTraceFromContext(ctx).Warnf("watch service %s failed: %s", name, err)
+				return
+			}
+			// apply the events on the last list, so no extra Get is needed
+			byID := make(map[string]discovery.Instance, len(instances))
+			for _, ins := range instances {
+				byID[ins.ID] = ins
+			}
+			for _, ev := range resp.Events {
+				id := strings.TrimPrefix(string(ev.Kv.Key), r.serviceKey(name))
+				if ev.Type == clientv3.EventTypeDelete {
+					delete(byID, id)
+					continue
+				}
+				var ins discovery.Instance
+				if err := json.Unmarshal(ev.Kv.Value, &ins); err == nil {
+					byID[id] = ins
+				}
+			}
+			instances = make([]discovery.Instance, 0, len(byID))
+			for _, ins := range byID {
+				instances = append(instances, ins)
+			}
+			discovery.SortInstances(instances)
+			select {
+			case ch <- instances:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,243 @@
+package dlog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tools-go/go-utils/notify"
+	"github.com/tools-go/go-utils/utils/clock"
+)
+
+// AlertRule is a rule of NewAlertBackend, the logs matched by all of Level, Pattern and Tag are counted
+type AlertRule struct {
+	Name    string
+	Level   string         // like "error" or "warning+" of NewLevelBackend, all the logs if empty
+	Pattern *regexp.Regexp // of the logs, without the time, the severity and the caller
+	// Tag matches the logs with the tag of key=[value], of Value unless it's empty
+	Tag   string
+	Value string
+	// the rule fires when Threshold logs are matched in the sliding Window, 1 and 1m by default
+	Threshold int
+	Window    time.Duration
+	// the rule doesn't fire again in Cooldown, 10m by default, the logs matched meanwhile are counted in
+	// the next alert rather than sent
+	Cooldown time.Duration
+	Severity notify.Level // of the alerts, notify.LevelError by default
+}
+
+// AlertConfig is the config of NewAlertBackend
+type AlertConfig struct {
+	Notifier  notify.Notifier
+	Rules     []AlertRule
+	QueueSize int           // the alerts waiting to be sent, 16 by default, the alerts beyond it are dropped
+	Timeout   time.Duration // of sending an alert, 30s by default
+	Clock     clock.Clock   // of the windows, nil for the real one
+}
+
+type alertRule struct {
+	AlertRule
+	match      func(s Severity) bool
+	hits       []time.Time // the times of the last Threshold matched logs, the oldest first
+	next       time.Time   // the end of the cooldown
+	suppressed int         // the logs matched in the cooldown
+}
+
+type alertBackend struct {
+	dropped   uint64 // accessed atomically
+	cfg       AlertConfig
+	host      string
+	mu        sync.Mutex // of the rules
+	rules     []*alertRule
+	queue     chan *notify.Message
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewAlertBackend evaluates the rules on the logs and sends the alerts by the notifier, for the services
+// without the external alerting, like
+//
+//	NewAlertBackend(AlertConfig{Notifier: notify.NewSlack(url), Rules: []AlertRule{
+//		{Name: "db down", Level: "error", Pattern: regexp.MustCompile(`connect .* refused`), Threshold: 10},
+//		{Name: "payment failed", Tag: "event", Value: "pay_failed", Threshold: 5, Window: 5 * time.Minute},
+//	}})
+//
+// It's used as an output along with the files. The alerts are sent by a background goroutine, so the
+// logging never waits for the notifier.
+func NewAlertBackend(cfg AlertConfig) (*alertBackend, error) {
+	if cfg.Notifier == nil {
+		return nil, fmt.Errorf("alert: no notifier")
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 16
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.New()
+	}
+	b := &alertBackend{
+		cfg:   cfg,
+		queue: make(chan *notify.Message, cfg.QueueSize),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	for _, rule := range cfg.Rules {
+		r := &alertRule{AlertRule: rule, match: func(Severity) bool { return true }}
+		if rule.Level != "" {
+			match, err := parseLevel(rule.Level)
+			if err != nil {
+				return nil, fmt.Errorf("alert %q: %s", rule.Name, err)
+			}
+			r.match = match
+		}
+		if r.Threshold <= 0 {
+			r.Threshold = 1
+		}
+		if r.Window <= 0 {
+			r.Window = time.Minute
+		}
+		if r.Cooldown <= 0 {
+			r.Cooldown = 10 * time.Minute
+		}
+		if r.Severity == "" {
+			r.Severity = notify.LevelError
+		}
+		b.rules = append(b.rules, r)
+	}
+	b.host, _ = os.Hostname()
+	go b.run()
+	return b, nil
+}
+
+func (self *alertBackend) Log(s Severity, msg []byte) {
+	var text []byte
+	for _, r := range self.rules {
+		if !r.match(s) {
+			continue
+		}
+		if text == nil {
+			text = bytes.TrimRight(bytes.TrimPrefix(body(msg), []byte(severityName[s]+" ")), "\n")
+			if caller, rest := cutWord(text); bytes.IndexByte(caller, ':') > 0 {
+				text = rest
+			}
+		}
+		if r.Pattern != nil && !r.Pattern.Match(text) {
+			continue
+		}
+		if r.Tag != "" && !hasTag(text, r.Tag, r.Value) {
+			continue
+		}
+		self.hit(r, msg)
+	}
+}
+
+// hasTag tells whether text has the tag key=[value], of any value if value is empty
+func hasTag(text []byte, key, value string) bool {
+	for rest := text; len(rest) > 0; {
+		k, v, after, ok := cutTag(rest)
+		if !ok {
+			_, rest = cutWord(rest)
+			continue
+		}
+		if k == key && (value == "" || v == value) {
+			return true
+		}
+		rest = after
+	}
+	return false
+}
+
+// hit counts the log matched by the rule, and queues the alert if it fires
+func (self *alertBackend) hit(r *alertRule, msg []byte) {
+	now := self.cfg.Clock.Now()
+	self.mu.Lock()
+	if now.Before(r.next) {
+		r.suppressed++
+		self.mu.Unlock()
+		return
+	}
+	if len(r.hits) == r.Threshold {
+		r.hits = r.hits[1:]
+	}
+	r.hits = append(r.hits, now)
+	if len(r.hits) < r.Threshold || now.Sub(r.hits[0]) > r.Window {
+		self.mu.Unlock()
+		return
+	}
+	suppressed := r.suppressed
+	r.hits, r.next, r.suppressed = nil, now.Add(r.Cooldown), 0
+	self.mu.Unlock()
+
+	text := fmt.Sprintf("%d logs matched in %s", r.Threshold, r.Window)
+	if suppressed > 0 {
+		text += fmt.Sprintf(", %d more in the cooldown since the last alert", suppressed)
+	}
+	text += ", the last one:\n" + string(bytes.TrimRight(msg, "\n"))
+	alert := &notify.Message{
+		Level: r.Severity,
+		Title: "[dlog] " + r.Name,
+		Text:  text,
+		Data: map[string]string{
+			"rule":       r.Name,
+			"host":       self.host,
+			"count":      strconv.Itoa(r.Threshold),
+			"suppressed": strconv.Itoa(suppressed),
+			"log":        string(bytes.TrimRight(msg, "\n")),
+		},
+	}
+	select {
+	case self.queue <- alert:
+	default:
+		atomic.AddUint64(&self.dropped, 1)
+	}
+}
+
+func (self *alertBackend) run() {
+	defer close(self.done)
+	for {
+		select {
+		case alert := <-self.queue:
+			self.send(alert)
+		case <-self.quit:
+			for {
+				select {
+				case alert := <-self.queue:
+					self.send(alert)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (self *alertBackend) send(alert *notify.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), self.cfg.Timeout)
+	defer cancel()
+	if err := self.cfg.Notifier.Notify(ctx, alert); err != nil {
+		atomic.AddUint64(&self.dropped, 1)
+		fmt.Fprintf(os.Stderr, "dlog: send the alert %q failed: %s\n", alert.Title, err)
+	}
+}
+
+// Dropped returns the count of the alerts dropped, as the queue is full or the sending failed
+func (self *alertBackend) Dropped() uint64 {
+	return atomic.LoadUint64(&self.dropped)
+}
+
+// close sends the queued alerts and stops
+func (self *alertBackend) close() {
+	self.closeOnce.Do(func() {
+		close(self.quit)
+		<-self.done
+	})
+}
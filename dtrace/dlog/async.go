@@ -0,0 +1,222 @@
+package dlog
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AsyncConfig is the config of NewAsyncBackend, the zero one uses the defaults
+type AsyncConfig struct {
+	QueueSize int           // the logs queued, 8192 by default
+	BatchSize int           // the most logs written at once, 256 by default
+	Linger    time.Duration // wait up to Linger for a batch to fill, 0 writes the logs queued at once
+	// the writing goroutines, 1 by default. With more of them the logs within a batch keep their order,
+	// but the batches may interleave.
+	Workers  int
+	Overflow OverflowPolicy // of the full queue, the dropped logs are counted by Dropped
+}
+
+type asyncEntry struct {
+	s   Severity
+	msg []byte
+}
+
+// batchLogger is implemented by the backends writing a batch of logs cheaper than one by one,
+// like the FileBackend taking its lock once
+type batchLogger interface {
+	logBatch(entries []asyncEntry)
+}
+
+type asyncBackend struct {
+	pending   int64  // the logs queued or being written, accessed atomically
+	lastWrite int64  // in unix nanoseconds, accessed atomically
+	dropped   uint64 // accessed atomically
+	// mu orders the queueing of the logs before Close, which stops the workers only after the logs being
+	// queued are done
+	mu        sync.RWMutex
+	closed    bool
+	backend   Backend
+	cfg       AsyncConfig
+	queue     chan asyncEntry
+	quit      chan struct{}
+	workers   sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewAsyncBackend queues the logs and writes them to backend in batches by the background goroutines,
+// so the logging calls don't wait for the disk. The FATAL logs are written at once after the queued ones.
+// Close or Sync the logger before exiting, or the queued logs are lost.
+// It isn't built on concurrency.Batcher, which has no overflow policies, linger or multiple workers.
+func NewAsyncBackend(backend Backend, cfg AsyncConfig) *asyncBackend {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 8192
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 256
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	b := &asyncBackend{
+		backend:   backend,
+		cfg:       cfg,
+		queue:     make(chan asyncEntry, cfg.QueueSize),
+		quit:      make(chan struct{}),
+		lastWrite: time.Now().UnixNano(),
+	}
+	b.workers.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go b.worker()
+	}
+	return b
+}
+
+func (self *asyncBackend) Log(s Severity, msg []byte) {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	if self.closed {
+		// the backend is closed with the workers
+		os.Stderr.Write(msg)
+		return
+	}
+	if s == FATAL {
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		self.Flush(ctx)
+		cancel()
+		self.backend.Log(s, msg)
+		return
+	}
+	// the logger reuses msg after Log returns
+	entry := asyncEntry{s: s, msg: append([]byte(nil), msg...)}
+	atomic.AddInt64(&self.pending, 1)
+	queued, evicted := enqueue(self.queue, entry, self.cfg.Overflow)
+	if evicted > 0 {
+		atomic.AddInt64(&self.pending, -int64(evicted))
+		atomic.AddUint64(&self.dropped, uint64(evicted))
+	}
+	if queued {
+		return
+	}
+	atomic.AddInt64(&self.pending, -1)
+	atomic.AddUint64(&self.dropped, 1)
+	if self.cfg.Overflow.Mode == OverflowStderr {
+		os.Stderr.Write(msg)
+	}
+}
+
+// Dropped returns the count of the logs dropped as the queue is full, or left in it by Close
+func (self *asyncBackend) Dropped() uint64 {
+	return atomic.LoadUint64(&self.dropped)
+}
+
+// Flush waits until the queued logs are written to the backend and the backend is synced, or ctx is done
+func (self *asyncBackend) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&self.pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("flush async: %d logs not written: %s", atomic.LoadInt64(&self.pending), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	if s, ok := self.backend.(syncer); ok {
+		return s.sync(ctx)
+	}
+	return nil
+}
+
+// Close flushes the queued logs until ctx is done, stops the writing goroutines and closes the backend.
+// The logs still queued when ctx is done, and the logs after Close, go to stderr.
+func (self *asyncBackend) Close(ctx context.Context) error {
+	// wait for the logs being queued, no more are queued after it
+	self.mu.Lock()
+	self.closed = true
+	self.mu.Unlock()
+	err := self.Flush(ctx)
+	self.closeOnce.Do(func() {
+		close(self.quit)
+		self.workers.Wait()
+		// the logs left as ctx is done, no one else receives or sends now
+		for len(self.queue) > 0 {
+			entry := <-self.queue
+			atomic.AddInt64(&self.pending, -1)
+			atomic.AddUint64(&self.dropped, 1)
+			os.Stderr.Write(entry.msg)
+		}
+		self.backend.close()
+	})
+	return err
+}
+
+func (self *asyncBackend) sync(ctx context.Context) error {
+	return self.Flush(ctx)
+}
+
+func (self *asyncBackend) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	self.Close(ctx)
+}
+
+func (self *asyncBackend) worker() {
+	defer self.workers.Done()
+	batch := make([]asyncEntry, 0, self.cfg.BatchSize)
+	for {
+		select {
+		case entry := <-self.queue:
+			batch = append(batch, entry)
+		case <-self.quit:
+			return
+		}
+		batch = self.fill(batch)
+		if b, ok := self.backend.(batchLogger); ok {
+			b.logBatch(batch)
+		} else {
+			for _, entry := range batch {
+				self.backend.Log(entry.s, entry.msg)
+			}
+		}
+		atomic.StoreInt64(&self.lastWrite, time.Now().UnixNano())
+		atomic.AddInt64(&self.pending, -int64(len(batch)))
+		for i := range batch {
+			batch[i].msg = nil
+		}
+		batch = batch[:0]
+	}
+}
+
+// fill takes the queued logs into batch until it's full, waiting up to the linger for more
+func (self *asyncBackend) fill(batch []asyncEntry) []asyncEntry {
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for len(batch) < self.cfg.BatchSize {
+		select {
+		case entry := <-self.queue:
+			batch = append(batch, entry)
+			continue
+		default:
+		}
+		if self.cfg.Linger <= 0 {
+			return batch
+		}
+		if timer == nil {
+			timer = time.NewTimer(self.cfg.Linger)
+		}
+		select {
+		case entry := <-self.queue:
+			batch = append(batch, entry)
+		case <-timer.C:
+			return batch
+		}
+	}
+	return batch
+}
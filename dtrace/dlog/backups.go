@@ -0,0 +1,102 @@
+package dlog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// BackupInfo describes a rotated file
+type BackupInfo struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Severity string    `json:"severity"`
+	Time     time.Time `json:"time"` // when the file started, or was last written if it's rotated by size
+	Size     int64     `json:"size"`
+	// Compressed is the compressor extension like "gz", or empty if not compressed
+	Compressed string `json:"compressed,omitempty"`
+	Encrypted  bool   `json:"encrypted,omitempty"`
+}
+
+// backupInfo parses the name of a rotated file, like INFO.log.2016071114.gz
+func backupInfo(f backupFile) BackupInfo {
+	info := BackupInfo{Name: filepath.Base(f.path), Path: f.path, Time: f.modTime, Size: f.size}
+	if name, ok := parseBackupName(info.Name); ok {
+		info.Severity, info.Compressed, info.Encrypted = name.severity, name.ext, name.encrypted
+		if t, ok := name.time(); ok {
+			info.Time = t
+		}
+	}
+	return info
+}
+
+// Backups returns the rotated files of the backend in both the log dir and the backup dir, the newest first
+func (self *FileBackend) Backups() ([]BackupInfo, error) {
+	files, err := self.listBackups()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]BackupInfo, 0, len(files))
+	for i := len(files) - 1; i >= 0; i-- {
+		infos = append(infos, backupInfo(files[i]))
+	}
+	return infos, nil
+}
+
+// OpenBackup opens the rotated file by the name returned by Backups, for downloading it,
+// the names not of the backups are rejected so it's safe to take them from the requests.
+// The encrypted files are decrypted by the keys of SetEncryption, but still compressed.
+func (self *FileBackend) OpenBackup(name string) (File, error) {
+	files, err := self.listBackups()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if filepath.Base(f.path) != name {
+			continue
+		}
+		file, err := self.fs.Open(f.path)
+		if err != nil {
+			return nil, err
+		}
+		self.mu.Lock()
+		keys := self.keys
+		self.mu.Unlock()
+		if b, _ := parseBackupName(name); !b.encrypted || keys == nil {
+			return file, nil
+		}
+		r, err := keys.NewReader(context.Background(), file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("open %s: %s", name, err)
+		}
+		return &decryptedFile{File: file, r: r}, nil
+	}
+	return nil, fmt.Errorf("backup %s not found", name)
+}
+
+// decryptedFile reads the decrypted content of the file
+type decryptedFile struct {
+	File
+	r io.Reader
+}
+
+func (f *decryptedFile) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+func Backups() ([]BackupInfo, error) {
+	if fileback := getFileBackend(); fileback != nil {
+		return fileback.Backups()
+	}
+	return nil, nil
+}
+
+func OpenBackup(name string) (File, error) {
+	if fileback := getFileBackend(); fileback != nil {
+		return fileback.OpenBackup(name)
+	}
+	return nil, fmt.Errorf("backup %s not found", name)
+}
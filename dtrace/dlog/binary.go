@@ -0,0 +1,363 @@
+package dlog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// BinaryRecord is a log of the fcbin format, see NewBinaryBackend
+type BinaryRecord struct {
+	Time     time.Time // zero if the log has no header
+	Severity Severity
+	Caller   string
+	Tags     [][2]string // in their order in the log
+	Message  string      // the rest of the text
+}
+
+type binaryBackend struct {
+	truncated uint64 // accessed atomically
+	dropped   uint64 // accessed atomically
+	backend   Backend
+}
+
+// NewBinaryBackend writes the logs to backend in the fcbin format, a compact binary encoding for the
+// pipelines of the high volume services: the header and the tags are parsed once on the host, and the
+// agents ship and decode the records without scanning the text. A record is
+//
+//	uvarint length of the rest
+//	byte severity
+//	varint unix nanoseconds, 0 without the header
+//	string caller
+//	uvarint count of the tags, followed by the string key and the string value of each
+//	string message
+//
+// where a string is its uvarint length followed by the bytes. The records are read by BinaryDecoder, and
+// printed as the text or the json lines by logctl decode. The message of a record over 64MB is truncated,
+// and the record is dropped if the rest is still over it, see Truncated and Dropped.
+//
+// A Logger writing to it directly skips the text header: the time and the caller are encoded as they are.
+// The header is still formatted and parsed back when the logs pass the backends reading the text first,
+// like the redaction, the async queue and the sampling of LogConfig, or the outputs added by AddOutput,
+// and for the FATAL logs and the limits of SetMaxBytes.
+func NewBinaryBackend(backend Backend) *binaryBackend {
+	return &binaryBackend{backend: backend}
+}
+
+// Truncated returns the count of the records whose messages are truncated to fit in the max size
+func (self *binaryBackend) Truncated() uint64 {
+	return atomic.LoadUint64(&self.truncated)
+}
+
+// Dropped returns the count of the records dropped as they are over the max size without the messages
+func (self *binaryBackend) Dropped() uint64 {
+	return atomic.LoadUint64(&self.dropped)
+}
+
+// encode appends the record of the log line msg to b, it returns false if the record is dropped
+func (self *binaryBackend) encode(b []byte, s Severity, msg []byte) ([]byte, bool) {
+	return self.counted(appendBinaryRecord(b, s, msg, maxBinaryRecord))
+}
+
+// counted counts the record appended to b if it's truncated or dropped
+func (self *binaryBackend) counted(b []byte, cut int, ok bool) ([]byte, bool) {
+	if !ok {
+		atomic.AddUint64(&self.dropped, 1)
+		return b, false
+	}
+	if cut > 0 {
+		atomic.AddUint64(&self.truncated, 1)
+	}
+	return b, true
+}
+
+var binaryBuffers = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+func (self *binaryBackend) Log(s Severity, msg []byte) {
+	buf := binaryBuffers.Get().(*[]byte)
+	var ok bool
+	if *buf, ok = self.encode((*buf)[:0], s, msg); ok {
+		self.backend.Log(s, *buf)
+	}
+	binaryBuffers.Put(buf)
+}
+
+// logEntry encodes the entry of the logger without the text header, which is neither formatted by the
+// logger nor parsed back here
+func (self *binaryBackend) logEntry(s Severity, t time.Time, file string, line int, msg []byte) {
+	var c [128]byte
+	caller := strconv.AppendInt(append(append(c[:0], file...), ':'), int64(line), 10)
+	buf := binaryBuffers.Get().(*[]byte)
+	var ok bool
+	if *buf, ok = self.counted(appendBinaryEntry((*buf)[:0], s, t.UnixNano(), caller, msg, maxBinaryRecord)); ok {
+		self.backend.Log(s, *buf)
+	}
+	binaryBuffers.Put(buf)
+}
+
+func (self *binaryBackend) logBatch(entries []asyncEntry) {
+	b, ok := self.backend.(batchLogger)
+	if !ok {
+		for _, e := range entries {
+			self.Log(e.s, e.msg)
+		}
+		return
+	}
+	encoded := make([]asyncEntry, 0, len(entries))
+	for _, e := range entries {
+		if record, ok := self.encode(nil, e.s, e.msg); ok {
+			encoded = append(encoded, asyncEntry{e.s, record})
+		}
+	}
+	b.logBatch(encoded)
+}
+
+func (self *binaryBackend) sync(ctx context.Context) error {
+	if s, ok := self.backend.(syncer); ok {
+		return s.sync(ctx)
+	}
+	return nil
+}
+
+func (self *binaryBackend) close() {
+	self.backend.close()
+}
+
+// appendBinaryRecord appends the record of the log line msg of severity s to b, see appendBinaryEntry
+func appendBinaryRecord(b []byte, s Severity, msg []byte, max int) (record []byte, cut int, ok bool) {
+	msg = bytes.TrimRight(msg, "\n")
+	var nanos int64
+	var caller []byte
+	if len(msg) >= dlogHeaderLen && msg[dlogHeaderLen-1] == ' ' {
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05.000000", string(msg[:dlogHeaderLen-1]), time.Local); err == nil {
+			nanos, msg = t.UnixNano(), msg[dlogHeaderLen:]
+			// the severity name and the caller follow the time
+			msg = bytes.TrimPrefix(msg, []byte(severityName[s]+" "))
+			if word, rest := cutWord(msg); bytes.IndexByte(word, ':') > 0 {
+				caller, msg = word, rest
+			}
+		}
+	}
+	return appendBinaryEntry(b, s, nanos, caller, msg, max)
+}
+
+// appendBinaryEntry appends the record of the entry to b, msg is the text after the header. The message is
+// cut to keep the record in max bytes, and cut is the bytes cut of it. It returns false with b unchanged if
+// the record is over max without the message.
+func appendBinaryEntry(b []byte, s Severity, nanos int64, caller, msg []byte, max int) (record []byte, cut int, ok bool) {
+	msg = bytes.TrimRight(msg, "\n")
+	// the tags are encoded in their order and the other words are joined as the message, msg is scanned
+	// for each of them instead of collecting them, so nothing is allocated
+	tags, textLen := 0, 0
+	for rest := msg; len(rest) > 0; {
+		if _, _, after, ok := cutTagBytes(rest); ok {
+			tags, rest = tags+1, after
+			continue
+		}
+		var word []byte
+		word, rest = cutWord(rest)
+		if textLen > 0 {
+			textLen++
+		}
+		textLen += len(word)
+	}
+
+	// 4 bytes are reserved for the length, up to 256MB which is over maxBinaryRecord, the unused ones
+	// are removed after
+	start := len(b)
+	b = append(b, 0, 0, 0, 0, byte(s))
+	b = binary.AppendVarint(b, nanos)
+	b = appendBinaryString(b, caller)
+	b = binary.AppendUvarint(b, uint64(tags))
+	for rest := msg; len(rest) > 0; {
+		key, value, after, ok := cutTagBytes(rest)
+		if !ok {
+			_, rest = cutWord(rest)
+			continue
+		}
+		b = appendBinaryString(b, key)
+		b = appendBinaryString(b, value)
+		rest = after
+	}
+	size := uvarintLen(textLen)
+	room := max - (len(b) - start - 4) - size
+	if room < 0 {
+		return b[:start], 0, false
+	}
+
+	// the message is written after the room of its length, which is filled after it's cut
+	lenAt := len(b)
+	for i := 0; i < size; i++ {
+		b = append(b, 0)
+	}
+	textAt := len(b)
+	for rest := msg; len(rest) > 0 && len(b)-textAt < room; {
+		if _, _, after, ok := cutTagBytes(rest); ok {
+			rest = after
+			continue
+		}
+		var word []byte
+		word, rest = cutWord(rest)
+		if len(b) > textAt {
+			b = append(b, ' ')
+		}
+		b = append(b, word...)
+	}
+	if len(b)-textAt > room {
+		b = b[:textAt+room]
+		// the partial rune at the end is cut too
+		for i := len(b) - 1; i >= textAt && i >= len(b)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(b[i]) {
+				if !utf8.FullRune(b[i:]) {
+					b = b[:i]
+				}
+				break
+			}
+		}
+	}
+	text := len(b) - textAt
+	cut = textLen - text
+	if n := binary.PutUvarint(b[lenAt:], uint64(text)); n < size {
+		b = append(b[:lenAt+n], b[textAt:]...)
+	}
+
+	var n [binary.MaxVarintLen64]byte
+	size = binary.PutUvarint(n[:], uint64(len(b)-start-4))
+	copy(b[start+4-size:], n[:size])
+	return append(b[:start], b[start+4-size:]...), cut, true
+}
+
+func uvarintLen(x int) int {
+	var n [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(n[:], uint64(x))
+}
+
+func appendBinaryString(b, s []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// maxBinaryRecord bounds the records written and read, so a corrupted length doesn't allocate for nothing
+const maxBinaryRecord = 64 << 20
+
+// ErrBinaryCorrupted is returned by BinaryDecoder if a record is malformed
+var ErrBinaryCorrupted = errors.New("corrupted fcbin record")
+
+// BinaryDecoder reads the records of the fcbin format
+type BinaryDecoder struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+// NewBinaryDecoder creates a decoder reading the records from r
+func NewBinaryDecoder(r io.Reader) *BinaryDecoder {
+	return &BinaryDecoder{r: bufio.NewReader(r)}
+}
+
+// Next returns the next record, io.EOF at the end
+func (d *BinaryDecoder) Next() (*BinaryRecord, error) {
+	size, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, ErrBinaryCorrupted
+	}
+	if size == 0 || size > maxBinaryRecord {
+		return nil, ErrBinaryCorrupted
+	}
+	if uint64(cap(d.buf)) < size {
+		d.buf = make([]byte, size)
+	}
+	data := d.buf[:size]
+	if _, err := io.ReadFull(d.r, data); err != nil {
+		return nil, ErrBinaryCorrupted
+	}
+	return decodeBinaryRecord(data)
+}
+
+func decodeBinaryRecord(data []byte) (*BinaryRecord, error) {
+	rec := &BinaryRecord{Severity: Severity(data[0])}
+	if rec.Severity < 0 || int(rec.Severity) >= numSeverity {
+		return nil, ErrBinaryCorrupted
+	}
+	data = data[1:]
+	nanos, n := binary.Varint(data)
+	if n <= 0 {
+		return nil, ErrBinaryCorrupted
+	}
+	if nanos != 0 {
+		rec.Time = time.Unix(0, nanos)
+	}
+	data = data[n:]
+	var ok bool
+	if rec.Caller, data, ok = cutBinaryString(data); !ok {
+		return nil, ErrBinaryCorrupted
+	}
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return nil, ErrBinaryCorrupted
+	}
+	data = data[n:]
+	rec.Tags = make([][2]string, count)
+	for i := range rec.Tags {
+		if rec.Tags[i][0], data, ok = cutBinaryString(data); !ok {
+			return nil, ErrBinaryCorrupted
+		}
+		if rec.Tags[i][1], data, ok = cutBinaryString(data); !ok {
+			return nil, ErrBinaryCorrupted
+		}
+	}
+	if rec.Message, data, ok = cutBinaryString(data); !ok || len(data) > 0 {
+		return nil, ErrBinaryCorrupted
+	}
+	return rec, nil
+}
+
+func cutBinaryString(data []byte) (string, []byte, bool) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || size > uint64(len(data)-n) {
+		return "", nil, false
+	}
+	return string(data[n : n+int(size)]), data[n+int(size):], true
+}
+
+// Text returns the log line of the record, the tags are before the message
+func (rec *BinaryRecord) Text() []byte {
+	var buf bytes.Buffer
+	if !rec.Time.IsZero() {
+		buf.WriteString(rec.Time.Format("2006-01-02 15:04:05.000000 "))
+		buf.WriteString(severityName[rec.Severity])
+		buf.WriteByte(' ')
+		if rec.Caller != "" {
+			buf.WriteString(rec.Caller)
+			buf.WriteByte(' ')
+		}
+	}
+	words := make([]string, 0, len(rec.Tags)+1)
+	for _, tag := range rec.Tags {
+		words = append(words, tag[0]+"=["+tag[1]+"]")
+	}
+	if rec.Message != "" {
+		words = append(words, rec.Message)
+	}
+	buf.WriteString(strings.Join(words, " "))
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// JSON returns the json line of the record, like NewJSONBackend writes the log
+func (rec *BinaryRecord) JSON(cfg JSONConfig) []byte {
+	var buf bytes.Buffer
+	encodeJSON(&buf, cfg.withDefaults(), rec.Severity, rec.Text())
+	return buf.Bytes()
+}
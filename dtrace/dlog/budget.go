@@ -0,0 +1,146 @@
+package dlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BackupBudget caps the total size of the rotated files of all the attached backends,
+// which may be the loggers of several modules sharing a disk. When the cap is exceeded,
+// the oldest file of the backend using the most is removed first, so a noisy module
+// can not evict the backups of the quiet ones.
+type BackupBudget struct {
+	maxBytes int64
+
+	mu           sync.Mutex
+	backends     []*FileBackend
+	kick         chan struct{}
+	errorHandler func(err error)
+}
+
+// NewBackupBudget creates a budget of maxBytes, it's enforced whenever an attached backend rotates
+func NewBackupBudget(maxBytes int64) *BackupBudget {
+	b := &BackupBudget{maxBytes: maxBytes, kick: make(chan struct{}, 1)}
+	go b.daemon()
+	return b
+}
+
+// Attach puts the rotated files of fb under the budget
+func (b *BackupBudget) Attach(fb *FileBackend) {
+	b.mu.Lock()
+	b.backends = append(b.backends, fb)
+	b.mu.Unlock()
+	fb.mu.Lock()
+	fb.budget = b
+	fb.mu.Unlock()
+	b.notify()
+}
+
+// SetErrorHandler sets the handler of the errors of enforcing the budget in the background,
+// which are printed to stderr by default
+func (b *BackupBudget) SetErrorHandler(handler func(err error)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.errorHandler = handler
+}
+
+func (b *BackupBudget) notify() {
+	select {
+	case b.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (b *BackupBudget) daemon() {
+	for range b.kick {
+		if err := b.Enforce(); err != nil {
+			b.mu.Lock()
+			handler := b.errorHandler
+			b.mu.Unlock()
+			if handler == nil {
+				fmt.Fprintf(os.Stderr, "dlog: enforce backup budget failed: %s\n", err)
+				continue
+			}
+			handler(err)
+		}
+	}
+}
+
+type backupFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// listBackups returns the rotated files in dir, the oldest first
+func listBackups(fs FS, dir string) ([]backupFile, error) {
+	infos, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []backupFile{}
+	for _, info := range infos {
+		if info.Mode().IsRegular() && backupReg.MatchString(info.Name()) {
+			files = append(files, backupFile{filepath.Join(dir, info.Name()), info.Size(), info.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	return files, nil
+}
+
+// Enforce removes the rotated files until the total size fits the budget
+func (b *BackupBudget) Enforce() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the backends sharing a directory share the files too
+	owned := map[string][]backupFile{}
+	owners := map[string]*FileBackend{}
+	var total int64
+	for _, fb := range b.backends {
+		if _, ok := owned[fb.dir]; ok {
+			continue
+		}
+		files, err := fb.listBackups()
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			total += f.size
+		}
+		owned[fb.dir] = files
+		owners[fb.dir] = fb
+	}
+
+	usage := func(files []backupFile) (n int64) {
+		for _, f := range files {
+			n += f.size
+		}
+		return n
+	}
+	for total > b.maxBytes {
+		var victim string
+		var most int64 = -1
+		for dir, files := range owned {
+			if n := usage(files); len(files) > 0 && n > most {
+				victim, most = dir, n
+			}
+		}
+		if victim == "" {
+			return nil
+		}
+		file := owned[victim][0]
+		if err := owners[victim].fs.Remove(file.path); err == nil {
+			owners[victim].countDelete(file.path)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		owned[victim] = owned[victim][1:]
+		total -= file.size
+	}
+	return nil
+}
@@ -0,0 +1,362 @@
+// logctl operates the log dirs written by the dlog file backends:
+//
+//	logctl tail [-severity INFO] [-n 10] DIR          follow the current file across the rotations
+//	logctl rotate DIR                                 rotate the live files, the writer reopens them in seconds
+//	logctl retention [-keep-hours 168] [-max-bytes N] [-backup-dir BDIR] DIR
+//	                                                  print the backups the retention would remove
+//	logctl grep [-backup-dir BDIR] DIR TRACE_ID       print the lines of a trace, compressed backups included
+//	logctl stats [-backup-dir BDIR] DIR               print the sizes and counts of the files by severity
+//	logctl decode [-format text] FILE...              print the records of the fcbin files as the text or the json lines
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"git.xiaojukeji.com/devops/MachineAlliance/self/commons/trace/dlog"
+)
+
+var commands = map[string]func(args []string) error{
+	"tail":      tail,
+	"rotate":    rotate,
+	"retention": retention,
+	"grep":      grep,
+	"stats":     stats,
+	"decode":    decode,
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: logctl tail|rotate|retention|grep|stats|decode [flags] DIR [args]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "logctl %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// parse parses the flags of a command, and returns the log dir and the other args
+func parse(fs *flag.FlagSet, args []string, nargs int) (string, []string) {
+	fs.Parse(args)
+	if fs.NArg() != nargs+1 {
+		fmt.Fprintf(os.Stderr, "logctl %s: expect %d args after the flags\n", fs.Name(), nargs+1)
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Arg(0), fs.Args()[1:]
+}
+
+func dirsOf(dir, backupDir string) []string {
+	if backupDir == "" || backupDir == dir {
+		return []string{dir}
+	}
+	return []string{dir, backupDir}
+}
+
+func tail(args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	severity := fs.String("severity", "INFO", "the severity of the file")
+	n := fs.Int("n", 10, "the count of the last lines to print first")
+	interval := fs.Duration("interval", 500*time.Millisecond, "the interval to poll the file")
+	dir, _ := parse(fs, args, 0)
+	path := filepath.Join(dir, strings.ToUpper(*severity)+".log")
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := printLastLines(f, *n); err != nil {
+		return err
+	}
+	for {
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			return err
+		}
+		time.Sleep(*interval)
+		// the file is rotated if the path is another file now, or it's truncated
+		cur, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		opened, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		offset, _ := f.Seek(0, io.SeekCurrent)
+		if os.SameFile(cur, opened) && cur.Size() >= offset {
+			continue
+		}
+		// drain the rotated file before switching to the new one, which may be created a bit later
+		io.Copy(os.Stdout, f)
+		next, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		f.Close()
+		f = next
+	}
+}
+
+// printLastLines prints the last n lines of f, and leaves f at the end
+func printLastLines(f *os.File, n int) error {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	const chunk = 64 * 1024
+	var tail []byte
+	offset := size
+	for offset > 0 && bytes.Count(tail, []byte("\n")) <= n {
+		read := int64(chunk)
+		if offset < read {
+			read = offset
+		}
+		offset -= read
+		buf := make([]byte, read)
+		if _, err := f.ReadAt(buf, offset); err != nil {
+			return err
+		}
+		tail = append(buf, tail...)
+	}
+	lines := bytes.SplitAfter(tail, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	for _, line := range lines {
+		os.Stdout.Write(line)
+	}
+	return nil
+}
+
+func rotate(args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	dir, _ := parse(fs, args, 0)
+	rotated, err := dlog.RotateDir(dlog.OSFS, dir, time.Now())
+	for _, name := range rotated {
+		fmt.Println(name)
+	}
+	return err
+}
+
+func retention(args []string) error {
+	fs := flag.NewFlagSet("retention", flag.ExitOnError)
+	keepHours := fs.Uint("keep-hours", 24*7, "the hours to keep the files rotated by the hour or the schedule, 0 to skip")
+	maxBytes := fs.Int64("max-bytes", 0, "the cap of the total size of the backups, 0 to skip")
+	backupDir := fs.String("backup-dir", "", "the dir the backups are moved to")
+	dir, _ := parse(fs, args, 0)
+
+	backups, err := dlog.ListBackups(dlog.OSFS, dirsOf(dir, *backupDir)...)
+	if err != nil {
+		return err
+	}
+	removed := map[string]string{}
+	if *keepHours > 0 {
+		for _, b := range dlog.ExpiredBackups(backups, *keepHours, time.Now()) {
+			removed[b.Path] = "expired"
+		}
+	}
+	if *maxBytes > 0 {
+		var total int64
+		for _, b := range backups {
+			if removed[b.Path] == "" {
+				total += b.Size
+			}
+		}
+		// the oldest are removed first, like the BackupBudget of a single backend
+		for i := len(backups) - 1; i >= 0 && total > *maxBytes; i-- {
+			if b := backups[i]; removed[b.Path] == "" {
+				removed[b.Path] = "over budget"
+				total -= b.Size
+			}
+		}
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	var freed int64
+	for i := len(backups) - 1; i >= 0; i-- {
+		if b := backups[i]; removed[b.Path] != "" {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", b.Path, b.Size, b.Time.Format(time.RFC3339), removed[b.Path])
+			freed += b.Size
+		}
+	}
+	w.Flush()
+	fmt.Printf("dry run: %d of %d backups, %d bytes would be removed\n", len(removed), len(backups), freed)
+	return nil
+}
+
+func grep(args []string) error {
+	fs := flag.NewFlagSet("grep", flag.ExitOnError)
+	backupDir := fs.String("backup-dir", "", "the dir the backups are moved to")
+	dir, rest := parse(fs, args, 1)
+	pattern := []byte("tid=[" + rest[0] + "]")
+
+	backups, err := dlog.ListBackups(dlog.OSFS, dirsOf(dir, *backupDir)...)
+	if err != nil {
+		return err
+	}
+	// the oldest first, then the live files, so the lines are printed in order
+	var paths []string
+	for i := len(backups) - 1; i >= 0; i-- {
+		paths = append(paths, backups[i].Path)
+	}
+	lives, err := dlog.LiveFiles(dlog.OSFS, dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range lives {
+		paths = append(paths, filepath.Join(dir, info.Name()))
+	}
+	for _, path := range paths {
+		if err := grepFile(path, pattern); err != nil {
+			fmt.Fprintf(os.Stderr, "logctl grep: %s\n", err)
+		}
+	}
+	return nil
+}
+
+func grepFile(path string, pattern []byte) error {
+	r, err := dlog.OpenDecompressed(dlog.OSFS, path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	name := filepath.Base(path)
+	for scanner.Scan() {
+		if bytes.Contains(scanner.Bytes(), pattern) {
+			fmt.Printf("%s: %s\n", name, scanner.Bytes())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read %s: %s", path, err)
+	}
+	return nil
+}
+
+func stats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	backupDir := fs.String("backup-dir", "", "the dir the backups are moved to")
+	dir, _ := parse(fs, args, 0)
+
+	type severityStats struct {
+		live, backups, compressed, bytes int64
+		oldest, newest                   time.Time
+	}
+	bySeverity := map[string]*severityStats{}
+	get := func(severity string) *severityStats {
+		if bySeverity[severity] == nil {
+			bySeverity[severity] = &severityStats{}
+		}
+		return bySeverity[severity]
+	}
+	lives, err := dlog.LiveFiles(dlog.OSFS, dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range lives {
+		get(strings.TrimSuffix(info.Name(), ".log")).live = info.Size()
+	}
+	backups, err := dlog.ListBackups(dlog.OSFS, dirsOf(dir, *backupDir)...)
+	if err != nil {
+		return err
+	}
+	for _, b := range backups {
+		s := get(b.Severity)
+		s.backups++
+		s.bytes += b.Size
+		if b.Compressed != "" {
+			s.compressed++
+		}
+		if s.oldest.IsZero() || b.Time.Before(s.oldest) {
+			s.oldest = b.Time
+		}
+		if b.Time.After(s.newest) {
+			s.newest = b.Time
+		}
+	}
+	var severities []string
+	for severity := range bySeverity {
+		severities = append(severities, severity)
+	}
+	sort.Strings(severities)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SEVERITY\tLIVE BYTES\tBACKUPS\tCOMPRESSED\tBACKUP BYTES\tOLDEST\tNEWEST")
+	for _, severity := range severities {
+		s := bySeverity[severity]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\n", severity, s.live, s.backups, s.compressed, s.bytes,
+			formatTime(s.oldest), formatTime(s.newest))
+	}
+	return w.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func decode(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	format := fs.String("format", "text", "text or json")
+	fs.Parse(args)
+	if fs.NArg() == 0 || (*format != "text" && *format != "json") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, path := range fs.Args() {
+		if err := decodeFile(w, path, *format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeFile prints the records of the fcbin file, compressed or not
+func decodeFile(w io.Writer, path, format string) error {
+	r, err := dlog.OpenDecompressed(dlog.OSFS, path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	d := dlog.NewBinaryDecoder(r)
+	for {
+		rec, err := d.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read %s: %s", path, err)
+		}
+		if format == "json" {
+			w.Write(rec.JSON(dlog.JSONConfig{}))
+		} else {
+			w.Write(rec.Text())
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package dlog
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressor compresses the rotated log files
+type Compressor interface {
+	// Ext is appended to the names of the compressed files, like ".gz"
+	Ext() string
+	// NewWriter returns a writer compressing into w, which is flushed by Close
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Ext() string {
+	return ".gz"
+}
+
+func (gzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) Ext() string {
+	return ".zst"
+}
+
+func (zstdCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	// the default level is about gzip -6 in ratio, and several times faster
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+}
+
+var (
+	// Gzip compresses the files with gzip
+	Gzip Compressor = gzipCompressor{}
+	// Zstd compresses the files with zstd, it's much faster than gzip for the large files
+	Zstd Compressor = zstdCompressor{}
+)
+
+// CompressorOf returns the compressor by name: gzip, zstd, or none and "" for no compression
+func CompressorOf(name string) (Compressor, error) {
+	switch name {
+	case "", "none":
+		return nil, nil
+	case "gzip", "gz":
+		return Gzip, nil
+	case "zstd", "zst":
+		return Zstd, nil
+	}
+	return nil, fmt.Errorf("unknown log compressor: %s", name)
+}
+
+// errArchiving is returned if the file is being archived by another writer, like another process
+// sharing the log dir
+var errArchiving = errors.New("being archived by another writer")
+
+// staleTempAge is the age of the temp files considered left by a crash, the live ones are written constantly
+const staleTempAge = time.Minute
+
+// openTemp creates the temp file of dst exclusively, it's renamed to dst once it's complete,
+// so a crash never leaves a truncated dst
+func openTemp(fs FS, dst string) (File, error) {
+	out, err := fs.OpenFile(dst+".tmp", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil && os.IsExist(err) {
+		return nil, errArchiving
+	}
+	return out, err
+}
+
+// compressFile compresses src by c and then encrypts it by keys into dst paced by t, either of them
+// may be nil, and removes src after it's done
+func compressFile(fs FS, c Compressor, keys *EncryptionKeys, src, dst string, t *MillThrottle) error {
+	in, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := openTemp(fs, dst)
+	if err != nil {
+		return err
+	}
+	w, err := newArchiveWriter(out, c, keys)
+	if err == nil {
+		if _, err = io.Copy(w, t.reader(in)); err == nil {
+			err = w.Close()
+		}
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fs.Remove(dst + ".tmp")
+		return err
+	}
+	if err := fs.Rename(dst+".tmp", dst); err != nil {
+		return err
+	}
+	return fs.Remove(src)
+}
+
+// newArchiveWriter returns a writer compressing by c and then encrypting by keys into w,
+// either of them may be nil, Close flushes both but doesn't close w
+func newArchiveWriter(w io.Writer, c Compressor, keys *EncryptionKeys) (io.WriteCloser, error) {
+	closers := []io.Closer{}
+	if keys != nil {
+		enc, err := keys.NewWriter(context.Background(), w)
+		if err != nil {
+			return nil, err
+		}
+		w = enc
+		closers = append(closers, enc)
+	}
+	if c != nil {
+		cw, err := c.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		w = cw
+		closers = append(closers, cw)
+	}
+	return &archiveWriter{w, closers}, nil
+}
+
+type archiveWriter struct {
+	io.Writer
+	closers []io.Closer // the inner one first
+}
+
+func (a *archiveWriter) Close() error {
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		if err := a.closers[i].Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveFile renames src to dst, or copies it paced by t if they are on different volumes
+func moveFile(fs FS, src, dst string, t *MillThrottle) error {
+	if err := fs.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := openTemp(fs, dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, t.reader(in))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fs.Remove(dst + ".tmp")
+		return err
+	}
+	if err := fs.Rename(dst+".tmp", dst); err != nil {
+		return err
+	}
+	return fs.Remove(src)
+}
+
+// rotatedFile is a rotated file with the settings when it's rotated
+type rotatedFile struct {
+	path       string
+	compressor Compressor
+	keys       *EncryptionKeys
+	backupDir  string
+}
+
+// archivedFrom tells if the archive dst is complete for src. The archive is written after the last write
+// of its source, while a name reused by the size rotation, like INFO.log.000, is written after the archive
+// of the previous cycle, which is replaced then.
+func archivedFrom(src, dst os.FileInfo) bool {
+	return dst.ModTime().After(src.ModTime())
+}
+
+// millDaemon compresses the rotated files and moves them to the backup dir one by one, off the logging path,
+// and refreshes the latest links after they are settled
+func (self *FileBackend) millDaemon() {
+	for {
+		var file rotatedFile
+		select {
+		case file = <-self.rotated:
+		case <-self.quit:
+			return
+		}
+		self.mu.Lock()
+		budget, symlinkLatest, throttle := self.budget, self.symlinkLatest, self.throttle
+		self.mu.Unlock()
+		dst := file.path
+		if file.backupDir != "" {
+			dst = filepath.Join(file.backupDir, filepath.Base(file.path))
+		}
+		if file.compressor != nil {
+			dst += file.compressor.Ext()
+		}
+		if file.keys != nil {
+			dst += encExt
+		}
+		src, err := self.fs.Stat(file.path)
+		if err != nil {
+			// archived already, e.g. queued again by the recovery
+			continue
+		}
+		if archived, err := self.fs.Stat(dst); err == nil && dst != file.path && archivedFrom(src, archived) {
+			// the process died after dst was complete but before src was removed
+			if err := self.fs.Remove(file.path); err != nil && !os.IsNotExist(err) {
+				self.reportError(fmt.Errorf("remove archived %s failed: %s", file.path, err))
+			}
+			continue
+		}
+		throttle.acquire()
+		self.millMu.Lock()
+		start := time.Now()
+		switch {
+		case file.compressor != nil || file.keys != nil:
+			err = compressFile(self.fs, file.compressor, file.keys, file.path, dst, throttle)
+		case dst != file.path:
+			err = moveFile(self.fs, file.path, dst, throttle)
+		}
+		self.millMu.Unlock()
+		throttle.release()
+		if dst != file.path && err != errArchiving {
+			self.countArchive(dst, time.Since(start), err)
+		}
+		if err != nil && err != errArchiving {
+			self.reportError(fmt.Errorf("archive %s failed: %s", file.path, err))
+		}
+		if budget != nil {
+			budget.notify()
+		}
+		if symlinkLatest > 0 {
+			self.linkLatest(symlinkLatest)
+		}
+	}
+}
+
+// recoverArchives cleans the temp files left by a crash in the middle of archiving, and queues
+// the rotated files left in the log dir to archive them with the current settings
+func (self *FileBackend) recoverArchives() {
+	self.mu.Lock()
+	compressor, keys, backupDir, now := self.compressor, self.keys, self.backupDir, self.clock.Now()
+	self.mu.Unlock()
+	for _, dir := range self.backupDirs() {
+		infos, err := self.fs.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, info := range infos {
+			name := strings.TrimSuffix(info.Name(), ".tmp")
+			if name == info.Name() || !backupReg.MatchString(name) || now.Sub(info.ModTime()) < staleTempAge {
+				continue
+			}
+			if err := self.fs.Remove(filepath.Join(dir, info.Name())); err != nil && !os.IsNotExist(err) {
+				self.reportError(fmt.Errorf("remove stale %s failed: %s", info.Name(), err))
+			}
+		}
+	}
+	if compressor == nil && keys == nil && backupDir == "" {
+		return
+	}
+	files, err := listBackups(self.fs, self.dir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		name, ok := parseBackupName(filepath.Base(f.path))
+		// the compressed files are only encrypted or moved to the backup dir, and the encrypted ones only moved
+		settled := name.encrypted || (name.ext != "" && keys == nil)
+		if !ok || (settled && backupDir == "") {
+			continue
+		}
+		c, k := compressor, keys
+		if name.ext != "" || name.encrypted {
+			c = nil
+		}
+		if name.encrypted {
+			k = nil
+		}
+		select {
+		case self.rotated <- rotatedFile{f.path, c, k, backupDir}:
+		default:
+			return
+		}
+	}
+}
+
+// SetCompressor compresses the rotated files by c in the background, nil disables the compression.
+// The rotated files left uncompressed by the previous runs are compressed too.
+func (self *FileBackend) SetCompressor(c Compressor) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.compressor = c
+	self.daemon(self.recoverArchives)
+}
+
+func SetCompressor(c Compressor) {
+	if fileback := getFileBackend(); fileback != nil {
+		fileback.SetCompressor(c)
+	}
+}
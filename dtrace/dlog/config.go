@@ -6,58 +6,193 @@ import (
 )
 
 type LogConfig struct {
-	Type              string // syslog/stderr/std/file
-	Level             string // DEBUG/INFO/WARNING/ERROR/FATAL
-	SyslogPriority    string // local0-7
+	Type              string   // syslog/stderr/std/file/outputs/auto, auto picks file or stdout by the container, see DetectContainer
+	Outputs           []string // of the outputs type, like "error:./log/errors" and "info+:stdout", see NewOutputsBackend
+	Level             string   // DEBUG/INFO/WARNING/ERROR/FATAL
+	Format            string   // text/json/fcbin of the file and the outputs types, json for the structured ingestion, see NewJSONBackend and NewBinaryBackend
+	SyslogPriority    string   // local0-7
 	SyslogSeverity    string
+	SyslogOverflow    string // stderr/drop-newest/drop-oldest/block=50ms, when the queue is full, see ParseOverflowPolicy
 	FileName          string
 	FileRotateCount   int
 	FileRotateSize    uint64
 	FileFlushDuration time.Duration
+	FileBufferSize    int // bytes buffered per file, 256KB if 0
 	RotateByHour      bool
-	KeepHours         uint // make sense when RotateByHour is T
+	RotateSchedule    string  // hourly/daily/@every 30m/cron spec like "0 */6 * * *", overrides RotateByHour
+	KeepHours         uint    // make sense when RotateByHour is T or RotateSchedule is set
+	FileCompress      string  // gzip/zstd/none, compress the rotated files in the background
+	MinFreeMB         uint64  // remove the oldest rotated files when the free space of the disk is below it
+	MinFreePercent    float64 // like MinFreeMB, but in the percentage of the disk size
+	BackupDir         string  // move the rotated files to it, the log dir only holds the live files
+	SymlinkLatest     int     // keep the links like INFO.log.latest.1 to the latest rotated files
+	FileSync          string  // never/rotate/bytes=N/interval=1s, comma separated, see ParseSyncPolicy
+	// the limits of archiving the rotated files, shared by the file backends of the same limits, see NewMillThrottle
+	CompressMaxConcurrent int
+	CompressBytesPerSec   int64
+	CompressIdle          float64
+	// queue the logs of the file backend and write them in batches in the background, see NewAsyncBackend
+	Async          bool
+	AsyncQueueSize int
+	AsyncBatchSize int
+	AsyncLinger    time.Duration
+	AsyncOverflow  string // like SyslogOverflow
+	// the keys and the layout of the json format
+	JSON JSONConfig
+	// mask the values of the sensitive keys in the logs of the file and the outputs types, the keys are
+	// the patterns like "*token*", DefaultRedactKeys if empty, see NewRedactBackend
+	Redact     bool
+	RedactKeys []string
+	// cap the values of the tags and the whole logs at the bytes, 0 for no limit, see SetMaxBytes
+	MaxFieldBytes int
+	MaxEntryBytes int
+	// drop the logs of the high volume values of a tag by the rates, the errors are always kept, see NewSampleBackend
+	Sampling SampleConfig
 }
 
-func initFromConfig(log *Logger,
-	sb *syslogBackend,
-	fb *FileBackend,
-	config LogConfig) error {
-
+// initFromConfig sets up log by config, and returns the backend created
+func initFromConfig(log *Logger, config LogConfig) (fb *FileBackend, sb *syslogBackend, err error) {
+	config = autoConfig(config, DetectContainer())
+	log.SetMaxBytes(config.MaxFieldBytes, config.MaxEntryBytes)
 	if config.Type == "stderr" || config.Type == "std" {
-		log.LogToStderr()
-		log.SetSeverity(config.Level)
-		return nil
+		log.update(func(cfg *loggerConfig) {
+			cfg.logToStderr = true
+			cfg.setSeverity(config.Level)
+		})
+		return nil, nil, nil
 	}
 
-	var err error
 	if config.Type == "syslog" {
+		policy, err := ParseOverflowPolicy(config.SyslogOverflow)
+		if err != nil {
+			return nil, nil, err
+		}
 		if sb, err = NewSyslogBackend(config.SyslogPriority, config.SyslogSeverity); err != nil {
-			return err
+			return nil, nil, err
 		}
+		sb.SetOverflowPolicy(policy)
 		log.SetLogging(config.Level, sb)
 	} else if config.Type == "file" {
-		if fb, err = NewFileBackend(config.FileName); err != nil {
-			return err
+		if fb, err = newFileBackendFromConfig(config); err != nil {
+			return nil, nil, err
+		}
+		if err = setFileLogging(log, config, fb); err != nil {
+			return nil, nil, err
+		}
+	} else if config.Type == "outputs" {
+		// the file outputs share the settings of the file type
+		backend, err := newOutputsBackend(config.Outputs, func(dir string) (*FileBackend, error) {
+			fileConfig := config
+			fileConfig.FileName = dir
+			return newFileBackendFromConfig(fileConfig)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if fbs := fileBackends(backend); len(fbs) > 0 {
+			fb = fbs[0]
+		}
+		if err = setFileLogging(log, config, backend); err != nil {
+			return nil, nil, err
 		}
-		fb.Rotate(config.FileRotateCount, config.FileRotateSize)
-		fb.SetFlushDuration(config.FileFlushDuration)
-		fb.SetRotateByHour(config.RotateByHour)
-		fb.SetKeepHours(config.KeepHours)
-		log.SetLogging(config.Level, fb)
 	} else {
-		return fmt.Errorf("unknown log type: %s", config.Type)
+		return nil, nil, fmt.Errorf("unknown log type: %s", config.Type)
+	}
+	return fb, sb, nil
+}
+
+// setFileLogging sets the backend of the file or the outputs type redacted and in the format, queued by the
+// async backend if it's set, and sampled by the rates if any
+func setFileLogging(log *Logger, config LogConfig, backend Backend) error {
+	switch config.Format {
+	case "", "text":
+	case "json":
+		backend = NewJSONBackend(backend, config.JSON)
+	case "fcbin":
+		backend = NewBinaryBackend(backend)
+	default:
+		return fmt.Errorf("unknown log format: %s", config.Format)
+	}
+	// redacted before the format
+	if config.Redact {
+		backend = NewRedactBackend(backend, RedactConfig{Keys: config.RedactKeys})
+	}
+	if config.Async {
+		overflow, err := ParseOverflowPolicy(config.AsyncOverflow)
+		if err != nil {
+			return err
+		}
+		backend = NewAsyncBackend(backend, AsyncConfig{
+			QueueSize: config.AsyncQueueSize,
+			BatchSize: config.AsyncBatchSize,
+			Linger:    config.AsyncLinger,
+			Overflow:  overflow,
+		})
+	}
+	// sampled before queued
+	if len(config.Sampling.Rates) > 0 {
+		backend = NewSampleBackend(backend, config.Sampling)
 	}
+	log.SetLogging(config.Level, backend)
 	return nil
 }
 
+func newFileBackendFromConfig(config LogConfig) (*FileBackend, error) {
+	fb, err := NewFileBackend(config.FileName)
+	if err != nil {
+		return nil, err
+	}
+	fb.Rotate(config.FileRotateCount, config.FileRotateSize)
+	fb.SetFlushDuration(config.FileFlushDuration)
+	fb.SetBufferSize(config.FileBufferSize)
+	fb.SetRotateByHour(config.RotateByHour)
+	if err = fb.SetRotateSchedule(config.RotateSchedule); err != nil {
+		return nil, err
+	}
+	fb.SetKeepHours(config.KeepHours)
+	compressor, err := CompressorOf(config.FileCompress)
+	if err != nil {
+		return nil, err
+	}
+	fb.SetCompressor(compressor)
+	fb.SetMinFreeSpace(config.MinFreeMB<<20, config.MinFreePercent)
+	if err = fb.SetBackupDir(config.BackupDir); err != nil {
+		return nil, err
+	}
+	fb.SetSymlinkLatest(config.SymlinkLatest)
+	policy, err := ParseSyncPolicy(config.FileSync)
+	if err != nil {
+		return nil, err
+	}
+	fb.SetSyncPolicy(policy)
+	fb.SetMillThrottle(sharedMillThrottle(config.CompressMaxConcurrent, config.CompressBytesPerSec, config.CompressIdle))
+	return fb, nil
+}
+
+// Init sets up the package level logger by config, the package level setters like SetRotateByHour
+// apply to the file backend it creates
 func Init(config LogConfig) error {
-	return initFromConfig(&logging, sysback, fileback, config)
+	fb, sb, err := initFromConfig(&logging, config)
+	if err != nil {
+		return err
+	}
+	backends.Lock()
+	defer backends.Unlock()
+	backends.file, backends.sys = fb, sb
+	return nil
+}
+
+// NewLoggerWithConfig creates a logger by config, independent of the package level one
+func NewLoggerWithConfig(config LogConfig) (*Logger, error) {
+	log := new(Logger)
+	if _, _, err := initFromConfig(log, config); err != nil {
+		return nil, err
+	}
+	return log, nil
 }
 
 func NewLoggerFromConfig(config LogConfig) (Logger, error) {
 	var log Logger
-	var fb *FileBackend = nil
-	var sb *syslogBackend = nil
-	err := initFromConfig(&log, sb, fb, config)
+	_, _, err := initFromConfig(&log, config)
 	return log, err
 }
@@ -0,0 +1,160 @@
+package dlog
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Container is the container the process runs in, detected by DetectContainer
+type Container struct {
+	Runtime     string // docker, containerd, cri-o, podman or kubernetes, empty if not in a container
+	ID          string // the container id in the cgroups, may be empty, like with the private cgroup namespace
+	MemoryLimit int64  // of the cgroup in bytes, 0 if unlimited or unknown
+}
+
+// InContainer tells whether the process runs in a container
+func (c Container) InContainer() bool {
+	return c.Runtime != ""
+}
+
+// ShortID returns the 12 characters of the id, like docker ps
+func (c Container) ShortID() string {
+	if len(c.ID) > 12 {
+		return c.ID[:12]
+	}
+	return c.ID
+}
+
+var detected struct {
+	sync.Once
+	container Container
+}
+
+// DetectContainer detects the container runtime and the cgroup limits of the process, once
+func DetectContainer() Container {
+	detected.Do(func() {
+		detected.container = detectContainer("/")
+	})
+	return detected.container
+}
+
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// cgroupRuntimes are the runtimes told by the cgroup paths, like /kubepods/burstable/pod.../cri-containerd-<id>.scope
+var cgroupRuntimes = []struct{ mark, runtime string }{
+	{"docker", "docker"},
+	{"crio", "cri-o"},
+	{"containerd", "containerd"},
+	{"libpod", "podman"},
+	{"kubepods", "kubernetes"},
+}
+
+// detectContainer detects the container under root, the / of the tests
+func detectContainer(root string) Container {
+	var c Container
+	for _, name := range []string{"proc/self/cgroup", "proc/self/mountinfo"} {
+		data, _ := ioutil.ReadFile(filepath.Join(root, name))
+		scanner := bufio.NewScanner(strings.NewReader(string(data)))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if c.ID == "" && (name == "proc/self/cgroup" || strings.Contains(line, "containers/")) {
+				c.ID = containerIDPattern.FindString(line)
+			}
+			if name != "proc/self/cgroup" || c.Runtime != "" {
+				continue
+			}
+			for _, r := range cgroupRuntimes {
+				if strings.Contains(line, r.mark) {
+					c.Runtime = r.runtime
+					break
+				}
+			}
+		}
+	}
+	if c.Runtime == "" {
+		switch {
+		case exists(filepath.Join(root, ".dockerenv")):
+			c.Runtime = "docker"
+		case exists(filepath.Join(root, "run/.containerenv")):
+			c.Runtime = "podman"
+		case os.Getenv("KUBERNETES_SERVICE_HOST") != "":
+			c.Runtime = "kubernetes"
+		}
+	}
+	c.MemoryLimit = memoryLimit(root)
+	return c
+}
+
+// memoryLimit reads the memory limit of cgroup v2, or of v1
+func memoryLimit(root string) int64 {
+	for _, name := range []string{"sys/fs/cgroup/memory.max", "sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+		data, err := ioutil.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		// max of v2, and the page aligned max int64 of v1 are unlimited
+		if err != nil || limit >= 1<<62 {
+			return 0
+		}
+		return limit
+	}
+	return 0
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// queueSizeOf returns the size of the async queues for the memory limit, the queued logs take up to
+// about 1/64 of it at 512 bytes a log, between 1024 and 65536, or 0 for the default if it's unlimited
+func queueSizeOf(memoryLimit int64) int {
+	if memoryLimit <= 0 {
+		return 0
+	}
+	n := memoryLimit / 64 / 512
+	if n < 1024 {
+		n = 1024
+	} else if n > 65536 {
+		n = 65536
+	}
+	return int(n)
+}
+
+// dirWritable tells whether the logs can be written to dir, it's created if absent
+func dirWritable(dir string) bool {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false
+	}
+	f, err := ioutil.TempFile(dir, ".dlog-probe")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return true
+}
+
+// autoConfig resolves the auto type of config for c: the file type if the log dir is writable, else the
+// logs go to stdout in a container, where they are collected by the runtime. The async queue is sized
+// by the memory limit if it's not set.
+func autoConfig(config LogConfig, c Container) LogConfig {
+	if config.Type != "auto" {
+		return config
+	}
+	config.Type = "file"
+	if c.InContainer() && (config.FileName == "" || !dirWritable(config.FileName)) {
+		config.Type, config.Outputs = "outputs", []string{"stdout"}
+	}
+	if config.AsyncQueueSize == 0 {
+		config.AsyncQueueSize = queueSizeOf(c.MemoryLimit)
+	}
+	return config
+}
@@ -0,0 +1,133 @@
+package dlog
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The helpers below operate the log dirs from outside of the process writing them, like the logctl tool.
+
+// ListBackups returns the rotated files in the dirs, like the log dir and the backup dir, the newest first
+func ListBackups(fs FS, dirs ...string) ([]BackupInfo, error) {
+	var files []backupFile
+	for _, dir := range dirs {
+		found, err := listBackups(fs, dir)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, found...)
+	}
+	infos := make([]BackupInfo, 0, len(files))
+	for _, f := range files {
+		infos = append(infos, backupInfo(f))
+	}
+	// by the time in the names, the files may be modified after rotated, like moved across the disks
+	sort.SliceStable(infos, func(i, j int) bool { return infos[i].Time.After(infos[j].Time) })
+	return infos, nil
+}
+
+// ExpiredBackups returns the backups removed by the retention of keepHours at now,
+// only the files rotated by the hour or the schedule expire
+func ExpiredBackups(backups []BackupInfo, keepHours uint, now time.Time) []BackupInfo {
+	var expired []BackupInfo
+	for _, b := range backups {
+		if shouldDel(b.Name, keepHours, now) {
+			expired = append(expired, b)
+		}
+	}
+	return expired
+}
+
+// RotateDir renames the non-empty live files in dir with the time of now, like INFO.log.201607111430,
+// the process writing them reopens the files within seconds. The rotated names are returned.
+func RotateDir(fs FS, dir string, now time.Time) ([]string, error) {
+	var rotated []string
+	for _, severity := range severityName {
+		live := filepath.Join(dir, severity+".log")
+		if info, err := fs.Stat(live); err != nil || info.Size() == 0 {
+			continue
+		}
+		name := uniqueBackupName(fs, []string{dir}, live+"."+now.Format(scheduleTagLayout))
+		if err := fs.Rename(live, name); err != nil {
+			return rotated, err
+		}
+		rotated = append(rotated, name)
+	}
+	return rotated, nil
+}
+
+// OpenDecompressed opens the log file at path, the files compressed by Gzip or Zstd are decompressed
+func OpenDecompressed(fs FS, path string) (io.ReadCloser, error) {
+	return OpenDecrypted(fs, path, nil)
+}
+
+// OpenDecrypted opens the log file at path like OpenDecompressed, the encrypted files are decrypted
+// by keys before decompressed
+func OpenDecrypted(fs FS, path string, keys *EncryptionKeys) (io.ReadCloser, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	var in io.Reader = f
+	if strings.HasSuffix(path, encExt) {
+		if keys == nil {
+			f.Close()
+			return nil, fmt.Errorf("open %s: encrypted", path)
+		}
+		if in, err = keys.NewReader(context.Background(), f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("open %s: %s", path, err)
+		}
+		path = strings.TrimSuffix(path, encExt)
+	}
+	switch filepath.Ext(path) {
+	case Gzip.Ext():
+		r, err := gzip.NewReader(in)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("open %s: %s", path, err)
+		}
+		return &decompressed{r, func() error { r.Close(); return f.Close() }}, nil
+	case Zstd.Ext():
+		r, err := zstd.NewReader(in, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("open %s: %s", path, err)
+		}
+		return &decompressed{r, func() error { r.Close(); return f.Close() }}, nil
+	}
+	return &decompressed{in, f.Close}, nil
+}
+
+type decompressed struct {
+	io.Reader
+	close func() error
+}
+
+func (d *decompressed) Close() error {
+	return d.close()
+}
+
+// LiveFiles returns the current files of the severities in dir, the missing ones are skipped
+func LiveFiles(fs FS, dir string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	for _, severity := range severityName {
+		info, err := fs.Stat(filepath.Join(dir, severity+".log"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
@@ -0,0 +1,69 @@
+package dlog
+
+import (
+	"fmt"
+	"os"
+)
+
+// freeSpace is the free space to keep on the disk of the logs
+type freeSpace struct {
+	bytes   uint64
+	percent float64
+}
+
+// SetMinFreeSpace removes the oldest rotated files whenever the free space of the disk holding the logs
+// drops below bytes or percent of the disk size, regardless of the rotate count and the keep hours.
+// A zero value disables the check, it's not supported on the platforms without statfs.
+func (self *FileBackend) SetMinFreeSpace(bytes uint64, percent float64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.minFree = freeSpace{bytes: bytes, percent: percent}
+}
+
+func SetMinFreeSpace(bytes uint64, percent float64) {
+	if fileback := getFileBackend(); fileback != nil {
+		fileback.SetMinFreeSpace(bytes, percent)
+	}
+}
+
+// enough reports whether the free space of the disk satisfies the minimum
+func (min freeSpace) enough(free, total uint64) bool {
+	if free < min.bytes {
+		return false
+	}
+	return total == 0 || float64(free)*100/float64(total) >= min.percent
+}
+
+func (self *FileBackend) pruneForFreeSpace() {
+	self.mu.Lock()
+	min := self.minFree
+	self.mu.Unlock()
+	if min.bytes == 0 && min.percent <= 0 {
+		return
+	}
+	free, total, err := diskFree(self.dir)
+	if err != nil {
+		self.reportError(fmt.Errorf("check free space of %s failed: %s", self.dir, err))
+		return
+	}
+	if min.enough(free, total) {
+		return
+	}
+	files, err := self.listBackups()
+	if err != nil {
+		self.reportError(fmt.Errorf("list rotated files of %s failed: %s", self.dir, err))
+		return
+	}
+	for _, f := range files {
+		if err := self.fs.Remove(f.path); err == nil {
+			self.countDelete(f.path)
+		} else if !os.IsNotExist(err) {
+			self.reportError(fmt.Errorf("remove %s for free space failed: %s", f.path, err))
+			return
+		}
+		if free, total, err = diskFree(self.dir); err != nil || min.enough(free, total) {
+			return
+		}
+	}
+	self.reportError(fmt.Errorf("free space of %s is still below the minimum without any rotated files", self.dir))
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !openbsd
+// +build !linux,!darwin,!freebsd,!openbsd
+
+package dlog
+
+import "errors"
+
+func diskFree(dir string) (free, total uint64, err error) {
+	return 0, 0, errors.New("free space is not supported on this platform")
+}
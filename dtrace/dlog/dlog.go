@@ -2,12 +2,16 @@ package dlog
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/tools-go/go-utils/utils/clock"
 )
 
 type Severity int
@@ -32,11 +36,41 @@ const (
 	numSeverity = 5
 )
 
+func (s Severity) String() string {
+	if s < 0 || int(s) >= numSeverity {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severityName[s]
+}
+
+// ParseSeverity parses the name of the severity, like INFO
+func ParseSeverity(name string) (Severity, error) {
+	for i, n := range severityName {
+		if n == name {
+			return Severity(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log severity: %s", name)
+}
+
 type Backend interface {
 	Log(s Severity, msg []byte)
 	close()
 }
 
+// syncer is implemented by the backends buffering the logs, for Logger.Sync
+type syncer interface {
+	sync(ctx context.Context) error
+}
+
+// entryLogger is implemented by the backends encoding the entries without the text header, like NewBinaryBackend
+type entryLogger interface {
+	logEntry(s Severity, t time.Time, file string, line int, msg []byte)
+}
+
+// closeTimeout bounds the delivery of the queued logs when the backend is closed by Logger.Close
+const closeTimeout = 3 * time.Second
+
 type stdBackend struct{}
 
 func (self *stdBackend) Log(s Severity, msg []byte) {
@@ -46,14 +80,42 @@ func (self *stdBackend) Log(s Severity, msg []byte) {
 func (self *stdBackend) close() {}
 
 type Logger struct {
-	s       Severity
-	backend Backend
-	mu      sync.Mutex
+	cfg atomic.Value // *loggerConfig, swapped as a whole so the logging never sees a half updated one
+	mu  sync.Mutex   // serializes the setters
 
 	freeList   *buffer
 	freeListMu sync.Mutex
 
+	outputsMu sync.Mutex
+	outputs   map[string]Backend // added by AddOutput
+	tee       *multiBackend      // the backend of the logger while it has the outputs
+}
+
+// loggerConfig is a snapshot of the settings of a Logger, never modified after it's stored
+type loggerConfig struct {
+	s           Severity
+	backend     Backend
 	logToStderr bool
+	clock       clock.Clock // of the timestamps, nil for the real one
+	// the limits of SetMaxBytes, 0 for no limit
+	maxFieldBytes int
+	maxEntryBytes int
+}
+
+func (self *Logger) config() *loggerConfig {
+	if cfg, ok := self.cfg.Load().(*loggerConfig); ok {
+		return cfg
+	}
+	return &loggerConfig{}
+}
+
+// update stores a copy of the config modified by fn
+func (self *Logger) update(fn func(cfg *loggerConfig)) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	cfg := *self.config()
+	fn(&cfg)
+	self.cfg.Store(&cfg)
 }
 
 //resued buffer for fast format the output string
@@ -130,8 +192,15 @@ func (self *Logger) putBuffer(b *buffer) {
 	self.freeListMu.Unlock()
 }
 
+func (self *Logger) now() time.Time {
+	if c := self.config().clock; c != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
 func (self *Logger) formatHeader(s Severity, file string, line int) *buffer {
-	now := time.Now()
+	now := self.now()
 	if line < 0 {
 		line = 0 // not a real line number, but acceptable to someDigits
 	}
@@ -168,19 +237,48 @@ func (self *Logger) formatHeader(s Severity, file string, line int) *buffer {
 }
 
 func (self *Logger) header(s Severity, depth int) *buffer {
-	_, file, line, ok := runtime.Caller(3 + depth)
-	if !ok {
-		file = "???"
-		line = 1
-	} else {
-		dirs := strings.Split(file, "/")
-		if len(dirs) >= 2 {
-			file = dirs[len(dirs)-2] + "/" + dirs[len(dirs)-1]
-		} else {
-			file = dirs[len(dirs)-1]
+	file, line := caller(3 + depth)
+	return self.formatHeader(s, file, line)
+}
+
+// callerCache caches the files and the lines of the logging calls by their pcs and depths, symbolizing
+// the pcs is the most of the cost of the headers. A slot is overwritten by the calls of the same hash.
+var callerCache [4096]atomic.Value // *cachedCaller
+
+type cachedCaller struct {
+	pc    uintptr
+	depth int
+	file  string // the dir and the name, like dlog/dlog.go
+	line  int
+}
+
+// caller returns the file and the line of the caller, skip is of runtime.Caller called where caller is
+func caller(skip int) (string, int) {
+	var pcs [1]uintptr
+	// the frames of runtime.Callers and caller
+	if runtime.Callers(skip+2, pcs[:]) == 0 {
+		return "???", 1
+	}
+	slot := &callerCache[(pcs[0]^uintptr(skip)<<7)%uintptr(len(callerCache))]
+	if c, ok := slot.Load().(*cachedCaller); ok && c.pc == pcs[0] && c.depth == skip {
+		return c.file, c.line
+	}
+	// the frames inlined are resolved by CallersFrames, like runtime.Caller
+	// copied, so pcs doesn't escape to the heap
+	frame, _ := runtime.CallersFrames([]uintptr{pcs[0]}).Next()
+	c := &cachedCaller{pc: pcs[0], depth: skip, file: shortFile(frame.File), line: frame.Line}
+	slot.Store(c)
+	return c.file, c.line
+}
+
+// shortFile returns the dir and the name of file
+func shortFile(file string) string {
+	if i := strings.LastIndexByte(file, '/'); i >= 0 {
+		if j := strings.LastIndexByte(file[:i], '/'); j >= 0 {
+			return file[j+1:]
 		}
 	}
-	return self.formatHeader(s, file, line)
+	return file
 }
 
 func (self *Logger) print(s Severity, args ...interface{}) {
@@ -192,7 +290,15 @@ func (self *Logger) printf(s Severity, format string, args ...interface{}) {
 }
 
 func (self *Logger) printDepth(s Severity, depth int, args ...interface{}) {
-	if self.s < s {
+	cfg := self.config()
+	if cfg.s < s {
+		return
+	}
+	if e := cfg.entryBackend(s); e != nil {
+		file, line := caller(2 + depth)
+		buf := self.getBuffer()
+		fmt.Fprint(buf, args...)
+		self.outputEntry(e, s, file, line, buf)
 		return
 	}
 	buf := self.header(s, depth)
@@ -204,7 +310,15 @@ func (self *Logger) printDepth(s Severity, depth int, args ...interface{}) {
 }
 
 func (self *Logger) printfDepth(s Severity, depth int, format string, args ...interface{}) {
-	if self.s < s {
+	cfg := self.config()
+	if cfg.s < s {
+		return
+	}
+	if e := cfg.entryBackend(s); e != nil {
+		file, line := caller(2 + depth)
+		buf := self.getBuffer()
+		fmt.Fprintf(buf, format, args...)
+		self.outputEntry(e, s, file, line, buf)
 		return
 	}
 	buf := self.header(s, depth)
@@ -224,14 +338,35 @@ func (self *Logger) printfSimple(format string, args ...interface{}) {
 	self.output(INFO, buf)
 }
 
+// entryBackend returns the backend if it encodes the entries itself, nil if the text line is needed:
+// for the FATAL logs, the stderr, and the limits of SetMaxBytes which cut the line
+func (cfg *loggerConfig) entryBackend(s Severity) entryLogger {
+	if s == FATAL || cfg.logToStderr || cfg.maxFieldBytes > 0 || cfg.maxEntryBytes > 0 {
+		return nil
+	}
+	e, _ := cfg.backend.(entryLogger)
+	return e
+}
+
+// outputEntry logs the message in buf with the time and the caller, but without the text header
+func (self *Logger) outputEntry(e entryLogger, s Severity, file string, line int, buf *buffer) {
+	e.logEntry(s, self.now(), file, line, buf.Bytes())
+	self.putBuffer(buf)
+}
+
 func (self *Logger) output(s Severity, buf *buffer) {
-	if self.s < s {
+	cfg := self.config()
+	if cfg.s < s {
 		return
 	}
-	if self.logToStderr {
-		os.Stderr.Write(buf.Bytes())
+	msg := buf.Bytes()
+	if cfg.maxFieldBytes > 0 || cfg.maxEntryBytes > 0 {
+		msg = truncateLog(msg, cfg.maxFieldBytes, cfg.maxEntryBytes)
+	}
+	if cfg.logToStderr {
+		os.Stderr.Write(msg)
 	} else {
-		self.backend.Log(s, buf.Bytes())
+		cfg.backend.Log(s, msg)
 	}
 	if s == FATAL {
 		trace := stacks(true)
@@ -263,33 +398,58 @@ func stacks(all bool) []byte {
 
 func NewLogger(level interface{}, backend Backend) *Logger {
 	l := new(Logger)
-	l.SetSeverity(level)
-	l.backend = backend
+	l.SetLogging(level, backend)
 	return l
 }
 
-func (l *Logger) SetSeverity(level interface{}) {
+// setSeverity parses level into cfg, it's left as is if level is unknown
+func (cfg *loggerConfig) setSeverity(level interface{}) {
 	if s, ok := level.(Severity); ok {
-		l.s = s
+		cfg.s = s
 	} else {
 		if s, ok := level.(string); ok {
 			for i, name := range severityName {
 				if name == s {
-					l.s = Severity(i)
+					cfg.s = Severity(i)
 				}
 			}
 		}
 	}
 }
 
+func (l *Logger) SetSeverity(level interface{}) {
+	l.update(func(cfg *loggerConfig) { cfg.setSeverity(level) })
+}
+
+// Severity returns the most verbose severity logged, the ones above it are dropped
+func (l *Logger) Severity() Severity {
+	return l.config().s
+}
+
 func (l *Logger) Close() {
-	if l.backend != nil {
-		l.backend.close()
+	if backend := l.config().backend; backend != nil {
+		backend.close()
 	}
 }
 
+// Sync delivers the logs buffered by the backend, like the ones queued by the syslog backend, and syncs
+// the files, so the last logs are not lost on exit. It returns the error of ctx if they are not delivered
+// before ctx is done.
+func (l *Logger) Sync(ctx context.Context) error {
+	if s, ok := l.config().backend.(syncer); ok {
+		return s.sync(ctx)
+	}
+	return nil
+}
+
 func (l *Logger) LogToStderr() {
-	l.logToStderr = true
+	l.update(func(cfg *loggerConfig) { cfg.logToStderr = true })
+}
+
+// SetClock sets the clock of the timestamps, like a clock.FakeClock for the golden tests comparing
+// the logs byte by byte, nil restores the real clock
+func (l *Logger) SetClock(c clock.Clock) {
+	l.update(func(cfg *loggerConfig) { cfg.clock = c })
 }
 
 func (l *Logger) Debug(args ...interface{}) {
@@ -332,13 +492,18 @@ func (l *Logger) Fatalf(format string, args ...interface{}) {
 	l.printf(FATAL, format, args...)
 }
 
+// SetLogging sets the level and the backend at once, so the logging never sees one without the other
 func (l *Logger) SetLogging(level interface{}, backend Backend) {
-	l.SetSeverity(level)
-	l.backend = backend
+	l.update(func(cfg *loggerConfig) {
+		cfg.setSeverity(level)
+		cfg.backend = backend
+	})
 }
 
 /////////////////////////////////////////////////////////////////
 // depth version, only a low level api
+
+// LogDepth logs with the caller of the function calling it, the depth is of the more wrappers above
 func (l *Logger) LogDepth(s Severity, depth int, args ...interface{}) {
 	l.printDepth(s, depth+1, args...)
 }
@@ -354,8 +519,20 @@ func (l *Logger) PrintfSimple(format string, args ...interface{}) {
 /*---------------------------------------------------------------------------*/
 
 var logging Logger
-var fileback *FileBackend = nil
-var sysback *syslogBackend = nil
+
+// the backends created by Init, for the package level setters
+var backends struct {
+	sync.RWMutex
+	file *FileBackend
+	sys  *syslogBackend
+}
+
+// getFileBackend returns the file backend created by Init, or nil
+func getFileBackend() *FileBackend {
+	backends.RLock()
+	defer backends.RUnlock()
+	return backends.file
+}
 
 func init() {
 	SetLogging(DEBUG, &stdBackend{})
@@ -373,10 +550,22 @@ func Close() {
 	logging.Close()
 }
 
+func Sync(ctx context.Context) error {
+	return logging.Sync(ctx)
+}
+
+func GetSeverity() Severity {
+	return logging.Severity()
+}
+
 func LogToStderr() {
 	logging.LogToStderr()
 }
 
+func SetClock(c clock.Clock) {
+	logging.SetClock(c)
+}
+
 /*-----------------------------public functions------------------------------*/
 
 func Debug(args ...interface{}) {
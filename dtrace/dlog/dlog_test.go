@@ -1,9 +1,36 @@
 package dlog
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log/syslog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/tools-go/go-utils/notify"
+	"github.com/tools-go/go-utils/secrets"
+	"github.com/tools-go/go-utils/utils/clock"
 )
 
 func InfoHelperDepth(format string, args ...interface{}) {
@@ -144,6 +171,755 @@ func TestSysLogBackend(t *testing.T) {
 	time.Sleep(time.Second * 2)
 }
 
+func TestSyncSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	b, err := DialSyslogBackend("udp", conn.LocalAddr().String(), syslog.LOG_LOCAL3, "dlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan int)
+	go func() {
+		n, buf := 0, make([]byte, 4096)
+		for {
+			conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				received <- n
+				return
+			}
+			n++
+		}
+	}()
+
+	l := NewLogger(DEBUG, b)
+	for i := 0; i < 100; i++ {
+		l.Infof("line %d", i)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := l.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if pending := atomic.LoadInt64(&b.pending); pending != 0 {
+		t.Fatalf("expect the queue drained, %d pending", pending)
+	}
+	if err := b.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	l.Info("after closed, to stderr")
+	if n := <-received; n != 100 {
+		t.Fatalf("expect 100 logs delivered, got %d", n)
+	}
+}
+
+func TestSyslogOverflow(t *testing.T) {
+	for spec, expected := range map[string]OverflowPolicy{
+		"":            {Mode: OverflowStderr},
+		"drop-newest": {Mode: OverflowDropNewest},
+		"drop-oldest": {Mode: OverflowDropOldest},
+		"block":       {Mode: OverflowBlock},
+		"block=50ms":  {Mode: OverflowBlock, Timeout: 50 * time.Millisecond},
+	} {
+		if p, err := ParseOverflowPolicy(spec); err != nil || p != expected {
+			t.Fatalf("%q: unexpected %+v, %v", spec, p, err)
+		}
+	}
+	for _, spec := range []string{"drop", "block=0", "block=x"} {
+		if _, err := ParseOverflowPolicy(spec); err == nil {
+			t.Fatalf("%q: expect error", spec)
+		}
+	}
+
+	// the queues of 2 logs without the writers
+	newBackend := func(policy string) *syslogBackend {
+		b := &syslogBackend{}
+		for i := 0; i < numSeverity; i++ {
+			b.buf[i] = make(chan []byte, 2)
+		}
+		p, _ := ParseOverflowPolicy(policy)
+		b.SetOverflowPolicy(p)
+		for _, msg := range []string{"1", "2", "3"} {
+			b.tryPutInBuf(INFO, []byte(msg))
+		}
+		return b
+	}
+	queued := func(b *syslogBackend) string {
+		return string(<-b.buf[INFO]) + string(<-b.buf[INFO])
+	}
+	if b := newBackend("drop-newest"); b.Dropped() != 1 || queued(b) != "12" {
+		t.Fatal("expect the newest log dropped")
+	}
+	if b := newBackend("drop-oldest"); b.Dropped() != 1 || queued(b) != "23" {
+		t.Fatal("expect the oldest log dropped")
+	}
+	start := time.Now()
+	b := newBackend("block=20ms")
+	if b.Dropped() != 1 || time.Since(start) < 20*time.Millisecond || atomic.LoadInt64(&b.pending) != 2 {
+		t.Fatal("expect the log dropped after the timeout")
+	}
+	if summary := b.dropSummary(); !strings.Contains(summary, "1 logs dropped") {
+		t.Fatalf("unexpected summary %q", summary)
+	}
+	if summary := b.dropSummary(); summary != "" {
+		t.Fatalf("expect no summary without new drops, got %q", summary)
+	}
+}
+
+// recordingBackend records the logs and the batches written by the async backend
+type recordingBackend struct {
+	mu      sync.Mutex
+	logs    []string
+	batches []int
+	block   chan struct{} // blocks the writes until closed, if not nil
+}
+
+func (r *recordingBackend) Log(s Severity, msg []byte) {
+	r.logBatch([]asyncEntry{{s, msg}})
+}
+
+func (r *recordingBackend) logBatch(entries []asyncEntry) {
+	if r.block != nil {
+		<-r.block
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range entries {
+		r.logs = append(r.logs, string(e.msg))
+	}
+	r.batches = append(r.batches, len(entries))
+}
+
+func (r *recordingBackend) close() {}
+
+func TestAsyncBackend(t *testing.T) {
+	rec := &recordingBackend{block: make(chan struct{})}
+	b := NewAsyncBackend(rec, AsyncConfig{QueueSize: 100, BatchSize: 30})
+	for i := 0; i < 100; i++ {
+		b.Log(INFO, []byte(fmt.Sprint(i)))
+	}
+	// the worker holds the first one, the rest are batched after the writes unblock
+	close(rec.block)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := NewLogger(DEBUG, b).Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	rec.mu.Lock()
+	if len(rec.logs) != 100 || len(rec.batches) > 5 {
+		t.Fatalf("expect 100 logs in at most 5 batches, got %d in %v", len(rec.logs), rec.batches)
+	}
+	for i, l := range rec.logs {
+		if l != fmt.Sprint(i) {
+			t.Fatalf("expect the logs in order, got %v", rec.logs)
+		}
+	}
+	rec.mu.Unlock()
+
+	// the full queue drops the newest
+	rec = &recordingBackend{block: make(chan struct{})}
+	b = NewAsyncBackend(rec, AsyncConfig{QueueSize: 2, Overflow: OverflowPolicy{Mode: OverflowDropNewest}})
+	for i := 0; i < 10; i++ {
+		b.Log(INFO, []byte(fmt.Sprint(i)))
+	}
+	close(rec.block)
+	if err := b.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if dropped := b.Dropped(); dropped < 7 || int(dropped)+len(rec.logs) != 10 {
+		t.Fatalf("expect the dropped counted, %d dropped, %d written", dropped, len(rec.logs))
+	}
+	n := len(rec.logs)
+	b.Log(INFO, []byte("after closed\n"))
+	if len(rec.logs) != n {
+		t.Fatalf("expect the logs after closed not written to the closed backend, got %v", rec.logs)
+	}
+
+	// the logs racing with Close are either written or dropped, none is left pending
+	rec = &recordingBackend{}
+	b = NewAsyncBackend(rec, AsyncConfig{QueueSize: 10})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				b.Log(INFO, []byte("racing\n"))
+			}
+		}()
+	}
+	b.Close(ctx)
+	wg.Wait()
+	if pending := atomic.LoadInt64(&b.pending); pending != 0 {
+		t.Fatalf("expect no logs pending after closed, got %d", pending)
+	}
+
+	// the logs left by the timed out Close are dropped
+	rec = &recordingBackend{block: make(chan struct{})}
+	b = NewAsyncBackend(rec, AsyncConfig{QueueSize: 10, BatchSize: 1})
+	for i := 0; i < 5; i++ {
+		b.Log(INFO, []byte("left\n"))
+	}
+	timeout, cancelTimeout := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancelTimeout()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(rec.block)
+	}()
+	if err := b.Close(timeout); err == nil {
+		t.Fatal("expect the close timed out")
+	}
+	if pending := atomic.LoadInt64(&b.pending); pending != 0 || int(b.Dropped())+len(rec.logs) != 5 {
+		t.Fatalf("expect the logs left dropped, %d pending, %d dropped, %d written", pending, b.Dropped(), len(rec.logs))
+	}
+
+	// to the file backend by the config
+	dir, _ := ioutil.TempDir("", "dlog-async")
+	defer os.RemoveAll(dir)
+	l, err := NewLoggerWithConfig(LogConfig{Type: "file", Level: "INFO", FileName: dir, Async: true, AsyncLinger: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("async")
+	if err := l.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "INFO.log")); !strings.Contains(string(data), "async") {
+		t.Fatalf("expect the log written, got %q", data)
+	}
+}
+
+func TestOutputsBackend(t *testing.T) {
+	root, _ := ioutil.TempDir("", "dlog-outputs")
+	defer os.RemoveAll(root)
+	errDir, allDir := filepath.Join(root, "errors"), filepath.Join(root, "all")
+	l, err := NewLoggerWithConfig(LogConfig{Type: "outputs", Level: "DEBUG", Outputs: []string{"error:" + errDir, "info+:" + allDir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("debug")
+	l.Info("info")
+	l.Error("error")
+	l.Sync(context.Background())
+	for path, expected := range map[string]string{
+		filepath.Join(errDir, "ERROR.log"): "error",
+		filepath.Join(errDir, "INFO.log"):  "",
+		filepath.Join(allDir, "INFO.log"):  "info",
+		filepath.Join(allDir, "ERROR.log"): "error",
+		filepath.Join(allDir, "DEBUG.log"): "",
+	} {
+		data, _ := ioutil.ReadFile(path)
+		if expected == "" && len(data) > 0 || !strings.Contains(string(data), expected) {
+			t.Fatalf("unexpected %s: %q", path, data)
+		}
+	}
+
+	for _, outputs := range [][]string{nil, {"verbose:stdout"}, {"info:"}} {
+		if _, err := NewOutputsBackend(outputs...); err == nil {
+			t.Fatalf("%q: expect error", outputs)
+		}
+	}
+	// not a level
+	var dirs []string
+	if _, err := newOutputsBackend([]string{`C:\log`, "warning+:stderr"}, func(dir string) (*FileBackend, error) {
+		dirs = append(dirs, dir)
+		return NewFileBackendFS(dir, NewMemFS())
+	}); err != nil || len(dirs) != 1 || dirs[0] != `C:\log` {
+		t.Fatalf("unexpected dirs %v, %v", dirs, err)
+	}
+}
+
+func TestAddOutput(t *testing.T) {
+	root, _ := ioutil.TempDir("", "dlog-add-output")
+	defer os.RemoveAll(root)
+	first, second, diag := filepath.Join(root, "first"), filepath.Join(root, "second"), filepath.Join(root, "diag")
+	fb, _ := NewFileBackend(first)
+	l := NewLogger("DEBUG", fb)
+	l.Info("before")
+	if err := l.AddOutput("info+:" + diag); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.AddOutput("info+:" + diag); err == nil {
+		t.Fatal("expect the duplicated output rejected")
+	}
+	l.Info("during")
+	fb.Flush()
+	// the outputs are kept when the backend is replaced
+	fb, _ = NewFileBackend(second)
+	l.SetLogging("DEBUG", fb)
+	if err := l.AddOutput("stderr"); err != nil {
+		t.Fatal(err)
+	}
+	l.Info("replaced")
+	if err := l.RemoveOutput("info+:" + diag); err != nil {
+		t.Fatal(err)
+	}
+	l.RemoveOutput("stderr")
+	l.Info("after")
+	l.Sync(context.Background())
+
+	for path, expected := range map[string][]string{
+		filepath.Join(first, "INFO.log"):  {"before", "during"},
+		filepath.Join(second, "INFO.log"): {"replaced", "after"},
+		filepath.Join(diag, "INFO.log"):   {"during", "replaced"},
+	} {
+		data, _ := ioutil.ReadFile(path)
+		var logs []string
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			logs = append(logs, line[strings.LastIndex(line, " ")+1:])
+		}
+		if strings.Join(logs, ",") != strings.Join(expected, ",") {
+			t.Fatalf("unexpected %s: %q", path, data)
+		}
+	}
+	if err := l.RemoveOutput("stderr"); err == nil {
+		t.Fatal("expect the removed output unknown")
+	}
+}
+
+func TestRemoveOutputLeaks(t *testing.T) {
+	root, _ := ioutil.TempDir("", "dlog-remove-output")
+	defer os.RemoveAll(root)
+	l := NewLogger("DEBUG", &stdBackend{})
+	output := "info+:" + filepath.Join(root, "diag")
+	// the shared watcher of the dirs is started once
+	l.AddOutput(output)
+	l.RemoveOutput(output)
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		if err := l.AddOutput(output); err != nil {
+			t.Fatal(err)
+		}
+		l.Info("incident")
+		if err := l.RemoveOutput(output); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i == 100 {
+			t.Fatalf("expect the daemons of the removed outputs stopped, %d goroutines left of %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(root, "diag", "INFO.log"))
+	if n := strings.Count(string(data), "incident"); n != 10 {
+		t.Fatalf("expect 10 logs flushed by the close, got %d", n)
+	}
+
+	fb, err := NewFileBackendFS("/logs", NewMemFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb.Close()
+	fb.Close()
+	fb.Log(INFO, []byte("after close\n"))
+	fb.Flush()
+	if !fb.closed {
+		t.Fatal("expect the files closed")
+	}
+
+	// Close waits for the archives recovered by the setters
+	fs := &blockingFS{FS: NewMemFS(), dir: "/backup", release: make(chan struct{})}
+	fb, err = NewFileBackendFS("/logs", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the recovery started by NewFileBackendFS reads the log dir only, let it finish first
+	time.Sleep(20 * time.Millisecond)
+	if err := fb.SetBackupDir("/backup"); err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan struct{})
+	go func() {
+		fb.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("expect Close waiting for the recovery of the archives")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(fs.release)
+	<-closed
+	reads := atomic.LoadInt32(&fs.reads)
+	fb.SetCompressor(Gzip)
+	if fb.daemons.Wait(); atomic.LoadInt32(&fs.reads) != reads {
+		t.Fatal("expect no recovery after Close")
+	}
+}
+
+// blockingFS blocks reading dir until release is closed
+type blockingFS struct {
+	FS
+	dir     string
+	release chan struct{}
+	reads   int32
+}
+
+func (fs *blockingFS) ReadDir(dir string) ([]os.FileInfo, error) {
+	if dir == fs.dir {
+		atomic.AddInt32(&fs.reads, 1)
+		<-fs.release
+	}
+	return fs.FS.ReadDir(dir)
+}
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2016, 7, 11, 14, 30, 0, 0, time.UTC)
+	for spec, expect := range map[string]time.Time{
+		"hourly":           time.Date(2016, 7, 11, 15, 0, 0, 0, time.UTC),
+		"daily":            time.Date(2016, 7, 12, 0, 0, 0, 0, time.UTC),
+		"@every 20m":       time.Date(2016, 7, 11, 14, 40, 0, 0, time.UTC),
+		"0 */6 * * *":      time.Date(2016, 7, 11, 18, 0, 0, 0, time.UTC),
+		"30 2 * * 6,0":     time.Date(2016, 7, 16, 2, 30, 0, 0, time.UTC),
+		"0 0 1 1-3 *":      time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 15 * 1":       time.Date(2016, 7, 15, 0, 0, 0, 0, time.UTC),
+		"0-30/15 14 * * *": time.Date(2016, 7, 12, 14, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":       time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC),
+	} {
+		s, err := ParseSchedule(spec)
+		if err != nil {
+			t.Fatalf("%s: %s", spec, err)
+		}
+		if next := s.Next(from); !next.Equal(expect) {
+			t.Fatalf("%s: expect %s, got %s", spec, expect, next)
+		}
+	}
+	for _, spec := range []string{"weekly", "@every 1s", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "0 0 30 2 *", "0 0 31 4,6,9,11 *"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Fatalf("%s: expect error", spec)
+		}
+	}
+}
+
+func TestRotateSchedule(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "dlog-schedule")
+	defer os.RemoveAll(dir)
+	fb, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := clock.NewFakeClock(time.Date(2016, 7, 11, 14, 30, 0, 0, time.Local))
+	fb.SetClock(c)
+	if err := fb.SetRotateSchedule("hourly"); err != nil {
+		t.Fatal(err)
+	}
+	fb.Log(INFO, []byte("before\n"))
+	since := fb.files[INFO].since
+	c.Advance(30 * time.Minute)
+	fb.Log(INFO, []byte("after\n"))
+	fb.Flush()
+
+	rotated, _ := ioutil.ReadFile(filepath.Join(dir, "INFO.log."+since.Format(scheduleTagLayout)))
+	current, _ := ioutil.ReadFile(filepath.Join(dir, "INFO.log"))
+	if string(rotated) != "before\n" || string(current) != "after\n" {
+		t.Fatalf("unexpected rotated %q, current %q", rotated, current)
+	}
+	if !fb.files[INFO].next.Equal(time.Date(2016, 7, 11, 16, 0, 0, 0, time.Local)) {
+		t.Fatalf("expect the next rotation scheduled, got %s", fb.files[INFO].next)
+	}
+	if !shouldDel("INFO.log."+since.Format(scheduleTagLayout), 1, c.Now().Add(2*time.Hour)) {
+		t.Fatal("expect the rotated file cleaned after the keep hours")
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	fs := NewMemFS()
+	fb, err := NewFileBackendFS("/logs", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"INFO.log.2016071110", "INFO.log.2016071111.gz", "INFO.log.2016071111_2.gz.enc",
+		"ERROR.log.201607111130.zst", "INFO.log.2016071113.gz.enc", "INFO.log.001.gz", "INFO.log.2016071111.gz.tmp"} {
+		f, _ := fs.OpenFile("/logs/"+name, os.O_CREATE|os.O_WRONLY, 0644)
+		f.Close()
+	}
+	fb.removeExpired(2, time.Date(2016, 7, 11, 14, 30, 0, 0, time.Local))
+	infos, _ := fs.ReadDir("/logs")
+	var names []string
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name(), ".log") {
+			names = append(names, info.Name())
+		}
+	}
+	expected := "INFO.log.001.gz INFO.log.2016071111.gz.tmp INFO.log.2016071113.gz.enc"
+	if strings.Join(names, " ") != expected {
+		t.Fatalf("unexpected files %v", names)
+	}
+}
+
+func TestCompressRotated(t *testing.T) {
+	for name, decompress := range map[string]func(r io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"zstd": func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	} {
+		dir, _ := ioutil.TempDir("", "dlog-compress")
+		defer os.RemoveAll(dir)
+		fb, err := NewFileBackend(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := CompressorOf(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fb.SetCompressor(c)
+		fb.Rotate(2, 16)
+		fb.Log(INFO, []byte("first line\n"))
+		fb.Log(INFO, []byte("second line\n"))
+
+		compressed := filepath.Join(dir, "INFO.log.000"+c.Ext())
+		for i := 0; i < 100; i++ {
+			if _, err := os.Stat(compressed); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		f, err := os.Open(compressed)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		r, err := decompress(f)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		data, _ := ioutil.ReadAll(r)
+		f.Close()
+		if string(data) != "first line\n" {
+			t.Fatalf("%s: unexpected %q", name, data)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "INFO.log.000")); !os.IsNotExist(err) {
+			t.Fatalf("%s: expect the rotated file removed", name)
+		}
+	}
+	if _, err := CompressorOf("lz4"); err == nil {
+		t.Fatal("expect unknown compressor")
+	}
+}
+
+func TestBackupBudget(t *testing.T) {
+	root, _ := ioutil.TempDir("", "dlog-budget")
+	defer os.RemoveAll(root)
+	write := func(dir, name string, size int, age time.Duration) {
+		os.MkdirAll(dir, 0755)
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, make([]byte, size), 0644)
+		os.Chtimes(path, time.Now().Add(-age), time.Now().Add(-age))
+	}
+	noisy, quiet := filepath.Join(root, "noisy"), filepath.Join(root, "quiet")
+	write(noisy, "INFO.log.000", 400, 4*time.Hour)
+	write(noisy, "INFO.log.001.gz", 400, 3*time.Hour)
+	write(noisy, "INFO.log.002", 400, time.Hour)
+	write(quiet, "INFO.log.2016071114", 300, 5*time.Hour)
+	write(quiet, "INFO.log.2016071115.zst", 100, 2*time.Hour)
+	// the files not rotated are never removed
+	write(noisy, "app.data", 1000, 6*time.Hour)
+
+	b := NewBackupBudget(1000)
+	for _, dir := range []string{noisy, quiet, noisy} {
+		fb, err := NewFileBackend(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Attach(fb)
+	}
+	if err := b.Enforce(); err != nil {
+		t.Fatal(err)
+	}
+	for name, exist := range map[string]bool{
+		"noisy/INFO.log.000":            false,
+		"noisy/INFO.log.001.gz":         false,
+		"noisy/INFO.log.002":            true,
+		"noisy/app.data":                true,
+		"quiet/INFO.log.2016071114":     true,
+		"quiet/INFO.log.2016071115.zst": true,
+	} {
+		if _, err := os.Stat(filepath.Join(root, name)); (err == nil) != exist {
+			t.Fatalf("%s: expect exist %v", name, exist)
+		}
+	}
+}
+
+type failingCompressor struct{}
+
+func (failingCompressor) Ext() string {
+	return ".fail"
+}
+
+func (failingCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("disk full")
+}
+
+func TestErrorHandler(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "dlog-errors")
+	defer os.RemoveAll(dir)
+	fb, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	fb.SetErrorHandler(func(err error) {
+		// logging with the backend itself is safe
+		fb.Log(INFO, []byte(err.Error()+"\n"))
+		errs <- err
+	})
+	fb.SetCompressor(failingCompressor{})
+	fb.Rotate(2, 16)
+	fb.Log(INFO, []byte("first line\n"))
+	fb.Log(INFO, []byte("second line\n"))
+
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "disk full") {
+			t.Fatalf("unexpected error %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the compression error reported")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "INFO.log.000")); err != nil {
+		t.Fatal("expect the rotated file kept")
+	}
+}
+
+func TestMinFreeSpace(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "dlog-free")
+	defer os.RemoveAll(dir)
+	fb, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 10)
+	fb.SetErrorHandler(func(err error) { errs <- err })
+	for _, name := range []string{"INFO.log.000", "ERROR.log.2016071114.gz", "app.data"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("data"), 0644)
+	}
+
+	// the disk always has enough space
+	fb.SetMinFreeSpace(1, 0)
+	fb.pruneForFreeSpace()
+	if files, _ := listBackups(OSFS, dir); len(files) != 2 {
+		t.Fatalf("expect the rotated files kept, got %v", files)
+	}
+
+	// the disk never has enough space
+	fb.SetMinFreeSpace(1<<62, 0)
+	fb.pruneForFreeSpace()
+	if files, _ := listBackups(OSFS, dir); len(files) != 0 {
+		t.Fatalf("expect the rotated files removed, got %v", files)
+	}
+	for _, name := range []string{"INFO.log", "app.data"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("expect %s kept", name)
+		}
+	}
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "still below the minimum") {
+			t.Fatalf("unexpected error %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the shortage reported")
+	}
+}
+
+func TestBackupDir(t *testing.T) {
+	root, _ := ioutil.TempDir("", "dlog-archive")
+	defer os.RemoveAll(root)
+	dir, archive := filepath.Join(root, "live"), filepath.Join(root, "archive")
+	fb, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fb.SetBackupDir(archive); err != nil {
+		t.Fatal(err)
+	}
+	fb.Rotate(3, 16)
+	fb.Log(INFO, []byte("first line\n"))
+	fb.Log(INFO, []byte("second line\n"))
+	fb.SetCompressor(Gzip)
+	fb.Log(INFO, []byte("third line\n"))
+
+	waitFor := func(path string) {
+		for i := 0; i < 100; i++ {
+			if _, err := os.Stat(path); err == nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expect %s", path)
+	}
+	waitFor(filepath.Join(archive, "INFO.log.000"))
+	waitFor(filepath.Join(archive, "INFO.log.001.gz"))
+	if data, _ := ioutil.ReadFile(filepath.Join(archive, "INFO.log.000")); string(data) != "first line\n" {
+		t.Fatalf("unexpected archived %q", data)
+	}
+	if files, _ := listBackups(OSFS, dir); len(files) != 0 {
+		t.Fatalf("expect no rotated files in the log dir, got %v", files)
+	}
+	if files, _ := fb.listBackups(); len(files) != 2 {
+		t.Fatalf("expect the archived files listed, got %v", files)
+	}
+}
+
+func TestBackups(t *testing.T) {
+	root, _ := ioutil.TempDir("", "dlog-backups")
+	defer os.RemoveAll(root)
+	dir, archive := filepath.Join(root, "live"), filepath.Join(root, "archive")
+	fb, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fb.SetBackupDir(archive); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	for name, content := range map[string]string{
+		filepath.Join(dir, "INFO.log.000"):                "by size",
+		filepath.Join(archive, "ERROR.log.2016071114.gz"): "hourly",
+		filepath.Join(archive, "INFO.log.201607111430"):   "scheduled",
+		filepath.Join(archive, "README.txt"):              "not a backup",
+	} {
+		ioutil.WriteFile(name, []byte(content), 0644)
+		os.Chtimes(name, old, old)
+		old = old.Add(time.Minute)
+	}
+	os.Chtimes(filepath.Join(dir, "INFO.log.000"), time.Now(), time.Now())
+
+	backups, err := fb.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]BackupInfo{}
+	for _, b := range backups {
+		got[b.Name] = b
+	}
+	if len(backups) != 3 || backups[0].Name != "INFO.log.000" {
+		t.Fatalf("unexpected backups %+v", backups)
+	}
+	if b := got["ERROR.log.2016071114.gz"]; b.Severity != "ERROR" || b.Compressed != "gz" || b.Size != 6 ||
+		!b.Time.Equal(time.Date(2016, 7, 11, 14, 0, 0, 0, time.Local)) {
+		t.Fatalf("unexpected hourly backup %+v", b)
+	}
+	if b := got["INFO.log.201607111430"]; b.Compressed != "" || !b.Time.Equal(time.Date(2016, 7, 11, 14, 30, 0, 0, time.Local)) {
+		t.Fatalf("unexpected scheduled backup %+v", b)
+	}
+
+	f, err := fb.OpenBackup("INFO.log.201607111430")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(f)
+	f.Close()
+	if string(data) != "scheduled" {
+		t.Fatalf("unexpected content %q", data)
+	}
+	if _, err := fb.OpenBackup("../live/INFO.log"); err == nil {
+		t.Fatal("expect only the backups opened")
+	}
+}
+
 /*
 func TestMultiBackend(t *testing.T) {
 	b1, err := NewFileBackend("/tmp/dlog-test")
@@ -160,3 +936,1947 @@ func TestMultiBackend(t *testing.T) {
 	Info("test multi")
 }
 */
+
+func TestMemFS(t *testing.T) {
+	t.Parallel()
+	fs := NewMemFS()
+	fb, err := NewFileBackendFS("/logs", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fb.SetBackupDir("/archive"); err != nil {
+		t.Fatal(err)
+	}
+	fb.Rotate(2, 16)
+	for _, line := range []string{"first line\n", "second line\n", "third line\n", "fourth line\n"} {
+		fb.Log(INFO, []byte(line))
+	}
+	fb.Flush()
+
+	for i := 0; i < 100; i++ {
+		if archived, _ := fs.ReadDir("/archive"); len(archived) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	backups, _ := fb.Backups()
+	names := []string{}
+	for _, b := range backups {
+		names = append(names, b.Name)
+	}
+	// the size rotation reuses the names after rotateNum files, the last one wins
+	if len(backups) != 2 {
+		t.Fatalf("unexpected backups %v", names)
+	}
+	f, err := fb.OpenBackup("INFO.log.000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(f)
+	if string(data) != "third line\n" {
+		t.Fatalf("unexpected rotated %q", data)
+	}
+	infos, _ := fs.ReadDir("/logs")
+	f, _ = fs.Open("/logs/INFO.log")
+	data, _ = ioutil.ReadAll(f)
+	if len(infos) != numSeverity || string(data) != "fourth line\n" {
+		t.Fatalf("unexpected live files %d, %q", len(infos), data)
+	}
+	if _, err := os.Stat("/logs"); !os.IsNotExist(err) {
+		t.Fatal("expect nothing written to the disk")
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	t.Parallel()
+	provider := secrets.ProviderFunc(func(ctx context.Context, name string) (secrets.Secret, error) {
+		switch name {
+		case "log-key-1":
+			return "0123456789abcdef0123456789abcdef", nil
+		case "log-key-2":
+			return secrets.Secret(base64.StdEncoding.EncodeToString([]byte("fedcba9876543210"))), nil
+		}
+		return "", fmt.Errorf("secret %s not found", name)
+	})
+	fs := NewMemFS()
+	fb, err := NewFileBackendFS("/logs", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewEncryptionKeys(provider, "log-key-1")
+	fb.SetCompressor(Gzip)
+	fb.SetEncryption(keys)
+	fb.Rotate(2, 16)
+	for _, line := range []string{"first line\n", "second line\n", "third line\n"} {
+		fb.Log(INFO, []byte(line))
+	}
+	fb.Flush()
+	for i := 0; i < 100; i++ {
+		if _, err := fs.Stat("/logs/INFO.log.001.gz.enc"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	backups, _ := fb.Backups()
+	if len(backups) != 2 || !backups[0].Encrypted || backups[0].Compressed != "gz" {
+		t.Fatalf("unexpected backups %+v", backups)
+	}
+
+	f, err := fb.OpenBackup("INFO.log.001.gz.enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(r); string(data) != "second line\n" {
+		t.Fatalf("unexpected decrypted %q", data)
+	}
+	if _, err := OpenDecompressed(fs, "/logs/INFO.log.001.gz.enc"); err == nil {
+		t.Fatal("expect the encrypted file not opened without the keys")
+	}
+	// the files of the previous keys are still decrypted after the rotation of the keys
+	rotated := NewEncryptionKeys(provider, "log-key-2", "log-key-1")
+	if rc, err := OpenDecrypted(fs, "/logs/INFO.log.001.gz.enc", rotated); err != nil {
+		t.Fatal(err)
+	} else if data, _ := ioutil.ReadAll(rc); string(data) != "second line\n" {
+		t.Fatalf("unexpected decrypted %q", data)
+	}
+	if _, err := OpenDecrypted(fs, "/logs/INFO.log.001.gz.enc", NewEncryptionKeys(provider, "log-key-2")); err == nil {
+		t.Fatal("expect the key not listed rejected")
+	}
+
+	// multiple chunks, and the truncated file rejected
+	var buf bytes.Buffer
+	w, err := rotated.NewWriter(context.Background(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := bytes.Repeat([]byte("0123456789\n"), encChunkSize/5)
+	w.Write(plain[:100])
+	w.Write(plain[100:])
+	w.Close()
+	dr, err := rotated.NewReader(context.Background(), bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(dr); err != nil || !bytes.Equal(data, plain) {
+		t.Fatalf("unexpected decrypted of %d bytes: %v", len(data), err)
+	}
+	dr, _ = rotated.NewReader(context.Background(), bytes.NewReader(buf.Bytes()[:buf.Len()-100]))
+	if _, err := ioutil.ReadAll(dr); err == nil {
+		t.Fatal("expect the truncated file rejected")
+	}
+}
+
+func TestNetworkBackend(t *testing.T) {
+	// the agent is not listening yet, the logs are queued until it's up
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+	nb, err := NewNetworkBackend("tcp://"+addr, NetworkConfig{MinBackoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nb.Log(INFO, []byte("first\n"))
+	nb.Log(ERROR, []byte("second\n"))
+	time.Sleep(50 * time.Millisecond)
+	if l, err = net.Listen("tcp", addr); err != nil {
+		t.Skipf("the port is taken: %s", err)
+	}
+	defer l.Close()
+	received := make(chan string, 10)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			received <- line
+		}
+	}()
+	nb.Log(INFO, []byte("third\n"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := nb.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"first\n", "second\n", "third\n"} {
+		select {
+		case line := <-received:
+			if line != expected {
+				t.Fatalf("expect %q, got %q", expected, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q not received", expected)
+		}
+	}
+
+	for _, output := range []string{"tcp://", "ftp://host", "unix://"} {
+		if _, err := NewOutputsBackend(output); err == nil {
+			t.Fatalf("expect %s rejected", output)
+		}
+	}
+	m, err := NewOutputsBackend("error:udp://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lb, ok := m.backends()[0].(*levelBackend); !ok || lb.backend.(*networkBackend).network != "udp" {
+		t.Fatalf("unexpected outputs %#v", m.backends())
+	}
+	m.close()
+}
+
+func TestNetworkBackendStalled(t *testing.T) {
+	// the agent accepts the connection but never reads
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(10 * time.Second)
+		}
+	}()
+	nb, err := NewNetworkBackend("tcp://"+l.Addr().String(), NetworkConfig{WriteTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(strings.Repeat("x", 64<<10) + "\n")
+	for i := 0; i < 256; i++ {
+		nb.Log(INFO, line)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := nb.Close(ctx); err == nil {
+		t.Fatal("expect the undelivered logs reported")
+	}
+	select {
+	case <-nb.done:
+	case <-time.After(time.Second):
+		t.Fatalf("expect the stalled write aborted by Close, closed in %s", time.Since(start))
+	}
+}
+
+// fakeProducer records the published logs, or fails them if down
+type fakeProducer struct {
+	mu        sync.Mutex
+	cfg       KafkaConfig
+	published map[string][]string
+	down      bool
+	closed    bool
+}
+
+func (p *fakeProducer) Produce(ctx context.Context, topic string, msgs [][]byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.down {
+		return fmt.Errorf("brokers down")
+	}
+	for _, msg := range msgs {
+		p.published[topic] = append(p.published[topic], string(msg))
+	}
+	return nil
+}
+
+func (p *fakeProducer) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestKafkaBackend(t *testing.T) {
+	if _, err := NewOutputsBackend("kafka://b1/logs"); err == nil {
+		t.Fatal("expect the kafka output rejected without a dialer")
+	}
+	producer := &fakeProducer{published: map[string][]string{}}
+	SetKafkaDialer(func(cfg KafkaConfig) (KafkaProducer, error) {
+		producer.cfg = cfg
+		return producer, nil
+	})
+	defer SetKafkaDialer(nil)
+
+	fs := NewMemFS()
+	m, err := newOutputsBackend([]string{"info+:kafka://b1:9092,b2:9092/app-logs?compression=zstd&linger=1ms&fallback=/fallback"},
+		func(dir string) (*FileBackend, error) {
+			return NewFileBackendFS(dir, fs)
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(producer.cfg.Brokers, ",") != "b1:9092,b2:9092" || producer.cfg.Topic != "app-logs" ||
+		producer.cfg.Compression != "zstd" || producer.cfg.Linger != time.Millisecond {
+		t.Fatalf("unexpected config %+v", producer.cfg)
+	}
+	if fbs := fileBackends(m); len(fbs) != 1 || fbs[0].dir != "/fallback" {
+		t.Fatalf("expect the fallback file backend, got %v", fbs)
+	}
+	m.Log(INFO, []byte("published\n"))
+	m.Log(DEBUG, []byte("filtered\n"))
+	m.sync(context.Background())
+	producer.mu.Lock()
+	producer.down = true
+	producer.mu.Unlock()
+	m.Log(ERROR, []byte("fallen back\n"))
+	m.sync(context.Background())
+	m.close()
+
+	if logs := producer.published["app-logs"]; len(logs) != 1 || logs[0] != "published\n" {
+		t.Fatalf("unexpected published %q", logs)
+	}
+	f, _ := fs.Open("/fallback/ERROR.log")
+	if data, _ := ioutil.ReadAll(f); string(data) != "fallen back\n" {
+		t.Fatalf("unexpected fallback %q", data)
+	}
+	if !producer.closed {
+		t.Fatal("expect the producer closed")
+	}
+
+	// the fallback created for the output is closed as the dialing fails
+	SetKafkaDialer(func(cfg KafkaConfig) (KafkaProducer, error) {
+		return nil, fmt.Errorf("brokers down")
+	})
+	var fallback *FileBackend
+	if _, err := newOutputsBackend([]string{"kafka://b1/logs?fallback=/fallback"}, func(dir string) (*FileBackend, error) {
+		fallback, err = NewFileBackendFS(dir, fs)
+		return fallback, err
+	}); err == nil {
+		t.Fatal("expect the kafka output failed to dial")
+	}
+	select {
+	case <-fallback.quit:
+	default:
+		t.Fatal("expect the fallback closed")
+	}
+
+	for _, output := range []string{"kafka://b1", "kafka:///logs", "kafka://b1/logs?batch=x"} {
+		if _, err := NewOutputsBackend(output); err == nil {
+			t.Fatalf("expect %s rejected", output)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	rec := &recordingBackend{block: make(chan struct{})}
+	async := NewAsyncBackend(rec, AsyncConfig{QueueSize: 1, Overflow: OverflowPolicy{Mode: OverflowDropNewest}})
+	l := NewLogger(INFO, async)
+	if h := l.Health(); !h.Healthy || h.Queued != 0 {
+		t.Fatalf("unexpected health %s", h)
+	}
+
+	// the writes are blocked, one is being written, one is queued and the others are dropped
+	for i := 0; i < 4; i++ {
+		l.Info("stuck")
+		time.Sleep(time.Millisecond)
+	}
+	SetStallTimeout(20 * time.Millisecond)
+	defer SetStallTimeout(30 * time.Second)
+	time.Sleep(50 * time.Millisecond)
+	h := l.Health()
+	if h.Healthy || h.Queued != 2 || h.Dropped != 2 || len(h.Stalled) != 1 || h.Stalled[0] != "async" {
+		t.Fatalf("unexpected health %s", h)
+	}
+
+	beats := &recordingBackend{}
+	stop := l.StartHeartbeat(5*time.Millisecond, beats)
+	time.Sleep(20 * time.Millisecond)
+	close(rec.block)
+	async.Flush(context.Background())
+	if !l.IsHealthy() {
+		t.Fatalf("expect healthy after the writes resumed, got %s", l.Health())
+	}
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	stop()
+	beats.mu.Lock()
+	defer beats.mu.Unlock()
+	if len(beats.logs) < 2 || !strings.Contains(beats.logs[0], "WARNING") || !strings.Contains(beats.logs[0], "stalled=[async]") ||
+		!strings.Contains(beats.logs[len(beats.logs)-1], "dlog heartbeat: healthy=true queued=0 dropped=2") {
+		t.Fatalf("unexpected heartbeats %q", beats.logs)
+	}
+
+	// the files are stuck if not flushed
+	fb, _ := NewFileBackendFS("/logs", NewMemFS())
+	if !NewLogger(INFO, fb).IsHealthy() {
+		t.Fatal("expect the files healthy")
+	}
+	atomic.StoreInt64(&fb.lastFlush, time.Now().Add(-time.Second).UnixNano())
+	if h := NewLogger(INFO, fb).Health(); h.Healthy || h.Stalled[0] != "file /logs" {
+		t.Fatalf("unexpected health %s", h)
+	}
+}
+
+func TestSyslog5424Backend(t *testing.T) {
+	const line = "2016-07-11 14:30:10.000000 ERROR dlog/dlog_test.go:1 failed\n"
+	hostname, _ := os.Hostname()
+
+	udp, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	defer udp.Close()
+	b, err := newOutput("error+:syslog://"+udp.LocalAddr().String()+"?facility=local3&tag=app", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Log(ERROR, []byte(line))
+	buf := make([]byte, 1024)
+	udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := udp.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// local3 * 8 + err
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<155>1 ") || !strings.HasSuffix(msg, fmt.Sprintf(" %s app %d - - ERROR dlog/dlog_test.go:1 failed", hostname, os.Getpid())) {
+		t.Fatalf("unexpected syslog message %q", msg)
+	}
+	b.close()
+
+	tcp, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer tcp.Close()
+	sb, err := NewSyslog5424Backend("syslog://"+tcp.Addr().String()+"?proto=tcp", NetworkConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb.Log(INFO, []byte(line))
+	conn, err := tcp.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _ = conn.Read(buf)
+	size, framed, _ := strings.Cut(string(buf[:n]), " ")
+	if size != fmt.Sprint(len(framed)) || !strings.HasPrefix(framed, "<134>1 ") {
+		t.Fatalf("unexpected framed message %q", buf[:n])
+	}
+	sb.close()
+
+	for _, addr := range []string{"syslog://host?proto=ftp", "syslog://?facility=nope", "journald://host"} {
+		if _, err := newOutput(addr, nil); err == nil {
+			t.Fatalf("expect %s rejected", addr)
+		}
+	}
+}
+
+func TestJournaldBackend(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "dlog-journald")
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "journal.sock")
+	conn, err := net.ListenPacket("unixgram", socket)
+	if err != nil {
+		t.Skipf("no unixgram: %s", err)
+	}
+	defer conn.Close()
+	jb, err := NewJournaldBackend("journald://"+socket+"?tag=app", NetworkConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jb.close()
+	buf := make([]byte, 1024)
+	for _, c := range []struct {
+		s        Severity
+		line     string
+		expected string
+	}{
+		{WARNING, "2016-07-11 14:30:10.000000 WARNING x.go:1 slow\n", "PRIORITY=4\nSYSLOG_IDENTIFIER=app\nCODE_FILE=x.go\nCODE_LINE=1\nMESSAGE=WARNING x.go:1 slow\n"},
+		{INFO, "2016-07-11 14:30:10.000000 INFO x.go:1 a\nb\n", "PRIORITY=6\nSYSLOG_IDENTIFIER=app\nCODE_FILE=x.go\nCODE_LINE=1\nMESSAGE\n\x0f\x00\x00\x00\x00\x00\x00\x00INFO x.go:1 a\nb\n"},
+		{ERROR, "2016-07-11 14:30:10.000000 ERROR api/order.go:42 tid=[3f2a] http.method=[GET] _pid=[1] priority=[0] failed\n",
+			"PRIORITY=3\nSYSLOG_IDENTIFIER=app\nCODE_FILE=api/order.go\nCODE_LINE=42\nTID=3f2a\nHTTP_METHOD=GET\n" +
+				"MESSAGE=ERROR api/order.go:42 tid=[3f2a] http.method=[GET] _pid=[1] priority=[0] failed\n"},
+	} {
+		jb.Log(c.s, []byte(c.line))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != c.expected {
+			t.Fatalf("expect %q, got %q", c.expected, buf[:n])
+		}
+	}
+}
+
+func TestLokiBackend(t *testing.T) {
+	var mu sync.Mutex
+	var pushes []string
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if calls++; calls == 1 {
+			http.Error(w, "ingester not ready", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/loki/api/v1/push" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected push %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		pushes = append(pushes, string(data))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	m, err := NewOutputsBackend("loki+" + server.URL + "?module=orders&fields=event,http.method&linger=1ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.backends()[0].(*asyncBackend).backend.(*lokiSink).cfg.Backoff = time.Millisecond
+	m.Log(INFO, []byte("2016-07-11 14:30:10.000000 INFO x.go:1 event=[order] http.method=[GET] created\n"))
+	m.Log(ERROR, []byte("2016-07-11 14:30:10.000001 ERROR x.go:2 failed\n"))
+	m.sync(context.Background())
+	m.close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 || len(pushes) != 1 {
+		t.Fatalf("expect a retry and a push, got %d calls %q", calls, pushes)
+	}
+	var push struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal([]byte(pushes[0]), &push); err != nil || len(push.Streams) != 2 {
+		t.Fatalf("unexpected push %s: %v", pushes[0], err)
+	}
+	info, errs := push.Streams[0], push.Streams[1]
+	if info.Stream["module"] != "orders" || info.Stream["level"] != "info" || info.Stream["event"] != "order" ||
+		info.Stream["http_method"] != "GET" || info.Stream["host"] == "" {
+		t.Fatalf("unexpected labels %v", info.Stream)
+	}
+	if _, ok := errs.Stream["event"]; ok || errs.Stream["level"] != "error" {
+		t.Fatalf("unexpected labels %v", errs.Stream)
+	}
+	ts := time.Date(2016, 7, 11, 14, 30, 10, 0, time.Local).UnixNano()
+	if info.Values[0] != [2]string{fmt.Sprint(ts), "INFO x.go:1 event=[order] http.method=[GET] created"} {
+		t.Fatalf("unexpected values %q", info.Values)
+	}
+
+	for _, output := range []string{"loki+http://", "loki+http://loki?batch=x", "loki+http://loki?format=xml"} {
+		if _, err := NewOutputsBackend(output); err == nil {
+			t.Fatalf("expect %s rejected", output)
+		}
+	}
+
+	// the json lines, and the batches split by the bytes
+	var lines []string
+	jsonServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var push struct {
+			Streams []struct {
+				Values [][2]string `json:"values"`
+			} `json:"streams"`
+		}
+		json.NewDecoder(r.Body).Decode(&push)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, stream := range push.Streams {
+			for _, v := range stream.Values {
+				lines = append(lines, v[1])
+			}
+		}
+		pushes = append(pushes, "")
+	}))
+	defer jsonServer.Close()
+	mu.Unlock()
+	lb, err := NewLokiBackend(LokiConfig{URL: jsonServer.URL + "/loki/api/v1/push", Format: "json", MaxBatchBytes: 100, Linger: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		lb.Log(INFO, []byte(fmt.Sprintf("2016-07-11 14:30:10.000000 INFO x.go:1 event=[order] created %d\n", i)))
+	}
+	lb.sync(context.Background())
+	lb.close()
+	mu.Lock()
+	if len(pushes) != 1+3 || len(lines) != 3 || !strings.HasPrefix(lines[0], `{"time":`) || !strings.HasSuffix(lines[0], `"event":"order","_msg":"created 0"}`) {
+		t.Fatalf("unexpected %d pushes of %q", len(pushes)-1, lines)
+	}
+}
+
+func TestDetectContainer(t *testing.T) {
+	root, _ := ioutil.TempDir("", "dlog-container")
+	defer os.RemoveAll(root)
+	write := func(name, content string) {
+		os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755)
+		ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644)
+	}
+	id := strings.Repeat("0123456789abcdef", 4)
+	write("proc/self/cgroup", "0::/kubepods.slice/kubepods-burstable.slice/cri-containerd-"+id+".scope\n")
+	write("sys/fs/cgroup/memory.max", "268435456\n")
+	if c := detectContainer(root); c.Runtime != "containerd" || c.ID != id || c.ShortID() != id[:12] || c.MemoryLimit != 256<<20 {
+		t.Fatalf("unexpected %+v", c)
+	}
+
+	write("proc/self/cgroup", "0::/\n")
+	write("proc/self/mountinfo", "1 2 8:1 /var/lib/docker/containers/"+id+"/hostname /etc/hostname rw - ext4 /dev/sda1 rw\n")
+	write("sys/fs/cgroup/memory.max", "max\n")
+	write(".dockerenv", "")
+	if c := detectContainer(root); c.Runtime != "docker" || c.ID != id || c.MemoryLimit != 0 {
+		t.Fatalf("unexpected %+v", c)
+	}
+
+	for limit, expected := range map[int64]int{0: 0, 16 << 20: 1024, 256 << 20: 8192, 64 << 30: 65536} {
+		if n := queueSizeOf(limit); n != expected {
+			t.Fatalf("expect the queue of %d for %d, got %d", expected, limit, n)
+		}
+	}
+
+	blocker := filepath.Join(root, "blocker")
+	write("blocker", "")
+	container := Container{Runtime: "docker", MemoryLimit: 256 << 20}
+	config := autoConfig(LogConfig{Type: "auto", FileName: filepath.Join(blocker, "log")}, container)
+	if config.Type != "outputs" || strings.Join(config.Outputs, ",") != "stdout" || config.AsyncQueueSize != 8192 {
+		t.Fatalf("expect stdout in the container, got %+v", config)
+	}
+	if config = autoConfig(LogConfig{Type: "auto", FileName: filepath.Join(root, "log")}, container); config.Type != "file" {
+		t.Fatalf("expect the writable dir, got %+v", config)
+	}
+	if config = autoConfig(LogConfig{Type: "auto", FileName: filepath.Join(blocker, "log")}, Container{}); config.Type != "file" {
+		t.Fatalf("expect the file out of containers, got %+v", config)
+	}
+}
+
+func TestFailoverBackend(t *testing.T) {
+	primary := &recordingBackend{block: make(chan struct{})}
+	fallback := &recordingBackend{}
+	var back int32
+	fb := NewFailoverBackend(NewAsyncBackend(primary, AsyncConfig{}), fallback, FailoverConfig{
+		Timeout:  20 * time.Millisecond,
+		Interval: 5 * time.Millisecond,
+		Probe: func(ctx context.Context) error {
+			if atomic.LoadInt32(&back) == 0 {
+				return fmt.Errorf("still down")
+			}
+			return nil
+		},
+	})
+	defer fb.close()
+	wait := func(failed bool) {
+		for i := 0; i < 200 && fb.FailedOver() != failed; i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if fb.FailedOver() != failed {
+			t.Fatalf("expect failed over %t", failed)
+		}
+	}
+
+	fb.Log(INFO, []byte("stuck\n"))
+	wait(true)
+	fb.Log(INFO, []byte("fallen back\n"))
+	close(primary.block)
+	time.Sleep(50 * time.Millisecond)
+	if !fb.FailedOver() {
+		t.Fatal("expect the fallback until the probe succeeds")
+	}
+	atomic.StoreInt32(&back, 1)
+	wait(false)
+	fb.Log(INFO, []byte("recovered\n"))
+	fb.sync(context.Background())
+
+	if strings.Join(primary.logs, "") != "stuck\nrecovered\n" || strings.Join(fallback.logs, "") != "fallen back\n" {
+		t.Fatalf("unexpected primary %q, fallback %q", primary.logs, fallback.logs)
+	}
+}
+
+func TestJSONBackend(t *testing.T) {
+	rec := &recordingBackend{}
+	jb := NewJSONBackend(rec, JSONConfig{})
+	ts := time.Date(2016, 7, 11, 14, 30, 10, 0, time.Local).Format(jsonTimeLayout)
+	for _, c := range []struct {
+		s        Severity
+		line     string
+		expected string
+	}{
+		{INFO, "2016-07-11 14:30:10.000000 INFO api/order.go:42 tname=[create] tid=[3f2a] event=[order] created 2 items\n",
+			`{"time":"` + ts + `","level":"INFO","caller":"api/order.go:42","tname":"create","tid":"3f2a","event":"order","_msg":"created 2 items"}`},
+		{ERROR, "2016-07-11 14:30:10.000000 ERROR x.go:1 sql.query=[select * from t where a = ?] level=[x] a=[1] a=[2] \"bad\"\tcode\x01 \xff\n",
+			`{"time":"` + ts + `","level":"ERROR","caller":"x.go:1","sql.query":"select * from t where a = ?","a":"1","_msg":"level=[x] a=[2] \"bad\"\tcode\u0001 \ufffd"}`},
+		{INFO, "a plain line\nof two lines\n", `{"level":"INFO","_msg":"a plain line\nof two lines"}`},
+	} {
+		jb.Log(c.s, []byte(c.line))
+		got := rec.logs[len(rec.logs)-1]
+		if got != c.expected+"\n" {
+			t.Fatalf("expect %s, got %s", c.expected, got)
+		}
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(got), &v); err != nil {
+			t.Fatalf("invalid json %s: %s", got, err)
+		}
+	}
+
+	jb = NewJSONBackend(rec, JSONConfig{TimeKey: "@timestamp", MessageKey: "message", TagsKey: "tags", SortFields: true})
+	jb.Log(INFO, []byte("2016-07-11 14:30:10.000000 INFO x.go:1 tid=[3f2a] event=[order] message=[m] done\n"))
+	if got := rec.logs[len(rec.logs)-1]; got != `{"@timestamp":"`+ts+`","level":"INFO","caller":"x.go:1","tags":{"event":"order","message":"m","tid":"3f2a"},"message":"done"}`+"\n" {
+		t.Fatalf("unexpected %s", got)
+	}
+
+	if _, err := NewLoggerWithConfig(LogConfig{Type: "outputs", Outputs: []string{"stdout"}, Format: "xml"}); err == nil {
+		t.Fatal("expect the unknown format rejected")
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	cfg, err := parseEventLogOutput("eventlog://orders?id=1000&install=true")
+	if err != nil || cfg.source != "orders" || cfg.eventID != 1000 || !cfg.install {
+		t.Fatalf("unexpected %+v: %v", cfg, err)
+	}
+	if cfg, err = parseEventLogOutput("eventlog://"); err != nil || cfg.source == "" || cfg.eventID != 1 {
+		t.Fatalf("unexpected %+v: %v", cfg, err)
+	}
+	for _, addr := range []string{"eventlog://orders?id=0", "eventlog://orders/x", "eventlog://orders?install=x"} {
+		if _, err := parseEventLogOutput(addr); err == nil {
+			t.Fatalf("expect %s rejected", addr)
+		}
+	}
+	msg := eventMessage([]byte("2016-07-11 14:30:10.000000 ERROR x.go:1 tname=[create] tid=[3f2a] failed\nretry\n"))
+	if msg != "tid: 3f2a\r\nERROR x.go:1 tname=[create] tid=[3f2a] failed\r\nretry" {
+		t.Fatalf("unexpected %q", msg)
+	}
+	if _, err := NewOutputsBackend("error+:eventlog://orders"); (err == nil) != (runtime.GOOS == "windows") {
+		t.Fatalf("unexpected %v on %s", err, runtime.GOOS)
+	}
+}
+
+func TestFluentBackend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan []interface{}, 2)
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			msg, err := decodeMsgpack(bufio.NewReader(conn))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			received <- msg.([]interface{})
+			// the first one is not acknowledged, and sent again
+			if i > 0 {
+				chunk := msg.([]interface{})[2].(map[string]interface{})["chunk"].(string)
+				conn.Write(appendMsgpackString(appendMsgpackString(appendMsgpackMap(nil, 1), "ack"), chunk))
+			}
+			conn.Close()
+		}
+	}()
+
+	fb, err := NewOutputsBackend("fluent://" + l.Addr().String() + "?tag=app.orders&ack=true&linger=1ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb.Log(INFO, []byte("2016-07-11 14:30:10.000000 INFO api/order.go:42 tid=[3f2a] level=[x] created\n"))
+	fb.sync(context.Background())
+	fb.close()
+
+	first, second := <-received, <-received
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("expect the batch sent again, got %v and %v", first, second)
+	}
+	events := second[1].([]interface{})
+	if second[0] != "app.orders" || len(events) != 1 {
+		t.Fatalf("unexpected %v", second)
+	}
+	event := events[0].([]interface{})
+	if ts := event[0].(time.Time); !ts.Equal(time.Date(2016, 7, 11, 14, 30, 10, 0, time.Local)) {
+		t.Fatalf("unexpected time %s", ts)
+	}
+	if record := event[1].(map[string]interface{}); !reflect.DeepEqual(record, map[string]interface{}{
+		"level": "info", "caller": "api/order.go:42", "tid": "3f2a", "message": "tid=[3f2a] level=[x] created",
+	}) {
+		t.Fatalf("unexpected record %v", record)
+	}
+
+	for _, output := range []string{"fluent://host:24224", "fluent+unix://?tag=x", "fluent://host?tag=x&ack=x"} {
+		if _, err := NewOutputsBackend(output); err == nil {
+			t.Fatalf("expect %s rejected", output)
+		}
+	}
+}
+
+func TestRedactBackend(t *testing.T) {
+	rec := &recordingBackend{}
+	rb := NewRedactBackend(rec, RedactConfig{Keys: append([]string{"user.email"}, DefaultRedactKeys...)})
+	for line, expected := range map[string]string{
+		"2016-07-11 14:30:10.000000 INFO x.go:1 tid=[3f2a] Password=[p w] user.email=[a@b.c] email=[x@y.z] done\n": "2016-07-11 14:30:10.000000 INFO x.go:1 tid=[3f2a] Password=[***] user.email=[***] email=[x@y.z] done\n",
+		`INFO x.go:1 req=[{"user":{"name":"n","phone":"123"},"items":[{"token":"t"}]}] plain text` + "\n":          `INFO x.go:1 req=[{"items":[{"token":"***"}],"user":{"name":"n","phone":"***"}}] plain text` + "\n",
+		"INFO x.go:1 nothing  sensitive auth.token=[t]":                                                            "INFO x.go:1 nothing  sensitive auth.token=[***]",
+		"INFO x.go:1 id_card=[110101]\n":                                                                           "INFO x.go:1 id_card=[***]\n",
+	} {
+		rb.Log(INFO, []byte(line))
+		if got := rec.logs[len(rec.logs)-1]; got != expected {
+			t.Fatalf("expect %q, got %q", expected, got)
+		}
+	}
+
+	cards := regexp.MustCompile(`\b\d{16}\b`)
+	rb = NewRedactBackend(rec, RedactConfig{Mask: "<masked>", Sanitize: func(key, value string) string {
+		if key == "card" {
+			return value[:4] + "..."
+		}
+		return cards.ReplaceAllString(value, "<card>")
+	}})
+	rb.Log(INFO, []byte("INFO x.go:1 card=[4111111111111111] secret=[s] paid by 5500000000000004\n"))
+	if got := rec.logs[len(rec.logs)-1]; got != "INFO x.go:1 card=[4111...] secret=[<masked>] paid by <card>\n" {
+		t.Fatalf("unexpected %q", got)
+	}
+
+	// redacted before the json format
+	dir, _ := ioutil.TempDir("", "dlog-redact")
+	defer os.RemoveAll(dir)
+	l, err := NewLoggerWithConfig(LogConfig{Type: "file", Level: "INFO", FileName: dir, Format: "json", Redact: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("login token=[abc]")
+	l.Sync(context.Background())
+	defer l.Close()
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "INFO.log")); !strings.Contains(string(data), `"token":"***"`) {
+		t.Fatalf("expect the token masked, got %s", data)
+	}
+}
+
+func TestSentryBackend(t *testing.T) {
+	var mu sync.Mutex
+	var events []map[string]interface{}
+	var failing int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/42/store/" || !strings.Contains(r.Header.Get("X-Sentry-Auth"), "sentry_key=public") {
+			t.Errorf("unexpected request %s %v", r.URL, r.Header)
+		}
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var event map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&event)
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	be, err := NewOutputsBackend("sentry+" + strings.Replace(srv.URL, "//", "//public@", 1) + "/42?env=prod&release=v1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb := be.backends()[0].(*sentryBackend)
+	sb.cfg.Failures, sb.cfg.Cooldown = 2, time.Hour
+	be.Log(INFO, []byte("2016-07-11 14:30:10.000000 INFO api/order.go:42 tid=[3f2a] created\n"))
+	be.Log(ERROR, []byte("2016-07-11 14:30:10.000000 ERROR api/order.go:43 tid=[3f2a] uid=[1001] create order: timeout\n"))
+	be.Log(WARNING, []byte("2016-07-11 14:30:10.000000 WARNING dtrace/handle_crash.go:20 panic: nil map, detail: goroutine 1\n"))
+	if err := sb.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expect the error and the panic sent, got %v", events)
+	}
+	e := events[0]
+	if e["level"] != "error" || e["environment"] != "prod" || e["release"] != "v1.2.0" || e["culprit"] != "api/order.go:43" ||
+		e["tags"].(map[string]interface{})["trace_id"] != "3f2a" || e["user"].(map[string]interface{})["id"] != "1001" {
+		t.Fatalf("unexpected event %v", e)
+	}
+	exception := e["exception"].(map[string]interface{})["values"].([]interface{})[0].(map[string]interface{})
+	frames := exception["stacktrace"].(map[string]interface{})["frames"].([]interface{})
+	if len(frames) == 0 || strings.HasPrefix(frames[len(frames)-1].(map[string]interface{})["function"].(string), dlogPackage+".") {
+		t.Fatalf("expect the stack of the caller without dlog, got %v", frames)
+	}
+	if events[1]["level"] != "fatal" {
+		t.Fatalf("expect the panic sent as fatal, got %v", events[1])
+	}
+
+	// the breaker opens after the failures in a row, the rest are dropped without sending
+	atomic.StoreInt32(&failing, 1)
+	for i := 0; i < 4; i++ {
+		be.Log(ERROR, []byte("2016-07-11 14:30:10.000000 ERROR api/order.go:43 failed\n"))
+		sb.Flush(context.Background())
+	}
+	if sb.Dropped() != 4 || time.Now().UnixNano() >= atomic.LoadInt64(&sb.openUntil) {
+		t.Fatalf("expect the breaker open with 4 dropped, got %d", sb.Dropped())
+	}
+	be.close()
+
+	if _, err := NewSentryBackend("https://sentry.example.com/42", SentryConfig{}); err == nil {
+		t.Fatal("expect the dsn without a key rejected")
+	}
+}
+
+type notifierFunc func(ctx context.Context, msg *notify.Message) error
+
+func (f notifierFunc) Notify(ctx context.Context, msg *notify.Message) error { return f(ctx, msg) }
+
+func TestAlertBackend(t *testing.T) {
+	var alerts []*notify.Message
+	fc := clock.NewFakeClock(time.Date(2016, 7, 11, 14, 30, 10, 0, time.Local))
+	ab, err := NewAlertBackend(AlertConfig{
+		Notifier: notifierFunc(func(ctx context.Context, msg *notify.Message) error {
+			alerts = append(alerts, msg)
+			return nil
+		}),
+		Rules: []AlertRule{
+			{Name: "db down", Level: "error+", Pattern: regexp.MustCompile(`connect .* refused`), Threshold: 3},
+			{Name: "payment failed", Tag: "event", Value: "pay_failed", Severity: notify.LevelWarning},
+		},
+		Clock: fc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := []byte("2016-07-11 14:30:10.000000 ERROR db/conn.go:12 connect 10.0.0.1:3306 refused\n")
+	ab.Log(ERROR, refused)
+	ab.Log(WARNING, []byte("2016-07-11 14:30:10.000000 WARNING db/conn.go:12 connect 10.0.0.1:3306 refused\n"))
+	ab.Log(ERROR, refused)
+	// the hits out of the window are not counted
+	fc.Advance(2 * time.Minute)
+	ab.Log(ERROR, refused)
+	ab.Log(ERROR, refused)
+	ab.Log(ERROR, refused)
+	// deduplicated in the cooldown, and counted in the next alert
+	ab.Log(ERROR, refused)
+	fc.Advance(11 * time.Minute)
+	for i := 0; i < 3; i++ {
+		ab.Log(ERROR, refused)
+	}
+	ab.Log(INFO, []byte("2016-07-11 14:30:10.000000 INFO pay/pay.go:7 event=[pay_failed] order=[42] declined\n"))
+	ab.Log(INFO, []byte("2016-07-11 14:30:10.000000 INFO pay/pay.go:7 event=[paid] order=[43]\n"))
+	ab.close()
+
+	if len(alerts) != 3 {
+		t.Fatalf("expect 3 alerts, got %d", len(alerts))
+	}
+	if alerts[0].Title != "[dlog] db down" || alerts[0].Level != notify.LevelError || !strings.HasSuffix(alerts[0].Text, string(refused[:len(refused)-1])) {
+		t.Fatalf("unexpected alert %+v", alerts[0])
+	}
+	if alerts[1].Data.(map[string]string)["suppressed"] != "1" {
+		t.Fatalf("expect the log in the cooldown counted, got %+v", alerts[1])
+	}
+	if alerts[2].Title != "[dlog] payment failed" || alerts[2].Level != notify.LevelWarning {
+		t.Fatalf("unexpected alert %+v", alerts[2])
+	}
+
+	if _, err := NewAlertBackend(AlertConfig{Notifier: notifierFunc(nil), Rules: []AlertRule{{Level: "bad"}}}); err == nil {
+		t.Fatal("expect the invalid level rejected")
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	rec := &recordingBackend{}
+	l := NewLogger(INFO, rec)
+	l.SetClock(clock.NewFakeClock(time.Date(2016, 7, 11, 14, 30, 10, 0, time.Local)))
+	l.SetMaxBytes(8, 200)
+	l.Infof("req=[%s] tid=[3f2a] done", strings.Repeat("x", 100))
+	l.Infof("body: %s", strings.Repeat("好", 100))
+	l.SetMaxBytes(0, 0)
+	l.Infof("req=[%s]", strings.Repeat("x", 100))
+
+	if !strings.HasSuffix(rec.logs[0], " req=[xxxxxxxx…(truncated 92 bytes)] tid=[3f2a] done\n") {
+		t.Fatalf("expect the tag truncated, got %q", rec.logs[0])
+	}
+	if len(rec.logs[1]) > 200 || !utf8.ValidString(rec.logs[1]) || !regexp.MustCompile(`好…\(truncated \d+ bytes\)\n$`).MatchString(rec.logs[1]) {
+		t.Fatalf("expect the log truncated at a rune in 200 bytes, got %d bytes %q", len(rec.logs[1]), rec.logs[1])
+	}
+	// the kept and the truncated bytes add up to the message
+	kept, marker := splitOnce(rec.logs[1][strings.Index(rec.logs[1], "body: ")+len("body: "):], "…")
+	if n, _ := strconv.Atoi(strings.Fields(marker)[1]); len(kept)+n != 300 {
+		t.Fatalf("expect %d bytes truncated, got %q", 300-len(kept), marker)
+	}
+	if !strings.Contains(rec.logs[2], strings.Repeat("x", 100)) {
+		t.Fatalf("expect no limit, got %q", rec.logs[2])
+	}
+}
+
+func TestRingBackend(t *testing.T) {
+	rb := NewRingBackend(5)
+	for _, line := range []string{
+		"2016-07-11 14:30:10.000000 INFO api/order.go:42 tname=[other] tid=[9b1c] tduration=[0] skipped",
+		"2016-07-11 14:30:10.010000 INFO api/order.go:42 tname=[create] tid=[3f2a] tduration=[0] received",
+		"2016-07-11 14:30:10.020000 INFO api/order.go:42 no trace",
+		"2016-07-11 14:30:10.030000 INFO db/query.go:12 tname=[query] tid=[3f2a] tancestor=[create] tduration=[15] slow=[true] queried",
+		"2016-07-11 14:30:10.040000 ERROR api/order.go:50 tname=[create] tid=[3f2a] tduration=[30] failed",
+		"2016-07-11 14:30:10.050000 INFO api/order.go:42 tname=[other] tid=[9b1c] tduration=[40] done",
+		"2016-07-11 14:30:10.060000 INFO api/order.go:42 tname=[other] tid=[9b1c] tduration=[50] overwrites the first one",
+	} {
+		s, _ := ParseSeverity(strings.Fields(line)[2])
+		rb.Log(s, []byte(line+"\n"))
+	}
+
+	timeline, ok := rb.Timeline("3f2a")
+	if !ok || timeline.DurationMS != 30 || !timeline.Start.Equal(time.Date(2016, 7, 11, 14, 30, 10, 10000000, time.Local)) {
+		t.Fatalf("unexpected timeline %+v", timeline)
+	}
+	expected := []TimelineSpan{
+		{Name: "create", StartMS: 0, DurationMS: 30, Logs: 2},
+		{Name: "query", Ancestors: []string{"create"}, StartMS: 5, DurationMS: 15, Logs: 1},
+	}
+	if !reflect.DeepEqual(timeline.Spans, expected) {
+		t.Fatalf("expect the spans %+v, got %+v", expected, timeline.Spans)
+	}
+	if l := timeline.Logs[1]; len(timeline.Logs) != 3 || l.OffsetMS != 20 || l.Span != "query" || l.Caller != "db/query.go:12" || l.Message != "slow=[true] queried" {
+		t.Fatalf("unexpected logs %+v", timeline.Logs)
+	}
+	if timeline, _ := rb.Timeline("9b1c"); len(timeline.Logs) != 2 || timeline.Spans[0].StartMS != 0 {
+		t.Fatalf("expect the oldest log overwritten, got %+v", timeline)
+	}
+	if _, ok := rb.Timeline("unknown"); ok {
+		t.Fatal("expect no timeline of the unknown trace")
+	}
+}
+
+func TestSymlinkLatest(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "dlog-latest")
+	defer os.RemoveAll(dir)
+	fb, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fb.SetBackupDir(filepath.Join(dir, "archive")); err != nil {
+		t.Fatal(err)
+	}
+	fb.SetSymlinkLatest(2)
+	fb.Rotate(3, 16)
+	fb.Log(INFO, []byte("first line\n"))
+	fb.Log(INFO, []byte("second line\n"))
+	fb.Log(INFO, []byte("third line\n"))
+
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(filepath.Join(dir, "INFO.log.latest.2")); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for link, expected := range map[string]string{"INFO.log.latest.1": "second line\n", "INFO.log.latest.2": "first line\n"} {
+		if data, _ := ioutil.ReadFile(filepath.Join(dir, link)); string(data) != expected {
+			t.Fatalf("%s: unexpected %q", link, data)
+		}
+	}
+	if target, _ := os.Readlink(filepath.Join(dir, "INFO.log.latest.1")); target != filepath.Join("archive", "INFO.log.001") {
+		t.Fatalf("expect the relative link, got %s", target)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "ERROR.log.latest.1")); !os.IsNotExist(err) {
+		t.Fatal("expect no links without backups")
+	}
+}
+
+func FuzzBackupName(f *testing.F) {
+	for _, name := range []string{"INFO.log.2016071114", "ERROR.log.201607111430.gz", "INFO.log.001", "INFO.log.2016071114_100.zst",
+		"INFO.log.", "INFO.log.2016071114_0", "WARNING.log.20160711143059", "INFO.log.日志", "INFO.log." + strings.Repeat("9", 300)} {
+		f.Add(name)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		b, ok := parseBackupName(name)
+		shouldDel(name, 1, time.Now())
+		if !ok {
+			return
+		}
+		// the names round trip, and the time tags are the times they are formatted from
+		base := b.base()
+		if b.ext != "" {
+			base += "." + b.ext
+		}
+		if base != name {
+			t.Fatalf("%q: round trip to %q", name, base)
+		}
+		if tm, ok := b.time(); ok {
+			layout := hourTagLayout
+			if len(b.tag) == len(scheduleTagLayout) {
+				layout = scheduleTagLayout
+			}
+			if tm.Format(layout) != b.tag {
+				t.Fatalf("%q: unexpected time %s", name, tm)
+			}
+		}
+		info := backupInfo(backupFile{path: filepath.Join("/logs", name)})
+		if info.Name != name || info.Severity != b.severity || info.Compressed != b.ext {
+			t.Fatalf("%q: unexpected info %+v", name, info)
+		}
+	})
+}
+
+func TestUniqueBackupName(t *testing.T) {
+	fs := NewMemFS()
+	fb, err := NewFileBackendFS("/logs", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fb.SetBackupDir("/archive"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/logs/INFO.log.2016071114", "/archive/INFO.log.2016071114_2.gz", "/archive/INFO.log.2016071114_3"} {
+		f, _ := fs.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0644)
+		f.Close()
+	}
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	if name := fb.uniqueBackupName("/logs/INFO.log.2016071114"); name != "/logs/INFO.log.2016071114_4" {
+		t.Fatalf("unexpected unique name %s", name)
+	}
+	if name := fb.uniqueBackupName("/logs/INFO.log.2016071115"); name != "/logs/INFO.log.2016071115" {
+		t.Fatalf("unexpected unique name %s", name)
+	}
+	for i := 4; i <= 120; i++ {
+		f, _ := fs.OpenFile(fmt.Sprintf("/archive/INFO.log.2016071114_%d", i), os.O_CREATE|os.O_WRONLY, 0644)
+		f.Close()
+	}
+	if name := fb.uniqueBackupName("/logs/INFO.log.2016071114"); name != "/logs/INFO.log.2016071114_121" {
+		t.Fatalf("unexpected unique name %s", name)
+	}
+}
+
+func TestConcurrentConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "dlog-config")
+	defer os.RemoveAll(dir)
+	l, err := NewLoggerWithConfig(LogConfig{Type: "file", Level: "INFO", FileName: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb := l.config().backend.(*FileBackend)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			l.SetSeverity([]string{"DEBUG", "INFO"}[i%2])
+			l.SetLogging(INFO, fb)
+			fb.Rotate(3, uint64(1024+i))
+			fb.SetKeepHours(uint(i))
+			fb.SetRotateByHour(i%2 == 0)
+			fb.SetFlushDuration(time.Second)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		l.Infof("line %d", i)
+	}
+	<-done
+	fb.Flush()
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "INFO.log")); !strings.Contains(string(data), "line 99") {
+		t.Fatalf("unexpected log %q", data)
+	}
+
+	if err := Init(LogConfig{Type: "file", Level: "INFO", FileName: filepath.Join(dir, "global")}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetLogging(DEBUG, &stdBackend{})
+	SetKeepHours(3)
+	if fb := getFileBackend(); fb == nil || fb.keepHours != 3 {
+		t.Fatal("expect the package level setters applied to the backend created by Init")
+	}
+}
+
+func TestRecoverArchives(t *testing.T) {
+	fs := NewMemFS()
+	fb, err := NewFileBackendFS("/logs", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		f, _ := fs.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		f.Write([]byte(content))
+		f.Close()
+	}
+	write("/logs/INFO.log.2016071114", "left uncompressed")
+	write("/logs/INFO.log.2016071113.gz.tmp", "truncated")
+	write("/logs/INFO.log.2016071112", "compressed but not removed")
+	write("/logs/INFO.log.2016071112.gz", "complete")
+	write("/logs/INFO.log.2016071111", "being compressed")
+	write("/logs/INFO.log.2016071111.gz.tmp", "by another writer")
+	if err := compressFile(fs, Gzip, nil, "/logs/INFO.log.2016071111", "/logs/INFO.log.2016071111.gz", nil); err != errArchiving {
+		t.Fatalf("expect skipped, got %v", err)
+	}
+
+	// the temp files are stale an hour later
+	fb.SetClock(clock.NewFakeClock(time.Now().Add(time.Hour)))
+	fb.SetCompressor(Gzip)
+	for i := 0; i < 100; i++ {
+		if _, err := fs.Stat("/logs/INFO.log.2016071114.gz"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := fs.Stat("/logs/INFO.log.2016071112"); err != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	infos, _ := fs.ReadDir("/logs")
+	var names []string
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name(), ".log") {
+			names = append(names, info.Name())
+		}
+	}
+	expected := "INFO.log.2016071111.gz INFO.log.2016071112.gz INFO.log.2016071114.gz"
+	if strings.Join(names, " ") != expected {
+		t.Fatalf("unexpected files %v", names)
+	}
+	f, _ := fs.Open("/logs/INFO.log.2016071112.gz")
+	if data, _ := ioutil.ReadAll(f); string(data) != "complete" {
+		t.Fatalf("expect the complete archive kept, got %q", data)
+	}
+}
+
+func TestCompressWrapped(t *testing.T) {
+	fs := NewMemFS()
+	fb, err := NewFileBackendFS("/logs", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan FileEvent, 16)
+	fb.SetObserver(func(ev FileEvent) { events <- ev })
+	fb.Rotate(2, 100)
+	fb.SetCompressor(Gzip)
+	// every 3 lines are rotated, so the backup names are reused twice
+	for i := 0; i < 13; i++ {
+		fb.Log(INFO, []byte(fmt.Sprintf("%029d\n", i)))
+		if i == 0 || i%3 != 0 {
+			continue
+		}
+		for archived := false; !archived; {
+			select {
+			case ev := <-events:
+				if ev.Err != nil {
+					t.Fatalf("unexpected event %+v", ev)
+				}
+				archived = ev.Kind == EventArchive
+			case <-time.After(time.Second):
+				t.Fatalf("timeout waiting for the archive of line %d", i)
+			}
+		}
+	}
+	for name, first := range map[string]int{"INFO.log.000.gz": 6, "INFO.log.001.gz": 9} {
+		f, err := fs.Open("/logs/" + name)
+		if err != nil {
+			t.Fatalf("expect %s kept: %s", name, err)
+		}
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(r)
+		if expected := fmt.Sprintf("%029d\n%029d\n%029d\n", first, first+1, first+2); string(data) != expected {
+			t.Fatalf("unexpected %s %q", name, data)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	fb, err := NewFileBackendFS("/logs", NewMemFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan FileEvent, 16)
+	fb.SetObserver(func(ev FileEvent) { events <- ev })
+	fb.Rotate(2, 100)
+	fb.SetCompressor(Gzip)
+	line := []byte(strings.Repeat("x", 29) + "\n")
+	for i := 0; i < 7; i++ {
+		fb.Log(INFO, line)
+	}
+	kinds := map[FileEventKind]int{}
+	for kinds[EventArchive] < 2 {
+		select {
+		case ev := <-events:
+			if ev.Err != nil {
+				t.Fatalf("unexpected event %+v", ev)
+			}
+			kinds[ev.Kind]++
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for the archives, got %v", kinds)
+		}
+	}
+	if kinds[EventRotate] != 2 {
+		t.Fatalf("expect 2 rotations, got %v", kinds)
+	}
+
+	b := NewBackupBudget(1)
+	b.Attach(fb)
+	if err := b.Enforce(); err != nil {
+		t.Fatal(err)
+	}
+	stats := fb.Stats()
+	stats.ArchiveTime = 0
+	expected := FileStats{BytesWritten: 210, Rotations: 2, BackupsDeleted: 2, Archived: 2}
+	if stats != expected {
+		t.Fatalf("unexpected stats %+v, expected %+v", stats, expected)
+	}
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	for spec, expected := range map[string]*SyncPolicy{
+		"":                          nil,
+		"never":                     {},
+		"rotate":                    {OnRotate: true},
+		"bytes=1024, rotate":        {Bytes: 1024, OnRotate: true},
+		"interval=200ms,bytes=4096": {Bytes: 4096, Interval: 200 * time.Millisecond},
+	} {
+		p, err := ParseSyncPolicy(spec)
+		if err != nil {
+			t.Fatalf("parse %q failed: %s", spec, err)
+		}
+		if (p == nil) != (expected == nil) || (p != nil && *p != *expected) {
+			t.Fatalf("parse %q: expect %+v, got %+v", spec, expected, p)
+		}
+	}
+	for _, spec := range []string{"always", "bytes=0", "bytes=1k", "interval=-1s", "rotate,"} {
+		if _, err := ParseSyncPolicy(spec); err == nil {
+			t.Fatalf("expect error of %q", spec)
+		}
+	}
+}
+
+// syncCountingFS counts the syncs of the files
+type syncCountingFS struct {
+	FS
+	syncs int64
+}
+
+type syncCountingFile struct {
+	File
+	fs *syncCountingFS
+}
+
+func (fs *syncCountingFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := fs.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return syncCountingFile{f, fs}, nil
+}
+
+func (f syncCountingFile) Sync() error {
+	atomic.AddInt64(&f.fs.syncs, 1)
+	return f.File.Sync()
+}
+
+func TestSyncPolicy(t *testing.T) {
+	fs := &syncCountingFS{FS: NewMemFS()}
+	fb, err := NewFileBackendFS("/logs", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(strings.Repeat("x", 29) + "\n")
+
+	fb.SetSyncPolicy(&SyncPolicy{Bytes: 100})
+	for i := 0; i < 7; i++ {
+		fb.Log(INFO, line)
+	}
+	if n := atomic.LoadInt64(&fs.syncs); n != 1 {
+		t.Fatalf("expect 1 sync after 210 bytes, got %d", n)
+	}
+	f, _ := fs.Open("/logs/INFO.log")
+	if data, _ := ioutil.ReadAll(f); len(data) != 120 {
+		t.Fatalf("expect the synced 120 bytes in the file, got %d", len(data))
+	}
+
+	// no sync on rotation unless asked
+	fb.SetSyncPolicy(&SyncPolicy{})
+	fb.Rotate(2, 100)
+	for i := 0; i < 4; i++ {
+		fb.Log(INFO, line)
+	}
+	if n := atomic.LoadInt64(&fs.syncs); n != 1 {
+		t.Fatalf("expect no sync on rotation, got %d", n-1)
+	}
+	fb.SetSyncPolicy(&SyncPolicy{OnRotate: true})
+	for i := 0; i < 3; i++ {
+		fb.Log(INFO, line)
+	}
+	if n := atomic.LoadInt64(&fs.syncs); n != 2 {
+		t.Fatalf("expect 1 sync on rotation, got %d", n-1)
+	}
+
+	fb.SetSyncPolicy(&SyncPolicy{Interval: 20 * time.Millisecond})
+	deadline := time.Now().Add(3 * time.Second)
+	for atomic.LoadInt64(&fs.syncs) < 2+2*numSeverity {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the files synced by the interval, got %d syncs", atomic.LoadInt64(&fs.syncs))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBufferSize(t *testing.T) {
+	fs := NewMemFS()
+	fb, err := NewFileBackendFS("/logs", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := func() int {
+		info, err := fs.Stat("/logs/INFO.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return int(info.Size())
+	}
+	line := []byte(strings.Repeat("x", 29) + "\n")
+	fb.Log(INFO, line)
+	fb.SetBufferSize(64)
+	if n := size(); n != 30 {
+		t.Fatalf("expect the buffered logs written on resizing, got %d bytes", n)
+	}
+	fb.Log(INFO, line)
+	fb.Log(INFO, line)
+	if n := size(); n != 30 {
+		t.Fatalf("expect the logs buffered, got %d bytes", n)
+	}
+	fb.Log(INFO, line)
+	if n := size(); n != 94 {
+		t.Fatalf("expect the full buffer written, got %d bytes", n)
+	}
+	fb.Flush()
+	if n := size(); n != 120 {
+		t.Fatalf("expect all written on flush, got %d bytes", n)
+	}
+}
+
+func TestDirHelpers(t *testing.T) {
+	fs := NewMemFS()
+	fs.MkdirAll("/logs", 0755)
+	write := func(name, content string) {
+		f, _ := fs.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		f.Write([]byte(content))
+		f.Close()
+	}
+	write("/logs/INFO.log", "live info\n")
+	write("/logs/ERROR.log", "")
+	write("/logs/INFO.log.201607111430", "taken\n")
+
+	now := time.Date(2016, 7, 11, 14, 30, 10, 0, time.Local)
+	rotated, err := RotateDir(fs, "/logs", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 1 || rotated[0] != "/logs/INFO.log.201607111430_2" {
+		t.Fatalf("unexpected rotated %v", rotated)
+	}
+	if _, err := fs.Stat("/logs/INFO.log"); !os.IsNotExist(err) {
+		t.Fatalf("expect the live file renamed, got %v", err)
+	}
+	if lives, _ := LiveFiles(fs, "/logs"); len(lives) != 1 || lives[0].Name() != "ERROR.log" {
+		t.Fatalf("unexpected live files %v", lives)
+	}
+
+	f, _ := fs.OpenFile("/logs/INFO.log.2016071013.gz", os.O_CREATE|os.O_WRONLY, 0644)
+	w := gzip.NewWriter(f)
+	w.Write([]byte("old info\n"))
+	w.Close()
+	f.Close()
+	backups, err := ListBackups(fs, "/logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("unexpected backups %v", backups)
+	}
+	expired := ExpiredBackups(backups, 24, now)
+	if len(expired) != 1 || expired[0].Name != "INFO.log.2016071013.gz" {
+		t.Fatalf("unexpected expired %v", expired)
+	}
+	for name, expected := range map[string]string{
+		"/logs/INFO.log.2016071013.gz":  "old info\n",
+		"/logs/INFO.log.201607111430_2": "live info\n",
+	} {
+		r, err := OpenDecompressed(fs, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := ioutil.ReadAll(r); string(data) != expected {
+			t.Fatalf("unexpected content of %s: %q", name, data)
+		}
+		r.Close()
+	}
+}
+
+func TestPurge(t *testing.T) {
+	fs := NewMemFS()
+	fs.MkdirAll("/logs", 0755)
+	fs.MkdirAll("/archive", 0755)
+	write := func(name string, c Compressor, content string) {
+		f, _ := fs.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		var w io.WriteCloser = f
+		if c != nil {
+			w, _ = c.NewWriter(f)
+		}
+		w.Write([]byte(content))
+		w.Close()
+		f.Close()
+	}
+	write("/logs/INFO.log", nil, "live u42\n")
+	write("/logs/INFO.log.2016071114", nil, "a u42 login\nb u7 login\nc u42 logout")
+	write("/archive/INFO.log.2016071013.gz", Gzip, "d u42\ne u7\n")
+	write("/archive/INFO.log.2016071012.zst", Zstd, "f u7\n")
+	fb, err := NewFileBackendFS("/logs", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not archiving the backups in the log dir like SetBackupDir
+	fb.backupDir = "/archive"
+
+	record, err := fb.Purge(PurgeConfig{Match: MatchSubject("u42"), Reason: "req-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Files) != 2 || record.Files[0] != (PurgedFile{"/logs/INFO.log.2016071114", 2}) ||
+		record.Files[1] != (PurgedFile{"/archive/INFO.log.2016071013.gz", 1}) {
+		t.Fatalf("unexpected purged files %+v", record.Files)
+	}
+	read := func(name string) string {
+		r, err := OpenDecompressed(fs, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		data, _ := ioutil.ReadAll(r)
+		return string(data)
+	}
+	for name, expected := range map[string]string{
+		"/logs/INFO.log":                   "live u42\n",
+		"/logs/INFO.log.2016071114":        "b u7 login\n",
+		"/archive/INFO.log.2016071013.gz":  "e u7\n",
+		"/archive/INFO.log.2016071012.zst": "f u7\n",
+	} {
+		if content := read(name); content != expected {
+			t.Fatalf("unexpected content of %s: %q", name, content)
+		}
+	}
+	audit := read("/logs/" + purgeAuditFile)
+	if !strings.Contains(audit, `"reason":"req-1"`) || !strings.Contains(audit, `"lines":2`) || strings.Contains(audit, "u42") {
+		t.Fatalf("unexpected audit %q", audit)
+	}
+	if backups, _ := fb.Backups(); len(backups) != 3 {
+		t.Fatalf("expect no temp files left, got %v", backups)
+	}
+
+	var buf bytes.Buffer
+	record, err = PurgeFiles(fs, PurgeConfig{Match: MatchSubject("u7"), Redact: RedactSubject("u7", "***"), Audit: &buf}, nil, "/archive")
+	if err != nil || len(record.Files) != 2 || !record.Redacted {
+		t.Fatalf("unexpected purge %+v %v", record, err)
+	}
+	if content := read("/archive/INFO.log.2016071013.gz"); content != "e ***\n" {
+		t.Fatalf("unexpected redacted content %q", content)
+	}
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Fatalf("unexpected audit %q", buf.String())
+	}
+	if _, err := PurgeFiles(fs, PurgeConfig{}, nil, "/archive"); err == nil {
+		t.Fatal("expect the purge without a matcher rejected")
+	}
+}
+
+func TestMillThrottle(t *testing.T) {
+	fs := NewMemFS()
+	fs.MkdirAll("/logs", 0755)
+	f, _ := fs.OpenFile("/logs/INFO.log.001", os.O_CREATE|os.O_WRONLY, 0644)
+	f.Write(bytes.Repeat([]byte("0123456789abcdef"), 16*1024))
+	f.Close()
+
+	// 256KB at 1MB/s
+	start := time.Now()
+	if err := compressFile(fs, Gzip, nil, "/logs/INFO.log.001", "/logs/INFO.log.001.gz", NewMillThrottle(0, 1<<20, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Fatalf("expect the compression paced, took %s", d)
+	}
+	r, err := OpenDecompressed(fs, "/logs/INFO.log.001.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(r); len(data) != 256*1024 {
+		t.Fatalf("unexpected size %d", len(data))
+	}
+
+	throttle := NewMillThrottle(1, 0, 0)
+	throttle.acquire()
+	acquired := make(chan struct{})
+	go func() {
+		throttle.acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expect at most 1 archiving at once")
+	case <-time.After(50 * time.Millisecond):
+	}
+	throttle.release()
+	<-acquired
+	throttle.release()
+
+	if sharedMillThrottle(2, 0, 0.5) != sharedMillThrottle(2, 0, 0.5) || sharedMillThrottle(0, 0, 0) != nil {
+		t.Fatal("expect the throttles of the same limits shared")
+	}
+}
+
+func TestWatchFiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "dlog-watch")
+	defer os.RemoveAll(dir)
+	fb, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor := func(cond func() bool) bool {
+		for i := 0; i < 100; i++ {
+			if cond() {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+	if !waitFor(func() bool { return atomic.LoadInt32(&fb.watched) == 1 }) {
+		t.Skip("fsnotify is not supported")
+	}
+
+	// reopened at once, the polling takes 5 seconds
+	live := filepath.Join(dir, "INFO.log")
+	os.Rename(live, live+".moved")
+	if !waitFor(func() bool { _, err := os.Stat(live); return err == nil }) {
+		t.Fatal("expect the moved file reopened")
+	}
+	fb.Log(INFO, []byte("after\n"))
+	fb.Flush()
+	if data, _ := ioutil.ReadFile(live); string(data) != "after\n" {
+		t.Fatalf("unexpected %s: %q", live, data)
+	}
+	os.Remove(live)
+	if !waitFor(func() bool { _, err := os.Stat(live); return err == nil }) {
+		t.Fatal("expect the removed file reopened")
+	}
+
+	// the in-memory files are polled
+	mem, _ := NewFileBackendFS("/logs", NewMemFS())
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&mem.watched) != 0 {
+		t.Fatal("expect the in-memory fs not watched")
+	}
+}
+
+func TestBinaryBackend(t *testing.T) {
+	rec := &recordingBackend{}
+	bb := NewBinaryBackend(rec)
+	lines := []string{
+		"2016-07-11 14:30:10.123456 INFO api/order.go:42 tname=[create] tid=[3f2a] event=[order] created 2 items\n",
+		"2016-07-11 14:30:10.000000 ERROR x.go:1 err=[" + strings.Repeat("x", 300) + "]\n",
+		"no header event=[plain]\n",
+	}
+	var data []byte
+	for i, line := range lines {
+		bb.Log([]Severity{INFO, ERROR, INFO}[i], []byte(line))
+		data = append(data, rec.logs[len(rec.logs)-1]...)
+	}
+	if len(rec.logs[0]) >= len(lines[0]) {
+		t.Fatalf("expect the record shorter than the line, got %d bytes", len(rec.logs[0]))
+	}
+
+	d := NewBinaryDecoder(bytes.NewReader(data))
+	for i, expected := range []string{
+		lines[0],
+		lines[1],
+		"event=[plain] no header\n",
+	} {
+		r, err := d.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(r.Text()); got != expected {
+			t.Fatalf("#%d: expect %q, got %q", i, expected, got)
+		}
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Fatalf("expect EOF, got %v", err)
+	}
+	d = NewBinaryDecoder(bytes.NewReader(data[:len(data)-1]))
+	d.Next()
+	d.Next()
+	if _, err := d.Next(); err != ErrBinaryCorrupted {
+		t.Fatalf("expect the truncated record corrupted, got %v", err)
+	}
+
+	// the records over the max size are truncated, or dropped if the tags are over it
+	huge := append([]byte("2016-07-11 14:30:10.000000 INFO x.go:1 tid=[3f2a] "), bytes.Repeat([]byte("x"), maxBinaryRecord)...)
+	bb.Log(INFO, huge)
+	if r, err := NewBinaryDecoder(strings.NewReader(rec.logs[len(rec.logs)-1])).Next(); err != nil || bb.Truncated() != 1 || len(r.Message) >= maxBinaryRecord {
+		t.Fatalf("expect the huge record truncated and decoded, got %d bytes, %v", len(r.Message), err)
+	}
+	if b, cut, ok := appendBinaryRecord(nil, INFO, []byte("k=[v] 日志"), 12); !ok || cut != 3 {
+		t.Fatalf("expect the partial rune cut, got %q, %d", b, cut)
+	}
+	if b, _, ok := appendBinaryRecord([]byte("prev"), INFO, []byte("key=[value] message"), 8); ok || string(b) != "prev" {
+		t.Fatalf("expect the record of the long tags dropped, got %q", b)
+	}
+	n := len(rec.logs)
+	bb.Log(INFO, append([]byte("err=["), append(bytes.Repeat([]byte("x"), maxBinaryRecord), ']')...))
+	if len(rec.logs) != n || bb.Dropped() != 1 {
+		t.Fatalf("expect the record dropped, %d dropped", bb.Dropped())
+	}
+
+	dir, _ := ioutil.TempDir("", "dlog-fcbin")
+	defer os.RemoveAll(dir)
+	l, err := NewLoggerWithConfig(LogConfig{Type: "file", Level: "INFO", FileName: dir, Format: "fcbin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("tid=[3f2a] done")
+	l.Sync(context.Background())
+	defer l.Close()
+	f, _ := os.Open(filepath.Join(dir, "INFO.log"))
+	defer f.Close()
+	r, err := NewBinaryDecoder(f).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if json := string(r.JSON(JSONConfig{})); r.Severity != INFO || !strings.Contains(json, `"tid":"3f2a","_msg":"done"}`) || !strings.Contains(json, `"caller":"dlog/dlog_test.go:`) {
+		t.Fatalf("unexpected record %+v", r)
+	}
+
+	// the logger encodes the entries without the text header, the same as the lines parsed back
+	rec = &recordingBackend{}
+	start := time.Now()
+	NewLogger(INFO, NewBinaryBackend(rec)).Infof("tid=[%s] created %d items", "3f2a", 2)
+	_, _, line, _ := runtime.Caller(0)
+	r, err = NewBinaryDecoder(strings.NewReader(rec.logs[0])).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Caller != fmt.Sprintf("dlog/dlog_test.go:%d", line-1) || r.Time.Before(start) || r.Time.After(time.Now()) ||
+		len(r.Tags) != 1 || r.Tags[0] != [2]string{"tid", "3f2a"} || r.Message != "created 2 items" {
+		t.Fatalf("unexpected record of the entry %+v", r)
+	}
+}
+
+func TestSampleBackend(t *testing.T) {
+	rec := &recordingBackend{}
+	sb := NewSampleBackend(rec, SampleConfig{
+		Rates:   map[string]float64{"poll": 0.1, "health": 0},
+		Related: map[string]string{"poll-done": "poll"},
+	})
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		tid := fmt.Sprintf("tname=[worker] tid=[%d] tduration=[0]", i)
+		before := len(rec.logs)
+		sb.Log(INFO, []byte("2016-07-11 14:30:10.000000 INFO x.go:1 "+tid+" event=[poll] queue=[orders]\n"))
+		sb.Log(INFO, []byte("2016-07-11 14:30:10.000000 INFO x.go:1 "+tid+" event=[poll-done] jobs=[0]\n"))
+		switch len(rec.logs) - before {
+		case 2:
+			kept++
+		case 0:
+		default:
+			t.Fatalf("expect the poll and the poll-done of %s sampled together", tid)
+		}
+	}
+	if kept < 50 || kept > 150 {
+		t.Fatalf("expect about 100 polls kept, got %d", kept)
+	}
+	if sb.Sampled() != int64(2*(1000-kept)) {
+		t.Fatalf("unexpected sampled %d, kept %d", sb.Sampled(), kept)
+	}
+
+	n := len(rec.logs)
+	sb.Log(INFO, []byte("2016-07-11 14:30:10.000000 INFO x.go:1 event=[health]\n"))
+	sb.Log(ERROR, []byte("2016-07-11 14:30:10.000000 ERROR x.go:1 event=[health] failed\n"))
+	sb.Log(INFO, []byte("2016-07-11 14:30:10.000000 INFO x.go:1 event=[other]\n"))
+	sb.Log(INFO, []byte("2016-07-11 14:30:10.000000 INFO x.go:1 no tags\n"))
+	if len(rec.logs)-n != 3 || strings.Contains(strings.Join(rec.logs[n:], ""), "INFO x.go:1 event=[health]") {
+		t.Fatalf("expect the errors and the other logs kept, got %q", rec.logs[n:])
+	}
+}
+
+func TestCaller(t *testing.T) {
+	rec := &recordingBackend{}
+	l := NewLogger(INFO, rec)
+	prev := logging.config()
+	SetLogging(INFO, rec)
+	defer SetLogging(prev.s, prev.backend)
+	// the depth 0 is the caller of the function calling LogDepth
+	wrapper := func(msg string) {
+		l.LogDepthf(INFO, 0, "%s", msg)
+	}
+	nested := func(msg string) {
+		func() { l.LogDepth(WARNING, 1, msg) }()
+	}
+
+	var lines []int
+	line := func() {
+		_, _, n, _ := runtime.Caller(1)
+		lines = append(lines, n+1)
+	}
+	// twice for the cached callers
+	for i := 0; i < 2; i++ {
+		line()
+		l.Info("logger")
+		line()
+		l.Infof("logger %s", "f")
+		line()
+		nested("nested")
+		line()
+		InfoHelperDepth("package %s", "depth")
+		line()
+		Info("package")
+		line()
+		Warningf("package %s", "f")
+		line()
+		wrapper("wrapped")
+		line()
+		wrapper("wrapped again")
+	}
+	if len(rec.logs) != len(lines) {
+		t.Fatalf("expect %d logs, got %d", len(lines), len(rec.logs))
+	}
+	for i, log := range rec.logs {
+		if caller := fmt.Sprintf(" dlog/dlog_test.go:%d ", lines[i]); !strings.Contains(log, caller) {
+			t.Errorf("expect the caller%sin %q", caller, log)
+		}
+	}
+}
+
+// the budgets of the allocations on the logging path, a regression fails the tests
+func TestLogAllocs(t *testing.T) {
+	fb, err := NewFileBackendFS("/logs", NewMemFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := NewLogger(INFO, fb)
+	msg := []byte("hello world\n")
+	for _, tc := range []struct {
+		name string
+		max  float64
+		fn   func()
+	}{
+		{"FileBackend.Log", 0, func() { fb.Log(INFO, msg) }},
+		{"Logger.Info", 0, func() { logger.Info("hello world") }},
+		{"Logger.Infof", 0, func() { logger.Infof("hello %s", "world") }},
+	} {
+		if n := testing.AllocsPerRun(1000, tc.fn); n > tc.max {
+			t.Errorf("%s allocates %v times per run, expected at most %v", tc.name, n, tc.max)
+		}
+	}
+}
+
+func BenchmarkFileBackendLog(b *testing.B) {
+	fb, err := NewFileBackendFS("/logs", NewMemFS())
+	if err != nil {
+		b.Fatal(err)
+	}
+	msg := []byte(strings.Repeat("x", 99) + "\n")
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fb.Log(INFO, msg)
+	}
+}
+
+func BenchmarkFileBackendLogRotate(b *testing.B) {
+	fb, err := NewFileBackendFS("/logs", NewMemFS())
+	if err != nil {
+		b.Fatal(err)
+	}
+	fb.Rotate(10, 1024*1024)
+	msg := []byte(strings.Repeat("x", 99) + "\n")
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fb.Log(INFO, msg)
+	}
+}
+
+func BenchmarkLoggerInfof(b *testing.B) {
+	fb, err := NewFileBackendFS("/logs", NewMemFS())
+	if err != nil {
+		b.Fatal(err)
+	}
+	logger := NewLogger(INFO, fb)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Infof("request=[%s] cost=[%d]", "/api/v1/orders", i)
+	}
+}
+
+func BenchmarkLoggerInfofBinary(b *testing.B) {
+	fb, err := NewFileBackendFS("/logs", NewMemFS())
+	if err != nil {
+		b.Fatal(err)
+	}
+	logger := NewLogger(INFO, NewBinaryBackend(fb))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Infof("request=[%s] cost=[%d]", "/api/v1/orders", i)
+	}
+}
+
+func BenchmarkCaller(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		caller(0)
+	}
+}
+
+func BenchmarkLoggerInfofParallel(b *testing.B) {
+	fb, err := NewFileBackendFS("/logs", NewMemFS())
+	if err != nil {
+		b.Fatal(err)
+	}
+	logger := NewLogger(INFO, fb)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			logger.Infof("request=[%s] cost=[%d]", "/api/v1/orders", 42)
+		}
+	})
+}
+
+func BenchmarkAsyncBackendLogParallel(b *testing.B) {
+	for _, linger := range []time.Duration{0, 100 * time.Microsecond} {
+		b.Run(fmt.Sprintf("linger=%s", linger), func(b *testing.B) {
+			fb, err := NewFileBackendFS("/logs", NewMemFS())
+			if err != nil {
+				b.Fatal(err)
+			}
+			ab := NewAsyncBackend(fb, AsyncConfig{Linger: linger, Overflow: OverflowPolicy{Mode: OverflowBlock}})
+			defer ab.Close(context.Background())
+			msg := []byte(strings.Repeat("x", 99) + "\n")
+			b.SetBytes(int64(len(msg)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					ab.Log(INFO, msg)
+				}
+			})
+			ab.Flush(context.Background())
+		})
+	}
+}
+
+func BenchmarkFileBackendLogParallel(b *testing.B) {
+	fb, err := NewFileBackendFS("/logs", NewMemFS())
+	if err != nil {
+		b.Fatal(err)
+	}
+	msg := []byte(strings.Repeat("x", 99) + "\n")
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			fb.Log(INFO, msg)
+		}
+	})
+}
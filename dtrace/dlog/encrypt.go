@@ -0,0 +1,260 @@
+package dlog
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/tools-go/go-utils/secrets"
+)
+
+// The encrypted files are named with encExt after the extension of the compressor, like
+// INFO.log.2016071114.gz.enc, and written as
+//
+//	encMagic | key name length (1 byte) | key name | nonce prefix (8 bytes) | chunks
+//
+// every chunk is the length of the sealed data (4 bytes, big endian) and the data sealed by AES-GCM
+// with the nonce of the prefix and the chunk index, the last chunk is marked by the additional data,
+// so a truncated file fails to decrypt instead of losing the tail silently.
+const (
+	encExt       = ".enc"
+	encMagic     = "DLOGENC1"
+	encChunkSize = 64 << 10
+)
+
+// EncryptionKeys are the AES keys encrypting the rotated files, loaded from a secrets provider.
+// The secrets are the keys of 16, 24 or 32 bytes, raw or in base64.
+type EncryptionKeys struct {
+	provider secrets.Provider
+	names    []string
+
+	mu     sync.Mutex
+	parsed map[string]cipher.AEAD // secret value -> cipher
+}
+
+// NewEncryptionKeys loads the keys from p, current is the name of the secret encrypting the new files,
+// and previous are the names of the rotated secrets, which still decrypt the files encrypted before.
+// The name of the key is written in the files, only the names here are loaded to decrypt them.
+//
+// The secrets are loaded on every file, so wrap the provider by secrets.NewCache, and the rotations
+// are picked up without restarts.
+func NewEncryptionKeys(p secrets.Provider, current string, previous ...string) *EncryptionKeys {
+	return &EncryptionKeys{
+		provider: p,
+		names:    append([]string{current}, previous...),
+		parsed:   map[string]cipher.AEAD{},
+	}
+}
+
+func (k *EncryptionKeys) load(ctx context.Context, name string) (cipher.AEAD, error) {
+	secret, err := k.provider.Get(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("load encryption key %s failed: %s", name, err)
+	}
+	value := secret.Reveal()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if aead, ok := k.parsed[value]; ok {
+		return aead, nil
+	}
+	key := []byte(value)
+	if n := len(key); n != 16 && n != 24 && n != 32 {
+		if key, err = base64.StdEncoding.DecodeString(value); err != nil {
+			return nil, fmt.Errorf("invalid encryption key %s: not of 16, 24 or 32 bytes", name)
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key %s: %s", name, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	k.parsed[value] = aead
+	return aead, nil
+}
+
+// NewWriter returns a writer encrypting into w by the current key, which is flushed by Close
+func (k *EncryptionKeys) NewWriter(ctx context.Context, w io.Writer) (io.WriteCloser, error) {
+	name := k.names[0]
+	aead, err := k.load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, len(encMagic)+1+len(name)+8)
+	header = append(header, encMagic...)
+	header = append(header, byte(len(name)))
+	header = append(header, name...)
+	prefix := make([]byte, 8)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, encChunkSize)}, nil
+}
+
+// NewReader returns a reader decrypting the file encrypted by NewWriter from r,
+// by the key named in the file
+func (k *EncryptionKeys) NewReader(ctx context.Context, r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(encMagic)+1)
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic[:len(encMagic)]) != encMagic {
+		return nil, fmt.Errorf("not an encrypted log file")
+	}
+	rest := make([]byte, int(magic[len(encMagic)])+8)
+	if _, err := io.ReadFull(br, rest); err != nil {
+		return nil, fmt.Errorf("truncated encrypted log file")
+	}
+	name, prefix := string(rest[:len(rest)-8]), rest[len(rest)-8:]
+	known := false
+	for _, n := range k.names {
+		known = known || n == name
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown encryption key %s", name)
+	}
+	aead, err := k.load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: br, aead: aead, prefix: prefix}, nil
+}
+
+func chunkNonce(aead cipher.AEAD, prefix []byte, index uint32) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[len(nonce)-4:], index)
+	return nonce
+}
+
+var (
+	chunkData = []byte{0}
+	chunkLast = []byte{1}
+)
+
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	buf    []byte
+	sealed []byte
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+		// the full chunk is held until more data comes, the last one is sealed by Close
+		if len(e.buf) == cap(e.buf) && len(p) > 0 {
+			if err := e.seal(chunkData); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) seal(ad []byte) error {
+	e.sealed = e.aead.Seal(e.sealed[:0], chunkNonce(e.aead, e.prefix, e.index), e.buf, ad)
+	e.index++
+	e.buf = e.buf[:0]
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(e.sealed)))
+	if _, err := e.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(e.sealed)
+	return err
+}
+
+// Close seals the last chunk, it doesn't close the underlying writer
+func (e *encryptWriter) Close() error {
+	return e.seal(chunkLast)
+}
+
+type decryptReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	plain  []byte
+	sealed []byte
+	last   bool
+}
+
+var errTruncated = errors.New("truncated encrypted log file")
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.last {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	var size [4]byte
+	if _, err := io.ReadFull(d.r, size[:]); err != nil {
+		return errTruncated
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > encChunkSize+uint32(d.aead.Overhead()) {
+		return fmt.Errorf("invalid encrypted chunk of %d bytes", n)
+	}
+	if cap(d.sealed) < int(n) {
+		d.sealed = make([]byte, n)
+	}
+	d.sealed = d.sealed[:n]
+	if _, err := io.ReadFull(d.r, d.sealed); err != nil {
+		return errTruncated
+	}
+	nonce := chunkNonce(d.aead, d.prefix, d.index)
+	plain, err := d.aead.Open(d.plain[:0], nonce, d.sealed, chunkData)
+	if err != nil {
+		if plain, err = d.aead.Open(d.plain[:0], nonce, d.sealed, chunkLast); err != nil {
+			return fmt.Errorf("decrypt log file failed: %s", err)
+		}
+		d.last = true
+	}
+	d.index++
+	d.plain = plain
+	return nil
+}
+
+// SetEncryption encrypts the rotated files by keys in the background, after they are compressed,
+// nil disables the encryption. The rotated files left unencrypted by the previous runs are encrypted too.
+// OpenBackup decrypts the files with the keys.
+func (self *FileBackend) SetEncryption(keys *EncryptionKeys) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.keys = keys
+	self.daemon(self.recoverArchives)
+}
+
+func SetEncryption(keys *EncryptionKeys) {
+	if fileback := getFileBackend(); fileback != nil {
+		fileback.SetEncryption(keys)
+	}
+}
@@ -0,0 +1,57 @@
+package dlog
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// eventLogConfig is parsed from the eventlog:// outputs
+type eventLogConfig struct {
+	source  string
+	eventID uint32
+	install bool
+}
+
+// parseEventLogOutput parses the output like eventlog://source?id=1000&install=true, the source is the
+// name of the program by default
+func parseEventLogOutput(addr string) (eventLogConfig, error) {
+	cfg := eventLogConfig{eventID: 1}
+	u, err := url.Parse(addr)
+	if err != nil || u.Scheme != "eventlog" || (u.Path != "" && u.Path != "/") {
+		return cfg, fmt.Errorf("invalid eventlog address %q", addr)
+	}
+	if cfg.source = u.Host; cfg.source == "" {
+		cfg.source = strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	}
+	query := u.Query()
+	if v := query.Get("id"); v != "" {
+		// the ids of the messages of EventCreate.exe, which the installed sources use
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || id < 1 || id > 1000 {
+			return cfg, fmt.Errorf("invalid event id: %s", v)
+		}
+		cfg.eventID = uint32(id)
+	}
+	if v := query.Get("install"); v != "" {
+		if cfg.install, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid install: %s", v)
+		}
+	}
+	return cfg, nil
+}
+
+// eventMessage returns the log without the time, as the events have their own, with the trace id in
+// the first line for the event viewer to filter by, and the line breaks of windows
+func eventMessage(msg []byte) string {
+	text := string(body(msg))
+	if i := strings.Index(text, "tid=["); i >= 0 && (i == 0 || text[i-1] == ' ') {
+		if j := strings.IndexByte(text[i+5:], ']'); j >= 0 {
+			text = "tid: " + text[i+5:i+5+j] + "\n" + text
+		}
+	}
+	return strings.Replace(text, "\n", "\r\n", -1)
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package dlog
+
+import "errors"
+
+type eventLogBackend struct{}
+
+func NewEventLogBackend(addr string) (*eventLogBackend, error) {
+	if _, err := parseEventLogOutput(addr); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("eventlog is not supported on this platform")
+}
+
+func (self *eventLogBackend) Log(s Severity, msg []byte) {}
+
+func (self *eventLogBackend) close() {}
@@ -0,0 +1,56 @@
+//go:build windows
+// +build windows
+
+package dlog
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+type eventLogBackend struct {
+	log     *eventlog.Log
+	eventID uint32
+}
+
+// NewEventLogBackend writes the logs to the windows event log, the addr is eventlog://source with the
+// query id of the event id, 1 by default, and install=true to register the source by EventCreate.exe,
+// which needs the administrator. The ERROR and the FATAL logs are the error events, the WARNING ones the
+// warnings, the others the information. The events are reported synchronously, queue them by
+// NewAsyncBackend for the busy services.
+func NewEventLogBackend(addr string) (*eventLogBackend, error) {
+	cfg, err := parseEventLogOutput(addr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.install {
+		// fails if the source is registered already
+		eventlog.InstallAsEventCreate(cfg.source, eventlog.Error|eventlog.Warning|eventlog.Info)
+	}
+	log, err := eventlog.Open(cfg.source)
+	if err != nil {
+		return nil, fmt.Errorf("open eventlog %s: %s", cfg.source, err)
+	}
+	return &eventLogBackend{log: log, eventID: cfg.eventID}, nil
+}
+
+func (self *eventLogBackend) Log(s Severity, msg []byte) {
+	var err error
+	switch text := eventMessage(msg); s {
+	case FATAL, ERROR:
+		err = self.log.Error(self.eventID, text)
+	case WARNING:
+		err = self.log.Warning(self.eventID, text)
+	default:
+		err = self.log.Info(self.eventID, text)
+	}
+	if err != nil {
+		os.Stderr.Write(msg)
+	}
+}
+
+func (self *eventLogBackend) close() {
+	self.log.Close()
+}
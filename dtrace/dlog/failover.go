@@ -0,0 +1,134 @@
+package dlog
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FailoverConfig is the config of NewFailoverBackend, the zero one uses the defaults
+type FailoverConfig struct {
+	Timeout  time.Duration // the primary stalled for it is failed over, 10s by default
+	Interval time.Duration // of checking the primary, 1s by default
+	// Probe tells whether the primary is back, like dialing the agent, it's called after the logs queued
+	// by the primary are drained. The drained primary is back if it's nil.
+	Probe func(ctx context.Context) error
+}
+
+type failoverBackend struct {
+	failed    int32 // 1 while the logs go to the fallback, accessed atomically
+	primary   Backend
+	fallback  Backend
+	cfg       FailoverConfig
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewFailoverBackend writes the logs to primary, like a network or a kafka backend shipping the logs off
+// the host, and switches to fallback, like a FileBackend, when the queued logs of primary stall for the
+// timeout, so an outage doesn't fill the queues and drop the logs. It switches back after the logs stuck
+// in primary are delivered and the probe succeeds. The stalls are told by the health of the backends.
+func NewFailoverBackend(primary, fallback Backend, cfg FailoverConfig) *failoverBackend {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	b := &failoverBackend{
+		primary:  primary,
+		fallback: fallback,
+		cfg:      cfg,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (self *failoverBackend) Log(s Severity, msg []byte) {
+	if atomic.LoadInt32(&self.failed) == 1 {
+		self.fallback.Log(s, msg)
+		return
+	}
+	self.primary.Log(s, msg)
+}
+
+// FailedOver tells whether the logs go to the fallback
+func (self *failoverBackend) FailedOver() bool {
+	return atomic.LoadInt32(&self.failed) == 1
+}
+
+func (self *failoverBackend) run() {
+	defer close(self.done)
+	ticker := time.NewTicker(self.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-self.quit:
+			return
+		}
+		stalled, queued := self.primaryHealth()
+		if atomic.LoadInt32(&self.failed) == 0 {
+			if stalled {
+				atomic.StoreInt32(&self.failed, 1)
+				fmt.Fprintf(os.Stderr, "dlog: the primary logging backend stalls, failed over with %d logs queued\n", queued)
+			}
+			continue
+		}
+		if queued > 0 || !self.probe() {
+			continue
+		}
+		atomic.StoreInt32(&self.failed, 0)
+		fmt.Fprintf(os.Stderr, "dlog: the primary logging backend is back\n")
+	}
+}
+
+// primaryHealth tells whether the queues of primary stall, and the logs queued by them
+func (self *failoverBackend) primaryHealth() (stalled bool, queued int64) {
+	walkBackends(self.primary, func(be Backend) {
+		if r, ok := be.(healthReporter); ok {
+			bh := r.health(self.cfg.Timeout)
+			stalled = stalled || bh.stalled
+			queued += bh.queued
+		}
+	})
+	return stalled, queued
+}
+
+func (self *failoverBackend) probe() bool {
+	if self.cfg.Probe == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), self.cfg.Interval)
+	defer cancel()
+	return self.cfg.Probe(ctx) == nil
+}
+
+// sync syncs the fallback, and the primary unless it's failed over, which waits for the stuck logs
+func (self *failoverBackend) sync(ctx context.Context) error {
+	var err error
+	if s, ok := self.fallback.(syncer); ok {
+		err = s.sync(ctx)
+	}
+	if s, ok := self.primary.(syncer); ok && atomic.LoadInt32(&self.failed) == 0 {
+		if perr := s.sync(ctx); perr != nil {
+			err = perr
+		}
+	}
+	return err
+}
+
+func (self *failoverBackend) close() {
+	self.closeOnce.Do(func() {
+		close(self.quit)
+		<-self.done
+	})
+	self.primary.close()
+	self.fallback.close()
+}
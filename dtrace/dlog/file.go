@@ -2,20 +2,22 @@ package dlog
 
 import (
 	"bufio"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/tools-go/go-utils/utils/clock"
 )
 
 const (
-	bufferSize = 256 * 1024
+	defaultBufferSize = 256 * 1024
 )
 
 func getLastCheck(now time.Time) uint64 {
@@ -24,14 +26,18 @@ func getLastCheck(now time.Time) uint64 {
 
 type syncBuffer struct {
 	*bufio.Writer
-	file     *os.File
+	file     File
 	count    uint64
 	cur      int
 	filePath string
 	parent   *FileBackend
+	since    time.Time // the start of the current file when rotated by the schedule
+	next     time.Time // the next scheduled rotation
+	unsynced uint64    // the bytes written since the last sync
 }
 
 func (self *syncBuffer) Sync() error {
+	self.unsynced = 0
 	return self.file.Sync()
 }
 
@@ -41,61 +47,212 @@ func (self *syncBuffer) close() {
 	self.file.Close()
 }
 
+// rotateTo renames the current file to rotated and opens a new one, the rotated file is
+// compressed and moved to the backup dir in the background if they are set
+func (self *syncBuffer) rotateTo(rotated string) {
+	self.Flush()
+	if self.parent.syncOnRotate() {
+		self.Sync()
+	}
+	self.file.Close()
+	if err := self.parent.fs.Rename(self.filePath, rotated); err != nil {
+		self.parent.reportError(fmt.Errorf("rotate %s failed: %s", self.filePath, err))
+	} else {
+		self.parent.countRotation(rotated)
+	}
+	if f, err := self.parent.fs.OpenFile(self.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+		self.Writer = bufio.NewWriterSize(f, self.parent.bufferSize)
+		self.file = f
+	} else {
+		self.parent.reportError(fmt.Errorf("reopen %s failed: %s", self.filePath, err))
+	}
+	self.count = 0
+	if self.parent.compressor != nil || self.parent.keys != nil || self.parent.backupDir != "" || self.parent.symlinkLatest > 0 {
+		select {
+		case self.parent.rotated <- rotatedFile{rotated, self.parent.compressor, self.parent.keys, self.parent.backupDir}:
+		default:
+			self.parent.reportError(fmt.Errorf("too many files to archive, %s is left in place", rotated))
+		}
+	}
+	if self.parent.budget != nil {
+		self.parent.budget.notify()
+	}
+}
+
+// rotateBySchedule renames the current file with the time it started, and opens a new one,
+// so the file is rotated on the first write after the scheduled time without any daemon
+func (self *syncBuffer) rotateBySchedule(now time.Time) {
+	self.rotateTo(self.parent.uniqueBackupName(self.filePath + "." + self.since.Format(scheduleTagLayout)))
+	self.since = self.next
+	self.next = self.parent.schedule.Next(now)
+}
+
 func (self *syncBuffer) write(b []byte) {
+	if !self.next.IsZero() {
+		if now := self.parent.clock.Now(); !now.Before(self.next) {
+			self.rotateBySchedule(now)
+		}
+	}
 	if !self.parent.rotateByHour && self.parent.maxSize > 0 && self.parent.rotateNum > 0 && self.count+uint64(len(b)) >= self.parent.maxSize {
-		os.Rename(self.filePath, self.filePath+fmt.Sprintf(".%03d", self.cur))
+		self.rotateTo(self.filePath + fmt.Sprintf(".%03d", self.cur))
 		self.cur++
 		if self.cur >= self.parent.rotateNum {
 			self.cur = 0
 		}
-		self.count = 0
 	}
 	self.count += uint64(len(b))
-	self.Writer.Write(b)
+	n, _ := self.Writer.Write(b)
+	atomic.AddUint64(&self.parent.counters.bytesWritten, uint64(n))
+	if p := self.parent.syncPolicy; p != nil && p.Bytes > 0 {
+		if self.unsynced += uint64(n); self.unsynced >= p.Bytes {
+			self.Flush()
+			self.Sync()
+		}
+	}
 }
 
 type FileBackend struct {
+	counters      fileCounters // first for the alignment of the atomic ops
+	lastFlush     int64        // in unix nanoseconds, accessed atomically
 	mu            sync.Mutex
 	dir           string //directory for log files
 	files         [numSeverity]syncBuffer
 	flushInterval time.Duration
+	bufferSize    int // of every file
 	rotateNum     int
 	maxSize       uint64
 	fall          bool
 	rotateByHour  bool
 	lastCheck     uint64
-	reg           *regexp.Regexp // for rotatebyhour log del...
-	keepHours     uint           // keep how many hours old, only make sense when rotatebyhour is T
+	keepHours     uint // keep how many hours old, only make sense when rotatebyhour is T
+	schedule      Schedule
+	compressor    Compressor
+	keys          *EncryptionKeys
+	rotated       chan rotatedFile // the rotated files to compress or archive
+	millMu        sync.Mutex       // held by archiving a rotated file, and by Purge
+	backupDir     string
+	budget        *BackupBudget
+	errs          chan error
+	errorHandler  func(err error)
+	minFree       freeSpace
+	clock         clock.Clock
+	fs            FS
+	symlinkLatest int // the count of the latest links per severity
+	syncPolicy    *SyncPolicy
+	throttle      *MillThrottle
+	sigReopen     bool  // the files are reopened by HandleRotateSignals instead of the polling
+	watched       int32 // the dir is watched by fsnotify, accessed atomically
+	events        chan FileEvent
+	observer      func(ev FileEvent)
+	closed        bool
+	quit          chan struct{} // closed by Close to stop the daemons
+	daemons       sync.WaitGroup
+	closeOnce     sync.Once
 }
 
+// scheduleTagLayout is the suffix of the files rotated by the schedule
+const scheduleTagLayout = "200601021504"
+
+// Flush writes the buffered logs to the files and syncs them
 func (self *FileBackend) Flush() {
+	self.flush(true)
+}
+
+func (self *FileBackend) flush(sync bool) {
 	self.mu.Lock()
 	defer self.mu.Unlock()
+	if self.closed {
+		return
+	}
 	for i := 0; i < numSeverity; i++ {
 		self.files[i].Flush()
-		self.files[i].Sync()
+		if sync {
+			self.files[i].Sync()
+		}
 	}
+	atomic.StoreInt64(&self.lastFlush, time.Now().UnixNano())
+}
 
+// Close stops the daemons of the backend and closes the files, the rotated files being archived
+// are finished first. The logs after it go to stderr.
+func (self *FileBackend) Close() {
+	self.closeOnce.Do(func() {
+		// the daemons started by the setters hold the lock, see daemon
+		self.mu.Lock()
+		close(self.quit)
+		self.mu.Unlock()
+		self.daemons.Wait()
+		self.unwatchFiles()
+		self.mu.Lock()
+		defer self.mu.Unlock()
+		for i := 0; i < numSeverity; i++ {
+			self.files[i].close()
+		}
+		self.closed = true
+	})
 }
 
 func (self *FileBackend) close() {
+	self.Close()
+}
+
+// sleep sleeps for d by c, it returns false at once if the backend is closed
+func (self *FileBackend) sleep(c clock.Clock, d time.Duration) bool {
+	select {
+	case <-c.After(d):
+		return true
+	case <-self.quit:
+		return false
+	}
+}
+
+// daemon runs fn in a goroutine waited by Close, it's not run after Close. The callers other than
+// NewFileBackendFS hold self.mu, so they are ordered with Close.
+func (self *FileBackend) daemon(fn func()) {
+	select {
+	case <-self.quit:
+		return
+	default:
+	}
+	self.daemons.Add(1)
+	go func() {
+		defer self.daemons.Done()
+		fn()
+	}()
+}
+
+// sync writes the buffered logs to the files at once, ctx is not checked
+func (self *FileBackend) sync(ctx context.Context) error {
 	self.Flush()
+	return nil
 }
 
 func (self *FileBackend) flushDaemon() {
 	for {
-		time.Sleep(self.flushInterval)
-		self.Flush()
+		self.mu.Lock()
+		clock, interval := self.clock, self.flushInterval
+		self.mu.Unlock()
+		if !self.sleep(clock, interval) {
+			return
+		}
+		self.mu.Lock()
+		sync := self.syncPolicy == nil
+		self.mu.Unlock()
+		self.flush(sync)
 	}
 }
 
-func shouldDel(fileName string, left uint) bool {
-	// tag should be like 2016071114
-	tagInt, err := strconv.Atoi(strings.Split(fileName, ".")[2])
+func shouldDel(fileName string, left uint, now time.Time) bool {
+	// tag should be like 2016071114, or 201607111430 if rotated by the schedule
+	name, ok := parseBackupName(fileName)
+	if !ok || (len(name.tag) != len(hourTagLayout) && len(name.tag) != len(scheduleTagLayout)) {
+		return false
+	}
+	tagInt, err := strconv.Atoi(name.tag[:len(hourTagLayout)])
 	if err != nil {
 		return false
 	}
-	point := time.Now().Unix() - int64(left*3600)
+	point := now.Unix() - int64(left*3600)
 
 	if getLastCheck(time.Unix(point, 0)) > uint64(tagInt) {
 		return true
@@ -107,50 +264,165 @@ func shouldDel(fileName string, left uint) bool {
 
 func (self *FileBackend) rotateByHourDaemon() {
 	for {
-		time.Sleep(time.Second * 1)
-		if self.rotateByHour {
-			check := getLastCheck(time.Now())
-			if self.lastCheck < check {
-				for i := 0; i < numSeverity; i++ {
-					os.Rename(self.files[i].filePath, self.files[i].filePath+fmt.Sprintf(".%d", self.lastCheck))
+		if !self.sleep(self.getClock(), time.Second) {
+			return
+		}
+		self.mu.Lock()
+		scheduled := self.schedule != nil
+		now := self.clock.Now()
+		rotateByHour, keepHours := self.rotateByHour, self.keepHours
+		self.mu.Unlock()
+		self.pruneForFreeSpace()
+		if rotateByHour || scheduled {
+			if rotateByHour {
+				check := getLastCheck(now)
+				self.mu.Lock()
+				if self.lastCheck < check {
+					for i := 0; i < numSeverity; i++ {
+						self.files[i].rotateTo(self.uniqueBackupName(self.files[i].filePath + fmt.Sprintf(".%d", self.lastCheck)))
+					}
+					self.lastCheck = check
 				}
-				self.lastCheck = check
+				self.mu.Unlock()
 			}
 
-			// also check log dir to del overtime files
-			files, err := ioutil.ReadDir(self.dir)
-			if err == nil {
-				for _, file := range files {
-					// exactly match, then we
-					if file.Name() == self.reg.FindString(file.Name()) &&
-						shouldDel(file.Name(), self.keepHours) {
-						os.Remove(filepath.Join(self.dir, file.Name()))
-					}
-				}
+			self.removeExpired(keepHours, now)
+		}
+	}
+}
+
+// removeExpired removes the backups rotated by the hour or the schedule more than keepHours ago,
+// compressed or encrypted or not, in the log dir and the backup dir
+func (self *FileBackend) removeExpired(keepHours uint, now time.Time) {
+	for _, dir := range self.backupDirs() {
+		files, err := self.fs.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if !shouldDel(file.Name(), keepHours, now) {
+				continue
+			}
+			if err := self.fs.Remove(filepath.Join(dir, file.Name())); err == nil {
+				self.countDelete(filepath.Join(dir, file.Name()))
+			} else if !os.IsNotExist(err) {
+				self.reportError(fmt.Errorf("remove expired %s failed: %s", file.Name(), err))
 			}
 		}
 	}
 }
 
+// reopen opens the file at its path again, after it's moved away by the tools like logrotate.
+// It's called with the lock held.
+func (self *syncBuffer) reopen() error {
+	f, err := self.parent.fs.OpenFile(self.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	self.close()
+	self.Writer = bufio.NewWriterSize(f, self.parent.bufferSize)
+	self.file = f
+	self.count = 0
+	return nil
+}
+
+// monitorFiles reopens the files removed or moved away, unless they are reopened by the signals.
+// They are watched by fsnotify if possible, otherwise polled every 5 seconds.
 func (self *FileBackend) monitorFiles() {
-	for range time.NewTicker(time.Second * 5).C {
-		for i := 0; i < numSeverity; i++ {
-			fileName := path.Join(self.dir, severityName[i]+".log")
-			if _, err := os.Stat(fileName); err != nil && os.IsNotExist(err) {
-				if f, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
-					self.mu.Lock()
-					self.files[i].close()
-					self.files[i].Writer = bufio.NewWriterSize(f, bufferSize)
-					self.files[i].file = f
-					self.mu.Unlock()
-				}
-			}
+	self.watchFiles()
+	for {
+		if !self.sleep(self.getClock(), time.Second*5) {
+			return
+		}
+		if atomic.LoadInt32(&self.watched) == 1 {
+			continue
+		}
+		self.reopenMissing("")
+		self.watchFiles()
+	}
+}
+
+// reopenMissing reopens the live file of name, or all of them if name is empty, if it doesn't exist
+func (self *FileBackend) reopenMissing(name string) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.sigReopen {
+		return
+	}
+	for i := 0; i < numSeverity; i++ {
+		f := &self.files[i]
+		if name != "" && name != path.Base(f.filePath) {
+			continue
+		}
+		if _, err := self.fs.Stat(f.filePath); err != nil && os.IsNotExist(err) {
+			f.reopen()
 		}
 	}
 }
 
+// Reopen reopens all the files at their paths, for the tools like logrotate moving them away
+// and signaling the process
+func (self *FileBackend) Reopen() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for i := 0; i < numSeverity; i++ {
+		if err := self.files[i].reopen(); err != nil {
+			return fmt.Errorf("reopen %s failed: %s", self.files[i].filePath, err)
+		}
+	}
+	return nil
+}
+
+// RotateNow rotates the non-empty files at once, they are named with the current time
+// like the files rotated by the schedule
+func (self *FileBackend) RotateNow() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	now := self.clock.Now()
+	for i := 0; i < numSeverity; i++ {
+		f := &self.files[i]
+		f.Flush()
+		if info, err := self.fs.Stat(f.filePath); err != nil || info.Size() == 0 {
+			continue
+		}
+		f.rotateTo(self.uniqueBackupName(f.filePath + "." + now.Format(scheduleTagLayout)))
+	}
+}
+
 func (self *FileBackend) Log(s Severity, msg []byte) {
 	self.mu.Lock()
+	if self.closed {
+		self.mu.Unlock()
+		os.Stderr.Write(msg)
+		return
+	}
+	self.log(s, msg)
+	self.mu.Unlock()
+	if s == FATAL {
+		self.Flush()
+	}
+}
+
+// logBatch writes the logs queued by the async backend with the lock taken once
+func (self *FileBackend) logBatch(entries []asyncEntry) {
+	fatal := false
+	self.mu.Lock()
+	for _, entry := range entries {
+		if self.closed {
+			os.Stderr.Write(entry.msg)
+			continue
+		}
+		self.log(entry.s, entry.msg)
+		fatal = fatal || entry.s == FATAL
+	}
+	self.mu.Unlock()
+	if fatal {
+		self.Flush()
+	}
+}
+
+// log writes msg to the files of s, it's called with the lock held
+func (self *FileBackend) log(s Severity, msg []byte) {
 	switch s {
 	case FATAL:
 		self.files[FATAL].write(msg)
@@ -166,35 +438,179 @@ func (self *FileBackend) Log(s Severity, msg []byte) {
 	if self.fall && s < INFO {
 		self.files[INFO].write(msg)
 	}
-	self.mu.Unlock()
-	if s == FATAL {
-		self.Flush()
-	}
 }
 
 func (self *FileBackend) Rotate(rotateNum1 int, maxSize1 uint64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
 	self.rotateNum = rotateNum1
 	self.maxSize = maxSize1
 }
 
 func (self *FileBackend) SetRotateByHour(rotateByHour bool) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
 	self.rotateByHour = rotateByHour
 	if self.rotateByHour {
-		self.lastCheck = getLastCheck(time.Now())
+		self.lastCheck = getLastCheck(self.clock.Now())
 	} else {
 		self.lastCheck = 0
 	}
 }
 
+// SetRotateSchedule rotates the files by the schedule, see ParseSchedule for the spec,
+// the rotated files are named like INFO.log.201607111430 with the time they started.
+// It takes precedence over SetRotateByHour, and an empty spec disables it.
+func (self *FileBackend) SetRotateSchedule(spec string) error {
+	var schedule Schedule
+	if spec != "" {
+		var err error
+		if schedule, err = ParseSchedule(spec); err != nil {
+			return err
+		}
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.schedule = schedule
+	now := self.clock.Now()
+	for i := 0; i < numSeverity; i++ {
+		self.files[i].since = now
+		self.files[i].next = time.Time{}
+		if schedule != nil {
+			self.files[i].next = schedule.Next(now)
+		}
+	}
+	if schedule != nil {
+		self.rotateByHour = false
+	}
+	return nil
+}
+
+// SetClock sets the clock of the rotation and the daemons, which pick it up on their next wake up,
+// it's mostly for testing the rotation with a clock.FakeClock
+func (self *FileBackend) SetClock(c clock.Clock) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.clock = c
+}
+
+func (self *FileBackend) getClock() clock.Clock {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.clock
+}
+
+// SetBackupDir moves the rotated files to dir, like /var/log/app/archive, which may be on another volume,
+// so the log dir only holds the live files. An empty dir keeps the rotated files in the log dir.
+// The rotated files left in the log dir by the previous runs are moved too.
+func (self *FileBackend) SetBackupDir(dir string) error {
+	if dir != "" {
+		if err := self.fs.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.backupDir = dir
+	if dir != "" {
+		self.daemon(self.recoverArchives)
+	}
+	return nil
+}
+
+// backupDirs returns the dirs of the rotated files, the log dir may still hold some not archived yet
+func (self *FileBackend) backupDirs() []string {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.backupDir == "" || self.backupDir == self.dir {
+		return []string{self.dir}
+	}
+	return []string{self.dir, self.backupDir}
+}
+
+// listBackups returns the rotated files of the backend, the oldest first
+func (self *FileBackend) listBackups() ([]backupFile, error) {
+	var all []backupFile
+	for _, dir := range self.backupDirs() {
+		files, err := listBackups(self.fs, dir)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, files...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].modTime.Before(all[j].modTime) })
+	return all, nil
+}
+
+// SetErrorHandler sets the handler of the errors of rotating, compressing and removing the files,
+// which are printed to stderr by default. The handler is called in a separate goroutine,
+// so it's safe to log with the backend itself.
+func (self *FileBackend) SetErrorHandler(handler func(err error)) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.errorHandler = handler
+}
+
+// reportError hands err to the error handler without blocking, it's dropped if too many are pending
+func (self *FileBackend) reportError(err error) {
+	select {
+	case self.errs <- err:
+	default:
+	}
+}
+
+func (self *FileBackend) errorDaemon() {
+	for {
+		var err error
+		select {
+		case err = <-self.errs:
+		case <-self.quit:
+			return
+		}
+		self.mu.Lock()
+		handler := self.errorHandler
+		self.mu.Unlock()
+		if handler == nil {
+			fmt.Fprintf(os.Stderr, "dlog: %s\n", err)
+			continue
+		}
+		handler(err)
+	}
+}
+
 func (self *FileBackend) SetKeepHours(hours uint) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
 	self.keepHours = hours
 }
 
 func (self *FileBackend) Fall() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
 	self.fall = true
 }
 
+// SetBufferSize sets the size of the buffer of every file, the logs are written to the files when
+// the buffer is full, on every flush and before rotating. 0 restores the default 256KB.
+func (self *FileBackend) SetBufferSize(size int) {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if size == self.bufferSize {
+		return
+	}
+	self.bufferSize = size
+	for i := 0; i < numSeverity; i++ {
+		self.files[i].Flush()
+		self.files[i].Writer = bufio.NewWriterSize(self.files[i].file, size)
+	}
+}
+
 func (self *FileBackend) SetFlushDuration(t time.Duration) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
 	if t >= time.Second {
 		self.flushInterval = t
 	} else {
@@ -202,63 +618,105 @@ func (self *FileBackend) SetFlushDuration(t time.Duration) {
 	}
 }
 func NewFileBackend(dir string) (*FileBackend, error) {
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	return NewFileBackendFS(dir, OSFS)
+}
+
+// NewFileBackendFS creates a FileBackend writing the files in dir of fs
+func NewFileBackendFS(dir string, fs FS) (*FileBackend, error) {
+	if err := fs.MkdirAll(dir, 0755); err != nil {
 		return nil, err
 	}
 	var fb FileBackend
 	fb.dir = dir
+	fb.fs = fs
 	for i := 0; i < numSeverity; i++ {
 		fileName := path.Join(dir, severityName[i]+".log")
-		f, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		f, err := fs.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			return nil, err
 		}
-		fb.files[i] = syncBuffer{Writer: bufio.NewWriterSize(f, bufferSize), file: f, filePath: fileName, parent: &fb}
+		fb.files[i] = syncBuffer{Writer: bufio.NewWriterSize(f, defaultBufferSize), file: f, filePath: fileName, parent: &fb}
 	}
 	// default
 	fb.flushInterval = time.Second * 3
+	fb.lastFlush = time.Now().UnixNano()
+	fb.bufferSize = defaultBufferSize
 	fb.rotateNum = 20
 	fb.maxSize = 1024 * 1024 * 1024
 	fb.rotateByHour = false
 	fb.lastCheck = 0
-	// init reg to match files
-	// ONLY cover this centry...
-	fb.reg = regexp.MustCompile("(INFO|ERROR|WARNING|DEBUG|FATAL)\\.log\\.20[0-9]{8}")
 	fb.keepHours = 24 * 7
-
-	go fb.flushDaemon()
-	go fb.monitorFiles()
-	go fb.rotateByHourDaemon()
+	fb.clock = clock.New()
+
+	fb.rotated = make(chan rotatedFile, 64)
+	fb.errs = make(chan error, 64)
+	fb.events = make(chan FileEvent, 64)
+	fb.quit = make(chan struct{})
+
+	fb.daemon(fb.flushDaemon)
+	fb.daemon(fb.syncDaemon)
+	fb.daemon(fb.millDaemon)
+	fb.daemon(fb.errorDaemon)
+	fb.daemon(fb.observerDaemon)
+	fb.daemon(fb.monitorFiles)
+	fb.daemon(fb.rotateByHourDaemon)
+	fb.daemon(fb.recoverArchives)
 	return &fb, nil
 }
 
 func Rotate(rotateNum1 int, maxSize1 uint64) {
-	if fileback != nil {
+	if fileback := getFileBackend(); fileback != nil {
 		fileback.Rotate(rotateNum1, maxSize1)
 	}
 }
 
 func Fall() {
-	if fileback != nil {
+	if fileback := getFileBackend(); fileback != nil {
 		fileback.Fall()
 	}
 }
 
 func SetFlushDuration(t time.Duration) {
-	if fileback != nil {
+	if fileback := getFileBackend(); fileback != nil {
 		fileback.SetFlushDuration(t)
 	}
 
 }
 
+func SetBufferSize(size int) {
+	if fileback := getFileBackend(); fileback != nil {
+		fileback.SetBufferSize(size)
+	}
+}
+
 func SetRotateByHour(rotateByHour bool) {
-	if fileback != nil {
+	if fileback := getFileBackend(); fileback != nil {
 		fileback.SetRotateByHour(rotateByHour)
 	}
 }
 
+func SetRotateSchedule(spec string) error {
+	if fileback := getFileBackend(); fileback != nil {
+		return fileback.SetRotateSchedule(spec)
+	}
+	return nil
+}
+
+func SetBackupDir(dir string) error {
+	if fileback := getFileBackend(); fileback != nil {
+		return fileback.SetBackupDir(dir)
+	}
+	return nil
+}
+
+func SetErrorHandler(handler func(err error)) {
+	if fileback := getFileBackend(); fileback != nil {
+		fileback.SetErrorHandler(handler)
+	}
+}
+
 func SetKeepHours(hours uint) {
-	if fileback != nil {
+	if fileback := getFileBackend(); fileback != nil {
 		fileback.SetKeepHours(hours)
 	}
 }
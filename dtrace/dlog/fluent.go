@@ -0,0 +1,397 @@
+package dlog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FluentConfig is the config of NewFluentBackend
+type FluentConfig struct {
+	Tag string // of the events, like app.orders, routed by the aggregator
+	// RequireAck waits for the aggregator to acknowledge each batch, the batches not acknowledged in
+	// AckTimeout are sent again, so the logs are not lost but may be duplicated
+	RequireAck bool
+	AckTimeout time.Duration // 10s by default
+	TLS        *tls.Config   // of the fluent+tls:// addresses, the default one verifies the host
+	// a batch failed to send is retried with the backoff doubling from 100ms to 5s, and dropped after
+	// RetryTimeout, 30s by default. The logs are queued meanwhile, the full queue is handled by Overflow.
+	RetryTimeout time.Duration
+	DialTimeout  time.Duration // 5s by default
+	// the batching of the logs, the defaults of NewAsyncBackend if zero
+	QueueSize int
+	BatchSize int
+	Linger    time.Duration
+	Overflow  OverflowPolicy
+}
+
+type fluentSink struct {
+	failed    uint64 // the logs dropped as the sending failed, accessed atomically
+	lastWrite int64  // in unix nanoseconds, accessed atomically
+	network   string
+	addr      string
+	cfg       FluentConfig
+	mu        sync.Mutex // of the connection, for the async workers
+	conn      net.Conn
+	reader    *bufio.Reader
+}
+
+// NewFluentBackend sends the logs to fluentd or fluent bit by the forward protocol, in batches by the
+// background goroutines of NewAsyncBackend. The addr is like fluent://host:24224, fluent+tls://host:24224
+// or fluent+unix:///var/run/fluent.sock. Each log is an event of the fields level, caller, message and
+// the tags of key=[value] in it.
+func NewFluentBackend(addr string, cfg FluentConfig) (*asyncBackend, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fluent address %q: %s", addr, err)
+	}
+	sink := &fluentSink{addr: u.Host, cfg: cfg, lastWrite: time.Now().UnixNano()}
+	switch u.Scheme {
+	case "fluent", "fluent+tls":
+		sink.network = strings.TrimPrefix(strings.TrimPrefix(u.Scheme, "fluent"), "+")
+		if sink.network == "" {
+			sink.network = "tcp"
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid fluent address %q: no host", addr)
+		}
+	case "fluent+unix":
+		if sink.network, sink.addr = "unix", u.Path; u.Path == "" {
+			return nil, fmt.Errorf("invalid fluent address %q: no path", addr)
+		}
+	default:
+		return nil, fmt.Errorf("invalid fluent address %q: unknown scheme", addr)
+	}
+	if cfg.Tag == "" {
+		return nil, fmt.Errorf("fluent: no tag")
+	}
+	if sink.cfg.AckTimeout <= 0 {
+		sink.cfg.AckTimeout = 10 * time.Second
+	}
+	if sink.cfg.RetryTimeout <= 0 {
+		sink.cfg.RetryTimeout = 30 * time.Second
+	}
+	if sink.cfg.DialTimeout <= 0 {
+		sink.cfg.DialTimeout = 5 * time.Second
+	}
+	return NewAsyncBackend(sink, AsyncConfig{
+		QueueSize: cfg.QueueSize,
+		BatchSize: cfg.BatchSize,
+		Linger:    cfg.Linger,
+		Overflow:  cfg.Overflow,
+	}), nil
+}
+
+func (self *fluentSink) Log(s Severity, msg []byte) {
+	self.logBatch([]asyncEntry{{s, msg}})
+}
+
+func (self *fluentSink) logBatch(entries []asyncEntry) {
+	data, chunk := self.encode(entries)
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	deadline := time.Now().Add(self.cfg.RetryTimeout)
+	backoff := 100 * time.Millisecond
+	for {
+		err := self.send(data, chunk)
+		if err == nil {
+			atomic.StoreInt64(&self.lastWrite, time.Now().UnixNano())
+			return
+		}
+		if self.conn != nil {
+			self.conn.Close()
+			self.conn = nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			atomic.AddUint64(&self.failed, uint64(len(entries)))
+			fmt.Fprintf(os.Stderr, "dlog: send %d logs to fluent %s://%s failed, dropped: %s\n", len(entries), self.network, self.addr, err)
+			return
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > 5*time.Second {
+			backoff = 5 * time.Second
+		}
+	}
+}
+
+// send writes the message, and waits for the ack of the chunk if it's required
+func (self *fluentSink) send(data []byte, chunk string) error {
+	if self.conn == nil {
+		dialer := &net.Dialer{Timeout: self.cfg.DialTimeout}
+		var err error
+		if self.network == "tls" {
+			self.conn, err = tls.DialWithDialer(dialer, "tcp", self.addr, self.cfg.TLS)
+		} else {
+			self.conn, err = dialer.Dial(self.network, self.addr)
+		}
+		if err != nil {
+			return err
+		}
+		self.reader = bufio.NewReader(self.conn)
+	}
+	self.conn.SetWriteDeadline(time.Now().Add(self.cfg.AckTimeout))
+	if _, err := self.conn.Write(data); err != nil {
+		return err
+	}
+	if !self.cfg.RequireAck {
+		return nil
+	}
+	self.conn.SetReadDeadline(time.Now().Add(self.cfg.AckTimeout))
+	resp, err := decodeMsgpack(self.reader)
+	if err != nil {
+		return fmt.Errorf("read ack: %s", err)
+	}
+	if m, ok := resp.(map[string]interface{}); !ok || m["ack"] != chunk {
+		return fmt.Errorf("unexpected ack %v", resp)
+	}
+	return nil
+}
+
+// encode encodes the entries in the forward mode: [tag, [[time, record], ...], {"chunk": id}]
+func (self *fluentSink) encode(entries []asyncEntry) ([]byte, string) {
+	b := appendMsgpackArray(nil, 3)
+	b = appendMsgpackString(b, self.cfg.Tag)
+	b = appendMsgpackArray(b, len(entries))
+	for _, e := range entries {
+		b = appendMsgpackArray(b, 2)
+		b = appendEventTime(b, logTime(e.msg))
+		b = appendFluentRecord(b, e.s, e.msg)
+	}
+	chunk := ""
+	if self.cfg.RequireAck {
+		id := make([]byte, 16)
+		rand.Read(id)
+		chunk = base64.StdEncoding.EncodeToString(id)
+		b = appendMsgpackMap(b, 1)
+		b = appendMsgpackString(b, "chunk")
+		b = appendMsgpackString(b, chunk)
+	} else {
+		b = appendMsgpackMap(b, 0)
+	}
+	return b, chunk
+}
+
+// appendFluentRecord appends the record of the log: the level, the caller, the tags and the message
+func appendFluentRecord(b []byte, s Severity, msg []byte) []byte {
+	fields := [][2]string{{"level", strings.ToLower(severityName[s])}}
+	text := bytes.TrimRight(msg, "\n")
+	// the time is of the event, the severity and the caller follow it
+	if len(msg) > dlogHeaderLen && msg[dlogHeaderLen-1] == ' ' {
+		text = bytes.TrimPrefix(body(msg), []byte(severityName[s]+" "))
+		if caller, rest := cutWord(text); bytes.IndexByte(caller, ':') > 0 {
+			fields = append(fields, [2]string{"caller", string(caller)})
+			text = rest
+		}
+	}
+	seen := map[string]bool{"level": true, "caller": true, "message": true}
+	for rest := text; len(rest) > 0; {
+		key, value, after, ok := cutTag(rest)
+		if !ok {
+			_, rest = cutWord(rest)
+			continue
+		}
+		rest = after
+		if !seen[key] {
+			seen[key] = true
+			fields = append(fields, [2]string{key, value})
+		}
+	}
+	fields = append(fields, [2]string{"message", string(text)})
+	b = appendMsgpackMap(b, len(fields))
+	for _, f := range fields {
+		b = appendMsgpackString(b, f[0])
+		b = appendMsgpackString(b, f[1])
+	}
+	return b
+}
+
+func (self *fluentSink) health(stall time.Duration) backendHealth {
+	return backendHealth{
+		name:      "fluent " + self.network + "://" + self.addr,
+		dropped:   atomic.LoadUint64(&self.failed),
+		lastWrite: time.Unix(0, atomic.LoadInt64(&self.lastWrite)),
+	}
+}
+
+func (self *fluentSink) close() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.conn != nil {
+		self.conn.Close()
+		self.conn = nil
+	}
+}
+
+// parseFluentOutput parses the output like fluent://host:24224?tag=app.orders&ack=true, the query may
+// also set batch, linger, queue and overflow
+func parseFluentOutput(addr string) (string, FluentConfig, error) {
+	var cfg FluentConfig
+	target, rawQuery := splitOnce(addr, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", cfg, err
+	}
+	cfg.Tag = query.Get("tag")
+	if v := query.Get("ack"); v != "" {
+		if cfg.RequireAck, err = strconv.ParseBool(v); err != nil {
+			return "", cfg, fmt.Errorf("invalid ack: %s", v)
+		}
+	}
+	for key, n := range map[string]*int{"batch": &cfg.BatchSize, "queue": &cfg.QueueSize} {
+		if v := query.Get(key); v != "" {
+			if *n, err = strconv.Atoi(v); err != nil {
+				return "", cfg, fmt.Errorf("invalid %s: %s", key, v)
+			}
+		}
+	}
+	if v := query.Get("linger"); v != "" {
+		if cfg.Linger, err = time.ParseDuration(v); err != nil {
+			return "", cfg, fmt.Errorf("invalid linger: %s", v)
+		}
+	}
+	if cfg.Overflow, err = ParseOverflowPolicy(query.Get("overflow")); err != nil {
+		return "", cfg, err
+	}
+	return target, cfg, nil
+}
+
+// The subset of msgpack of the forward protocol, the strings, the arrays, the maps and the event time.
+
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n < 1<<8:
+		b = append(b, 0xd9, byte(n))
+	case n < 1<<16:
+		b = append(b, 0xda, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0xdb, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackArray(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n < 1<<16:
+		return append(b, 0xdc, byte(n>>8), byte(n))
+	}
+	return append(b, 0xdd, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func appendMsgpackMap(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n < 1<<16:
+		return append(b, 0xde, byte(n>>8), byte(n))
+	}
+	return append(b, 0xdf, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+// appendEventTime appends the EventTime of fluentd, the ext type 0 of the seconds and the nanoseconds
+func appendEventTime(b []byte, t time.Time) []byte {
+	b = append(b, 0xd7, 0x00)
+	var buf [8]byte
+	binary.BigEndian.PutUint32(buf[:4], uint32(t.Unix()))
+	binary.BigEndian.PutUint32(buf[4:], uint32(t.Nanosecond()))
+	return append(b, buf[:]...)
+}
+
+// decodeMsgpack decodes a value of the subset, the event times are decoded as time.Time
+func decodeMsgpack(r *bufio.Reader) (interface{}, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	readN := func(n int) ([]byte, error) {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+	length := func(size int) (int, error) {
+		buf, err := readN(size)
+		if err != nil {
+			return 0, err
+		}
+		n := 0
+		for _, b := range buf {
+			n = n<<8 | int(b)
+		}
+		return n, nil
+	}
+	var n int
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c == 0xc0:
+		return nil, nil
+	case c == 0xc2 || c == 0xc3:
+		return c == 0xc3, nil
+	case c&0xe0 == 0xa0, c == 0xd9, c == 0xda, c == 0xdb:
+		if n = int(c & 0x1f); c&0xe0 != 0xa0 {
+			if n, err = length(1 << (c - 0xd9)); err != nil {
+				return nil, err
+			}
+		}
+		buf, err := readN(n)
+		return string(buf), err
+	case c&0xf0 == 0x90, c == 0xdc, c == 0xdd:
+		if n = int(c & 0x0f); c&0xf0 != 0x90 {
+			if n, err = length(2 << (c - 0xdc)); err != nil {
+				return nil, err
+			}
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = decodeMsgpack(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	case c&0xf0 == 0x80, c == 0xde, c == 0xdf:
+		if n = int(c & 0x0f); c&0xf0 != 0x80 {
+			if n, err = length(2 << (c - 0xde)); err != nil {
+				return nil, err
+			}
+		}
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, err := decodeMsgpack(r)
+			if err != nil {
+				return nil, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, errors.New("msgpack: the keys of the maps are not strings")
+			}
+			if m[k], err = decodeMsgpack(r); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case c == 0xd7:
+		buf, err := readN(9)
+		if err != nil || buf[0] != 0 {
+			return nil, fmt.Errorf("msgpack: unexpected ext %v", err)
+		}
+		return time.Unix(int64(binary.BigEndian.Uint32(buf[1:5])), int64(binary.BigEndian.Uint32(buf[5:]))), nil
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", c)
+}
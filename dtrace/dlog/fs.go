@@ -0,0 +1,49 @@
+package dlog
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// File is a file opened by the FS
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	Sync() error
+}
+
+// FS is the file system the FileBackend writes, rotates and removes the files on,
+// it's the os one by default, and NewMemFS for the tests not touching the disk
+type FS interface {
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Rename(oldpath, newpath string) error
+	Stat(name string) (os.FileInfo, error)
+	// ReadDir returns the entries of dir sorted by name
+	ReadDir(dir string) ([]os.FileInfo, error)
+	Remove(name string) error
+	MkdirAll(dir string, perm os.FileMode) error
+	Symlink(oldname, newname string) error
+}
+
+// OSFS is the file system of the os
+var OSFS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (osFS) Rename(oldpath, newpath string) error        { return os.Rename(oldpath, newpath) }
+func (osFS) Stat(name string) (os.FileInfo, error)       { return os.Stat(name) }
+func (osFS) ReadDir(dir string) ([]os.FileInfo, error)   { return ioutil.ReadDir(dir) }
+func (osFS) Remove(name string) error                    { return os.Remove(name) }
+func (osFS) MkdirAll(dir string, perm os.FileMode) error { return os.MkdirAll(dir, perm) }
+func (osFS) Symlink(oldname, newname string) error       { return os.Symlink(oldname, newname) }
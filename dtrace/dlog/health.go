@@ -0,0 +1,176 @@
+package dlog
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Health is the state of the logging pipeline of a logger, summed over its backends
+type Health struct {
+	Healthy   bool
+	Queued    int64     // the logs queued by the async, syslog, network and kafka backends
+	Dropped   uint64    // the logs dropped as their queues are full
+	LastWrite time.Time // the latest write of the queued logs, or flush of the files
+	Stalled   []string  // the backends stuck, like "file /var/log/app"
+}
+
+// backendHealth is reported by the backends queuing or buffering the logs
+type backendHealth struct {
+	name      string
+	queued    int64
+	dropped   uint64
+	lastWrite time.Time
+	stalled   bool
+}
+
+type healthReporter interface {
+	health(stall time.Duration) backendHealth
+}
+
+// stallTimeout is how long the queued logs may wait for a write, or the files for a flush, before
+// the backend is considered stuck, in nanoseconds
+var stallTimeout int64 = int64(30 * time.Second)
+
+// SetStallTimeout sets how long the queued logs may wait before the pipeline is unhealthy, 30s by default.
+// It should be longer than the flush interval of the files.
+func SetStallTimeout(d time.Duration) {
+	atomic.StoreInt64(&stallTimeout, int64(d))
+}
+
+// queueHealth is the health of a queue with the time of the last write
+func queueHealth(name string, pending int64, dropped uint64, lastWrite int64, stall time.Duration) backendHealth {
+	last := time.Unix(0, lastWrite)
+	return backendHealth{
+		name:      name,
+		queued:    pending,
+		dropped:   dropped,
+		lastWrite: last,
+		stalled:   pending > 0 && time.Since(last) > stall,
+	}
+}
+
+func (self *asyncBackend) health(stall time.Duration) backendHealth {
+	return queueHealth("async", atomic.LoadInt64(&self.pending), atomic.LoadUint64(&self.dropped), atomic.LoadInt64(&self.lastWrite), stall)
+}
+
+func (self *networkBackend) health(stall time.Duration) backendHealth {
+	return queueHealth("network "+self.network+"://"+self.addr, atomic.LoadInt64(&self.pending), atomic.LoadUint64(&self.dropped), atomic.LoadInt64(&self.lastWrite), stall)
+}
+
+// health of the files is by the flush daemon, which is blocked if the disk hangs
+func (self *FileBackend) health(stall time.Duration) backendHealth {
+	last := time.Unix(0, atomic.LoadInt64(&self.lastFlush))
+	return backendHealth{name: "file " + self.dir, lastWrite: last, stalled: time.Since(last) > stall}
+}
+
+// Health returns the health of the backends of the logger
+func (l *Logger) Health() Health {
+	stall := time.Duration(atomic.LoadInt64(&stallTimeout))
+	var h Health
+	walkBackends(l.config().backend, func(be Backend) {
+		r, ok := be.(healthReporter)
+		if !ok {
+			return
+		}
+		bh := r.health(stall)
+		h.Queued += bh.queued
+		h.Dropped += bh.dropped
+		if bh.lastWrite.After(h.LastWrite) {
+			h.LastWrite = bh.lastWrite
+		}
+		if bh.stalled {
+			h.Stalled = append(h.Stalled, bh.name)
+		}
+	})
+	h.Healthy = len(h.Stalled) == 0
+	return h
+}
+
+// IsHealthy tells whether the logging pipeline is moving, for the readiness checks to mark the instance
+// unready when the logs are stuck, like the disk hangs or the log agent is down for long
+func (l *Logger) IsHealthy() bool {
+	return l.Health().Healthy
+}
+
+// walkBackends calls fn with be and the backends it routes to
+func walkBackends(be Backend, fn func(be Backend)) {
+	if be == nil {
+		return
+	}
+	fn(be)
+	switch b := be.(type) {
+	case *levelBackend:
+		walkBackends(b.backend, fn)
+	case *asyncBackend:
+		walkBackends(b.backend, fn)
+	case *jsonBackend:
+		walkBackends(b.backend, fn)
+	case *binaryBackend:
+		walkBackends(b.backend, fn)
+	case *redactBackend:
+		walkBackends(b.backend, fn)
+	case *sampleBackend:
+		walkBackends(b.backend, fn)
+	case *kafkaSink:
+		walkBackends(b.cfg.Fallback, fn)
+	case *failoverBackend:
+		walkBackends(b.primary, fn)
+		walkBackends(b.fallback, fn)
+	case *multiBackend:
+		for _, sub := range b.backends() {
+			walkBackends(sub, fn)
+		}
+	}
+}
+
+// String formats the health like the heartbeat logs
+func (h Health) String() string {
+	s := fmt.Sprintf("healthy=%t queued=%d dropped=%d last_write=%s", h.Healthy, h.Queued, h.Dropped, h.LastWrite.Format(time.RFC3339))
+	if len(h.Stalled) > 0 {
+		s += " stalled=[" + strings.Join(h.Stalled, ", ") + "]"
+	}
+	return s
+}
+
+// StartHeartbeat logs the health of the logger every interval to out, like a separate file watched by
+// the monitoring, or by the logger itself if out is nil, so a silent pipeline is told from a quiet service.
+// The heartbeat is stopped by the returned function.
+func (l *Logger) StartHeartbeat(interval time.Duration, out Backend) (stop func()) {
+	hb := l
+	if out != nil {
+		hb = NewLogger(INFO, out)
+	}
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if h := l.Health(); h.Healthy {
+					hb.Infof("dlog heartbeat: %s", h)
+				} else {
+					hb.Warningf("dlog heartbeat: %s", h)
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+	var stopped int32
+	return func() {
+		if atomic.CompareAndSwapInt32(&stopped, 0, 1) {
+			close(quit)
+		}
+	}
+}
+
+func IsHealthy() bool {
+	return logging.IsHealthy()
+}
+
+func StartHeartbeat(interval time.Duration, out Backend) (stop func()) {
+	return logging.StartHeartbeat(interval, out)
+}
@@ -0,0 +1,257 @@
+package dlog
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// jsonTimeLayout is of the time of the JSON logs, RFC 3339 in microseconds like the text logs
+const jsonTimeLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+// JSONConfig is the config of NewJSONBackend, the zero one uses the defaults
+type JSONConfig struct {
+	// the keys of the fields from the header and of the rest of the text, time, level, caller and _msg by default
+	TimeKey    string
+	LevelKey   string
+	CallerKey  string
+	MessageKey string
+	// TagsKey nests the tags in an object of it, like {"tags":{"tid":"3f2a"}}, they are promoted to the
+	// top level if it's empty
+	TagsKey    string
+	SortFields bool // sort the tags by the keys, rather than their order in the log
+}
+
+type jsonBackend struct {
+	backend Backend
+	cfg     JSONConfig
+}
+
+// NewJSONBackend writes the logs to backend as the JSON objects, a line per log, for the structured
+// ingestion like ELK. The log
+//
+//	2016-07-11 14:30:10.000000 INFO api/order.go:42 tname=[create] tid=[3f2a] event=[order] created 2 items
+//
+// is written as
+//
+//	{"time":"2016-07-11T14:30:10.000000+08:00","level":"INFO","caller":"api/order.go:42","tname":"create","tid":"3f2a","event":"order","_msg":"created 2 items"}
+//
+// The tags of key=[value] are promoted to the fields in their order, the rest of the text is _msg. The
+// repeated tags and the tags named like time, level, caller and _msg are left in _msg. All the values are
+// strings, so a tag is of the same type in all the logs. The keys and the layout are set by cfg for the
+// pipelines parsing the logs.
+func NewJSONBackend(backend Backend, cfg JSONConfig) Backend {
+	return &jsonBackend{backend: backend, cfg: cfg.withDefaults()}
+}
+
+func (cfg JSONConfig) withDefaults() JSONConfig {
+	for key, value := range map[*string]string{&cfg.TimeKey: "time", &cfg.LevelKey: "level", &cfg.CallerKey: "caller", &cfg.MessageKey: "_msg"} {
+		if *key == "" {
+			*key = value
+		}
+	}
+	return cfg
+}
+
+var jsonBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func (self *jsonBackend) Log(s Severity, msg []byte) {
+	buf := jsonBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	encodeJSON(buf, self.cfg, s, msg)
+	self.backend.Log(s, buf.Bytes())
+	jsonBuffers.Put(buf)
+}
+
+func (self *jsonBackend) logBatch(entries []asyncEntry) {
+	b, ok := self.backend.(batchLogger)
+	if !ok {
+		for _, e := range entries {
+			self.Log(e.s, e.msg)
+		}
+		return
+	}
+	encoded := make([]asyncEntry, len(entries))
+	for i, e := range entries {
+		var buf bytes.Buffer
+		encodeJSON(&buf, self.cfg, e.s, e.msg)
+		encoded[i] = asyncEntry{e.s, buf.Bytes()}
+	}
+	b.logBatch(encoded)
+}
+
+func (self *jsonBackend) sync(ctx context.Context) error {
+	if s, ok := self.backend.(syncer); ok {
+		return s.sync(ctx)
+	}
+	return nil
+}
+
+func (self *jsonBackend) close() {
+	self.backend.close()
+}
+
+// encodeJSON writes the log line msg of severity s as a JSON object to buf
+func encodeJSON(buf *bytes.Buffer, cfg JSONConfig, s Severity, msg []byte) {
+	msg = bytes.TrimRight(msg, "\n")
+	var t time.Time
+	var caller []byte
+	if len(msg) >= dlogHeaderLen && msg[dlogHeaderLen-1] == ' ' {
+		if parsed, err := time.ParseInLocation("2006-01-02 15:04:05.000000", string(msg[:dlogHeaderLen-1]), time.Local); err == nil {
+			t, msg = parsed, msg[dlogHeaderLen:]
+			// the severity name and the caller follow the time
+			msg = bytes.TrimPrefix(msg, []byte(severityName[s]+" "))
+			word, rest := cutWord(msg)
+			if bytes.IndexByte(word, ':') > 0 {
+				caller, msg = word, rest
+			}
+		}
+	}
+
+	var tags [][2]string
+	var text []string
+	seen := map[string]bool{}
+	if cfg.TagsKey == "" {
+		seen = map[string]bool{cfg.TimeKey: true, cfg.LevelKey: true, cfg.CallerKey: true, cfg.MessageKey: true}
+	}
+	for len(msg) > 0 {
+		if key, value, rest, ok := cutTag(msg); ok && !seen[key] {
+			seen[key] = true
+			tags = append(tags, [2]string{key, value})
+			msg = rest
+			continue
+		}
+		var word []byte
+		word, msg = cutWord(msg)
+		text = append(text, string(word))
+	}
+	if cfg.SortFields {
+		sort.Slice(tags, func(i, j int) bool { return tags[i][0] < tags[j][0] })
+	}
+
+	buf.WriteByte('{')
+	if !t.IsZero() {
+		writeJSONKey(buf, cfg.TimeKey)
+		writeJSONString(buf, t.Format(jsonTimeLayout))
+		buf.WriteByte(',')
+	}
+	writeJSONKey(buf, cfg.LevelKey)
+	writeJSONString(buf, severityName[s])
+	if caller != nil {
+		buf.WriteByte(',')
+		writeJSONKey(buf, cfg.CallerKey)
+		writeJSONString(buf, string(caller))
+	}
+	if cfg.TagsKey != "" {
+		buf.WriteByte(',')
+		writeJSONKey(buf, cfg.TagsKey)
+		buf.WriteByte('{')
+	}
+	for i, tag := range tags {
+		if i > 0 || cfg.TagsKey == "" {
+			buf.WriteByte(',')
+		}
+		writeJSONKey(buf, tag[0])
+		writeJSONString(buf, tag[1])
+	}
+	if cfg.TagsKey != "" {
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(',')
+	writeJSONKey(buf, cfg.MessageKey)
+	writeJSONString(buf, strings.Join(text, " "))
+	buf.WriteString("}\n")
+}
+
+func writeJSONKey(buf *bytes.Buffer, key string) {
+	writeJSONString(buf, key)
+	buf.WriteByte(':')
+}
+
+// cutWord returns the text before the first space, and after it
+func cutWord(msg []byte) (word, rest []byte) {
+	if i := bytes.IndexByte(msg, ' '); i >= 0 {
+		return msg[:i], msg[i+1:]
+	}
+	return msg, nil
+}
+
+// cutTag parses the tag key=[value] at the start of msg, the value may contain the spaces, it ends by
+// "] " or at the end. rest is after the tag and the space.
+func cutTag(msg []byte) (key, value string, rest []byte, ok bool) {
+	k, v, rest, ok := cutTagBytes(msg)
+	return string(k), string(v), rest, ok
+}
+
+// cutTagBytes is cutTag without copying the key and the value
+func cutTagBytes(msg []byte) (key, value, rest []byte, ok bool) {
+	i := bytes.Index(msg, []byte("=["))
+	if i <= 0 || !isTagKey(msg[:i]) {
+		return nil, nil, msg, false
+	}
+	body := msg[i+2:]
+	for j := 0; j < len(body); j++ {
+		if body[j] == ']' && (j+1 == len(body) || body[j+1] == ' ') {
+			if j+2 <= len(body) {
+				rest = body[j+2:]
+			}
+			return msg[:i], body[:j], rest, true
+		}
+	}
+	return nil, nil, msg, false
+}
+
+func isTagKey(key []byte) bool {
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// writeJSONString writes s quoted as a JSON string, the invalid UTF-8 is replaced by U+FFFD
+func writeJSONString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	buf.WriteByte('"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				buf.WriteByte('\\')
+				buf.WriteByte(c)
+			case c == '\n':
+				buf.WriteString(`\n`)
+			case c == '\r':
+				buf.WriteString(`\r`)
+			case c == '\t':
+				buf.WriteString(`\t`)
+			case c < 0x20 || c == 0x7f:
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[c>>4])
+				buf.WriteByte(hex[c&0xf])
+			default:
+				buf.WriteByte(c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf.WriteString(`\ufffd`)
+		} else if r == '\u2028' || r == '\u2029' {
+			// valid JSON, but not of javascript
+			buf.WriteString(`\u202`)
+			buf.WriteByte(hex[r&0xf])
+		} else {
+			buf.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	buf.WriteByte('"')
+}
@@ -0,0 +1,186 @@
+package dlog
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KafkaProducer publishes the logs to kafka, it's implemented by wrapping a kafka client like sarama,
+// which is not a dependency of dlog
+type KafkaProducer interface {
+	// Produce publishes the messages to the topic, and returns after they are acknowledged or failed
+	Produce(ctx context.Context, topic string, msgs [][]byte) error
+	Close() error
+}
+
+// KafkaDialer creates the producer of cfg, honoring the brokers and the compression of it
+type KafkaDialer func(cfg KafkaConfig) (KafkaProducer, error)
+
+// KafkaConfig is the config of NewKafkaBackend
+type KafkaConfig struct {
+	Brokers     []string
+	Topic       string
+	Compression string // of the producer, like gzip, snappy, lz4 or zstd, the producer's default if empty
+	// the batching of the logs, the defaults of NewAsyncBackend if zero
+	QueueSize int
+	BatchSize int
+	Linger    time.Duration
+	Overflow  OverflowPolicy
+	Timeout   time.Duration // of publishing a batch, 10s by default
+	// Fallback is written with the logs failed to publish, like a FileBackend, they go to stderr if it's nil
+	Fallback Backend
+}
+
+var kafkaDialer struct {
+	sync.Mutex
+	dial KafkaDialer
+}
+
+// SetKafkaDialer sets the dialer creating the producers of NewKafkaBackend and the kafka:// outputs
+func SetKafkaDialer(d KafkaDialer) {
+	kafkaDialer.Lock()
+	defer kafkaDialer.Unlock()
+	kafkaDialer.dial = d
+}
+
+type kafkaSink struct {
+	producer KafkaProducer
+	cfg      KafkaConfig
+}
+
+// NewKafkaBackend publishes the logs to the topic of cfg in batches by the background goroutines,
+// the logs failed to publish are written to the fallback, so they are not lost while kafka is down.
+// The producer is created by the dialer of SetKafkaDialer, the fallback is closed if the dialing fails.
+func NewKafkaBackend(cfg KafkaConfig) (*asyncBackend, error) {
+	if len(cfg.Brokers) == 0 || cfg.Topic == "" {
+		return nil, fmt.Errorf("kafka: no brokers or topic")
+	}
+	kafkaDialer.Lock()
+	dial := kafkaDialer.dial
+	kafkaDialer.Unlock()
+	var producer KafkaProducer
+	err := fmt.Errorf("no dialer, set one by SetKafkaDialer")
+	if dial != nil {
+		producer, err = dial(cfg)
+	}
+	if err != nil {
+		if cfg.Fallback != nil {
+			cfg.Fallback.close()
+		}
+		return nil, fmt.Errorf("kafka: %s", err)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	sink := &kafkaSink{producer: producer, cfg: cfg}
+	return NewAsyncBackend(sink, AsyncConfig{
+		QueueSize: cfg.QueueSize,
+		BatchSize: cfg.BatchSize,
+		Linger:    cfg.Linger,
+		Overflow:  cfg.Overflow,
+	}), nil
+}
+
+func (self *kafkaSink) Log(s Severity, msg []byte) {
+	self.logBatch([]asyncEntry{{s, msg}})
+}
+
+func (self *kafkaSink) logBatch(entries []asyncEntry) {
+	msgs := make([][]byte, len(entries))
+	for i, e := range entries {
+		msgs[i] = e.msg
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), self.cfg.Timeout)
+	defer cancel()
+	err := self.producer.Produce(ctx, self.cfg.Topic, msgs)
+	if err == nil {
+		return
+	}
+	if self.cfg.Fallback == nil {
+		fmt.Fprintf(os.Stderr, "dlog: publish %d logs to kafka topic %s failed: %s\n", len(msgs), self.cfg.Topic, err)
+		for _, msg := range msgs {
+			os.Stderr.Write(msg)
+		}
+		return
+	}
+	if b, ok := self.cfg.Fallback.(batchLogger); ok {
+		b.logBatch(entries)
+		return
+	}
+	for _, e := range entries {
+		self.cfg.Fallback.Log(e.s, e.msg)
+	}
+}
+
+func (self *kafkaSink) sync(ctx context.Context) error {
+	if s, ok := self.cfg.Fallback.(syncer); ok {
+		return s.sync(ctx)
+	}
+	return nil
+}
+
+func (self *kafkaSink) close() {
+	self.producer.Close()
+	if self.cfg.Fallback != nil {
+		self.cfg.Fallback.close()
+	}
+}
+
+// parseKafkaOutput parses the output like kafka://broker1:9092,broker2:9092/topic?compression=zstd,
+// the query may also set batch, linger, queue, overflow and fallback, the dir of the fallback files
+func parseKafkaOutput(addr string, newFile func(dir string) (*FileBackend, error)) (KafkaConfig, error) {
+	var cfg KafkaConfig
+	rest := strings.TrimPrefix(addr, "kafka://")
+	rest, rawQuery := splitOnce(rest, "?")
+	brokers, topic := splitOnce(rest, "/")
+	for _, broker := range strings.Split(brokers, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			cfg.Brokers = append(cfg.Brokers, broker)
+		}
+	}
+	cfg.Topic = topic
+	if len(cfg.Brokers) == 0 || cfg.Topic == "" || strings.Contains(topic, "/") {
+		return cfg, fmt.Errorf("expect kafka://brokers/topic")
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Compression = query.Get("compression")
+	for key, n := range map[string]*int{"batch": &cfg.BatchSize, "queue": &cfg.QueueSize} {
+		if v := query.Get(key); v != "" {
+			if *n, err = strconv.Atoi(v); err != nil {
+				return cfg, fmt.Errorf("invalid %s: %s", key, v)
+			}
+		}
+	}
+	if v := query.Get("linger"); v != "" {
+		if cfg.Linger, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("invalid linger: %s", v)
+		}
+	}
+	if cfg.Overflow, err = ParseOverflowPolicy(query.Get("overflow")); err != nil {
+		return cfg, err
+	}
+	if dir := query.Get("fallback"); dir != "" {
+		fb, err := newFile(dir)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Fallback = fb
+	}
+	return cfg, nil
+}
+
+func splitOnce(s, sep string) (string, string) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):]
+	}
+	return s, ""
+}
@@ -0,0 +1,66 @@
+package dlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SetSymlinkLatest keeps n symlinks per severity in the log dir pointing to the latest rotated files,
+// like INFO.log.latest.1 to the most recent one, so tailing the previous file doesn't need to find it.
+// They are refreshed after every rotation, 0 disables it and the existing links are left as is.
+func (self *FileBackend) SetSymlinkLatest(n int) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.symlinkLatest = n
+}
+
+func SetSymlinkLatest(n int) {
+	if fileback := getFileBackend(); fileback != nil {
+		fileback.SetSymlinkLatest(n)
+	}
+}
+
+func latestName(dir, severity string, i int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s.log.latest.%d", severity, i))
+}
+
+// linkLatest points the latest links to the newest n backups of every severity, the links beyond
+// the count of the backups are removed
+func (self *FileBackend) linkLatest(n int) {
+	backups, err := self.Backups()
+	if err != nil {
+		self.reportError(fmt.Errorf("list rotated files of %s failed: %s", self.dir, err))
+		return
+	}
+	bySeverity := map[string][]BackupInfo{}
+	for _, b := range backups {
+		bySeverity[b.Severity] = append(bySeverity[b.Severity], b)
+	}
+	for _, severity := range severityName {
+		files := bySeverity[severity]
+		for i := 1; i <= n; i++ {
+			link := latestName(self.dir, severity, i)
+			if i > len(files) {
+				if err := self.fs.Remove(link); err != nil && !os.IsNotExist(err) {
+					self.reportError(fmt.Errorf("remove %s failed: %s", link, err))
+				}
+				continue
+			}
+			target := files[i-1].Path
+			if rel, err := filepath.Rel(self.dir, target); err == nil {
+				target = rel
+			}
+			// replace the link atomically, so it's never missing for the readers
+			tmp := link + ".tmp"
+			self.fs.Remove(tmp)
+			if err := self.fs.Symlink(target, tmp); err != nil {
+				self.reportError(fmt.Errorf("link %s failed: %s", link, err))
+				continue
+			}
+			if err := self.fs.Rename(tmp, link); err != nil {
+				self.reportError(fmt.Errorf("link %s failed: %s", link, err))
+			}
+		}
+	}
+}
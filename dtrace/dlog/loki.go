@@ -0,0 +1,271 @@
+package dlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// LokiConfig is the config of NewLokiBackend
+type LokiConfig struct {
+	URL    string            // of the push API, like http://loki:3100/loki/api/v1/push
+	Module string            // the label module, to tell the logs of the modules apart
+	Labels map[string]string // the static labels, like the env
+	// FieldLabels are the tags of the fields in the logs made the labels, like "event" of key=[value],
+	// keep them of low cardinality, the ids and the paths with ids make too many streams
+	FieldLabels []string
+	Header      http.Header // like the Authorization, or the X-Scope-OrgID of the tenant
+	Client      *http.Client
+	// Format of the lines, text or json of NewJSONBackend for the json parser of LogQL, text by default
+	Format string
+	JSON   JSONConfig
+	// MaxBatchBytes splits the batches into the pushes of the logs up to it, 1MB by default, so a push
+	// is within the limits of loki and the memory of encoding it is bounded
+	MaxBatchBytes int
+	// a batch is pushed up to Retries+1 times with the backoff doubling from Backoff, 3 and 500ms by default,
+	// and dropped after that, so an outage of loki fills the queue instead of blocking the logging
+	Retries int
+	Backoff time.Duration
+	// the batching of the logs, the defaults of NewAsyncBackend if zero
+	QueueSize int
+	BatchSize int
+	Linger    time.Duration
+	Overflow  OverflowPolicy
+}
+
+type lokiSink struct {
+	failed    uint64 // the logs dropped as the pushes failed, accessed atomically
+	lastWrite int64  // in unix nanoseconds, accessed atomically
+	cfg       LokiConfig
+	labels    map[string]string
+	fields    map[string]*regexp.Regexp
+}
+
+// NewLokiBackend pushes the logs to grafana loki in batches by the background goroutines, labeled by
+// the module, the level, the host, the static labels and the FieldLabels found in the logs
+func NewLokiBackend(cfg LokiConfig) (*asyncBackend, error) {
+	if u, err := url.Parse(cfg.URL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid loki url %q", cfg.URL)
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.Retries <= 0 {
+		cfg.Retries = 3
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBatchBytes <= 0 {
+		cfg.MaxBatchBytes = 1 << 20
+	}
+	switch cfg.Format {
+	case "", "text":
+	case "json":
+		cfg.JSON = cfg.JSON.withDefaults()
+	default:
+		return nil, fmt.Errorf("unknown loki format: %s", cfg.Format)
+	}
+	sink := &lokiSink{cfg: cfg, labels: map[string]string{}, fields: map[string]*regexp.Regexp{}, lastWrite: time.Now().UnixNano()}
+	if hostname, err := os.Hostname(); err == nil {
+		sink.labels["host"] = hostname
+	}
+	if cfg.Module != "" {
+		sink.labels["module"] = cfg.Module
+	}
+	for k, v := range cfg.Labels {
+		sink.labels[k] = v
+	}
+	for _, tag := range cfg.FieldLabels {
+		sink.fields[tag] = regexp.MustCompile(`(?:^| )` + regexp.QuoteMeta(tag) + `=\[([^\]]*)\]`)
+	}
+	return NewAsyncBackend(sink, AsyncConfig{
+		QueueSize: cfg.QueueSize,
+		BatchSize: cfg.BatchSize,
+		Linger:    cfg.Linger,
+		Overflow:  cfg.Overflow,
+	}), nil
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// labelNameReplacer converts the tags like http.method to the label names, which are [a-zA-Z_][a-zA-Z0-9_]*
+var labelNameReplacer = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+func (self *lokiSink) Log(s Severity, msg []byte) {
+	self.logBatch([]asyncEntry{{s, msg}})
+}
+
+func (self *lokiSink) logBatch(entries []asyncEntry) {
+	for start, size := 0, 0; start < len(entries); {
+		end := start
+		for size = 0; end < len(entries) && (end == start || size+len(entries[end].msg) <= self.cfg.MaxBatchBytes); end++ {
+			size += len(entries[end].msg)
+		}
+		self.pushBatch(entries[start:end])
+		start = end
+	}
+}
+
+// pushBatch pushes the entries grouped into the streams by the labels
+func (self *lokiSink) pushBatch(entries []asyncEntry) {
+	streams := map[string]*lokiStream{}
+	var keys []string
+	for _, e := range entries {
+		labels := map[string]string{"level": strings.ToLower(e.s.String())}
+		for k, v := range self.labels {
+			labels[k] = v
+		}
+		line := body(e.msg)
+		for tag, reg := range self.fields {
+			if m := reg.FindSubmatch(line); m != nil {
+				labels[labelNameReplacer.ReplaceAllString(tag, "_")] = string(m[1])
+			}
+		}
+		key := streamKey(labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: labels}
+			streams[key] = stream
+			keys = append(keys, key)
+		}
+		if self.cfg.Format == "json" {
+			var buf bytes.Buffer
+			encodeJSON(&buf, self.cfg.JSON, e.s, e.msg)
+			line = bytes.TrimRight(buf.Bytes(), "\n")
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(logTime(e.msg).UnixNano(), 10), string(line)})
+	}
+	push := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, key := range keys {
+		push.Streams = append(push.Streams, streams[key])
+	}
+	data, _ := json.Marshal(push)
+
+	backoff := self.cfg.Backoff
+	var err error
+	for i := 0; i <= self.cfg.Retries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = self.push(data); err == nil {
+			atomic.StoreInt64(&self.lastWrite, time.Now().UnixNano())
+			return
+		}
+	}
+	atomic.AddUint64(&self.failed, uint64(len(entries)))
+	fmt.Fprintf(os.Stderr, "dlog: push %d logs to loki failed, dropped: %s\n", len(entries), err)
+}
+
+func (self *lokiSink) push(data []byte) error {
+	req, err := http.NewRequestWithContext(context.Background(), "POST", self.cfg.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, v := range self.cfg.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := self.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+}
+
+func (self *lokiSink) health(stall time.Duration) backendHealth {
+	return backendHealth{
+		name:      "loki " + self.cfg.URL,
+		dropped:   atomic.LoadUint64(&self.failed),
+		lastWrite: time.Unix(0, atomic.LoadInt64(&self.lastWrite)),
+	}
+}
+
+func (self *lokiSink) close() {}
+
+// streamKey identifies the stream of the labels
+func streamKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k + "=" + strconv.Quote(labels[k]) + ",")
+	}
+	return b.String()
+}
+
+// logTime parses the time of the log line, or now if it's not of the dlog format
+func logTime(msg []byte) time.Time {
+	if len(msg) >= dlogHeaderLen {
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05.000000", string(msg[:dlogHeaderLen-1]), time.Local); err == nil {
+			return t
+		}
+	}
+	return time.Now()
+}
+
+// parseLokiOutput parses the output like loki+http://loki:3100?module=orders&fields=event,
+// the path is /loki/api/v1/push by default, the query may also set format, batch, linger, queue and overflow
+func parseLokiOutput(addr string) (LokiConfig, error) {
+	var cfg LokiConfig
+	u, err := url.Parse(addr)
+	if err != nil {
+		return cfg, err
+	}
+	query := u.Query()
+	u.Scheme, u.RawQuery = strings.TrimPrefix(u.Scheme, "loki+"), ""
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/loki/api/v1/push"
+	}
+	cfg.URL = u.String()
+	cfg.Module = query.Get("module")
+	cfg.Format = query.Get("format")
+	for _, tag := range strings.Split(query.Get("fields"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			cfg.FieldLabels = append(cfg.FieldLabels, tag)
+		}
+	}
+	for key, n := range map[string]*int{"batch": &cfg.BatchSize, "queue": &cfg.QueueSize} {
+		if v := query.Get(key); v != "" {
+			if *n, err = strconv.Atoi(v); err != nil {
+				return cfg, fmt.Errorf("invalid %s: %s", key, v)
+			}
+		}
+	}
+	if v := query.Get("linger"); v != "" {
+		if cfg.Linger, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("invalid linger: %s", v)
+		}
+	}
+	if cfg.Overflow, err = ParseOverflowPolicy(query.Get("overflow")); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
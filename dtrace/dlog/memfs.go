@@ -0,0 +1,235 @@
+package dlog
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memFS keeps the files in memory, the open files keep working after they are renamed or removed like on unix
+type memFS struct {
+	mu    sync.Mutex
+	files map[string]*memNode
+	dirs  map[string]bool
+	links map[string]string
+}
+
+type memNode struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemFS creates an empty in-memory FS, for the fast and parallel tests of the rotation and the retention
+func NewMemFS() FS {
+	return &memFS{files: map[string]*memNode{}, dirs: map[string]bool{"/": true, ".": true}, links: map[string]string{}}
+}
+
+// resolve follows the symlink name, the relative targets are relative to the dir of the link
+func (fs *memFS) resolve(name string) string {
+	target, ok := fs.links[name]
+	if !ok {
+		return name
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(name), target)
+	}
+	return filepath.Clean(target)
+}
+
+func (fs *memFS) Open(name string) (File, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (fs *memFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	name = fs.resolve(filepath.Clean(name))
+	node, ok := fs.files[name]
+	if ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		if !fs.dirs[filepath.Dir(name)] {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		node = &memNode{modTime: time.Now()}
+		fs.files[name] = node
+	}
+	if flag&os.O_TRUNC != 0 {
+		node.data = nil
+	}
+	return &memFile{fs: fs, node: node, append: flag&os.O_APPEND != 0}, nil
+}
+
+func (fs *memFS) Rename(oldpath, newpath string) error {
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if !fs.dirs[filepath.Dir(newpath)] {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	if target, ok := fs.links[oldpath]; ok {
+		delete(fs.files, newpath)
+		delete(fs.links, oldpath)
+		fs.links[newpath] = target
+		return nil
+	}
+	node, ok := fs.files[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	delete(fs.links, newpath)
+	delete(fs.files, oldpath)
+	fs.files[newpath] = node
+	return nil
+}
+
+func (fs *memFS) Stat(name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	name = fs.resolve(filepath.Clean(name))
+	if node, ok := fs.files[name]; ok {
+		return memInfo{name: filepath.Base(name), size: int64(len(node.data)), modTime: node.modTime}, nil
+	}
+	if fs.dirs[name] {
+		return memInfo{name: filepath.Base(name), dir: true}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (fs *memFS) ReadDir(dir string) ([]os.FileInfo, error) {
+	dir = filepath.Clean(dir)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if !fs.dirs[dir] {
+		return nil, &os.PathError{Op: "open", Path: dir, Err: os.ErrNotExist}
+	}
+	var infos []os.FileInfo
+	for name, node := range fs.files {
+		if filepath.Dir(name) == dir {
+			infos = append(infos, memInfo{name: filepath.Base(name), size: int64(len(node.data)), modTime: node.modTime})
+		}
+	}
+	for name := range fs.links {
+		if filepath.Dir(name) == dir {
+			infos = append(infos, memInfo{name: filepath.Base(name), link: true})
+		}
+	}
+	for name := range fs.dirs {
+		if name != dir && filepath.Dir(name) == dir {
+			infos = append(infos, memInfo{name: filepath.Base(name), dir: true})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (fs *memFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.links[name]; ok {
+		delete(fs.links, name)
+		return nil
+	}
+	if _, ok := fs.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+func (fs *memFS) MkdirAll(dir string, perm os.FileMode) error {
+	dir = filepath.Clean(dir)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for ; !fs.dirs[dir]; dir = filepath.Dir(dir) {
+		if _, ok := fs.files[dir]; ok {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: os.ErrExist}
+		}
+		fs.dirs[dir] = true
+	}
+	return nil
+}
+
+func (fs *memFS) Symlink(oldname, newname string) error {
+	newname = filepath.Clean(newname)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, isFile := fs.files[newname]
+	_, isLink := fs.links[newname]
+	if isFile || isLink || fs.dirs[newname] {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	if !fs.dirs[filepath.Dir(newname)] {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	fs.links[newname] = oldname
+	return nil
+}
+
+type memFile struct {
+	fs     *memFS
+	node   *memNode
+	append bool
+	offset int
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.offset >= len(f.node.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.offset:])
+	f.offset += n
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.append {
+		f.offset = len(f.node.data)
+	}
+	if end := f.offset + len(p); end > len(f.node.data) {
+		f.node.data = append(f.node.data, make([]byte, end-len(f.node.data))...)
+	}
+	copy(f.node.data[f.offset:], p)
+	f.offset += len(p)
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Close() error { return nil }
+func (f *memFile) Sync() error  { return nil }
+
+type memInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+	link    bool
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.dir }
+func (i memInfo) Sys() interface{}   { return nil }
+
+func (i memInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	if i.link {
+		return os.ModeSymlink | 0777
+	}
+	return 0644
+}
@@ -1,23 +1,69 @@
 package dlog
 
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
 type multiBackend struct {
-	bes []Backend
+	bes atomic.Value // []Backend, replaced as a whole so the logging takes no lock
+	mu  sync.Mutex   // serializes Add and Remove
 }
 
 func NewMultiBackend(bes ...Backend) (*multiBackend, error) {
 	var b multiBackend
-	b.bes = bes
+	b.bes.Store(bes)
 	return &b, nil
 }
 
+func (self *multiBackend) backends() []Backend {
+	bes, _ := self.bes.Load().([]Backend)
+	return bes
+}
+
+// Add adds the backend at runtime
+func (self *multiBackend) Add(be Backend) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	old := self.backends()
+	bes := make([]Backend, 0, len(old)+1)
+	self.bes.Store(append(append(bes, old...), be))
+}
+
+// Remove removes the backend at runtime, it's not closed
+func (self *multiBackend) Remove(be Backend) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	var bes []Backend
+	for _, b := range self.backends() {
+		if b != be {
+			bes = append(bes, b)
+		}
+	}
+	self.bes.Store(bes)
+}
+
 func (self *multiBackend) Log(s Severity, msg []byte) {
-	for _, be := range self.bes {
+	for _, be := range self.backends() {
 		be.Log(s, msg)
 	}
 }
 
 func (self *multiBackend) close() {
-	for _, be := range self.bes {
+	for _, be := range self.backends() {
 		be.close()
 	}
 }
+
+func (self *multiBackend) sync(ctx context.Context) error {
+	var firstErr error
+	for _, be := range self.backends() {
+		if s, ok := be.(syncer); ok {
+			if err := s.sync(ctx); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
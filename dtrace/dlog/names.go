@@ -0,0 +1,99 @@
+package dlog
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// The rotated files are named like INFO.log.2016071114 by the hour with the hour they started,
+// INFO.log.201607111430 by the schedule with the minute they started, and INFO.log.001 by the size,
+// a suffix like _2 is appended if the name is taken, the extension of the compressor if compressed,
+// and .enc if encrypted.
+
+// backupReg matches the rotated files, compressed or not
+var backupReg = regexp.MustCompile(`^(INFO|ERROR|WARNING|DEBUG|FATAL)\.log\.([0-9]+)(?:_([1-9][0-9]{0,8}))?(?:\.([0-9a-z]+))?(\.enc)?$`)
+
+const hourTagLayout = "2006010215"
+
+// backupName is the parsed name of a rotated file
+type backupName struct {
+	severity  string
+	tag       string
+	seq       int    // of the duplicated names, 0 for the first one
+	ext       string // of the compressor, empty if not compressed
+	encrypted bool
+}
+
+func parseBackupName(name string) (backupName, bool) {
+	m := backupReg.FindStringSubmatch(name)
+	if m == nil {
+		return backupName{}, false
+	}
+	b := backupName{severity: m[1], tag: m[2], ext: m[4], encrypted: m[5] != ""}
+	if b.ext == "enc" && !b.encrypted {
+		// encrypted but not compressed
+		b.ext, b.encrypted = "", true
+	}
+	if m[3] != "" {
+		b.seq, _ = strconv.Atoi(m[3])
+	}
+	return b, true
+}
+
+// base returns the name without the extension of the compressor
+func (b backupName) base() string {
+	if b.seq == 0 {
+		return b.severity + ".log." + b.tag
+	}
+	return fmt.Sprintf("%s.log.%s_%d", b.severity, b.tag, b.seq)
+}
+
+// time returns the time the file started, false for the files rotated by the size
+func (b backupName) time() (time.Time, bool) {
+	for _, layout := range []string{scheduleTagLayout, hourTagLayout} {
+		if len(b.tag) != len(layout) {
+			continue
+		}
+		if t, err := time.ParseInLocation(layout, b.tag, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// uniqueBackupName returns rotated, or with a suffix like _2 if it's taken by a rotated file in the log dir
+// or the backup dir, compressed or not, so a restart within the hour doesn't overwrite the previous backups.
+// It's called with the lock held.
+func (self *FileBackend) uniqueBackupName(rotated string) string {
+	dirs := []string{self.dir}
+	if self.backupDir != "" && self.backupDir != self.dir {
+		dirs = append(dirs, self.backupDir)
+	}
+	return uniqueBackupName(self.fs, dirs, rotated)
+}
+
+func uniqueBackupName(fs FS, dirs []string, rotated string) string {
+	taken := map[string]bool{}
+	for _, dir := range dirs {
+		files, err := listBackups(fs, dir)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if b, ok := parseBackupName(filepath.Base(f.path)); ok {
+				taken[b.base()] = true
+			}
+		}
+	}
+	if !taken[filepath.Base(rotated)] {
+		return rotated
+	}
+	for seq := 2; ; seq++ {
+		if name := fmt.Sprintf("%s_%d", rotated, seq); !taken[filepath.Base(name)] {
+			return name
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package dlog
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NetworkConfig is the config of NewNetworkBackend, the zero one uses the defaults
+type NetworkConfig struct {
+	QueueSize    int           // the logs queued, 8192 by default
+	DialTimeout  time.Duration // 5s by default
+	WriteTimeout time.Duration // of writing a batch, the stalled connection is reconnected after it, 10s by default
+	// the backoff of reconnecting doubles from MinBackoff to MaxBackoff, 100ms and 30s by default
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	TLS        *tls.Config    // of the tls:// addresses, the default one verifies the host
+	Overflow   OverflowPolicy // of the full queue, the dropped logs are counted by Dropped
+}
+
+// networkBatchSize is the most logs written at once
+const networkBatchSize = 256
+
+type networkBackend struct {
+	pending   int64  // the logs queued or being written, accessed atomically
+	lastWrite int64  // in unix nanoseconds, accessed atomically
+	dropped   uint64 // accessed atomically
+	closed    int32
+	network   string
+	addr      string
+	cfg       NetworkConfig
+	frame     func(s Severity, msg []byte) []byte // formats the logs for the protocol, like syslog
+	queue     chan []byte
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// owned by the writing goroutine, conn is set with connMu held and closed by Close too
+	connMu sync.Mutex
+	conn   net.Conn
+	writer *bufio.Writer // of the stream connections
+}
+
+// NewNetworkBackend sends the logs to addr, like a local log agent, the address is a URL of tcp://host:port,
+// tls://host:port, udp://host:port, unix:///path/to/socket or unixgram:///path/to/socket. The logs are
+// queued and written by a background goroutine, which reconnects with a backoff when the connection is
+// broken, so a restarting agent doesn't block or lose the logs unless the queue is full. The logs being
+// written when the connection breaks are sent again, and may be duplicated.
+func NewNetworkBackend(addr string, cfg NetworkConfig) (*networkBackend, error) {
+	return newNetworkBackend(addr, cfg, nil)
+}
+
+// newNetworkBackend creates the backend sending the logs formatted by frame, a new slice is returned by it
+func newNetworkBackend(addr string, cfg NetworkConfig, frame func(s Severity, msg []byte) []byte) (*networkBackend, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network address %q: %s", addr, err)
+	}
+	b := &networkBackend{network: u.Scheme, addr: u.Host, frame: frame, lastWrite: time.Now().UnixNano()}
+	switch u.Scheme {
+	case "tcp", "tls", "udp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid network address %q: no host", addr)
+		}
+	case "unix", "unixgram":
+		if b.addr = u.Path; b.addr == "" {
+			return nil, fmt.Errorf("invalid network address %q: no path", addr)
+		}
+	default:
+		return nil, fmt.Errorf("invalid network address %q: unknown scheme", addr)
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 8192
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = 5 * time.Second
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 10 * time.Second
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+	b.cfg = cfg
+	b.queue = make(chan []byte, cfg.QueueSize)
+	b.quit = make(chan struct{})
+	b.done = make(chan struct{})
+	go b.run()
+	return b, nil
+}
+
+func (self *networkBackend) Log(s Severity, msg []byte) {
+	if atomic.LoadInt32(&self.closed) == 1 {
+		os.Stderr.Write(msg)
+		return
+	}
+	if self.frame != nil {
+		msg = self.frame(s, msg)
+	} else {
+		// the logger reuses msg after Log returns
+		msg = append([]byte(nil), msg...)
+	}
+	atomic.AddInt64(&self.pending, 1)
+	queued, evicted := enqueue(self.queue, msg, self.cfg.Overflow)
+	if evicted > 0 {
+		atomic.AddInt64(&self.pending, -int64(evicted))
+		atomic.AddUint64(&self.dropped, uint64(evicted))
+	}
+	if queued {
+		return
+	}
+	atomic.AddInt64(&self.pending, -1)
+	atomic.AddUint64(&self.dropped, 1)
+	if self.cfg.Overflow.Mode == OverflowStderr {
+		os.Stderr.Write(msg)
+	}
+}
+
+// Dropped returns the count of the logs dropped as the queue is full
+func (self *networkBackend) Dropped() uint64 {
+	return atomic.LoadUint64(&self.dropped)
+}
+
+// Flush waits until the queued logs are written to the connection, or ctx is done
+func (self *networkBackend) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&self.pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("flush %s://%s: %d logs not delivered: %s", self.network, self.addr, atomic.LoadInt64(&self.pending), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Close flushes the queued logs until ctx is done, and closes the connection, the write blocked
+// by a stalled agent is aborted. The logs after it go to stderr.
+func (self *networkBackend) Close(ctx context.Context) error {
+	atomic.StoreInt32(&self.closed, 1)
+	err := self.Flush(ctx)
+	self.closeOnce.Do(func() {
+		close(self.quit)
+		self.setConn(nil, nil)
+	})
+	select {
+	case <-self.done:
+	case <-ctx.Done():
+		if err == nil {
+			err = fmt.Errorf("close %s://%s: %s", self.network, self.addr, ctx.Err())
+		}
+	}
+	return err
+}
+
+// setConn closes the current connection and replaces it
+func (self *networkBackend) setConn(conn net.Conn, writer *bufio.Writer) {
+	self.connMu.Lock()
+	defer self.connMu.Unlock()
+	if self.conn != nil {
+		self.conn.Close()
+	}
+	self.conn, self.writer = conn, writer
+}
+
+func (self *networkBackend) sync(ctx context.Context) error {
+	return self.Flush(ctx)
+}
+
+func (self *networkBackend) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	self.Close(ctx)
+}
+
+func (self *networkBackend) run() {
+	defer close(self.done)
+	defer self.setConn(nil, nil)
+	batch := make([][]byte, 0, networkBatchSize)
+	for {
+		select {
+		case msg := <-self.queue:
+			batch = append(batch, msg)
+		case <-self.quit:
+			return
+		}
+	fill:
+		for len(batch) < networkBatchSize {
+			select {
+			case msg := <-self.queue:
+				batch = append(batch, msg)
+			default:
+				break fill
+			}
+		}
+		for !self.write(batch) {
+			if !self.reconnect() {
+				return
+			}
+		}
+		atomic.StoreInt64(&self.lastWrite, time.Now().UnixNano())
+		atomic.AddInt64(&self.pending, -int64(len(batch)))
+		for i := range batch {
+			batch[i] = nil
+		}
+		batch = batch[:0]
+	}
+}
+
+// write writes the batch to the connection in the write timeout, the broken connection is closed
+func (self *networkBackend) write(batch [][]byte) bool {
+	self.connMu.Lock()
+	conn, writer := self.conn, self.writer
+	self.connMu.Unlock()
+	if conn == nil {
+		return false
+	}
+	err := conn.SetWriteDeadline(time.Now().Add(self.cfg.WriteTimeout))
+	if err == nil && writer != nil {
+		for _, msg := range batch {
+			writer.Write(msg)
+		}
+		err = writer.Flush()
+	} else if err == nil {
+		// a datagram per log
+		for _, msg := range batch {
+			if _, err = conn.Write(msg); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		select {
+		case <-self.quit:
+			// closed by Close
+		default:
+			fmt.Fprintf(os.Stderr, "dlog: write %s://%s failed: %s\n", self.network, self.addr, err)
+		}
+		self.setConn(nil, nil)
+		return false
+	}
+	return true
+}
+
+// reconnect dials until it's connected, or false if the backend is closed
+func (self *networkBackend) reconnect() bool {
+	backoff := self.cfg.MinBackoff
+	for reported := false; ; reported = true {
+		select {
+		case <-self.quit:
+			return false
+		default:
+		}
+		conn, err := self.dial()
+		if err == nil {
+			var writer *bufio.Writer
+			if self.network != "udp" && self.network != "unixgram" {
+				writer = bufio.NewWriterSize(conn, 64<<10)
+			}
+			self.setConn(conn, writer)
+			return true
+		}
+		if !reported {
+			fmt.Fprintf(os.Stderr, "dlog: dial %s://%s failed, retrying: %s\n", self.network, self.addr, err)
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-self.quit:
+			timer.Stop()
+			return false
+		}
+		if backoff *= 2; backoff > self.cfg.MaxBackoff {
+			backoff = self.cfg.MaxBackoff
+		}
+	}
+}
+
+func (self *networkBackend) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: self.cfg.DialTimeout}
+	if self.network == "tls" {
+		return tls.DialWithDialer(dialer, "tcp", self.addr, self.cfg.TLS)
+	}
+	return dialer.Dial(self.network, self.addr)
+}
@@ -0,0 +1,91 @@
+package dlog
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OverflowMode tells what the syslog and the async backends do with a log when their queues are full
+type OverflowMode int
+
+const (
+	OverflowStderr     OverflowMode = iota // write the log to stderr instead, the default
+	OverflowDropNewest                     // drop the log
+	OverflowDropOldest                     // drop the oldest queued log to make room for it
+	OverflowBlock                          // wait for the room, up to the timeout if it's set
+)
+
+// OverflowPolicy is the policy of the full queues of the syslog and the async backends. The logs not
+// queued are counted as dropped, including the ones written to stderr. The syslog backend also sends
+// a summary like "dlog: 12 logs dropped" to syslog every 10 seconds while the logs are dropped.
+type OverflowPolicy struct {
+	Mode    OverflowMode
+	Timeout time.Duration // of OverflowBlock, the log is dropped after it, 0 waits forever
+}
+
+// ParseOverflowPolicy parses the policy, "" or "stderr" for the default one, "drop-newest",
+// "drop-oldest", "block", or "block=duration" like "block=50ms"
+func ParseOverflowPolicy(spec string) (OverflowPolicy, error) {
+	spec = strings.TrimSpace(spec)
+	switch spec {
+	case "", "stderr":
+		return OverflowPolicy{Mode: OverflowStderr}, nil
+	case "drop-newest":
+		return OverflowPolicy{Mode: OverflowDropNewest}, nil
+	case "drop-oldest":
+		return OverflowPolicy{Mode: OverflowDropOldest}, nil
+	case "block":
+		return OverflowPolicy{Mode: OverflowBlock}, nil
+	}
+	if strings.HasPrefix(spec, "block=") {
+		timeout, err := time.ParseDuration(strings.TrimPrefix(spec, "block="))
+		if err == nil && timeout <= 0 {
+			err = fmt.Errorf("non-positive timeout")
+		}
+		if err != nil {
+			return OverflowPolicy{}, fmt.Errorf("invalid overflow policy: %s: %s", spec, err)
+		}
+		return OverflowPolicy{Mode: OverflowBlock, Timeout: timeout}, nil
+	}
+	return OverflowPolicy{}, fmt.Errorf("invalid overflow policy: %s", spec)
+}
+
+// dropSummaryInterval is the interval of the summaries of the dropped logs
+const dropSummaryInterval = 10 * time.Second
+
+// enqueue puts item into ch by the policy, it returns whether item is queued, and how many queued items
+// are evicted for it
+func enqueue[T any](ch chan T, item T, policy OverflowPolicy) (queued bool, evicted int) {
+	select {
+	case ch <- item:
+		return true, 0
+	default:
+	}
+	switch policy.Mode {
+	case OverflowDropOldest:
+		select {
+		case <-ch:
+			evicted++
+		default:
+		}
+		select {
+		case ch <- item:
+			return true, evicted
+		default:
+		}
+	case OverflowBlock:
+		if policy.Timeout <= 0 {
+			ch <- item
+			return true, 0
+		}
+		timer := time.NewTimer(policy.Timeout)
+		defer timer.Stop()
+		select {
+		case ch <- item:
+			return true, 0
+		case <-timer.C:
+		}
+	}
+	return false, evicted
+}
@@ -0,0 +1,225 @@
+package dlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PurgeMatcher tells whether a log line is of the subject purged, like a user
+type PurgeMatcher func(line []byte) bool
+
+// MatchSubject matches the lines containing the identifier of the subject, like the user id
+func MatchSubject(id string) PurgeMatcher {
+	return func(line []byte) bool {
+		return bytes.Contains(line, []byte(id))
+	}
+}
+
+// RedactSubject replaces the identifier of the subject in the lines with mask, for PurgeConfig.Redact
+func RedactSubject(id, mask string) func(line []byte) []byte {
+	return func(line []byte) []byte {
+		return bytes.ReplaceAll(line, []byte(id), []byte(mask))
+	}
+}
+
+// PurgeConfig is the config of purging the logs of a subject, for the right-to-be-forgotten requests
+type PurgeConfig struct {
+	Match PurgeMatcher
+	// Redact returns the line written instead of a matched one, nil removes the matched lines
+	Redact func(line []byte) []byte
+	// Reason is recorded in the audit, like the id of the request, never put the subject in it
+	Reason string
+	// Audit is where the record of the purge is appended as a JSON line, the FileBackend appends it to
+	// purge.audit of the log dir if it's nil
+	Audit io.Writer
+}
+
+// PurgeRecord is the audit record of a purge, it tells the files rewritten but not the lines of them
+type PurgeRecord struct {
+	Time     time.Time    `json:"time"`
+	Reason   string       `json:"reason,omitempty"`
+	Redacted bool         `json:"redacted"`
+	Files    []PurgedFile `json:"files"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// PurgedFile is a file rewritten by a purge
+type PurgedFile struct {
+	Path  string `json:"path"`
+	Lines int    `json:"lines"` // removed or redacted
+}
+
+// purgeAuditFile is the audit of the FileBackend in the log dir
+const purgeAuditFile = "purge.audit"
+
+// PurgeFiles removes or redacts the matched lines of the rotated files in dirs, compressed or not, like the
+// log dir and the backup dir. A file is rewritten to a temp one and renamed over the original, so it's
+// never left half purged. The live files are not purged, rotate them first by RotateDir. The encrypted
+// files are decrypted and encrypted again by keys, which may be nil if there are none. It's not safe
+// with a process archiving the files in dirs, which should purge them by FileBackend.Purge instead.
+func PurgeFiles(fs FS, cfg PurgeConfig, keys *EncryptionKeys, dirs ...string) (*PurgeRecord, error) {
+	record, err := purgeFiles(fs, cfg, keys, dirs)
+	return record, writePurgeRecord(cfg.Audit, record, err)
+}
+
+func purgeFiles(fs FS, cfg PurgeConfig, keys *EncryptionKeys, dirs []string) (*PurgeRecord, error) {
+	record := &PurgeRecord{Time: time.Now(), Reason: cfg.Reason, Redacted: cfg.Redact != nil, Files: []PurgedFile{}}
+	if cfg.Match == nil {
+		return record, fmt.Errorf("no purge matcher")
+	}
+	for _, dir := range dirs {
+		files, err := listBackups(fs, dir)
+		if err != nil {
+			return record, err
+		}
+		for _, f := range files {
+			name, _ := parseBackupName(filepath.Base(f.path))
+			var c Compressor
+			if name.ext != "" {
+				if c, err = CompressorOf(name.ext); err != nil || c == nil {
+					// not a backup, like the temp file of one being archived
+					continue
+				}
+			}
+			k := keys
+			if !name.encrypted {
+				k = nil
+			}
+			lines, err := purgeFile(fs, c, k, f.path, cfg)
+			if err != nil {
+				return record, fmt.Errorf("purge %s: %s", f.path, err)
+			}
+			if lines > 0 {
+				record.Files = append(record.Files, PurgedFile{Path: f.path, Lines: lines})
+			}
+		}
+	}
+	return record, nil
+}
+
+// purgeFile rewrites the file at path compressed by c and encrypted by keys without the matched lines,
+// the file is not touched if no lines are matched
+func purgeFile(fs FS, c Compressor, keys *EncryptionKeys, path string, cfg PurgeConfig) (int, error) {
+	in, err := OpenDecrypted(fs, path, keys)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := openTemp(fs, path)
+	if err != nil {
+		return 0, err
+	}
+	w, err := newArchiveWriter(out, c, keys)
+	if err != nil {
+		out.Close()
+		fs.Remove(path + ".tmp")
+		return 0, err
+	}
+	lines, err := purgeLines(bufio.NewReader(in), w, cfg)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || lines == 0 {
+		fs.Remove(path + ".tmp")
+		return 0, err
+	}
+	return lines, fs.Rename(path+".tmp", path)
+}
+
+func purgeLines(r *bufio.Reader, w io.Writer, cfg PurgeConfig) (int, error) {
+	bw := bufio.NewWriter(w)
+	var lines int
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			if cfg.Match(line) {
+				lines++
+				if cfg.Redact != nil {
+					line = cfg.Redact(line)
+				} else {
+					line = nil
+				}
+			}
+			if _, werr := bw.Write(line); werr != nil {
+				return lines, werr
+			}
+		}
+		if err == io.EOF {
+			return lines, bw.Flush()
+		} else if err != nil {
+			return lines, err
+		}
+	}
+}
+
+// writePurgeRecord appends the record to the audit, with the error of the purge if it failed halfway
+func writePurgeRecord(audit io.Writer, record *PurgeRecord, err error) error {
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if audit == nil {
+		return err
+	}
+	data, _ := json.Marshal(record)
+	if _, werr := audit.Write(append(data, '\n')); werr != nil && err == nil {
+		err = fmt.Errorf("write purge audit: %s", werr)
+	}
+	return err
+}
+
+// Purge removes or redacts the matched lines of the rotated files of the backend, in both the log dir
+// and the backup dir, the archiving of the files waits for it. The record is appended to purge.audit
+// of the log dir unless cfg.Audit is set. The logs still in the live files are not purged, call
+// RotateNow before it to purge them too.
+func (self *FileBackend) Purge(cfg PurgeConfig) (*PurgeRecord, error) {
+	self.millMu.Lock()
+	defer self.millMu.Unlock()
+	self.mu.Lock()
+	keys := self.keys
+	self.mu.Unlock()
+	record, err := purgeFiles(self.fs, cfg, keys, self.backupDirs())
+	if cfg.Audit != nil {
+		return record, writePurgeRecord(cfg.Audit, record, err)
+	}
+	audit, aerr := self.fs.OpenFile(filepath.Join(self.dir, purgeAuditFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if aerr != nil {
+		if err == nil {
+			err = fmt.Errorf("open purge audit: %s", aerr)
+		}
+		return record, writePurgeRecord(nil, record, err)
+	}
+	defer audit.Close()
+	return record, writePurgeRecord(audit, record, err)
+}
+
+// Purge purges the rotated files of all the file backends of the logger, see FileBackend.Purge
+func (l *Logger) Purge(cfg PurgeConfig) (*PurgeRecord, error) {
+	merged := &PurgeRecord{Time: time.Now(), Reason: cfg.Reason, Redacted: cfg.Redact != nil, Files: []PurgedFile{}}
+	var errs []string
+	for _, fb := range fileBackends(l.config().backend) {
+		record, err := fb.Purge(cfg)
+		merged.Files = append(merged.Files, record.Files...)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		merged.Error = strings.Join(errs, "; ")
+		return merged, fmt.Errorf("%s", merged.Error)
+	}
+	return merged, nil
+}
+
+func Purge(cfg PurgeConfig) (*PurgeRecord, error) {
+	return logging.Purge(cfg)
+}
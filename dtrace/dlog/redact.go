@@ -0,0 +1,174 @@
+package dlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+// DefaultRedactKeys are the patterns of the keys masked by default
+var DefaultRedactKeys = []string{"*password*", "*passwd*", "*secret*", "*token*", "*id_card*", "*phone*"}
+
+// RedactConfig is the config of NewRedactBackend
+type RedactConfig struct {
+	// Keys are the patterns of path.Match of the keys whose values are masked, like "*token*" or "user.email",
+	// matched case insensitively by the whole keys and their last segments after the dots.
+	// DefaultRedactKeys if nil.
+	Keys []string
+	Mask string // replaces the values, *** by default
+	// Sanitize rewrites the values for the custom rules of the PII, like masking the numbers of the
+	// bank cards. It's called with the keys of the tags, and with the empty key for the whole log after
+	// the tags are masked.
+	Sanitize func(key, value string) string
+}
+
+type redactBackend struct {
+	backend Backend
+	cfg     RedactConfig
+}
+
+// NewRedactBackend masks the values of the sensitive keys in the logs before writing them to backend:
+// the tags of key=[value], and the keys of the JSON objects in the values of the tags, like the requests
+// logged by trace.Proto, at any depth.
+func NewRedactBackend(backend Backend, cfg RedactConfig) Backend {
+	if cfg.Keys == nil {
+		cfg.Keys = DefaultRedactKeys
+	}
+	keys := make([]string, len(cfg.Keys))
+	for i, key := range cfg.Keys {
+		keys[i] = strings.ToLower(key)
+	}
+	cfg.Keys = keys
+	if cfg.Mask == "" {
+		cfg.Mask = "***"
+	}
+	return &redactBackend{backend: backend, cfg: cfg}
+}
+
+func (self *redactBackend) Log(s Severity, msg []byte) {
+	self.backend.Log(s, self.redact(msg))
+}
+
+func (self *redactBackend) logBatch(entries []asyncEntry) {
+	b, ok := self.backend.(batchLogger)
+	if !ok {
+		for _, e := range entries {
+			self.Log(e.s, e.msg)
+		}
+		return
+	}
+	redacted := make([]asyncEntry, len(entries))
+	for i, e := range entries {
+		redacted[i] = asyncEntry{e.s, self.redact(e.msg)}
+	}
+	b.logBatch(redacted)
+}
+
+func (self *redactBackend) sync(ctx context.Context) error {
+	if s, ok := self.backend.(syncer); ok {
+		return s.sync(ctx)
+	}
+	return nil
+}
+
+func (self *redactBackend) close() {
+	self.backend.close()
+}
+
+// sensitive tells whether the values of key are masked
+func (self *redactBackend) sensitive(key string) bool {
+	key = strings.ToLower(key)
+	last := key[strings.LastIndexByte(key, '.')+1:]
+	for _, pattern := range self.cfg.Keys {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, last); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// redact returns msg with the values masked, or msg itself if nothing is masked
+func (self *redactBackend) redact(msg []byte) []byte {
+	if !bytes.Contains(msg, []byte("=[")) && self.cfg.Sanitize == nil {
+		return msg
+	}
+	line := bytes.TrimRight(msg, "\n")
+	var out bytes.Buffer
+	changed := false
+	for rest := line; len(rest) > 0; {
+		if key, value, after, ok := cutTag(rest); ok {
+			redacted := self.redactValue(key, value)
+			changed = changed || redacted != value
+			out.WriteString(key + "=[" + redacted + "]")
+			// the space after the tag
+			if n := len(key) + len(value) + 3; n < len(rest) {
+				out.WriteByte(' ')
+			}
+			rest = after
+			continue
+		}
+		word, after := cutWord(rest)
+		out.Write(word)
+		if len(word) < len(rest) {
+			out.WriteByte(' ')
+		}
+		rest = after
+	}
+	redacted := out.Bytes()
+	if self.cfg.Sanitize != nil {
+		redacted = []byte(self.cfg.Sanitize("", out.String()))
+	} else if !changed {
+		return msg
+	}
+	return append(redacted, msg[len(line):]...)
+}
+
+// redactValue masks the value of the tag key, or the sensitive keys of the JSON object in it
+func (self *redactBackend) redactValue(key, value string) string {
+	if self.sensitive(key) {
+		return self.cfg.Mask
+	}
+	if trimmed := strings.TrimSpace(value); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var v interface{}
+		decoder := json.NewDecoder(strings.NewReader(trimmed))
+		decoder.UseNumber()
+		if decoder.Decode(&v) == nil && !decoder.More() {
+			if self.redactJSON(v) {
+				data, _ := json.Marshal(v)
+				value = string(data)
+			}
+		}
+	}
+	if self.cfg.Sanitize != nil {
+		value = self.cfg.Sanitize(key, value)
+	}
+	return value
+}
+
+// redactJSON masks the values of the sensitive keys of the objects in v, and tells whether any is masked
+func (self *redactBackend) redactJSON(v interface{}) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if self.sensitive(key) {
+				v[key] = self.cfg.Mask
+				changed = true
+			} else if self.redactJSON(value) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if self.redactJSON(value) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
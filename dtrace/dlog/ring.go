@@ -0,0 +1,242 @@
+package dlog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timeline is the timeline of a trace in the ring buffer, the offsets and the durations are in milliseconds
+// from the first log of the trace
+type Timeline struct {
+	TraceID    string         `json:"traceID"`
+	Start      time.Time      `json:"start"`
+	DurationMS float64        `json:"durationMs"`
+	Spans      []TimelineSpan `json:"spans"`
+	Logs       []TimelineLog  `json:"logs"`
+}
+
+// TimelineSpan is a trace of dtrace in the timeline, started at the time of its log minus its tduration
+type TimelineSpan struct {
+	Name       string   `json:"name"`
+	Ancestors  []string `json:"ancestors,omitempty"` // the names of the parents, the nearest first
+	StartMS    float64  `json:"startMs"`
+	DurationMS float64  `json:"durationMs"` // until its last log
+	Logs       int      `json:"logs"`
+}
+
+// TimelineLog is a log of the trace
+type TimelineLog struct {
+	Time     time.Time `json:"time"`
+	OffsetMS float64   `json:"offsetMs"`
+	Level    string    `json:"level"`
+	Span     string    `json:"span,omitempty"`
+	Caller   string    `json:"caller,omitempty"`
+	Message  string    `json:"message"`
+}
+
+type ringEntry struct {
+	s   Severity
+	tid string
+	msg []byte
+}
+
+type ringBackend struct {
+	mu      sync.Mutex
+	entries []ringEntry
+	next    int
+	full    bool
+}
+
+// NewRingBackend keeps the last size logs of the traces in memory, for rendering the timelines of the
+// recent requests by Logger.TraceTimeline without a tracing backend. The logs without a tid are ignored.
+// It's used as an output along with the files, like "ring://10000" of NewOutputsBackend. It reads the logs
+// of the text format, it's added by Logger.AddOutput if the json format is set.
+func NewRingBackend(size int) *ringBackend {
+	if size <= 0 {
+		size = 10000
+	}
+	return &ringBackend{entries: make([]ringEntry, size)}
+}
+
+func (self *ringBackend) Log(s Severity, msg []byte) {
+	tid := traceID(msg)
+	if tid == "" {
+		return
+	}
+	self.mu.Lock()
+	e := &self.entries[self.next]
+	e.s, e.tid = s, tid
+	// the buffers of the slots are reused
+	e.msg = append(e.msg[:0], msg...)
+	if self.next++; self.next == len(self.entries) {
+		self.next, self.full = 0, true
+	}
+	self.mu.Unlock()
+}
+
+// traceID returns the value of the tid tag in msg
+func traceID(msg []byte) string {
+	i := bytes.Index(msg, []byte(" tid=["))
+	if i < 0 {
+		return ""
+	}
+	value := msg[i+len(" tid=["):]
+	j := bytes.IndexByte(value, ']')
+	if j <= 0 {
+		return ""
+	}
+	return string(value[:j])
+}
+
+func (self *ringBackend) close() {}
+
+// Timeline returns the timeline of the trace tid, false if no log of it is in the buffer
+func (self *ringBackend) Timeline(tid string) (*Timeline, bool) {
+	var logs []TimelineLog
+	spans := map[string]*timelineSpan{}
+	var order []string
+
+	self.mu.Lock()
+	n := self.next
+	if self.full {
+		n = len(self.entries)
+	}
+	for i := 0; i < n; i++ {
+		// from the oldest
+		e := &self.entries[(self.next-n+i+len(self.entries))%len(self.entries)]
+		if e.tid != tid {
+			continue
+		}
+		l, key, sp := timelineLog(e.s, e.msg)
+		logs = append(logs, l)
+		if sp == nil {
+			continue
+		}
+		if s, ok := spans[key]; ok {
+			s.Logs++
+			if sp.start.Before(s.start) {
+				s.start = sp.start
+			}
+			if l.Time.After(s.end) {
+				s.end = l.Time
+			}
+			continue
+		}
+		spans[key] = sp
+		order = append(order, key)
+	}
+	self.mu.Unlock()
+	if len(logs) == 0 {
+		return nil, false
+	}
+
+	t := &Timeline{TraceID: tid, Start: logs[0].Time}
+	for _, s := range spans {
+		if s.start.Before(t.Start) {
+			t.Start = s.start
+		}
+	}
+	end := t.Start
+	for i := range logs {
+		logs[i].OffsetMS = milliseconds(logs[i].Time.Sub(t.Start))
+		if logs[i].Time.After(end) {
+			end = logs[i].Time
+		}
+	}
+	for _, key := range order {
+		s := spans[key]
+		s.StartMS = milliseconds(s.start.Sub(t.Start))
+		s.DurationMS = milliseconds(s.end.Sub(s.start))
+		t.Spans = append(t.Spans, s.TimelineSpan)
+	}
+	sort.SliceStable(t.Spans, func(i, j int) bool { return t.Spans[i].StartMS < t.Spans[j].StartMS })
+	t.DurationMS = milliseconds(end.Sub(t.Start))
+	t.Logs = logs
+	return t, true
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+type timelineSpan struct {
+	TimelineSpan
+	start, end time.Time
+}
+
+// timelineLog parses the log of dtrace, and its span if it's logged by a trace, the key of the span is
+// the names of it and its ancestors
+func timelineLog(s Severity, msg []byte) (TimelineLog, string, *timelineSpan) {
+	l := TimelineLog{Time: logTime(msg), Level: severityName[s]}
+	text := bytes.TrimRight(bytes.TrimPrefix(body(msg), []byte(severityName[s]+" ")), "\n")
+	if caller, rest := cutWord(text); bytes.IndexByte(caller, ':') > 0 {
+		l.Caller, text = string(caller), rest
+	}
+	sp := &timelineSpan{}
+	var duration time.Duration
+	var words []string
+	for rest := text; len(rest) > 0; {
+		key, value, after, ok := cutTag(rest)
+		if !ok {
+			var word []byte
+			word, rest = cutWord(rest)
+			words = append(words, string(word))
+			continue
+		}
+		rest = after
+		switch key {
+		case "tname":
+			sp.Name = value
+		case "tancestor":
+			sp.Ancestors = strings.Split(value, ",")
+		case "tduration":
+			ms, _ := strconv.Atoi(value)
+			duration = time.Duration(ms) * time.Millisecond
+		case "tid":
+		default:
+			words = append(words, key+"=["+value+"]")
+		}
+	}
+	l.Message = strings.Join(words, " ")
+	if sp.Name == "" {
+		return l, "", nil
+	}
+	l.Span = sp.Name
+	sp.Logs = 1
+	sp.start, sp.end = l.Time.Add(-duration), l.Time
+	return l, strings.Join(append([]string{sp.Name}, sp.Ancestors...), "<"), sp
+}
+
+// ErrTraceNotFound is returned by TraceTimeline if the logs of the trace are not in the ring buffers,
+// never logged or overwritten by the later ones
+var ErrTraceNotFound = errors.New("trace not found in the ring buffer")
+
+// TraceTimeline returns the timeline of the trace tid in the ring buffers of the logger, see NewRingBackend
+func (l *Logger) TraceTimeline(tid string) (*Timeline, error) {
+	var rings []*ringBackend
+	walkBackends(l.config().backend, func(be Backend) {
+		if r, ok := be.(*ringBackend); ok {
+			rings = append(rings, r)
+		}
+	})
+	if len(rings) == 0 {
+		return nil, fmt.Errorf("no ring buffer of the logs, add the output ring://")
+	}
+	for _, r := range rings {
+		if t, ok := r.Timeline(tid); ok {
+			return t, nil
+		}
+	}
+	return nil, ErrTraceNotFound
+}
+
+// TraceTimeline returns the timeline of the trace in the ring buffers of the package, see Logger.TraceTimeline
+func TraceTimeline(tid string) (*Timeline, error) {
+	return logging.TraceTimeline(tid)
+}
@@ -0,0 +1,250 @@
+package dlog
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// levelBackend passes the logs of the matched severities to the backend
+type levelBackend struct {
+	match   func(s Severity) bool
+	backend Backend
+}
+
+// NewLevelBackend passes the logs of the level to backend, the level is like "ERROR" for the severity only,
+// or "INFO+" for the severity and the more severe ones, case insensitive
+func NewLevelBackend(level string, backend Backend) (*levelBackend, error) {
+	match, err := parseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	return &levelBackend{match: match, backend: backend}, nil
+}
+
+func parseLevel(level string) (func(s Severity) bool, error) {
+	name := strings.ToUpper(strings.TrimSpace(level))
+	orMore := strings.HasSuffix(name, "+")
+	severity, err := ParseSeverity(strings.TrimSuffix(name, "+"))
+	if err != nil {
+		return nil, err
+	}
+	if orMore {
+		return func(s Severity) bool { return s <= severity }, nil
+	}
+	return func(s Severity) bool { return s == severity }, nil
+}
+
+func (self *levelBackend) Log(s Severity, msg []byte) {
+	if self.match(s) {
+		self.backend.Log(s, msg)
+	}
+}
+
+func (self *levelBackend) close() {
+	self.backend.close()
+}
+
+func (self *levelBackend) sync(ctx context.Context) error {
+	if s, ok := self.backend.(syncer); ok {
+		return s.sync(ctx)
+	}
+	return nil
+}
+
+type stderrBackend struct{}
+
+func (self *stderrBackend) Log(s Severity, msg []byte) {
+	os.Stderr.Write(msg)
+}
+
+func (self *stderrBackend) close() {}
+
+// NewOutputsBackend routes the logs to the outputs like "error:./log/errors", "info+:stdout" or "./log",
+// one logger sends the errors to a separate dir and everything to stdout. An output is a target optionally
+// prefixed by a level of NewLevelBackend, all the logs go to it without the level. The target is stdout,
+// stderr, an address of NewNetworkBackend like "unix:///var/run/agent.sock", a kafka topic like
+// "kafka://broker1,broker2/topic" published by NewKafkaBackend, syslog:// of NewSyslog5424Backend,
+// journald:// of NewJournaldBackend, loki+http:// or loki+https:// of NewLokiBackend, fluent:// of
+// NewFluentBackend, eventlog:// of NewEventLogBackend on windows, sentry+https:// of NewSentryBackend,
+// ring://10000 of NewRingBackend, or the dir of a FileBackend.
+func NewOutputsBackend(outputs ...string) (*multiBackend, error) {
+	return newOutputsBackend(outputs, NewFileBackend)
+}
+
+func newOutputsBackend(outputs []string, newFile func(dir string) (*FileBackend, error)) (*multiBackend, error) {
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("no outputs")
+	}
+	var bes []Backend
+	for _, output := range outputs {
+		be, err := newOutput(output, newFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output %q: %s", output, err)
+		}
+		bes = append(bes, be)
+	}
+	return NewMultiBackend(bes...)
+}
+
+func newOutput(output string, newFile func(dir string) (*FileBackend, error)) (Backend, error) {
+	target, level := output, ""
+	// the colons of the drives like C:\log and the schemes like tcp:// are not the levels
+	if i := strings.Index(output, ":"); i > 1 && !strings.HasPrefix(output[i:], "://") {
+		if _, err := parseLevel(output[:i]); err != nil {
+			return nil, err
+		}
+		level, target = output[:i], output[i+1:]
+	}
+	var be Backend
+	switch target {
+	case "":
+		return nil, fmt.Errorf("no target")
+	case "stdout":
+		be = &stdBackend{}
+	case "stderr":
+		be = &stderrBackend{}
+	default:
+		var err error
+		switch {
+		case strings.HasPrefix(target, "kafka://"):
+			var cfg KafkaConfig
+			if cfg, err = parseKafkaOutput(target, newFile); err == nil {
+				be, err = NewKafkaBackend(cfg)
+			}
+		case strings.HasPrefix(target, "syslog://"):
+			be, err = NewSyslog5424Backend(target, NetworkConfig{})
+		case strings.HasPrefix(target, "journald://"):
+			be, err = NewJournaldBackend(target, NetworkConfig{})
+		case strings.HasPrefix(target, "fluent://"), strings.HasPrefix(target, "fluent+tls://"), strings.HasPrefix(target, "fluent+unix://"):
+			addr, cfg, perr := parseFluentOutput(target)
+			if err = perr; err == nil {
+				be, err = NewFluentBackend(addr, cfg)
+			}
+		case strings.HasPrefix(target, "eventlog://"):
+			be, err = NewEventLogBackend(target)
+		case strings.HasPrefix(target, "loki+http://"), strings.HasPrefix(target, "loki+https://"):
+			var cfg LokiConfig
+			if cfg, err = parseLokiOutput(target); err == nil {
+				be, err = NewLokiBackend(cfg)
+			}
+		case strings.HasPrefix(target, "sentry+http://"), strings.HasPrefix(target, "sentry+https://"):
+			dsn, cfg, perr := parseSentryOutput(target)
+			if err = perr; err == nil {
+				be, err = NewSentryBackend(dsn, cfg)
+			}
+		case strings.HasPrefix(target, "ring://"):
+			size := 0
+			if v := strings.TrimPrefix(target, "ring://"); v != "" {
+				if size, err = strconv.Atoi(v); err != nil {
+					err = fmt.Errorf("invalid size: %s", v)
+				}
+			}
+			be = NewRingBackend(size)
+		case strings.Contains(target, "://"):
+			be, err = NewNetworkBackend(target, NetworkConfig{})
+		default:
+			be, err = newFile(target)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if level == "" {
+		return be, nil
+	}
+	return NewLevelBackend(level, be)
+}
+
+// fileBackends returns the file backends routed to by be, the first one is set for the package level setters
+func fileBackends(be Backend) []*FileBackend {
+	switch b := be.(type) {
+	case *FileBackend:
+		return []*FileBackend{b}
+	case *levelBackend:
+		return fileBackends(b.backend)
+	case *asyncBackend:
+		return fileBackends(b.backend)
+	case *jsonBackend:
+		return fileBackends(b.backend)
+	case *binaryBackend:
+		return fileBackends(b.backend)
+	case *redactBackend:
+		return fileBackends(b.backend)
+	case *sampleBackend:
+		return fileBackends(b.backend)
+	case *kafkaSink:
+		return fileBackends(b.cfg.Fallback)
+	case *failoverBackend:
+		return append(fileBackends(b.primary), fileBackends(b.fallback)...)
+	case *multiBackend:
+		var fbs []*FileBackend
+		for _, sub := range b.backends() {
+			fbs = append(fbs, fileBackends(sub)...)
+		}
+		return fbs
+	}
+	return nil
+}
+
+// AddOutput tees the logs to the output at runtime, without recreating the logger, like "debug+:/tmp/diag"
+// to collect the debug logs to a diagnostic dir during an incident. The output is of NewOutputsBackend,
+// the logs are still filtered by the severity of the logger.
+func (l *Logger) AddOutput(output string) error {
+	l.outputsMu.Lock()
+	defer l.outputsMu.Unlock()
+	if _, ok := l.outputs[output]; ok {
+		return fmt.Errorf("output %q is added already", output)
+	}
+	be, err := newOutput(output, NewFileBackend)
+	if err != nil {
+		return fmt.Errorf("invalid output %q: %s", output, err)
+	}
+	if l.outputs == nil {
+		l.outputs = map[string]Backend{}
+	}
+	l.outputs[output] = be
+	if l.tee != nil && l.config().backend == l.tee {
+		l.tee.Add(be)
+		return nil
+	}
+	// the backend is replaced by SetLogging since, tee the outputs to the new one
+	var bes []Backend
+	if backend := l.config().backend; backend != nil {
+		bes = append(bes, backend)
+	}
+	for _, be := range l.outputs {
+		bes = append(bes, be)
+	}
+	l.tee, _ = NewMultiBackend(bes...)
+	l.update(func(cfg *loggerConfig) { cfg.backend = l.tee })
+	return nil
+}
+
+// RemoveOutput removes the output added by AddOutput, and closes it
+func (l *Logger) RemoveOutput(output string) error {
+	l.outputsMu.Lock()
+	defer l.outputsMu.Unlock()
+	be, ok := l.outputs[output]
+	if !ok {
+		return fmt.Errorf("output %q is not added", output)
+	}
+	delete(l.outputs, output)
+	if l.tee != nil {
+		l.tee.Remove(be)
+	}
+	be.close()
+	return nil
+}
+
+// AddOutput tees the logs of the package to the output, see Logger.AddOutput
+func AddOutput(output string) error {
+	return logging.AddOutput(output)
+}
+
+// RemoveOutput removes the output added by AddOutput
+func RemoveOutput(output string) error {
+	return logging.RemoveOutput(output)
+}
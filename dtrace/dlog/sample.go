@@ -0,0 +1,115 @@
+package dlog
+
+import (
+	"context"
+	"hash/fnv"
+	mrand "math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// SampleConfig is the config of NewSampleBackend
+type SampleConfig struct {
+	Tag string // the key of the tag sampled by its values, "event" by default, like event=[poll]
+	// Rates are the ratios of the logs kept of the values of the tag, like {"poll": 0.01}, the logs of
+	// the other values and without the tag are all kept
+	Rates map[string]float64
+	// Related are the values sampled along with the ones in Rates, like {"poll-done": "poll"}, so the
+	// pairs of the logs of a trace are kept or dropped together
+	Related map[string]string
+}
+
+type sampleBackend struct {
+	backend Backend
+	cfg     SampleConfig
+	sampled int64
+
+	mu   sync.Mutex
+	rand *mrand.Rand
+}
+
+// NewSampleBackend drops the logs of the high volume values of a tag by the rates before writing them to
+// backend, the errors and the fatals are always kept. The logs of a dtrace.Trace are sampled by the hash of
+// its tid, so the logs of the same trace of the related values, like event=[poll] and event=[poll-done]
+// logged by a worker, are kept or dropped together. The logs without a tid are sampled randomly.
+// Only the logs written by dlog are sampled, the ones of the trace package, like the request-in and the
+// request-out of trace.HandleFunc, go to glog.
+func NewSampleBackend(backend Backend, cfg SampleConfig) *sampleBackend {
+	if cfg.Tag == "" {
+		cfg.Tag = "event"
+	}
+	return &sampleBackend{backend: backend, cfg: cfg, rand: mrand.New(mrand.NewSource(mrand.Int63()))}
+}
+
+func (self *sampleBackend) Log(s Severity, msg []byte) {
+	if s <= ERROR || self.keep(msg) {
+		self.backend.Log(s, msg)
+		return
+	}
+	atomic.AddInt64(&self.sampled, 1)
+}
+
+// Sampled returns the count of the logs dropped by the sampling
+func (self *sampleBackend) Sampled() int64 {
+	return atomic.LoadInt64(&self.sampled)
+}
+
+// rate returns the ratio of the logs kept of the value of the tag in msg, 1 if it's not sampled
+func (self *sampleBackend) rate(msg []byte) float64 {
+	value, ok := tagValue(body(msg), self.cfg.Tag)
+	if !ok {
+		return 1
+	}
+	if related, ok := self.cfg.Related[value]; ok {
+		value = related
+	}
+	if r, ok := self.cfg.Rates[value]; ok {
+		return r
+	}
+	return 1
+}
+
+func (self *sampleBackend) keep(msg []byte) bool {
+	r := self.rate(msg)
+	if r >= 1 {
+		return true
+	}
+	if r <= 0 {
+		return false
+	}
+	if tid := traceID(msg); tid != "" {
+		h := fnv.New32a()
+		h.Write([]byte(tid))
+		return float64(h.Sum32()%10000) < r*10000
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.rand.Float64() < r
+}
+
+// tagValue returns the value of the first tag key in text
+func tagValue(text []byte, key string) (string, bool) {
+	for rest := text; len(rest) > 0; {
+		k, v, after, ok := cutTag(rest)
+		if !ok {
+			_, rest = cutWord(rest)
+			continue
+		}
+		if k == key {
+			return v, true
+		}
+		rest = after
+	}
+	return "", false
+}
+
+func (self *sampleBackend) sync(ctx context.Context) error {
+	if s, ok := self.backend.(syncer); ok {
+		return s.sync(ctx)
+	}
+	return nil
+}
+
+func (self *sampleBackend) close() {
+	self.backend.close()
+}
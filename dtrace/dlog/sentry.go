@@ -0,0 +1,377 @@
+package dlog
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	rdebug "runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SentryConfig is the config of NewSentryBackend, the zero one uses the defaults
+type SentryConfig struct {
+	Environment string
+	Release     string  // the version of the main module and the vcs revision in the build info by default
+	SampleRate  float64 // of the events sent, 0 or 1 sends all
+	QueueSize   int     // the events waiting to be sent, 100 by default, the events beyond it are dropped
+	Timeout     time.Duration
+	// the breaker stops sending for Cooldown after Failures sends failed in a row, 5 and 30s by default,
+	// so a sentry outage costs no more than the dropped events
+	Failures int
+	Cooldown time.Duration
+	Client   *http.Client
+}
+
+type sentryBackend struct {
+	pending   int64  // accessed atomically
+	lastWrite int64  // in unix nanoseconds, accessed atomically
+	dropped   uint64 // accessed atomically
+	openUntil int64  // the breaker is open until it, in unix nanoseconds, accessed atomically
+	store     string
+	auth      string
+	cfg       SentryConfig
+	host      string
+	queue     chan []byte
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	failures  int // in a row, owned by the sending goroutine
+}
+
+// NewSentryBackend reports the ERROR and the FATAL logs, and the panics logged by dtrace.LogCrashStack,
+// to sentry or a compatible service of the dsn like https://key@sentry.example.com/42. The events carry
+// the stack of the logging call, the tid as the trace_id tag, the tags user or uid as the user, and the
+// release. The other logs are ignored, so it's used as an output along with the files. The events are
+// sent by a background goroutine, the logging never waits for sentry.
+func NewSentryBackend(dsn string, cfg SentryConfig) (*sentryBackend, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid sentry dsn %q", dsn)
+	}
+	project := path.Base(u.Path)
+	if _, err := strconv.Atoi(project); err != nil {
+		return nil, fmt.Errorf("invalid sentry dsn %q: no project", dsn)
+	}
+	auth := "Sentry sentry_version=7, sentry_client=dlog/1.0, sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	if cfg.Release == "" {
+		cfg.Release = buildRelease()
+	}
+	if cfg.SampleRate <= 0 || cfg.SampleRate > 1 {
+		cfg.SampleRate = 1
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 100
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Failures <= 0 {
+		cfg.Failures = 5
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: cfg.Timeout}
+	}
+	b := &sentryBackend{
+		store:     u.Scheme + "://" + u.Host + strings.TrimSuffix(path.Dir(u.Path), "/") + "/api/" + project + "/store/",
+		auth:      auth,
+		cfg:       cfg,
+		queue:     make(chan []byte, cfg.QueueSize),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+		lastWrite: time.Now().UnixNano(),
+	}
+	b.host, _ = os.Hostname()
+	go b.run()
+	return b, nil
+}
+
+// buildRelease returns the version of the main module, with the vcs revision if it's stamped
+func buildRelease() string {
+	bi, ok := rdebug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	release := bi.Main.Path + "@" + bi.Main.Version
+	for _, s := range bi.Settings {
+		if s.Key == "vcs.revision" {
+			release += "+" + s.Value
+		}
+	}
+	return release
+}
+
+func (self *sentryBackend) Log(s Severity, msg []byte) {
+	level := ""
+	switch {
+	case s == FATAL:
+		level = "fatal"
+	case s == ERROR:
+		level = "error"
+	case bytes.Contains(msg, []byte(" panic: ")):
+		level = "fatal"
+	default:
+		return
+	}
+	if self.cfg.SampleRate < 1 && mrand.Float64() >= self.cfg.SampleRate {
+		return
+	}
+	if time.Now().UnixNano() < atomic.LoadInt64(&self.openUntil) {
+		atomic.AddUint64(&self.dropped, 1)
+		return
+	}
+	event := self.event(s, level, msg, callerFrames())
+	atomic.AddInt64(&self.pending, 1)
+	select {
+	case self.queue <- event:
+	default:
+		atomic.AddInt64(&self.pending, -1)
+		atomic.AddUint64(&self.dropped, 1)
+	}
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// dlogPackage and its parent, the trace wrapping it, are skipped in the stacks of the events
+var dlogPackage = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	// the name is like github.com/x/dtrace/dlog.init.func1, the package ends at the dot after the last slash
+	name := runtime.FuncForPC(pc).Name()
+	slash := strings.LastIndexByte(name, '/')
+	return name[:slash+1+strings.IndexByte(name[slash+1:], '.')]
+}()
+
+// callerFrames returns the stack of the logging call, the oldest frame first
+func callerFrames() []sentryFrame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	parent := path.Dir(dlogPackage)
+	var stack []sentryFrame
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, dlogPackage+".") && !strings.HasPrefix(f.Function, parent+".") {
+			pkg := f.Function[:strings.IndexByte(f.Function+".", '.')]
+			if i := strings.LastIndexByte(f.Function, '/'); i >= 0 {
+				pkg = f.Function[:i]
+			}
+			stack = append(stack, sentryFrame{
+				Function: f.Function,
+				Filename: path.Base(path.Dir(f.File)) + "/" + path.Base(f.File),
+				AbsPath:  f.File,
+				Lineno:   f.Line,
+				// the modules are of the domains, the standard library is not
+				InApp: strings.Contains(strings.SplitN(pkg, "/", 2)[0], "."),
+			})
+		}
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	return stack
+}
+
+// event encodes the event of the log
+func (self *sentryBackend) event(s Severity, level string, msg []byte, stack []sentryFrame) []byte {
+	id := make([]byte, 16)
+	rand.Read(id)
+	text := bytes.TrimRight(bytes.TrimPrefix(body(msg), []byte(severityName[s]+" ")), "\n")
+	caller, rest := cutWord(text)
+	if bytes.IndexByte(caller, ':') > 0 {
+		text = rest
+	} else {
+		caller = nil
+	}
+	tags := map[string]string{}
+	var user map[string]string
+	for rest := text; len(rest) > 0; {
+		key, value, after, ok := cutTag(rest)
+		if !ok {
+			_, rest = cutWord(rest)
+			continue
+		}
+		rest = after
+		switch key {
+		case "tid":
+			tags["trace_id"] = value
+		case "user", "uid", "user.id":
+			user = map[string]string{"id": value}
+		}
+	}
+	message := string(text)
+	if i := strings.IndexByte(message, '\n'); i >= 0 {
+		message = message[:i]
+	}
+	event := map[string]interface{}{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   logTime(msg).UTC().Format("2006-01-02T15:04:05.000000Z"),
+		"level":       level,
+		"logger":      "dlog",
+		"platform":    "go",
+		"server_name": self.host,
+		"message":     map[string]string{"formatted": string(text)},
+		"tags":        tags,
+		"exception": map[string]interface{}{"values": []interface{}{map[string]interface{}{
+			"type":       level,
+			"value":      message,
+			"stacktrace": map[string]interface{}{"frames": stack},
+		}}},
+	}
+	if caller != nil {
+		event["culprit"] = string(caller)
+	}
+	if user != nil {
+		event["user"] = user
+	}
+	if self.cfg.Release != "" {
+		event["release"] = self.cfg.Release
+	}
+	if self.cfg.Environment != "" {
+		event["environment"] = self.cfg.Environment
+	}
+	data, _ := json.Marshal(event)
+	return data
+}
+
+func (self *sentryBackend) run() {
+	defer close(self.done)
+	for {
+		select {
+		case event := <-self.queue:
+			self.send(event)
+			atomic.AddInt64(&self.pending, -1)
+		case <-self.quit:
+			return
+		}
+	}
+}
+
+// send sends the event, the failures in a row open the breaker
+func (self *sentryBackend) send(event []byte) {
+	if time.Now().UnixNano() < atomic.LoadInt64(&self.openUntil) {
+		atomic.AddUint64(&self.dropped, 1)
+		return
+	}
+	err := self.post(event)
+	if err == nil {
+		self.failures = 0
+		atomic.StoreInt64(&self.lastWrite, time.Now().UnixNano())
+		return
+	}
+	atomic.AddUint64(&self.dropped, 1)
+	if self.failures++; self.failures >= self.cfg.Failures {
+		self.failures = 0
+		atomic.StoreInt64(&self.openUntil, time.Now().Add(self.cfg.Cooldown).UnixNano())
+		fmt.Fprintf(os.Stderr, "dlog: send the events to sentry failed, paused for %s: %s\n", self.cfg.Cooldown, err)
+	}
+}
+
+func (self *sentryBackend) post(event []byte) error {
+	req, err := http.NewRequest("POST", self.store, bytes.NewReader(event))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", self.auth)
+	resp, err := self.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// Dropped returns the count of the events dropped, as the queue is full, the breaker is open or the
+// sending failed
+func (self *sentryBackend) Dropped() uint64 {
+	return atomic.LoadUint64(&self.dropped)
+}
+
+// Flush waits until the queued events are sent, or ctx is done
+func (self *sentryBackend) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&self.pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("flush sentry: %d events not sent: %s", atomic.LoadInt64(&self.pending), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (self *sentryBackend) sync(ctx context.Context) error {
+	return self.Flush(ctx)
+}
+
+func (self *sentryBackend) health(stall time.Duration) backendHealth {
+	return queueHealth("sentry", atomic.LoadInt64(&self.pending), atomic.LoadUint64(&self.dropped), atomic.LoadInt64(&self.lastWrite), stall)
+}
+
+func (self *sentryBackend) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	self.Flush(ctx)
+	self.closeOnce.Do(func() {
+		close(self.quit)
+		<-self.done
+	})
+}
+
+// parseSentryOutput parses the output like sentry+https://key@sentry.example.com/42?sample=0.1&env=prod,
+// the query may also set release and queue
+func parseSentryOutput(output string) (string, SentryConfig, error) {
+	var cfg SentryConfig
+	target, rawQuery := splitOnce(strings.TrimPrefix(output, "sentry+"), "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", cfg, err
+	}
+	cfg.Environment = query.Get("env")
+	cfg.Release = query.Get("release")
+	if v := query.Get("sample"); v != "" {
+		if cfg.SampleRate, err = strconv.ParseFloat(v, 64); err != nil || cfg.SampleRate <= 0 || cfg.SampleRate > 1 {
+			return "", cfg, fmt.Errorf("invalid sample: %s", v)
+		}
+	}
+	if v := query.Get("queue"); v != "" {
+		if cfg.QueueSize, err = strconv.Atoi(v); err != nil {
+			return "", cfg, fmt.Errorf("invalid queue: %s", v)
+		}
+	}
+	return target, cfg, nil
+}
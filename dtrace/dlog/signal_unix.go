@@ -0,0 +1,56 @@
+//go:build linux || darwin || freebsd || openbsd
+// +build linux darwin freebsd openbsd
+
+package dlog
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// HandleRotateSignals reopens the files of the backends on SIGHUP, after they are moved away by logrotate,
+// and rotates them on SIGUSR1. The backends stop reopening the moved files on their own, as they are
+// reopened by the signals. The package level file backend is handled if none is given. It returns the func
+// to stop handling the signals, which restores the watching and the polling.
+func HandleRotateSignals(backends ...*FileBackend) (stop func()) {
+	if len(backends) == 0 {
+		if fileback := getFileBackend(); fileback != nil {
+			backends = append(backends, fileback)
+		}
+	}
+	setSigReopen(backends, true)
+	sigs := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGUSR1)
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				for _, fb := range backends {
+					if sig == syscall.SIGUSR1 {
+						fb.RotateNow()
+					} else if err := fb.Reopen(); err != nil {
+						fb.reportError(fmt.Errorf("reopen on %s: %s", sig, err))
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+		setSigReopen(backends, false)
+	}
+}
+
+func setSigReopen(backends []*FileBackend, on bool) {
+	for _, fb := range backends {
+		fb.mu.Lock()
+		fb.sigReopen = on
+		fb.mu.Unlock()
+	}
+}
@@ -0,0 +1,56 @@
+//go:build linux || darwin || freebsd || openbsd
+// +build linux darwin freebsd openbsd
+
+package dlog
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tools-go/go-utils/utils/clock"
+)
+
+func TestHandleRotateSignals(t *testing.T) {
+	fs := NewMemFS()
+	fb, err := NewFileBackendFS("/logs", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := HandleRotateSignals(fb)
+	defer stop()
+	waitFor := func(name string) {
+		for i := 0; i < 100; i++ {
+			if _, err := fs.Stat(name); err == nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("%s not found", name)
+	}
+
+	// moved away by logrotate, the logs go to the moved file until reopened
+	fb.Log(INFO, []byte("before\n"))
+	fb.Flush()
+	fs.Rename("/logs/INFO.log", "/logs/INFO.log.1")
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	waitFor("/logs/INFO.log")
+	fb.Log(INFO, []byte("after\n"))
+	fb.Flush()
+	for name, expected := range map[string]string{"/logs/INFO.log.1": "before\n", "/logs/INFO.log": "after\n"} {
+		f, _ := fs.Open(name)
+		if data, _ := ioutil.ReadAll(f); string(data) != expected {
+			t.Fatalf("unexpected %s: %q", name, data)
+		}
+	}
+
+	fb.SetClock(clock.NewFakeClock(time.Date(2016, 7, 11, 14, 30, 0, 0, time.Local)))
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	waitFor("/logs/INFO.log.201607111430")
+	// INFO.log.1 moved by logrotate, and the rotated one, the empty files of the other severities are not rotated
+	if backups, _ := fb.Backups(); len(backups) != 2 {
+		t.Fatalf("expect only the non-empty file rotated, got %v", backups)
+	}
+}
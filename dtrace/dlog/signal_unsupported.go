@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !openbsd
+// +build !linux,!darwin,!freebsd,!openbsd
+
+package dlog
+
+// HandleRotateSignals does nothing on this platform, the moved files are still reopened by the watching and the polling
+func HandleRotateSignals(backends ...*FileBackend) (stop func()) {
+	return func() {}
+}
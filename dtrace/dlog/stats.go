@@ -0,0 +1,117 @@
+package dlog
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// FileStats are the counters of a FileBackend since it's created, to watch the log volume of a module
+// without listing its dir. It's a plain struct, so it can be published by expvar.Func or copied into
+// the counters of any metrics system.
+type FileStats struct {
+	BytesWritten   uint64
+	Rotations      uint64
+	BackupsDeleted uint64        // by the expiry, the budget and the free space check
+	Archived       uint64        // the rotated files compressed or moved to the backup dir
+	ArchiveTime    time.Duration // the total time spent on archiving
+	MillErrors     uint64        // the failures of archiving
+}
+
+// FileEventKind is the kind of a FileEvent
+type FileEventKind string
+
+const (
+	EventRotate  FileEventKind = "rotate"
+	EventArchive FileEventKind = "archive"
+	EventDelete  FileEventKind = "delete"
+)
+
+// FileEvent is a change of the files of a FileBackend
+type FileEvent struct {
+	Kind     FileEventKind
+	Path     string        // the rotated, archived or deleted file
+	Duration time.Duration // of archiving
+	Err      error         // of archiving
+}
+
+type fileCounters struct {
+	bytesWritten   uint64
+	rotations      uint64
+	backupsDeleted uint64
+	archived       uint64
+	archiveTime    int64
+	millErrors     uint64
+}
+
+// Stats returns a snapshot of the counters
+func (self *FileBackend) Stats() FileStats {
+	c := &self.counters
+	return FileStats{
+		BytesWritten:   atomic.LoadUint64(&c.bytesWritten),
+		Rotations:      atomic.LoadUint64(&c.rotations),
+		BackupsDeleted: atomic.LoadUint64(&c.backupsDeleted),
+		Archived:       atomic.LoadUint64(&c.archived),
+		ArchiveTime:    time.Duration(atomic.LoadInt64(&c.archiveTime)),
+		MillErrors:     atomic.LoadUint64(&c.millErrors),
+	}
+}
+
+func Stats() FileStats {
+	if fileback := getFileBackend(); fileback != nil {
+		return fileback.Stats()
+	}
+	return FileStats{}
+}
+
+// SetObserver sets the observer of the rotations, archives and deletions, nil disables it.
+// Like the error handler it's called in a separate goroutine, and the events are dropped
+// if too many are pending, the counters of Stats are always exact.
+func (self *FileBackend) SetObserver(observer func(ev FileEvent)) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.observer = observer
+}
+
+func (self *FileBackend) notifyObserver(ev FileEvent) {
+	select {
+	case self.events <- ev:
+	default:
+	}
+}
+
+func (self *FileBackend) observerDaemon() {
+	for {
+		var ev FileEvent
+		select {
+		case ev = <-self.events:
+		case <-self.quit:
+			return
+		}
+		self.mu.Lock()
+		observer := self.observer
+		self.mu.Unlock()
+		if observer != nil {
+			observer(ev)
+		}
+	}
+}
+
+func (self *FileBackend) countRotation(rotated string) {
+	atomic.AddUint64(&self.counters.rotations, 1)
+	self.notifyObserver(FileEvent{Kind: EventRotate, Path: rotated})
+}
+
+func (self *FileBackend) countArchive(path string, d time.Duration, err error) {
+	atomic.AddInt64(&self.counters.archiveTime, int64(d))
+	if err != nil {
+		atomic.AddUint64(&self.counters.millErrors, 1)
+	} else {
+		atomic.AddUint64(&self.counters.archived, 1)
+	}
+	self.notifyObserver(FileEvent{Kind: EventArchive, Path: path, Duration: d, Err: err})
+}
+
+func (self *FileBackend) countDelete(path string) {
+	atomic.AddUint64(&self.counters.backupsDeleted, 1)
+	self.notifyObserver(FileEvent{Kind: EventDelete, Path: path})
+}
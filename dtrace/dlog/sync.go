@@ -0,0 +1,110 @@
+package dlog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyncPolicy tells when the written logs are fsynced to the disk. Without a policy the files are
+// synced on every periodic flush and rotation; with one the periodic flush only hands the buffers
+// to the os, and the files are synced by the policy. The zero policy never syncs, except Flush and
+// the FATAL logs which always do.
+type SyncPolicy struct {
+	Bytes    uint64        // sync a file after every Bytes written to it, in the logging call
+	Interval time.Duration // sync all the files every Interval in the background
+	OnRotate bool          // sync a file before it's rotated
+}
+
+// ParseSyncPolicy parses the sync policy, "" for the default one, "never", or a comma separated
+// list of "bytes=N", "interval=duration" and "rotate", e.g. "bytes=1048576,rotate" or "interval=200ms"
+func ParseSyncPolicy(spec string) (*SyncPolicy, error) {
+	spec = strings.TrimSpace(spec)
+	switch spec {
+	case "":
+		return nil, nil
+	case "never":
+		return &SyncPolicy{}, nil
+	}
+	var p SyncPolicy
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		kv := strings.SplitN(item, "=", 2)
+		var err error
+		switch {
+		case item == "rotate":
+			p.OnRotate = true
+		case len(kv) == 2 && kv[0] == "bytes":
+			if p.Bytes, err = strconv.ParseUint(kv[1], 10, 64); err == nil && p.Bytes == 0 {
+				err = fmt.Errorf("zero bytes")
+			}
+		case len(kv) == 2 && kv[0] == "interval":
+			if p.Interval, err = time.ParseDuration(kv[1]); err == nil && p.Interval <= 0 {
+				err = fmt.Errorf("non-positive interval")
+			}
+		default:
+			err = fmt.Errorf("unknown item %q", item)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid sync policy: %s: %s", spec, err)
+		}
+	}
+	return &p, nil
+}
+
+// SetSyncPolicy sets the policy of syncing the files, nil restores the default one
+func (self *FileBackend) SetSyncPolicy(p *SyncPolicy) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if p != nil {
+		copied := *p
+		p = &copied
+	}
+	self.syncPolicy = p
+}
+
+func SetSyncPolicy(p *SyncPolicy) {
+	if fileback := getFileBackend(); fileback != nil {
+		fileback.SetSyncPolicy(p)
+	}
+}
+
+// syncOnRotate tells whether to sync the file before rotating it, it's called with the lock held
+func (self *FileBackend) syncOnRotate() bool {
+	return self.syncPolicy == nil || self.syncPolicy.OnRotate
+}
+
+// syncDaemon syncs the files by the interval of the policy, it checks the policy at least every second
+// so a new interval takes effect soon
+func (self *FileBackend) syncDaemon() {
+	var last time.Time
+	for {
+		self.mu.Lock()
+		clock, policy := self.clock, self.syncPolicy
+		self.mu.Unlock()
+		if policy == nil || policy.Interval <= 0 {
+			last = time.Time{}
+			if !self.sleep(clock, time.Second) {
+				return
+			}
+			continue
+		}
+		now := clock.Now()
+		if last.IsZero() {
+			last = now
+		}
+		if next := last.Add(policy.Interval); now.Before(next) {
+			wait := next.Sub(now)
+			if wait > time.Second {
+				wait = time.Second
+			}
+			if !self.sleep(clock, wait) {
+				return
+			}
+			continue
+		}
+		self.Flush()
+		last = now
+	}
+}
@@ -0,0 +1,187 @@
+package dlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syslogFacilities are the facilities of the syslog outputs by name
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverities are the syslog severities of the dlog ones, like the syslog backend
+var syslogSeverities = [numSeverity]int{0, 3, 4, 6, 7}
+
+const (
+	defaultSyslogSocket   = "/dev/log"
+	defaultJournaldSocket = "/run/systemd/journal/socket"
+)
+
+// dlogHeaderLen is the length of the timestamp of the log lines, like "2006-01-02 15:04:05.000000 "
+const dlogHeaderLen = 27
+
+// body returns msg without the timestamp and the trailing line break
+func body(msg []byte) []byte {
+	if len(msg) > dlogHeaderLen {
+		msg = msg[dlogHeaderLen:]
+	}
+	return bytes.TrimRight(msg, "\n")
+}
+
+// NewSyslog5424Backend sends the logs to syslog in the format of RFC 5424, the addr is like
+//
+//	syslog://                                  the local daemon by /dev/log
+//	syslog:///var/run/syslog                   the local daemon by the socket
+//	syslog://host:514?proto=tcp                the remote daemon by udp (the default), tcp or tls
+//
+// with the query facility (local0 by default, or user, daemon...) and tag (the name of the program by
+// default). The severities of the logs map to the syslog ones, and the tcp streams are framed by the
+// octet counting of RFC 6587. It's a NewNetworkBackend, so it queues and reconnects the same way.
+func NewSyslog5424Backend(addr string, cfg NetworkConfig) (*networkBackend, error) {
+	u, err := url.Parse(addr)
+	if err != nil || u.Scheme != "syslog" {
+		return nil, fmt.Errorf("invalid syslog address %q", addr)
+	}
+	query := u.Query()
+	facility, ok := syslogFacilities[query.Get("facility")]
+	if !ok && query.Get("facility") != "" {
+		return nil, fmt.Errorf("unknown syslog facility: %s", query.Get("facility"))
+	} else if !ok {
+		facility = syslogFacilities["local0"]
+	}
+	tag := query.Get("tag")
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+	target := "unixgram://" + defaultSyslogSocket
+	switch {
+	case u.Host != "":
+		proto := query.Get("proto")
+		if proto == "" {
+			proto = "udp"
+		} else if proto != "udp" && proto != "tcp" && proto != "tls" {
+			return nil, fmt.Errorf("unknown syslog proto: %s", proto)
+		}
+		target = proto + "://" + u.Host
+	case u.Path != "" && u.Path != "/":
+		target = "unixgram://" + u.Path
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	stream := !strings.HasPrefix(target, "udp") && !strings.HasPrefix(target, "unixgram")
+	prefix := " " + hostname + " " + tag + " " + strconv.Itoa(os.Getpid()) + " - - "
+	frame := func(s Severity, msg []byte) []byte {
+		var b bytes.Buffer
+		b.WriteString("<" + strconv.Itoa(facility*8+syslogSeverities[s]) + ">1 ")
+		b.WriteString(time.Now().Format("2006-01-02T15:04:05.000000Z07:00"))
+		b.WriteString(prefix)
+		b.Write(body(msg))
+		if !stream {
+			return b.Bytes()
+		}
+		return append([]byte(strconv.Itoa(b.Len())+" "), b.Bytes()...)
+	}
+	return newNetworkBackend(target, cfg, frame)
+}
+
+// NewJournaldBackend sends the logs to systemd-journald by its native protocol, the addr is journald://,
+// or journald:///path/to/socket, with the query tag for SYSLOG_IDENTIFIER (the name of the program by
+// default). The severities of the logs map to PRIORITY like the syslog ones, the callers to CODE_FILE and
+// CODE_LINE, and the tags of key=[value] in the logs to the fields like HTTP_METHOD for journalctl to
+// match, the MESSAGE is the log without the time. The logs beyond the size of a datagram of the socket
+// are dropped by the kernel, they are not passed by memfd.
+func NewJournaldBackend(addr string, cfg NetworkConfig) (*networkBackend, error) {
+	u, err := url.Parse(addr)
+	if err != nil || u.Scheme != "journald" || u.Host != "" {
+		return nil, fmt.Errorf("invalid journald address %q", addr)
+	}
+	tag := u.Query().Get("tag")
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+	socket := defaultJournaldSocket
+	if u.Path != "" && u.Path != "/" {
+		socket = u.Path
+	}
+	frame := func(s Severity, msg []byte) []byte {
+		var b bytes.Buffer
+		b.WriteString("PRIORITY=" + strconv.Itoa(syslogSeverities[s]) + "\n")
+		b.WriteString("SYSLOG_IDENTIFIER=" + tag + "\n")
+		writeJournalFields(&b, s, body(msg))
+		writeJournalField(&b, "MESSAGE", body(msg))
+		return b.Bytes()
+	}
+	return newNetworkBackend("unixgram://"+socket, cfg, frame)
+}
+
+// writeJournalFields writes the caller and the tags of the log without the time as the fields, the tags
+// clashing with the fields written by dlog or the repeated ones are skipped
+func writeJournalFields(b *bytes.Buffer, s Severity, msg []byte) {
+	msg = bytes.TrimPrefix(msg, []byte(severityName[s]+" "))
+	caller, rest := cutWord(msg)
+	if i := bytes.LastIndexByte(caller, ':'); i > 0 {
+		if _, err := strconv.Atoi(string(caller[i+1:])); err == nil {
+			writeJournalField(b, "CODE_FILE", caller[:i])
+			writeJournalField(b, "CODE_LINE", caller[i+1:])
+			msg = rest
+		}
+	}
+	seen := map[string]bool{"PRIORITY": true, "SYSLOG_IDENTIFIER": true, "CODE_FILE": true, "CODE_LINE": true, "MESSAGE": true}
+	for len(msg) > 0 {
+		key, value, rest, ok := cutTag(msg)
+		if !ok {
+			_, msg = cutWord(msg)
+			continue
+		}
+		msg = rest
+		if name := journalFieldName(key); name != "" && !seen[name] {
+			seen[name] = true
+			writeJournalField(b, name, []byte(value))
+		}
+	}
+}
+
+// journalFieldName converts the tag to the name of the journal field, like http.method to HTTP_METHOD,
+// or empty if it's not valid, like starting by an underscore for the trusted fields of journald
+func journalFieldName(tag string) string {
+	name := []byte(strings.ToUpper(tag))
+	for i, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || len(name) > 64 || name[0] == '_' || name[0] >= '0' && name[0] <= '9' {
+		return ""
+	}
+	return string(name)
+}
+
+// writeJournalField writes the field of the native protocol, the values with line breaks are written
+// with their lengths
+func writeJournalField(b *bytes.Buffer, key string, value []byte) {
+	if bytes.IndexByte(value, '\n') < 0 {
+		b.WriteString(key + "=")
+		b.Write(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteString(key + "\n")
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	b.Write(size[:])
+	b.Write(value)
+	b.WriteByte('\n')
+}
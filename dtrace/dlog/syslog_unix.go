@@ -3,14 +3,26 @@
 package dlog
 
 import (
+	"context"
 	"fmt"
 	"log/syslog"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type syslogBackend struct {
-	writer [numSeverity]*syslog.Writer
-	buf    [numSeverity]chan []byte
+	pending   int64  // the logs queued or being written, accessed atomically
+	lastWrite int64  // in unix nanoseconds, accessed atomically
+	dropped   uint64 // the logs not delivered as the queues are full, accessed atomically
+	reported  uint64 // the dropped logs in the summaries
+	closed    int32
+	policy    atomic.Value // OverflowPolicy
+	writer    [numSeverity]*syslog.Writer
+	buf       [numSeverity]chan []byte
+	quit      chan struct{}
+	closeOnce sync.Once
 }
 
 var SyslogPriorityMap = map[string]syslog.Priority{
@@ -76,26 +88,120 @@ func (self *syslogBackend) Log(s Severity, msg []byte) {
 }
 
 func (self *syslogBackend) close() {
-	for i := 0; i < numSeverity; i++ {
-		self.writer[i].Close()
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	self.Close(ctx)
+}
+
+func (self *syslogBackend) sync(ctx context.Context) error {
+	return self.Flush(ctx)
+}
+
+// Flush waits until the queued logs are written to syslog, or ctx is done
+func (self *syslogBackend) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&self.pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("flush syslog: %d logs not delivered: %s", atomic.LoadInt64(&self.pending), ctx.Err())
+		case <-ticker.C:
+		}
 	}
+	return nil
+}
+
+// Close flushes the queued logs until ctx is done, and closes the connections to syslog.
+// The logs after it go to stderr.
+func (self *syslogBackend) Close(ctx context.Context) error {
+	atomic.StoreInt32(&self.closed, 1)
+	err := self.Flush(ctx)
+	self.closeOnce.Do(func() {
+		close(self.quit)
+		for i := 0; i < numSeverity; i++ {
+			self.writer[i].Close()
+		}
+	})
+	return err
+}
+
+// SetOverflowPolicy sets the policy of the full queues
+func (self *syslogBackend) SetOverflowPolicy(p OverflowPolicy) {
+	self.policy.Store(p)
+}
+
+// Dropped returns the count of the logs not delivered to syslog as the queues are full
+func (self *syslogBackend) Dropped() uint64 {
+	return atomic.LoadUint64(&self.dropped)
 }
 
 func (self *syslogBackend) tryPutInBuf(s Severity, msg []byte) {
-	select {
-	case self.buf[s] <- msg:
-	default:
+	if atomic.LoadInt32(&self.closed) == 1 {
+		os.Stderr.Write(msg)
+		return
+	}
+	atomic.AddInt64(&self.pending, 1)
+	policy, _ := self.policy.Load().(OverflowPolicy)
+	queued, evicted := enqueue(self.buf[s], msg, policy)
+	if evicted > 0 {
+		atomic.AddInt64(&self.pending, -int64(evicted))
+		atomic.AddUint64(&self.dropped, uint64(evicted))
+	}
+	if queued {
+		return
+	}
+	atomic.AddInt64(&self.pending, -1)
+	atomic.AddUint64(&self.dropped, 1)
+	if policy.Mode == OverflowStderr {
 		os.Stderr.Write(msg)
 	}
 }
 
+// dropSummary returns the summary of the logs dropped since the last one, or "" if there are none
+func (self *syslogBackend) dropSummary() string {
+	dropped := atomic.LoadUint64(&self.dropped)
+	if dropped == self.reported {
+		return ""
+	}
+	n := dropped - self.reported
+	self.reported = dropped
+	return fmt.Sprintf("dlog: %d logs dropped as the syslog queues are full, %d in total", n, dropped)
+}
+
+func (self *syslogBackend) health(stall time.Duration) backendHealth {
+	return queueHealth("syslog", atomic.LoadInt64(&self.pending), atomic.LoadUint64(&self.dropped), atomic.LoadInt64(&self.lastWrite), stall)
+}
+
 func (self *syslogBackend) log() {
+	self.quit = make(chan struct{})
+	self.lastWrite = time.Now().UnixNano()
 	for i := 0; i < numSeverity; i++ {
 		go func(index int) {
 			for {
-				msg := <-self.buf[index]
-				self.writer[index].Write(msg[27:])
+				select {
+				case msg := <-self.buf[index]:
+					self.writer[index].Write(msg[27:])
+					atomic.StoreInt64(&self.lastWrite, time.Now().UnixNano())
+					atomic.AddInt64(&self.pending, -1)
+				case <-self.quit:
+					return
+				}
 			}
 		}(i)
 	}
+	go func() {
+		ticker := time.NewTicker(dropSummaryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if summary := self.dropSummary(); summary != "" {
+					// bypass the queues, they are likely full
+					self.writer[WARNING].Write([]byte(summary))
+				}
+			case <-self.quit:
+				return
+			}
+		}
+	}()
 }
@@ -3,6 +3,7 @@
 package dlog
 
 import (
+	"context"
 	"fmt"
 )
 
@@ -19,6 +20,25 @@ func (self *syslogBackend) Log(s Severity, msg []byte) {
 func (self *syslogBackend) close() {
 }
 
+func (self *syslogBackend) sync(ctx context.Context) error {
+	return nil
+}
+
+func (self *syslogBackend) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (self *syslogBackend) Close(ctx context.Context) error {
+	return nil
+}
+
+func (self *syslogBackend) SetOverflowPolicy(p OverflowPolicy) {
+}
+
+func (self *syslogBackend) Dropped() uint64 {
+	return 0
+}
+
 func (self *syslogBackend) tryPutInBuf(s Severity, msg []byte) {
 }
 
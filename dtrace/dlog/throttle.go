@@ -0,0 +1,113 @@
+package dlog
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// MillThrottle limits the archiving of the rotated files, so compressing several large backups at once
+// doesn't saturate a cpu core and the disk of the service. It can be shared by the backends of several modules.
+type MillThrottle struct {
+	sem         chan struct{}
+	bytesPerSec int64
+	idle        float64
+}
+
+// NewMillThrottle creates a throttle running at most maxConcurrent archivings at once, reading at most
+// bytesPerSec for each of them, and sleeping idle times the busy time after every chunk like nice,
+// e.g. 1 takes half a core at most. 0 disables each of the limits.
+func NewMillThrottle(maxConcurrent int, bytesPerSec int64, idle float64) *MillThrottle {
+	t := &MillThrottle{bytesPerSec: bytesPerSec, idle: idle}
+	if maxConcurrent > 0 {
+		t.sem = make(chan struct{}, maxConcurrent)
+	}
+	return t
+}
+
+// SetMillThrottle throttles the compression and the copying of the rotated files by t, nil disables it
+func (self *FileBackend) SetMillThrottle(t *MillThrottle) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.throttle = t
+}
+
+func SetMillThrottle(t *MillThrottle) {
+	if fileback := getFileBackend(); fileback != nil {
+		fileback.SetMillThrottle(t)
+	}
+}
+
+var millThrottles = struct {
+	sync.Mutex
+	m map[[3]float64]*MillThrottle
+}{m: map[[3]float64]*MillThrottle{}}
+
+// sharedMillThrottle returns the throttle of the settings, the backends created by the same settings
+// share it, so the concurrency is limited across them. It's nil if all the limits are disabled.
+func sharedMillThrottle(maxConcurrent int, bytesPerSec int64, idle float64) *MillThrottle {
+	if maxConcurrent <= 0 && bytesPerSec <= 0 && idle <= 0 {
+		return nil
+	}
+	key := [3]float64{float64(maxConcurrent), float64(bytesPerSec), idle}
+	millThrottles.Lock()
+	defer millThrottles.Unlock()
+	if t, ok := millThrottles.m[key]; ok {
+		return t
+	}
+	t := NewMillThrottle(maxConcurrent, bytesPerSec, idle)
+	millThrottles.m[key] = t
+	return t
+}
+
+func (t *MillThrottle) acquire() {
+	if t != nil && t.sem != nil {
+		t.sem <- struct{}{}
+	}
+}
+
+func (t *MillThrottle) release() {
+	if t != nil && t.sem != nil {
+		<-t.sem
+	}
+}
+
+// throttleChunk is the max size read at once, the pacing is applied per chunk
+const throttleChunk = 64 * 1024
+
+// reader paces the reads of r by the rate and the idle ratio
+func (t *MillThrottle) reader(r io.Reader) io.Reader {
+	if t == nil || (t.bytesPerSec <= 0 && t.idle <= 0) {
+		return r
+	}
+	return &throttledReader{r: r, t: t, start: time.Now()}
+}
+
+type throttledReader struct {
+	r     io.Reader
+	t     *MillThrottle
+	start time.Time
+	last  time.Time // when the last read returned
+	n     int64
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	now := time.Now()
+	if tr.t.idle > 0 && !tr.last.IsZero() {
+		// the time since the last read is spent on compressing and writing the chunk
+		time.Sleep(time.Duration(float64(now.Sub(tr.last)) * tr.t.idle))
+	}
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := tr.r.Read(p)
+	tr.n += int64(n)
+	if tr.t.bytesPerSec > 0 {
+		expected := time.Duration(float64(tr.n) / float64(tr.t.bytesPerSec) * float64(time.Second))
+		if wait := expected - time.Since(tr.start); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	tr.last = time.Now()
+	return n, err
+}
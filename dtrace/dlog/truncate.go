@@ -0,0 +1,94 @@
+package dlog
+
+import (
+	"bytes"
+	"strconv"
+	"unicode/utf8"
+)
+
+// minEntryBytes keeps the header and a bit of the message of the truncated logs
+const minEntryBytes = 128
+
+// SetMaxBytes caps the values of the tags of key=[value] at field bytes and the whole logs at entry bytes,
+// so a huge request body logged by accident doesn't make a multi-MB line breaking the parsers downstream.
+// The cut parts are replaced by a marker like "…(truncated 52301 bytes)". 0 for no limit.
+func (l *Logger) SetMaxBytes(field, entry int) {
+	if entry > 0 && entry < minEntryBytes {
+		entry = minEntryBytes
+	}
+	l.update(func(cfg *loggerConfig) { cfg.maxFieldBytes, cfg.maxEntryBytes = field, entry })
+}
+
+func SetMaxBytes(field, entry int) {
+	logging.SetMaxBytes(field, entry)
+}
+
+// truncateLog returns msg with the values of the tags capped at maxField bytes and the line at maxEntry
+// bytes, or msg itself if it's within the limits
+func truncateLog(msg []byte, maxField, maxEntry int) []byte {
+	line := bytes.TrimSuffix(msg, []byte("\n"))
+	truncated := line
+	if maxField > 0 && len(line) > dlogHeaderLen+maxField {
+		truncated = truncateFields(truncated, maxField)
+	}
+	if maxEntry > 0 && len(truncated)+len(msg)-len(line) > maxEntry {
+		truncated = truncateEntry(truncated, maxEntry-(len(msg)-len(line)))
+	}
+	if len(truncated) == len(line) {
+		return msg
+	}
+	return append(truncated, msg[len(line):]...)
+}
+
+// truncateFields caps the values of the tags in line, a new slice is returned if any is cut
+func truncateFields(line []byte, max int) []byte {
+	var out []byte
+	last := 0
+	for rest := line; len(rest) > 0; {
+		key, value, after, ok := cutTag(rest)
+		if !ok {
+			_, rest = cutWord(rest)
+			continue
+		}
+		if len(value) > max {
+			start := len(line) - len(rest) + len(key) + 2
+			cut := runeCut(value, max)
+			out = append(out, line[last:start+cut]...)
+			out = appendTruncated(out, len(value)-cut)
+			last = start + len(value)
+		}
+		rest = after
+	}
+	if out == nil {
+		return line
+	}
+	return append(out, line[last:]...)
+}
+
+// truncateEntry cuts line to max bytes with the marker, a new slice is returned
+func truncateEntry(line []byte, max int) []byte {
+	cut := max - len(appendTruncated(nil, len(line)-max))
+	// the marker is longer by a digit if the cut part is
+	cut = max - len(appendTruncated(nil, len(line)-cut))
+	if cut < 0 {
+		cut = 0
+	}
+	cut = runeCut(string(line), cut)
+	out := make([]byte, 0, max)
+	out = append(out, line[:cut]...)
+	return appendTruncated(out, len(line)-cut)
+}
+
+// runeCut returns n, or less so s[:n] doesn't end in the middle of a rune
+func runeCut(s string, n int) int {
+	for n > 0 && n < len(s) && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return n
+}
+
+func appendTruncated(b []byte, n int) []byte {
+	b = append(b, "…(truncated "...)
+	b = strconv.AppendInt(b, int64(n), 10)
+	return append(b, " bytes)"...)
+}
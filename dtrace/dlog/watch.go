@@ -0,0 +1,110 @@
+package dlog
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// The backends on the os fs share a single fsnotify watcher, so hundreds of them don't use up the inotify
+// instances. A live file removed or moved away is reopened at once, and monitorFiles only polls the backends
+// not watched, like the ones on the in-memory fs, or whose dir is removed or can't be watched.
+var watcher struct {
+	sync.Mutex
+	w    *fsnotify.Watcher
+	dirs map[string][]*FileBackend // by the cleaned dirs
+}
+
+// watchFiles adds the dir of the backend to the watcher, it returns whether the dir is watched
+func (self *FileBackend) watchFiles() bool {
+	if _, ok := self.fs.(osFS); !ok {
+		return false
+	}
+	dir := filepath.Clean(self.dir)
+	watcher.Lock()
+	defer watcher.Unlock()
+	if watcher.w == nil {
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			return false
+		}
+		watcher.w, watcher.dirs = w, map[string][]*FileBackend{}
+		go watchDaemon(w)
+	}
+	if _, ok := watcher.dirs[dir]; !ok {
+		if err := watcher.w.Add(dir); err != nil {
+			return false
+		}
+	}
+	watcher.dirs[dir] = append(watcher.dirs[dir], self)
+	atomic.StoreInt32(&self.watched, 1)
+	return true
+}
+
+// unwatchFiles removes the backend from the watcher, the dir is unwatched if no other backends are in it
+func (self *FileBackend) unwatchFiles() {
+	if atomic.LoadInt32(&self.watched) == 0 {
+		return
+	}
+	dir := filepath.Clean(self.dir)
+	watcher.Lock()
+	defer watcher.Unlock()
+	backends := watcher.dirs[dir]
+	for i, fb := range backends {
+		if fb == self {
+			backends = append(backends[:i:i], backends[i+1:]...)
+			break
+		}
+	}
+	if len(backends) == 0 {
+		delete(watcher.dirs, dir)
+		watcher.w.Remove(dir)
+	} else {
+		watcher.dirs[dir] = backends
+	}
+	atomic.StoreInt32(&self.watched, 0)
+}
+
+func watchDaemon(w *fsnotify.Watcher) {
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if ev.Op&(fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			watcher.Lock()
+			if backends, ok := watcher.dirs[ev.Name]; ok {
+				// the dir itself is removed or moved, its backends are polled until it's watched again
+				delete(watcher.dirs, ev.Name)
+				w.Remove(ev.Name)
+				for _, fb := range backends {
+					atomic.StoreInt32(&fb.watched, 0)
+				}
+			}
+			backends := watcher.dirs[filepath.Dir(ev.Name)]
+			watcher.Unlock()
+			for _, fb := range backends {
+				fb.reopenMissing(filepath.Base(ev.Name))
+			}
+		case _, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			// the events may be lost as the queue overflows, check all the files
+			watcher.Lock()
+			var all []*FileBackend
+			for _, backends := range watcher.dirs {
+				all = append(all, backends...)
+			}
+			watcher.Unlock()
+			for _, fb := range all {
+				fb.reopenMissing("")
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"path/filepath"
+	"time"
+
+	"git.xiaojukeji.com/devops/MachineAlliance/self/commons/trace/dlog"
+	"github.com/tools-go/go-utils/dtrace"
+)
+
+// every module logs to its own dir with its own rotation, the traces of a module use its logger
+func newModuleLogger(dir, module string, config dlog.LogConfig) *dlog.Logger {
+	config.FileName = filepath.Join(dir, module)
+	logger, err := dlog.NewLoggerWithConfig(config)
+	if err != nil {
+		log.Fatalf("create the logger of %s failed: %s", module, err)
+	}
+	return logger
+}
+
+func main() {
+	dir := flag.String("dir", "./logs", "the root dir of the logs")
+	n := flag.Int("n", 10000, "the count of the requests")
+	flag.Parse()
+
+	config := dlog.LogConfig{
+		Type:            "file",
+		Level:           "INFO",
+		FileRotateCount: 5,
+		FileRotateSize:  1024 * 1024,
+		FileCompress:    "gzip",
+		SymlinkLatest:   1,
+	}
+	api := newModuleLogger(*dir, "api", config)
+	// the audit logs are rotated hourly, kept for a week and synced before rotating
+	config.RotateSchedule, config.KeepHours, config.FileSync = "hourly", 24*7, "rotate"
+	audit := newModuleLogger(*dir, "audit", config)
+	defer api.Close()
+	defer audit.Close()
+
+	for i := 0; i < *n; i++ {
+		tracer := dtrace.New("order").SetLogger(api)
+		tracer.Infof("event=[create] order=[%d]", i)
+		auditor := dtrace.WithParent(tracer, "audit").SetLogger(audit)
+		auditor.Infof("event=[create] order=[%d] user=[demo]", i)
+		time.Sleep(100 * time.Microsecond)
+	}
+	log.Printf("logs are written to %s/api and %s/audit", *dir, *dir)
+}
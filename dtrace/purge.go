@@ -0,0 +1,39 @@
+package dtrace
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"git.xiaojukeji.com/devops/MachineAlliance/self/commons/trace/dlog"
+)
+
+// Purge removes or redacts the logs of a subject, like a user, from the rotated files of the logger of
+// the traces and the loggers of the tenants, for the right-to-be-forgotten requests. Every log dir gets
+// an audit record of the files rewritten, see dlog.FileBackend.Purge.
+//
+//	dtrace.Purge(dlog.PurgeConfig{Match: dlog.MatchSubject(uid), Reason: "ticket-123"})
+func Purge(cfg dlog.PurgeConfig) (*dlog.PurgeRecord, error) {
+	loggers := []*dlog.Logger{dlog.GetLogger()}
+	if r := getTenantRouter(); r != nil {
+		r.mu.Lock()
+		for _, l := range r.loggers {
+			loggers = append(loggers, l)
+		}
+		r.mu.Unlock()
+	}
+	merged := &dlog.PurgeRecord{Time: time.Now(), Reason: cfg.Reason, Redacted: cfg.Redact != nil, Files: []dlog.PurgedFile{}}
+	var errs []string
+	for _, l := range loggers {
+		record, err := l.Purge(cfg)
+		merged.Files = append(merged.Files, record.Files...)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		merged.Error = strings.Join(errs, "; ")
+		return merged, fmt.Errorf("%s", merged.Error)
+	}
+	return merged, nil
+}
@@ -0,0 +1,118 @@
+package dtrace
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
+
+	"git.xiaojukeji.com/devops/MachineAlliance/self/commons/trace/dlog"
+	"github.com/gin-gonic/gin"
+)
+
+const tenantValueID = "51733"
+
+// TenantRouter creates a logger per tenant, writing the files of the tenant in its own dir, for the
+// platforms keeping the logs of the tenants apart
+type TenantRouter struct {
+	config    dlog.LogConfig
+	keepHours map[string]uint
+	mu        sync.Mutex
+	loggers   map[string]*dlog.Logger
+}
+
+var tenantNameReg = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+// NewTenantRouter creates the router, the logs of a tenant go to the dir FileName/tenant with the other
+// settings of config, and are kept for the hours of keepHours if the tenant is in it, or KeepHours of config
+func NewTenantRouter(config dlog.LogConfig, keepHours map[string]uint) *TenantRouter {
+	config.Type = "file"
+	return &TenantRouter{config: config, keepHours: keepHours, loggers: map[string]*dlog.Logger{}}
+}
+
+// Logger returns the logger of the tenant, created at the first call
+func (r *TenantRouter) Logger(tenant string) (*dlog.Logger, error) {
+	if !tenantNameReg.MatchString(tenant) {
+		return nil, fmt.Errorf("invalid tenant name: %q", tenant)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if l, ok := r.loggers[tenant]; ok {
+		return l, nil
+	}
+	config := r.config
+	config.FileName = filepath.Join(r.config.FileName, tenant)
+	if hours, ok := r.keepHours[tenant]; ok {
+		config.KeepHours = hours
+	}
+	l, err := dlog.NewLoggerWithConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	r.loggers[tenant] = l
+	return l, nil
+}
+
+// Close closes the loggers of the tenants
+func (r *TenantRouter) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, l := range r.loggers {
+		l.Close()
+	}
+}
+
+var tenantRouter atomic.Value // routerHolder
+
+type routerHolder struct{ *TenantRouter }
+
+// SetTenantRouter routes the logs of the traces with the tenants to the loggers of r,
+// nil logs them with the logger of the traces
+func SetTenantRouter(r *TenantRouter) {
+	tenantRouter.Store(routerHolder{r})
+}
+
+func getTenantRouter() *TenantRouter {
+	holder, _ := tenantRouter.Load().(routerHolder)
+	return holder.TenantRouter
+}
+
+// WithTenant returns the context of the tenant, the trace in it logs the field tenant=[name], and logs
+// to the files of the tenant if a TenantRouter is set. The child traces inherit the tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	tracer := GetTraceFromContext(ctx)
+	if t, ok := tracer.(*trace); ok {
+		tracer = t.withTenant(tenant)
+	}
+	if gctx, ok := ctx.(*gin.Context); ok {
+		gctx.Set(tenantValueID, tenant)
+		gctx.Set(tracerLogHandlerID, tracer)
+		return gctx
+	}
+	ctx = context.WithValue(ctx, tenantValueID, tenant)
+	return context.WithValue(ctx, tracerLogHandlerID, tracer)
+}
+
+// GetTenantFromContext returns the tenant of the context, or an empty string
+func GetTenantFromContext(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantValueID).(string); ok {
+		return tenant
+	}
+	return ""
+}
+
+func (t *trace) withTenant(tenant string) *trace {
+	ct := *t
+	ct.tenant = tenant
+	if router := getTenantRouter(); router != nil {
+		if l, err := router.Logger(tenant); err == nil {
+			ct.logger = l
+		} else {
+			t.Warnf("log to the files of the tenant failed: %s", err)
+		}
+	}
+	ct.head = ct.packHeader()
+	return &ct
+}
@@ -20,11 +20,13 @@ import (
 	"fmt"
 	"runtime"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"git.xiaojukeji.com/devops/MachineAlliance/self/commons/trace/dlog"
 
 	"github.com/nu7hatch/gouuid"
+	"github.com/tools-go/go-utils/utils/clock"
 )
 
 const (
@@ -80,6 +82,7 @@ type trace struct {
 	id        string
 	head      string
 	logger    dlogger
+	tenant    string // see WithTenant
 }
 
 //New will create a Trace using a name, identifying the trace process
@@ -94,19 +97,17 @@ func New(name string, id ...string) Trace {
 func WithParent(p Trace, name string) Trace {
 	t := &trace{
 		parent:    p,
-		startTime: time.Now(),
+		startTime: now(),
 		name:      name,
 		logger:    dlog.GetLogger(),
 	}
 	if p != nil {
 		t.id = p.ID()
-	} else {
-		id := ""
-		uid, err := uuid.NewV4()
-		if err == nil {
-			id = uid.String()
+		if pt, ok := p.(*trace); ok && pt.tenant != "" {
+			t.tenant, t.logger = pt.tenant, pt.logger
 		}
-		t.id = id
+	} else {
+		t.id = newID()
 	}
 
 	t.head = t.packHeader()
@@ -117,7 +118,7 @@ func WithParent(p Trace, name string) Trace {
 func WithID(name string, id string) Trace {
 	t := &trace{
 		parent:    nil,
-		startTime: time.Now(),
+		startTime: now(),
 		name:      name,
 		id:        id,
 		logger:    dlog.GetLogger(),
@@ -126,6 +127,57 @@ func WithID(name string, id string) Trace {
 	return t
 }
 
+var idGenerator atomic.Value // func() string
+
+// SetIDGenerator sets the generator of the ids of the new root traces, like a counter for the golden tests
+// comparing the logs byte by byte, nil restores the random uuids
+func SetIDGenerator(gen func() string) {
+	idGenerator.Store(gen)
+}
+
+func newID() string {
+	if gen, ok := idGenerator.Load().(func() string); ok && gen != nil {
+		return gen()
+	}
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return ""
+	}
+	return uid.String()
+}
+
+var traceClock atomic.Value // clockHolder
+
+type clockHolder struct{ clock.Clock }
+
+// SetClock sets the clock of the start times and the durations of the traces, like a clock.FakeClock
+// for the golden tests, nil restores the real clock
+func SetClock(c clock.Clock) {
+	traceClock.Store(clockHolder{c})
+}
+
+func now() time.Time {
+	if h, ok := traceClock.Load().(clockHolder); ok && h.Clock != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
+
+var initialFields atomic.Value // string
+
+// SetInitialFields sets the fields logged by all the traces created afterwards, like the deployment metadata,
+// kvs are the pairs of the keys and the values
+func SetInitialFields(kvs ...string) {
+	var buffer bytes.Buffer
+	for i := 0; i+1 < len(kvs); i += 2 {
+		buffer.WriteString(kvs[i])
+		buffer.WriteString("=[")
+		buffer.WriteString(kvs[i+1])
+		buffer.WriteString("] ")
+	}
+	initialFields.Store(buffer.String())
+}
+
 func (t *trace) packHeader() string {
 	var buffer bytes.Buffer
 
@@ -137,6 +189,16 @@ func (t *trace) packHeader() string {
 	buffer.WriteString(t.ID())
 	buffer.WriteString("] ")
 
+	if t.tenant != "" {
+		buffer.WriteString("tenant=[")
+		buffer.WriteString(t.tenant)
+		buffer.WriteString("] ")
+	}
+
+	if fields, ok := initialFields.Load().(string); ok {
+		buffer.WriteString(fields)
+	}
+
 	if t.parent != nil {
 		buffer.WriteString("tancestor=[")
 		for np := t.parent; np != nil; np = np.Parent() {
@@ -179,7 +241,7 @@ func (t *trace) Start() time.Time {
 
 func (t *trace) Duration() time.Duration {
 	// time.Millisecond
-	return time.Since(t.startTime) / time.Millisecond
+	return now().Sub(t.startTime) / time.Millisecond
 }
 
 // copy this from glog
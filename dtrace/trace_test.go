@@ -0,0 +1,78 @@
+package dtrace
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"git.xiaojukeji.com/devops/MachineAlliance/self/commons/trace/dlog"
+	"github.com/tools-go/go-utils/utils/clock"
+)
+
+func TestGoldenLog(t *testing.T) {
+	fs := dlog.NewMemFS()
+	fb, err := dlog.NewFileBackendFS("/logs", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := clock.NewFakeClock(time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local))
+	logger := dlog.NewLogger(dlog.INFO, fb)
+	logger.SetClock(c)
+	n := 0
+	SetIDGenerator(func() string { n++; return fmt.Sprintf("id-%d", n) })
+	SetClock(c)
+	defer SetIDGenerator(nil)
+	defer SetClock(nil)
+
+	parent := New("golden").SetLogger(logger)
+	child := WithParent(parent, "child").SetLogger(logger)
+	c.Advance(1500 * time.Millisecond)
+	parent.Infof("hello %s", "world")
+	_, _, line, _ := runtime.Caller(0)
+	child.Warnf("bye")
+	fb.Flush()
+
+	for name, expected := range map[string]string{
+		"INFO.log":    fmt.Sprintf("2020-01-02 03:04:06.500000 INFO dtrace/trace_test.go:%d tname=[golden] tid=[id-1] tduration=[1500] hello world\n", line-1),
+		"WARNING.log": fmt.Sprintf("2020-01-02 03:04:06.500000 WARNING dtrace/trace_test.go:%d tname=[child] tid=[id-1] tancestor=[golden] tduration=[1500] bye\n", line+1),
+	} {
+		f, err := fs.Open("/logs/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := ioutil.ReadAll(f); string(data) != expected {
+			t.Fatalf("unexpected %s:\n%s\nexpected:\n%s", name, data, expected)
+		}
+	}
+}
+
+func TestTenant(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "dtrace-tenant")
+	defer os.RemoveAll(dir)
+	router := NewTenantRouter(dlog.LogConfig{Level: "INFO", FileName: dir}, map[string]uint{"acme": 24})
+	defer router.Close()
+	SetTenantRouter(router)
+	defer SetTenantRouter(nil)
+
+	ctx := WithTenant(WithTraceForContext(context.Background(), "req"), "acme")
+	if tenant := GetTenantFromContext(ctx); tenant != "acme" {
+		t.Fatalf("unexpected tenant %q", tenant)
+	}
+	WithParent(GetTraceFromContext(ctx), "db").Infof("query done")
+	l, _ := router.Logger("acme")
+	l.Sync(context.Background())
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "acme", "INFO.log"))
+	if !strings.Contains(string(data), "tname=[db]") || !strings.Contains(string(data), "tenant=[acme] tancestor=[req]") {
+		t.Fatalf("expect the child logged to the files of the tenant, got %q", data)
+	}
+
+	if _, err := router.Logger("../acme"); err == nil {
+		t.Fatal("expect the invalid tenant rejected")
+	}
+}
@@ -0,0 +1,290 @@
+// Package eventbus is an in-process publish/subscribe bus with typed topics,
+// so the modules of a service can communicate without importing each other:
+//
+//	var ConfigChanged = eventbus.NewTopic[Config]("config.changed")
+//
+//	// in the subscriber module
+//	eventbus.Subscribe(eventbus.Default, ConfigChanged, func(ctx context.Context, cfg Config) { ... })
+//	// in the publisher module
+//	eventbus.Publish(ctx, eventbus.Default, ConfigChanged, cfg)
+//
+// Every subscriber has its own buffer and goroutine, so a slow or panicking subscriber won't affect the others.
+package eventbus
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/trace"
+)
+
+// DefaultBufferSize is the default buffer size of the subscribers
+const DefaultBufferSize = 64
+
+// ErrClosed is returned when publishing to a closed bus
+var ErrClosed = errors.New("eventbus closed")
+
+// Topic is a named topic of the events of type T
+type Topic[T any] struct {
+	name string
+}
+
+// NewTopic creates a topic, the topics of the same name should have the same type
+func NewTopic[T any](name string) Topic[T] {
+	return Topic[T]{name: name}
+}
+
+// Name of the topic
+func (t Topic[T]) Name() string {
+	return t.name
+}
+
+// Policy decides what to do when the buffer of a subscriber is full
+type Policy int
+
+// slow consumer policies
+const (
+	// Block blocks the publisher until the buffer is available or the context is done
+	Block Policy = iota
+	// DropNewest drops the event being published
+	DropNewest
+	// DropOldest drops the oldest event in the buffer to make room for the new one
+	DropOldest
+)
+
+type options struct {
+	bufferSize int
+	policy     Policy
+}
+
+// SubscribeOption for Subscribe
+type SubscribeOption func(opts *options)
+
+// WithBufferSize set the buffer size of the subscriber
+func WithBufferSize(size int) SubscribeOption {
+	return func(opts *options) {
+		opts.bufferSize = size
+	}
+}
+
+// WithPolicy set the slow consumer policy of the subscriber, default is Block
+func WithPolicy(policy Policy) SubscribeOption {
+	return func(opts *options) {
+		opts.policy = policy
+	}
+}
+
+type envelope struct {
+	ctx   context.Context
+	event interface{}
+}
+
+type subscriber struct {
+	topic   string
+	handler func(ctx context.Context, event interface{})
+	policy  Policy
+	// mu orders the sends to ch before it is closed, the publishers hold the read lock while sending
+	mu      sync.RWMutex
+	ch      chan envelope
+	done    chan struct{}
+	once    sync.Once
+	dropped int64
+	wg      sync.WaitGroup
+	// gid is the id of the goroutine running the handler
+	gid int64
+}
+
+func (s *subscriber) run() {
+	defer s.wg.Done()
+	atomic.StoreInt64(&s.gid, goid())
+	for env := range s.ch {
+		s.handle(env)
+	}
+}
+
+// wait waits for the buffered events handled, unless it is called by the handler itself,
+// which would wait for its own goroutine forever
+func (s *subscriber) wait() {
+	if atomic.LoadInt64(&s.gid) == goid() {
+		return
+	}
+	s.wg.Wait()
+}
+
+// goid returns the id of the current goroutine, parsed from the header of its stack: "goroutine 18 [running]:"
+func goid() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
+
+// handle calls the handler, and recovers the panics so the subscriber keeps working
+func (s *subscriber) handle(env envelope) {
+	defer trace.HandleCrash(func(r interface{}) {
+		trace.GetTraceFromContext(env.ctx).Errorf("eventbus subscriber of %s panic: %v, detail: %s", s.topic, r, trace.Stacks(false))
+	})
+	s.handler(env.ctx, env.event)
+}
+
+func (s *subscriber) deliver(ctx context.Context, env envelope) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+	switch s.policy {
+	case DropNewest:
+		select {
+		case s.ch <- env:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- env:
+				return nil
+			default:
+			}
+			select {
+			case <-s.ch:
+				atomic.AddInt64(&s.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.ch <- env:
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Bus is an event bus, the zero value is not usable, use New instead
+type Bus struct {
+	mu     sync.RWMutex
+	subs   map[string][]*subscriber
+	closed bool
+}
+
+// Default is the default bus of the process
+var Default = New()
+
+// New creates a bus
+func New() *Bus {
+	return &Bus{subs: map[string][]*subscriber{}}
+}
+
+// Subscribe calls handler with the events of the topic in order, until the returned unsubscribe func is called.
+// The context of the publisher is passed to the handler, so the trace is kept.
+func Subscribe[T any](bus *Bus, topic Topic[T], handler func(ctx context.Context, event T), ops ...SubscribeOption) (unsubscribe func()) {
+	opts := &options{bufferSize: DefaultBufferSize}
+	for _, op := range ops {
+		op(opts)
+	}
+	if opts.bufferSize <= 0 {
+		opts.bufferSize = 1
+	}
+	s := &subscriber{
+		topic: topic.name,
+		handler: func(ctx context.Context, event interface{}) {
+			handler(ctx, event.(T))
+		},
+		policy: opts.policy,
+		ch:     make(chan envelope, opts.bufferSize),
+		done:   make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run()
+
+	bus.mu.Lock()
+	bus.subs[topic.name] = append(bus.subs[topic.name], s)
+	bus.mu.Unlock()
+	return func() {
+		bus.remove(s)
+		s.wait()
+	}
+}
+
+// remove removes the subscriber and stops it after the buffered events are handled
+func (bus *Bus) remove(s *subscriber) {
+	bus.mu.Lock()
+	subs := bus.subs[s.topic]
+	for i := range subs {
+		if subs[i] == s {
+			bus.subs[s.topic] = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	bus.mu.Unlock()
+
+	s.once.Do(func() {
+		// unblock the publishers waiting for s first, they are holding the read lock of s
+		close(s.done)
+		s.mu.Lock()
+		// no one is publishing to s now, since the write lock is held
+		close(s.ch)
+		s.mu.Unlock()
+	})
+}
+
+// Publish sends the event to all the subscribers of the topic, it blocks only if a subscriber
+// with the Block policy is full, and returns the ctx error if ctx is done while blocking
+func Publish[T any](ctx context.Context, bus *Bus, topic Topic[T], event T) error {
+	// deliver without holding the lock of the bus, so the handlers blocking the publisher
+	// can still subscribe or unsubscribe
+	bus.mu.RLock()
+	closed, subs := bus.closed, bus.subs[topic.name]
+	bus.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	env := envelope{ctx: ctx, event: event}
+	for _, s := range subs {
+		if err := s.deliver(ctx, env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dropped returns the count of the dropped events of the topic by the slow consumer policies
+func (bus *Bus) Dropped(topic string) int64 {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	var dropped int64
+	for _, s := range bus.subs[topic] {
+		dropped += atomic.LoadInt64(&s.dropped)
+	}
+	return dropped
+}
+
+// Close stops the bus, and waits for the subscribers to handle the buffered events
+func (bus *Bus) Close() {
+	bus.mu.Lock()
+	bus.closed = true
+	var all []*subscriber
+	for _, subs := range bus.subs {
+		all = append(all, subs...)
+	}
+	bus.mu.Unlock()
+	for _, s := range all {
+		bus.remove(s)
+		s.wait()
+	}
+}
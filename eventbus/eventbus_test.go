@@ -0,0 +1,179 @@
+package eventbus
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type configChanged struct {
+	Key   string
+	Value string
+}
+
+var (
+	configTopic = NewTopic[configChanged]("config.changed")
+	countTopic  = NewTopic[int]("count")
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+	ctx := context.TODO()
+
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+	unsubscribe := Subscribe(bus, configTopic, func(ctx context.Context, e configChanged) {
+		mu.Lock()
+		received = append(received, e.Key+"="+e.Value)
+		mu.Unlock()
+	})
+	// a panicking subscriber does not affect the others
+	Subscribe(bus, configTopic, func(ctx context.Context, e configChanged) {
+		panic("boom")
+	})
+
+	for _, v := range []string{"1", "2", "3"} {
+		if err := Publish(ctx, bus, configTopic, configChanged{Key: "k", Value: v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// events of other topics are not delivered
+	Publish(ctx, bus, countTopic, 1)
+	unsubscribe()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 3 || received[0] != "k=1" || received[2] != "k=3" {
+		t.Fatalf("unexpected events: %v", received)
+	}
+	Publish(ctx, bus, configTopic, configChanged{Key: "k", Value: "4"})
+	if len(received) != 3 {
+		t.Fatalf("expect no events after unsubscribed: %v", received)
+	}
+}
+
+func TestSlowConsumerPolicies(t *testing.T) {
+	ctx := context.TODO()
+	for _, tc := range []struct {
+		policy   Policy
+		expect   []int
+		dropped  int64
+		blocking bool
+	}{
+		{policy: DropNewest, expect: []int{0, 1}, dropped: 3},
+		{policy: DropOldest, expect: []int{3, 4}, dropped: 3},
+		{policy: Block, blocking: true},
+	} {
+		bus := New()
+		release := make(chan struct{})
+		var received []int
+		started := make(chan struct{})
+		unsubscribe := Subscribe(bus, countTopic, func(ctx context.Context, n int) {
+			if n == -1 {
+				close(started)
+				<-release
+				return
+			}
+			received = append(received, n)
+		}, WithBufferSize(2), WithPolicy(tc.policy))
+
+		Publish(ctx, bus, countTopic, -1)
+		<-started
+		if tc.blocking {
+			Publish(ctx, bus, countTopic, 0)
+			Publish(ctx, bus, countTopic, 1)
+			timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			if err := Publish(timeout, bus, countTopic, 2); err != context.DeadlineExceeded {
+				t.Fatalf("expect publish blocked, got %v", err)
+			}
+			cancel()
+			close(release)
+			unsubscribe()
+			continue
+		}
+		for i := 0; i < 5; i++ {
+			Publish(ctx, bus, countTopic, i)
+		}
+		if dropped := bus.Dropped(countTopic.Name()); dropped != tc.dropped {
+			t.Fatalf("policy %d: expect %d dropped, got %d", tc.policy, tc.dropped, dropped)
+		}
+		close(release)
+		unsubscribe()
+		if len(received) != len(tc.expect) || received[0] != tc.expect[0] || received[1] != tc.expect[1] {
+			t.Fatalf("policy %d: unexpected events %v", tc.policy, received)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	bus := New()
+	var count int
+	Subscribe(bus, countTopic, func(ctx context.Context, n int) {
+		time.Sleep(time.Millisecond)
+		count += n
+	})
+	for i := 0; i < 10; i++ {
+		Publish(context.TODO(), bus, countTopic, 1)
+	}
+	bus.Close()
+	if count != 10 {
+		t.Fatalf("expect buffered events handled before closed, got %d", count)
+	}
+	if err := Publish(context.TODO(), bus, countTopic, 1); err != ErrClosed {
+		t.Fatalf("expect closed, got %v", err)
+	}
+}
+
+func TestUnsubscribeInHandler(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+	var unsubscribe func()
+	done := make(chan struct{})
+	unsubscribe = Subscribe(bus, countTopic, func(ctx context.Context, n int) {
+		// waiting for the handler itself would deadlock
+		unsubscribe()
+		close(done)
+	})
+	Publish(context.TODO(), bus, countTopic, 1)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("unsubscribe in the handler blocked")
+	}
+}
+
+func TestSubscribeInBlockingHandler(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+	ctx := context.TODO()
+	release := make(chan struct{})
+	subscribed := make(chan struct{})
+	Subscribe(bus, countTopic, func(ctx context.Context, n int) {
+		if n == 0 {
+			<-release
+			Subscribe(bus, configTopic, func(ctx context.Context, e configChanged) {})
+			close(subscribed)
+		}
+	}, WithBufferSize(1))
+
+	Publish(ctx, bus, countTopic, 0)
+	Publish(ctx, bus, countTopic, 1)
+	published := make(chan struct{})
+	go func() {
+		// blocks on the full subscriber until it subscribes
+		Publish(ctx, bus, countTopic, 2)
+		close(published)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	select {
+	case <-subscribed:
+	case <-time.After(time.Second):
+		t.Fatal("subscribe in the handler blocked by the publisher")
+	}
+	<-published
+}
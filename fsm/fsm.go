@@ -0,0 +1,186 @@
+// Package fsm is a finite state machine helper for the order/workflow style services.
+//
+// The machine is stateless, the current state is kept by the caller(e.g. a column of the order table),
+// and Fire checks the transition, runs the guard and the action, and returns the new state:
+//
+//	m := fsm.MustNew("order",
+//		fsm.Transition[*Order]{From: []fsm.State{"created"}, Event: "pay", To: "paid", Guard: checkAmount, Action: charge},
+//		fsm.Transition[*Order]{From: []fsm.State{"created", "paid"}, Event: "cancel", To: "cancelled", Action: refund},
+//	)
+//	order.State, err = m.Fire(ctx, order.State, "pay", order)
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/tools-go/go-utils/trace"
+)
+
+// State of the machine
+type State string
+
+// Event triggers the transitions
+type Event string
+
+// Transition moves the machine from any of the From states to the To state on the Event.
+// Guard rejects the transition by returning an error, and Action is run before the state is changed,
+// the state is kept if the action fails.
+type Transition[T any] struct {
+	From   []State
+	Event  Event
+	To     State
+	Guard  func(ctx context.Context, obj T) error
+	Action func(ctx context.Context, obj T) error
+}
+
+// TransitionError is returned if the event is not allowed in the state
+type TransitionError struct {
+	Machine string
+	State   State
+	Event   Event
+}
+
+func (err *TransitionError) Error() string {
+	return fmt.Sprintf("fsm %s: event %s is not allowed in state %s", err.Machine, err.Event, err.State)
+}
+
+// IsTransitionError checks if the err is a TransitionError
+func IsTransitionError(err error) bool {
+	_, ok := err.(*TransitionError)
+	return ok
+}
+
+// GuardError is returned if the transition is rejected by the guard
+type GuardError struct {
+	Machine string
+	State   State
+	Event   Event
+	Err     error
+}
+
+func (err *GuardError) Error() string {
+	return fmt.Sprintf("fsm %s: event %s in state %s is rejected: %s", err.Machine, err.Event, err.State, err.Err)
+}
+
+// Unwrap returns the error of the guard
+func (err *GuardError) Unwrap() error {
+	return err.Err
+}
+
+// IsGuardError checks if the err is a GuardError
+func IsGuardError(err error) bool {
+	_, ok := err.(*GuardError)
+	return ok
+}
+
+// Hook is called after a transition is done
+type Hook[T any] func(ctx context.Context, from, to State, event Event, obj T)
+
+// FSM is the state machine of the objects of type T
+type FSM[T any] struct {
+	name        string
+	transitions map[State]map[Event]*Transition[T]
+	hooks       []Hook[T]
+}
+
+// New creates a state machine, a state can have at most one transition of an event
+func New[T any](name string, transitions ...Transition[T]) (*FSM[T], error) {
+	m := &FSM[T]{name: name, transitions: map[State]map[Event]*Transition[T]{}}
+	for i := range transitions {
+		t := &transitions[i]
+		if t.Event == "" || t.To == "" || len(t.From) == 0 {
+			return nil, fmt.Errorf("fsm %s: transition #%d should have the from states, the event and the to state", name, i)
+		}
+		for _, from := range t.From {
+			events, ok := m.transitions[from]
+			if !ok {
+				events = map[Event]*Transition[T]{}
+				m.transitions[from] = events
+			}
+			if _, ok := events[t.Event]; ok {
+				return nil, fmt.Errorf("fsm %s: duplicated transition of event %s in state %s", name, t.Event, from)
+			}
+			events[t.Event] = t
+		}
+	}
+	return m, nil
+}
+
+// MustNew is like New but panics on the invalid transitions
+func MustNew[T any](name string, transitions ...Transition[T]) *FSM[T] {
+	m, err := New(name, transitions...)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// OnTransition adds a hook of the transitions, e.g. to persist the state or publish the changes,
+// it should be called before the machine is used
+func (m *FSM[T]) OnTransition(hook Hook[T]) {
+	m.hooks = append(m.hooks, hook)
+}
+
+// Can checks if the event is allowed in the state, the guard is not checked
+func (m *FSM[T]) Can(state State, event Event) bool {
+	_, ok := m.transitions[state][event]
+	return ok
+}
+
+// Events returns the allowed events in the state, in lexicographical order
+func (m *FSM[T]) Events(state State) []Event {
+	events := make([]Event, 0, len(m.transitions[state]))
+	for e := range m.transitions[state] {
+		events = append(events, e)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i] < events[j] })
+	return events
+}
+
+// Fire triggers the event in the state, and returns the new state.
+// The current state is returned with a TransitionError, a GuardError or the error of the action if it fails.
+func (m *FSM[T]) Fire(ctx context.Context, state State, event Event, obj T) (State, error) {
+	tracer := trace.GetTraceFromContext(ctx)
+	t, ok := m.transitions[state][event]
+	if !ok {
+		err := &TransitionError{Machine: m.name, State: state, Event: event}
+		tracer.Warn(err)
+		return state, err
+	}
+	if t.Guard != nil {
+		if err := t.Guard(ctx, obj); err != nil {
+			gerr := &GuardError{Machine: m.name, State: state, Event: event, Err: err}
+			tracer.Warn(gerr)
+			return state, gerr
+		}
+	}
+	if t.Action != nil {
+		if err := t.Action(ctx, obj); err != nil {
+			tracer.Errorf("fsm %s: action of %s --%s--> %s failed: %s", m.name, state, event, t.To, err)
+			return state, err
+		}
+	}
+	tracer.Infof("fsm %s: %s --%s--> %s", m.name, state, event, t.To)
+	for _, hook := range m.hooks {
+		hook(ctx, state, t.To, event, obj)
+	}
+	return t.To, nil
+}
+
+// Dot renders the machine in the graphviz dot language, which is handy for the documents
+func (m *FSM[T]) Dot() string {
+	states := make([]string, 0, len(m.transitions))
+	for s := range m.transitions {
+		states = append(states, string(s))
+	}
+	sort.Strings(states)
+	dot := fmt.Sprintf("digraph %q {\n", m.name)
+	for _, s := range states {
+		for _, e := range m.Events(State(s)) {
+			dot += fmt.Sprintf("\t%q -> %q [label=%q];\n", s, m.transitions[State(s)][e].To, e)
+		}
+	}
+	return dot + "}\n"
+}
@@ -0,0 +1,96 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type order struct {
+	Amount int
+	Paid   int
+	Logs   []string
+}
+
+func newOrderFSM(t *testing.T) *FSM[*order] {
+	m, err := New("order",
+		Transition[*order]{
+			From:  []State{"created"},
+			Event: "pay",
+			To:    "paid",
+			Guard: func(ctx context.Context, o *order) error {
+				if o.Amount <= 0 {
+					return errors.New("invalid amount")
+				}
+				return nil
+			},
+			Action: func(ctx context.Context, o *order) error {
+				if o.Amount > 100 {
+					return errors.New("insufficient balance")
+				}
+				o.Paid = o.Amount
+				return nil
+			},
+		},
+		Transition[*order]{From: []State{"created", "paid"}, Event: "cancel", To: "cancelled"},
+		Transition[*order]{From: []State{"paid"}, Event: "ship", To: "shipped"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.OnTransition(func(ctx context.Context, from, to State, event Event, o *order) {
+		o.Logs = append(o.Logs, string(from)+">"+string(to))
+	})
+	return m
+}
+
+func TestFire(t *testing.T) {
+	ctx := context.TODO()
+	m := newOrderFSM(t)
+
+	o := &order{Amount: 10}
+	state, err := m.Fire(ctx, "created", "ship", o)
+	if !IsTransitionError(err) || state != "created" {
+		t.Fatalf("expect transition error, got %s, %v", state, err)
+	}
+	state, err = m.Fire(ctx, "created", "pay", &order{})
+	if !IsGuardError(err) || state != "created" || errors.Unwrap(err).Error() != "invalid amount" {
+		t.Fatalf("expect guard error, got %s, %v", state, err)
+	}
+	state, err = m.Fire(ctx, "created", "pay", &order{Amount: 1000})
+	if err == nil || err.Error() != "insufficient balance" || state != "created" {
+		t.Fatalf("expect action error, got %s, %v", state, err)
+	}
+
+	for _, event := range []Event{"pay", "ship"} {
+		if state, err = m.Fire(ctx, state, event, o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if state != "shipped" || o.Paid != 10 || strings.Join(o.Logs, ",") != "created>paid,paid>shipped" {
+		t.Fatalf("unexpected result: %s %+v", state, o)
+	}
+
+	if !m.Can("paid", "cancel") || m.Can("shipped", "cancel") {
+		t.Fatal("unexpected Can")
+	}
+	if events := m.Events("paid"); len(events) != 2 || events[0] != "cancel" || events[1] != "ship" {
+		t.Fatalf("unexpected events: %v", events)
+	}
+	if dot := m.Dot(); !strings.Contains(dot, `"created" -> "paid" [label="pay"];`) {
+		t.Fatalf("unexpected dot:\n%s", dot)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	if _, err := New("x", Transition[int]{From: []State{"a"}, Event: "e"}); err == nil {
+		t.Fatal("expect missing to state")
+	}
+	_, err := New("x",
+		Transition[int]{From: []State{"a"}, Event: "e", To: "b"},
+		Transition[int]{From: []State{"a"}, Event: "e", To: "c"})
+	if err == nil {
+		t.Fatal("expect duplicated transition")
+	}
+}
@@ -0,0 +1,32 @@
+package ginmiddleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tools-go/go-utils/abuse"
+	"github.com/tools-go/go-utils/errors"
+)
+
+// Abuse evaluates the requests with d, the blocked ones are rejected, and the others are passed
+// with the decision in the request context, which can be got by abuse.FromContext(c.Request.Context())
+func Abuse(d *abuse.Detector) Middleware {
+	return func(next gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req, dec := d.Evaluate(c.Request)
+			d.Log(c, req, dec)
+			c.Request = c.Request.WithContext(abuse.WithDecision(c.Request.Context(), dec))
+			if dec.Action == abuse.Block {
+				if h := d.BlockHandler(); h != nil {
+					h(c.Writer, c.Request)
+					c.Abort()
+					return
+				}
+				c.AbortWithStatusJSON(http.StatusForbidden, errors.ErrSwitch(errors.NewForbiddenError("request blocked")))
+				return
+			}
+			next(c)
+			d.Learn(req, c.Writer.Status())
+		}
+	}
+}
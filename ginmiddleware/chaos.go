@@ -0,0 +1,25 @@
+package ginmiddleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tools-go/go-utils/chaos"
+)
+
+// Chaos injects the faults of inj into the requests, the handlers are skipped if a fault replies or aborts
+func Chaos(inj *chaos.Injector) Middleware {
+	m := inj.Middleware()
+	return func(next gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			handled := false
+			m(func(w http.ResponseWriter, r *http.Request) {
+				handled = true
+				next(c)
+			})(c.Writer, c.Request)
+			if !handled {
+				c.Abort()
+			}
+		}
+	}
+}
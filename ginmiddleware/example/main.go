@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tools-go/go-utils/dtrace"
+	"github.com/tools-go/go-utils/ginmiddleware"
+	"github.com/tools-go/go-utils/loadshed"
+	"github.com/tools-go/go-utils/middleware"
+	"github.com/tools-go/go-utils/priority"
+)
+
+// chain wraps h with the middlewares, the first one is the outermost
+func chain(h gin.HandlerFunc, mws ...ginmiddleware.Middleware) gin.HandlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+func main() {
+	addr := flag.String("addr", ":8002", "the listen addr")
+	maxGoroutines := flag.Int("max-goroutines", 10000, "shed the requests above the goroutines")
+	flag.Parse()
+
+	ipfilter, err := middleware.NewIPFilter(middleware.IPFilterConfig{
+		Deny:           []string{"192.0.2.0/24"},
+		TrustedProxies: []string{"127.0.0.1"},
+	})
+	if err != nil {
+		log.Fatalf("create the ip filter failed: %s", err)
+	}
+	classifier := priority.NewClassifier(
+		priority.Route{Path: "/healthz", Priority: priority.Critical},
+		priority.Route{Path: "/reports/*", Priority: priority.Background},
+	)
+	shedder := loadshed.New(context.Background(), loadshed.Config{
+		Gauges: []loadshed.Gauge{loadshed.GoroutineGauge(*maxGoroutines)},
+	})
+	ginmiddleware.SetDefaultResponseInterceptor(ginmiddleware.NewLogRecorder())
+
+	// recover and trace first, so the rejections of the others are logged with the trace id
+	mws := []ginmiddleware.Middleware{
+		ginmiddleware.RecoverWithTrace("example"),
+		ginmiddleware.IPFilter(ipfilter),
+		ginmiddleware.Priority(classifier),
+		ginmiddleware.LoadShed(shedder),
+	}
+	r := gin.New()
+	r.GET("/healthz", chain(func(c *gin.Context) { c.String(http.StatusOK, "ok") }, mws...))
+	r.GET("/orders/:id", chain(func(c *gin.Context) {
+		dtrace.GetTraceFromContext(c).Infof("event=[get order] id=[%s]", c.Param("id"))
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id")})
+	}, mws...))
+	r.GET("/reports/daily", chain(func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"priority": priority.FromContext(c.Request.Context()).String()})
+	}, mws...))
+	r.GET("/panic", chain(func(c *gin.Context) { panic("boom") }, mws...))
+
+	log.Fatal(r.Run(*addr))
+}
+
+// curl -v http://127.0.0.1:8002/orders/1
+// curl -v http://127.0.0.1:8002/panic
@@ -0,0 +1,26 @@
+package ginmiddleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tools-go/go-utils/dtrace"
+	"github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/middleware"
+)
+
+// IPFilter rejects the requests of the clients denied by f with 403, and logs the rejections for the audit
+func IPFilter(f *middleware.IPFilter) Middleware {
+	return func(next gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			ip := f.ClientIP(c.Request)
+			if ok, rule := f.Check(ip); !ok {
+				dtrace.GetTraceFromContext(c).Warnf("event=[ip-rejected] ip=[%s] remote=[%s] rule=[%s] method=[%s] url=[%s]",
+					ip, c.Request.RemoteAddr, rule, c.Request.Method, c.Request.URL.String())
+				c.AbortWithStatusJSON(http.StatusForbidden, errors.ErrSwitch(errors.NewForbiddenError("ip not allowed")))
+				return
+			}
+			next(c)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package ginmiddleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tools-go/go-utils/loadshed"
+)
+
+// LoadShed replies 503 with Retry-After to the requests shed by s
+func LoadShed(s *loadshed.Shedder) Middleware {
+	return func(next gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			if !s.Allow(c.Request) {
+				c.Header("Retry-After", s.RetryAfter())
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, loadshed.Overloaded)
+				return
+			}
+			next(c)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package ginmiddleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/tools-go/go-utils/priority"
+)
+
+// Priority stores the priority classified by cl in the request context, the routes are matched by the full path of gin
+func Priority(cl *priority.Classifier) Middleware {
+	return func(next gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			p := cl.Classify(c.Request, c.FullPath())
+			c.Request = c.Request.WithContext(priority.WithPriority(c.Request.Context(), p))
+			next(c)
+		}
+	}
+}
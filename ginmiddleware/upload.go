@@ -0,0 +1,356 @@
+package ginmiddleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tools-go/go-utils/dtrace"
+	"github.com/tools-go/go-utils/storage"
+)
+
+// default limits of Upload
+const (
+	DefaultMaxFileSize  = 32 << 20
+	DefaultMaxTotalSize = 128 << 20
+	DefaultMaxFiles     = 10
+	// the size limit of a non-file form value
+	maxFormValueSize = 1 << 20
+)
+
+// the context keys of the upload results
+const (
+	uploadedFilesKey = "ginmiddleware.uploadedFiles"
+	uploadedFormKey  = "ginmiddleware.uploadedForm"
+)
+
+// the codes of UploadError
+const (
+	UploadInvalidRequest  = "invalid_request"
+	UploadTooLarge        = "too_large"
+	UploadTooManyFiles    = "too_many_files"
+	UploadTypeNotAllowed  = "type_not_allowed"
+	UploadFieldNotAllowed = "field_not_allowed"
+	UploadRejected        = "rejected"
+	UploadStorageFailed   = "storage_failed"
+)
+
+// UploadedFile is a file part stored by Upload
+type UploadedFile struct {
+	Field    string `json:"field"`
+	Filename string `json:"filename"`
+	// ContentType is detected by the content, the one declared by the client is ignored
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	// Key of the object in the storage
+	Key string `json:"key"`
+}
+
+// UploadError is the structured error replied when an upload is rejected
+type UploadError struct {
+	Status   int    `json:"-"`
+	Code     string `json:"code"`
+	Msg      string `json:"msg"`
+	Field    string `json:"field,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+func (e *UploadError) Error() string {
+	if e.Filename != "" {
+		return fmt.Sprintf("upload %s rejected: %s", e.Filename, e.Msg)
+	}
+	return fmt.Sprintf("upload rejected: %s", e.Msg)
+}
+
+// IsUploadError judges error is an *UploadError
+func IsUploadError(err error) bool {
+	_, ok := err.(*UploadError)
+	return ok
+}
+
+// UploadConfig is the config of Upload
+type UploadConfig struct {
+	// Store is where the files are streamed to
+	Store storage.Blob
+	// Prefix of the object keys, default is "uploads"
+	Prefix string
+	// Key generates the object key of a file, default is "<prefix>/<date>/<random>/<filename>"
+	Key func(c *gin.Context, file *UploadedFile) string
+
+	MaxFileSize  int64
+	MaxTotalSize int64
+	MaxFiles     int
+	// AllowedTypes are the detected content types allowed, like "image/png" or "image/*",
+	// all types are allowed if it is empty
+	AllowedTypes []string
+	// AllowedFields are the form fields which can carry files, all fields are allowed if it is empty
+	AllowedFields []string
+
+	// Scan is called for every stored file before the handler runs, r reads the stored content,
+	// the upload is rejected if it returns an error, e.g. when a virus is found
+	Scan func(ctx context.Context, file *UploadedFile, r io.Reader) error
+}
+
+// Upload middleware streams the files of a multipart request to the storage, the handler
+// can get them by UploadedFiles and the other form values by UploadedForm.
+// A rejected request is replied with an UploadError as json, and the stored files are deleted.
+func Upload(cfg UploadConfig) Middleware {
+	if cfg.Store == nil {
+		panic("ginmiddleware: Upload requires a storage")
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "uploads"
+	}
+	if cfg.Key == nil {
+		cfg.Key = func(c *gin.Context, file *UploadedFile) string {
+			return path.Join(cfg.Prefix, time.Now().Format("20060102"), randomHex(8), safeFilename(file.Filename))
+		}
+	}
+	if cfg.MaxFileSize <= 0 {
+		cfg.MaxFileSize = DefaultMaxFileSize
+	}
+	if cfg.MaxTotalSize <= 0 {
+		cfg.MaxTotalSize = DefaultMaxTotalSize
+	}
+	if cfg.MaxFiles <= 0 {
+		cfg.MaxFiles = DefaultMaxFiles
+	}
+
+	return func(next gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			tracer := dtrace.GetTraceFromContext(c)
+			files, form, err := receive(c, &cfg)
+			if err == nil {
+				err = scan(c, &cfg, files)
+			}
+			if err != nil {
+				for _, f := range files {
+					if derr := cfg.Store.Delete(c, f.Key); derr != nil {
+						tracer.Warnf("delete rejected upload %s failed: %s", f.Key, derr)
+					}
+				}
+				uerr, ok := err.(*UploadError)
+				if !ok {
+					uerr = bodyError(err, &cfg, http.StatusInternalServerError).(*UploadError)
+				}
+				tracer.Warnf("%s", uerr)
+				c.AbortWithStatusJSON(uerr.Status, uerr)
+				return
+			}
+			for _, f := range files {
+				tracer.Infof("uploaded %s to %s, %d bytes, %s, sha256 %s", f.Filename, f.Key, f.Size, f.ContentType, f.SHA256)
+			}
+			c.Set(uploadedFilesKey, files)
+			c.Set(uploadedFormKey, form)
+			next(c)
+		}
+	}
+}
+
+// UploadedFiles returns the files stored by Upload
+func UploadedFiles(c *gin.Context) []*UploadedFile {
+	files, _ := c.Value(uploadedFilesKey).([]*UploadedFile)
+	return files
+}
+
+// UploadedForm returns the non-file form values of the request handled by Upload
+func UploadedForm(c *gin.Context) url.Values {
+	form, _ := c.Value(uploadedFormKey).(url.Values)
+	return form
+}
+
+func receive(c *gin.Context, cfg *UploadConfig) ([]*UploadedFile, url.Values, error) {
+	if c.Request.ContentLength > cfg.MaxTotalSize {
+		return nil, nil, &UploadError{Status: http.StatusRequestEntityTooLarge, Code: UploadTooLarge,
+			Msg: fmt.Sprintf("request body exceeds %d bytes", cfg.MaxTotalSize)}
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, cfg.MaxTotalSize)
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, nil, &UploadError{Status: http.StatusBadRequest, Code: UploadInvalidRequest, Msg: err.Error()}
+	}
+
+	var files []*UploadedFile
+	form := url.Values{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return files, form, nil
+		}
+		if err != nil {
+			return files, nil, bodyError(err, cfg, http.StatusBadRequest)
+		}
+		if part.FileName() == "" {
+			value, err := ioutil.ReadAll(io.LimitReader(part, maxFormValueSize+1))
+			if err != nil {
+				return files, nil, bodyError(err, cfg, http.StatusBadRequest)
+			}
+			if len(value) > maxFormValueSize {
+				return files, nil, &UploadError{Status: http.StatusRequestEntityTooLarge, Code: UploadTooLarge,
+					Msg: fmt.Sprintf("form value exceeds %d bytes", maxFormValueSize), Field: part.FormName()}
+			}
+			form.Add(part.FormName(), string(value))
+			continue
+		}
+
+		file := &UploadedFile{Field: part.FormName(), Filename: part.FileName()}
+		if len(cfg.AllowedFields) > 0 && !contains(cfg.AllowedFields, file.Field) {
+			return files, nil, &UploadError{Status: http.StatusBadRequest, Code: UploadFieldNotAllowed,
+				Msg: fmt.Sprintf("field %s can not carry files", file.Field), Field: file.Field, Filename: file.Filename}
+		}
+		if len(files) >= cfg.MaxFiles {
+			return files, nil, &UploadError{Status: http.StatusBadRequest, Code: UploadTooManyFiles,
+				Msg: fmt.Sprintf("more than %d files", cfg.MaxFiles), Field: file.Field, Filename: file.Filename}
+		}
+		if err := store(c, cfg, file, part); err != nil {
+			if file.Key != "" {
+				// the object may be partially written
+				files = append(files, file)
+			}
+			return files, nil, bodyError(err, cfg, http.StatusInternalServerError)
+		}
+		files = append(files, file)
+	}
+}
+
+// store streams a file part to the storage, the content type is detected by the first 512 bytes
+func store(c *gin.Context, cfg *UploadConfig, file *UploadedFile, part io.Reader) error {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return bodyError(err, cfg, http.StatusBadRequest)
+	}
+	head = head[:n]
+	file.ContentType = http.DetectContentType(head)
+	if len(cfg.AllowedTypes) > 0 && !typeAllowed(cfg.AllowedTypes, file.ContentType) {
+		return &UploadError{Status: http.StatusUnsupportedMediaType, Code: UploadTypeNotAllowed,
+			Msg: fmt.Sprintf("type %s is not allowed", file.ContentType), Field: file.Field, Filename: file.Filename}
+	}
+
+	file.Key = cfg.Key(c, file)
+	hash := sha256.New()
+	counter := &limitedReader{r: io.MultiReader(bytes.NewReader(head), part), limit: cfg.MaxFileSize}
+	err = storage.Upload(c, cfg.Store, file.Key, io.TeeReader(counter, hash), 0, storage.WithContentType(file.ContentType))
+	file.Size = counter.n
+	if counter.exceeded {
+		return &UploadError{Status: http.StatusRequestEntityTooLarge, Code: UploadTooLarge,
+			Msg: fmt.Sprintf("file exceeds %d bytes", cfg.MaxFileSize), Field: file.Field, Filename: file.Filename}
+	}
+	if err != nil {
+		return err
+	}
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+func scan(c *gin.Context, cfg *UploadConfig, files []*UploadedFile) error {
+	if cfg.Scan == nil {
+		return nil
+	}
+	for _, f := range files {
+		r, _, err := cfg.Store.Get(c, f.Key)
+		if err != nil {
+			return err
+		}
+		err = cfg.Scan(c, f, r)
+		r.Close()
+		if err != nil {
+			return &UploadError{Status: http.StatusUnprocessableEntity, Code: UploadRejected,
+				Msg: err.Error(), Field: f.Field, Filename: f.Filename}
+		}
+	}
+	return nil
+}
+
+// bodyError converts err to an UploadError, the body is limited by http.MaxBytesReader,
+// and the other errors are replied with status
+func bodyError(err error, cfg *UploadConfig, status int) error {
+	if _, ok := err.(*UploadError); ok {
+		return err
+	}
+	var maxErr *http.MaxBytesError
+	if stderrors.As(err, &maxErr) {
+		return &UploadError{Status: http.StatusRequestEntityTooLarge, Code: UploadTooLarge,
+			Msg: fmt.Sprintf("request body exceeds %d bytes", cfg.MaxTotalSize)}
+	}
+	code := UploadInvalidRequest
+	if status >= http.StatusInternalServerError {
+		code = UploadStorageFailed
+	}
+	return &UploadError{Status: status, Code: code, Msg: err.Error()}
+}
+
+// limitedReader fails the read when more than limit bytes are read
+type limitedReader struct {
+	r        io.Reader
+	limit    int64
+	n        int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.limit {
+		l.exceeded = true
+		return 0, fmt.Errorf("file exceeds %d bytes", l.limit)
+	}
+	return n, err
+}
+
+func typeAllowed(allowed []string, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range allowed {
+		if t == mediaType || strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// safeFilename keeps the base name of the client file name, and replaces the unsafe characters
+func safeFilename(name string) string {
+	name = path.Base(strings.Replace(name, `\`, "/", -1))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\:*?"<>|#%`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "." || name == ".." || name == "/" || name == "" {
+		name = "file"
+	}
+	return name
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
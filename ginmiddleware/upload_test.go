@@ -0,0 +1,173 @@
+package ginmiddleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tools-go/go-utils/storage"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+type uploadPart struct {
+	field, filename, content string
+}
+
+func multipartRequest(parts ...uploadPart) *http.Request {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for _, p := range parts {
+		if p.filename == "" {
+			mw.WriteField(p.field, p.content)
+			continue
+		}
+		w, _ := mw.CreateFormFile(p.field, p.filename)
+		io.WriteString(w, p.content)
+	}
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func uploadServer(t *testing.T, cfg UploadConfig) (*gin.Engine, storage.Blob, *[]*UploadedFile) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewLocalBlob(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Store = store
+	var handled []*UploadedFile
+	engine := gin.New()
+	engine.POST("/upload", Upload(cfg).HandlerFunc(func(c *gin.Context) {
+		handled = UploadedFiles(c)
+		c.String(http.StatusOK, UploadedForm(c).Get("title"))
+	}))
+	return engine, store, &handled
+}
+
+func TestUpload(t *testing.T) {
+	image := string(pngHeader) + strings.Repeat("x", 1000)
+	engine, store, handled := uploadServer(t, UploadConfig{
+		Prefix:        "avatars",
+		AllowedTypes:  []string{"image/*", "text/plain"},
+		AllowedFields: []string{"file"},
+	})
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, multipartRequest(
+		uploadPart{field: "title", content: "my avatar"},
+		uploadPart{field: "file", filename: "../../me.png", content: image},
+		uploadPart{field: "file", filename: "note.txt", content: "hello"},
+	))
+	if w.Code != http.StatusOK || w.Body.String() != "my avatar" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	if len(*handled) != 2 {
+		t.Fatalf("unexpected files: %+v", *handled)
+	}
+	f := (*handled)[0]
+	sum := sha256.Sum256([]byte(image))
+	if f.ContentType != "image/png" || f.Size != int64(len(image)) || f.SHA256 != hex.EncodeToString(sum[:]) ||
+		!strings.HasPrefix(f.Key, "avatars/") || !strings.HasSuffix(f.Key, "/me.png") {
+		t.Fatalf("unexpected file: %+v", f)
+	}
+	r, info, err := store.Get(context.TODO(), f.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if b, _ := ioutil.ReadAll(r); string(b) != image || info.ContentType != "image/png" {
+		t.Fatalf("unexpected stored object: %+v", info)
+	}
+}
+
+func TestUploadRejected(t *testing.T) {
+	cases := []struct {
+		name   string
+		cfg    UploadConfig
+		parts  []uploadPart
+		status int
+		code   string
+	}{
+		{
+			name:   "type",
+			cfg:    UploadConfig{AllowedTypes: []string{"image/*"}},
+			parts:  []uploadPart{{field: "file", filename: "a.png", content: "not a png"}},
+			status: http.StatusUnsupportedMediaType,
+			code:   UploadTypeNotAllowed,
+		},
+		{
+			name:   "file size",
+			cfg:    UploadConfig{MaxFileSize: 10},
+			parts:  []uploadPart{{field: "file", filename: "a.txt", content: "more than 10 bytes"}},
+			status: http.StatusRequestEntityTooLarge,
+			code:   UploadTooLarge,
+		},
+		{
+			name:   "total size",
+			cfg:    UploadConfig{MaxTotalSize: 100},
+			parts:  []uploadPart{{field: "file", filename: "a.txt", content: strings.Repeat("x", 200)}},
+			status: http.StatusRequestEntityTooLarge,
+			code:   UploadTooLarge,
+		},
+		{
+			name:   "form value size",
+			parts:  []uploadPart{{field: "title", content: strings.Repeat("x", maxFormValueSize+1)}},
+			status: http.StatusRequestEntityTooLarge,
+			code:   UploadTooLarge,
+		},
+		{
+			name:   "count",
+			cfg:    UploadConfig{MaxFiles: 1},
+			parts:  []uploadPart{{field: "file", filename: "a.txt", content: "a"}, {field: "file", filename: "b.txt", content: "b"}},
+			status: http.StatusBadRequest,
+			code:   UploadTooManyFiles,
+		},
+		{
+			name: "scan",
+			cfg: UploadConfig{Scan: func(ctx context.Context, file *UploadedFile, r io.Reader) error {
+				b, _ := ioutil.ReadAll(r)
+				if bytes.Contains(b, []byte("EICAR")) {
+					return errors.New("virus found")
+				}
+				return nil
+			}},
+			parts:  []uploadPart{{field: "file", filename: "a.txt", content: "ok"}, {field: "file", filename: "b.txt", content: "EICAR"}},
+			status: http.StatusUnprocessableEntity,
+			code:   UploadRejected,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			engine, store, handled := uploadServer(t, c.cfg)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, multipartRequest(c.parts...))
+			if w.Code != c.status {
+				t.Fatalf("expect %d, got %d %s", c.status, w.Code, w.Body.String())
+			}
+			var uerr UploadError
+			if err := json.Unmarshal(w.Body.Bytes(), &uerr); err != nil || uerr.Code != c.code {
+				t.Fatalf("unexpected error: %s", w.Body.String())
+			}
+			if *handled != nil {
+				t.Fatal("the handler should not run")
+			}
+			if objects, _ := store.List(context.TODO(), ""); len(objects) != 0 {
+				t.Fatalf("the rejected files should be deleted: %+v", objects)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+// Package httpdiff sends the same requests to two handlers or upstreams, like the old and the new
+// implementations in a migration, and reports the differences of the status, the headers and the json bodies
+package httpdiff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Difference is a difference of the responses, Path is like "status", "header.Content-Type"
+// or "body.items.0.id", A and B are the values, nil if absent
+type Difference struct {
+	Path string      `json:"path"`
+	A    interface{} `json:"a"`
+	B    interface{} `json:"b"`
+}
+
+// Report is the differences of the responses to a request
+type Report struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	Differences []Difference `json:"differences,omitempty"`
+}
+
+// Equal reports whether the responses are the same, except the ignored parts
+func (r Report) Equal() bool {
+	return len(r.Differences) == 0
+}
+
+func (r Report) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s: %d differences", r.Method, r.URL, len(r.Differences))
+	for _, d := range r.Differences {
+		fmt.Fprintf(&buf, "\n  %s: %v != %v", d.Path, d.A, d.B)
+	}
+	return buf.String()
+}
+
+// Option for the Differ
+type Option func(d *Differ)
+
+// WithIgnoreHeaders ignores the headers, Date is always ignored
+func WithIgnoreHeaders(headers ...string) Option {
+	return func(d *Differ) {
+		for _, h := range headers {
+			d.ignoreHeaders[http.CanonicalHeaderKey(h)] = true
+		}
+	}
+}
+
+// WithIgnoreFields ignores the volatile fields of the json bodies, like "updated_at" or "items.*.id",
+// a "*" matches any key or index of a level
+func WithIgnoreFields(paths ...string) Option {
+	return func(d *Differ) {
+		for _, p := range paths {
+			d.ignoreFields = append(d.ignoreFields, strings.Split(p, "."))
+		}
+	}
+}
+
+// Differ compares the responses
+type Differ struct {
+	ignoreHeaders map[string]bool
+	ignoreFields  [][]string
+}
+
+// New creates a Differ
+func New(opts ...Option) *Differ {
+	d := &Differ{ignoreHeaders: map[string]bool{"Date": true}}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Upstream returns a handler proxying the requests to the upstream, so it can be compared like a handler
+func Upstream(base string, client *http.Client) (http.Handler, error) {
+	u, err := url.Parse(base)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream %q", base)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	if client != nil && client.Transport != nil {
+		proxy.Transport = client.Transport
+	}
+	return proxy, nil
+}
+
+// Compare sends req to a and b, and compares the responses
+func (d *Differ) Compare(req *http.Request, a, b http.Handler) (Report, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return Report{}, err
+		}
+		req.Body.Close()
+	}
+	serve := func(h http.Handler) *httptest.ResponseRecorder {
+		r := req.Clone(req.Context())
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	ra, rb := serve(a), serve(b)
+	return d.CompareResponses(req, ra.Result(), rb.Result())
+}
+
+// CompareResponses compares the responses to req, the bodies are consumed
+func (d *Differ) CompareResponses(req *http.Request, a, b *http.Response) (Report, error) {
+	report := Report{Method: req.Method, URL: req.URL.String()}
+	if a.StatusCode != b.StatusCode {
+		report.Differences = append(report.Differences, Difference{Path: "status", A: a.StatusCode, B: b.StatusCode})
+	}
+	report.Differences = append(report.Differences, d.diffHeaders(a.Header, b.Header)...)
+
+	bodyA, err := ioutil.ReadAll(a.Body)
+	a.Body.Close()
+	if err != nil {
+		return report, err
+	}
+	bodyB, err := ioutil.ReadAll(b.Body)
+	b.Body.Close()
+	if err != nil {
+		return report, err
+	}
+	report.Differences = append(report.Differences, d.diffBodies(bodyA, bodyB)...)
+	return report, nil
+}
+
+func (d *Differ) diffHeaders(a, b http.Header) []Difference {
+	keys := map[string]bool{}
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		if !d.ignoreHeaders[k] {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	var diffs []Difference
+	for _, k := range names {
+		va, vb := strings.Join(a[k], ", "), strings.Join(b[k], ", ")
+		if va == vb {
+			continue
+		}
+		diff := Difference{Path: "header." + k}
+		if _, ok := a[k]; ok {
+			diff.A = va
+		}
+		if _, ok := b[k]; ok {
+			diff.B = vb
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+func decodeJSON(data []byte) (interface{}, bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return nil, false
+	}
+	return v, true
+}
+
+func (d *Differ) diffBodies(a, b []byte) []Difference {
+	va, okA := decodeJSON(a)
+	vb, okB := decodeJSON(b)
+	if !okA || !okB {
+		if bytes.Equal(a, b) {
+			return nil
+		}
+		return []Difference{{Path: "body", A: string(a), B: string(b)}}
+	}
+	var diffs []Difference
+	d.diffValues(nil, va, vb, &diffs)
+	return diffs
+}
+
+func (d *Differ) ignored(path []string) bool {
+	for _, pattern := range d.ignoreFields {
+		if len(pattern) != len(path) {
+			continue
+		}
+		matched := true
+		for i := range pattern {
+			if pattern[i] != "*" && pattern[i] != path[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// diffValues compares the decoded json values recursively, the keys are compared in order for the stable reports
+func (d *Differ) diffValues(path []string, a, b interface{}, diffs *[]Difference) {
+	if d.ignored(path) {
+		return
+	}
+	add := func(path []string, a, b interface{}) {
+		*diffs = append(*diffs, Difference{Path: strings.Join(append([]string{"body"}, path...), "."), A: a, B: b})
+	}
+	child := func(key string) []string {
+		return append(append([]string(nil), path...), key)
+	}
+	switch ta := a.(type) {
+	case map[string]interface{}:
+		tb, ok := b.(map[string]interface{})
+		if !ok {
+			add(path, a, b)
+			return
+		}
+		keys := map[string]bool{}
+		for k := range ta {
+			keys[k] = true
+		}
+		for k := range tb {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			va, okA := ta[k]
+			vb, okB := tb[k]
+			if okA && okB {
+				d.diffValues(child(k), va, vb, diffs)
+			} else if !d.ignored(child(k)) {
+				add(child(k), va, vb)
+			}
+		}
+	case []interface{}:
+		tb, ok := b.([]interface{})
+		if !ok {
+			add(path, a, b)
+			return
+		}
+		for i := 0; i < len(ta) || i < len(tb); i++ {
+			key := strconv.Itoa(i)
+			switch {
+			case i >= len(ta):
+				if !d.ignored(child(key)) {
+					add(child(key), nil, tb[i])
+				}
+			case i >= len(tb):
+				if !d.ignored(child(key)) {
+					add(child(key), ta[i], nil)
+				}
+			default:
+				d.diffValues(child(key), ta[i], tb[i], diffs)
+			}
+		}
+	case json.Number:
+		// the numbers are compared by the values, so 1 and 1.0 are the same
+		tb, ok := b.(json.Number)
+		if !ok {
+			add(path, a, b)
+			return
+		}
+		if ta == tb {
+			return
+		}
+		fa, errA := ta.Float64()
+		fb, errB := tb.Float64()
+		if errA != nil || errB != nil || fa != fb {
+			add(path, a, b)
+		}
+	default:
+		if !reflect.DeepEqual(a, b) {
+			add(path, a, b)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package httpdiff
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	old := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", "1")
+		w.Header().Set("X-Version", "v1")
+		w.Write([]byte(`{"id":1,"name":"a","updated_at":"` + time.Now().String() + `","items":[{"id":1,"ts":1},{"id":2,"ts":2}]}`))
+	})
+	body := ""
+	migrated := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 16)
+		n, _ := r.Body.Read(buf)
+		body = string(buf[:n])
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", "2")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"name":"b","id":1.0,"updated_at":"now","items":[{"id":1,"ts":3}],"extra":true}`))
+	})
+
+	d := New(WithIgnoreHeaders("x-request-id"), WithIgnoreFields("updated_at", "items.*.ts"))
+	report, err := d.Compare(httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("hello")), old, migrated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "hello" {
+		t.Fatalf("expect the body replayed, got %q", body)
+	}
+	var paths []string
+	for _, diff := range report.Differences {
+		paths = append(paths, diff.Path)
+	}
+	expected := "status header.X-Version body.extra body.items.1 body.name"
+	if strings.Join(paths, " ") != expected || report.Equal() {
+		t.Fatalf("unexpected differences %s", report)
+	}
+
+	report, err = d.Compare(httptest.NewRequest(http.MethodGet, "/", nil), old, old)
+	if err != nil || !report.Equal() {
+		t.Fatalf("expect equal, got %s %v", report, err)
+	}
+}
+
+func TestUpstream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("plain " + r.URL.Path))
+	}))
+	defer srv.Close()
+	up, err := Upstream(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("plain /other"))
+	})
+	report, err := New(WithIgnoreHeaders("Content-Length", "Content-Type")).Compare(httptest.NewRequest(http.MethodGet, "/ping", nil), up, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Differences) != 1 || report.Differences[0].Path != "body" || report.Differences[0].A != "plain /ping" {
+		t.Fatalf("unexpected differences %s", report)
+	}
+	if _, err := Upstream("upstream:8080", nil); err == nil {
+		t.Fatal("expect invalid upstream")
+	}
+}
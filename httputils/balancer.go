@@ -0,0 +1,308 @@
+package httputils
+
+import (
+	"context"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/leopoldxx/go-utils/errors"
+	"github.com/leopoldxx/go-utils/trace"
+	"github.com/tools-go/go-utils/discovery"
+)
+
+var errNoUpstream = errors.NewNotReadyError("upstream")
+
+// Endpoint is the address and the weight of an upstream
+type Endpoint struct {
+	Addr   string
+	Weight int
+}
+
+// Upstream is an endpoint of the Balancer with the statistics
+type Upstream struct {
+	Addr   string
+	Weight int
+
+	pending  int64
+	requests int64
+	failures int64
+	latency  int64 // total latency in nanoseconds
+	// for passive health checking
+	mu           sync.Mutex
+	consecutive  int
+	ejectedUntil time.Time
+	// for the smooth weighted round robin
+	current int
+}
+
+// Pending returns the count of the in-flight requests
+func (up *Upstream) Pending() int64 {
+	return atomic.LoadInt64(&up.pending)
+}
+
+func (up *Upstream) ejected(now time.Time) bool {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	return now.Before(up.ejectedUntil)
+}
+
+// UpstreamStats is the statistics of an upstream
+type UpstreamStats struct {
+	Addr       string
+	Weight     int
+	Pending    int64
+	Requests   int64
+	Failures   int64
+	AvgLatency time.Duration
+	Ejected    bool
+}
+
+// Policy picks an upstream from the available ones, which are never empty
+type Policy interface {
+	Pick(ctx context.Context, ups []*Upstream) *Upstream
+}
+
+// PolicyFunc is an adapter to use a function as a Policy
+type PolicyFunc func(ctx context.Context, ups []*Upstream) *Upstream
+
+// Pick calls f(ctx, ups)
+func (f PolicyFunc) Pick(ctx context.Context, ups []*Upstream) *Upstream {
+	return f(ctx, ups)
+}
+
+// RoundRobin picks the upstreams in turn
+func RoundRobin() Policy {
+	var next uint64
+	return PolicyFunc(func(ctx context.Context, ups []*Upstream) *Upstream {
+		return ups[(atomic.AddUint64(&next, 1)-1)%uint64(len(ups))]
+	})
+}
+
+// WeightedRoundRobin picks the upstreams in proportion to the weights with the smooth weighted round robin of nginx
+func WeightedRoundRobin() Policy {
+	var mu sync.Mutex
+	return PolicyFunc(func(ctx context.Context, ups []*Upstream) *Upstream {
+		mu.Lock()
+		defer mu.Unlock()
+		var (
+			best  *Upstream
+			total int
+		)
+		for _, up := range ups {
+			up.current += up.Weight
+			total += up.Weight
+			if best == nil || up.current > best.current {
+				best = up
+			}
+		}
+		best.current -= total
+		return best
+	})
+}
+
+// LeastPending picks the upstream with the least in-flight requests relative to its weight
+func LeastPending() Policy {
+	var next uint64
+	return PolicyFunc(func(ctx context.Context, ups []*Upstream) *Upstream {
+		// start from a rotating offset, so the ties are broken evenly
+		offset := int(atomic.AddUint64(&next, 1) % uint64(len(ups)))
+		var best *Upstream
+		for i := range ups {
+			up := ups[(offset+i)%len(ups)]
+			if best == nil || up.Pending()*int64(best.Weight) < best.Pending()*int64(up.Weight) {
+				best = up
+			}
+		}
+		return best
+	})
+}
+
+// ConsistentHash picks the upstream by the hash of key(ctx) on a hash ring, so the requests with the same key
+// stick to the same upstream while the upstreams are stable; the trace ID is used if key is nil
+func ConsistentHash(key func(ctx context.Context) string) Policy {
+	if key == nil {
+		key = func(ctx context.Context) string {
+			return trace.GetTraceFromContext(ctx).ID()
+		}
+	}
+	const replicas = 100
+	var (
+		mu    sync.Mutex
+		addrs string
+		ring  []uint32
+		nodes map[uint32]*Upstream
+	)
+	return PolicyFunc(func(ctx context.Context, ups []*Upstream) *Upstream {
+		mu.Lock()
+		// rebuild the ring only when the upstreams are changed
+		current := ""
+		for _, up := range ups {
+			current += up.Addr + "/" + strconv.Itoa(up.Weight) + ","
+		}
+		if current != addrs {
+			addrs, ring, nodes = current, nil, map[uint32]*Upstream{}
+			for _, up := range ups {
+				for i := 0; i < replicas*up.Weight; i++ {
+					h := crc32.ChecksumIEEE([]byte(up.Addr + "#" + strconv.Itoa(i)))
+					ring = append(ring, h)
+					nodes[h] = up
+				}
+			}
+			sort.Slice(ring, func(i, j int) bool { return ring[i] < ring[j] })
+		}
+		r, n := ring, nodes
+		mu.Unlock()
+
+		h := crc32.ChecksumIEEE([]byte(key(ctx)))
+		i := sort.Search(len(r), func(i int) bool { return r[i] >= h })
+		if i == len(r) {
+			i = 0
+		}
+		return n[r[i]]
+	})
+}
+
+type balancerOptions struct {
+	scheme        string
+	maxFails      int
+	ejectDuration time.Duration
+}
+
+// BalancerOption for the Balancer
+type BalancerOption func(opts *balancerOptions)
+
+// WithScheme set the scheme of the upstreams, default is "http"
+func WithScheme(scheme string) BalancerOption {
+	return func(opts *balancerOptions) {
+		opts.scheme = scheme
+	}
+}
+
+// WithEjection ejects an upstream for duration after maxFails consecutive failures,
+// the network errors and 5xx responses are counted as failures
+func WithEjection(maxFails int, duration time.Duration) BalancerOption {
+	return func(opts *balancerOptions) {
+		opts.maxFails = maxFails
+		opts.ejectDuration = duration
+	}
+}
+
+// Balancer balances the requests over a dynamic set of upstreams
+type Balancer struct {
+	policy Policy
+	opts   *balancerOptions
+	mu     sync.RWMutex
+	ups    []*Upstream
+}
+
+// NewBalancer creates a Balancer of the policy
+func NewBalancer(policy Policy, ops ...BalancerOption) *Balancer {
+	opts := &balancerOptions{scheme: "http", maxFails: 5, ejectDuration: 30 * time.Second}
+	for _, op := range ops {
+		op(opts)
+	}
+	return &Balancer{policy: policy, opts: opts}
+}
+
+// SetEndpoints replaces the upstreams, the statistics of the unchanged ones are kept
+func (b *Balancer) SetEndpoints(endpoints ...Endpoint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	old := make(map[string]*Upstream, len(b.ups))
+	for _, up := range b.ups {
+		old[up.Addr] = up
+	}
+	newUps := make([]*Upstream, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if ep.Weight <= 0 {
+			ep.Weight = 1
+		}
+		up, ok := old[ep.Addr]
+		if !ok || up.Weight != ep.Weight {
+			up = &Upstream{Addr: ep.Addr, Weight: ep.Weight}
+		}
+		newUps = append(newUps, up)
+	}
+	b.ups = newUps
+}
+
+// Watch keeps the upstreams updated with the instances of the resolver
+func (b *Balancer) Watch(res *discovery.Resolver) {
+	res.OnChange(func(instances []discovery.Instance) {
+		endpoints := make([]Endpoint, 0, len(instances))
+		for _, ins := range instances {
+			endpoints = append(endpoints, Endpoint{Addr: ins.Addr, Weight: ins.Weight})
+		}
+		b.SetEndpoints(endpoints...)
+	})
+}
+
+// Pick returns the picked upstream, and a done func which must be called with the result of the request.
+// The ejected upstreams are skipped, unless all of the upstreams are ejected.
+func (b *Balancer) Pick(ctx context.Context) (*Upstream, func(err error), error) {
+	b.mu.RLock()
+	all := b.ups
+	b.mu.RUnlock()
+	if len(all) == 0 {
+		return nil, nil, errNoUpstream
+	}
+	now := time.Now()
+	available := make([]*Upstream, 0, len(all))
+	for _, up := range all {
+		if !up.ejected(now) {
+			available = append(available, up)
+		}
+	}
+	if len(available) == 0 {
+		available = all
+	}
+	up := b.policy.Pick(ctx, available)
+	atomic.AddInt64(&up.pending, 1)
+	start := time.Now()
+	return up, func(err error) {
+		atomic.AddInt64(&up.pending, -1)
+		atomic.AddInt64(&up.requests, 1)
+		atomic.AddInt64(&up.latency, int64(time.Since(start)))
+		up.mu.Lock()
+		defer up.mu.Unlock()
+		if err == nil {
+			up.consecutive = 0
+			return
+		}
+		atomic.AddInt64(&up.failures, 1)
+		up.consecutive++
+		if b.opts.maxFails > 0 && up.consecutive >= b.opts.maxFails {
+			up.consecutive = 0
+			up.ejectedUntil = time.Now().Add(b.opts.ejectDuration)
+			trace.GetTraceFromContext(ctx).Warnf("upstream %s is ejected for %v: %s", up.Addr, b.opts.ejectDuration, err)
+		}
+	}, nil
+}
+
+// Stats returns the statistics of the upstreams
+func (b *Balancer) Stats() []UpstreamStats {
+	b.mu.RLock()
+	ups := b.ups
+	b.mu.RUnlock()
+	now := time.Now()
+	stats := make([]UpstreamStats, 0, len(ups))
+	for _, up := range ups {
+		s := UpstreamStats{
+			Addr:     up.Addr,
+			Weight:   up.Weight,
+			Pending:  up.Pending(),
+			Requests: atomic.LoadInt64(&up.requests),
+			Failures: atomic.LoadInt64(&up.failures),
+			Ejected:  up.ejected(now),
+		}
+		if s.Requests > 0 {
+			s.AvgLatency = time.Duration(atomic.LoadInt64(&up.latency) / s.Requests)
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
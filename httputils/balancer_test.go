@@ -0,0 +1,125 @@
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leopoldxx/go-utils/trace"
+)
+
+func pickN(t *testing.T, b *Balancer, ctx context.Context, n int) map[string]int {
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		up, done, err := b.Pick(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[up.Addr]++
+		done(nil)
+	}
+	return counts
+}
+
+func TestBalancerPolicies(t *testing.T) {
+	ctx := context.TODO()
+	if _, _, err := NewBalancer(RoundRobin()).Pick(ctx); err == nil {
+		t.Fatal("expect no upstream")
+	}
+
+	b := NewBalancer(RoundRobin())
+	b.SetEndpoints(Endpoint{Addr: "a"}, Endpoint{Addr: "b"})
+	if counts := pickN(t, b, ctx, 10); counts["a"] != 5 || counts["b"] != 5 {
+		t.Fatalf("unexpected round robin: %v", counts)
+	}
+
+	b = NewBalancer(WeightedRoundRobin())
+	b.SetEndpoints(Endpoint{Addr: "a", Weight: 3}, Endpoint{Addr: "b", Weight: 1})
+	if counts := pickN(t, b, ctx, 8); counts["a"] != 6 || counts["b"] != 2 {
+		t.Fatalf("unexpected weighted round robin: %v", counts)
+	}
+
+	b = NewBalancer(LeastPending())
+	b.SetEndpoints(Endpoint{Addr: "a"}, Endpoint{Addr: "b"})
+	first, _, _ := b.Pick(ctx) // keep it pending
+	for i := 0; i < 3; i++ {
+		up, done, _ := b.Pick(ctx)
+		if up == first {
+			t.Fatalf("expect the idle upstream, got %s", up.Addr)
+		}
+		done(nil)
+	}
+
+	b = NewBalancer(ConsistentHash(nil))
+	b.SetEndpoints(Endpoint{Addr: "a"}, Endpoint{Addr: "b"}, Endpoint{Addr: "c"})
+	tctx := trace.WithTraceForContext(ctx, "test", "trace-id-1")
+	up, _, _ := b.Pick(tctx)
+	if counts := pickN(t, b, tctx, 10); counts[up.Addr] != 10 {
+		t.Fatalf("expect sticky upstream %s: %v", up.Addr, counts)
+	}
+	if counts := pickN(t, b, ctx, 300); len(counts) != 3 {
+		t.Fatalf("expect all upstreams picked by random trace ids: %v", counts)
+	}
+}
+
+func TestBalancerEjection(t *testing.T) {
+	ctx := context.TODO()
+	b := NewBalancer(RoundRobin(), WithEjection(2, time.Hour))
+	b.SetEndpoints(Endpoint{Addr: "a"}, Endpoint{Addr: "b"})
+	for i := 0; i < 4; i++ {
+		up, done, _ := b.Pick(ctx)
+		if up.Addr == "a" {
+			done(context.DeadlineExceeded)
+		} else {
+			done(nil)
+		}
+	}
+	if counts := pickN(t, b, ctx, 4); counts["b"] != 4 {
+		t.Fatalf("expect a ejected: %v", counts)
+	}
+	stats := b.Stats()
+	if !stats[0].Ejected || stats[0].Failures != 2 || stats[1].Requests != 6 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// all the upstreams are picked if all of them are ejected
+	b.SetEndpoints(Endpoint{Addr: "a"})
+	if counts := pickN(t, b, ctx, 2); counts["a"] != 2 {
+		t.Fatalf("expect a picked: %v", counts)
+	}
+}
+
+func TestRestBalancer(t *testing.T) {
+	var servers []*httptest.Server
+	var endpoints []Endpoint
+	for _, status := range []int{http.StatusOK, http.StatusBadGateway} {
+		status := status
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v1/hello" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(status)
+		}))
+		defer server.Close()
+		servers = append(servers, server)
+		endpoints = append(endpoints, Endpoint{Addr: strings.TrimPrefix(server.URL, "http://")})
+	}
+	b := NewBalancer(RoundRobin(), WithEjection(1, time.Hour))
+	b.SetEndpoints(endpoints...)
+
+	statuses := map[int]int{}
+	for i := 0; i < 4; i++ {
+		resp, err := NewRestCli().Balancer(b).ResourcePath("/api/v1/hello").Do()
+		if err != nil {
+			t.Fatal(err)
+		}
+		statuses[resp.Status]++
+	}
+	if statuses[http.StatusOK] != 3 || statuses[http.StatusBadGateway] != 1 {
+		t.Fatalf("expect the failed upstream ejected: %v", statuses)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -12,6 +13,7 @@ import (
 	"strings"
 
 	"github.com/leopoldxx/go-utils/trace"
+	"github.com/tools-go/go-utils/utils/urlutil"
 )
 
 // DebugLevel of the debug logs
@@ -40,6 +42,8 @@ type RestCli struct {
 	into     map[string]interface{}
 	debug    DebugLevel
 	isStream bool
+	err      error
+	balancer *Balancer
 }
 
 var defaultHTTPClient = func() *http.Client {
@@ -136,6 +140,17 @@ func (rest *RestCli) SetHeader(header, value string) *RestCli {
 	return rest
 }
 
+// ResourcePathSegments will set the remote api Resource by joining the escaped segments,
+// segments like ".." are rejected to prevent path traversal
+func (rest *RestCli) ResourcePathSegments(segments ...string) *RestCli {
+	resource, err := urlutil.JoinPath("", segments...)
+	if err != nil {
+		rest.err = err
+		return rest
+	}
+	return rest.ResourcePath(resource)
+}
+
 // ClearHeader will clear a header from the rest request
 func (rest *RestCli) ClearHeader(header string) *RestCli {
 	delete(rest.headers, header)
@@ -152,6 +167,19 @@ func (rest *RestCli) SetQuery(query string, value ...string) *RestCli {
 	return rest
 }
 
+// QueryObject will add the querys encoded from a tagged struct(see urlutil.Values) for the rest request
+func (rest *RestCli) QueryObject(obj interface{}) *RestCli {
+	values, err := urlutil.Values(obj)
+	if err != nil {
+		rest.err = err
+		return rest
+	}
+	for k, vs := range values {
+		rest.querys[k] = append(rest.querys[k], vs...)
+	}
+	return rest
+}
+
 // ClearQuery will clear a query from the rest request
 func (rest *RestCli) ClearQuery(query string) *RestCli {
 	delete(rest.querys, query)
@@ -182,6 +210,20 @@ func (rest *RestCli) Into(status string, resp interface{}) *RestCli {
 	return rest
 }
 
+// Balancer will send the rest request to an upstream picked by the balancer instead of the host
+func (rest *RestCli) Balancer(b *Balancer) *RestCli {
+	rest.balancer = b
+	return rest
+}
+
+// Throttler will send the rest request through the throttler, see Throttler for the details
+func (rest *RestCli) Throttler(t *Throttler) *RestCli {
+	client := *rest.cli
+	client.Transport = t.Transport(rest.cli.Transport)
+	rest.cli = &client
+	return rest
+}
+
 // Stream will turn on or turn off the debug process
 func (rest *RestCli) Stream() *RestCli {
 	rest.isStream = true
@@ -205,6 +247,12 @@ func (rest *RestCli) Do() (*Response, error) {
 	}
 
 	tracer := trace.GetTraceFromContext(rest.ctx)
+	if rest.err != nil {
+		if rest.debug >= Debug1 {
+			tracer.Error("build request failed:", rest.err)
+		}
+		return nil, rest.err
+	}
 	if _, exists := rest.headers["x-request-id"]; !exists {
 		rest.headers["x-request-id"] = tracer.ID()
 	}
@@ -247,10 +295,23 @@ func (rest *RestCli) Do() (*Response, error) {
 		}
 	}
 
+	api := rest.api
+	var done func(error)
+	if rest.balancer != nil {
+		up, pickDone, err := rest.balancer.Pick(rest.ctx)
+		if err != nil {
+			if rest.debug >= Debug1 {
+				tracer.Error("pick upstream failed:", err)
+			}
+			return nil, err
+		}
+		api, done = rest.balancer.opts.scheme+"://"+up.Addr+rest.resource, pickDone
+	}
+
 	req, err := NewRequest(
 		rest.ctx,
 		rest.method,
-		rest.api,
+		api,
 		rest.headers,
 		rest.querys,
 		bodyReader)
@@ -266,6 +327,13 @@ func (rest *RestCli) Do() (*Response, error) {
 	}
 
 	resp, err := ClientDo(rest.cli, req, true) // always return  a Body Reader, avoid memory copy
+	if done != nil {
+		if err == nil && resp.Status >= 500 {
+			done(fmt.Errorf("unexpected status %d", resp.Status))
+		} else {
+			done(err)
+		}
+	}
 	if err != nil {
 		if rest.debug >= Debug1 {
 			tracer.Error("do request failed:", err)
@@ -97,3 +97,40 @@ func TestRest(t *testing.T) {
 
 	}
 }
+
+func TestRestQueryObject(t *testing.T) {
+	type listReq struct {
+		Page int      `url:"page"`
+		Tags []string `url:"tag,omitempty"`
+	}
+
+	ts := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.EscapedPath() != "/users/a%2Fb" {
+					t.Errorf("unexpected path: %s", r.URL.EscapedPath())
+				}
+				if r.URL.Query().Get("page") != "3" || len(r.URL.Query()["tag"]) != 2 {
+					t.Errorf("unexpected query: %s", r.URL.RawQuery)
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+	defer ts.Close()
+
+	_, err := NewRestCli().
+		Host(ts.URL).
+		ResourcePathSegments("users", "a/b").
+		QueryObject(&listReq{Page: 3, Tags: []string{"x", "y"}}).
+		Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewRestCli().
+		Host(ts.URL).
+		ResourcePathSegments("users", "..").
+		Do()
+	if err == nil {
+		t.Fatal("expect unsafe resource path failed")
+	}
+}
@@ -0,0 +1,260 @@
+package httputils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/leopoldxx/go-utils/trace"
+	"github.com/tools-go/go-utils/priority"
+)
+
+// ThrottledError is returned if the host asks to retry after a longer time than the max wait
+type ThrottledError struct {
+	Host       string
+	RetryAfter time.Duration
+}
+
+func (err *ThrottledError) Error() string {
+	return fmt.Sprintf("requests to %s are throttled, retry after %v", err.Host, err.RetryAfter)
+}
+
+// IsThrottledError checks if the err is a ThrottledError, which may be wrapped by the http.Client
+func IsThrottledError(err error) bool {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	_, ok := err.(*ThrottledError)
+	return ok
+}
+
+// ThrottleStats is the throttle state of a host
+type ThrottleStats struct {
+	Limit        float64
+	Inflight     int
+	Throttled    int64 // count of the 429/503 responses and timeouts
+	BlockedUntil time.Time
+}
+
+type throttleOptions struct {
+	initLimit  float64
+	minLimit   float64
+	maxLimit   float64
+	maxWait    time.Duration
+	maxRetries int
+}
+
+// ThrottleOption for the Throttler
+type ThrottleOption func(opts *throttleOptions)
+
+// WithConcurrencyLimit set the initial, min and max concurrency per host
+func WithConcurrencyLimit(init, min, max int) ThrottleOption {
+	return func(opts *throttleOptions) {
+		opts.initLimit, opts.minLimit, opts.maxLimit = float64(init), float64(min), float64(max)
+	}
+}
+
+// WithRetryAfter set the max time to wait for a Retry-After, and the max retries of the throttled requests,
+// the requests are failed with a ThrottledError if the host asks to wait longer
+func WithRetryAfter(maxWait time.Duration, maxRetries int) ThrottleOption {
+	return func(opts *throttleOptions) {
+		opts.maxWait = maxWait
+		opts.maxRetries = maxRetries
+	}
+}
+
+type hostState struct {
+	sync.Mutex
+	limit        float64
+	inflight     int
+	throttled    int64
+	blockedUntil time.Time
+	// closed and replaced when a slot is released
+	released chan struct{}
+}
+
+// backgroundShare is the share of the concurrency of a host taken by the background requests at most,
+// the rest is kept for the others
+const backgroundShare = 0.5
+
+// Throttler protects the downstreams with the adaptive concurrency(AIMD) per host,
+// and honors the Retry-After of the 429/503 responses.
+// The priority of the requests in the context is honored: the background ones take half of the
+// concurrency at most, and the critical ones are not limited by the concurrency but the Retry-After.
+type Throttler struct {
+	opts  *throttleOptions
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+// NewThrottler creates a Throttler, by default the concurrency per host starts at 16 in [1, 256],
+// and the requests wait for a Retry-After up to 10s and retry at most 2 times
+func NewThrottler(ops ...ThrottleOption) *Throttler {
+	opts := &throttleOptions{initLimit: 16, minLimit: 1, maxLimit: 256, maxWait: 10 * time.Second, maxRetries: 2}
+	for _, op := range ops {
+		op(opts)
+	}
+	if opts.minLimit < 1 {
+		opts.minLimit = 1
+	}
+	opts.maxLimit = math.Max(opts.maxLimit, opts.minLimit)
+	opts.initLimit = math.Min(math.Max(opts.initLimit, opts.minLimit), opts.maxLimit)
+	return &Throttler{opts: opts, hosts: map[string]*hostState{}}
+}
+
+func (t *Throttler) host(host string) *hostState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.hosts[host]
+	if !ok {
+		s = &hostState{limit: t.opts.initLimit, released: make(chan struct{})}
+		t.hosts[host] = s
+	}
+	return s
+}
+
+// Stats returns the throttle state of the hosts
+func (t *Throttler) Stats() map[string]ThrottleStats {
+	t.mu.Lock()
+	hosts := make(map[string]*hostState, len(t.hosts))
+	for host, s := range t.hosts {
+		hosts[host] = s
+	}
+	t.mu.Unlock()
+
+	stats := make(map[string]ThrottleStats, len(hosts))
+	for host, s := range hosts {
+		s.Lock()
+		stats[host] = ThrottleStats{Limit: s.limit, Inflight: s.inflight, Throttled: s.throttled, BlockedUntil: s.blockedUntil}
+		s.Unlock()
+	}
+	return stats
+}
+
+// acquire waits for the Retry-After and a concurrency slot of the host by the priority in ctx
+func (t *Throttler) acquire(ctx context.Context, host string, s *hostState) error {
+	p := priority.FromContext(ctx)
+	for {
+		s.Lock()
+		if wait := time.Until(s.blockedUntil); wait > 0 {
+			s.Unlock()
+			if wait > t.opts.maxWait {
+				return &ThrottledError{Host: host, RetryAfter: wait}
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			continue
+		}
+		limit := math.Floor(s.limit)
+		if p <= priority.Background {
+			limit = math.Max(math.Floor(s.limit*backgroundShare), 1)
+		}
+		if p >= priority.Critical || float64(s.inflight) < limit {
+			s.inflight++
+			s.Unlock()
+			return nil
+		}
+		released := s.released
+		s.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+// release adjusts the limit by AIMD: +1/limit on success, and halved on overload
+func (t *Throttler) release(s *hostState, overloaded bool, retryAfter time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.inflight--
+	if overloaded {
+		s.throttled++
+		s.limit = math.Max(s.limit/2, t.opts.minLimit)
+	} else {
+		s.limit = math.Min(s.limit+1/s.limit, t.opts.maxLimit)
+	}
+	if until := time.Now().Add(retryAfter); retryAfter > 0 && until.After(s.blockedUntil) {
+		s.blockedUntil = until
+	}
+	close(s.released)
+	s.released = make(chan struct{})
+}
+
+// parseRetryAfter parses the seconds or the http date of the Retry-After header
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// Transport wraps the base transport with the throttling, http.DefaultTransport is used if base is nil
+func (t *Throttler) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &throttleTransport{throttler: t, base: base}
+}
+
+type throttleTransport struct {
+	throttler *Throttler
+	base      http.RoundTripper
+}
+
+func (tt *throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t := tt.throttler
+	ctx := req.Context()
+	s := t.host(req.URL.Host)
+	for attempt := 0; ; attempt++ {
+		if err := t.acquire(ctx, req.URL.Host, s); err != nil {
+			return nil, err
+		}
+		resp, err := tt.base.RoundTrip(req)
+		if err != nil {
+			netErr, ok := err.(net.Error)
+			t.release(s, ok && netErr.Timeout(), 0)
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+			t.release(s, false, 0)
+			return resp, nil
+		}
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		t.release(s, true, retryAfter)
+
+		// the body can not be replayed
+		if attempt >= t.opts.maxRetries || retryAfter > t.opts.maxWait || req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		trace.GetTraceFromContext(ctx).Warnf("request to %s is throttled with %d, retry after %v", req.URL.Host, resp.StatusCode, retryAfter)
+	}
+}
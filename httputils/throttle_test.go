@@ -0,0 +1,136 @@
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tools-go/go-utils/priority"
+)
+
+func TestThrottlerRetryAfter(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 4:
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	throttler := NewThrottler()
+	start := time.Now()
+	resp, err := NewRestCli().Throttler(throttler).Host(server.URL).Post().Object(map[string]string{"a": "b"}).Do()
+	if err != nil || resp.Status != http.StatusOK || calls != 3 {
+		t.Fatalf("expect retried after the 429 and 503, got %v %v, %d calls", resp, err, calls)
+	}
+	if time.Since(start) < time.Second {
+		t.Fatal("expect waited for the Retry-After")
+	}
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	stats := throttler.Stats()[host]
+	if stats.Throttled != 2 || stats.Limit >= 16 || stats.Inflight != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	// the Retry-After is longer than the max wait, the response is returned
+	// and the following requests fail fast
+	resp, err = NewRestCli().Throttler(throttler).Host(server.URL).Do()
+	if err != nil || resp.Status != http.StatusServiceUnavailable {
+		t.Fatalf("expect 503 returned, got %v %v", resp, err)
+	}
+	if _, err := NewRestCli().Throttler(throttler).Host(server.URL).Do(); !IsThrottledError(err) {
+		t.Fatalf("expect throttled, got %v", err)
+	}
+}
+
+func TestThrottlerConcurrency(t *testing.T) {
+	var inflight, maxInflight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInflight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInflight, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewThrottler(WithConcurrencyLimit(2, 1, 2)).Transport(nil)}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			resp, err := client.Do(req.WithContext(context.TODO()))
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	if maxInflight != 2 {
+		t.Fatalf("expect at most 2 inflight requests, got %d", maxInflight)
+	}
+}
+
+func TestThrottlerPriority(t *testing.T) {
+	throttler := NewThrottler(WithConcurrencyLimit(4, 4, 4))
+	s := throttler.host("api")
+	acquire := func(p priority.Priority) error {
+		ctx, cancel := context.WithTimeout(priority.WithPriority(context.Background(), p), 20*time.Millisecond)
+		defer cancel()
+		return throttler.acquire(ctx, "api", s)
+	}
+	for i := 0; i < 2; i++ {
+		if err := acquire(priority.Background); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := acquire(priority.Background); err == nil {
+		t.Fatal("expect the background requests take half of the concurrency at most")
+	}
+	for i := 0; i < 2; i++ {
+		if err := acquire(priority.Normal); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := acquire(priority.Normal); err == nil {
+		t.Fatal("expect the normal requests limited")
+	}
+	if err := acquire(priority.Critical); err != nil {
+		t.Fatalf("expect the critical requests not limited by the concurrency, got %v", err)
+	}
+	if stats := throttler.Stats()["api"]; stats.Inflight != 5 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for value, expect := range map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"Wed, 01 Jan 2020 00:00:30 GMT": 30 * time.Second,
+		"invalid":                       0,
+	} {
+		if d := parseRetryAfter(value, now); d != expect {
+			t.Fatalf("unexpected retry after of %q: %v", value, d)
+		}
+	}
+}
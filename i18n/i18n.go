@@ -0,0 +1,242 @@
+// Package i18n localizes the messages by the catalogs of the locales.
+//
+// A catalog file is named by its locale, like "en.yaml", "zh-CN.json", the values are text/templates,
+// and a plural message is a map of the plural categories(zero, one, two, few, many, other):
+//
+//	hello: "Hello, {{.Name}}"
+//	unread:
+//	  one: "You have {{.Count}} unread message"
+//	  other: "You have {{.Count}} unread messages"
+//
+// The catalogs can be embedded by embed.FS and loaded by LoadFS, or loaded from a directory by LoadDir.
+package i18n
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// message is a localized message, a simple message has the "other" form only
+type message struct {
+	forms map[string]*template.Template
+}
+
+// Catalog holds the messages of the locales
+type Catalog struct {
+	fallback string
+
+	mu       sync.RWMutex
+	messages map[string]map[string]*message // locale -> key -> message
+}
+
+// NewCatalog creates a catalog, fallback is the locale used when a message is missing in the requested one
+func NewCatalog(fallback string) *Catalog {
+	return &Catalog{fallback: canonical(fallback), messages: map[string]map[string]*message{}}
+}
+
+// Fallback returns the fallback locale
+func (c *Catalog) Fallback() string {
+	return c.fallback
+}
+
+// Locales returns the locales which have messages
+func (c *Catalog) Locales() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	locales := make([]string, 0, len(c.messages))
+	for locale := range c.messages {
+		locales = append(locales, locale)
+	}
+	return sortedLocales(locales, c.fallback)
+}
+
+// Add adds the messages of locale, a value is a string or a map of the plural forms
+func (c *Catalog) Add(locale string, messages map[string]interface{}) error {
+	locale = canonical(locale)
+	parsed := make(map[string]*message, len(messages))
+	for key, value := range messages {
+		m := &message{forms: map[string]*template.Template{}}
+		switch v := value.(type) {
+		case string:
+			tmpl, err := template.New(key).Parse(v)
+			if err != nil {
+				return fmt.Errorf("parse message %s of %s failed: %s", key, locale, err)
+			}
+			m.forms[Other] = tmpl
+		case map[string]interface{}:
+			for form, text := range v {
+				form = strings.ToLower(form)
+				if !validForm(form) {
+					return fmt.Errorf("message %s of %s has an invalid plural form %s", key, locale, form)
+				}
+				tmpl, err := template.New(key + "." + form).Parse(fmt.Sprint(text))
+				if err != nil {
+					return fmt.Errorf("parse message %s of %s failed: %s", key, locale, err)
+				}
+				m.forms[form] = tmpl
+			}
+			if m.forms[Other] == nil {
+				return fmt.Errorf("message %s of %s has no \"other\" form", key, locale)
+			}
+		default:
+			return fmt.Errorf("message %s of %s should be a string or a map, got %T", key, locale, value)
+		}
+		parsed[key] = m
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.messages[locale] == nil {
+		c.messages[locale] = map[string]*message{}
+	}
+	for key, m := range parsed {
+		c.messages[locale][key] = m
+	}
+	return nil
+}
+
+// LoadFS loads the catalog files(*.json, *.yaml, *.yml) in dir of fsys, the file names are the locales
+func (c *Catalog) LoadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		ext := path.Ext(entry.Name())
+		if entry.IsDir() || ext != ".json" && ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		messages, err := parseFile(data, ext)
+		if err != nil {
+			return fmt.Errorf("parse %s failed: %s", entry.Name(), err)
+		}
+		if err := c.Add(strings.TrimSuffix(entry.Name(), ext), messages); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadDir loads the catalog files in the local directory
+func (c *Catalog) LoadDir(dir string) error {
+	return c.LoadFS(os.DirFS(dir), ".")
+}
+
+func parseFile(data []byte, ext string) (map[string]interface{}, error) {
+	var raw map[string]interface{}
+	if ext == ".json" {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+		return raw, nil
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	raw = make(map[string]interface{}, len(doc))
+	for key, value := range doc {
+		// yaml.v2 decodes the nested maps as map[interface{}]interface{}
+		if m, ok := value.(map[interface{}]interface{}); ok {
+			forms := make(map[string]interface{}, len(m))
+			for k, v := range m {
+				forms[fmt.Sprint(k)] = v
+			}
+			value = forms
+		}
+		raw[key] = value
+	}
+	return raw, nil
+}
+
+// lookup finds the message in locale, its parent locales and the fallback locale
+func (c *Catalog) lookup(locale, key string) (*message, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, l := range append(parents(locale), c.fallback) {
+		if m, ok := c.messages[l][key]; ok {
+			return m, l
+		}
+	}
+	return nil, ""
+}
+
+// Localizer localizes the messages for a locale
+type Localizer struct {
+	catalog *Catalog
+	locale  string
+}
+
+// Localizer returns a Localizer of locale
+func (c *Catalog) Localizer(locale string) *Localizer {
+	return &Localizer{catalog: c, locale: canonical(locale)}
+}
+
+// Locale returns the locale of the localizer
+func (l *Localizer) Locale() string {
+	return l.locale
+}
+
+// T returns the message of key executed with data, the key is returned if the message is missing
+func (l *Localizer) T(key string, data ...interface{}) string {
+	var d interface{}
+	if len(data) > 0 {
+		d = data[0]
+	}
+	return l.render(key, Other, d)
+}
+
+// N returns the plural form of the message of key for count, data is available as {{.Data}} and the count as {{.Count}}
+func (l *Localizer) N(key string, count int, data ...interface{}) string {
+	var d interface{}
+	if len(data) > 0 {
+		d = data[0]
+	}
+	m, locale := l.catalog.lookup(l.locale, key)
+	if m == nil {
+		return key
+	}
+	return l.execute(m, PluralForm(locale, count), key, map[string]interface{}{"Count": count, "Data": d})
+}
+
+func (l *Localizer) render(key, form string, data interface{}) string {
+	m, _ := l.catalog.lookup(l.locale, key)
+	if m == nil {
+		return key
+	}
+	return l.execute(m, form, key, data)
+}
+
+func (l *Localizer) execute(m *message, form, key string, data interface{}) string {
+	tmpl := m.forms[form]
+	if tmpl == nil {
+		tmpl = m.forms[Other]
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return key
+	}
+	return buf.String()
+}
+
+// FuncMap returns the template functions "T" and "N" of the localizer,
+// so that the templates of the pages and notifications can be localized
+func (l *Localizer) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"T": l.T,
+		"N": l.N,
+	}
+}
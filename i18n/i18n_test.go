@@ -0,0 +1,130 @@
+package i18n
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+
+	"github.com/leopoldxx/go-utils/errors"
+)
+
+func testCatalog(t *testing.T) *Catalog {
+	c := NewCatalog("en")
+	if err := c.LoadDir("testdata"); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestLocalizer(t *testing.T) {
+	c := testCatalog(t)
+	if locales := c.Locales(); len(locales) != 3 || locales[0] != "en" {
+		t.Fatalf("unexpected locales: %v", locales)
+	}
+
+	zh := c.Localizer("zh_cn")
+	if s := zh.T("hello", map[string]string{"Name": "Tom"}); s != "你好，Tom" {
+		t.Fatalf("unexpected message: %s", s)
+	}
+	if s := zh.N("unread", 1); s != "你有 1 条未读消息" {
+		t.Fatalf("unexpected plural: %s", s)
+	}
+	// missing messages fall back to en, then to the key
+	if s := zh.T("only_en"); s != "English only" {
+		t.Fatalf("unexpected fallback: %s", s)
+	}
+	if s := zh.T("missing"); s != "missing" {
+		t.Fatalf("unexpected missing: %s", s)
+	}
+
+	en := c.Localizer("en-US")
+	if s := en.N("unread", 1); s != "You have 1 unread message" {
+		t.Fatalf("unexpected plural: %s", s)
+	}
+	if s := en.N("unread", 5); s != "You have 5 unread messages" {
+		t.Fatalf("unexpected plural: %s", s)
+	}
+
+	ru := c.Localizer("ru")
+	for n, expected := range map[int]string{1: "1 сообщение", 3: "3 сообщения", 5: "5 сообщений", 21: "21 сообщение", 12: "12 сообщений"} {
+		if s := ru.N("unread", n); s != expected {
+			t.Fatalf("expect %s, got %s", expected, s)
+		}
+	}
+}
+
+func TestCatalogAdd(t *testing.T) {
+	c := NewCatalog("en")
+	if err := c.Add("en", map[string]interface{}{"x": map[string]interface{}{"one": "a"}}); err == nil {
+		t.Fatal("expect the other form is required")
+	}
+	if err := c.Add("en", map[string]interface{}{"x": map[string]interface{}{"some": "a", "other": "b"}}); err == nil {
+		t.Fatal("expect invalid plural form")
+	}
+	if err := c.Add("en", map[string]interface{}{"x": "{{.Broken"}); err == nil {
+		t.Fatal("expect invalid template")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	supported := []string{"en", "zh-CN", "fr-FR"}
+	for header, expected := range map[string]string{
+		"":                            "en",
+		"de":                          "en",
+		"zh-CN,zh;q=0.9,en;q=0.8":     "zh-CN",
+		"zh-cn-x-private":             "zh-CN",
+		"fr":                          "fr-FR",
+		"de;q=1, fr;q=0.5, en;q=0.7":  "en",
+		"zh-CN;q=0, en-GB;q=0.5, *":   "en",
+		"ja, zh-TW;q=0.8, zh-CN;q=0.": "en",
+	} {
+		if locale := Negotiate(header, supported); locale != expected {
+			t.Fatalf("%q: expect %s, got %s", header, expected, locale)
+		}
+	}
+}
+
+func TestNegotiation(t *testing.T) {
+	c := testCatalog(t)
+	handler := Negotiation(c).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(T(r.Context(), "hello", map[string]string{"Name": "Tom"})))
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9")
+	handler(w, req)
+	if w.Body.String() != "你好，Tom" || w.Header().Get("Content-Language") != "zh-CN" {
+		t.Fatalf("unexpected response: %s %v", w.Body.String(), w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/?lang=en", req.Body))
+	if w.Body.String() != "Hello, Tom" {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+}
+
+func TestLocalizeError(t *testing.T) {
+	en := testCatalog(t).Localizer("en")
+	if s := en.Error(errors.NewNotFoundError("user 1")); s != "resource 'user 1' is not found, please check the id" {
+		t.Fatalf("unexpected error: %s", s)
+	}
+	if s := en.Error(errors.NewBadRequestError("bad")); s != "bad" {
+		t.Fatalf("unexpected error: %s", s)
+	}
+}
+
+func TestFuncMap(t *testing.T) {
+	tmpl := template.Must(template.New("page").Funcs(testCatalog(t).Localizer("en").FuncMap()).
+		Parse(`{{T "hello" .}} {{N "unread" .Count}}`))
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, map[string]interface{}{"Name": "Tom", "Count": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "Hello, Tom You have 2 unread messages" {
+		t.Fatalf("unexpected page: %s", buf.String())
+	}
+}
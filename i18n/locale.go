@@ -0,0 +1,176 @@
+package i18n
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/leopoldxx/go-utils/errors"
+	"github.com/leopoldxx/go-utils/middleware"
+)
+
+// canonical formats the locale like "zh-CN", "zh_cn" is accepted too
+func canonical(locale string) string {
+	parts := strings.Split(strings.Replace(strings.TrimSpace(locale), "_", "-", -1), "-")
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		switch len(parts[i]) {
+		case 2: // region
+			parts[i] = strings.ToUpper(parts[i])
+		case 4: // script, like Hans
+			parts[i] = strings.ToUpper(parts[i][:1]) + strings.ToLower(parts[i][1:])
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// parents returns locale and its parent locales, like "zh-Hans-CN", "zh-Hans", "zh"
+func parents(locale string) []string {
+	var locales []string
+	for locale != "" {
+		locales = append(locales, locale)
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+	return locales
+}
+
+func sortedLocales(locales []string, first string) []string {
+	sort.Slice(locales, func(i, j int) bool {
+		if (locales[i] == first) != (locales[j] == first) {
+			return locales[i] == first
+		}
+		return locales[i] < locales[j]
+	})
+	return locales
+}
+
+// Negotiate selects the best supported locale for the Accept-Language header,
+// the first supported locale is returned if nothing matches
+func Negotiate(acceptLanguage string, supported []string) string {
+	if len(supported) == 0 {
+		return ""
+	}
+	type weighted struct {
+		locale string
+		q      float64
+	}
+	var wanted []weighted
+	for _, item := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(item), ";")
+		if fields[0] == "" || fields[0] == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			wanted = append(wanted, weighted{canonical(fields[0]), q})
+		}
+	}
+	sort.SliceStable(wanted, func(i, j int) bool { return wanted[i].q > wanted[j].q })
+
+	canonicals := make([]string, len(supported))
+	for i, s := range supported {
+		canonicals[i] = canonical(s)
+	}
+	for _, w := range wanted {
+		// "zh-CN" matches "zh-CN", then "zh"
+		for _, l := range parents(w.locale) {
+			for i, s := range canonicals {
+				if s == l {
+					return supported[i]
+				}
+			}
+		}
+		// "en" matches "en-US"
+		for i, s := range canonicals {
+			if strings.HasPrefix(s, w.locale+"-") {
+				return supported[i]
+			}
+		}
+	}
+	return supported[0]
+}
+
+type localizerKey struct{}
+
+// WithLocalizer returns a copy of ctx with the localizer
+func WithLocalizer(ctx context.Context, l *Localizer) context.Context {
+	return context.WithValue(ctx, localizerKey{}, l)
+}
+
+// LocalizerFromContext returns the localizer in ctx, nil if absent
+func LocalizerFromContext(ctx context.Context) *Localizer {
+	l, _ := ctx.Value(localizerKey{}).(*Localizer)
+	return l
+}
+
+// T localizes the message by the localizer in ctx, the key is returned if there is no localizer
+func T(ctx context.Context, key string, data ...interface{}) string {
+	if l := LocalizerFromContext(ctx); l != nil {
+		return l.T(key, data...)
+	}
+	return key
+}
+
+// Negotiation middleware selects the locale of the request from the "lang" query parameter
+// or the Accept-Language header, and puts the localizer of it into the request context
+func Negotiation(c *Catalog) middleware.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			lang := r.Header.Get("Accept-Language")
+			if q := r.URL.Query().Get("lang"); q != "" {
+				lang = q
+			}
+			locale := Negotiate(lang, c.Locales())
+			w.Header().Set("Content-Language", locale)
+			next(w, r.WithContext(WithLocalizer(r.Context(), c.Localizer(locale))))
+		}
+	}
+}
+
+// errorKeys are the message keys of the error kinds of the errors package
+var errorKeys = []struct {
+	key string
+	is  func(error) bool
+}{
+	{"errors.bad_request", errors.IsBadRequestError},
+	{"errors.param", errors.IsParamError},
+	{"errors.not_found", errors.IsNotFoundError},
+	{"errors.conflict", errors.IsConflictError},
+	{"errors.forbidden", errors.IsForbiddenError},
+	{"errors.not_ready", errors.IsNotReadyError},
+	{"errors.task_running", errors.IsTaskIsRunningError},
+	{"errors.db", errors.IsDBError},
+	{"errors.server", errors.IsServerError},
+}
+
+// Error localizes err by the message of its kind in the errors package, like "errors.not_found",
+// the original error message is available as {{.Error}}, err.Error() is returned if the message is missing
+func (l *Localizer) Error(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, k := range errorKeys {
+		if !k.is(err) {
+			continue
+		}
+		if m, _ := l.catalog.lookup(l.locale, k.key); m != nil {
+			return l.execute(m, Other, k.key, map[string]interface{}{"Error": err.Error()})
+		}
+		break
+	}
+	return err.Error()
+}
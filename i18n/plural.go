@@ -0,0 +1,120 @@
+package i18n
+
+import (
+	"strings"
+	"sync"
+)
+
+// the plural categories of CLDR
+const (
+	Zero  = "zero"
+	One   = "one"
+	Two   = "two"
+	Few   = "few"
+	Many  = "many"
+	Other = "other"
+)
+
+// PluralRule returns the plural category of n
+type PluralRule func(n int) string
+
+var (
+	rulesMu sync.RWMutex
+	rules   = map[string]PluralRule{}
+)
+
+func init() {
+	oneOther := func(n int) string {
+		if n == 1 {
+			return One
+		}
+		return Other
+	}
+	for _, lang := range []string{"en", "de", "nl", "sv", "da", "no", "it", "es", "pt", "el", "fi", "hu", "tr", "bg"} {
+		rules[lang] = oneOther
+	}
+	for _, lang := range []string{"zh", "ja", "ko", "th", "vi", "id", "ms"} {
+		rules[lang] = func(n int) string { return Other }
+	}
+	rules["fr"] = func(n int) string {
+		if n == 0 || n == 1 {
+			return One
+		}
+		return Other
+	}
+	slavic := func(n int) string {
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return One
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return Few
+		}
+		return Many
+	}
+	rules["ru"] = slavic
+	rules["uk"] = slavic
+	rules["pl"] = func(n int) string {
+		switch {
+		case n == 1:
+			return One
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return Few
+		}
+		return Many
+	}
+	rules["cs"] = func(n int) string {
+		switch {
+		case n == 1:
+			return One
+		case n >= 2 && n <= 4:
+			return Few
+		}
+		return Other
+	}
+	rules["ar"] = func(n int) string {
+		switch {
+		case n == 0:
+			return Zero
+		case n == 1:
+			return One
+		case n == 2:
+			return Two
+		case n%100 >= 3 && n%100 <= 10:
+			return Few
+		case n%100 >= 11:
+			return Many
+		}
+		return Other
+	}
+}
+
+// RegisterPluralRule set the plural rule of a language, like "en" or "pt-BR"
+func RegisterPluralRule(lang string, rule PluralRule) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	rules[canonical(lang)] = rule
+}
+
+// PluralForm returns the plural category of n in locale, the rule of
+// the parent locale is used if absent, and "other" if none is found
+func PluralForm(locale string, n int) string {
+	if n < 0 {
+		n = -n
+	}
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	for _, l := range parents(canonical(locale)) {
+		if rule, ok := rules[l]; ok {
+			return rule(n)
+		}
+	}
+	return Other
+}
+
+func validForm(form string) bool {
+	switch strings.ToLower(form) {
+	case Zero, One, Two, Few, Many, Other:
+		return true
+	}
+	return false
+}
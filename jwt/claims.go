@@ -0,0 +1,78 @@
+package jwt
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Claims is implemented by the claims structs which embed RegisteredClaims, like
+//
+//	type UserClaims struct {
+//		jwt.RegisteredClaims
+//		Roles []string `json:"roles"`
+//	}
+type Claims interface {
+	Registered() *RegisteredClaims
+}
+
+// RegisteredClaims are the registered claims of RFC 7519, the times are the unix seconds
+type RegisteredClaims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Registered implements Claims
+func (c *RegisteredClaims) Registered() *RegisteredClaims {
+	return c
+}
+
+// ExpiresTime returns the expiration time, zero if the token never expires
+func (c *RegisteredClaims) ExpiresTime() time.Time {
+	if c.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// Audience is the "aud" claim, which is a string or an array of strings
+type Audience []string
+
+// Contains reports whether aud contains any of the values
+func (aud Audience) Contains(values ...string) bool {
+	for _, a := range aud {
+		for _, v := range values {
+			if a == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MarshalJSON encodes a single audience as a string
+func (aud Audience) MarshalJSON() ([]byte, error) {
+	if len(aud) == 1 {
+		return json.Marshal(aud[0])
+	}
+	return json.Marshal([]string(aud))
+}
+
+// UnmarshalJSON decodes a string or an array of strings
+func (aud *Audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*aud = Audience{s}
+		return nil
+	}
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*aud = values
+	return nil
+}
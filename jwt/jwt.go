@@ -0,0 +1,300 @@
+// Package jwt issues and verifies the HS256, RS256 and ES256 JSON web tokens with the typed claims.
+//
+// The keys are provided by a KeySet, SecretKeys loads them from the secrets provider,
+// so the signing key can be rotated while the tokens signed by the previous keys are still accepted.
+package jwt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidToken is wrapped by all the errors of the invalid tokens, which should be responded with 401
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrMalformed is returned when the token can not be decoded
+	ErrMalformed = fmt.Errorf("%w: malformed", ErrInvalidToken)
+	// ErrUnknownKey is returned when no key matches the kid and the alg of the token
+	ErrUnknownKey = fmt.Errorf("%w: unknown key", ErrInvalidToken)
+	// ErrSignature is returned when the signature mismatches
+	ErrSignature = fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+	// ErrExpired is returned when the token is expired
+	ErrExpired = fmt.Errorf("%w: expired", ErrInvalidToken)
+	// ErrNotValidYet is returned when the token is used before its "nbf" or "iat"
+	ErrNotValidYet = fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	// ErrClaims is returned when the issuer or the audience mismatches
+	ErrClaims = fmt.Errorf("%w: claims mismatch", ErrInvalidToken)
+	// ErrRevoked is returned when the token is revoked
+	ErrRevoked = fmt.Errorf("%w: revoked", ErrInvalidToken)
+
+	// ErrNoSigningKey is returned when the key set can not sign the tokens
+	ErrNoSigningKey = errors.New("no signing key")
+)
+
+// RevocationFunc reports whether the token of the claims is revoked, e.g. by looking up the "jti" in a revocation list
+type RevocationFunc func(ctx context.Context, claims *RegisteredClaims) (bool, error)
+
+type options struct {
+	issuer   string
+	audience []string
+	ttl      time.Duration
+	leeway   time.Duration
+	revoked  RevocationFunc
+	now      func() time.Time
+}
+
+// Option is the option of Manager
+type Option func(opts *options)
+
+// WithIssuer sets the "iss" of the issued tokens, and requires it on the verified ones
+func WithIssuer(issuer string) Option {
+	return func(opts *options) {
+		opts.issuer = issuer
+	}
+}
+
+// WithAudience sets the "aud" of the issued tokens, and requires any of them on the verified ones
+func WithAudience(audience ...string) Option {
+	return func(opts *options) {
+		opts.audience = audience
+	}
+}
+
+// WithTTL sets the "exp" of the issued tokens, 1 hour by default, the "exp" set in the claims is kept
+func WithTTL(ttl time.Duration) Option {
+	return func(opts *options) {
+		opts.ttl = ttl
+	}
+}
+
+// WithLeeway tolerates the clock skew between the issuers and the verifiers, 1 minute by default
+func WithLeeway(leeway time.Duration) Option {
+	return func(opts *options) {
+		opts.leeway = leeway
+	}
+}
+
+// WithRevocation checks the verified tokens against a revocation list
+func WithRevocation(fn RevocationFunc) Option {
+	return func(opts *options) {
+		opts.revoked = fn
+	}
+}
+
+// WithClock replaces time.Now, mainly for the tests
+func WithClock(now func() time.Time) Option {
+	return func(opts *options) {
+		opts.now = now
+	}
+}
+
+// Manager issues and verifies the tokens
+type Manager struct {
+	keys KeySet
+	opts options
+}
+
+// New creates a manager of the key set
+func New(keys KeySet, opts ...Option) *Manager {
+	m := &Manager{
+		keys: keys,
+		opts: options{ttl: time.Hour, leeway: time.Minute, now: time.Now},
+	}
+	for _, opt := range opts {
+		opt(&m.opts)
+	}
+	return m
+}
+
+type header struct {
+	Alg Algorithm `json:"alg"`
+	Typ string    `json:"typ,omitempty"`
+	Kid string    `json:"kid,omitempty"`
+}
+
+var encoding = base64.RawURLEncoding
+
+// Issue signs the claims, "iss", "aud", "iat", "exp" and "jti" are filled if empty
+func (m *Manager) Issue(ctx context.Context, claims Claims) (string, error) {
+	key, err := m.keys.SigningKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	rc := claims.Registered()
+	now := m.opts.now()
+	if rc.Issuer == "" {
+		rc.Issuer = m.opts.issuer
+	}
+	if len(rc.Audience) == 0 {
+		rc.Audience = m.opts.audience
+	}
+	if rc.IssuedAt == 0 {
+		rc.IssuedAt = now.Unix()
+	}
+	if rc.ExpiresAt == 0 && m.opts.ttl > 0 {
+		rc.ExpiresAt = now.Add(m.opts.ttl).Unix()
+	}
+	if rc.ID == "" {
+		rc.ID = newID()
+	}
+
+	h, err := json.Marshal(header{Alg: key.Alg, Typ: "JWT", Kid: key.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := encoding.EncodeToString(h) + "." + encoding.EncodeToString(payload)
+	sig, err := key.sign(input)
+	if err != nil {
+		return "", err
+	}
+	return input + "." + encoding.EncodeToString(sig), nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Verify verifies the token and decodes its claims into claims, the errors of the invalid tokens wrap
+// ErrInvalidToken, while the errors of the key set and the revocation list are returned as they are
+func (m *Manager) Verify(ctx context.Context, token string, claims Claims) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrMalformed
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return err
+	}
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("%w: signature: %s", ErrMalformed, err)
+	}
+	// the key is selected by the alg, so an "alg":"none" or a HS256 token signed by a public key never matches
+	key, err := m.keys.VerifyingKey(ctx, h.Kid, h.Alg)
+	if err != nil {
+		return err
+	}
+	if !key.verify(parts[0]+"."+parts[1], sig) {
+		return ErrSignature
+	}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return err
+	}
+	return m.validate(ctx, claims.Registered())
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := encoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrMalformed, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %s", ErrMalformed, err)
+	}
+	return nil
+}
+
+func (m *Manager) validate(ctx context.Context, rc *RegisteredClaims) error {
+	now := m.opts.now()
+	leeway := m.opts.leeway
+	if rc.ExpiresAt != 0 && !now.Before(time.Unix(rc.ExpiresAt, 0).Add(leeway)) {
+		return fmt.Errorf("%w at %s", ErrExpired, time.Unix(rc.ExpiresAt, 0).Format(time.RFC3339))
+	}
+	if rc.NotBefore != 0 && now.Add(leeway).Before(time.Unix(rc.NotBefore, 0)) {
+		return fmt.Errorf("%w before %s", ErrNotValidYet, time.Unix(rc.NotBefore, 0).Format(time.RFC3339))
+	}
+	if rc.IssuedAt != 0 && now.Add(leeway).Before(time.Unix(rc.IssuedAt, 0)) {
+		return fmt.Errorf("%w: issued in the future", ErrNotValidYet)
+	}
+	if m.opts.issuer != "" && rc.Issuer != m.opts.issuer {
+		return fmt.Errorf("%w: issuer %q", ErrClaims, rc.Issuer)
+	}
+	if len(m.opts.audience) > 0 && !rc.Audience.Contains(m.opts.audience...) {
+		return fmt.Errorf("%w: audience %q", ErrClaims, []string(rc.Audience))
+	}
+	if m.opts.revoked != nil {
+		revoked, err := m.opts.revoked(ctx, rc)
+		if err != nil {
+			return err
+		}
+		if revoked {
+			return ErrRevoked
+		}
+	}
+	return nil
+}
+
+// BearerToken returns the token of the "Authorization: Bearer" header, empty if absent
+func BearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// Transport returns a http.RoundTripper for the service to service calls, which sets the
+// "Authorization: Bearer" header with a token of the claims. The token is reused until
+// half of its lifetime passed, claims is called to create the claims of every new token.
+func (m *Manager) Transport(base http.RoundTripper, claims func() Claims) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{manager: m, base: base, claims: claims}
+}
+
+type transport struct {
+	manager *Manager
+	base    http.RoundTripper
+	claims  func() Claims
+
+	mu      sync.Mutex
+	token   string
+	refresh time.Time
+}
+
+func (t *transport) getToken(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.manager.opts.now()
+	if t.token != "" && now.Before(t.refresh) {
+		return t.token, nil
+	}
+	claims := t.claims()
+	token, err := t.manager.Issue(ctx, claims)
+	if err != nil {
+		return "", err
+	}
+	t.token = token
+	t.refresh = now.Add(t.manager.opts.ttl / 2)
+	if exp := claims.Registered().ExpiresTime(); !exp.IsZero() {
+		t.refresh = now.Add(exp.Sub(now) / 2)
+	}
+	return token, nil
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	token, err := t.getToken(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,201 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	utilerrors "github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/secrets"
+)
+
+type userClaims struct {
+	RegisteredClaims
+	Roles []string `json:"roles"`
+}
+
+func pemKey(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestIssueVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	for _, c := range []struct {
+		alg  Algorithm
+		data []byte
+	}{
+		{HS256, []byte("a-very-long-hmac-secret")},
+		{RS256, pemKey(t, rsaKey)},
+		{ES256, pemKey(t, ecKey)},
+	} {
+		key, err := ParseKey(c.alg, c.data)
+		if err != nil {
+			t.Fatalf("%s: %v", c.alg, err)
+		}
+		m := New(StaticKeys(key), WithIssuer("auth"), WithAudience("api"))
+		token, err := m.Issue(context.Background(), &userClaims{RegisteredClaims: RegisteredClaims{Subject: "u1"}, Roles: []string{"admin"}})
+		if err != nil {
+			t.Fatalf("%s: %v", c.alg, err)
+		}
+		var claims userClaims
+		if err := m.Verify(context.Background(), token, &claims); err != nil {
+			t.Fatalf("%s: %v", c.alg, err)
+		}
+		if claims.Subject != "u1" || claims.Issuer != "auth" || !claims.Audience.Contains("api") ||
+			claims.ID == "" || len(claims.Roles) != 1 || claims.ExpiresAt-claims.IssuedAt != 3600 {
+			t.Fatalf("%s: unexpected claims %+v", c.alg, claims)
+		}
+
+		// tamper the payload
+		parts := strings.Split(token, ".")
+		parts[1] = encoding.EncodeToString([]byte(`{"sub":"u2","iss":"auth","aud":"api"}`))
+		if err := m.Verify(context.Background(), strings.Join(parts, "."), &claims); !errors.Is(err, ErrSignature) {
+			t.Fatalf("%s: expect signature mismatch, got %v", c.alg, err)
+		}
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	clock := func() time.Time { return now }
+	secret := NewHMACKey("k1", []byte("a-very-long-hmac-secret"))
+	m := New(StaticKeys(secret), WithClock(clock), WithLeeway(30*time.Second), WithAudience("api"))
+
+	issue := func(rc RegisteredClaims) string {
+		token, err := m.Issue(context.Background(), &rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	for _, c := range []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"skewed exp", issue(RegisteredClaims{ExpiresAt: now.Unix() - 10}), nil},
+		{"expired", issue(RegisteredClaims{ExpiresAt: now.Unix() - 30}), ErrExpired},
+		{"skewed nbf", issue(RegisteredClaims{NotBefore: now.Unix() + 10}), nil},
+		{"not before", issue(RegisteredClaims{NotBefore: now.Unix() + 60}), ErrNotValidYet},
+		{"audience", issue(RegisteredClaims{Audience: Audience{"web"}}), ErrClaims},
+		{"malformed", "a.b", ErrMalformed},
+		{"alg none", encoding.EncodeToString([]byte(`{"alg":"none","kid":"k1"}`)) + ".e30.", ErrUnknownKey},
+	} {
+		err := m.Verify(context.Background(), c.token, &RegisteredClaims{})
+		if c.err == nil && err != nil || c.err != nil && !errors.Is(err, c.err) {
+			t.Fatalf("%s: expect %v, got %v", c.name, c.err, err)
+		}
+		if c.err != nil && !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("%s: expect invalid token, got %v", c.name, err)
+		}
+	}
+
+	// a RS256 key never verifies a HS256 token signed by its public key
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pub, _ := ParseKey(RS256, pemKey(t, rsaKey))
+	pub.Private = nil
+	der, _ := x509.MarshalPKIXPublicKey(pub.Public)
+	forged, _ := New(StaticKeys(NewHMACKey(pub.ID, der))).Issue(context.Background(), &RegisteredClaims{})
+	if err := New(StaticKeys(pub)).Verify(context.Background(), forged, &RegisteredClaims{}); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expect unknown key, got %v", err)
+	}
+	if _, err := New(StaticKeys(pub)).Issue(context.Background(), &RegisteredClaims{}); !errors.Is(err, ErrNoSigningKey) {
+		t.Fatalf("expect no signing key, got %v", err)
+	}
+}
+
+func TestRevocation(t *testing.T) {
+	revoked := map[string]bool{}
+	m := New(StaticKeys(NewHMACKey("", []byte("a-very-long-hmac-secret"))),
+		WithRevocation(func(ctx context.Context, claims *RegisteredClaims) (bool, error) {
+			return revoked[claims.ID], nil
+		}))
+	claims := &RegisteredClaims{}
+	token, _ := m.Issue(context.Background(), claims)
+	if err := m.Verify(context.Background(), token, &RegisteredClaims{}); err != nil {
+		t.Fatal(err)
+	}
+	revoked[claims.ID] = true
+	if err := m.Verify(context.Background(), token, &RegisteredClaims{}); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("expect revoked, got %v", err)
+	}
+}
+
+func TestSecretKeysRotation(t *testing.T) {
+	values := map[string]string{"jwt.current": "the-first-hmac-secret"}
+	provider := secrets.ProviderFunc(func(ctx context.Context, name string) (secrets.Secret, error) {
+		if v, ok := values[name]; ok {
+			return secrets.Secret(v), nil
+		}
+		return "", utilerrors.NewNotFoundError("secret " + name)
+	})
+	m := New(SecretKeys(provider, HS256, "jwt.current", "jwt.previous"))
+	old, err := m.Issue(context.Background(), &RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rotate
+	values["jwt.previous"], values["jwt.current"] = values["jwt.current"], "the-second-hmac-secret"
+	token, _ := m.Issue(context.Background(), &RegisteredClaims{})
+	for _, tk := range []string{old, token} {
+		if err := m.Verify(context.Background(), tk, &RegisteredClaims{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// retire the previous secret
+	delete(values, "jwt.previous")
+	if err := m.Verify(context.Background(), old, &RegisteredClaims{}); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expect unknown key, got %v", err)
+	}
+	if err := m.Verify(context.Background(), token, &RegisteredClaims{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	m := New(StaticKeys(NewHMACKey("", []byte("a-very-long-hmac-secret"))), WithAudience("orders"))
+	var tokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := BearerToken(r)
+		if err := m.Verify(r.Context(), token, &RegisteredClaims{}); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		tokens = append(tokens, token)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: m.Transport(nil, func() Claims {
+		return &RegisteredClaims{Subject: "billing"}
+	})}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d", resp.StatusCode)
+		}
+	}
+	if len(tokens) != 2 || tokens[0] != tokens[1] {
+		t.Fatal("expect the token to be reused")
+	}
+}
@@ -0,0 +1,305 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	utilerrors "github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/secrets"
+)
+
+// Algorithm is the "alg" of the tokens
+type Algorithm string
+
+// the supported algorithms
+const (
+	HS256 Algorithm = "HS256"
+	RS256 Algorithm = "RS256"
+	ES256 Algorithm = "ES256"
+)
+
+// Key is a signing or verifying key
+type Key struct {
+	// ID is the "kid" of the tokens
+	ID  string
+	Alg Algorithm
+	// Secret is the key of HS256
+	Secret []byte
+	// Private is the *rsa.PrivateKey of RS256 or the *ecdsa.PrivateKey of ES256, nil for the verifying only keys
+	Private crypto.Signer
+	// Public is the *rsa.PublicKey of RS256 or the *ecdsa.PublicKey of ES256
+	Public crypto.PublicKey
+}
+
+// NewHMACKey creates a HS256 key, the ID is derived from the secret if empty
+func NewHMACKey(id string, secret []byte) *Key {
+	if id == "" {
+		id = keyID(secret)
+	}
+	return &Key{ID: id, Alg: HS256, Secret: secret}
+}
+
+// ParseKey parses a key of alg, data is the secret of HS256, or a PEM encoded private or public key
+// of RS256 and ES256. The ID of the key is derived from the secret or the public key.
+func ParseKey(alg Algorithm, data []byte) (*Key, error) {
+	if alg == HS256 {
+		if len(data) == 0 {
+			return nil, errors.New("empty HS256 secret")
+		}
+		return NewHMACKey("", data), nil
+	}
+	if alg != RS256 && alg != ES256 {
+		return nil, fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data of the %s key", alg)
+	}
+	key := &Key{Alg: alg}
+	if priv, err := parsePrivateKey(block.Bytes); err == nil {
+		key.Private = priv
+		key.Public = priv.Public()
+	} else if pub, err := parsePublicKey(block.Bytes); err == nil {
+		key.Public = pub
+	} else {
+		return nil, fmt.Errorf("parse %s key failed: %s", alg, err)
+	}
+	if err := key.check(); err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public)
+	if err != nil {
+		return nil, err
+	}
+	key.ID = keyID(der)
+	return key, nil
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return x509.ParseECPrivateKey(der)
+}
+
+func parsePublicKey(der []byte) (crypto.PublicKey, error) {
+	if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PublicKey(der)
+}
+
+// keyID is a short fingerprint of the key material, which is safe to be exposed in the tokens
+func keyID(material []byte) string {
+	sum := sha256.Sum256(material)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// check validates the key type against the algorithm
+func (k *Key) check() error {
+	switch k.Alg {
+	case HS256:
+		if len(k.Secret) == 0 {
+			return errors.New("empty HS256 secret")
+		}
+	case RS256:
+		if _, ok := k.Public.(*rsa.PublicKey); !ok {
+			return fmt.Errorf("RS256 requires a RSA key, got %T", k.Public)
+		}
+	case ES256:
+		pub, ok := k.Public.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() {
+			return fmt.Errorf("ES256 requires a P-256 ECDSA key, got %T", k.Public)
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm %s", k.Alg)
+	}
+	return nil
+}
+
+// CanSign reports whether the key has the private part
+func (k *Key) CanSign() bool {
+	return k.Alg == HS256 && len(k.Secret) > 0 || k.Private != nil
+}
+
+func (k *Key) sign(input string) ([]byte, error) {
+	if !k.CanSign() {
+		return nil, fmt.Errorf("key %s can not sign", k.ID)
+	}
+	switch k.Alg {
+	case HS256:
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write([]byte(input))
+		return mac.Sum(nil), nil
+	case RS256:
+		digest := sha256.Sum256([]byte(input))
+		return k.Private.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case ES256:
+		priv, ok := k.Private.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("ES256 requires an ECDSA private key, got %T", k.Private)
+		}
+		digest := sha256.Sum256([]byte(input))
+		r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		// JWS uses the fixed size R || S instead of the ASN.1 encoding
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm %s", k.Alg)
+}
+
+func (k *Key) verify(input string, sig []byte) bool {
+	switch k.Alg {
+	case HS256:
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write([]byte(input))
+		return len(k.Secret) > 0 && hmac.Equal(sig, mac.Sum(nil))
+	case RS256:
+		pub, ok := k.Public.(*rsa.PublicKey)
+		digest := sha256.Sum256([]byte(input))
+		return ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil
+	case ES256:
+		pub, ok := k.Public.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		digest := sha256.Sum256([]byte(input))
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+	}
+	return false
+}
+
+// KeySet provides the keys to sign and verify the tokens
+type KeySet interface {
+	// SigningKey returns the current key to sign the new tokens
+	SigningKey(ctx context.Context) (*Key, error)
+	// VerifyingKey returns the key of the kid and the alg in the token header, kid may be empty
+	VerifyingKey(ctx context.Context, kid string, alg Algorithm) (*Key, error)
+}
+
+type staticKeys []*Key
+
+// StaticKeys is a KeySet of the fixed keys, the first one signs the tokens, and all of them verify
+func StaticKeys(keys ...*Key) KeySet {
+	return staticKeys(keys)
+}
+
+func (keys staticKeys) SigningKey(ctx context.Context) (*Key, error) {
+	if len(keys) == 0 || !keys[0].CanSign() {
+		return nil, ErrNoSigningKey
+	}
+	return keys[0], nil
+}
+
+func (keys staticKeys) VerifyingKey(ctx context.Context, kid string, alg Algorithm) (*Key, error) {
+	return findKey(keys, kid, alg)
+}
+
+func findKey(keys []*Key, kid string, alg Algorithm) (*Key, error) {
+	for _, key := range keys {
+		if key.Alg == alg && (kid == "" || key.ID == kid) {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: kid %q alg %s", ErrUnknownKey, kid, alg)
+}
+
+// secretKeys loads the keys from a secrets provider
+type secretKeys struct {
+	provider secrets.Provider
+	alg      Algorithm
+	names    []string
+
+	mu     sync.Mutex
+	parsed map[string]*Key // secret value -> key
+}
+
+// SecretKeys loads the keys of alg from the secrets provider, current is the name of the secret signing
+// the new tokens, and previous are the names of the rotated secrets, which still verify the issued tokens
+// until they expire. The missing previous secrets are ignored.
+//
+// The secrets are loaded on every use, so wrap the provider by secrets.NewCache, and the rotations
+// are picked up without restarts.
+func SecretKeys(p secrets.Provider, alg Algorithm, current string, previous ...string) KeySet {
+	return &secretKeys{
+		provider: p,
+		alg:      alg,
+		names:    append([]string{current}, previous...),
+		parsed:   map[string]*Key{},
+	}
+}
+
+func (s *secretKeys) load(ctx context.Context, name string) (*Key, error) {
+	secret, err := s.provider.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	value := secret.Reveal()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.parsed[value]; ok {
+		return key, nil
+	}
+	key, err := ParseKey(s.alg, []byte(value))
+	if err != nil {
+		return nil, fmt.Errorf("parse secret %s failed: %s", name, err)
+	}
+	// the stale keys of the past rotations are dropped
+	if len(s.parsed) >= 4*len(s.names) {
+		s.parsed = map[string]*Key{}
+	}
+	s.parsed[value] = key
+	return key, nil
+}
+
+func (s *secretKeys) SigningKey(ctx context.Context) (*Key, error) {
+	key, err := s.load(ctx, s.names[0])
+	if err != nil {
+		return nil, err
+	}
+	if !key.CanSign() {
+		return nil, ErrNoSigningKey
+	}
+	return key, nil
+}
+
+func (s *secretKeys) VerifyingKey(ctx context.Context, kid string, alg Algorithm) (*Key, error) {
+	keys := make([]*Key, 0, len(s.names))
+	for i, name := range s.names {
+		key, err := s.load(ctx, name)
+		if err != nil {
+			if i > 0 && utilerrors.IsNotFoundError(err) {
+				continue
+			}
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return findKey(keys, kid, alg)
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !openbsd
+// +build !linux,!darwin,!freebsd,!openbsd
+
+package loadshed
+
+import "time"
+
+func cpuTime() time.Duration {
+	return 0
+}
@@ -0,0 +1,234 @@
+// Package loadshed rejects the low priority requests when the system is under pressure,
+// like the high cpu usage, too many goroutines or the deep queues
+package loadshed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/middleware"
+	"github.com/tools-go/go-utils/priority"
+	"github.com/tools-go/go-utils/trace"
+)
+
+// Gauge is a measure of the pressure, the system is overloaded when Value exceeds Threshold
+type Gauge struct {
+	Name      string
+	Value     func() float64
+	Threshold float64
+}
+
+// GoroutineGauge measures the number of goroutines
+func GoroutineGauge(threshold int) Gauge {
+	return Gauge{
+		Name:      "goroutines",
+		Value:     func() float64 { return float64(runtime.NumGoroutine()) },
+		Threshold: float64(threshold),
+	}
+}
+
+// QueueGauge measures the depth of a queue, like the pending items of a writer or a worker pool
+func QueueGauge(name string, depth func() int, threshold int) Gauge {
+	return Gauge{
+		Name:      name,
+		Value:     func() float64 { return float64(depth()) },
+		Threshold: float64(threshold),
+	}
+}
+
+// CPUGauge measures the cpu usage of the process since the last sample, 1 means all the cpus are busy,
+// it's always 0 on the platforms without getrusage
+func CPUGauge(threshold float64) Gauge {
+	var lastCPU time.Duration
+	var lastTime time.Time
+	return Gauge{
+		Name: "cpu",
+		Value: func() float64 {
+			now, used := time.Now(), cpuTime()
+			defer func() { lastCPU, lastTime = used, now }()
+			if lastTime.IsZero() || !now.After(lastTime) {
+				return 0
+			}
+			return float64(used-lastCPU) / float64(now.Sub(lastTime)) / float64(runtime.NumCPU())
+		},
+		Threshold: threshold,
+	}
+}
+
+// Config of the Shedder
+type Config struct {
+	// Gauges are sampled every Interval, the pressure is the max ratio of the values to the thresholds
+	Gauges []Gauge
+	// Priority returns the priority of the request, the default is the one stored in the context
+	// by the priority middleware, the critical requests are never shed
+	Priority func(r *http.Request) priority.Priority
+	// Levels are the pressures to shed the background and the normal requests, the default is 1 and 1.25
+	Levels []float64
+	// Interval to sample the gauges and log the shed counts, the default is 1s
+	Interval time.Duration
+	// RetryAfter is replied to the shed requests, the default is 1s
+	RetryAfter time.Duration
+}
+
+// Stats of the Shedder
+type Stats struct {
+	Pressure float64
+	Gauges   map[string]float64
+	// Shed is the count of the shed requests by priority
+	Shed map[priority.Priority]int64
+}
+
+// Shedder decides whether to shed the requests by the pressure
+type Shedder struct {
+	cfg Config
+
+	pressure atomic.Value // float64
+	shed     [priority.Critical]int64
+
+	mu     sync.Mutex
+	gauges map[string]float64
+}
+
+// New creates a Shedder, the gauges are sampled until ctx is done
+func New(ctx context.Context, cfg Config) *Shedder {
+	if len(cfg.Levels) == 0 {
+		cfg.Levels = []float64{1, 1.25}
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = time.Second
+	}
+	s := &Shedder{cfg: cfg, gauges: map[string]float64{}}
+	s.pressure.Store(float64(0))
+	s.sample()
+	go s.run(ctx)
+	return s
+}
+
+func (s *Shedder) sample() {
+	pressure := 0.0
+	values := make(map[string]float64, len(s.cfg.Gauges))
+	for _, g := range s.cfg.Gauges {
+		v := g.Value()
+		values[g.Name] = v
+		if g.Threshold > 0 {
+			pressure = math.Max(pressure, v/g.Threshold)
+		}
+	}
+	s.mu.Lock()
+	s.gauges = values
+	s.mu.Unlock()
+	s.pressure.Store(pressure)
+}
+
+func (s *Shedder) run(ctx context.Context) {
+	tracer := trace.GetTraceFromContext(ctx)
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	var last [priority.Critical]int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.sample()
+
+		var counts []string
+		for p := range last {
+			n := atomic.LoadInt64(&s.shed[p])
+			if n > last[p] {
+				counts = append(counts, fmt.Sprintf("%s=%d", priority.Priority(p), n-last[p]))
+			}
+			last[p] = n
+		}
+		if len(counts) > 0 {
+			tracer.Warnf("event=[load-shed] shed=[%s] pressure=[%.2f] gauges=[%s]",
+				strings.Join(counts, " "), s.Pressure(), s.gaugesString())
+		}
+	}
+}
+
+func (s *Shedder) gaugesString() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]string, 0, len(s.gauges))
+	for name, v := range s.gauges {
+		items = append(items, fmt.Sprintf("%s=%.2f", name, v))
+	}
+	sort.Strings(items)
+	return strings.Join(items, " ")
+}
+
+// Pressure returns the pressure of the last sample
+func (s *Shedder) Pressure() float64 {
+	return s.pressure.Load().(float64)
+}
+
+// Allow reports whether to handle the request, the shed ones are counted
+func (s *Shedder) Allow(r *http.Request) bool {
+	p := priority.FromContext(r.Context())
+	if s.cfg.Priority != nil {
+		p = s.cfg.Priority(r)
+	}
+	if p < priority.Background {
+		p = priority.Background
+	}
+	if p >= priority.Critical || int(p) >= len(s.cfg.Levels) || s.Pressure() < s.cfg.Levels[p] {
+		return true
+	}
+	atomic.AddInt64(&s.shed[p], 1)
+	return false
+}
+
+// RetryAfter returns the value of the Retry-After header replied to the shed requests
+func (s *Shedder) RetryAfter() string {
+	return strconv.Itoa(int(math.Ceil(s.cfg.RetryAfter.Seconds())))
+}
+
+// Stats returns the current stats
+func (s *Shedder) Stats() Stats {
+	s.mu.Lock()
+	gauges := make(map[string]float64, len(s.gauges))
+	for k, v := range s.gauges {
+		gauges[k] = v
+	}
+	s.mu.Unlock()
+	shed := map[priority.Priority]int64{}
+	for p := range s.shed {
+		shed[priority.Priority(p)] = atomic.LoadInt64(&s.shed[p])
+	}
+	return Stats{Pressure: s.Pressure(), Gauges: gauges, Shed: shed}
+}
+
+// Overloaded is the body replied to the shed requests
+var Overloaded = errors.Error{Code: http.StatusServiceUnavailable, Msg: "server overloaded, retry later"}
+
+// Middleware replies 503 with Retry-After to the shed requests
+func (s *Shedder) Middleware() middleware.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !s.Allow(r) {
+				w.Header().Set("Retry-After", s.RetryAfter())
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(Overloaded)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package loadshed
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tools-go/go-utils/priority"
+)
+
+func TestShedder(t *testing.T) {
+	var depth int64
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, Config{
+		Gauges: []Gauge{
+			QueueGauge("writer", func() int { return int(atomic.LoadInt64(&depth)) }, 100),
+			GoroutineGauge(1 << 20),
+			CPUGauge(1),
+		},
+		Interval:   10 * time.Millisecond,
+		RetryAfter: 1500 * time.Millisecond,
+	})
+	cl := priority.NewClassifier(
+		priority.Route{Path: "/healthz", Priority: priority.Critical},
+		priority.Route{Path: "/prefetch", Priority: priority.Background},
+	)
+	h := cl.Middleware().HandlerFunc(s.Middleware().HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	status := func(path string) int {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code == http.StatusServiceUnavailable && w.Header().Get("Retry-After") != "2" {
+			t.Fatalf("unexpected Retry-After %q", w.Header().Get("Retry-After"))
+		}
+		return w.Code
+	}
+	waitFor := func(pressure float64) {
+		for i := 0; i < 100; i++ {
+			if math.Abs(s.Pressure()-pressure) < 0.01 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expect pressure %v, got %v", pressure, s.Pressure())
+	}
+
+	for _, c := range []struct {
+		depth    int64
+		statuses map[string]int
+	}{
+		{50, map[string]int{"/prefetch": 200, "/": 200, "/healthz": 200}},
+		{110, map[string]int{"/prefetch": 503, "/": 200, "/healthz": 200}},
+		{200, map[string]int{"/prefetch": 503, "/": 503, "/healthz": 200}},
+	} {
+		atomic.StoreInt64(&depth, c.depth)
+		waitFor(float64(c.depth) / 100)
+		for path, expect := range c.statuses {
+			if code := status(path); code != expect {
+				t.Fatalf("depth %d %s: expect %d, got %d", c.depth, path, expect, code)
+			}
+		}
+	}
+
+	stats := s.Stats()
+	if stats.Shed[priority.Background] != 2 || stats.Shed[priority.Normal] != 1 || stats.Gauges["writer"] != 200 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
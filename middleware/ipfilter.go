@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/leopoldxx/go-utils/errors"
+	"github.com/leopoldxx/go-utils/trace"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// IPFilterConfig is the rules of IPFilter, the entries are CIDRs like "10.0.0.0/8" or single IPs
+type IPFilterConfig struct {
+	// Allow are the allowed clients, all the clients not denied are allowed if it is empty
+	Allow []string `json:"allow" yaml:"allow"`
+	// Deny are the denied clients, which take precedence over Allow
+	Deny []string `json:"deny" yaml:"deny"`
+	// TrustedProxies are the proxies whose X-Real-IP and X-Forwarded-For headers are trusted,
+	// the headers of the other peers are ignored, as they can be forged to bypass the rules
+	TrustedProxies []string `json:"trustedProxies" yaml:"trustedProxies"`
+}
+
+type ipRules struct {
+	allow, deny, proxies []*net.IPNet
+}
+
+// IPFilter allows or denies the clients by their IPs, the rules can be updated at runtime
+type IPFilter struct {
+	rules atomic.Value // *ipRules
+}
+
+// NewIPFilter creates an IPFilter of the rules
+func NewIPFilter(cfg IPFilterConfig) (*IPFilter, error) {
+	f := &IPFilter{}
+	if err := f.Update(cfg); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Update replaces the rules atomically, the old rules are kept if the new ones are invalid
+func (f *IPFilter) Update(cfg IPFilterConfig) error {
+	rules := &ipRules{}
+	var err error
+	if rules.allow, err = parseCIDRs(cfg.Allow); err != nil {
+		return err
+	}
+	if rules.deny, err = parseCIDRs(cfg.Deny); err != nil {
+		return err
+	}
+	if rules.proxies, err = parseCIDRs(cfg.TrustedProxies); err != nil {
+		return err
+	}
+	f.rules.Store(rules)
+	return nil
+}
+
+func parseCIDRs(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", entry)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q", entry)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func matchCIDRs(nets []*net.IPNet, ip net.IP) string {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return n.String()
+		}
+	}
+	return ""
+}
+
+// Check reports whether ip is allowed, and the matched rule if it's denied
+func (f *IPFilter) Check(ip net.IP) (bool, string) {
+	rules := f.rules.Load().(*ipRules)
+	if ip == nil {
+		return false, "invalid ip"
+	}
+	if rule := matchCIDRs(rules.deny, ip); rule != "" {
+		return false, "deny " + rule
+	}
+	if len(rules.allow) > 0 && matchCIDRs(rules.allow, ip) == "" {
+		return false, "not allowed"
+	}
+	return true, ""
+}
+
+// ClientIP returns the IP of the client, the real IP headers are used only if the peer is a trusted proxy,
+// and the X-Forwarded-For is walked from the right, skipping the trusted proxies
+func (f *IPFilter) ClientIP(r *http.Request) net.IP {
+	rules := f.rules.Load().(*ipRules)
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer == nil || matchCIDRs(rules.proxies, peer) == "" {
+		return peer
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		if matchCIDRs(rules.proxies, ip) == "" {
+			return ip
+		}
+		peer = ip
+	}
+	return peer
+}
+
+// Middleware rejects the requests of the denied clients with 403, and logs the rejections for the audit
+func (f *IPFilter) Middleware() Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ip := f.ClientIP(r)
+			if ok, rule := f.Check(ip); !ok {
+				trace.GetTraceFromRequest(r).Warnf("event=[ip-rejected] ip=[%s] remote=[%s] rule=[%s] method=[%s] url=[%s]",
+					ip, r.RemoteAddr, rule, r.Method, r.URL.String())
+				e := errors.ErrSwitch(errors.NewForbiddenError("ip not allowed"))
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(e.Code)
+				json.NewEncoder(w).Encode(e)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// LoadFile loads the rules from a json or yaml file
+func (f *IPFilter) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg IPFilterConfig
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		err = yaml.Unmarshal(data, &cfg)
+	} else {
+		err = json.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("parse %s failed: %s", path, err)
+	}
+	return f.Update(cfg)
+}
+
+// WatchFile loads the rules from the file, and reloads them when it's modified, it checks every interval
+// until ctx is done. The invalid rules are logged and ignored, the current ones are kept.
+func (f *IPFilter) WatchFile(ctx context.Context, path string, interval time.Duration) {
+	tracer := trace.GetTraceFromContext(ctx)
+	var modified time.Time
+	if info, err := os.Stat(path); err == nil {
+		modified = info.ModTime()
+	}
+	if err := f.LoadFile(path); err != nil {
+		tracer.Errorf("load ip rules failed: %s", err)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().After(modified) {
+			continue
+		}
+		modified = info.ModTime()
+		if err := f.LoadFile(path); err != nil {
+			tracer.Errorf("reload ip rules failed: %s", err)
+			continue
+		}
+		tracer.Infof("ip rules reloaded from %s", path)
+	}
+}
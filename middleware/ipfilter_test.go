@@ -0,0 +1,104 @@
+package middleware_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/leopoldxx/go-utils/middleware"
+)
+
+func TestIPFilter(t *testing.T) {
+	f, err := NewIPFilter(IPFilterConfig{
+		Allow:          []string{"10.0.0.0/8", "192.168.1.10", "2001:db8::/32"},
+		Deny:           []string{"10.0.1.0/24"},
+		TrustedProxies: []string{"172.16.0.0/12"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, allowed := range map[string]bool{
+		"10.1.2.3":      true,
+		"10.0.1.5":      false,
+		"192.168.1.10":  true,
+		"192.168.1.11":  false,
+		"2001:db8::1":   true,
+		"::ffff:10.1.1": false,
+		"8.8.8.8":       false,
+	} {
+		if ok, _ := f.Check(net.ParseIP(ip)); ok != allowed {
+			t.Fatalf("%s: expect %v", ip, allowed)
+		}
+	}
+
+	h := f.Middleware().HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, c := range []struct {
+		remote, realIP, forwarded string
+		status                    int
+	}{
+		{"10.1.2.3:1234", "", "", http.StatusOK},
+		{"8.8.8.8:1234", "", "", http.StatusForbidden},
+		// the headers of the untrusted peers are ignored
+		{"8.8.8.8:1234", "10.1.2.3", "10.1.2.3", http.StatusForbidden},
+		{"172.16.0.1:1234", "10.1.2.3", "", http.StatusOK},
+		{"172.16.0.1:1234", "", "10.1.2.3, 172.16.0.2", http.StatusOK},
+		// the leftmost hops are forged by the client
+		{"172.16.0.1:1234", "", "10.1.2.3, 8.8.8.8, 172.16.0.2", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		req.RemoteAddr = c.remote
+		if c.realIP != "" {
+			req.Header.Set("X-Real-IP", c.realIP)
+		}
+		if c.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		w := httptest.NewRecorder()
+		h(w, req)
+		if w.Code != c.status {
+			t.Fatalf("%+v: unexpected status %d", c, w.Code)
+		}
+	}
+
+	if err := f.Update(IPFilterConfig{Allow: []string{"10.0.0.0/33"}}); err == nil {
+		t.Fatal("expect invalid cidr")
+	}
+	if ok, _ := f.Check(net.ParseIP("10.0.1.5")); ok {
+		t.Fatal("expect the old rules kept")
+	}
+}
+
+func TestIPFilterWatchFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ipfilter")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rules.yaml")
+	ioutil.WriteFile(path, []byte("allow: [10.0.0.0/8]\n"), 0644)
+
+	f, _ := NewIPFilter(IPFilterConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.WatchFile(ctx, path, 10*time.Millisecond)
+
+	ip := net.ParseIP("192.168.1.1")
+	waitFor := func(allowed bool) {
+		for i := 0; i < 100; i++ {
+			if ok, _ := f.Check(ip); ok == allowed {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expect %s allowed %v", ip, allowed)
+	}
+	waitFor(false)
+	ioutil.WriteFile(path, []byte("allow: [10.0.0.0/8, 192.168.0.0/16]\n"), 0644)
+	os.Chtimes(path, time.Now().Add(time.Second), time.Now().Add(time.Second))
+	waitFor(true)
+}
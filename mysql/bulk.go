@@ -0,0 +1,220 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VividCortex/mysqlerr"
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/leopoldxx/go-utils/errors"
+	"github.com/leopoldxx/go-utils/retry"
+	"github.com/leopoldxx/go-utils/trace"
+)
+
+const (
+	// DefaultMaxPacketSize is the default max_allowed_packet of MySQL 5.7,
+	// bulk statements are chunked to stay under it
+	DefaultMaxPacketSize = 4 << 20
+	// reserve some bytes for the protocol header and the estimation error
+	packetSizeReserved = 1 << 10
+)
+
+// BatchStats is the statistics of a bulk operation batch
+type BatchStats struct {
+	Table    string
+	Op       string // insert or upsert
+	Batch    int
+	Rows     int
+	Bytes    int
+	Affected int64
+	Attempts int
+	Duration time.Duration
+	Err      error
+}
+
+// BatchObserver will be called after each batch finished, it can be used for metrics
+type BatchObserver func(ctx context.Context, stats BatchStats)
+
+// WithMaxPacketSize set the max_allowed_packet of the server for bulk operations
+func WithMaxPacketSize(size int) Option {
+	return func(opts *options) {
+		opts.maxPacketSize = size
+	}
+}
+
+// WithBatchRetry retry a failed batch on retriable errors(deadlock, lock wait timeout, bad conn),
+// attempts is the total times of a batch to be executed, retry is disabled inside a transaction.
+// The invalid conn error is retried only for Upsert and the queries, a BulkInsert batch may have
+// been applied by the server before the conn broke.
+func WithBatchRetry(attempts int, interval time.Duration) Option {
+	return func(opts *options) {
+		opts.batchAttempts = attempts
+		opts.batchInterval = interval
+	}
+}
+
+// WithBatchObserver set the observer of the bulk operation batches
+func WithBatchObserver(observer BatchObserver) Option {
+	return func(opts *options) {
+		opts.batchObserver = observer
+	}
+}
+
+// BulkInsert inserts rows into table by multi-row INSERT statements, each statement has at most
+// batchSize rows(batchSize <= 0 means no limit), and is chunked to stay under max_allowed_packet
+func BulkInsert(ctx context.Context, db Execer, table string, fields []Field, rows [][]Value, batchSize int, ops ...Option) (int64, error) {
+	return bulkExec(ctx, db, "insert", table, fields, rows, "", batchSize, ops...)
+}
+
+// Upsert is like BulkInsert, but updates updateFields of the existing rows on duplicate keys
+// by ON DUPLICATE KEY UPDATE, all the fields will be updated if updateFields is empty.
+// As MySQL does, the affected count of an updated row is 2.
+func Upsert(ctx context.Context, db Execer, table string, fields []Field, rows [][]Value, updateFields []Field, batchSize int, ops ...Option) (int64, error) {
+	if len(updateFields) == 0 {
+		updateFields = fields
+	}
+	updates := make([]string, 0, len(updateFields))
+	for _, f := range updateFields {
+		updates = append(updates, fmt.Sprintf("%s=VALUES(%s)", string(f), string(f)))
+	}
+	suffix := " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ",")
+	return bulkExec(ctx, db, "upsert", table, fields, rows, suffix, batchSize, ops...)
+}
+
+func bulkExec(ctx context.Context, db Execer, op string, table string, fields []Field, rows [][]Value, suffix string, batchSize int, ops ...Option) (int64, error) {
+	opts := &options{
+		maxPacketSize: DefaultMaxPacketSize,
+		batchAttempts: 1,
+	}
+	for _, o := range ops {
+		o(opts)
+	}
+	if db == nil {
+		return 0, errors.NewBadRequestError("invalid db handler")
+	}
+	if len(fields) == 0 {
+		return 0, errors.NewBadRequestError("no fields to insert")
+	}
+	if _, ok := db.(*sqlx.Tx); ok || opts.batchAttempts < 1 {
+		opts.batchAttempts = 1
+	}
+	// check all the rows before any statement, so a bad row never leaves a partial write
+	for i, row := range rows {
+		if len(row) != len(fields) {
+			return 0, errors.NewBadRequestError(fmt.Sprintf("row #%d has %d values, expect %d", i, len(row), len(fields)))
+		}
+	}
+	tracer := trace.GetTraceFromContext(ctx)
+
+	cols := make([]string, 0, len(fields))
+	for _, f := range fields {
+		cols = append(cols, string(f))
+	}
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", table, strings.Join(cols, ","))
+	holder := "(" + strings.TrimSuffix(strings.Repeat("?,", len(fields)), ",") + ")"
+
+	maxRows := maxPlaceHolder / len(fields)
+	if batchSize > 0 && batchSize < maxRows {
+		maxRows = batchSize
+	}
+
+	var total int64
+	for batch := 0; len(rows) > 0; batch++ {
+		size := len(prefix) + len(suffix) + len(opts.extra)
+		n := 0
+		for ; n < len(rows) && n < maxRows; n++ {
+			rowSize := len(holder) + 1 + estimateRowSize(rows[n])
+			if n > 0 && size+rowSize > opts.maxPacketSize-packetSizeReserved {
+				break
+			}
+			size += rowSize
+		}
+
+		holders := make([]string, n)
+		args := make([]interface{}, 0, n*len(fields))
+		for i := 0; i < n; i++ {
+			holders[i] = holder
+			for _, v := range rows[i] {
+				args = append(args, v)
+			}
+		}
+		query := prefix + strings.Join(holders, ",") + suffix
+		if len(opts.extra) > 0 {
+			query = query + " " + opts.extra
+		}
+
+		stats := BatchStats{Table: table, Op: op, Batch: batch, Rows: n, Bytes: size}
+		start := time.Now()
+		var lastErr error
+		err := retry.Do(opts.batchAttempts, func() error {
+			stats.Attempts++
+			result, err := db.ExecContext(ctx, query, args...)
+			lastErr = err
+			if err != nil {
+				if stats.Attempts < opts.batchAttempts && isRetriableError(err, op == "upsert") {
+					tracer.Warnf("%s table %s batch #%d failed, will retry: %s", op, table, batch, err)
+					return retry.NewRetriableError(err.Error())
+				}
+				return err
+			}
+			stats.Affected, _ = result.RowsAffected()
+			return nil
+		}, opts.batchInterval)
+		stats.Duration = time.Since(start)
+		stats.Err = lastErr
+		RecordQuery(ctx, query, stats.Affected, start)
+		if opts.batchObserver != nil {
+			opts.batchObserver(ctx, stats)
+		}
+		if err != nil {
+			tracer.Errorf("%s table %s batch #%d(%d rows) failed: %s", op, table, batch, n, err)
+			return total, processErrors(lastErr)
+		}
+		tracer.Infof("%s table %s batch #%d, %d rows, %d bytes, %d affected, cost %v", op, table, batch, n, size, stats.Affected, stats.Duration)
+		total += stats.Affected
+		rows = rows[n:]
+	}
+	return total, nil
+}
+
+// estimateRowSize estimates the bytes of the row values sent by the text protocol
+func estimateRowSize(row []Value) int {
+	size := 0
+	for _, v := range row {
+		switch x := v.(type) {
+		case nil:
+			size += 4
+		case string:
+			// quotes and possible escapes
+			size += len(x) + len(x)/8 + 2
+		case []byte:
+			size += len(x) + len(x)/8 + 2
+		case time.Time:
+			size += 28
+		default:
+			size += 21
+		}
+		size++
+	}
+	return size
+}
+
+// isRetriableError tells if the statement failed with err can be executed again, the idempotent ones
+// are retried on the invalid conn too, which may break after the statement is applied
+func isRetriableError(err error, idempotent bool) bool {
+	// the driver returns ErrBadConn only if nothing is sent to the server
+	if err == driver.ErrBadConn || (idempotent && err == gomysql.ErrInvalidConn) {
+		return true
+	}
+	if driverErr, ok := err.(*gomysql.MySQLError); ok {
+		switch driverErr.Number {
+		case mysqlerr.ER_LOCK_DEADLOCK, mysqlerr.ER_LOCK_WAIT_TIMEOUT:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package mysql_test
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/leopoldxx/go-utils/errors"
+	"github.com/leopoldxx/go-utils/mysql"
+	"github.com/leopoldxx/go-utils/mysql/testkit"
+)
+
+func TestBulkInsert(t *testing.T) {
+	ctx := context.TODO()
+	db, store := testkit.NewDB()
+
+	var rows [][]mysql.Value
+	for i := 0; i < 25; i++ {
+		rows = append(rows, []mysql.Value{i + 1, "name", i})
+	}
+
+	var batches []mysql.BatchStats
+	observer := func(ctx context.Context, stats mysql.BatchStats) {
+		batches = append(batches, stats)
+	}
+	n, err := mysql.BulkInsert(ctx, db, "users", []mysql.Field{"id", "name", "age"}, rows, 10, mysql.WithBatchObserver(observer))
+	if err != nil || n != 25 {
+		t.Fatalf("bulk insert failed: %d, %v", n, err)
+	}
+	if len(batches) != 3 || batches[2].Rows != 5 || batches[0].Attempts != 1 {
+		t.Fatalf("unexpected batches: %+v", batches)
+	}
+	if len(store.Rows("users")) != 25 {
+		t.Fatal("unexpected rows count")
+	}
+
+	// a small packet size forces one row per statement
+	batches = nil
+	_, err = mysql.BulkInsert(ctx, db, "logs", []mysql.Field{"msg"}, [][]mysql.Value{{"a"}, {"b"}, {"c"}}, 0,
+		mysql.WithMaxPacketSize(1), mysql.WithBatchObserver(observer))
+	if err != nil || len(batches) != 3 {
+		t.Fatalf("expect 3 batches, got %d, %v", len(batches), err)
+	}
+
+	_, err = mysql.BulkInsert(ctx, db, "users", []mysql.Field{"id", "name", "age"}, rows[:1], 10)
+	if !errors.IsConflictError(err) {
+		t.Fatalf("expect conflict error, got %v", err)
+	}
+
+	// a bad row in a later batch is rejected before any batch is written
+	bad := [][]mysql.Value{{"a"}, {"b"}, {"c", "d"}}
+	_, err = mysql.BulkInsert(ctx, db, "events", []mysql.Field{"msg"}, bad, 2)
+	if !errors.IsBadRequestError(err) || !strings.Contains(err.Error(), "row #2 ") {
+		t.Fatalf("expect row #2 rejected, got %v", err)
+	}
+	if rows := store.Rows("events"); len(rows) != 0 {
+		t.Fatalf("expect nothing written, got %v", rows)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	ctx := context.TODO()
+	db, store := testkit.NewDB()
+
+	fields := []mysql.Field{"id", "name", "age"}
+	_, err := mysql.BulkInsert(ctx, db, "users", fields, [][]mysql.Value{{1, "foo", 10}, {2, "bar", 20}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := mysql.Upsert(ctx, db, "users", fields, [][]mysql.Value{{2, "bar2", 21}, {3, "baz", 30}}, []mysql.Field{"age"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 for the updated row, 1 for the inserted one
+	if n != 3 {
+		t.Fatalf("unexpected affected rows: %d", n)
+	}
+	rows := store.Rows("users")
+	if len(rows) != 3 || rows[1]["name"] != "bar" || rows[1]["age"] != int64(21) {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+}
+
+// brokenConn fails the first statement with the invalid conn after it's applied
+type brokenConn struct {
+	mysql.Execer
+	broken bool
+}
+
+func (c *brokenConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	result, err := c.Execer.ExecContext(ctx, query, args...)
+	if err == nil && !c.broken {
+		c.broken = true
+		return nil, gomysql.ErrInvalidConn
+	}
+	return result, err
+}
+
+func TestBatchRetryInvalidConn(t *testing.T) {
+	ctx := context.TODO()
+	db, store := testkit.NewDB()
+
+	var batches []mysql.BatchStats
+	observer := func(ctx context.Context, stats mysql.BatchStats) {
+		batches = append(batches, stats)
+	}
+	fields := []mysql.Field{"id", "name", "age"}
+	// the insert may be applied already, so it's not retried
+	_, err := mysql.BulkInsert(ctx, &brokenConn{Execer: db}, "users", fields, [][]mysql.Value{{1, "foo", 10}}, 0,
+		mysql.WithBatchRetry(3, time.Millisecond), mysql.WithBatchObserver(observer))
+	if err == nil || len(batches) != 1 || batches[0].Attempts != 1 {
+		t.Fatalf("expect the insert failed without retry, got %v, %+v", err, batches)
+	}
+
+	batches = nil
+	_, err = mysql.Upsert(ctx, &brokenConn{Execer: db}, "users", fields, [][]mysql.Value{{1, "bar", 20}}, nil, 0,
+		mysql.WithBatchRetry(3, time.Millisecond), mysql.WithBatchObserver(observer))
+	if err != nil || len(batches) != 1 || batches[0].Attempts != 2 {
+		t.Fatalf("expect the upsert retried, got %v, %+v", err, batches)
+	}
+	if rows := store.Rows("users"); len(rows) != 1 || rows[0]["name"] != "bar" {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+}
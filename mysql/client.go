@@ -27,6 +27,16 @@ type options struct {
 	maxIdleConnsCount int
 	// for operation
 	extra string
+	// for bulk operations
+	maxPacketSize int
+	batchAttempts int
+	batchInterval time.Duration
+	batchObserver BatchObserver
+	// for iterations
+	iterateKey       string
+	pageSize         int
+	pageInterval     time.Duration
+	progressInterval time.Duration
 }
 
 // Option for MySQL Client
@@ -55,6 +65,7 @@ func New(addr string, ops ...Option) (*Client, error) {
 	for _, op := range ops {
 		op(opts)
 	}
+	registerDSNPassword(addr)
 
 	db, err := sqlx.Open("mysql", addr)
 	if err != nil {
@@ -190,8 +201,9 @@ func formatSelectParameters(table string, rowFields []Field, whereClause []Where
 	}()), whereFieldsValue, nil
 }
 
-// SelectRows is a util function to select some rows from a table
-func SelectRows(ctx context.Context, db *sqlx.DB, tx *sqlx.Tx, table string, fields []Field, whereClause []WhereClause, result interface{}, ops ...Option) error {
+// SelectRows is a util function to select some rows from a table.
+// The helpers run the sql by db or tx, whichever is not nil, both can be any ExecQueryer like a mock.
+func SelectRows(ctx context.Context, db, tx ExecQueryer, table string, fields []Field, whereClause []WhereClause, result interface{}, ops ...Option) error {
 	opts := &options{}
 	for _, op := range ops {
 		op(opts)
@@ -206,13 +218,13 @@ func SelectRows(ctx context.Context, db *sqlx.DB, tx *sqlx.Tx, table string, fie
 		sqlTpl = sqlTpl + " " + opts.extra
 	}
 
-	if db != nil {
-		err = db.Select(result, sqlTpl, fieldsValue...)
-	} else if tx != nil {
-		err = tx.Select(result, sqlTpl, fieldsValue...)
-	} else {
+	handler := execQueryer(db, tx)
+	if handler == nil {
 		return errors.NewBadRequestError("invalid db handler")
 	}
+	start := time.Now()
+	err = handler.SelectContext(ctx, result, sqlTpl, fieldsValue...)
+	RecordQuery(ctx, sqlTpl, resultRows(result), start)
 
 	if err != nil {
 		if isNoRowsError(err) {
@@ -255,7 +267,7 @@ func formatInsertParameters(table string, rowFields []Field, rowValues [][]Value
 }
 
 // InsertRows is a util function to insert some rows into a table
-func InsertRows(ctx context.Context, db *sqlx.DB, tx *sqlx.Tx, table string, rowFields []Field, rowValues [][]Value, ops ...Option) (int64, error) {
+func InsertRows(ctx context.Context, db, tx ExecQueryer, table string, rowFields []Field, rowValues [][]Value, ops ...Option) (int64, error) {
 	var count int64
 
 	if len(rowValues) > 0 {
@@ -275,7 +287,7 @@ func InsertRows(ctx context.Context, db *sqlx.DB, tx *sqlx.Tx, table string, row
 	}
 	return count, nil
 }
-func insertRows(ctx context.Context, db *sqlx.DB, tx *sqlx.Tx, table string, rowFields []Field, rowValues [][]Value, ops ...Option) (int64, error) {
+func insertRows(ctx context.Context, db, tx ExecQueryer, table string, rowFields []Field, rowValues [][]Value, ops ...Option) (int64, error) {
 	opts := &options{}
 	for _, op := range ops {
 		op(opts)
@@ -290,19 +302,19 @@ func insertRows(ctx context.Context, db *sqlx.DB, tx *sqlx.Tx, table string, row
 		sqlTpl = sqlTpl + " " + opts.extra
 	}
 
-	var result sql.Result
-	if db != nil {
-		result, err = db.Exec(sqlTpl, fieldValues...)
-	} else if tx != nil {
-		result, err = tx.Exec(sqlTpl, fieldValues...)
-	} else {
+	handler := execQueryer(db, tx)
+	if handler == nil {
 		return 0, errors.NewBadRequestError("invalid db handler")
 	}
+	start := time.Now()
+	result, err := handler.ExecContext(ctx, sqlTpl, fieldValues...)
 	if err != nil {
+		RecordQuery(ctx, sqlTpl, 0, start)
 		tracer.Errorf("failed to insert table %s: %s", table, err)
 		return 0, processErrors(err)
 	}
 	num, _ := result.RowsAffected()
+	RecordQuery(ctx, sqlTpl, num, start)
 	tracer.Infof("insert table %s #%d rows successfully", table, num)
 	return num, nil
 }
@@ -327,7 +339,7 @@ func formatUpdateParameters(table string, values map[Field]Value, whereClause []
 }
 
 // UpdateRows is a util function to update rows values in a table
-func UpdateRows(ctx context.Context, db *sqlx.DB, tx *sqlx.Tx, table string, values map[Field]Value, whereClause []WhereClause) (int64, error) {
+func UpdateRows(ctx context.Context, db, tx ExecQueryer, table string, values map[Field]Value, whereClause []WhereClause) (int64, error) {
 	tracer := trace.GetTraceFromContext(ctx)
 	sqlTpl, fieldValues, err := formatUpdateParameters(table, values, whereClause)
 	if err != nil {
@@ -335,19 +347,19 @@ func UpdateRows(ctx context.Context, db *sqlx.DB, tx *sqlx.Tx, table string, val
 		return 0, err
 	}
 
-	var result sql.Result
-	if db != nil {
-		result, err = db.Exec(sqlTpl, fieldValues...)
-	} else if tx != nil {
-		result, err = tx.Exec(sqlTpl, fieldValues...)
-	} else {
+	handler := execQueryer(db, tx)
+	if handler == nil {
 		return 0, errors.NewBadRequestError("invalid db handler")
 	}
+	start := time.Now()
+	result, err := handler.ExecContext(ctx, sqlTpl, fieldValues...)
 	if err != nil {
+		RecordQuery(ctx, sqlTpl, 0, start)
 		tracer.Errorf("failed to update table %s: %s", table, err)
 		return 0, processErrors(err)
 	}
 	num, _ := result.RowsAffected()
+	RecordQuery(ctx, sqlTpl, num, start)
 	tracer.Infof("update table %s #%d rows successfully", table, num)
 	return num, nil
 }
@@ -365,7 +377,7 @@ func formatDeleteParameters(table string, whereClause []WhereClause) (string, []
 }
 
 // DeleteRows is a util function to delete rows from a table
-func DeleteRows(ctx context.Context, db *sqlx.DB, tx *sqlx.Tx, table string, whereClause []WhereClause, ops ...Option) (int64, error) {
+func DeleteRows(ctx context.Context, db, tx ExecQueryer, table string, whereClause []WhereClause, ops ...Option) (int64, error) {
 	opts := &options{}
 	for _, op := range ops {
 		op(opts)
@@ -381,23 +393,32 @@ func DeleteRows(ctx context.Context, db *sqlx.DB, tx *sqlx.Tx, table string, whe
 		sqlTpl = sqlTpl + " " + opts.extra
 	}
 
-	var result sql.Result
-	if db != nil {
-		result, err = db.Exec(sqlTpl, fieldValues...)
-	} else if tx != nil {
-		result, err = tx.Exec(sqlTpl, fieldValues...)
-	} else {
+	handler := execQueryer(db, tx)
+	if handler == nil {
 		return 0, errors.NewBadRequestError("invalid db handler")
 	}
+	start := time.Now()
+	result, err := handler.ExecContext(ctx, sqlTpl, fieldValues...)
 	if err != nil {
+		RecordQuery(ctx, sqlTpl, 0, start)
 		tracer.Errorf("failed to delete table %s: %s", table, err)
 		return 0, processErrors(err)
 	}
 	num, _ := result.RowsAffected()
+	RecordQuery(ctx, sqlTpl, num, start)
 	tracer.Infof("delete table %s #%d rows successfully", table, num)
 	return num, nil
 }
 
+// resultRows returns the rows selected into result, a pointer to a slice or a struct
+func resultRows(result interface{}) int64 {
+	v := reflect.Indirect(reflect.ValueOf(result))
+	if v.Kind() == reflect.Slice {
+		return int64(v.Len())
+	}
+	return 1
+}
+
 func processErrors(err error) error {
 	switch err {
 	case sql.ErrNoRows:
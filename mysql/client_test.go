@@ -3,6 +3,7 @@ package mysql_test
 import (
 	"testing"
 
+	"github.com/leopoldxx/go-utils/mysql"
 	"github.com/leopoldxx/go-utils/mysql/instance"
 )
 
@@ -13,3 +14,13 @@ func TestNewClient(t *testing.T) {
 	}
 
 }
+
+func TestRedactDSN(t *testing.T) {
+	dsn := mysql.RedactDSN("user:p@ss@tcp(127.0.0.1:3306)/test?parseTime=true")
+	if dsn != "user:******@tcp(127.0.0.1:3306)/test?parseTime=true" {
+		t.Fatalf("unexpected dsn: %s", dsn)
+	}
+	if dsn := mysql.RedactDSN("invalid"); dsn != "<invalid dsn>" {
+		t.Fatalf("unexpected dsn: %s", dsn)
+	}
+}
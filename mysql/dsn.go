@@ -0,0 +1,25 @@
+package mysql
+
+import (
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/tools-go/go-utils/secrets"
+)
+
+// RedactDSN returns the dsn with the password masked, which is safe to be logged
+func RedactDSN(dsn string) string {
+	cfg, err := gomysql.ParseDSN(dsn)
+	if err != nil {
+		return "<invalid dsn>"
+	}
+	if cfg.Passwd != "" {
+		cfg.Passwd = secrets.Mask
+	}
+	return cfg.FormatDSN()
+}
+
+// registerDSNPassword registers the password of the dsn for the redaction of secrets.Redact
+func registerDSNPassword(dsn string) {
+	if cfg, err := gomysql.ParseDSN(dsn); err == nil && cfg.Passwd != "" {
+		secrets.Register(cfg.Passwd)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/leopoldxx/go-utils/mysql"
+	"github.com/leopoldxx/go-utils/trace"
+)
+
+// CREATE TABLE demo_users (
+//
+//	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+//	name VARCHAR(64) NOT NULL UNIQUE,
+//	typ VARCHAR(16) NOT NULL
+//
+// );
+const table = "demo_users"
+
+type user struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+	Type string `db:"typ"`
+}
+
+func main() {
+	dsn := flag.String("dsn", "root:@tcp(127.0.0.1:3306)/demo?parseTime=true", "the dsn of the demo db")
+	maxConns := flag.Int("max-conns", 10, "the max open connections")
+	flag.Parse()
+
+	cli, err := mysql.New(*dsn, mysql.WithMaxConnsCount(*maxConns), mysql.WithMaxIdleConnsCount(*maxConns))
+	if err != nil {
+		log.Fatalf("connect %s failed: %s", mysql.RedactDSN(*dsn), err)
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ctx = trace.WithTraceForContext(ctx, "mysql-example")
+	tracer := trace.GetTraceFromContext(ctx)
+
+	fields := []mysql.Field{mysql.FieldName, mysql.FieldType}
+	rows := [][]mysql.Value{{"alice", "admin"}, {"bob", "guest"}, {"carol", "guest"}}
+	// the rows are split into the batches under max_allowed_packet, the existing names are updated
+	n, err := mysql.Upsert(ctx, cli.DB(), table, fields, rows, []mysql.Field{mysql.FieldType}, 2,
+		mysql.WithBatchObserver(func(ctx context.Context, stats mysql.BatchStats) {
+			tracer.Infof("batch=[%+v]", stats)
+		}))
+	if err != nil {
+		log.Fatalf("upsert failed: %s", err)
+	}
+	tracer.Infof("upserted=[%d]", n)
+
+	err = cli.RunInTx(ctx, func(tx mysql.ExecQueryer) error {
+		var guests []user
+		if err := tx.SelectContext(ctx, &guests, "SELECT id, name, typ FROM "+table+" WHERE typ = ?", "guest"); err != nil {
+			return err
+		}
+		for _, u := range guests {
+			if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET typ = ? WHERE id = ?", "member", u.ID); err != nil {
+				return err
+			}
+		}
+		tracer.Infof("promoted=[%d]", len(guests))
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("promote the guests failed: %s", err)
+	}
+}
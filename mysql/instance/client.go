@@ -1,15 +1,18 @@
 package instance
 
 import (
+	"context"
+	"fmt"
 	"sync"
 
 	"github.com/leopoldxx/go-utils/mysql"
 	"github.com/spf13/viper"
+	"github.com/tools-go/go-utils/secrets"
 )
 
 /*
 [mysql]
-	connection = "test:test@tcp(127.0.0.1:3306)/test?charset=utf8&parseTime=true&loc=Asia%2FShanghai"
+	connection = "test:${secret:mysql_password}@tcp(127.0.0.1:3306)/test?charset=utf8&parseTime=true&loc=Asia%2FShanghai"
 	maxConnsCount = 100
 	maxIdleConnsCount = 50
 */
@@ -35,14 +38,19 @@ func initDaoDefaultConfigs() {
 	viper.SetDefault(mysqlMaxIdleConns, 50)
 }
 
-// GetMySQLClient create a mysql backend storage Client
+// GetMySQLClient create a mysql backend storage Client,
+// the secret references like ${secret:mysql_password} in the connection are loaded by secrets.Default
 func GetMySQLClient() *mysql.Client {
 	mysqlOnce.Do(func() {
-		mysqlClient, _ = mysql.New(viper.GetString(mysqlConnection),
+		connection, err := secrets.Expand(context.TODO(), secrets.Default(), viper.GetString(mysqlConnection))
+		if err != nil {
+			panic(fmt.Sprintf("load mysql secrets failed: %s", err))
+		}
+		mysqlClient, err = mysql.New(connection,
 			mysql.WithMaxConnsCount(viper.GetInt(mysqlMaxConnsCount)),
 			mysql.WithMaxIdleConnsCount(viper.GetInt(mysqlMaxIdleConns)))
 		if mysqlClient == nil {
-			panic("connect mysql failed")
+			panic(fmt.Sprintf("connect mysql %s failed: %s", mysql.RedactDSN(connection), secrets.Redact(fmt.Sprint(err))))
 		}
 	})
 	return mysqlClient
@@ -0,0 +1,78 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Execer executes sql statements without returning rows, *sqlx.DB and *sqlx.Tx both implement it
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Queryer queries rows, *sqlx.DB and *sqlx.Tx both implement it
+type Queryer interface {
+	Queryx(query string, args ...interface{}) (*sqlx.Rows, error)
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error)
+	QueryRowx(query string, args ...interface{}) *sqlx.Row
+	QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row
+	Select(dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	Get(dest interface{}, query string, args ...interface{}) error
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+// ExecQueryer can both execute statements and query rows
+type ExecQueryer interface {
+	Execer
+	Queryer
+}
+
+// TxRunner runs a function inside a transaction, the transaction will be committed
+// if fn returns nil, otherwise it will be rollbacked
+type TxRunner interface {
+	RunInTx(ctx context.Context, fn func(tx ExecQueryer) error) error
+}
+
+var (
+	_ ExecQueryer = (*sqlx.DB)(nil)
+	_ ExecQueryer = (*sqlx.Tx)(nil)
+	_ TxRunner    = (*Client)(nil)
+)
+
+// RunInTx runs fn in a transaction of the client db
+func (cli *Client) RunInTx(ctx context.Context, fn func(tx ExecQueryer) error) error {
+	return TransactionHandler(ctx, cli.db, func(tx *sqlx.Tx) error {
+		return fn(tx)
+	})
+}
+
+// NewWithDB creates a Client with an opened db, it's useful for wrapping
+// a db created by other drivers, such as the in-memory one of the testkit
+func NewWithDB(db *sqlx.DB) *Client {
+	return &Client{db: db}
+}
+
+// execQueryer picks the valid db handler for the helpers, the nil *sqlx.DB and *sqlx.Tx
+// passed as the interface are skipped too
+func execQueryer(db, tx ExecQueryer) ExecQueryer {
+	for _, handler := range []ExecQueryer{db, tx} {
+		switch h := handler.(type) {
+		case nil:
+			continue
+		case *sqlx.DB:
+			if h == nil {
+				continue
+			}
+		case *sqlx.Tx:
+			if h == nil {
+				continue
+			}
+		}
+		return handler
+	}
+	return nil
+}
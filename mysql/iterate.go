@@ -0,0 +1,231 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/leopoldxx/go-utils/errors"
+	"github.com/leopoldxx/go-utils/trace"
+)
+
+// default values for IterateRows
+const (
+	DefaultIterateKey       = "id"
+	DefaultPageSize         = 1000
+	DefaultProgressInterval = 10 * time.Second
+)
+
+// WithIterateKey set the unique and indexed column used to page through the rows, default is "id"
+func WithIterateKey(column string) Option {
+	return func(opts *options) {
+		opts.iterateKey = column
+	}
+}
+
+// WithPageSize set the rows count of each page
+func WithPageSize(size int) Option {
+	return func(opts *options) {
+		opts.pageSize = size
+	}
+}
+
+// WithPageInterval set the pause between two pages, to reduce the load of the server
+func WithPageInterval(d time.Duration) Option {
+	return func(opts *options) {
+		opts.pageInterval = d
+	}
+}
+
+// WithProgressInterval set the interval of the progress logs
+func WithProgressInterval(d time.Duration) Option {
+	return func(opts *options) {
+		opts.progressInterval = d
+	}
+}
+
+// IterateRows pages through the large result set of query by the ranges of the iterate key,
+// so that only one page of rows is loaded at a time, fn will be called for each row.
+//
+// The query should be a simple SELECT without ORDER BY/LIMIT, like "SELECT id, name FROM users WHERE age > ?",
+// and the iterate key must be selected. Every page is queried by appending "key > ? ORDER BY key LIMIT n" to it,
+// the WHERE condition of the query is parenthesized first, so "WHERE a = 1 OR b = 2" pages
+// by "WHERE (a = 1 OR b = 2) AND key > ?".
+// A failed page query is retried with an exponential backoff if WithBatchRetry is set.
+func IterateRows(ctx context.Context, db Queryer, query string, args []interface{}, fn func(rows *sqlx.Rows) error, ops ...Option) error {
+	opts := &options{
+		iterateKey:       DefaultIterateKey,
+		pageSize:         DefaultPageSize,
+		progressInterval: DefaultProgressInterval,
+		batchAttempts:    1,
+	}
+	for _, op := range ops {
+		op(opts)
+	}
+	if db == nil {
+		return errors.NewBadRequestError("invalid db handler")
+	}
+	if opts.pageSize <= 0 {
+		opts.pageSize = DefaultPageSize
+	}
+	tracer := trace.GetTraceFromContext(ctx)
+
+	firstPage := fmt.Sprintf("%s ORDER BY %s LIMIT %d", query, opts.iterateKey, opts.pageSize)
+	nextPage := fmt.Sprintf("%s WHERE %s > ? ORDER BY %s LIMIT %d", query, opts.iterateKey, opts.iterateKey, opts.pageSize)
+	if idx := whereIndex(query); idx >= 0 {
+		nextPage = fmt.Sprintf("%s WHERE (%s) AND %s > ? ORDER BY %s LIMIT %d",
+			strings.TrimSpace(query[:idx]), strings.TrimSpace(query[idx+len("WHERE"):]), opts.iterateKey, opts.iterateKey, opts.pageSize)
+	}
+
+	var (
+		lastKey      interface{}
+		total, pages int
+		start        = time.Now()
+		lastProgress = start
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		pageQuery, pageArgs := firstPage, args
+		if lastKey != nil {
+			pageQuery = nextPage
+			pageArgs = append(append(make([]interface{}, 0, len(args)+1), args...), lastKey)
+		}
+		pageStart := time.Now()
+		rows, err := queryPage(ctx, db, pageQuery, pageArgs, opts)
+		if err != nil {
+			RecordQuery(ctx, pageQuery, 0, pageStart)
+			tracer.Errorf("iterate rows failed at page #%d, last key %v: %s", pages, lastKey, err)
+			return processErrors(err)
+		}
+		count, key, err := iteratePage(rows, opts.iterateKey, fn)
+		// the rows are read lazily, so the page costs until they are iterated, fn included
+		RecordQuery(ctx, pageQuery, int64(count), pageStart)
+		if err != nil {
+			return err
+		}
+		pages++
+		total += count
+		if key != nil {
+			lastKey = key
+		}
+
+		if time.Since(lastProgress) >= opts.progressInterval {
+			lastProgress = time.Now()
+			tracer.Infof("iterate rows in progress: %d rows, %d pages, last key %v, cost %v", total, pages, lastKey, time.Since(start))
+		}
+		if count < opts.pageSize {
+			break
+		}
+		if opts.pageInterval > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(opts.pageInterval):
+			}
+		}
+	}
+	tracer.Infof("iterate rows finished: %d rows, %d pages, last key %v, cost %v", total, pages, lastKey, time.Since(start))
+	return nil
+}
+
+// whereIndex returns the index of the WHERE keyword of the query, or -1 if it has no WHERE clause.
+// The WHERE in the subqueries, the quoted strings and the quoted identifiers are skipped.
+func whereIndex(query string) int {
+	depth := 0
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; c {
+		case '\'', '"', '`':
+			// skip to the closing quote, the escaped quotes are skipped with the backslash
+			for i++; i < len(query) && query[i] != c; i++ {
+				if query[i] == '\\' {
+					i++
+				}
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case 'w', 'W':
+			end := i + len("WHERE")
+			if depth == 0 && end <= len(query) && strings.EqualFold(query[i:end], "WHERE") &&
+				(i == 0 || !isIdentByte(query[i-1])) && (end == len(query) || !isIdentByte(query[end])) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func queryPage(ctx context.Context, db Queryer, query string, args []interface{}, opts *options) (*sqlx.Rows, error) {
+	backoff := opts.batchInterval
+	for attempt := 1; ; attempt++ {
+		rows, err := db.QueryxContext(ctx, query, args...)
+		if err == nil || attempt >= opts.batchAttempts || !isRetriableError(err, true) {
+			return rows, err
+		}
+		trace.GetTraceFromContext(ctx).Warnf("query page failed, will retry after %v: %s", backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// iteratePage calls fn with each row, and returns the rows count and the key of the last row
+func iteratePage(rows *sqlx.Rows, key string, fn func(rows *sqlx.Rows) error) (int, interface{}, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, nil, err
+	}
+	keyIdx := -1
+	for i, col := range columns {
+		if col == key {
+			keyIdx = i
+			break
+		}
+	}
+	if keyIdx < 0 {
+		return 0, nil, errors.NewBadRequestError(fmt.Sprintf("iterate key %s is not selected", key))
+	}
+
+	var (
+		count   int
+		lastKey interface{}
+		dest    = make([]interface{}, len(columns))
+		keyDest interface{}
+	)
+	for i := range dest {
+		dest[i] = new(interface{})
+	}
+	dest[keyIdx] = &keyDest
+	for rows.Next() {
+		// a row can be scanned more than once, so scan the key before fn
+		if err := rows.Scan(dest...); err != nil {
+			return count, lastKey, err
+		}
+		if b, ok := keyDest.([]byte); ok {
+			lastKey = string(b)
+		} else {
+			lastKey = keyDest
+		}
+		if err := fn(rows); err != nil {
+			return count, lastKey, err
+		}
+		count++
+	}
+	return count, lastKey, rows.Err()
+}
@@ -0,0 +1,85 @@
+package mysql_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/leopoldxx/go-utils/mysql"
+	"github.com/leopoldxx/go-utils/mysql/testkit"
+)
+
+func TestIterateRows(t *testing.T) {
+	ctx := context.TODO()
+	db, _ := testkit.NewDB()
+
+	var rows [][]mysql.Value
+	for i := 0; i < 25; i++ {
+		rows = append(rows, []mysql.Value{"name", i})
+	}
+	if _, err := mysql.BulkInsert(ctx, db, "users", []mysql.Field{"name", "age"}, rows, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	type user struct {
+		ID   int64  `db:"id"`
+		Name string `db:"name"`
+		Age  int    `db:"age"`
+	}
+	var users []user
+	err := mysql.IterateRows(ctx, db, "SELECT id, name, age FROM users WHERE age >= ?", []interface{}{5},
+		func(rows *sqlx.Rows) error {
+			var u user
+			if err := rows.StructScan(&u); err != nil {
+				return err
+			}
+			users = append(users, u)
+			return nil
+		}, mysql.WithPageSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 20 || users[0].Age != 5 || users[19].ID != 25 {
+		t.Fatalf("unexpected users: %d %+v", len(users), users)
+	}
+
+	// the OR condition is kept as a whole with the key range
+	var ids []int64
+	err = mysql.IterateRows(ctx, db, "SELECT id, age FROM users WHERE age < ? OR age >= ?", []interface{}{3, 22},
+		func(rows *sqlx.Rows) error {
+			var u user
+			if err := rows.StructScan(&u); err != nil {
+				return err
+			}
+			ids = append(ids, u.ID)
+			return nil
+		}, mysql.WithPageSize(2))
+	if err != nil || len(ids) != 6 || ids[2] != 3 || ids[3] != 23 {
+		t.Fatalf("unexpected ids of the OR condition: %v, %v", ids, err)
+	}
+
+	count := 0
+	err = mysql.IterateRows(ctx, db, "SELECT id FROM users", nil, func(rows *sqlx.Rows) error {
+		count++
+		return nil
+	}, mysql.WithPageSize(5))
+	if err != nil || count != 25 {
+		t.Fatalf("expect 25 rows, got %d, %v", count, err)
+	}
+
+	stop := errors.New("stop")
+	err = mysql.IterateRows(ctx, db, "SELECT id FROM users", nil, func(rows *sqlx.Rows) error {
+		return stop
+	})
+	if err != stop {
+		t.Fatalf("expect iteration stopped by fn, got %v", err)
+	}
+
+	err = mysql.IterateRows(ctx, db, "SELECT name FROM users", nil, func(rows *sqlx.Rows) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expect failed without the iterate key selected")
+	}
+}
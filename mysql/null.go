@@ -0,0 +1,251 @@
+package mysql
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// TimeLayout is the layout of the DATETIME columns returned without parseTime=true
+const TimeLayout = "2006-01-02 15:04:05.999999"
+
+var jsonNull = []byte("null")
+
+// NullString is a nullable string column, which is marshaled to json as a string or null
+type NullString struct {
+	sql.NullString
+}
+
+// NewNullString returns a valid NullString
+func NewNullString(s string) NullString {
+	return NullString{sql.NullString{String: s, Valid: true}}
+}
+
+// MarshalJSON implements json.Marshaler
+func (n NullString) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return jsonNull, nil
+	}
+	return json.Marshal(n.String)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (n *NullString) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, jsonNull) {
+		*n = NullString{}
+		return nil
+	}
+	if err := json.Unmarshal(data, &n.String); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
+
+// NullInt64 is a nullable integer column, which is marshaled to json as a number or null
+type NullInt64 struct {
+	sql.NullInt64
+}
+
+// NewNullInt64 returns a valid NullInt64
+func NewNullInt64(i int64) NullInt64 {
+	return NullInt64{sql.NullInt64{Int64: i, Valid: true}}
+}
+
+// MarshalJSON implements json.Marshaler
+func (n NullInt64) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return jsonNull, nil
+	}
+	return json.Marshal(n.Int64)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (n *NullInt64) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, jsonNull) {
+		*n = NullInt64{}
+		return nil
+	}
+	if err := json.Unmarshal(data, &n.Int64); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
+
+// NullTime is a nullable DATETIME/TIMESTAMP column, which is marshaled to json as a RFC3339 string or null.
+// It can be scanned with or without parseTime=true in the dsn, the text value is parsed in local time zone.
+type NullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+// NewNullTime returns a valid NullTime
+func NewNullTime(t time.Time) NullTime {
+	return NullTime{Time: t, Valid: true}
+}
+
+// Scan implements sql.Scanner
+func (n *NullTime) Scan(value interface{}) error {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		*n = NullTime{}
+		return nil
+	case time.Time:
+		n.Time, n.Valid = v, true
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return fmt.Errorf("can not scan %T into NullTime", value)
+	}
+	// zero dates are treated as NULL
+	if s == "" || strings.HasPrefix(s, "0000-00-00") {
+		*n = NullTime{}
+		return nil
+	}
+	t, err := time.ParseInLocation(TimeLayout, s, time.Local)
+	if err != nil {
+		if t, err = time.ParseInLocation("2006-01-02", s, time.Local); err != nil {
+			return fmt.Errorf("can not scan %q into NullTime: %s", s, err)
+		}
+	}
+	n.Time, n.Valid = t, true
+	return nil
+}
+
+// Value implements driver.Valuer
+func (n NullTime) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.Time, nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (n NullTime) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return jsonNull, nil
+	}
+	return n.Time.MarshalJSON()
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (n *NullTime) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, jsonNull) {
+		*n = NullTime{}
+		return nil
+	}
+	if err := n.Time.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
+
+// JSONColumn stores a value of T as json in a JSON/TEXT/BLOB column.
+//
+// If Compress is set, the json is gzipped before written, which is only suitable for BLOB columns.
+// Scan detects the gzipped values by the magic header, so the compressed and plain values can be
+// mixed in the same column, which makes it easy to turn on the compression for an existing table.
+type JSONColumn[T any] struct {
+	Val      T
+	Compress bool
+	// MaxSize is the max bytes of the decompressed json scanned, DefaultJSONColumnMaxSize if 0,
+	// so a crafted value can't decompress into unbounded memory
+	MaxSize int64
+}
+
+// DefaultJSONColumnMaxSize is the default max bytes of the decompressed json of JSONColumn,
+// which is the default max_allowed_packet of MySQL 8.0
+const DefaultJSONColumnMaxSize = 64 << 20
+
+// NewJSONColumn returns a JSONColumn of v
+func NewJSONColumn[T any](v T, compress bool) JSONColumn[T] {
+	return JSONColumn[T]{Val: v, Compress: compress}
+}
+
+// Value implements driver.Valuer
+func (c JSONColumn[T]) Value() (driver.Value, error) {
+	data, err := json.Marshal(c.Val)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Compress {
+		return string(data), nil
+	}
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Scan implements sql.Scanner, NULL is scanned as the zero value of T
+func (c *JSONColumn[T]) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		var zero T
+		c.Val = zero
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("can not scan %T into JSONColumn", value)
+	}
+	if isGzipped(data) {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		maxSize := c.MaxSize
+		if maxSize <= 0 {
+			maxSize = DefaultJSONColumnMaxSize
+		}
+		if data, err = ioutil.ReadAll(io.LimitReader(r, maxSize+1)); err != nil {
+			return fmt.Errorf("decompress json column failed: %s", err)
+		}
+		if int64(len(data)) > maxSize {
+			return fmt.Errorf("decompress json column failed: larger than %d bytes", maxSize)
+		}
+	}
+	var val T
+	if err := json.Unmarshal(data, &val); err != nil {
+		return fmt.Errorf("unmarshal json column failed: %s", err)
+	}
+	c.Val = val
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, only the value is marshaled
+func (c JSONColumn[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Val)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (c *JSONColumn[T]) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &c.Val)
+}
+
+// json text never starts with the gzip magic header 0x1f 0x8b
+func isGzipped(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b
+}
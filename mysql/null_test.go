@@ -0,0 +1,100 @@
+package mysql_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leopoldxx/go-utils/mysql"
+	"github.com/leopoldxx/go-utils/mysql/testkit"
+)
+
+func TestNullTypesJSON(t *testing.T) {
+	type item struct {
+		Name  mysql.NullString `json:"name"`
+		Count mysql.NullInt64  `json:"count"`
+		Time  mysql.NullTime   `json:"time"`
+	}
+	data, err := json.Marshal(item{})
+	if err != nil || string(data) != `{"name":null,"count":null,"time":null}` {
+		t.Fatalf("unexpected json: %s, %v", data, err)
+	}
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	in := item{Name: mysql.NewNullString("foo"), Count: mysql.NewNullInt64(3), Time: mysql.NewNullTime(now)}
+	data, err = json.Marshal(in)
+	if err != nil || string(data) != `{"name":"foo","count":3,"time":"2020-01-02T03:04:05Z"}` {
+		t.Fatalf("unexpected json: %s, %v", data, err)
+	}
+	var out item
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.Name.Valid || out.Name.String != "foo" || out.Count.Int64 != 3 || !out.Time.Time.Equal(now) {
+		t.Fatalf("unexpected item: %+v", out)
+	}
+}
+
+func TestNullTimeScan(t *testing.T) {
+	var n mysql.NullTime
+	if err := n.Scan([]byte("2020-01-02 03:04:05")); err != nil || !n.Valid || n.Time.Day() != 2 {
+		t.Fatalf("unexpected time: %+v, %v", n, err)
+	}
+	if err := n.Scan("0000-00-00 00:00:00"); err != nil || n.Valid {
+		t.Fatalf("expect zero date scanned as NULL: %+v, %v", n, err)
+	}
+	if err := n.Scan(nil); err != nil || n.Valid {
+		t.Fatalf("expect NULL: %+v, %v", n, err)
+	}
+	if err := n.Scan(1); err == nil {
+		t.Fatal("expect failed to scan an int")
+	}
+}
+
+func TestJSONColumn(t *testing.T) {
+	type attrs struct {
+		Tags []string `json:"tags"`
+	}
+	db, _ := testkit.NewDB()
+	ctx := context.TODO()
+	for _, compress := range []bool{false, true} {
+		col := mysql.NewJSONColumn(attrs{Tags: []string{"a", "b"}}, compress)
+		res, err := db.ExecContext(ctx, "INSERT INTO items (attrs) VALUES (?)", col)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+
+		var out mysql.JSONColumn[attrs]
+		if err := db.GetContext(ctx, &out, "SELECT attrs FROM items WHERE id = ?", id); err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Val.Tags) != 2 || out.Val.Tags[1] != "b" {
+			t.Fatalf("unexpected value: %+v", out.Val)
+		}
+	}
+
+	var out mysql.JSONColumn[*attrs]
+	if err := out.Scan(nil); err != nil || out.Val != nil {
+		t.Fatalf("expect NULL scanned as zero value: %+v, %v", out, err)
+	}
+	if err := out.Scan("{bad"); err == nil {
+		t.Fatal("expect failed to scan invalid json")
+	}
+
+	// the decompressed json is limited
+	data, err := mysql.NewJSONColumn(strings.Repeat("x", 1000), true).Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited := mysql.JSONColumn[string]{MaxSize: 100}
+	if err := limited.Scan(data); err == nil || !strings.Contains(err.Error(), "larger than 100 bytes") {
+		t.Fatalf("expect the large json rejected, got %v", err)
+	}
+	limited.MaxSize = 0
+	if err := limited.Scan(data); err != nil || len(limited.Val) != 1000 {
+		t.Fatalf("expect the json scanned by the default limit, got %d, %v", len(limited.Val), err)
+	}
+}
@@ -0,0 +1,48 @@
+package mysql
+
+import (
+	"context"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leopoldxx/go-utils/trace"
+)
+
+var (
+	sqlLiterals     = regexp.MustCompile(`'(?:[^'\\]|\\.)*'|"(?:[^"\\]|\\.)*"|\b\d+(?:\.\d+)?\b`)
+	sqlPlaceholders = regexp.MustCompile(`\?(?:\s*,\s*\?)+`)
+	sqlValueLists   = regexp.MustCompile(`\(\?\)(?:\s*,\s*\(\?\))+`)
+	sqlSpaces       = regexp.MustCompile(`\s+`)
+)
+
+// NormalizeSQL replaces the literals by ?, and collapses the lists of the placeholders and the spaces,
+// so the statements differing in the values or the numbers of them are the same, like
+//
+//	SELECT id FROM users WHERE (id IN (?,?,?)) LIMIT 10  =>  select id from users where (id in (?)) limit ?
+func NormalizeSQL(query string) string {
+	query = sqlLiterals.ReplaceAllString(query, "?")
+	query = sqlPlaceholders.ReplaceAllString(query, "?")
+	query = sqlValueLists.ReplaceAllString(query, "(?)")
+	query = sqlSpaces.ReplaceAllString(strings.TrimSpace(query), " ")
+	return strings.ToLower(query)
+}
+
+// SQLHash returns the hash of the normalized query, see NormalizeSQL
+func SQLHash(query string) string {
+	h := fnv.New64a()
+	h.Write([]byte(NormalizeSQL(query)))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// RecordQuery adds the summary of the query started at start to the request of ctx, the helpers of the
+// package record their queries, the ones run by the db directly can be recorded by it. The access log of
+// trace.HandleFunc logs the count and the total duration of the queries of the request.
+func RecordQuery(ctx context.Context, query string, rows int64, start time.Time) {
+	if !trace.CollectsQueries(ctx) {
+		return
+	}
+	trace.AddQuery(ctx, trace.Query{Hash: SQLHash(query), Rows: rows, Duration: time.Since(start)})
+}
@@ -0,0 +1,56 @@
+package mysql_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/leopoldxx/go-utils/mysql"
+	"github.com/leopoldxx/go-utils/mysql/testkit"
+	"github.com/leopoldxx/go-utils/trace"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	for query, expected := range map[string]string{
+		"SELECT id FROM users WHERE (id IN (?,?,?)) LIMIT 10":   "select id from users where (id in (?)) limit ?",
+		"INSERT INTO users (id,name) VALUES (?,?),(?,?),(?,?)":  "insert into users (id,name) values (?)",
+		"UPDATE users SET name='it''s', age=3  WHERE\n\tid = ?": "update users set name=??, age=? where id = ?",
+		"select * from t2 where name = \"x\\\"y\" and v = 1.5":  "select * from t2 where name = ? and v = ?",
+	} {
+		if normalized := mysql.NormalizeSQL(query); normalized != expected {
+			t.Errorf("expect %q normalized to %q, got %q", query, expected, normalized)
+		}
+	}
+	if mysql.SQLHash("SELECT id FROM users WHERE id IN (?,?)") != mysql.SQLHash("select id from users where id in (?)") {
+		t.Fatal("expect the same hash of the same normalized statements")
+	}
+}
+
+func TestRecordQueries(t *testing.T) {
+	db, _ := testkit.NewDB()
+	fields := []mysql.Field{"id", "name", "age"}
+	// not recorded out of a request
+	if _, err := mysql.BulkInsert(context.TODO(), db, "users", fields, [][]mysql.Value{{1, "foo", 10}}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := trace.WithQueries(context.TODO())
+	if _, err := mysql.BulkInsert(ctx, db, "users", fields, [][]mysql.Value{{2, "bar", 20}, {3, "baz", 30}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	type user struct {
+		ID   int64  `db:"id"`
+		Name string `db:"name"`
+		Age  int    `db:"age"`
+	}
+	var users []user
+	if err := mysql.SelectRows(ctx, db, nil, "users", fields, []mysql.WhereClause{{"age": []int{10, 20, 30}}}, &users); err != nil {
+		t.Fatal(err)
+	}
+	queries, count, duration := trace.Queries(ctx)
+	if count != 2 || len(queries) != 2 || duration <= 0 {
+		t.Fatalf("expect 2 queries recorded, got %d %v %+v", count, duration, queries)
+	}
+	if queries[0].Rows != 2 || queries[1].Rows != 3 || queries[1].Hash != mysql.SQLHash("SELECT id,name,age FROM users WHERE (age IN (?))") {
+		t.Fatalf("unexpected queries %+v", queries)
+	}
+}
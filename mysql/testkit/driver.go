@@ -0,0 +1,218 @@
+// Package testkit provides an in-memory stub database and fixture loaders,
+// so the code built on the mysql package can be unit tested without a live MySQL.
+//
+// The stub understands the statements generated by the mysql helpers:
+// SELECT(with WHERE/ORDER BY/LIMIT), INSERT(with ON DUPLICATE KEY UPDATE), UPDATE and DELETE.
+// The "id" column is the auto increment primary key of every table.
+package testkit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DriverName is the name of the in-memory stub driver registered to database/sql
+const DriverName = "mysql-testkit"
+
+var (
+	storesMu sync.Mutex
+	stores   = map[string]*Store{}
+	dbSeq    int64
+)
+
+func init() {
+	sql.Register(DriverName, &stubDriver{})
+}
+
+// NewDB creates an isolated in-memory database, the returned *sqlx.DB uses the
+// mysql bind type so that it can be used by all the helpers of the mysql package.
+// The store is dropped when the db is closed.
+func NewDB() (*sqlx.DB, *Store) {
+	dsn := fmt.Sprintf("testkit-%d", atomic.AddInt64(&dbSeq, 1))
+	store := OpenStore(dsn)
+	db := sqlx.NewDb(sql.OpenDB(&connector{dsn: dsn, store: store}), "mysql")
+	return db, store
+}
+
+// OpenStore returns the store of the dsn, sql.Open(DriverName, dsn) will share the same store
+func OpenStore(dsn string) *Store {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	store, ok := stores[dsn]
+	if !ok {
+		store = newStore()
+		stores[dsn] = store
+	}
+	return store
+}
+
+type stubDriver struct{}
+
+func (d *stubDriver) Open(dsn string) (driver.Conn, error) {
+	return &conn{store: OpenStore(dsn)}, nil
+}
+
+type connector struct {
+	dsn   string
+	store *Store
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{store: c.store}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return &stubDriver{}
+}
+
+// Close is called by sql.DB.Close, it drops the store of the db
+func (c *connector) Close() error {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	if stores[c.dsn] == c.store {
+		delete(stores, c.dsn)
+	}
+	return nil
+}
+
+type conn struct {
+	store *Store
+	tx    *tx
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	st, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{conn: c, st: st}, nil
+}
+
+func (c *conn) Close() error {
+	if c.tx != nil {
+		return c.tx.Rollback()
+	}
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, fmt.Errorf("testkit: nested transactions are not supported")
+	}
+	// a transaction is implemented by snapshot & restore, it is isolated
+	// from nothing, but is enough for testing commit/rollback behaviors
+	c.tx = &tx{conn: c, snapshot: c.store.snapshot()}
+	return c.tx, nil
+}
+
+// CheckNamedValue converts the args with the default converter of database/sql
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	v, err := driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	nv.Value = v
+	return nil
+}
+
+type tx struct {
+	conn     *conn
+	snapshot map[string]*table
+}
+
+func (t *tx) Commit() error {
+	t.conn.tx = nil
+	return nil
+}
+
+func (t *tx) Rollback() error {
+	t.conn.store.restore(t.snapshot)
+	t.conn.tx = nil
+	return nil
+}
+
+type stmt struct {
+	conn *conn
+	st   *statement
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return s.st.numArgs
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	res, err := s.conn.store.execute(s.st, toInterfaces(args))
+	if err != nil {
+		return nil, err
+	}
+	return &result{affected: res.affected, lastID: res.lastID}, nil
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	res, err := s.conn.store.execute(s.st, toInterfaces(args))
+	if err != nil {
+		return nil, err
+	}
+	return &rows{columns: res.columns, values: res.rows}, nil
+}
+
+func toInterfaces(args []driver.Value) []interface{} {
+	values := make([]interface{}, len(args))
+	for i := range args {
+		if b, ok := args[i].([]byte); ok {
+			// copy the bytes, database/sql may reuse them
+			args[i] = string(b)
+		}
+		values[i] = args[i]
+	}
+	return values
+}
+
+type result struct {
+	affected int64
+	lastID   int64
+}
+
+func (r *result) LastInsertId() (int64, error) {
+	return r.lastID, nil
+}
+
+func (r *result) RowsAffected() (int64, error) {
+	return r.affected, nil
+}
+
+type rows struct {
+	columns []string
+	values  [][]interface{}
+	pos     int
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.values) {
+		return io.EOF
+	}
+	for i, v := range r.values[r.pos] {
+		dest[i] = v
+	}
+	r.pos++
+	return nil
+}
@@ -0,0 +1,21 @@
+package testkit
+
+import "testing"
+
+func TestCloseDB(t *testing.T) {
+	db, store := NewDB()
+	storesMu.Lock()
+	n := len(stores)
+	storesMu.Unlock()
+	db.Close()
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	if len(stores) != n-1 {
+		t.Fatalf("expect the store dropped when the db is closed, %d stores left", len(stores))
+	}
+	for _, s := range stores {
+		if s == store {
+			t.Fatal("expect the store of the closed db dropped")
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package testkit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/leopoldxx/go-utils/mysql"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Fixture is the seed data of a table
+type Fixture struct {
+	Table string
+	Rows  []Row
+}
+
+// Fixtures are loaded in order, so tables referenced by others should be put first
+type Fixtures []Fixture
+
+// ParseFixtures parses yaml seed data like:
+//
+//	users:
+//	  - id: 1
+//	    name: foo
+//	  - id: 2
+//	    name: bar
+//	orders:
+//	  - user_id: 1
+//	    amount: 100
+func ParseFixtures(data []byte) (Fixtures, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse fixtures failed: %s", err)
+	}
+	var fixtures Fixtures
+	for _, item := range doc {
+		name, ok := item.Key.(string)
+		if !ok {
+			return nil, fmt.Errorf("parse fixtures failed: invalid table name %v", item.Key)
+		}
+		fixture := Fixture{Table: name}
+		rows, ok := item.Value.([]interface{})
+		if !ok && item.Value != nil {
+			return nil, fmt.Errorf("parse fixtures failed: rows of %s should be a list", name)
+		}
+		for _, r := range rows {
+			row, err := toRow(r)
+			if err != nil {
+				return nil, fmt.Errorf("parse fixtures failed: table %s: %s", name, err)
+			}
+			fixture.Rows = append(fixture.Rows, row)
+		}
+		fixtures = append(fixtures, fixture)
+	}
+	return fixtures, nil
+}
+
+// ParseFixtureFiles reads and parses the fixture files in order
+func ParseFixtureFiles(files ...string) (Fixtures, error) {
+	var fixtures Fixtures
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		f, err := ParseFixtures(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		fixtures = append(fixtures, f...)
+	}
+	return fixtures, nil
+}
+
+func toRow(v interface{}) (Row, error) {
+	m, ok := v.(yaml.MapSlice)
+	if !ok {
+		return nil, fmt.Errorf("row should be a map, got %T", v)
+	}
+	row := Row{}
+	for _, item := range m {
+		col, ok := item.Key.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid column %v", item.Key)
+		}
+		value, err := toColumnValue(item.Value)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %s", col, err)
+		}
+		row[col] = value
+	}
+	return row, nil
+}
+
+// toColumnValue stores nested values as json, which is what json columns expect
+func toColumnValue(v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case yaml.MapSlice, []interface{}, map[interface{}]interface{}:
+		b, err := json.Marshal(toJSONCompatible(v))
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	}
+	return v, nil
+}
+
+func toJSONCompatible(v interface{}) interface{} {
+	switch x := v.(type) {
+	case yaml.MapSlice:
+		m := make(map[string]interface{}, len(x))
+		for _, item := range x {
+			m[fmt.Sprint(item.Key)] = toJSONCompatible(item.Value)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, item := range x {
+			m[fmt.Sprint(k)] = toJSONCompatible(item)
+		}
+		return m
+	case []interface{}:
+		for i := range x {
+			x[i] = toJSONCompatible(x[i])
+		}
+		return x
+	}
+	return v
+}
+
+// Load inserts the fixtures into db, one statement per row
+func (fixtures Fixtures) Load(ctx context.Context, db mysql.Execer) error {
+	for _, fixture := range fixtures {
+		for _, row := range fixture.Rows {
+			columns := make([]string, 0, len(row))
+			for col := range row {
+				columns = append(columns, col)
+			}
+			sort.Strings(columns)
+			values := make([]interface{}, 0, len(columns))
+			for _, col := range columns {
+				values = append(values, row[col])
+			}
+			query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+				fixture.Table,
+				strings.Join(columns, ","),
+				strings.TrimSuffix(strings.Repeat("?,", len(columns)), ","))
+			if _, err := db.ExecContext(ctx, query, values...); err != nil {
+				return fmt.Errorf("load fixture of %s failed: %s", fixture.Table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadFixtureFiles parses the fixture files and loads them into db
+func LoadFixtureFiles(ctx context.Context, db mysql.Execer, files ...string) error {
+	fixtures, err := ParseFixtureFiles(files...)
+	if err != nil {
+		return err
+	}
+	return fixtures.Load(ctx, db)
+}
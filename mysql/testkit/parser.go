@@ -0,0 +1,656 @@
+package testkit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokNumber
+	tokString
+	tokSymbol
+	tokPlaceholder
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(query string) ([]token, error) {
+	var tokens []token
+	rs := []rune(query)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '?':
+			tokens = append(tokens, token{tokPlaceholder, "?"})
+			i++
+		case r == '\'' || r == '"':
+			quote := r
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(rs); j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+					sb.WriteRune(rs[j])
+					continue
+				}
+				if rs[j] == quote {
+					if j+1 < len(rs) && rs[j+1] == quote {
+						sb.WriteRune(quote)
+						j++
+						continue
+					}
+					break
+				}
+				sb.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("unterminated string in %q", query)
+			}
+			tokens = append(tokens, token{tokString, sb.String()})
+			i = j + 1
+		case r == '`':
+			j := i + 1
+			for j < len(rs) && rs[j] != '`' {
+				j++
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("unterminated identifier in %q", query)
+			}
+			tokens = append(tokens, token{tokIdent, string(rs[i+1 : j])})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i + 1
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, string(rs[i:j])})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_' || rs[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, string(rs[i:j])})
+			i = j
+		default:
+			if i+1 < len(rs) {
+				two := string(rs[i : i+2])
+				if two == ">=" || two == "<=" || two == "!=" || two == "<>" {
+					tokens = append(tokens, token{tokSymbol, two})
+					i += 2
+					continue
+				}
+			}
+			if strings.ContainsRune("(),=<>*;+", r) {
+				tokens = append(tokens, token{tokSymbol, string(r)})
+				i++
+				continue
+			}
+			return nil, fmt.Errorf("unexpected character %q in %q", r, query)
+		}
+	}
+	return tokens, nil
+}
+
+// operand of an expression: a placeholder, a literal or a column reference
+type operand struct {
+	placeholder int // index of the args, -1 if not a placeholder
+	column      string
+	literal     interface{}
+	isNull      bool
+}
+
+type condExpr struct {
+	// for logical nodes
+	op          string // AND, OR
+	left, right *condExpr
+	// for comparison nodes
+	column string
+	cmp    string // =, !=, <, <=, >, >=, IN, IS NULL, IS NOT NULL
+	values []operand
+}
+
+type assignment struct {
+	column string
+	value  operand
+	// column = VALUES(column) in ON DUPLICATE KEY UPDATE
+	fromValues string
+}
+
+type orderBy struct {
+	column string
+	desc   bool
+}
+
+type statement struct {
+	kind    string // SELECT, INSERT, UPDATE, DELETE
+	table   string
+	columns []string
+	count   bool // SELECT COUNT(*)
+	rows    [][]operand
+	sets    []assignment
+	where   *condExpr
+	orders  []orderBy
+	limit   int
+	offset  int
+	// INSERT ... ON DUPLICATE KEY UPDATE
+	upsert []assignment
+	// INSERT IGNORE
+	ignore  bool
+	numArgs int
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	args   int
+}
+
+func parse(query string) (*statement, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	stmt, err := p.statement()
+	if err != nil {
+		return nil, fmt.Errorf("testkit: %s: %q", err, query)
+	}
+	stmt.numArgs = p.args
+	return stmt, nil
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) next() (token, error) {
+	if p.pos >= len(p.tokens) {
+		return token{}, fmt.Errorf("unexpected end of statement")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *parser) isKeyword(kw string) bool {
+	t, ok := p.peek()
+	return ok && t.kind == tokIdent && strings.EqualFold(t.text, kw)
+}
+
+func (p *parser) isSymbol(sym string) bool {
+	t, ok := p.peek()
+	return ok && t.kind == tokSymbol && t.text == sym
+}
+
+func (p *parser) expectKeyword(kws ...string) error {
+	for _, kw := range kws {
+		if !p.isKeyword(kw) {
+			t, _ := p.peek()
+			return fmt.Errorf("expect %s, got %q", kw, t.text)
+		}
+		p.pos++
+	}
+	return nil
+}
+
+func (p *parser) expectSymbol(sym string) error {
+	if !p.isSymbol(sym) {
+		t, _ := p.peek()
+		return fmt.Errorf("expect %q, got %q", sym, t.text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if t.kind != tokIdent {
+		return "", fmt.Errorf("expect identifier, got %q", t.text)
+	}
+	// strip table qualifier
+	if idx := strings.LastIndexByte(t.text, '.'); idx >= 0 {
+		return t.text[idx+1:], nil
+	}
+	return t.text, nil
+}
+
+func (p *parser) statement() (*statement, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	var stmt *statement
+	switch strings.ToUpper(t.text) {
+	case "SELECT":
+		stmt, err = p.selectStmt()
+	case "INSERT":
+		stmt, err = p.insertStmt()
+	case "UPDATE":
+		stmt, err = p.updateStmt()
+	case "DELETE":
+		stmt, err = p.deleteStmt()
+	default:
+		return nil, fmt.Errorf("unsupported statement %q", t.text)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.isSymbol(";") {
+		p.pos++
+	}
+	if t, ok := p.peek(); ok {
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+	return stmt, nil
+}
+
+func (p *parser) selectStmt() (*statement, error) {
+	stmt := &statement{kind: "SELECT"}
+	if p.isKeyword("COUNT") {
+		p.pos++
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("*"); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		stmt.count = true
+	} else if p.isSymbol("*") {
+		p.pos++
+	} else {
+		for {
+			col, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			stmt.columns = append(stmt.columns, col)
+			if !p.isSymbol(",") {
+				break
+			}
+			p.pos++
+		}
+	}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	table, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	stmt.table = table
+	if stmt.where, err = p.optionalWhere(); err != nil {
+		return nil, err
+	}
+	if p.isKeyword("ORDER") {
+		p.pos++
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			col, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			ob := orderBy{column: col}
+			if p.isKeyword("DESC") {
+				ob.desc = true
+				p.pos++
+			} else if p.isKeyword("ASC") {
+				p.pos++
+			}
+			stmt.orders = append(stmt.orders, ob)
+			if !p.isSymbol(",") {
+				break
+			}
+			p.pos++
+		}
+	}
+	stmt.limit = -1
+	if p.isKeyword("LIMIT") {
+		p.pos++
+		n, err := p.intValue()
+		if err != nil {
+			return nil, err
+		}
+		stmt.limit = n
+		if p.isSymbol(",") {
+			// LIMIT offset, count
+			p.pos++
+			m, err := p.intValue()
+			if err != nil {
+				return nil, err
+			}
+			stmt.offset, stmt.limit = n, m
+		} else if p.isKeyword("OFFSET") {
+			p.pos++
+			if stmt.offset, err = p.intValue(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.isKeyword("FOR") {
+		// FOR UPDATE, ignored by the stub
+		p.pos++
+		if err := p.expectKeyword("UPDATE"); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) intValue() (int, error) {
+	t, err := p.next()
+	if err != nil {
+		return 0, err
+	}
+	if t.kind != tokNumber {
+		return 0, fmt.Errorf("expect number, got %q", t.text)
+	}
+	return strconv.Atoi(t.text)
+}
+
+func (p *parser) insertStmt() (*statement, error) {
+	stmt := &statement{kind: "INSERT"}
+	if p.isKeyword("IGNORE") {
+		p.pos++
+		stmt.ignore = true
+	}
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	table, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	stmt.table = table
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		stmt.columns = append(stmt.columns, col)
+		if p.isSymbol(")") {
+			p.pos++
+			break
+		}
+		if err := p.expectSymbol(","); err != nil {
+			return nil, err
+		}
+	}
+	if !p.isKeyword("VALUES") && !p.isKeyword("VALUE") {
+		return nil, fmt.Errorf("expect VALUES")
+	}
+	p.pos++
+	for {
+		values, err := p.operandList()
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != len(stmt.columns) {
+			return nil, fmt.Errorf("column count doesn't match value count")
+		}
+		stmt.rows = append(stmt.rows, values)
+		if !p.isSymbol(",") {
+			break
+		}
+		p.pos++
+	}
+	if p.isKeyword("ON") {
+		p.pos++
+		if err := p.expectKeyword("DUPLICATE", "KEY", "UPDATE"); err != nil {
+			return nil, err
+		}
+		if stmt.upsert, err = p.assignments(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) updateStmt() (*statement, error) {
+	stmt := &statement{kind: "UPDATE"}
+	table, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	stmt.table = table
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	if stmt.sets, err = p.assignments(); err != nil {
+		return nil, err
+	}
+	stmt.where, err = p.optionalWhere()
+	return stmt, err
+}
+
+func (p *parser) deleteStmt() (*statement, error) {
+	stmt := &statement{kind: "DELETE"}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	table, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	stmt.table = table
+	stmt.where, err = p.optionalWhere()
+	return stmt, err
+}
+
+func (p *parser) assignments() ([]assignment, error) {
+	var sets []assignment
+	for {
+		col, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		a := assignment{column: col}
+		if p.isKeyword("VALUES") {
+			p.pos++
+			if err := p.expectSymbol("("); err != nil {
+				return nil, err
+			}
+			if a.fromValues, err = p.ident(); err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+		} else if a.value, err = p.operand(); err != nil {
+			return nil, err
+		}
+		sets = append(sets, a)
+		if !p.isSymbol(",") {
+			return sets, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *parser) operandList() ([]operand, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	var values []operand
+	for {
+		v, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		if p.isSymbol(")") {
+			p.pos++
+			return values, nil
+		}
+		if err := p.expectSymbol(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) operand() (operand, error) {
+	t, err := p.next()
+	if err != nil {
+		return operand{}, err
+	}
+	switch t.kind {
+	case tokPlaceholder:
+		p.args++
+		return operand{placeholder: p.args - 1}, nil
+	case tokNumber:
+		if strings.Contains(t.text, ".") {
+			f, err := strconv.ParseFloat(t.text, 64)
+			return operand{placeholder: -1, literal: f}, err
+		}
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		return operand{placeholder: -1, literal: n}, err
+	case tokString:
+		return operand{placeholder: -1, literal: t.text}, nil
+	case tokIdent:
+		switch strings.ToUpper(t.text) {
+		case "NULL":
+			return operand{placeholder: -1, isNull: true}, nil
+		case "TRUE":
+			return operand{placeholder: -1, literal: int64(1)}, nil
+		case "FALSE":
+			return operand{placeholder: -1, literal: int64(0)}, nil
+		case "NOW":
+			if p.isSymbol("(") {
+				p.pos++
+				if err := p.expectSymbol(")"); err != nil {
+					return operand{}, err
+				}
+				return operand{placeholder: -1, column: "NOW()"}, nil
+			}
+		}
+		col := t.text
+		if idx := strings.LastIndexByte(col, '.'); idx >= 0 {
+			col = col[idx+1:]
+		}
+		return operand{placeholder: -1, column: col}, nil
+	}
+	return operand{}, fmt.Errorf("unexpected %q", t.text)
+}
+
+func (p *parser) optionalWhere() (*condExpr, error) {
+	if !p.isKeyword("WHERE") {
+		return nil, nil
+	}
+	p.pos++
+	return p.orExpr()
+}
+
+func (p *parser) orExpr() (*condExpr, error) {
+	left, err := p.andExpr()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.pos++
+		right, err := p.andExpr()
+		if err != nil {
+			return nil, err
+		}
+		left = &condExpr{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) andExpr() (*condExpr, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.pos++
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		left = &condExpr{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) factor() (*condExpr, error) {
+	if p.isSymbol("(") {
+		p.pos++
+		expr, err := p.orExpr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expectSymbol(")")
+	}
+	col, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	expr := &condExpr{column: col}
+	switch {
+	case p.isKeyword("IN"):
+		p.pos++
+		expr.cmp = "IN"
+		expr.values, err = p.operandList()
+		return expr, err
+	case p.isKeyword("NOT"):
+		p.pos++
+		if err := p.expectKeyword("IN"); err != nil {
+			return nil, err
+		}
+		expr.cmp = "NOT IN"
+		expr.values, err = p.operandList()
+		return expr, err
+	case p.isKeyword("IS"):
+		p.pos++
+		expr.cmp = "IS NULL"
+		if p.isKeyword("NOT") {
+			p.pos++
+			expr.cmp = "IS NOT NULL"
+		}
+		return expr, p.expectKeyword("NULL")
+	}
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokSymbol || !strings.Contains("= != <> < <= > >=", t.text) {
+		return nil, fmt.Errorf("unsupported operator %q", t.text)
+	}
+	expr.cmp = t.text
+	if expr.cmp == "<>" {
+		expr.cmp = "!="
+	}
+	v, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	expr.values = []operand{v}
+	return expr, nil
+}
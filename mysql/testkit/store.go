@@ -0,0 +1,540 @@
+package testkit
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gomysql "github.com/go-sql-driver/mysql"
+)
+
+// mysql error numbers the stub can return
+const (
+	errDupEntry      = 1062
+	errNoSuchTable   = 1146
+	errBadFieldError = 1054
+)
+
+// Row is a row of a table, keyed by the column name
+type Row map[string]interface{}
+
+type table struct {
+	name    string
+	columns []string
+	rows    []Row
+	autoInc int64
+	// unique keys, each key is a list of columns, the primary key "id" is always unique
+	uniques [][]string
+}
+
+func (t *table) clone() *table {
+	nt := &table{
+		name:    t.name,
+		columns: append([]string{}, t.columns...),
+		rows:    make([]Row, 0, len(t.rows)),
+		autoInc: t.autoInc,
+		uniques: t.uniques,
+	}
+	for _, r := range t.rows {
+		nt.rows = append(nt.rows, r.clone())
+	}
+	return nt
+}
+
+func (r Row) clone() Row {
+	nr := make(Row, len(r))
+	for k, v := range r {
+		nr[k] = v
+	}
+	return nr
+}
+
+func (t *table) hasColumn(col string) bool {
+	for _, c := range t.columns {
+		if c == col {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *table) addColumn(col string) {
+	if !t.hasColumn(col) {
+		t.columns = append(t.columns, col)
+	}
+}
+
+// conflict returns the index of the row that conflicts with r on a unique key, or -1
+func (t *table) conflict(r Row, skip int) int {
+	keys := append([][]string{{"id"}}, t.uniques...)
+	for i, row := range t.rows {
+		if i == skip {
+			continue
+		}
+		for _, key := range keys {
+			matched := true
+			for _, col := range key {
+				a, b := r[col], row[col]
+				if a == nil || b == nil || compareValues(a, b) != 0 {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Store is an in-memory database used by the stub driver
+type Store struct {
+	mu     sync.Mutex
+	tables map[string]*table
+	// strict means tables must be created before used
+	strict bool
+	now    func() time.Time
+}
+
+func newStore() *Store {
+	return &Store{tables: map[string]*table{}, now: time.Now}
+}
+
+// Strict requires tables to be created with CreateTable before being used,
+// otherwise tables are created on the first insert
+func (s *Store) Strict(strict bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.strict = strict
+}
+
+// SetNow overrides the clock used for NOW()
+func (s *Store) SetNow(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// CreateTable creates an empty table with columns, uniqueKeys are extra unique
+// indexes besides the primary key "id", like []string{"name"} or []string{"a", "b"}
+func (s *Store) CreateTable(name string, columns []string, uniqueKeys ...[]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := &table{name: name, uniques: uniqueKeys}
+	for _, col := range columns {
+		t.addColumn(col)
+	}
+	s.tables[name] = t
+}
+
+// Rows returns a copy of the rows of the table
+func (s *Store) Rows(name string) []Row {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tables[name]
+	if !ok {
+		return nil
+	}
+	rows := make([]Row, 0, len(t.rows))
+	for _, r := range t.rows {
+		rows = append(rows, r.clone())
+	}
+	return rows
+}
+
+// Tables returns the sorted table names
+func (s *Store) Tables() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.tables))
+	for name := range s.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Reset drops all the tables
+func (s *Store) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables = map[string]*table{}
+}
+
+func (s *Store) snapshot() map[string]*table {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := make(map[string]*table, len(s.tables))
+	for name, t := range s.tables {
+		snap[name] = t.clone()
+	}
+	return snap
+}
+
+func (s *Store) restore(snap map[string]*table) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables = snap
+}
+
+func (s *Store) table(name string, create bool) (*table, error) {
+	t, ok := s.tables[name]
+	if ok {
+		return t, nil
+	}
+	if !create || s.strict {
+		return nil, &gomysql.MySQLError{Number: errNoSuchTable, Message: fmt.Sprintf("Table '%s' doesn't exist", name)}
+	}
+	t = &table{name: name}
+	s.tables[name] = t
+	return t, nil
+}
+
+type execResult struct {
+	columns  []string
+	rows     [][]interface{}
+	affected int64
+	lastID   int64
+}
+
+func (s *Store) execute(stmt *statement, args []interface{}) (*execResult, error) {
+	if len(args) != stmt.numArgs {
+		return nil, fmt.Errorf("testkit: expect %d args, got %d", stmt.numArgs, len(args))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch stmt.kind {
+	case "SELECT":
+		return s.selectRows(stmt, args)
+	case "INSERT":
+		return s.insertRows(stmt, args)
+	case "UPDATE":
+		return s.updateRows(stmt, args)
+	case "DELETE":
+		return s.deleteRows(stmt, args)
+	}
+	return nil, fmt.Errorf("testkit: unsupported statement %s", stmt.kind)
+}
+
+func (s *Store) value(o operand, args []interface{}, row Row) interface{} {
+	switch {
+	case o.placeholder >= 0:
+		return args[o.placeholder]
+	case o.isNull:
+		return nil
+	case o.column == "NOW()":
+		return s.now()
+	case o.column != "":
+		return row[o.column]
+	}
+	return o.literal
+}
+
+func (s *Store) checkColumns(t *table, cols ...string) error {
+	if !s.strict {
+		return nil
+	}
+	for _, col := range cols {
+		if !t.hasColumn(col) {
+			return &gomysql.MySQLError{Number: errBadFieldError, Message: fmt.Sprintf("Unknown column '%s' in '%s'", col, t.name)}
+		}
+	}
+	return nil
+}
+
+func (s *Store) match(expr *condExpr, args []interface{}, row Row) bool {
+	if expr == nil {
+		return true
+	}
+	switch expr.op {
+	case "AND":
+		return s.match(expr.left, args, row) && s.match(expr.right, args, row)
+	case "OR":
+		return s.match(expr.left, args, row) || s.match(expr.right, args, row)
+	}
+	v := row[expr.column]
+	switch expr.cmp {
+	case "IS NULL":
+		return v == nil
+	case "IS NOT NULL":
+		return v != nil
+	case "IN", "NOT IN":
+		found := false
+		for _, o := range expr.values {
+			if other := s.value(o, args, row); v != nil && other != nil && compareValues(v, other) == 0 {
+				found = true
+				break
+			}
+		}
+		return found == (expr.cmp == "IN")
+	}
+	other := s.value(expr.values[0], args, row)
+	if v == nil || other == nil {
+		// NULL never equals to anything
+		return false
+	}
+	c := compareValues(v, other)
+	switch expr.cmp {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+func (s *Store) selectRows(stmt *statement, args []interface{}) (*execResult, error) {
+	t, err := s.table(stmt.table, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkColumns(t, stmt.columns...); err != nil {
+		return nil, err
+	}
+	var matched []Row
+	for _, r := range t.rows {
+		if s.match(stmt.where, args, r) {
+			matched = append(matched, r)
+		}
+	}
+	if stmt.count {
+		return &execResult{columns: []string{"COUNT(*)"}, rows: [][]interface{}{{int64(len(matched))}}}, nil
+	}
+	if len(stmt.orders) > 0 {
+		sort.SliceStable(matched, func(i, j int) bool {
+			for _, ob := range stmt.orders {
+				a, b := matched[i][ob.column], matched[j][ob.column]
+				c := compareNullable(a, b)
+				if c == 0 {
+					continue
+				}
+				if ob.desc {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+	if stmt.offset > 0 {
+		if stmt.offset >= len(matched) {
+			matched = nil
+		} else {
+			matched = matched[stmt.offset:]
+		}
+	}
+	if stmt.limit >= 0 && stmt.limit < len(matched) {
+		matched = matched[:stmt.limit]
+	}
+
+	columns := stmt.columns
+	if len(columns) == 0 {
+		columns = t.columns
+	}
+	result := &execResult{columns: columns}
+	for _, r := range matched {
+		values := make([]interface{}, len(columns))
+		for i, col := range columns {
+			values[i] = r[col]
+		}
+		result.rows = append(result.rows, values)
+	}
+	return result, nil
+}
+
+func (s *Store) insertRows(stmt *statement, args []interface{}) (*execResult, error) {
+	t, err := s.table(stmt.table, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkColumns(t, stmt.columns...); err != nil {
+		return nil, err
+	}
+	result := &execResult{}
+	for _, values := range stmt.rows {
+		row := Row{}
+		for i, col := range stmt.columns {
+			row[col] = s.value(values[i], args, nil)
+		}
+		if idx := t.conflict(row, -1); idx >= 0 {
+			switch {
+			case stmt.upsert != nil:
+				old := t.rows[idx]
+				for _, a := range stmt.upsert {
+					if a.fromValues != "" {
+						old[a.column] = row[a.fromValues]
+					} else {
+						old[a.column] = s.value(a.value, args, old)
+					}
+				}
+				// mysql reports 2 affected rows for an updated row
+				result.affected += 2
+				continue
+			case stmt.ignore:
+				continue
+			}
+			return nil, &gomysql.MySQLError{Number: errDupEntry, Message: fmt.Sprintf("Duplicate entry for table '%s'", t.name)}
+		}
+		if row["id"] == nil {
+			t.autoInc++
+			row["id"] = t.autoInc
+			t.addColumn("id")
+		} else if id, ok := toInt64(row["id"]); ok && id > t.autoInc {
+			t.autoInc = id
+		}
+		for _, col := range stmt.columns {
+			t.addColumn(col)
+		}
+		t.rows = append(t.rows, row)
+		result.affected++
+		if id, ok := toInt64(row["id"]); ok {
+			result.lastID = id
+		}
+	}
+	return result, nil
+}
+
+func (s *Store) updateRows(stmt *statement, args []interface{}) (*execResult, error) {
+	t, err := s.table(stmt.table, false)
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]string, 0, len(stmt.sets))
+	for _, a := range stmt.sets {
+		cols = append(cols, a.column)
+	}
+	if err := s.checkColumns(t, cols...); err != nil {
+		return nil, err
+	}
+	result := &execResult{}
+	for i, r := range t.rows {
+		if !s.match(stmt.where, args, r) {
+			continue
+		}
+		updated := r.clone()
+		changed := false
+		for _, a := range stmt.sets {
+			v := s.value(a.value, args, r)
+			if compareNullable(updated[a.column], v) != 0 || (updated[a.column] == nil) != (v == nil) {
+				changed = true
+			}
+			updated[a.column] = v
+			t.addColumn(a.column)
+		}
+		if t.conflict(updated, i) >= 0 {
+			return nil, &gomysql.MySQLError{Number: errDupEntry, Message: fmt.Sprintf("Duplicate entry for table '%s'", t.name)}
+		}
+		t.rows[i] = updated
+		if changed {
+			result.affected++
+		}
+	}
+	return result, nil
+}
+
+func (s *Store) deleteRows(stmt *statement, args []interface{}) (*execResult, error) {
+	t, err := s.table(stmt.table, false)
+	if err != nil {
+		return nil, err
+	}
+	result := &execResult{}
+	kept := t.rows[:0]
+	for _, r := range t.rows {
+		if s.match(stmt.where, args, r) {
+			result.affected++
+			continue
+		}
+		kept = append(kept, r)
+	}
+	t.rows = kept
+	return result, nil
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), n == float64(int64(n))
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// compareNullable sorts NULL first like mysql
+func compareNullable(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return compareValues(a, b)
+}
+
+// compareValues compares two non-nil driver values
+func compareValues(a, b interface{}) int {
+	if x, ok := toFloat64(a); ok {
+		if y, ok := toFloat64(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	if x, ok := a.(time.Time); ok {
+		if y, ok := b.(time.Time); ok {
+			switch {
+			case x.Before(y):
+				return -1
+			case x.After(y):
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(toString(a), toString(b))
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(bytes.TrimRight(s, "\x00"))
+	case time.Time:
+		return s.Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprint(v)
+}
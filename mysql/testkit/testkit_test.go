@@ -0,0 +1,168 @@
+package testkit_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	goerrors "github.com/leopoldxx/go-utils/errors"
+	"github.com/leopoldxx/go-utils/mysql"
+	"github.com/leopoldxx/go-utils/mysql/testkit"
+)
+
+type user struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+	Age  int    `db:"age"`
+}
+
+const seed = `
+users:
+  - id: 1
+    name: foo
+    age: 18
+  - id: 2
+    name: bar
+    age: 20
+  - id: 3
+    name: baz
+    age: 30
+`
+
+func newDB(t *testing.T) (*sqlx.DB, *testkit.Store) {
+	db, store := testkit.NewDB()
+	fixtures, err := testkit.ParseFixtures([]byte(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fixtures.Load(context.TODO(), db); err != nil {
+		t.Fatal(err)
+	}
+	return db, store
+}
+
+func TestHelpers(t *testing.T) {
+	ctx := context.TODO()
+	db, store := newDB(t)
+	fields := []mysql.Field{"id", "name", "age"}
+
+	var users []user
+	err := mysql.SelectRows(ctx, db, nil, "users", fields,
+		[]mysql.WhereClause{{"name": []string{"foo", "baz"}}, {"id": 2}}, &users, mysql.WithExtra("ORDER BY age DESC LIMIT 2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Name != "baz" || users[1].Name != "bar" {
+		t.Fatalf("unexpected users: %+v", users)
+	}
+
+	n, err := mysql.InsertRows(ctx, db, nil, "users", []mysql.Field{"name", "age"}, [][]mysql.Value{{"qux", 40}, {"quux", 50}})
+	if err != nil || n != 2 {
+		t.Fatalf("insert rows failed: %d, %v", n, err)
+	}
+	if rows := store.Rows("users"); len(rows) != 5 || rows[4]["id"] != int64(5) {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+
+	_, err = mysql.InsertRows(ctx, db, nil, "users", []mysql.Field{"id", "name"}, [][]mysql.Value{{1, "dup"}})
+	if !goerrors.IsConflictError(err) {
+		t.Fatalf("expect conflict error, got %v", err)
+	}
+
+	n, err = mysql.UpdateRows(ctx, db, nil, "users", map[mysql.Field]mysql.Value{"age": 19}, []mysql.WhereClause{{"name": "foo"}})
+	if err != nil || n != 1 {
+		t.Fatalf("update rows failed: %d, %v", n, err)
+	}
+
+	n, err = mysql.DeleteRows(ctx, db, nil, "users", []mysql.WhereClause{{"age": []int{19, 20}}})
+	if err != nil || n != 2 {
+		t.Fatalf("delete rows failed: %d, %v", n, err)
+	}
+
+	var count int
+	if err := db.Get(&count, "SELECT COUNT(*) FROM users WHERE age >= ?", 30); err != nil || count != 3 {
+		t.Fatalf("count failed: %d, %v", count, err)
+	}
+}
+
+// countingDB is a mock of the db handler counting the statements
+type countingDB struct {
+	mysql.ExecQueryer
+	execs int
+}
+
+func (c *countingDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	c.execs++
+	return c.ExecQueryer.ExecContext(ctx, query, args...)
+}
+
+func TestHelpersWithExecQueryer(t *testing.T) {
+	ctx := context.TODO()
+	db, _ := newDB(t)
+	mock := &countingDB{ExecQueryer: db}
+	if _, err := mysql.UpdateRows(ctx, nil, mock, "users", map[mysql.Field]mysql.Value{"age": 21}, []mysql.WhereClause{{"name": "foo"}}); err != nil {
+		t.Fatal(err)
+	}
+	var nilDB *sqlx.DB
+	if _, err := mysql.DeleteRows(ctx, nilDB, mock, "users", []mysql.WhereClause{{"name": "bar"}}); err != nil {
+		t.Fatal(err)
+	}
+	if mock.execs != 2 {
+		t.Fatalf("expect 2 statements run by the mock, got %d", mock.execs)
+	}
+	if _, err := mysql.DeleteRows(ctx, nil, nil, "users", nil); err == nil {
+		t.Fatal("expect no db handler rejected")
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	ctx := context.TODO()
+	db, store := newDB(t)
+	cli := mysql.NewWithDB(db)
+
+	oops := errors.New("oops")
+	err := cli.RunInTx(ctx, func(tx mysql.ExecQueryer) error {
+		if _, err := tx.Exec("DELETE FROM users"); err != nil {
+			return err
+		}
+		return oops
+	})
+	if err != oops {
+		t.Fatalf("expect tx failed with %v, got %v", oops, err)
+	}
+	if len(store.Rows("users")) != 3 {
+		t.Fatal("tx should be rollbacked")
+	}
+
+	err = cli.RunInTx(ctx, func(tx mysql.ExecQueryer) error {
+		_, err := tx.Exec("UPDATE users SET age = ? WHERE id = ?", 99, 1)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u user
+	if err := db.Get(&u, "SELECT id, name, age FROM users WHERE id = 1"); err != nil || u.Age != 99 {
+		t.Fatalf("tx should be committed: %+v, %v", u, err)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	db, store := testkit.NewDB()
+	store.Strict(true)
+	if _, err := db.Exec("INSERT INTO users (name) VALUES (?)", "foo"); err == nil {
+		t.Fatal("expect insert into an unknown table failed")
+	}
+	store.CreateTable("users", []string{"id", "name"}, []string{"name"})
+	if _, err := db.Exec("INSERT INTO users (name) VALUES (?)", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES (?)", "foo"); err == nil {
+		t.Fatal("expect duplicate entry on the unique key")
+	}
+	if _, err := db.Exec("INSERT INTO users (age) VALUES (?)", 1); err == nil {
+		t.Fatal("expect unknown column failed")
+	}
+}
@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/retry"
+)
+
+// EmailConfig is the config of the SMTP server and the mail addresses
+type EmailConfig struct {
+	// Addr is the address of the SMTP server like "smtp.example.com:587",
+	// STARTTLS is used if the server supports it
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+type email struct {
+	cfg EmailConfig
+}
+
+// NewEmail creates a notifier which sends the messages by SMTP, the title is the subject of the mails
+func NewEmail(cfg EmailConfig, ops ...Option) Notifier {
+	opts := newOptions(ops)
+	if cfg.From == "" || len(cfg.To) == 0 {
+		opts.err = errors.NewBadRequestError("from and to addresses are required")
+	}
+	return newNotifier("email", &email{cfg: cfg}, opts)
+}
+
+func (e *email) send(ctx context.Context, msg *Message) error {
+	err := e.sendMail(ctx, buildMail(e.cfg.From, e.cfg.To, msg, time.Now()))
+	if ctx.Err() != nil {
+		// the connection is closed by the cancellation, report it instead of the closed connection
+		return ctx.Err()
+	}
+	if _, ok := err.(net.Error); ok {
+		return retry.NewRetriableError(err.Error())
+	}
+	return err
+}
+
+// sendMail works like smtp.SendMail, but the connection is bound to ctx: the deadline of ctx is set on it,
+// and it is closed once ctx is done, so a hung server never blocks or leaks the sender
+func (e *email) sendMail(ctx context.Context, mail []byte) error {
+	host, _, _ := net.SplitHostPort(e.cfg.Addr)
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", e.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if e.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range e.cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(mail); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func buildMail(from string, to []string, msg *Message, now time.Time) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", from)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", fmt.Sprintf("[%s] %s", msg.Level, msg.Title)))
+	fmt.Fprintf(buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	// normalize the line breaks as required by the SMTP
+	buf.WriteString(strings.Replace(strings.Replace(msg.Text, "\r\n", "\n", -1), "\n", "\r\n", -1))
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,227 @@
+// Package notify sends the alerts to the email, generic webhooks and the chat robots(DingTalk, Slack...).
+//
+// All the notifiers support the text templates, rate limiting and retrying by the options:
+//
+//	n := notify.NewSlack(webhookURL,
+//		notify.WithTemplate("[{{.Level}}] {{.Title}}", "{{.Text}}\nhost: {{.Data.host}}"),
+//		notify.WithRateLimit(10, time.Minute),
+//		notify.WithRetry(3, time.Second))
+//	n.Notify(ctx, &notify.Message{Level: notify.LevelError, Title: "panic", Text: stack, Data: map[string]string{"host": host}})
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/retry"
+	"github.com/tools-go/go-utils/trace"
+)
+
+// ErrRateLimited is returned if the message is dropped by the rate limiter
+var ErrRateLimited = errors.New("notification dropped by the rate limiter")
+
+// Level of the message
+type Level string
+
+// Predefined levels
+const (
+	LevelInfo    Level = "INFO"
+	LevelWarning Level = "WARNING"
+	LevelError   Level = "ERROR"
+)
+
+// Message is the notification to send, Data is used by the templates only
+type Message struct {
+	Level Level
+	Title string
+	Text  string
+	Data  interface{}
+}
+
+// Notifier sends the messages
+type Notifier interface {
+	Notify(ctx context.Context, msg *Message) error
+}
+
+// sender is implemented by the backends, and wrapped by the common options
+type sender interface {
+	send(ctx context.Context, msg *Message) error
+}
+
+type options struct {
+	title       *template.Template
+	text        *template.Template
+	err         error
+	limit       int
+	per         time.Duration
+	attempts    int
+	interval    time.Duration
+	httpTimeout time.Duration
+}
+
+// Option for the notifiers
+type Option func(opts *options)
+
+// WithTemplate set the text/template of the title and the text, which are executed with the *Message,
+// so the message fields can be referenced like {{.Title}} and {{.Data.xxx}}; an empty template keeps the field as is
+func WithTemplate(title, text string) Option {
+	return func(opts *options) {
+		if title != "" {
+			opts.title, opts.err = template.New("title").Parse(title)
+			if opts.err != nil {
+				return
+			}
+		}
+		if text != "" {
+			opts.text, opts.err = template.New("text").Parse(text)
+		}
+	}
+}
+
+// WithRateLimit allows at most limit messages per duration, the others are dropped with ErrRateLimited,
+// to prevent the alert storms from flooding the receivers
+func WithRateLimit(limit int, per time.Duration) Option {
+	return func(opts *options) {
+		opts.limit = limit
+		opts.per = per
+	}
+}
+
+// WithRetry retries the failed sending, only the network errors, 429 and 5xx responses are retried
+func WithRetry(attempts int, interval time.Duration) Option {
+	return func(opts *options) {
+		opts.attempts = attempts
+		opts.interval = interval
+	}
+}
+
+// WithHTTPTimeout set the timeout of the webhook requests, default is 5s
+func WithHTTPTimeout(d time.Duration) Option {
+	return func(opts *options) {
+		opts.httpTimeout = d
+	}
+}
+
+func newOptions(ops []Option) *options {
+	opts := &options{attempts: 1, httpTimeout: 5 * time.Second}
+	for _, op := range ops {
+		op(opts)
+	}
+	if opts.attempts <= 0 {
+		opts.attempts = 1
+	}
+	return opts
+}
+
+type notifier struct {
+	name    string
+	sender  sender
+	opts    *options
+	limiter *limiter
+}
+
+func newNotifier(name string, s sender, opts *options) Notifier {
+	n := &notifier{name: name, sender: s, opts: opts}
+	if opts.limit > 0 && opts.per > 0 {
+		n.limiter = newLimiter(opts.limit, opts.per)
+	}
+	return n
+}
+
+func (n *notifier) Notify(ctx context.Context, msg *Message) error {
+	if n.opts.err != nil {
+		return n.opts.err
+	}
+	if n.limiter != nil && !n.limiter.allow(time.Now()) {
+		return ErrRateLimited
+	}
+	rendered, err := n.render(msg)
+	if err != nil {
+		return err
+	}
+	tracer := trace.GetTraceFromContext(ctx)
+	err = retry.Do(n.opts.attempts, func() error {
+		err := n.sender.send(ctx, rendered)
+		if err != nil {
+			tracer.Warnf("send %s notification failed: %s", n.name, err)
+		}
+		return err
+	}, n.opts.interval)
+	if err != nil {
+		return fmt.Errorf("send %s notification failed: %s", n.name, err)
+	}
+	return nil
+}
+
+func (n *notifier) render(msg *Message) (*Message, error) {
+	rendered := *msg
+	if rendered.Level == "" {
+		rendered.Level = LevelInfo
+	}
+	for _, t := range []struct {
+		tmpl *template.Template
+		out  *string
+	}{{n.opts.title, &rendered.Title}, {n.opts.text, &rendered.Text}} {
+		if t.tmpl == nil {
+			continue
+		}
+		buf := &bytes.Buffer{}
+		if err := t.tmpl.Execute(buf, msg); err != nil {
+			return nil, fmt.Errorf("render %s template failed: %s", t.tmpl.Name(), err)
+		}
+		*t.out = buf.String()
+	}
+	return &rendered, nil
+}
+
+// limiter is a fixed window rate limiter, which is enough for the notifications
+type limiter struct {
+	sync.Mutex
+	limit int
+	per   time.Duration
+	start time.Time
+	count int
+}
+
+func newLimiter(limit int, per time.Duration) *limiter {
+	return &limiter{limit: limit, per: per}
+}
+
+func (l *limiter) allow(now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+	if now.Sub(l.start) >= l.per {
+		l.start, l.count = now, 0
+	}
+	if l.count >= l.limit {
+		return false
+	}
+	l.count++
+	return true
+}
+
+type multi []Notifier
+
+// Multi sends the messages with all the notifiers, and returns the errors of the failed ones
+func Multi(notifiers ...Notifier) Notifier {
+	return multi(notifiers)
+}
+
+func (m multi) Notify(ctx context.Context, msg *Message) error {
+	var errs []string
+	for _, n := range m {
+		if err := n.Notify(ctx, msg); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	var (
+		calls int32
+		body  map[string]interface{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+
+	n := NewWebhook(server.URL,
+		WithTemplate("[{{.Level}}] {{.Title}}", "{{.Text}} on {{.Data.host}}"),
+		WithRetry(3, time.Millisecond))
+	err := n.Notify(context.TODO(), &Message{Level: LevelError, Title: "panic", Text: "nil pointer", Data: map[string]string{"host": "h1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || body["title"] != "[ERROR] panic" || body["text"] != "nil pointer on h1" {
+		t.Fatalf("unexpected calls %d, body %v", calls, body)
+	}
+
+	if err := NewWebhook(server.URL, WithTemplate("{{.Bad", "")).Notify(context.TODO(), &Message{}); err == nil {
+		t.Fatal("expect invalid template")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	n := NewSlack(server.URL, WithRateLimit(2, time.Hour))
+	for i := 0; i < 3; i++ {
+		err := n.Notify(context.TODO(), &Message{Title: "t"})
+		if i < 2 && err != nil || i == 2 && err != ErrRateLimited {
+			t.Fatalf("unexpected error of #%d: %v", i, err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("expect 2 calls, got %d", calls)
+	}
+}
+
+func TestDingTalk(t *testing.T) {
+	var text string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("access_token") != "token" || r.URL.Query().Get("sign") == "" {
+			w.Write([]byte(`{"errcode":310000,"errmsg":"sign not match"}`))
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		text = string(data)
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	defer server.Close()
+
+	err := NewDingTalk(server.URL+"?access_token=token", "secret").Notify(context.TODO(), &Message{Title: "disk full", Text: "/data"})
+	if err != nil || !strings.Contains(text, `### [INFO] disk full\n\n/data`) {
+		t.Fatalf("unexpected result: %s, %v", text, err)
+	}
+	err = NewDingTalk(server.URL+"?access_token=token", "").Notify(context.TODO(), &Message{Title: "disk full"})
+	if err == nil || !strings.Contains(err.Error(), "310000") {
+		t.Fatalf("expect sign error, got %v", err)
+	}
+}
+
+func TestMulti(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	n := Multi(NewSlack(server.URL), NewEmail(EmailConfig{}))
+	err := n.Notify(context.TODO(), &Message{Title: "t"})
+	if err == nil || !strings.Contains(err.Error(), "from and to addresses are required") {
+		t.Fatalf("expect email config error, got %v", err)
+	}
+}
+
+func TestBuildMail(t *testing.T) {
+	mail := string(buildMail("a@example.com", []string{"b@example.com", "c@example.com"},
+		&Message{Level: LevelWarning, Title: "磁盘", Text: "line1\nline2"}, time.Unix(0, 0).UTC()))
+	for _, expect := range []string{
+		"To: b@example.com, c@example.com\r\n",
+		"Subject: =?utf-8?q?[WARNING]_=E7=A3=81=E7=9B=98?=\r\n",
+		"\r\n\r\nline1\r\nline2\r\n",
+	} {
+		if !strings.Contains(mail, expect) {
+			t.Fatalf("expect %q in mail:\n%s", expect, mail)
+		}
+	}
+}
+
+func TestEmailHungServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	closed := make(chan struct{})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		// never greets, the sender should close the connection when ctx is done
+		ioutil.ReadAll(conn)
+		close(closed)
+	}()
+
+	e := &email{cfg: EmailConfig{Addr: l.Addr().String(), From: "a@example.com", To: []string{"b@example.com"}}}
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	if err := e.send(ctx, &Message{Title: "t"}); err != context.DeadlineExceeded {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("expect the connection closed")
+	}
+}
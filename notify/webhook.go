@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/tools-go/go-utils/retry"
+)
+
+// postJSON posts the body as json, and decodes the response into result if it's not nil,
+// the network errors, 429 and 5xx responses are retriable
+func postJSON(ctx context.Context, client *http.Client, api string, body, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, api, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return retry.NewRetriableError(err.Error())
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return retry.NewRetriableError(fmt.Sprintf("unexpected response %s: %s", resp.Status, respBody))
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, respBody)
+	}
+	if result != nil {
+		if err := json.Unmarshal(respBody, result); err != nil {
+			return fmt.Errorf("invalid response %q: %s", respBody, err)
+		}
+	}
+	return nil
+}
+
+type webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook creates a notifier which posts the messages as json to the url, like:
+//
+//	{"level":"ERROR","title":"...","text":"...","data":{...}}
+func NewWebhook(url string, ops ...Option) Notifier {
+	opts := newOptions(ops)
+	return newNotifier("webhook", &webhook{url: url, client: &http.Client{Timeout: opts.httpTimeout}}, opts)
+}
+
+func (w *webhook) send(ctx context.Context, msg *Message) error {
+	return postJSON(ctx, w.client, w.url, map[string]interface{}{
+		"level": msg.Level,
+		"title": msg.Title,
+		"text":  msg.Text,
+		"data":  msg.Data,
+	}, nil)
+}
+
+type dingTalk struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewDingTalk creates a notifier of the DingTalk robot, the messages are sent as markdown,
+// secret is used to sign the requests if the robot is secured by the signature
+func NewDingTalk(webhookURL, secret string, ops ...Option) Notifier {
+	opts := newOptions(ops)
+	return newNotifier("dingtalk", &dingTalk{url: webhookURL, secret: secret, client: &http.Client{Timeout: opts.httpTimeout}}, opts)
+}
+
+func (d *dingTalk) signedURL(now time.Time) (string, error) {
+	if d.secret == "" {
+		return d.url, nil
+	}
+	u, err := url.Parse(d.url)
+	if err != nil {
+		return "", err
+	}
+	timestamp := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	h := hmac.New(sha256.New, []byte(d.secret))
+	h.Write([]byte(timestamp + "\n" + d.secret))
+	query := u.Query()
+	query.Set("timestamp", timestamp)
+	query.Set("sign", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func (d *dingTalk) send(ctx context.Context, msg *Message) error {
+	api, err := d.signedURL(time.Now())
+	if err != nil {
+		return err
+	}
+	result := &struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}{}
+	err = postJSON(ctx, d.client, api, map[string]interface{}{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"title": msg.Title,
+			"text":  fmt.Sprintf("### [%s] %s\n\n%s", msg.Level, msg.Title, msg.Text),
+		},
+	}, result)
+	if err != nil {
+		return err
+	}
+	if result.ErrCode != 0 {
+		// 130101: too many messages in a minute
+		if result.ErrCode == 130101 {
+			return retry.NewRetriableError(result.ErrMsg)
+		}
+		return fmt.Errorf("dingtalk error %d: %s", result.ErrCode, result.ErrMsg)
+	}
+	return nil
+}
+
+type slack struct {
+	url    string
+	client *http.Client
+}
+
+// NewSlack creates a notifier of the Slack incoming webhook, which also works with
+// the Slack compatible webhooks of Mattermost, Rocket.Chat and so on
+func NewSlack(webhookURL string, ops ...Option) Notifier {
+	opts := newOptions(ops)
+	return newNotifier("slack", &slack{url: webhookURL, client: &http.Client{Timeout: opts.httpTimeout}}, opts)
+}
+
+func (s *slack) send(ctx context.Context, msg *Message) error {
+	return postJSON(ctx, s.client, s.url, map[string]interface{}{
+		"text": fmt.Sprintf("*[%s] %s*\n%s", msg.Level, msg.Title, msg.Text),
+	}, nil)
+}
@@ -0,0 +1,213 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tools-go/go-utils/jwt"
+	"github.com/tools-go/go-utils/trace"
+)
+
+// the unknown kids refetch the keys at most once in minRefetchInterval,
+// so the forged tokens can not flood the provider
+const minRefetchInterval = time.Minute
+
+// JWKS is a jwt.KeySet of the JSON web keys of a provider, which are cached with a TTL,
+// and refetched when a token is signed by an unknown key, e.g. after the provider rotated its keys
+type JWKS struct {
+	url    string
+	client *http.Client
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	keys    []*jwt.Key
+	fetched time.Time
+	call    *jwksCall // the fetch in flight, nil if none
+}
+
+// jwksCall is a fetch of the keys shared by the callers, err is set before done is closed
+type jwksCall struct {
+	done chan struct{}
+	err  error
+}
+
+// NewJWKS creates the key set of the JWKS URL, ttl is 1 hour if not positive
+func NewJWKS(url string, client *http.Client, ttl time.Duration) *JWKS {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	return &JWKS{url: url, client: client, ttl: ttl}
+}
+
+// SigningKey implements jwt.KeySet, the provider keys never sign
+func (s *JWKS) SigningKey(ctx context.Context) (*jwt.Key, error) {
+	return nil, jwt.ErrNoSigningKey
+}
+
+// VerifyingKey implements jwt.KeySet, the stale keys are used while they are being refetched
+func (s *JWKS) VerifyingKey(ctx context.Context, kid string, alg jwt.Algorithm) (*jwt.Key, error) {
+	err := s.refresh(ctx, s.ttl, false)
+	key, cached := s.find(kid, alg)
+	if key != nil {
+		return key, nil
+	}
+	if err != nil && !cached {
+		return nil, err
+	}
+	// the unknown key waits for the fetch in flight, or refetches the keys
+	if err := s.refresh(ctx, minRefetchInterval, true); err != nil {
+		return nil, err
+	}
+	if key, _ := s.find(kid, alg); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: kid %q alg %s", jwt.ErrUnknownKey, kid, alg)
+}
+
+// find returns the key of kid and alg, and whether any keys are cached
+func (s *JWKS) find(kid string, alg jwt.Algorithm) (*jwt.Key, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range s.keys {
+		if key.Alg == alg && (kid == "" || key.ID == kid) {
+			return key, true
+		}
+	}
+	return nil, s.keys != nil
+}
+
+// refresh fetches the keys if they were fetched longer than interval ago, the lock is not held
+// during the fetch, which is shared by the concurrent callers. If a fetch is in flight, it's waited
+// for if wait is set. The stale keys are kept if the fetch fails.
+func (s *JWKS) refresh(ctx context.Context, interval time.Duration, wait bool) error {
+	s.mu.Lock()
+	if call := s.call; call != nil {
+		s.mu.Unlock()
+		if !wait {
+			return nil
+		}
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if time.Since(s.fetched) <= interval {
+		s.mu.Unlock()
+		return nil
+	}
+	call := &jwksCall{done: make(chan struct{})}
+	// the failures are not retried until the next refetch interval
+	s.call, s.fetched = call, time.Now()
+	s.mu.Unlock()
+
+	keys, err := s.fetch(ctx)
+	s.mu.Lock()
+	if err == nil {
+		s.keys = keys
+	}
+	s.call = nil
+	s.mu.Unlock()
+	call.err = err
+	close(call.done)
+	return err
+}
+
+// fetch loads the keys from the provider
+func (s *JWKS) fetch(ctx context.Context) ([]*jwt.Key, error) {
+	doc := &struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := getJSON(ctx, s.client, s.url, doc); err != nil {
+		trace.GetTraceFromContext(ctx).Warnf("fetch jwks %s failed: %s", s.url, err)
+		return nil, err
+	}
+	keys := make([]*jwt.Key, 0, len(doc.Keys))
+	for _, jwk := range doc.Keys {
+		key, err := jwk.key()
+		if err != nil {
+			trace.GetTraceFromContext(ctx).Warnf("skip the key %s of jwks %s: %s", jwk.Kid, s.url, err)
+			continue
+		}
+		if key != nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// key converts the JWK, nil is returned for the keys of the encryption or the unsupported algorithms
+func (k jsonWebKey) key() (*jwt.Key, error) {
+	if k.Use == "enc" {
+		return nil, nil
+	}
+	switch {
+	case k.Kty == "RSA" && (k.Alg == "" || k.Alg == string(jwt.RS256)):
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &jwt.Key{ID: k.Kid, Alg: jwt.RS256, Public: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case k.Kty == "EC" && k.Crv == "P-256" && (k.Alg == "" || k.Alg == string(jwt.ES256)):
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x.Bytes()) > 32 || len(y.Bytes()) > 32 {
+			return nil, fmt.Errorf("invalid P-256 point")
+		}
+		point := make([]byte, 65)
+		point[0] = 4 // uncompressed
+		x.FillBytes(point[1:33])
+		y.FillBytes(point[33:])
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, err
+		}
+		return &jwt.Key{ID: k.Kid, Alg: jwt.ES256, Public: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}, nil
+	}
+	return nil, nil
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid base64url integer %q", s)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,284 @@
+// Package oidc is an OpenID Connect client of the authorization code flow with PKCE,
+// it refreshes the tokens, verifies the ID tokens by the cached JWKS of the provider,
+// and keeps the user sessions of the admin consoles by a gin middleware.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tools-go/go-utils/jwt"
+)
+
+// Config is the config of a client registered in the provider
+type Config struct {
+	// Issuer is the issuer URL of the provider, the endpoints are discovered from
+	// Issuer + "/.well-known/openid-configuration"
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes are requested besides "openid", like "profile", "email", "offline_access"
+	Scopes []string
+	// Leeway tolerates the clock skew when verifying the ID tokens, 1 minute by default
+	Leeway time.Duration
+	// JWKSTTL is the cache TTL of the provider keys, 1 hour by default
+	JWKSTTL time.Duration
+	Client  *http.Client
+}
+
+// Endpoints are the discovered endpoints of the provider
+type Endpoints struct {
+	Issuer        string `json:"issuer"`
+	Authorization string `json:"authorization_endpoint"`
+	Token         string `json:"token_endpoint"`
+	UserInfo      string `json:"userinfo_endpoint"`
+	JWKS          string `json:"jwks_uri"`
+	EndSession    string `json:"end_session_endpoint"`
+}
+
+// Token is the token response of the provider
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	IDToken      string    `json:"id_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// IDTokenClaims are the claims of the ID tokens
+type IDTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string   `json:"nonce,omitempty"`
+	Name              string   `json:"name,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	EmailVerified     bool     `json:"email_verified,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+}
+
+// Error is the error response of the token endpoint, like "invalid_grant"
+type Error struct {
+	Status      int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oidc error %s: %s", e.Code, e.Description)
+	}
+	return fmt.Sprintf("oidc error %s", e.Code)
+}
+
+// IsInvalidGrant judges whether err means the code or the refresh token is invalid or expired,
+// so the user should log in again
+func IsInvalidGrant(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Code == "invalid_grant"
+}
+
+// Client is the client of a provider
+type Client struct {
+	cfg       Config
+	endpoints Endpoints
+	keys      *JWKS
+	verifier  *jwt.Manager
+}
+
+// New discovers the endpoints of the provider and creates a client
+func New(ctx context.Context, cfg Config) (*Client, error) {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.Leeway == 0 {
+		cfg.Leeway = time.Minute
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+
+	c := &Client{cfg: cfg}
+	if err := c.get(ctx, cfg.Issuer+"/.well-known/openid-configuration", &c.endpoints); err != nil {
+		return nil, fmt.Errorf("discover %s failed: %s", cfg.Issuer, err)
+	}
+	if c.endpoints.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("issuer mismatch, expect %s, got %s", cfg.Issuer, c.endpoints.Issuer)
+	}
+	if c.endpoints.Authorization == "" || c.endpoints.Token == "" || c.endpoints.JWKS == "" {
+		return nil, fmt.Errorf("incomplete discovery document of %s", cfg.Issuer)
+	}
+	c.keys = NewJWKS(c.endpoints.JWKS, cfg.Client, cfg.JWKSTTL)
+	c.verifier = jwt.New(c.keys, jwt.WithIssuer(cfg.Issuer), jwt.WithAudience(cfg.ClientID), jwt.WithLeeway(cfg.Leeway))
+	return c, nil
+}
+
+// Endpoints returns the discovered endpoints
+func (c *Client) Endpoints() Endpoints {
+	return c.endpoints
+}
+
+// Keys returns the cached JWKS of the provider, which can verify the access tokens too if they are JWTs
+func (c *Client) Keys() *JWKS {
+	return c.keys
+}
+
+// NewPKCE generates a PKCE code verifier and its S256 challenge
+func NewPKCE() (verifier, challenge string) {
+	verifier = randomString(32)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// AuthURL returns the URL of the authorization endpoint to redirect the user to,
+// state and nonce should be random and verified on the callback, verifier is from NewPKCE
+func (c *Client) AuthURL(state, nonce, verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.cfg.ClientID},
+		"redirect_uri":          {c.cfg.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, c.cfg.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(c.endpoints.Authorization, "?") {
+		sep = "&"
+	}
+	return c.endpoints.Authorization + sep + q.Encode()
+}
+
+// Exchange exchanges the authorization code for the tokens
+func (c *Client) Exchange(ctx context.Context, code, verifier string) (*Token, error) {
+	return c.token(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	})
+}
+
+// Refresh gets the new tokens by the refresh token, the refresh token is kept if the provider doesn't rotate it
+func (c *Client) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	token, err := c.token(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+	return token, nil
+}
+
+func (c *Client) token(ctx context.Context, form url.Values) (*Token, error) {
+	form.Set("client_id", c.cfg.ClientID)
+	req, err := http.NewRequest(http.MethodPost, c.endpoints.Token, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	}
+	resp, err := c.cfg.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		e := &Error{Status: resp.StatusCode}
+		if json.Unmarshal(body, e) != nil || e.Code == "" {
+			return nil, fmt.Errorf("token request failed: %s %s", resp.Status, body)
+		}
+		return nil, e
+	}
+	result := &struct {
+		Token
+		ExpiresIn int64 `json:"expires_in"`
+	}{}
+	if err := json.Unmarshal(body, result); err != nil {
+		return nil, fmt.Errorf("invalid token response: %s", err)
+	}
+	if result.AccessToken == "" {
+		return nil, fmt.Errorf("invalid token response: no access_token")
+	}
+	if result.ExpiresIn > 0 {
+		result.Expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	return &result.Token, nil
+}
+
+// VerifyIDToken verifies the signature, the issuer, the audience, the times and the nonce of the ID token,
+// nonce is skipped if empty, e.g. the ID tokens of the refresh responses
+func (c *Client) VerifyIDToken(ctx context.Context, raw, nonce string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+	if err := c.verifier.Verify(ctx, raw, claims); err != nil {
+		return nil, err
+	}
+	if nonce != "" && claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", jwt.ErrClaims)
+	}
+	return claims, nil
+}
+
+// UserInfo gets the claims of the user from the userinfo endpoint by the access token
+func (c *Client) UserInfo(ctx context.Context, accessToken string, claims interface{}) error {
+	if c.endpoints.UserInfo == "" {
+		return fmt.Errorf("provider %s has no userinfo endpoint", c.cfg.Issuer)
+	}
+	return c.get(ctx, c.endpoints.UserInfo, claims, "Bearer "+accessToken)
+}
+
+func (c *Client) get(ctx context.Context, api string, v interface{}, authorization ...string) error {
+	return getJSON(ctx, c.cfg.Client, api, v, authorization...)
+}
+
+func getJSON(ctx context.Context, client *http.Client, api string, v interface{}, authorization ...string) error {
+	req, err := http.NewRequest(http.MethodGet, api, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if len(authorization) > 0 {
+		req.Header.Set("Authorization", authorization[0])
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("get %s failed: %s %s", api, resp.Status, body)
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,386 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tools-go/go-utils/dtrace"
+	"github.com/tools-go/go-utils/jwt"
+)
+
+// fakeProvider is a minimal provider issuing the RS256 ID tokens
+type fakeProvider struct {
+	*httptest.Server
+	t       *testing.T
+	signer  *jwt.Manager
+	key     *jwt.Key
+	mu      sync.Mutex
+	codes   map[string][2]string // code -> nonce, challenge
+	refresh map[string]bool
+	expires int
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	key, err := jwt.ParseKey(jwt.RS256, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{t: t, key: key, codes: map[string][2]string{}, refresh: map[string]bool{}, expires: 3600}
+	mux := http.NewServeMux()
+	p.Server = httptest.NewServer(mux)
+	p.signer = jwt.New(jwt.StaticKeys(key), jwt.WithIssuer(p.URL), jwt.WithAudience("console"))
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Endpoints{
+			Issuer:        p.URL,
+			Authorization: p.URL + "/authorize",
+			Token:         p.URL + "/token",
+			JWKS:          p.URL + "/jwks",
+			EndSession:    p.URL + "/logout",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		pub := key.Public.(*rsa.PublicKey)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": key.ID, "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "console" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(Error{Code: "invalid_client"})
+			return
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		nonce := ""
+		switch r.PostFormValue("grant_type") {
+		case "authorization_code":
+			code, ok := p.codes[r.PostFormValue("code")]
+			sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+			if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != code[1] {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(Error{Code: "invalid_grant"})
+				return
+			}
+			delete(p.codes, r.PostFormValue("code"))
+			nonce = code[0]
+		case "refresh_token":
+			if !p.refresh[r.PostFormValue("refresh_token")] {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(Error{Code: "invalid_grant", Description: "token revoked"})
+				return
+			}
+		}
+		idToken, _ := p.signer.Issue(r.Context(), &IDTokenClaims{
+			RegisteredClaims:  jwt.RegisteredClaims{Subject: "u1"},
+			Nonce:             nonce,
+			PreferredUsername: "alice",
+		})
+		refresh := "rt-" + idToken[len(idToken)-8:]
+		p.refresh[refresh] = true
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "at", "token_type": "Bearer", "expires_in": p.expires,
+			"id_token": idToken, "refresh_token": refresh,
+		})
+	})
+	return p
+}
+
+// authorize plays the user approving the login at the provider
+func (p *fakeProvider) authorize(authURL string) string {
+	u, err := url.Parse(authURL)
+	if err != nil || !strings.HasPrefix(authURL, p.URL+"/authorize?") {
+		p.t.Fatalf("unexpected auth url %s", authURL)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("scope") != "openid profile" {
+		p.t.Fatalf("unexpected auth url %s", authURL)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.codes["code1"] = [2]string{q.Get("nonce"), q.Get("code_challenge")}
+	return "/auth/callback?code=code1&state=" + url.QueryEscape(q.Get("state"))
+}
+
+func newClient(t *testing.T, p *fakeProvider) *Client {
+	client, err := New(context.Background(), Config{
+		Issuer:       p.URL + "/",
+		ClientID:     "console",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://console/auth/callback",
+		Scopes:       []string{"profile"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	p := newFakeProvider(t)
+	defer p.Close()
+	client := newClient(t, p)
+
+	token, _ := p.signer.Issue(context.Background(), &IDTokenClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "u1"}, Nonce: "n1"})
+	claims, err := client.VerifyIDToken(context.Background(), token, "n1")
+	if err != nil || claims.Subject != "u1" {
+		t.Fatalf("unexpected %v %v", claims, err)
+	}
+	if _, err := client.VerifyIDToken(context.Background(), token, "n2"); !errors.Is(err, jwt.ErrInvalidToken) {
+		t.Fatalf("expect nonce mismatch, got %v", err)
+	}
+	other, _ := jwt.New(jwt.StaticKeys(p.key), jwt.WithIssuer(p.URL), jwt.WithAudience("other")).
+		Issue(context.Background(), &IDTokenClaims{})
+	if _, err := client.VerifyIDToken(context.Background(), other, ""); !errors.Is(err, jwt.ErrClaims) {
+		t.Fatalf("expect audience mismatch, got %v", err)
+	}
+}
+
+func TestJWKSFetchUnlocked(t *testing.T) {
+	p := newFakeProvider(t)
+	defer p.Close()
+	// the refetches are blocked until released
+	var fetches int32
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-block
+		}
+		resp, err := http.Get(p.URL + "/jwks")
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(w, resp.Body)
+	}))
+	defer srv.Close()
+	release := func() {
+		select {
+		case <-block:
+		default:
+			close(block)
+		}
+	}
+	defer release()
+
+	ctx := context.Background()
+	keys := NewJWKS(srv.URL, nil, 20*time.Millisecond)
+	if _, err := keys.VerifyingKey(ctx, p.key.ID, jwt.RS256); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	refetched := make(chan error, 1)
+	go func() {
+		_, err := keys.VerifyingKey(ctx, p.key.ID, jwt.RS256)
+		refetched <- err
+	}()
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the stale keys are used while they are being refetched, and the unknown keys wait for the same fetch
+	stale := make(chan error, 1)
+	go func() {
+		_, err := keys.VerifyingKey(ctx, p.key.ID, jwt.RS256)
+		stale <- err
+	}()
+	select {
+	case err := <-stale:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the stale keys used during the refetch")
+	}
+	unknown := make(chan error, 1)
+	go func() {
+		_, err := keys.VerifyingKey(ctx, "rotated", jwt.RS256)
+		unknown <- err
+	}()
+	select {
+	case err := <-unknown:
+		t.Fatalf("expect the unknown key waiting for the refetch, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	if err := <-refetched; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-unknown; !errors.Is(err, jwt.ErrUnknownKey) {
+		t.Fatalf("expect unknown key, got %v", err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Fatalf("expect the keys fetched twice, got %d", n)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	p := newFakeProvider(t)
+	defer p.Close()
+	sessions, err := newClient(t, p).Sessions(SessionConfig{Key: []byte("0123456789abcdef0123456789abcdef")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/auth/login", sessions.Login)
+	r.GET("/auth/callback", sessions.Callback)
+	r.GET("/auth/logout", sessions.Logout)
+	r.GET("/admin", sessions.Middleware().HandlerFunc(func(c *gin.Context) {
+		user, err := dtrace.GetUserInfoFromContext(c)
+		if err != nil || CurrentSession(c).Subject != "u1" {
+			t.Fatalf("unexpected user %s %v", user, err)
+		}
+		c.String(http.StatusOK, user)
+	}))
+
+	var cookies []*http.Cookie
+	do := func(path string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", accept)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		for _, set := range w.Result().Cookies() {
+			kept := cookies[:0]
+			for _, c := range cookies {
+				if c.Name != set.Name {
+					kept = append(kept, c)
+				}
+			}
+			cookies = kept
+			if set.MaxAge >= 0 {
+				cookies = append(cookies, set)
+			}
+		}
+		return w
+	}
+
+	if w := do("/admin", "application/json"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+	w := do("/admin?tab=1", "text/html")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/auth/login?return_to=%2Fadmin%3Ftab%3D1" {
+		t.Fatalf("unexpected redirection %d %s", w.Code, w.Header().Get("Location"))
+	}
+	w = do(w.Header().Get("Location"), "text/html")
+	callback := p.authorize(w.Header().Get("Location"))
+
+	if w := do(strings.Replace(callback, "state=", "state=x", 1), "text/html"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expect state mismatch, got %d", w.Code)
+	}
+	if w := do(callback, "text/html"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expect the state to be consumed, got %d", w.Code)
+	}
+
+	// login again
+	callback = p.authorize(do("/auth/login?return_to=/admin", "text/html").Header().Get("Location"))
+	w = do(callback, "text/html")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/admin" {
+		t.Fatalf("unexpected callback %d %s", w.Code, w.Body.String())
+	}
+	if w := do("/admin", "application/json"); w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Fatalf("unexpected %d %s", w.Code, w.Body.String())
+	}
+
+	// a tampered cookie
+	saved := cookies
+	cookies = []*http.Cookie{{Name: "oidc_session", Value: saved[0].Value[:len(saved[0].Value)-2] + "AA"}}
+	if w := do("/admin", "application/json"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expect tampered cookie rejected, got %d", w.Code)
+	}
+	cookies = saved
+
+	w = do("/auth/logout", "text/html")
+	if w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), p.URL+"/logout?client_id=console") {
+		t.Fatalf("unexpected logout %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if w := do("/admin", "application/json"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expect logged out, got %d", w.Code)
+	}
+}
+
+func TestSafeReturnTo(t *testing.T) {
+	for in, out := range map[string]string{
+		"/admin?tab=1":     "/admin?tab=1",
+		"":                 "/",
+		"//evil.com":       "/",
+		"/\\evil.com":      "/",
+		"https://evil.com": "/",
+	} {
+		if got := safeReturnTo(in); got != out {
+			t.Fatalf("%s: unexpected %s", in, got)
+		}
+	}
+}
+
+func TestSessionRefresh(t *testing.T) {
+	p := newFakeProvider(t)
+	defer p.Close()
+	p.expires = 1
+	sessions, _ := newClient(t, p).Sessions(SessionConfig{Key: []byte("0123456789abcdef")})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/auth/login", sessions.Login)
+	r.GET("/auth/callback", sessions.Callback)
+	r.GET("/admin", sessions.Middleware().HandlerFunc(func(c *gin.Context) {
+		c.String(http.StatusOK, CurrentSession(c).RefreshToken)
+	}))
+	serve := func(path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/auth/login", nil)
+	w = serve(p.authorize(w.Header().Get("Location")), w.Result().Cookies())
+	session := w.Result().Cookies()
+	time.Sleep(1100 * time.Millisecond)
+
+	w = serve("/admin", session)
+	if w.Code != http.StatusOK || len(w.Result().Cookies()) != 1 {
+		t.Fatalf("expect refreshed, got %d", w.Code)
+	}
+	refreshed := w.Result().Cookies()
+
+	// revoke all the refresh tokens
+	p.mu.Lock()
+	p.refresh = map[string]bool{}
+	p.mu.Unlock()
+	time.Sleep(1100 * time.Millisecond)
+	if w := serve("/admin", refreshed); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expect revoked, got %d", w.Code)
+	}
+}
@@ -0,0 +1,325 @@
+package oidc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tools-go/go-utils/dtrace"
+	"github.com/tools-go/go-utils/ginmiddleware"
+)
+
+// the gin context key of the current session
+const sessionKey = "oidc.session"
+
+// the lifetime of a login attempt
+const loginTimeout = 10 * time.Minute
+
+// SessionConfig is the config of the user sessions, which are kept in the encrypted cookies
+type SessionConfig struct {
+	// Key encrypts the cookies by AES-GCM, 16, 24 or 32 bytes, e.g. loaded from the secrets provider
+	Key []byte
+	// CookieName is "oidc_session" by default, the login state is kept in CookieName + "_state"
+	CookieName string
+	// CookiePath is "/" by default
+	CookiePath string
+	Secure     bool
+	// MaxAge is the lifetime of the sessions, 12 hours by default, the tokens are refreshed within it
+	MaxAge time.Duration
+	// LoginPath is the path of the Login handler, the unauthenticated page requests are redirected to it,
+	// "/auth/login" by default
+	LoginPath string
+	// AfterLogout is redirected to after the logout, "/" by default
+	AfterLogout string
+}
+
+// Session is the logged in user
+type Session struct {
+	Subject  string   `json:"sub"`
+	Username string   `json:"username,omitempty"`
+	Name     string   `json:"name,omitempty"`
+	Email    string   `json:"email,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	// RefreshToken is used to refresh the tokens after Expiry, the session is not refreshed without it
+	RefreshToken string    `json:"rt,omitempty"`
+	Expiry       time.Time `json:"exp,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+// User returns the name of the user in the logs and the audits
+func (s *Session) User() string {
+	switch {
+	case s.Username != "":
+		return s.Username
+	case s.Email != "":
+		return s.Email
+	}
+	return s.Subject
+}
+
+func (s *Session) update(claims *IDTokenClaims, token *Token) {
+	s.Subject = claims.Subject
+	s.Username = claims.PreferredUsername
+	s.Name = claims.Name
+	s.Email = claims.Email
+	s.Groups = claims.Groups
+	s.RefreshToken = token.RefreshToken
+	s.Expiry = token.Expiry
+}
+
+// CurrentSession returns the session established by the middleware of Sessions, nil if absent
+func CurrentSession(c *gin.Context) *Session {
+	s, _ := c.Value(sessionKey).(*Session)
+	return s
+}
+
+// loginState is kept during a login attempt
+type loginState struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	ReturnTo string    `json:"returnTo"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Sessions keeps the user sessions of the client
+type Sessions struct {
+	client *Client
+	cfg    SessionConfig
+	aead   cipher.AEAD
+}
+
+// Sessions creates the user sessions of the client
+func (c *Client) Sessions(cfg SessionConfig) (*Sessions, error) {
+	block, err := aes.NewCipher(cfg.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session key: %s", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CookieName == "" {
+		cfg.CookieName = "oidc_session"
+	}
+	if cfg.CookiePath == "" {
+		cfg.CookiePath = "/"
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = 12 * time.Hour
+	}
+	if cfg.LoginPath == "" {
+		cfg.LoginPath = "/auth/login"
+	}
+	if cfg.AfterLogout == "" {
+		cfg.AfterLogout = "/"
+	}
+	return &Sessions{client: c, cfg: cfg, aead: aead}, nil
+}
+
+// Middleware requires a session, the expired tokens are refreshed, and the user is set for
+// dtrace.GetUserInfoFromContext. The unauthenticated page requests are redirected to the LoginPath,
+// and the others get 401.
+func (s *Sessions) Middleware() ginmiddleware.Middleware {
+	return func(next gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			tracer := dtrace.GetTraceFromContext(c)
+			session := &Session{}
+			if err := s.readCookie(c, s.cfg.CookieName, session); err != nil || time.Since(session.Created) > s.cfg.MaxAge {
+				s.unauthenticated(c)
+				return
+			}
+			if session.RefreshToken != "" && !session.Expiry.IsZero() && time.Now().After(session.Expiry) {
+				if err := s.refresh(c, session); err != nil {
+					if IsInvalidGrant(err) || errors.Is(err, errSubjectChanged) {
+						tracer.Infof("session of %s is revoked: %s", session.User(), err)
+						s.clearCookie(c, s.cfg.CookieName)
+						s.unauthenticated(c)
+						return
+					}
+					// keep the session within its lifetime if the provider is unavailable
+					tracer.Warnf("refresh session of %s failed: %s", session.User(), err)
+				} else {
+					s.writeCookie(c, s.cfg.CookieName, session, s.cfg.MaxAge-time.Since(session.Created))
+				}
+			}
+			c.Set(sessionKey, session)
+			c.Set(dtrace.DefaultLoginUser, session.User())
+			next(c)
+		}
+	}
+}
+
+var errSubjectChanged = errors.New("subject of the refreshed ID token changed")
+
+func (s *Sessions) refresh(c *gin.Context, session *Session) error {
+	token, err := s.client.Refresh(c, session.RefreshToken)
+	if err != nil {
+		return err
+	}
+	if token.IDToken == "" {
+		session.RefreshToken, session.Expiry = token.RefreshToken, token.Expiry
+		return nil
+	}
+	claims, err := s.client.VerifyIDToken(c, token.IDToken, "")
+	if err != nil {
+		return err
+	}
+	if claims.Subject != session.Subject {
+		return errSubjectChanged
+	}
+	session.update(claims, token)
+	return nil
+}
+
+func (s *Sessions) unauthenticated(c *gin.Context) {
+	if c.Request.Method == http.MethodGet && strings.Contains(c.GetHeader("Accept"), "text/html") {
+		c.Redirect(http.StatusFound, s.cfg.LoginPath+"?return_to="+url.QueryEscape(c.Request.URL.RequestURI()))
+		c.Abort()
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": "unauthorized", "msg": "login required"})
+}
+
+// Login redirects the user to the provider, the "return_to" query parameter is redirected to after the callback
+func (s *Sessions) Login(c *gin.Context) {
+	verifier, _ := NewPKCE()
+	state := &loginState{
+		State:    randomString(16),
+		Nonce:    randomString(16),
+		Verifier: verifier,
+		ReturnTo: safeReturnTo(c.Query("return_to")),
+		Expires:  time.Now().Add(loginTimeout),
+	}
+	s.writeCookie(c, s.stateCookie(), state, loginTimeout)
+	c.Redirect(http.StatusFound, s.client.AuthURL(state.State, state.Nonce, state.Verifier))
+}
+
+// safeReturnTo accepts the local paths only, so the login can not be an open redirect
+func safeReturnTo(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		return "/"
+	}
+	return returnTo
+}
+
+// Callback handles the redirection of the provider at the RedirectURL, it exchanges the code,
+// verifies the ID token and establishes the session
+func (s *Sessions) Callback(c *gin.Context) {
+	tracer := dtrace.GetTraceFromContext(c)
+	state := &loginState{}
+	err := s.readCookie(c, s.stateCookie(), state)
+	s.clearCookie(c, s.stateCookie())
+	switch {
+	case err != nil || time.Now().After(state.Expires):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"code": "invalid_state", "msg": "login expired, please retry"})
+		return
+	case c.Query("state") != state.State:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"code": "invalid_state", "msg": "state mismatch"})
+		return
+	case c.Query("error") != "":
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": c.Query("error"), "msg": c.Query("error_description")})
+		return
+	}
+
+	token, err := s.client.Exchange(c, c.Query("code"), state.Verifier)
+	if err != nil {
+		tracer.Warnf("exchange the code failed: %s", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": "exchange_failed", "msg": "login failed, please retry"})
+		return
+	}
+	if token.IDToken == "" {
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"code": "no_id_token", "msg": "provider returned no id_token"})
+		return
+	}
+	claims, err := s.client.VerifyIDToken(c, token.IDToken, state.Nonce)
+	if err != nil {
+		tracer.Warnf("verify the id token failed: %s", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": "invalid_id_token", "msg": "login failed, please retry"})
+		return
+	}
+	session := &Session{Created: time.Now()}
+	session.update(claims, token)
+	s.writeCookie(c, s.cfg.CookieName, session, s.cfg.MaxAge)
+	tracer.Infof("user %s logged in", session.User())
+	c.Redirect(http.StatusFound, state.ReturnTo)
+}
+
+// Logout clears the session, and redirects to the end session endpoint of the provider if there is one
+func (s *Sessions) Logout(c *gin.Context) {
+	s.clearCookie(c, s.cfg.CookieName)
+	target := s.cfg.AfterLogout
+	if endpoint := s.client.endpoints.EndSession; endpoint != "" {
+		q := url.Values{"client_id": {s.client.cfg.ClientID}}
+		if u, err := url.Parse(target); err == nil && u.IsAbs() {
+			q.Set("post_logout_redirect_uri", target)
+		}
+		sep := "?"
+		if strings.Contains(endpoint, "?") {
+			sep = "&"
+		}
+		target = endpoint + sep + q.Encode()
+	}
+	c.Redirect(http.StatusFound, target)
+}
+
+func (s *Sessions) stateCookie() string {
+	return s.cfg.CookieName + "_state"
+}
+
+// writeCookie encrypts v with the cookie name as the additional data, so the cookies can not be swapped
+func (s *Sessions) writeCookie(c *gin.Context, name string, v interface{}, maxAge time.Duration) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	rand.Read(nonce)
+	value := base64.RawURLEncoding.EncodeToString(s.aead.Seal(nonce, nonce, data, []byte(name)))
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     s.cfg.CookiePath,
+		MaxAge:   int(maxAge / time.Second),
+		Secure:   s.cfg.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Sessions) readCookie(c *gin.Context, name string, v interface{}) error {
+	cookie, err := c.Request.Cookie(name)
+	if err != nil {
+		return err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(data) < s.aead.NonceSize() {
+		return fmt.Errorf("malformed cookie %s", name)
+	}
+	n := s.aead.NonceSize()
+	plain, err := s.aead.Open(nil, data[:n], data[n:], []byte(name))
+	if err != nil {
+		return fmt.Errorf("decrypt cookie %s failed: %s", name, err)
+	}
+	return json.Unmarshal(plain, v)
+}
+
+func (s *Sessions) clearCookie(c *gin.Context, name string) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Path:     s.cfg.CookiePath,
+		MaxAge:   -1,
+		Secure:   s.cfg.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
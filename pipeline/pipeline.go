@@ -0,0 +1,383 @@
+// Package pipeline connects generic processing stages by channels.
+//
+// A Pipeline owns the context of its stages: the first error of any stage cancels
+// the context, so that every stage stops and closes its output, and Wait returns the error.
+//
+//	p := pipeline.New(ctx)
+//	ids := pipeline.From(p, 1, 2, 3)
+//	users := pipeline.Map(p, ids, loadUser, pipeline.WithName("load"), pipeline.WithConcurrency(8))
+//	active := pipeline.Filter(p, users, isActive)
+//	result, err := pipeline.Collect(p, active)
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/leopoldxx/go-utils/trace"
+)
+
+// Stats is the statistics of a stage
+type Stats struct {
+	Name   string
+	In     int64
+	Out    int64
+	Errors int64
+	// Busy is the total time spent in the stage function, summed over the workers
+	Busy time.Duration
+}
+
+// Pipeline manages the lifecycle and the statistics of the stages
+type Pipeline struct {
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	errOnce sync.Once
+	err     error
+	// set if a stage is stopped by the context before its input is done, accessed atomically
+	interrupted int32
+
+	mu     sync.Mutex
+	stages []*stage
+}
+
+// New creates a pipeline, the stages stop when ctx is done
+func New(ctx context.Context) *Pipeline {
+	p := &Pipeline{parent: ctx}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	return p
+}
+
+// Context returns the context shared by the stages, it is canceled on the first error
+func (p *Pipeline) Context() context.Context {
+	return p.ctx
+}
+
+// Wait waits for all the stages to exit, and returns the first error of them, or the error of
+// the parent context if it stopped any stage early
+func (p *Pipeline) Wait() error {
+	p.wg.Wait()
+	p.cancel()
+	if p.err != nil {
+		return p.err
+	}
+	if atomic.LoadInt32(&p.interrupted) == 0 {
+		return nil
+	}
+	return p.parent.Err()
+}
+
+// Stats returns the statistics of the stages in the order they were added
+func (p *Pipeline) Stats() []Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]Stats, 0, len(p.stages))
+	for _, s := range p.stages {
+		stats = append(stats, Stats{
+			Name:   s.name,
+			In:     atomic.LoadInt64(&s.in),
+			Out:    atomic.LoadInt64(&s.out),
+			Errors: atomic.LoadInt64(&s.errors),
+			Busy:   time.Duration(atomic.LoadInt64(&s.busy)),
+		})
+	}
+	return stats
+}
+
+func (p *Pipeline) fail(s *stage, err error) {
+	atomic.AddInt64(&s.errors, 1)
+	p.errOnce.Do(func() {
+		p.err = fmt.Errorf("stage %s: %w", s.name, err)
+		trace.GetTraceFromContext(p.ctx).Errorf("pipeline stage %s failed: %s", s.name, err)
+		p.cancel()
+	})
+}
+
+type options struct {
+	name        string
+	concurrency int
+	buffer      int
+}
+
+// Option of a stage
+type Option func(opts *options)
+
+// WithName set the name of the stage shown in the stats and errors
+func WithName(name string) Option {
+	return func(opts *options) {
+		opts.name = name
+	}
+}
+
+// WithConcurrency set the count of the workers of the stage, default is 1,
+// the order of the items is not kept if it is greater than 1
+func WithConcurrency(n int) Option {
+	return func(opts *options) {
+		opts.concurrency = n
+	}
+}
+
+// WithBuffer set the buffer size of the output channel of the stage
+func WithBuffer(size int) Option {
+	return func(opts *options) {
+		opts.buffer = size
+	}
+}
+
+type stage struct {
+	name   string
+	in     int64
+	out    int64
+	errors int64
+	busy   int64
+}
+
+func (p *Pipeline) addStage(kind string, ops []Option) (*stage, *options) {
+	opts := &options{concurrency: 1}
+	for _, op := range ops {
+		op(opts)
+	}
+	if opts.concurrency < 1 {
+		opts.concurrency = 1
+	}
+	if opts.buffer < 0 {
+		opts.buffer = 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if opts.name == "" {
+		opts.name = fmt.Sprintf("%s#%d", kind, len(p.stages))
+	}
+	s := &stage{name: opts.name}
+	p.stages = append(p.stages, s)
+	return s, opts
+}
+
+// run starts the workers of a stage, out is closed after all of them exit
+func run[T any](p *Pipeline, workers int, out chan T, worker func()) {
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	p.wg.Add(workers + 1)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			defer wg.Done()
+			worker()
+		}()
+	}
+	go func() {
+		defer p.wg.Done()
+		wg.Wait()
+		close(out)
+	}()
+}
+
+func send[T any](p *Pipeline, out chan<- T, v T) bool {
+	select {
+	case out <- v:
+		return true
+	case <-p.ctx.Done():
+		atomic.StoreInt32(&p.interrupted, 1)
+		return false
+	}
+}
+
+func receive[T any](p *Pipeline, in <-chan T) (T, bool) {
+	select {
+	case v, ok := <-in:
+		return v, ok
+	case <-p.ctx.Done():
+		// the input may be closed as the context is done, which doesn't stop the stage early
+		select {
+		case _, ok := <-in:
+			if ok {
+				atomic.StoreInt32(&p.interrupted, 1)
+			}
+		default:
+			atomic.StoreInt32(&p.interrupted, 1)
+		}
+		var zero T
+		return zero, false
+	}
+}
+
+// From emits the items as a source stage
+func From[T any](p *Pipeline, items ...T) <-chan T {
+	s, _ := p.addStage("from", nil)
+	out := make(chan T)
+	run(p, 1, out, func() {
+		for _, item := range items {
+			if !send(p, out, item) {
+				return
+			}
+			atomic.AddInt64(&s.out, 1)
+		}
+	})
+	return out
+}
+
+// Map transforms every item of in by fn
+func Map[In, Out any](p *Pipeline, in <-chan In, fn func(ctx context.Context, item In) (Out, error), ops ...Option) <-chan Out {
+	s, opts := p.addStage("map", ops)
+	out := make(chan Out, opts.buffer)
+	run(p, opts.concurrency, out, func() {
+		for {
+			item, ok := receive(p, in)
+			if !ok {
+				return
+			}
+			atomic.AddInt64(&s.in, 1)
+			start := time.Now()
+			v, err := fn(p.ctx, item)
+			atomic.AddInt64(&s.busy, int64(time.Since(start)))
+			if err != nil {
+				p.fail(s, err)
+				return
+			}
+			if !send(p, out, v) {
+				return
+			}
+			atomic.AddInt64(&s.out, 1)
+		}
+	})
+	return out
+}
+
+// Filter passes the items of in that fn returns true for
+func Filter[T any](p *Pipeline, in <-chan T, fn func(ctx context.Context, item T) (bool, error), ops ...Option) <-chan T {
+	s, opts := p.addStage("filter", ops)
+	out := make(chan T, opts.buffer)
+	run(p, opts.concurrency, out, func() {
+		for {
+			item, ok := receive(p, in)
+			if !ok {
+				return
+			}
+			atomic.AddInt64(&s.in, 1)
+			start := time.Now()
+			pass, err := fn(p.ctx, item)
+			atomic.AddInt64(&s.busy, int64(time.Since(start)))
+			if err != nil {
+				p.fail(s, err)
+				return
+			}
+			if !pass {
+				continue
+			}
+			if !send(p, out, item) {
+				return
+			}
+			atomic.AddInt64(&s.out, 1)
+		}
+	})
+	return out
+}
+
+// FanOut distributes the items of in to n outputs, each item goes to one of them,
+// so the outputs can be consumed by different stages in parallel
+func FanOut[T any](p *Pipeline, in <-chan T, n int, ops ...Option) []<-chan T {
+	s, opts := p.addStage("fanout", ops)
+	if n < 1 {
+		n = 1
+	}
+	outs := make([]<-chan T, n)
+	for i := range outs {
+		out := make(chan T, opts.buffer)
+		outs[i] = out
+		run(p, 1, out, func() {
+			for {
+				item, ok := receive(p, in)
+				if !ok {
+					return
+				}
+				atomic.AddInt64(&s.in, 1)
+				if !send(p, out, item) {
+					return
+				}
+				atomic.AddInt64(&s.out, 1)
+			}
+		})
+	}
+	return outs
+}
+
+// FanIn merges the items of ins into one output
+func FanIn[T any](p *Pipeline, ins []<-chan T, ops ...Option) <-chan T {
+	return merge(p, "fanin", ins, ops)
+}
+
+// Buffer decouples a fast producer from a slow consumer by a buffer of size items
+func Buffer[T any](p *Pipeline, in <-chan T, size int, ops ...Option) <-chan T {
+	return merge(p, "buffer", []<-chan T{in}, append([]Option{WithBuffer(size)}, ops...))
+}
+
+func merge[T any](p *Pipeline, kind string, ins []<-chan T, ops []Option) <-chan T {
+	s, opts := p.addStage(kind, ops)
+	out := make(chan T, opts.buffer)
+	var wg sync.WaitGroup
+	wg.Add(len(ins))
+	p.wg.Add(len(ins) + 1)
+	for _, in := range ins {
+		go func(in <-chan T) {
+			defer p.wg.Done()
+			defer wg.Done()
+			for {
+				item, ok := receive(p, in)
+				if !ok {
+					return
+				}
+				atomic.AddInt64(&s.in, 1)
+				if !send(p, out, item) {
+					return
+				}
+				atomic.AddInt64(&s.out, 1)
+			}
+		}(in)
+	}
+	go func() {
+		defer p.wg.Done()
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// Sink calls fn with every item of in, it is the end of a pipeline
+func Sink[T any](p *Pipeline, in <-chan T, fn func(ctx context.Context, item T) error, ops ...Option) {
+	s, opts := p.addStage("sink", ops)
+	run(p, opts.concurrency, make(chan struct{}), func() {
+		for {
+			item, ok := receive(p, in)
+			if !ok {
+				return
+			}
+			atomic.AddInt64(&s.in, 1)
+			start := time.Now()
+			err := fn(p.ctx, item)
+			atomic.AddInt64(&s.busy, int64(time.Since(start)))
+			if err != nil {
+				p.fail(s, err)
+				return
+			}
+			atomic.AddInt64(&s.out, 1)
+		}
+	})
+}
+
+// Collect drains in and waits for the pipeline, the items are returned if no error occurred
+func Collect[T any](p *Pipeline, in <-chan T) ([]T, error) {
+	var items []T
+	for item := range in {
+		items = append(items, item)
+	}
+	if err := p.Wait(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPipeline(t *testing.T) {
+	p := New(context.TODO())
+	nums := From(p, 1, 2, 3, 4, 5, 6)
+	odds := Filter(p, nums, func(ctx context.Context, n int) (bool, error) {
+		return n%2 == 1, nil
+	})
+	strs := Map(p, odds, func(ctx context.Context, n int) (string, error) {
+		return strconv.Itoa(n * 10), nil
+	}, WithName("format"), WithConcurrency(3))
+	result, err := Collect(p, Buffer(p, strs, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(result)
+	if len(result) != 3 || result[0] != "10" || result[2] != "50" {
+		t.Fatalf("unexpected result: %v", result)
+	}
+
+	stats := p.Stats()
+	if len(stats) != 4 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if stats[1].Name != "filter#1" || stats[1].In != 6 || stats[1].Out != 3 {
+		t.Fatalf("unexpected filter stats: %+v", stats[1])
+	}
+	if stats[2].Name != "format" || stats[2].In != 3 || stats[2].Out != 3 {
+		t.Fatalf("unexpected map stats: %+v", stats[2])
+	}
+}
+
+func TestFanOutFanIn(t *testing.T) {
+	p := New(context.TODO())
+	items := make([]int, 100)
+	for i := range items {
+		items[i] = i
+	}
+	var workers int32
+	outs := FanOut(p, From(p, items...), 4)
+	for i := range outs {
+		outs[i] = Map(p, outs[i], func(ctx context.Context, n int) (int, error) {
+			atomic.AddInt32(&workers, 1)
+			return n * 2, nil
+		})
+	}
+	var sum int64
+	Sink(p, FanIn(p, outs), func(ctx context.Context, n int) error {
+		sum += int64(n)
+		return nil
+	})
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 9900 || workers != 100 {
+		t.Fatalf("unexpected sum %d, %d items", sum, workers)
+	}
+}
+
+func TestPipelineError(t *testing.T) {
+	p := New(context.TODO())
+	source := make(chan int)
+	go func() {
+		// an endless source is stopped by the cancellation
+		for i := 0; ; i++ {
+			select {
+			case source <- i:
+			case <-p.Context().Done():
+				close(source)
+				return
+			}
+		}
+	}()
+	failure := errors.New("bad item")
+	out := Map(p, source, func(ctx context.Context, n int) (int, error) {
+		if n == 10 {
+			return 0, failure
+		}
+		return n, nil
+	}, WithName("check"), WithConcurrency(2))
+
+	done := make(chan error)
+	go func() {
+		_, err := Collect(p, out)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, failure) || p.Stats()[0].Errors != 1 {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pipeline is not canceled")
+	}
+}
+
+func TestPipelineCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	p := New(ctx)
+	out := Map(p, From(p, 1, 2, 3), func(ctx context.Context, n int) (int, error) {
+		return n, nil
+	})
+	<-out
+	cancel()
+	if err := p.Wait(); err != context.Canceled {
+		t.Fatalf("expect canceled, got %v", err)
+	}
+
+	// the parent canceled after all the stages are done doesn't fail the pipeline
+	ctx, cancel = context.WithCancel(context.TODO())
+	p = New(ctx)
+	out = Map(p, From(p, 1, 2, 3), func(ctx context.Context, n int) (int, error) {
+		return n, nil
+	})
+	sum := 0
+	for n := range out {
+		sum += n
+	}
+	cancel()
+	if err := p.Wait(); err != nil || sum != 6 {
+		t.Fatalf("expect all the items done, got %d, %v", sum, err)
+	}
+}
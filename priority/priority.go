@@ -0,0 +1,130 @@
+// Package priority classifies the requests by priority, the priority is stored in the context
+// and honored by the load shedding, the abuse rate policy and the throttling of httputils,
+// so the health checks and the payments are served while the batch traffic is shed.
+// There's no worker pool in this tree, the workers of the apps can read it by FromContext.
+package priority
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tools-go/go-utils/middleware"
+)
+
+// Priority of a request
+type Priority int
+
+const (
+	// Background is for the batch, prefetch and retry traffic, which is dropped first
+	Background Priority = iota
+	// Normal is the default priority
+	Normal
+	// Critical is for the health checks, payments and so on, which are served as long as possible
+	Critical
+)
+
+func (p Priority) String() string {
+	switch p {
+	case Background:
+		return "background"
+	case Normal:
+		return "normal"
+	case Critical:
+		return "critical"
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+// Parse parses a priority name
+func Parse(s string) (Priority, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "background", "low", "batch":
+		return Background, nil
+	case "normal", "":
+		return Normal, nil
+	case "critical", "high":
+		return Critical, nil
+	}
+	return Normal, fmt.Errorf("unknown priority %q", s)
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx carrying p
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// FromContext returns the priority in ctx, Normal if absent
+func FromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return Normal
+}
+
+// Route annotates the priority of the routes
+type Route struct {
+	// Method matches all the methods if it's empty
+	Method string
+	// Path is the full path of gin like "/orders/:id", or a path prefix ending with "*" like "/batch/*"
+	Path     string
+	Priority Priority
+}
+
+// Classifier classifies the requests by the routes and the header
+type Classifier struct {
+	// Header carries the priority set by the client, it's ignored if empty
+	Header string
+	// MaxHeader caps the priority claimed by the header, as the clients could claim all the requests critical,
+	// so the header can only lower the priority unless it's raised for the trusted clients
+	MaxHeader Priority
+	// Routes are tried in order, the first matched one wins over the header
+	Routes []Route
+	// Default is the priority of the other requests
+	Default Priority
+}
+
+// NewClassifier creates a Classifier of the routes, the header is X-Priority capped at Normal,
+// and the default is Normal
+func NewClassifier(routes ...Route) *Classifier {
+	return &Classifier{Header: "X-Priority", MaxHeader: Normal, Routes: routes, Default: Normal}
+}
+
+// Classify returns the priority of the request, route is the full path of gin or empty
+func (cl *Classifier) Classify(r *http.Request, route string) Priority {
+	for _, rt := range cl.Routes {
+		if rt.Method != "" && rt.Method != r.Method {
+			continue
+		}
+		if prefix := strings.TrimSuffix(rt.Path, "*"); prefix != rt.Path {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				return rt.Priority
+			}
+		} else if rt.Path == route || rt.Path == r.URL.Path {
+			return rt.Priority
+		}
+	}
+	if cl.Header != "" {
+		if value := r.Header.Get(cl.Header); value != "" {
+			if p, err := Parse(value); err == nil {
+				if p > cl.MaxHeader {
+					p = cl.MaxHeader
+				}
+				return p
+			}
+		}
+	}
+	return cl.Default
+}
+
+// Middleware stores the priority of the requests in the context
+func (cl *Classifier) Middleware() middleware.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			next(w, r.WithContext(WithPriority(r.Context(), cl.Classify(r, ""))))
+		}
+	}
+}
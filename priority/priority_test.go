@@ -0,0 +1,49 @@
+package priority
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClassifier(t *testing.T) {
+	cl := NewClassifier(
+		Route{Path: "/healthz", Priority: Critical},
+		Route{Method: http.MethodPost, Path: "/orders/:id/pay", Priority: Critical},
+		Route{Path: "/batch/*", Priority: Background},
+	)
+	for _, c := range []struct {
+		method, path, route, header string
+		expect                      Priority
+	}{
+		{http.MethodGet, "/healthz", "", "", Critical},
+		{http.MethodPost, "/orders/1/pay", "/orders/:id/pay", "", Critical},
+		{http.MethodGet, "/orders/1/pay", "/orders/:id/pay", "", Normal},
+		{http.MethodPost, "/batch/export", "", "critical", Background},
+		{http.MethodGet, "/users", "", "", Normal},
+		{http.MethodGet, "/users", "", "background", Background},
+		// the header can not raise the priority above MaxHeader
+		{http.MethodGet, "/users", "", "critical", Normal},
+		{http.MethodGet, "/users", "", "unknown", Normal},
+	} {
+		r := httptest.NewRequest(c.method, c.path, nil)
+		if c.header != "" {
+			r.Header.Set("X-Priority", c.header)
+		}
+		if p := cl.Classify(r, c.route); p != c.expect {
+			t.Fatalf("%+v: got %s", c, p)
+		}
+	}
+
+	var got Priority
+	h := cl.Middleware().HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+	})
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/batch/sync", nil))
+	if got != Background {
+		t.Fatalf("expect background in the context, got %s", got)
+	}
+	if p := FromContext(httptest.NewRequest(http.MethodGet, "/", nil).Context()); p != Normal {
+		t.Fatalf("expect normal by default, got %s", p)
+	}
+}
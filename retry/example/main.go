@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/leopoldxx/go-utils/retry"
+)
+
+func main() {
+	attempts := flag.Int("attempts", 3, "the max attempts")
+	interval := flag.Duration("interval", 100*time.Millisecond, "the interval between the attempts")
+	failures := flag.Int("failures", 2, "the count of the failures before succeeding")
+	flag.Parse()
+
+	calls := 0
+	err := retry.Do(*attempts, func() error {
+		calls++
+		if calls <= *failures {
+			// only the retriable errors are retried, the others fail at once
+			return retry.NewRetriableError(fmt.Sprintf("attempt %d failed", calls))
+		}
+		return nil
+	}, *interval)
+	if err != nil {
+		log.Fatalf("failed after %d attempts: %s", calls, err)
+	}
+	log.Printf("succeeded after %d attempts", calls)
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"strings"
 	"time"
+
+	"github.com/tools-go/go-utils/utils/clock"
 )
 
 type retriableError struct {
@@ -37,6 +39,11 @@ func (e merrs) Err() error {
 
 // Do will retry attempts time after callback failed, and wait for d duration between each callback
 func Do(attempts int, callback func() error, d time.Duration) error {
+	return DoWithClock(clock.New(), attempts, callback, d)
+}
+
+// DoWithClock is Do waiting with the clock c, so the backoff can be tested with a clock.FakeClock
+func DoWithClock(c clock.Clock, attempts int, callback func() error, d time.Duration) error {
 	var errs merrs
 	if attempts == -1 {
 		attempts = ^int(0)
@@ -51,7 +58,7 @@ func Do(attempts int, callback func() error, d time.Duration) error {
 			return errs.Err()
 		}
 		if int(d) > 0 {
-			<-time.After(d)
+			<-c.After(d)
 		}
 	}
 	return errs.Err()
@@ -4,9 +4,11 @@ import (
 	"errors"
 	"log"
 	"testing"
+	"time"
 
 	"github.com/leopoldxx/go-utils/retry"
 	"github.com/stretchr/testify/assert"
+	"github.com/tools-go/go-utils/utils/clock"
 )
 
 func TestRetry(t *testing.T) {
@@ -37,3 +39,21 @@ func TestRetry(t *testing.T) {
 	log.Println(err)
 
 }
+
+func TestRetryWithClock(t *testing.T) {
+	c := clock.NewFakeClock(time.Now())
+	done := make(chan error)
+	calls := 0
+	go func() {
+		done <- retry.DoWithClock(c, 3, func() error {
+			calls++
+			return retry.NewRetriableError("mean it")
+		}, time.Minute)
+	}()
+	for i := 0; i < 3; i++ {
+		c.BlockUntil(1)
+		c.Advance(time.Minute)
+	}
+	assert.NotNil(t, <-done)
+	assert.Equal(t, 3, calls)
+}
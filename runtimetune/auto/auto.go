@@ -0,0 +1,11 @@
+// Package auto applies runtimetune at init, with GOMEMLIMIT at 90% of the memory limit of the cgroup,
+// or the ratio of RUNTIMETUNE_MEMORY_RATIO:
+//
+//	import _ "github.com/tools-go/go-utils/runtimetune/auto"
+package auto
+
+import "github.com/tools-go/go-utils/runtimetune"
+
+func init() {
+	runtimetune.Apply(runtimetune.Config{MemoryLimitRatio: 0.9})
+}
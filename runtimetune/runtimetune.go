@@ -0,0 +1,171 @@
+// Package runtimetune sets GOMAXPROCS by the cpu quota of the cgroup, and GOMEMLIMIT by the memory limit
+// of it, so the services in the containers neither run more threads than the cpus they are allowed, nor
+// get killed by the OOM killer before the GC runs hard. Import runtimetune/auto to apply it at init.
+package runtimetune
+
+import (
+	"expvar"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+
+	"git.xiaojukeji.com/devops/MachineAlliance/self/commons/trace/dlog"
+)
+
+// the sources of the settings
+const (
+	FromDefault = "default" // left as the runtime sets it
+	FromEnv     = "env"     // by GOMAXPROCS or GOMEMLIMIT
+	FromCgroup  = "cgroup"
+)
+
+// MemoryRatioEnv overrides Config.MemoryLimitRatio, like 0.8, or 0 to leave GOMEMLIMIT alone
+const MemoryRatioEnv = "RUNTIMETUNE_MEMORY_RATIO"
+
+// Config of Apply
+type Config struct {
+	// MemoryLimitRatio of the memory limit of the cgroup set as GOMEMLIMIT, leaving the rest to the
+	// memory out of the heap, like the stacks and the cgo, 0 leaves GOMEMLIMIT alone
+	MemoryLimitRatio float64
+}
+
+// Settings are the values applied, exported by expvar as runtimetune
+type Settings struct {
+	GOMAXPROCS     int     `json:"gomaxprocs"`
+	GOMAXPROCSFrom string  `json:"gomaxprocsFrom"`
+	CPUQuota       float64 `json:"cpuQuota"` // of the cgroup in cpus, 0 if unlimited or unknown
+	GOMEMLIMIT     int64   `json:"gomemlimit"`
+	GOMEMLIMITFrom string  `json:"gomemlimitFrom"`
+	MemoryLimit    int64   `json:"memoryLimit"` // of the cgroup in bytes, 0 if unlimited or unknown
+}
+
+var current struct {
+	sync.Mutex
+	settings Settings
+	publish  sync.Once
+}
+
+// Current returns the settings of the last Apply
+func Current() Settings {
+	current.Lock()
+	defer current.Unlock()
+	return current.settings
+}
+
+// Apply sets GOMAXPROCS and GOMEMLIMIT by the limits of the cgroup, the ones set by the env are respected.
+// The settings are logged and published by expvar.
+func Apply(cfg Config) Settings {
+	s := apply(cfg, "/")
+	dlog.Infof("event=[runtimetune] gomaxprocs=[%d] gomaxprocs_from=[%s] cpu_quota=[%g] gomemlimit=[%d] gomemlimit_from=[%s] memory_limit=[%d]",
+		s.GOMAXPROCS, s.GOMAXPROCSFrom, s.CPUQuota, s.GOMEMLIMIT, s.GOMEMLIMITFrom, s.MemoryLimit)
+	current.Lock()
+	current.settings = s
+	current.Unlock()
+	current.publish.Do(func() {
+		expvar.Publish("runtimetune", expvar.Func(func() interface{} { return Current() }))
+	})
+	return s
+}
+
+// apply applies cfg by the cgroup under root, the / of the tests
+func apply(cfg Config, root string) Settings {
+	s := Settings{GOMAXPROCSFrom: FromDefault, GOMEMLIMITFrom: FromDefault}
+	s.CPUQuota = cpuQuota(root)
+	switch {
+	case os.Getenv("GOMAXPROCS") != "":
+		s.GOMAXPROCSFrom = FromEnv
+	case s.CPUQuota > 0:
+		runtime.GOMAXPROCS(procsOf(s.CPUQuota, runtime.NumCPU()))
+		s.GOMAXPROCSFrom = FromCgroup
+	}
+	s.GOMAXPROCS = runtime.GOMAXPROCS(0)
+
+	if v := os.Getenv(MemoryRatioEnv); v != "" {
+		if ratio, err := strconv.ParseFloat(v, 64); err == nil && ratio >= 0 && ratio <= 1 {
+			cfg.MemoryLimitRatio = ratio
+		}
+	}
+	s.MemoryLimit = memoryLimit(root)
+	switch {
+	case os.Getenv("GOMEMLIMIT") != "":
+		s.GOMEMLIMITFrom = FromEnv
+	case s.MemoryLimit > 0 && cfg.MemoryLimitRatio > 0:
+		debug.SetMemoryLimit(int64(float64(s.MemoryLimit) * math.Min(cfg.MemoryLimitRatio, 1)))
+		s.GOMEMLIMITFrom = FromCgroup
+	}
+	// a negative value reads the limit without changing it
+	s.GOMEMLIMIT = debug.SetMemoryLimit(-1)
+	return s
+}
+
+// procsOf returns the procs for the quota, rounded down but at least 1, and at most the cpus
+func procsOf(quota float64, cpus int) int {
+	procs := int(math.Floor(quota))
+	if procs < 1 {
+		procs = 1
+	}
+	if procs > cpus {
+		procs = cpus
+	}
+	return procs
+}
+
+// cpuQuota reads the cpu quota of cgroup v2, or of v1, in cpus
+func cpuQuota(root string) float64 {
+	// v2: "max 100000" or "200000 100000"
+	if data, err := ioutil.ReadFile(filepath.Join(root, "sys/fs/cgroup/cpu.max")); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0
+		}
+		return ratio(fields[0], fields[1])
+	}
+	for _, dir := range []string{"sys/fs/cgroup/cpu", "sys/fs/cgroup/cpu,cpuacct"} {
+		quota, err := ioutil.ReadFile(filepath.Join(root, dir, "cpu.cfs_quota_us"))
+		if err != nil {
+			continue
+		}
+		period, err := ioutil.ReadFile(filepath.Join(root, dir, "cpu.cfs_period_us"))
+		if err != nil {
+			return 0
+		}
+		// -1 is unlimited
+		return ratio(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+	}
+	return 0
+}
+
+func ratio(quota, period string) float64 {
+	q, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseInt(period, 10, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return float64(q) / float64(p)
+}
+
+// memoryLimit reads the memory limit of cgroup v2, or of v1
+func memoryLimit(root string) int64 {
+	for _, name := range []string{"sys/fs/cgroup/memory.max", "sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+		data, err := ioutil.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		// max of v2, and the page aligned max int64 of v1 are unlimited
+		if err != nil || limit >= 1<<62 {
+			return 0
+		}
+		return limit
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package runtimetune
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestCgroupLimits(t *testing.T) {
+	for name, c := range map[string]struct {
+		files  map[string]string
+		quota  float64
+		memory int64
+	}{
+		"v2":           {map[string]string{"sys/fs/cgroup/cpu.max": "250000 100000\n", "sys/fs/cgroup/memory.max": "1073741824\n"}, 2.5, 1 << 30},
+		"v2 unlimited": {map[string]string{"sys/fs/cgroup/cpu.max": "max 100000\n", "sys/fs/cgroup/memory.max": "max\n"}, 0, 0},
+		"v1": {map[string]string{
+			"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_quota_us":  "50000\n",
+			"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+			"sys/fs/cgroup/memory/memory.limit_in_bytes":  "536870912\n",
+		}, 0.5, 512 << 20},
+		"v1 unlimited": {map[string]string{
+			"sys/fs/cgroup/cpu/cpu.cfs_quota_us":         "-1\n",
+			"sys/fs/cgroup/cpu/cpu.cfs_period_us":        "100000\n",
+			"sys/fs/cgroup/memory/memory.limit_in_bytes": "9223372036854771712\n",
+		}, 0, 0},
+		"none": {nil, 0, 0},
+	} {
+		root := writeFiles(t, c.files)
+		if quota := cpuQuota(root); quota != c.quota {
+			t.Fatalf("%s: expect quota %g, got %g", name, c.quota, quota)
+		}
+		if memory := memoryLimit(root); memory != c.memory {
+			t.Fatalf("%s: expect memory %d, got %d", name, c.memory, memory)
+		}
+	}
+
+	for _, c := range []struct {
+		quota       float64
+		cpus, procs int
+	}{{2.5, 8, 2}, {0.5, 8, 1}, {16, 8, 8}} {
+		if procs := procsOf(c.quota, c.cpus); procs != c.procs {
+			t.Fatalf("expect %d procs of %g, got %d", c.procs, c.quota, procs)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	defer debug.SetMemoryLimit(debug.SetMemoryLimit(-1))
+	t.Setenv("GOMAXPROCS", "")
+	t.Setenv("GOMEMLIMIT", "")
+	t.Setenv(MemoryRatioEnv, "")
+	root := writeFiles(t, map[string]string{"sys/fs/cgroup/cpu.max": "100000 100000\n", "sys/fs/cgroup/memory.max": "1000000000\n"})
+
+	s := apply(Config{MemoryLimitRatio: 0.9}, root)
+	expected := Settings{GOMAXPROCS: 1, GOMAXPROCSFrom: FromCgroup, CPUQuota: 1, GOMEMLIMIT: 900000000, GOMEMLIMITFrom: FromCgroup, MemoryLimit: 1000000000}
+	if s != expected {
+		t.Fatalf("expect %+v, got %+v", expected, s)
+	}
+
+	// the env overrides
+	t.Setenv("GOMAXPROCS", "3")
+	t.Setenv(MemoryRatioEnv, "0.5")
+	if s = apply(Config{MemoryLimitRatio: 0.9}, root); s.GOMAXPROCSFrom != FromEnv || s.GOMEMLIMIT != 500000000 {
+		t.Fatalf("expect GOMAXPROCS left and the ratio of the env, got %+v", s)
+	}
+	t.Setenv("GOMEMLIMIT", "2GiB")
+	if s = apply(Config{MemoryLimitRatio: 0.9}, root); s.GOMEMLIMITFrom != FromEnv || s.GOMEMLIMIT != 500000000 {
+		t.Fatalf("expect GOMEMLIMIT left, got %+v", s)
+	}
+}
@@ -0,0 +1,299 @@
+// Package saga runs the multi-step operations across services, every step has a compensating action,
+// and the compensations of the done steps are run in reverse order if a step fails.
+//
+// The progress can be persisted by a Store, so the sagas interrupted by crashes can be compensated by Recover.
+// Every step is logged with a child trace named "<saga>.<step>" of the trace in the context.
+package saga
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/retry"
+	"github.com/tools-go/go-utils/trace"
+)
+
+// Status of a saga or a step
+type Status string
+
+// statuses
+const (
+	StatusPending      Status = "pending"
+	StatusRunning      Status = "running"
+	StatusDone         Status = "done"
+	StatusFailed       Status = "failed"
+	StatusCompensated  Status = "compensated"
+	StatusCompensating Status = "compensating"
+	// the compensation failed, which needs the manual intervention
+	StatusStuck Status = "stuck"
+)
+
+// Step of a saga, Compensate can be nil if the action has nothing to undo
+type Step struct {
+	Name       string
+	Action     func(ctx context.Context) error
+	Compensate func(ctx context.Context) error
+}
+
+// StepRecord is the persisted status of a step
+type StepRecord struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Record is the persisted progress of a saga execution
+type Record struct {
+	ID        string       `json:"id"`
+	Saga      string       `json:"saga"`
+	Status    Status       `json:"status"`
+	Steps     []StepRecord `json:"steps"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// Store persists the records, Save is called whenever a step changes its status
+type Store interface {
+	Save(ctx context.Context, rec *Record) error
+}
+
+// StoreFunc is an adapter to use a function as a Store
+type StoreFunc func(ctx context.Context, rec *Record) error
+
+// Save calls f(ctx, rec)
+func (f StoreFunc) Save(ctx context.Context, rec *Record) error {
+	return f(ctx, rec)
+}
+
+// MemoryStore keeps the records in memory, which is useful for tests
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+// NewMemoryStore creates a MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]Record{}}
+}
+
+// Save implements Store
+func (s *MemoryStore) Save(ctx context.Context, rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *rec
+	copied.Steps = append([]StepRecord(nil), rec.Steps...)
+	s.records[rec.ID] = copied
+	return nil
+}
+
+// Get returns the record of the id
+func (s *MemoryStore) Get(id string) (Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.records[id]
+	return rec, ok
+}
+
+// Unfinished returns the records which are not done, compensated or stuck, which should be recovered
+func (s *MemoryStore) Unfinished() []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []Record
+	for _, rec := range s.records {
+		switch rec.Status {
+		case StatusDone, StatusCompensated, StatusStuck:
+		default:
+			records = append(records, rec)
+		}
+	}
+	return records
+}
+
+type options struct {
+	store              Store
+	compensateAttempts int
+	compensateInterval time.Duration
+}
+
+// Option for the saga
+type Option func(opts *options)
+
+// WithStore persists the progress with the store
+func WithStore(store Store) Option {
+	return func(opts *options) {
+		opts.store = store
+	}
+}
+
+// WithCompensateRetry retries the failed compensations, default is 3 attempts with 1s interval
+func WithCompensateRetry(attempts int, interval time.Duration) Option {
+	return func(opts *options) {
+		opts.compensateAttempts = attempts
+		opts.compensateInterval = interval
+	}
+}
+
+// Saga is the definition of a multi-step operation
+type Saga struct {
+	name  string
+	steps []Step
+	opts  *options
+}
+
+// New creates a saga of the steps
+func New(name string, steps []Step, ops ...Option) (*Saga, error) {
+	opts := &options{compensateAttempts: 3, compensateInterval: time.Second}
+	for _, op := range ops {
+		op(opts)
+	}
+	names := map[string]bool{}
+	for i, step := range steps {
+		if step.Name == "" || step.Action == nil || names[step.Name] {
+			return nil, errors.NewBadRequestError(fmt.Sprintf("saga %s: step #%d should have a unique name and an action", name, i))
+		}
+		names[step.Name] = true
+	}
+	return &Saga{name: name, steps: steps, opts: opts}, nil
+}
+
+// Error is returned if the saga fails, Err is the error of the failed step,
+// and Stuck is set if any of the compensations failed
+type Error struct {
+	Saga  string
+	Step  string
+	Err   error
+	Stuck bool
+}
+
+func (err *Error) Error() string {
+	msg := fmt.Sprintf("saga %s failed at step %s: %s", err.Saga, err.Step, err.Err)
+	if err.Stuck {
+		msg += ", and the compensation failed"
+	}
+	return msg
+}
+
+// Unwrap returns the error of the failed step
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+func (s *Saga) newRecord(id string) *Record {
+	rec := &Record{ID: id, Saga: s.name, Status: StatusPending}
+	for _, step := range s.steps {
+		rec.Steps = append(rec.Steps, StepRecord{Name: step.Name, Status: StatusPending})
+	}
+	return rec
+}
+
+func (s *Saga) save(ctx context.Context, rec *Record) {
+	if s.opts.store == nil {
+		return
+	}
+	rec.UpdatedAt = time.Now()
+	if err := s.opts.store.Save(ctx, rec); err != nil {
+		trace.GetTraceFromContext(ctx).Warnf("saga %s: save record %s failed: %s", s.name, rec.ID, err)
+	}
+}
+
+func (s *Saga) stepContext(ctx context.Context, step string) context.Context {
+	return trace.WithTraceForContext2(ctx, trace.WithParent(trace.GetTraceFromContext(ctx), s.name+"."+step))
+}
+
+// Run executes the steps in order with the execution id, which identifies the record in the store.
+// If a step fails, the done steps are compensated in reverse order, and an *Error is returned.
+func (s *Saga) Run(ctx context.Context, id string) error {
+	rec := s.newRecord(id)
+	rec.Status = StatusRunning
+	s.save(ctx, rec)
+
+	for i, step := range s.steps {
+		stepCtx := s.stepContext(ctx, step.Name)
+		tracer := trace.GetTraceFromContext(stepCtx)
+		rec.Steps[i].Status = StatusRunning
+		s.save(ctx, rec)
+
+		tracer.Infof("saga %s/%s: run step %s", s.name, id, step.Name)
+		err := step.Action(stepCtx)
+		if err == nil {
+			rec.Steps[i].Status = StatusDone
+			s.save(ctx, rec)
+			continue
+		}
+
+		tracer.Errorf("saga %s/%s: step %s failed: %s", s.name, id, step.Name, err)
+		// the failed step is compensated too, since it may be partially done
+		rec.Steps[i].Status, rec.Steps[i].Error = StatusFailed, err.Error()
+		rec.Status = StatusFailed
+		s.save(ctx, rec)
+		stuck := s.compensate(ctx, rec) != nil
+		return &Error{Saga: s.name, Step: step.Name, Err: err, Stuck: stuck}
+	}
+	rec.Status = StatusDone
+	s.save(ctx, rec)
+	trace.GetTraceFromContext(ctx).Infof("saga %s/%s: done", s.name, id)
+	return nil
+}
+
+// Recover compensates an unfinished record loaded from the store, e.g. the process crashed while running it
+func (s *Saga) Recover(ctx context.Context, rec *Record) error {
+	if rec.Saga != s.name || len(rec.Steps) != len(s.steps) {
+		return errors.NewBadRequestError(fmt.Sprintf("record %s does not belong to saga %s", rec.ID, s.name))
+	}
+	switch rec.Status {
+	case StatusDone, StatusCompensated:
+		return nil
+	}
+	trace.GetTraceFromContext(ctx).Warnf("saga %s/%s: recover from status %s", s.name, rec.ID, rec.Status)
+	return s.compensate(ctx, rec)
+}
+
+// compensate runs the compensations of the started steps in reverse order,
+// the record is marked as stuck if any of them fails after retries, and the others are still run
+func (s *Saga) compensate(ctx context.Context, rec *Record) error {
+	rec.Status = StatusCompensating
+	s.save(ctx, rec)
+
+	var errs []string
+	for i := len(s.steps) - 1; i >= 0; i-- {
+		step := s.steps[i]
+		switch rec.Steps[i].Status {
+		case StatusRunning, StatusDone, StatusFailed, StatusStuck:
+		default:
+			continue
+		}
+		stepCtx := s.stepContext(ctx, step.Name)
+		tracer := trace.GetTraceFromContext(stepCtx)
+		if step.Compensate != nil {
+			tracer.Infof("saga %s/%s: compensate step %s", s.name, rec.ID, step.Name)
+			err := retry.Do(s.opts.compensateAttempts, func() error {
+				if err := step.Compensate(stepCtx); err != nil {
+					tracer.Warnf("saga %s/%s: compensate step %s failed: %s", s.name, rec.ID, step.Name, err)
+					return retry.NewRetriableError(err.Error())
+				}
+				return nil
+			}, s.opts.compensateInterval)
+			if err != nil {
+				rec.Steps[i].Status, rec.Steps[i].Error = StatusStuck, err.Error()
+				s.save(ctx, rec)
+				errs = append(errs, step.Name+": "+err.Error())
+				continue
+			}
+		}
+		rec.Steps[i].Status = StatusCompensated
+		s.save(ctx, rec)
+	}
+
+	if len(errs) > 0 {
+		rec.Status = StatusStuck
+		s.save(ctx, rec)
+		trace.GetTraceFromContext(ctx).Errorf("saga %s/%s: compensation failed, manual intervention needed: %s", s.name, rec.ID, strings.Join(errs, "; "))
+		return fmt.Errorf("saga %s/%s compensation failed: %s", s.name, rec.ID, strings.Join(errs, "; "))
+	}
+	rec.Status = StatusCompensated
+	s.save(ctx, rec)
+	return nil
+}
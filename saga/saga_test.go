@@ -0,0 +1,109 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSteps(calls *[]string, failAt string, compensateFails map[string]int) []Step {
+	var steps []Step
+	for _, name := range []string{"reserve", "charge", "ship"} {
+		name := name
+		steps = append(steps, Step{
+			Name: name,
+			Action: func(ctx context.Context) error {
+				*calls = append(*calls, name)
+				if name == failAt {
+					return errors.New(name + " failed")
+				}
+				return nil
+			},
+			Compensate: func(ctx context.Context) error {
+				*calls = append(*calls, "undo-"+name)
+				if compensateFails[name] > 0 {
+					compensateFails[name]--
+					return errors.New("undo " + name + " failed")
+				}
+				return nil
+			},
+		})
+	}
+	return steps
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.TODO()
+	store := NewMemoryStore()
+
+	var calls []string
+	s, err := New("order", newSteps(&calls, "", nil), WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(ctx, "o1"); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := store.Get("o1"); rec.Status != StatusDone || strings.Join(calls, ",") != "reserve,charge,ship" {
+		t.Fatalf("unexpected result: %+v %v", rec, calls)
+	}
+
+	calls = nil
+	s, _ = New("order", newSteps(&calls, "ship", nil), WithStore(store))
+	err = s.Run(ctx, "o2")
+	var serr *Error
+	if !errors.As(err, &serr) || serr.Step != "ship" || serr.Stuck {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(calls, ",") != "reserve,charge,ship,undo-ship,undo-charge,undo-reserve" {
+		t.Fatalf("unexpected calls: %v", calls)
+	}
+	rec, _ := store.Get("o2")
+	if rec.Status != StatusCompensated || rec.Steps[2].Error != "ship failed" {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+	if len(store.Unfinished()) != 0 {
+		t.Fatalf("unexpected unfinished records: %+v", store.Unfinished())
+	}
+}
+
+func TestCompensateRetry(t *testing.T) {
+	ctx := context.TODO()
+	store := NewMemoryStore()
+	var calls []string
+	s, _ := New("order", newSteps(&calls, "ship", map[string]int{"charge": 1, "reserve": 5}),
+		WithStore(store), WithCompensateRetry(2, time.Millisecond))
+	err := s.Run(ctx, "o3")
+	var serr *Error
+	if !errors.As(err, &serr) || !serr.Stuck {
+		t.Fatalf("expect stuck, got %v", err)
+	}
+	rec, _ := store.Get("o3")
+	if rec.Status != StatusStuck || rec.Steps[1].Status != StatusCompensated || rec.Steps[0].Status != StatusStuck {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var calls []string
+	s, _ := New("order", newSteps(&calls, "", nil))
+	rec := &Record{ID: "o4", Saga: "order", Status: StatusRunning, Steps: []StepRecord{
+		{Name: "reserve", Status: StatusDone},
+		{Name: "charge", Status: StatusRunning},
+		{Name: "ship", Status: StatusPending},
+	}}
+	if err := s.Recover(context.TODO(), rec); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(calls, ",") != "undo-charge,undo-reserve" || rec.Status != StatusCompensated {
+		t.Fatalf("unexpected recovery: %v %+v", calls, rec)
+	}
+	if err := s.Recover(context.TODO(), &Record{Saga: "other"}); err == nil {
+		t.Fatal("expect invalid record")
+	}
+	if _, err := New("order", []Step{{Name: "a"}}); err == nil {
+		t.Fatal("expect invalid step")
+	}
+}
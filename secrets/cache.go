@@ -0,0 +1,108 @@
+package secrets
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tools-go/go-utils/trace"
+)
+
+// RotateFunc is called when a cached secret is changed
+type RotateFunc func(name string, old, new Secret)
+
+type entry struct {
+	value   Secret
+	expires time.Time
+}
+
+// Cache caches the secrets of a provider with a TTL, and calls the rotation callbacks when the secrets are changed.
+// The stale value is still returned if the reloading fails, so a flapping secret store won't break the callers.
+type Cache struct {
+	provider  Provider
+	ttl       time.Duration
+	mu        sync.Mutex
+	entries   map[string]*entry
+	callbacks []RotateFunc
+}
+
+// NewCache creates a cache of the provider, the secrets are reloaded after ttl
+func NewCache(p Provider, ttl time.Duration) *Cache {
+	return &Cache{provider: p, ttl: ttl, entries: map[string]*entry{}}
+}
+
+// OnRotate adds a callback for the secret changes, e.g. to reconnect the db with the new password
+func (c *Cache) OnRotate(fn RotateFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.callbacks = append(c.callbacks, fn)
+}
+
+// Get returns the cached secret, or loads it from the provider if it's expired
+func (c *Cache) Get(ctx context.Context, name string) (Secret, error) {
+	c.mu.Lock()
+	e, ok := c.entries[name]
+	c.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.value, nil
+	}
+	value, err := c.load(ctx, name)
+	if err != nil && ok {
+		trace.GetTraceFromContext(ctx).Warnf("reload secret %s failed, use the stale one: %s", name, err)
+		return e.value, nil
+	}
+	return value, err
+}
+
+func (c *Cache) load(ctx context.Context, name string) (Secret, error) {
+	value, err := c.provider.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	old, ok := c.entries[name]
+	c.entries[name] = &entry{value: value, expires: time.Now().Add(c.ttl)}
+	callbacks := c.callbacks
+	c.mu.Unlock()
+
+	if ok && old.value != value {
+		for _, fn := range callbacks {
+			fn(name, old.value, value)
+		}
+	}
+	return value, nil
+}
+
+// Refresh reloads all the cached secrets, and returns the last error if any of them failed
+func (c *Cache) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	names := make([]string, 0, len(c.entries))
+	for name := range c.entries {
+		names = append(names, name)
+	}
+	c.mu.Unlock()
+
+	var lastErr error
+	for _, name := range names {
+		if _, err := c.load(ctx, name); err != nil {
+			trace.GetTraceFromContext(ctx).Warnf("refresh secret %s failed: %s", name, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Run refreshes the cached secrets every interval until ctx is done,
+// so that the rotations are noticed without waiting for the next Get
+func (c *Cache) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Refresh(ctx)
+		}
+	}
+}
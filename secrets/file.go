@@ -0,0 +1,93 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/utils/urlutil"
+)
+
+// readSecretFile reads dir/name, which can not escape dir
+func readSecretFile(dir, name string) ([]byte, error) {
+	p, err := urlutil.SafeJoin(dir, name)
+	if err != nil || p == dir {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("invalid secret name %q", name))
+	}
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, errors.NewNotFoundError("secret " + name)
+	}
+	return data, err
+}
+
+// NewFileProvider loads the secrets from the files under dir, one secret per file named by the secret name,
+// which is the layout of the mounted Kubernetes and Docker secrets(/run/secrets).
+// The trailing line breaks of the files are trimmed.
+func NewFileProvider(dir string) Provider {
+	return ProviderFunc(func(ctx context.Context, name string) (Secret, error) {
+		data, err := readSecretFile(dir, name)
+		if err != nil {
+			return "", err
+		}
+		value := strings.TrimRight(string(data), "\r\n")
+		Register(value)
+		return Secret(value), nil
+	})
+}
+
+// Decrypter decrypts the ciphertext, which is usually implemented by the KMS clients
+type Decrypter interface {
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// DecrypterFunc is an adapter to use a function as a Decrypter
+type DecrypterFunc func(ctx context.Context, ciphertext []byte) ([]byte, error)
+
+// Decrypt calls f(ctx, ciphertext)
+func (f DecrypterFunc) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	return f(ctx, ciphertext)
+}
+
+// NewKMSFileProvider loads the encrypted secrets from the files under dir like NewFileProvider,
+// and decrypts them with d, so the secrets can be committed or shipped with the deployments safely
+func NewKMSFileProvider(dir string, d Decrypter) Provider {
+	return ProviderFunc(func(ctx context.Context, name string) (Secret, error) {
+		data, err := readSecretFile(dir, name)
+		if err != nil {
+			return "", err
+		}
+		plain, err := d.Decrypt(ctx, data)
+		if err != nil {
+			return "", fmt.Errorf("decrypt secret %s failed: %s", name, err)
+		}
+		value := string(plain)
+		Register(value)
+		return Secret(value), nil
+	})
+}
+
+// NewAESGCMDecrypter creates a Decrypter of AES-GCM with a local data key of 16, 24 or 32 bytes,
+// the ciphertext should be the nonce followed by the sealed data
+func NewAESGCMDecrypter(key []byte) (Decrypter, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return DecrypterFunc(func(ctx context.Context, ciphertext []byte) ([]byte, error) {
+		if len(ciphertext) < gcm.NonceSize() {
+			return nil, errors.NewBadRequestError("ciphertext too short")
+		}
+		nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+		return gcm.Open(nil, nonce, sealed, nil)
+	}), nil
+}
@@ -0,0 +1,189 @@
+// Package secrets loads the secrets like the db passwords from the environment variables, files,
+// Vault or KMS encrypted files, caches them with a TTL and notifies the rotations.
+//
+// The loaded values are registered for the redaction, so Redact can be used to scrub them from the logs
+// and the config dumps, and the Secret type never prints its value.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tools-go/go-utils/errors"
+)
+
+// Mask is the replacement of the redacted secrets
+const Mask = "******"
+
+// Secret is a string which is masked when printed or marshaled, use Reveal to get the value
+type Secret string
+
+// Reveal returns the value of the secret
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// String implements fmt.Stringer
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return Mask
+}
+
+// GoString implements fmt.GoStringer, so %#v is masked too
+func (s Secret) GoString() string {
+	return fmt.Sprintf("%q", s.String())
+}
+
+// MarshalText implements encoding.TextMarshaler, which is used by json, yaml and toml
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Provider loads the secret of the name, a NotFound error of the errors package
+// should be returned if the secret does not exist
+type Provider interface {
+	Get(ctx context.Context, name string) (Secret, error)
+}
+
+// ProviderFunc is an adapter to use a function as a Provider
+type ProviderFunc func(ctx context.Context, name string) (Secret, error)
+
+// Get calls f(ctx, name)
+func (f ProviderFunc) Get(ctx context.Context, name string) (Secret, error) {
+	return f(ctx, name)
+}
+
+var envReplacer = regexp.MustCompile(`[^A-Za-z0-9]`)
+
+// NewEnvProvider loads the secrets from the environment variables, the name is converted to
+// the upper case with prefix, and the characters other than letters and digits are replaced by "_",
+// e.g. "db.password" is loaded from APP_DB_PASSWORD with the prefix "APP_"
+func NewEnvProvider(prefix string) Provider {
+	return ProviderFunc(func(ctx context.Context, name string) (Secret, error) {
+		key := prefix + strings.ToUpper(envReplacer.ReplaceAllString(name, "_"))
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			return "", errors.NewNotFoundError("secret " + name)
+		}
+		Register(value)
+		return Secret(value), nil
+	})
+}
+
+// Chain tries the providers in order, and returns the first found secret
+func Chain(providers ...Provider) Provider {
+	return ProviderFunc(func(ctx context.Context, name string) (Secret, error) {
+		for _, p := range providers {
+			s, err := p.Get(ctx, name)
+			if err == nil {
+				return s, nil
+			}
+			if !errors.IsNotFoundError(err) {
+				return "", err
+			}
+		}
+		return "", errors.NewNotFoundError("secret " + name)
+	})
+}
+
+var (
+	defaultMu       sync.RWMutex
+	defaultProvider = NewEnvProvider("")
+)
+
+// Default returns the default provider, which loads the secrets from the environment variables if not set
+func Default() Provider {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultProvider
+}
+
+// SetDefault replaces the default provider
+func SetDefault(p Provider) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultProvider = p
+}
+
+var refRegexp = regexp.MustCompile(`\$\{secret:([^}]+)\}`)
+
+// Expand replaces the references like "${secret:db_password}" in s with the secrets loaded from p,
+// e.g. "user:${secret:db_password}@tcp(127.0.0.1:3306)/test"
+func Expand(ctx context.Context, p Provider, s string) (string, error) {
+	var err error
+	expanded := refRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+		var secret Secret
+		secret, err = p.Get(ctx, refRegexp.FindStringSubmatch(ref)[1])
+		return secret.Reveal()
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+var (
+	redactMu sync.RWMutex
+	// sorted by the length in descending order, so a secret containing another one is replaced first
+	redacted []string
+)
+
+// the short values are too likely to be a part of the normal text
+const minRedactLen = 4
+
+// Register adds the values for the redaction, the values loaded by the providers are registered automatically
+func Register(values ...string) {
+	redactMu.Lock()
+	defer redactMu.Unlock()
+	for _, v := range values {
+		if len(v) < minRedactLen || contains(redacted, v) {
+			continue
+		}
+		redacted = append(redacted, v)
+	}
+	sort.SliceStable(redacted, func(i, j int) bool { return len(redacted[i]) > len(redacted[j]) })
+}
+
+// Unregister removes the values from the redaction
+func Unregister(values ...string) {
+	redactMu.Lock()
+	defer redactMu.Unlock()
+	kept := redacted[:0]
+	for _, v := range redacted {
+		if !contains(values, v) {
+			kept = append(kept, v)
+		}
+	}
+	redacted = kept
+}
+
+func contains(values []string, v string) bool {
+	for i := range values {
+		if values[i] == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact replaces all the registered secret values in s with the Mask
+func Redact(s string) string {
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+	for _, v := range redacted {
+		if strings.Contains(s, v) {
+			s = strings.Replace(s, v, Mask, -1)
+		}
+	}
+	return s
+}
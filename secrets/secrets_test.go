@@ -0,0 +1,185 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tools-go/go-utils/errors"
+)
+
+func TestSecretFormat(t *testing.T) {
+	cfg := struct {
+		User     string `json:"user"`
+		Password Secret `json:"password"`
+	}{"root", "p4ssw0rd"}
+	data, _ := json.Marshal(cfg)
+	if string(data) != `{"user":"root","password":"******"}` {
+		t.Fatalf("unexpected json: %s", data)
+	}
+	for _, s := range []string{fmt.Sprint(cfg), fmt.Sprintf("%v", cfg), fmt.Sprintf("%#v", cfg.Password)} {
+		if strings.Contains(s, "p4ssw0rd") || !strings.Contains(s, Mask) {
+			t.Fatalf("unexpected format: %s", s)
+		}
+	}
+	if cfg.Password.Reveal() != "p4ssw0rd" {
+		t.Fatal("unexpected value")
+	}
+}
+
+func TestProviders(t *testing.T) {
+	ctx := context.TODO()
+	os.Setenv("TEST_DB_PASSWORD", "env-secret")
+	dir, _ := ioutil.TempDir("", "secrets")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "db_password"), []byte("file-secret\n"), 0600)
+
+	p := Chain(NewEnvProvider("TEST_"), NewFileProvider(dir))
+	if s, err := p.Get(ctx, "db.password"); err != nil || s.Reveal() != "env-secret" {
+		t.Fatalf("unexpected secret: %v, %v", s.Reveal(), err)
+	}
+	if s, err := p.Get(ctx, "db_password"); err != nil || s.Reveal() != "env-secret" {
+		t.Fatalf("unexpected secret: %v, %v", s.Reveal(), err)
+	}
+	if s, err := NewFileProvider(dir).Get(ctx, "db_password"); err != nil || s.Reveal() != "file-secret" {
+		t.Fatalf("unexpected secret: %v, %v", s.Reveal(), err)
+	}
+	if _, err := p.Get(ctx, "none"); !errors.IsNotFoundError(err) {
+		t.Fatalf("expect not found, got %v", err)
+	}
+	if _, err := NewFileProvider(dir).Get(ctx, "../etc/passwd"); !errors.IsBadRequestError(err) {
+		t.Fatalf("expect invalid name, got %v", err)
+	}
+
+	dsn, err := Expand(ctx, p, "root:${secret:db_password}@tcp(127.0.0.1:3306)/test")
+	if err != nil || dsn != "root:env-secret@tcp(127.0.0.1:3306)/test" {
+		t.Fatalf("unexpected dsn: %s, %v", dsn, err)
+	}
+	if _, err := Expand(ctx, p, "${secret:none}"); err == nil {
+		t.Fatal("expect failed to expand")
+	}
+	if s := Redact("connect " + dsn + " failed, file-secret"); s != "connect root:******@tcp(127.0.0.1:3306)/test failed, ******" {
+		t.Fatalf("unexpected redaction: %s", s)
+	}
+}
+
+func TestKMSFileProvider(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	nonce := make([]byte, gcm.NonceSize())
+	ciphertext := gcm.Seal(nonce, nonce, []byte("kms-secret"), nil)
+
+	dir, _ := ioutil.TempDir("", "secrets")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "token"), ciphertext, 0600)
+
+	d, err := NewAESGCMDecrypter(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := NewKMSFileProvider(dir, d).Get(context.TODO(), "token"); err != nil || s.Reveal() != "kms-secret" {
+		t.Fatalf("unexpected secret: %v, %v", s.Reveal(), err)
+	}
+	d, _ = NewAESGCMDecrypter([]byte("fedcba9876543210"))
+	if _, err := NewKMSFileProvider(dir, d).Get(context.TODO(), "token"); err == nil {
+		t.Fatal("expect failed to decrypt with a wrong key")
+	}
+}
+
+func TestVaultProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/data/apps/order":
+			w.Write([]byte(`{"data":{"data":{"db_password":"vault-secret","port":3306}}}`))
+		case "/v1/kv/data/apps/single":
+			w.Write([]byte(`{"data":{"data":{"token":"single-secret"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.TODO()
+	p := NewVaultProvider(VaultConfig{Addr: server.URL, Token: "token", Mount: "kv"})
+	for name, expect := range map[string]string{
+		"apps/order#db_password": "vault-secret",
+		"apps/order#port":        "3306",
+		"apps/single":            "single-secret",
+	} {
+		if s, err := p.Get(ctx, name); err != nil || s.Reveal() != expect {
+			t.Fatalf("unexpected secret of %s: %v, %v", name, s.Reveal(), err)
+		}
+	}
+	if _, err := p.Get(ctx, "apps/order"); !errors.IsNotFoundError(err) {
+		t.Fatalf("expect not found with multiple keys, got %v", err)
+	}
+	if _, err := p.Get(ctx, "apps/none#key"); !errors.IsNotFoundError(err) {
+		t.Fatalf("expect not found, got %v", err)
+	}
+	p = NewVaultProvider(VaultConfig{Addr: server.URL, Mount: "kv"})
+	if _, err := p.Get(ctx, "apps/single"); !errors.IsForbiddenError(err) {
+		t.Fatalf("expect forbidden, got %v", err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.TODO()
+	var (
+		value   = "v1"
+		fail    bool
+		loads   int
+		rotated []string
+	)
+	p := ProviderFunc(func(ctx context.Context, name string) (Secret, error) {
+		loads++
+		if fail {
+			return "", fmt.Errorf("store unavailable")
+		}
+		return Secret(value), nil
+	})
+	c := NewCache(p, time.Hour)
+	c.OnRotate(func(name string, old, new Secret) {
+		rotated = append(rotated, name+":"+old.Reveal()+"->"+new.Reveal())
+	})
+
+	for i := 0; i < 2; i++ {
+		if s, err := c.Get(ctx, "key"); err != nil || s.Reveal() != "v1" {
+			t.Fatalf("unexpected secret: %v, %v", s.Reveal(), err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("expect cached, loaded %d times", loads)
+	}
+
+	value = "v2"
+	if err := c.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := c.Get(ctx, "key"); s.Reveal() != "v2" || len(rotated) != 1 || rotated[0] != "key:v1->v2" {
+		t.Fatalf("unexpected rotation: %v %v", s.Reveal(), rotated)
+	}
+
+	c.ttl = 0
+	fail = true
+	if s, err := c.Get(ctx, "key"); err != nil || s.Reveal() != "v2" {
+		t.Fatalf("expect the stale secret, got %v, %v", s.Reveal(), err)
+	}
+	if _, err := c.Get(ctx, "other"); err == nil {
+		t.Fatal("expect failed to load")
+	}
+}
@@ -0,0 +1,101 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tools-go/go-utils/errors"
+)
+
+// VaultConfig is the config of the Vault KV version 2 secrets engine
+type VaultConfig struct {
+	// Addr is the address of the Vault server like "https://vault.example.com:8200"
+	Addr      string
+	Token     string
+	Namespace string
+	// Mount is the mount path of the KV engine, default is "secret"
+	Mount  string
+	Client *http.Client
+}
+
+// NewVaultProvider loads the secrets from the Vault KV version 2 secrets engine,
+// the name is "path#key" like "apps/order#db_password", and the key can be omitted
+// if the secret has only one key
+func NewVaultProvider(cfg VaultConfig) Provider {
+	if cfg.Mount == "" {
+		cfg.Mount = "secret"
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return ProviderFunc(func(ctx context.Context, name string) (Secret, error) {
+		path, key := name, ""
+		if i := strings.LastIndex(name, "#"); i >= 0 {
+			path, key = name[:i], name[i+1:]
+		}
+		data, err := readVault(ctx, cfg, path)
+		if err != nil {
+			return "", err
+		}
+		if key == "" && len(data) == 1 {
+			for k := range data {
+				key = k
+			}
+		}
+		value, ok := data[key]
+		if !ok {
+			return "", errors.NewNotFoundError("secret " + name)
+		}
+		s, ok := value.(string)
+		if !ok {
+			b, _ := json.Marshal(value)
+			s = string(b)
+		}
+		Register(s)
+		return Secret(s), nil
+	})
+}
+
+func readVault(ctx context.Context, cfg VaultConfig, path string) (map[string]interface{}, error) {
+	api := fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimSuffix(cfg.Addr, "/"), strings.Trim(cfg.Mount, "/"), strings.Trim(path, "/"))
+	req, err := http.NewRequest(http.MethodGet, api, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", cfg.Token)
+	if cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", cfg.Namespace)
+	}
+	resp, err := cfg.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errors.NewNotFoundError("secret " + path)
+	case resp.StatusCode == http.StatusForbidden:
+		return nil, errors.NewForbiddenError(fmt.Sprintf("read secret %s from vault denied", path))
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("read secret %s from vault failed: %s %s", path, resp.Status, body)
+	}
+	result := &struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(body, result); err != nil {
+		return nil, fmt.Errorf("invalid vault response: %s", err)
+	}
+	return result.Data.Data, nil
+}
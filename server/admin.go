@@ -0,0 +1,217 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	rdebug "runtime/debug"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"git.xiaojukeji.com/devops/MachineAlliance/self/commons/trace/dlog"
+	"github.com/gorilla/mux"
+)
+
+// The admin endpoints are served under /debug/admin, for the operators and the svcctl tool:
+//
+//	GET  /debug/admin/routes       the routes registered
+//	GET  /debug/admin/buildinfo    the go version, the module versions and the uptime
+//	GET  /debug/admin/loglevel     the dlog severity and the glog verbosity
+//	PUT  /debug/admin/loglevel     ?level=DEBUG&v=2, either of them
+//	GET  /debug/admin/maintenance  whether the service is under maintenance
+//	PUT  /debug/admin/maintenance  ?on=true|false, the requests out of /debug/ are replied 503 when it's on
+//	GET  /debug/admin/traces/{id}  the timeline of the trace in the ring buffer of dlog, ?format=html for a gantt
+//
+// They are served only to the loopback clients, like svcctl on the host, unless an authorizer is set
+// by AdminAuthorizer or AdminToken. Note that all the requests come from the loopback behind a local proxy.
+const adminPrefix = "/debug/admin"
+
+// Admin switch on/off the admin api
+func Admin(d bool) Option {
+	return func(opts *options) {
+		opts.admin = d
+	}
+}
+
+// AdminAuthorizer set the func authorizing the requests to the admin api, the others are replied 403
+func AdminAuthorizer(authorize func(r *http.Request) bool) Option {
+	return func(opts *options) {
+		opts.adminAuthorizer = authorize
+	}
+}
+
+// AdminToken authorizes the requests to the admin api with the header "Authorization: Bearer <token>",
+// svcctl sends it by the -token flag
+func AdminToken(token string) Option {
+	return AdminAuthorizer(func(r *http.Request) bool {
+		return token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+	})
+}
+
+// loopback authorizes the requests from the loopback addresses, it's the default authorizer of the admin api
+func loopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// BuildInfo is replied by the buildinfo endpoint
+type BuildInfo struct {
+	GoVersion string            `json:"goVersion"`
+	Path      string            `json:"path,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	Settings  map[string]string `json:"settings,omitempty"` // like vcs.revision
+	Deps      map[string]string `json:"deps,omitempty"`
+	Hostname  string            `json:"hostname"`
+	PID       int               `json:"pid"`
+	Started   time.Time         `json:"started"`
+	Uptime    string            `json:"uptime"`
+}
+
+// LogLevel is replied by the loglevel endpoint
+type LogLevel struct {
+	Level string `json:"level"`
+	V     string `json:"v,omitempty"`
+}
+
+func (s *server) admin(router *mux.Router, authorize func(r *http.Request) bool) {
+	if authorize == nil {
+		authorize = loopback
+	}
+	authorized := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !authorize(r) {
+				replyError(w, http.StatusForbidden, "admin api is not authorized")
+				return
+			}
+			h(w, r)
+		}
+	}
+	sub := router.PathPrefix(adminPrefix).Subrouter()
+	sub.Methods("GET").Path("/routes").HandlerFunc(authorized(s.routes))
+	sub.Methods("GET").Path("/buildinfo").HandlerFunc(authorized(s.buildInfo))
+	sub.Methods("GET").Path("/loglevel").HandlerFunc(authorized(s.logLevel))
+	sub.Methods("PUT").Path("/loglevel").HandlerFunc(authorized(s.setLogLevel))
+	sub.Methods("GET").Path("/maintenance").HandlerFunc(authorized(s.maintenanceMode))
+	sub.Methods("PUT").Path("/maintenance").HandlerFunc(authorized(s.setMaintenanceMode))
+	sub.Methods("GET").Path("/traces/{id}").HandlerFunc(authorized(s.traceTimeline))
+}
+
+func replyJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func replyError(w http.ResponseWriter, code int, msg string) {
+	replyJSON(w, code, map[string]string{"error": msg})
+}
+
+func (s *server) routes(w http.ResponseWriter, r *http.Request) {
+	var routes []string
+	s.rrouter.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		if len(methods) == 0 {
+			methods = []string{"*"}
+		}
+		routes = append(routes, strings.Join(methods, ",")+" "+tpl)
+		return nil
+	})
+	sort.Strings(routes)
+	replyJSON(w, http.StatusOK, routes)
+}
+
+func (s *server) buildInfo(w http.ResponseWriter, r *http.Request) {
+	info := BuildInfo{GoVersion: runtime.Version(), PID: os.Getpid(), Started: s.started, Uptime: time.Since(s.started).String()}
+	info.Hostname, _ = os.Hostname()
+	if bi, ok := rdebug.ReadBuildInfo(); ok {
+		info.Path, info.Version = bi.Main.Path, bi.Main.Version
+		info.Deps = map[string]string{}
+		for _, dep := range bi.Deps {
+			info.Deps[dep.Path] = dep.Version
+		}
+		info.Settings = map[string]string{}
+		for _, setting := range bi.Settings {
+			info.Settings[setting.Key] = setting.Value
+		}
+	}
+	replyJSON(w, http.StatusOK, info)
+}
+
+func currentLogLevel() LogLevel {
+	level := LogLevel{Level: dlog.GetSeverity().String()}
+	if v := flag.Lookup("v"); v != nil {
+		level.V = v.Value.String()
+	}
+	return level
+}
+
+func (s *server) logLevel(w http.ResponseWriter, r *http.Request) {
+	replyJSON(w, http.StatusOK, currentLogLevel())
+}
+
+func (s *server) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	level, v := r.URL.Query().Get("level"), r.URL.Query().Get("v")
+	if level == "" && v == "" {
+		replyError(w, http.StatusBadRequest, "level or v is required")
+		return
+	}
+	var severity dlog.Severity
+	if level != "" {
+		var err error
+		if severity, err = dlog.ParseSeverity(strings.ToUpper(level)); err != nil {
+			replyError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if v != "" {
+		// the verbosity of glog, which the trace package logs with
+		if flag.Lookup("v") == nil {
+			replyError(w, http.StatusBadRequest, "no verbosity flag registered")
+			return
+		}
+		if err := flag.Set("v", v); err != nil {
+			replyError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if level != "" {
+		dlog.SetSeverity(severity)
+	}
+	replyJSON(w, http.StatusOK, currentLogLevel())
+}
+
+func (s *server) maintenanceMode(w http.ResponseWriter, r *http.Request) {
+	replyJSON(w, http.StatusOK, map[string]bool{"maintenance": s.underMaintenance()})
+}
+
+func (s *server) setMaintenanceMode(w http.ResponseWriter, r *http.Request) {
+	var on int32
+	switch r.URL.Query().Get("on") {
+	case "true", "1":
+		on = 1
+	case "false", "0":
+	default:
+		replyError(w, http.StatusBadRequest, "on should be true or false")
+		return
+	}
+	atomic.StoreInt32(&s.maintenance, on)
+	s.maintenanceMode(w, r)
+}
+
+func (s *server) underMaintenance() bool {
+	return atomic.LoadInt32(&s.maintenance) == 1
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"git.xiaojukeji.com/devops/MachineAlliance/self/commons/trace/dlog"
+	"github.com/gorilla/mux"
+)
+
+type ping struct{}
+
+func (ping) Register(router *mux.Router) {
+	router.Methods("GET").Path("/ping").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	s := New(Admin(true), APIPrefix("/api"))
+	s.Register(ping{})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	do := func(method, path string, v interface{}) int {
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			json.NewDecoder(resp.Body).Decode(v)
+		}
+		return resp.StatusCode
+	}
+
+	var routes []string
+	if code := do("GET", "/debug/admin/routes", &routes); code != http.StatusOK {
+		t.Fatalf("unexpected code %d", code)
+	}
+	found := false
+	for _, route := range routes {
+		found = found || route == "GET /api/ping"
+	}
+	if !found {
+		t.Fatalf("expect the api routes listed, got %v", routes)
+	}
+
+	var info BuildInfo
+	if code := do("GET", "/debug/admin/buildinfo", &info); code != http.StatusOK || info.GoVersion == "" || info.PID == 0 {
+		t.Fatalf("unexpected build info %d %+v", code, info)
+	}
+
+	defer dlog.SetSeverity(dlog.GetSeverity())
+	var level LogLevel
+	if code := do("PUT", "/debug/admin/loglevel?level=debug", &level); code != http.StatusOK || level.Level != "DEBUG" {
+		t.Fatalf("unexpected log level %d %+v", code, level)
+	}
+	if code := do("PUT", "/debug/admin/loglevel?level=verbose", nil); code != http.StatusBadRequest {
+		t.Fatalf("expect unknown levels rejected, got %d", code)
+	}
+	if dlog.GetSeverity() != dlog.DEBUG {
+		t.Fatalf("unexpected severity %s", dlog.GetSeverity())
+	}
+
+	if code := do("PUT", "/debug/admin/maintenance?on=true", nil); code != http.StatusOK {
+		t.Fatalf("unexpected code %d", code)
+	}
+	if code := do("GET", "/api/ping", nil); code != http.StatusServiceUnavailable {
+		t.Fatalf("expect 503 under maintenance, got %d", code)
+	}
+	var mode map[string]bool
+	if code := do("PUT", "/debug/admin/maintenance?on=false", &mode); code != http.StatusOK || mode["maintenance"] {
+		t.Fatalf("unexpected maintenance mode %d %v", code, mode)
+	}
+	if code := do("GET", "/api/ping", nil); code != http.StatusOK {
+		t.Fatalf("expect served after maintenance, got %d", code)
+	}
+
+	if code := do("GET", "/debug/admin/traces/3f2a", nil); code != http.StatusServiceUnavailable {
+		t.Fatalf("expect 503 without the ring buffer, got %d", code)
+	}
+	if err := dlog.AddOutput("ring://100"); err != nil {
+		t.Fatal(err)
+	}
+	defer dlog.RemoveOutput("ring://100")
+	dlog.Infof("tname=[create] tid=[3f2a] tduration=[5] created")
+	var timeline dlog.Timeline
+	if code := do("GET", "/debug/admin/traces/3f2a", &timeline); code != http.StatusOK || len(timeline.Spans) != 1 || timeline.Logs[0].Message != "created" {
+		t.Fatalf("unexpected timeline %d %+v", code, timeline)
+	}
+	if code := do("GET", "/debug/admin/traces/3f2a?format=html", nil); code != http.StatusOK {
+		t.Fatalf("unexpected code %d", code)
+	}
+	if code := do("GET", "/debug/admin/traces/unknown", nil); code != http.StatusNotFound {
+		t.Fatalf("expect 404 for the unknown traces, got %d", code)
+	}
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	do := func(s Server, method, path, remote, auth string) int {
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = remote
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// only the loopback clients are served by default
+	s := New(Admin(true))
+	if code := do(s, "PUT", "/debug/admin/maintenance?on=true", "10.0.0.1:5678", ""); code != http.StatusForbidden {
+		t.Fatalf("expect the remote clients rejected, got %d", code)
+	}
+	if code := do(s, "GET", "/debug/admin/maintenance", "[::1]:5678", ""); code != http.StatusOK {
+		t.Fatalf("expect the loopback clients served, got %d", code)
+	}
+
+	s = New(Admin(true), AdminToken("secret"))
+	for _, auth := range []string{"", "Bearer wrong"} {
+		if code := do(s, "PUT", "/debug/admin/loglevel?level=debug", "127.0.0.1:5678", auth); code != http.StatusForbidden {
+			t.Fatalf("expect %q rejected, got %d", auth, code)
+		}
+	}
+	if code := do(s, "PUT", "/debug/admin/maintenance?on=false", "10.0.0.1:5678", "Bearer secret"); code != http.StatusOK {
+		t.Fatalf("expect the token authorized, got %d", code)
+	}
+}
@@ -0,0 +1,205 @@
+// svcctl operates the services built on the server package by their debug endpoints,
+// the admin api is served with the server.Admin option and pprof with server.PProf:
+//
+//	svcctl [-addr http://127.0.0.1:8080] routes
+//	svcctl buildinfo
+//	svcctl loglevel [LEVEL] [-v N]           show or set the dlog severity and the glog verbosity
+//	svcctl maintenance [on|off]             show or toggle the maintenance mode
+//	svcctl goroutines                       dump the stacks of all the goroutines
+//	svcctl profile [-seconds 30] [-o FILE]  save a cpu profile
+//	svcctl heap [-o FILE]                   save a heap profile
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const adminPrefix = "/debug/admin"
+
+var (
+	addr    = flag.String("addr", "http://127.0.0.1:8080", "the address of the service")
+	timeout = flag.Duration("timeout", 10*time.Second, "the timeout of the requests, the profiles take longer")
+	token   = flag.String("token", "", "the token of the admin api, if the service is started with server.AdminToken")
+)
+
+var commands = map[string]func(args []string) error{
+	"routes":      getJSON(adminPrefix + "/routes"),
+	"buildinfo":   getJSON(adminPrefix + "/buildinfo"),
+	"loglevel":    loglevel,
+	"maintenance": maintenance,
+	"goroutines":  goroutines,
+	"profile":     profile,
+	"heap":        heap,
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: svcctl [-addr URL] [-token TOKEN] routes|buildinfo|loglevel|maintenance|goroutines|profile|heap [args]")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		usage()
+	}
+	if err := cmd(flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "svcctl %s: %s\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+// call requests the service, and returns the body of the 2xx responses
+func call(method, path string, query url.Values, timeout time.Duration) ([]byte, error) {
+	u := strings.TrimSuffix(*addr, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	resp, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s not found, is the admin or pprof api switched on?", path)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return body, nil
+}
+
+func printJSON(body []byte) error {
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err := out.WriteTo(os.Stdout)
+	return err
+}
+
+func getJSON(path string) func(args []string) error {
+	return func(args []string) error {
+		body, err := call("GET", path, nil, *timeout)
+		if err != nil {
+			return err
+		}
+		return printJSON(body)
+	}
+}
+
+func loglevel(args []string) error {
+	fs := flag.NewFlagSet("loglevel", flag.ExitOnError)
+	v := fs.String("v", "", "the glog verbosity")
+	fs.Parse(args)
+	query := url.Values{}
+	if fs.NArg() > 0 {
+		query.Set("level", fs.Arg(0))
+	}
+	if *v != "" {
+		query.Set("v", *v)
+	}
+	method := "GET"
+	if len(query) > 0 {
+		method = "PUT"
+	}
+	body, err := call(method, adminPrefix+"/loglevel", query, *timeout)
+	if err != nil {
+		return err
+	}
+	return printJSON(body)
+}
+
+func maintenance(args []string) error {
+	method, query := "GET", url.Values{}
+	if len(args) > 0 {
+		switch args[0] {
+		case "on":
+			query.Set("on", "true")
+		case "off":
+			query.Set("on", "false")
+		default:
+			return fmt.Errorf("expect on or off, got %s", args[0])
+		}
+		method = "PUT"
+	}
+	body, err := call(method, adminPrefix+"/maintenance", query, *timeout)
+	if err != nil {
+		return err
+	}
+	return printJSON(body)
+}
+
+func goroutines(args []string) error {
+	body, err := call("GET", "/debug/pprof/goroutine", url.Values{"debug": {"2"}}, *timeout)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(body)
+	return err
+}
+
+// save writes the profile to the file named by -o
+func save(name string, args []string, query url.Values, seconds *int, fs *flag.FlagSet) error {
+	out := fs.String("o", name+".pprof", "the file to save the profile to")
+	fs.Parse(args)
+	wait := *timeout
+	if seconds != nil {
+		query.Set("seconds", fmt.Sprint(*seconds))
+		wait += time.Duration(*seconds) * time.Second
+	}
+	body, err := call("GET", "/debug/pprof/"+name, query, wait)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, bytes.NewReader(body)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("saved to %s, see it by: go tool pprof %s\n", *out, *out)
+	return nil
+}
+
+func profile(args []string) error {
+	fs := flag.NewFlagSet("profile", flag.ExitOnError)
+	seconds := fs.Int("seconds", 30, "the duration of the cpu profile")
+	return save("profile", args, url.Values{}, seconds, fs)
+}
+
+func heap(args []string) error {
+	return save("heap", args, url.Values{}, nil, flag.NewFlagSet("heap", flag.ExitOnError))
+}
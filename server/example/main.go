@@ -1,7 +1,9 @@
 package main
 
 import (
+	"flag"
 	"io"
+	"log"
 	"net/http"
 	"os"
 
@@ -12,10 +14,19 @@ import (
 )
 
 func main() {
+	addr := flag.String("addr", ":8001", "the listen addr")
+	prefix := flag.String("prefix", "/example", "the prefix of the apis")
+	pprof := flag.Bool("pprof", false, "serve the pprof handlers under /debug/pprof")
+	admin := flag.Bool("admin", false, "serve the admin api under /debug/admin for svcctl")
+	flag.Parse()
+
 	middleware.SetDefaultResponseInterceptor(middleware.NewLogRecorder())
-	s := server.New(server.ListenAddr(":8001"), server.APIPrefix("/example"))
+	s := server.New(server.ListenAddr(*addr), server.APIPrefix(*prefix), server.PProf(*pprof), server.Admin(*admin))
 	s.Register(new(filesvr))
-	s.ListenAndServe()
+	// it serves until SIGTERM or SIGINT, then stops accepting and waits for the in-flight requests
+	if err := s.ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
 }
 
 type filesvr struct{}
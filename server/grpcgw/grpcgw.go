@@ -0,0 +1,293 @@
+// Package grpcgw serves the unary methods of the gRPC services as JSON over HTTP, for the services
+// exposing both protocols from one binary. The services are registered to the Gateway by their generated
+// Register functions like to a grpc.Server:
+//
+//	pb.RegisterGreeterServer(grpcServer, impl)
+//	pb.RegisterGreeterServer(gw, impl)
+//
+// and called by POST {prefix}/{package.Service}/{Method} with the request message in the protobuf JSON
+// mapping. The errors are translated by the errors package, and the requests are traced like the other
+// handlers of the server.
+package grpcgw
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/mux"
+	"github.com/leopoldxx/go-utils/errors"
+	"github.com/leopoldxx/go-utils/server/reply"
+	"github.com/leopoldxx/go-utils/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// the header carrying the trace id, like the trace handlers
+const requestIDHeader = "x-request-id"
+
+// the headers forwarded as the incoming metadata, besides the ones prefixed by metadataHeaderPrefix
+var forwardedHeaders = []string{"Authorization", "X-Request-Id"}
+
+// metadataHeaderPrefix is stripped from the headers forwarded as the metadata, like grpc-gateway
+const metadataHeaderPrefix = "Grpc-Metadata-"
+
+type method struct {
+	impl    interface{}
+	handler grpc.MethodHandler
+}
+
+type options struct {
+	interceptors []grpc.UnaryServerInterceptor
+	marshal      protojson.MarshalOptions
+	unmarshal    protojson.UnmarshalOptions
+}
+
+// Option of the Gateway
+type Option func(opts *options)
+
+// Interceptors sets the interceptors around the methods, in the order of grpc.ChainUnaryInterceptor,
+// pass the same ones as the grpc.Server so both protocols share the auth, the metrics and the recovery
+func Interceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(opts *options) {
+		opts.interceptors = append(opts.interceptors, interceptors...)
+	}
+}
+
+// EmitUnpopulated replies the fields of the zero values too
+func EmitUnpopulated(d bool) Option {
+	return func(opts *options) {
+		opts.marshal.EmitUnpopulated = d
+	}
+}
+
+// Gateway is an http.Handler calling the registered gRPC services
+type Gateway struct {
+	prefix  string
+	opts    options
+	methods map[string]method // by /package.Service/Method
+}
+
+var _ grpc.ServiceRegistrar = (*Gateway)(nil)
+
+// New creates a Gateway serving the methods under prefix, like "/grpc"
+func New(prefix string, opts ...Option) *Gateway {
+	gw := &Gateway{
+		prefix:  strings.TrimSuffix(prefix, "/"),
+		methods: map[string]method{},
+	}
+	gw.opts.unmarshal.DiscardUnknown = true
+	for _, opt := range opts {
+		opt(&gw.opts)
+	}
+	return gw
+}
+
+// RegisterService registers the unary methods of the service, the streaming ones are not served
+func (gw *Gateway) RegisterService(desc *grpc.ServiceDesc, impl interface{}) {
+	for _, md := range desc.Methods {
+		gw.methods["/"+desc.ServiceName+"/"+md.MethodName] = method{impl: impl, handler: md.Handler}
+	}
+}
+
+// Register mounts the gateway on the router of the server, it implements the server.Controller
+func (gw *Gateway) Register(router *mux.Router) {
+	router.PathPrefix(gw.prefix + "/").Methods("POST").Handler(gw)
+}
+
+// GinHandler returns the handler to mount the gateway on a gin router, like
+// router.POST(prefix+"/*method", gw.GinHandler())
+func (gw *Gateway) GinHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		gw.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// ServeHTTP calls the method of the path
+func (gw *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, gw.prefix)
+	trace.HandleFunc(name, func(w http.ResponseWriter, r *http.Request) {
+		gw.serve(w, r, name)
+	})(w, r)
+}
+
+func (gw *Gateway) serve(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		reply.CommReply(w, r, http.StatusMethodNotAllowed, "only POST is allowed")
+		return
+	}
+	m, ok := gw.methods[name]
+	if !ok {
+		reply.ResourceNotFound(w, r, "unknown method "+name)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		reply.BadRequest(w, r, err)
+		return
+	}
+	dec := func(req interface{}) error {
+		if len(body) == 0 {
+			return nil
+		}
+		if err := gw.opts.unmarshal.Unmarshal(body, req.(proto.Message)); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid request: %s", err)
+		}
+		return nil
+	}
+	ctx := metadata.NewIncomingContext(r.Context(), incomingMetadata(r))
+	resp, err := m.handler(m.impl, ctx, dec, gw.interceptor(name))
+	if err != nil {
+		code, msg := HTTPStatus(err)
+		trace.GetTraceFromRequest(r).Warnf("call %s failed: %s", name, err)
+		reply.CommReply(w, r, code, msg)
+		return
+	}
+	data, err := gw.opts.marshal.Marshal(resp.(proto.Message))
+	if err != nil {
+		reply.InternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// interceptor chains the interceptors for the method, nil if there are none
+func (gw *Gateway) interceptor(name string) grpc.UnaryServerInterceptor {
+	interceptors := gw.opts.interceptors
+	if len(interceptors) == 0 {
+		return nil
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		for i := len(interceptors) - 1; i > 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return interceptors[0](ctx, req, info, handler)
+	}
+}
+
+func incomingMetadata(r *http.Request) metadata.MD {
+	md := metadata.MD{}
+	for _, key := range forwardedHeaders {
+		if v := r.Header.Values(key); len(v) > 0 {
+			md.Append(key, v...)
+		}
+	}
+	for key, v := range r.Header {
+		if strings.HasPrefix(key, metadataHeaderPrefix) {
+			md.Append(strings.TrimPrefix(key, metadataHeaderPrefix), v...)
+		}
+	}
+	// the id of the trace created by the handler, maybe a new one
+	md.Set(requestIDHeader, trace.GetTraceFromRequest(r).ID())
+	return md
+}
+
+// TraceInterceptor puts a trace of the x-request-id in the metadata into the context of the gRPC calls,
+// so the handlers log with the same trace ids whichever protocol they are called by
+func TraceInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDHeader); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	// called by the gateway, traced already
+	if id != "" && trace.GetTraceFromContext(ctx).ID() == id {
+		return handler(ctx, req)
+	}
+	if id != "" {
+		ctx = trace.WithTraceForContext(ctx, info.FullMethod, id)
+	} else {
+		ctx = trace.WithTraceForContext(ctx, info.FullMethod)
+	}
+	return handler(ctx, req)
+}
+
+// ErrorInterceptor translates the errors of the errors package returned by the handlers to the gRPC
+// statuses, so the gRPC clients get the codes the HTTP clients get
+func ErrorInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err != nil {
+		err = ToStatus(err)
+	}
+	return resp, err
+}
+
+// ToStatus translates err to a gRPC status error, the status errors are returned as is
+func ToStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	var code codes.Code
+	switch {
+	case errors.IsConflictError(err):
+		code = codes.AlreadyExists
+	case errors.IsNotReadyError(err):
+		code = codes.Unavailable
+	case errors.IsTaskIsRunningError(err):
+		code = codes.FailedPrecondition
+	default:
+		switch errors.ErrSwitch(err).Code {
+		case http.StatusBadRequest:
+			code = codes.InvalidArgument
+		case http.StatusNotFound:
+			code = codes.NotFound
+		case http.StatusForbidden:
+			code = codes.PermissionDenied
+		case http.StatusInternalServerError:
+			code = codes.Internal
+		default:
+			code = codes.Unknown
+		}
+	}
+	return status.Error(code, err.Error())
+}
+
+// HTTPStatus returns the http status and the message of err, the gRPC status errors by their codes,
+// the others by the errors package
+func HTTPStatus(err error) (int, string) {
+	st, ok := status.FromError(err)
+	if !ok {
+		e := errors.ErrSwitch(err)
+		return e.Code, e.Msg
+	}
+	switch st.Code() {
+	case codes.OK:
+		return http.StatusOK, st.Message()
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest, st.Message()
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized, st.Message()
+	case codes.PermissionDenied:
+		return http.StatusForbidden, st.Message()
+	case codes.NotFound:
+		return http.StatusNotFound, st.Message()
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict, st.Message()
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests, st.Message()
+	case codes.Canceled:
+		return 499, st.Message() // client closed request
+	case codes.Unimplemented:
+		return http.StatusNotImplemented, st.Message()
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable, st.Message()
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout, st.Message()
+	}
+	return http.StatusInternalServerError, st.Message()
+}
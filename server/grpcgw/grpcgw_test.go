@@ -0,0 +1,143 @@
+package grpcgw
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/leopoldxx/go-utils/errors"
+	"github.com/leopoldxx/go-utils/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// echoServer is called like the generated code of
+//
+//	service Echo { rpc Echo(google.protobuf.StringValue) returns (google.protobuf.StringValue); }
+type echoServer struct{}
+
+func (echoServer) Echo(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	switch in.Value {
+	case "missing":
+		return nil, errors.NewNotFoundError(in.Value)
+	case "denied":
+		return nil, status.Error(codes.PermissionDenied, "denied")
+	case "trace":
+		return wrapperspb.String(trace.GetTraceFromContext(ctx).ID()), nil
+	case "metadata":
+		md, _ := metadata.FromIncomingContext(ctx)
+		return wrapperspb.String(strings.Join(md.Get("tenant"), ",")), nil
+	}
+	return in, nil
+}
+
+var echoDesc = grpc.ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*interface {
+		Echo(context.Context, *wrapperspb.StringValue) (*wrapperspb.StringValue, error)
+	})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Echo",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := new(wrapperspb.StringValue)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return srv.(echoServer).Echo(ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/test.Echo/Echo"}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.(echoServer).Echo(ctx, req.(*wrapperspb.StringValue))
+			}
+			return interceptor(ctx, in, info, handler)
+		},
+	}},
+}
+
+func TestGateway(t *testing.T) {
+	var calls []string
+	counting := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			calls = append(calls, name+" "+info.FullMethod)
+			return handler(ctx, req)
+		}
+	}
+	gw := New("/grpc", Interceptors(counting("first"), TraceInterceptor, counting("second")))
+	gw.RegisterService(&echoDesc, echoServer{})
+	router := mux.NewRouter()
+	gw.Register(router)
+
+	call := func(path, body string, header ...string) (int, string) {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code, strings.TrimSpace(w.Body.String())
+	}
+
+	for _, c := range []struct {
+		body   string
+		code   int
+		substr string
+	}{
+		{`"hello"`, http.StatusOK, `"hello"`},
+		{`"missing"`, http.StatusNotFound, "resource 'missing' is not found"},
+		{`"denied"`, http.StatusForbidden, "denied"},
+		{`{bad`, http.StatusBadRequest, "invalid request"},
+	} {
+		code, body := call("/grpc/test.Echo/Echo", c.body)
+		if code != c.code || !strings.Contains(body, c.substr) {
+			t.Fatalf("%s: unexpected reply %d %s", c.body, code, body)
+		}
+	}
+	if len(calls) != 6 || calls[0] != "first /test.Echo/Echo" || calls[1] != "second /test.Echo/Echo" {
+		t.Fatalf("unexpected interceptor calls %v", calls)
+	}
+
+	if code, body := call("/grpc/test.Echo/Echo", `"trace"`, "X-Request-Id", "req-1"); code != http.StatusOK || body != `"req-1"` {
+		t.Fatalf("expect the trace of the request id, got %d %s", code, body)
+	}
+	if code, body := call("/grpc/test.Echo/Echo", `"metadata"`, "Grpc-Metadata-Tenant", "t1"); code != http.StatusOK || body != `"t1"` {
+		t.Fatalf("expect the metadata forwarded, got %d %s", code, body)
+	}
+	if code, _ := call("/grpc/test.Echo/Missing", `"hello"`); code != http.StatusNotFound {
+		t.Fatalf("expect 404 of the unknown method, got %d", code)
+	}
+}
+
+func TestToStatus(t *testing.T) {
+	for err, expected := range map[error]codes.Code{
+		errors.NewBadRequestError("bad"):    codes.InvalidArgument,
+		errors.NewNotFoundError("x"):        codes.NotFound,
+		errors.NewConflictError("x"):        codes.AlreadyExists,
+		errors.NewForbiddenError("no"):      codes.PermissionDenied,
+		errors.NewDBError("down"):           codes.Internal,
+		errors.New("other"):                 codes.Unknown,
+		status.Error(codes.Aborted, "busy"): codes.Aborted,
+	} {
+		if code := status.Code(ToStatus(err)); code != expected {
+			t.Fatalf("%s: expect %s, got %s", err, expected, code)
+		}
+	}
+
+	for err, expected := range map[error]int{
+		errors.NewParamError("bad"):                     http.StatusBadRequest,
+		errors.NewNotFoundError("x"):                    http.StatusNotFound,
+		status.Error(codes.NotFound, "x"):               http.StatusNotFound,
+		status.Error(codes.Unavailable, "down"):         http.StatusServiceUnavailable,
+		status.Error(codes.DeadlineExceeded, "timeout"): http.StatusGatewayTimeout,
+	} {
+		if code, _ := HTTPStatus(err); code != expected {
+			t.Fatalf("%s: expect %d, got %d", err, expected, code)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tools-go/go-utils/server/scaffold"
+)
+
+// newservice -module github.com/example/order-api [-mysql] [-redis] [-dir ./order-api] order-api
+func main() {
+	var opts scaffold.Options
+	flag.StringVar(&opts.Module, "module", "", "the module path of the service, like github.com/example/order-api")
+	flag.BoolVar(&opts.MySQL, "mysql", false, "add the mysql client")
+	flag.BoolVar(&opts.Redis, "redis", false, "add the redis client")
+	dir := flag.String("dir", "", "the dir to generate the service in, ./NAME by default")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: newservice -module MODULE [-mysql] [-redis] [-dir DIR] NAME")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || opts.Module == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts.Name = flag.Arg(0)
+	if *dir == "" {
+		*dir = opts.Name
+	}
+
+	files, err := scaffold.Generate(*dir, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "newservice: %s\n", err)
+		os.Exit(1)
+	}
+	for _, f := range files {
+		fmt.Println(f)
+	}
+	fmt.Printf("cd %s && go mod tidy && go build\n", *dir)
+}
@@ -0,0 +1,94 @@
+// Package scaffold generates the skeleton of a new service built on the server, middleware and trace
+// packages, so the new services start with the same layout, config loading and shutdown.
+package scaffold
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/tools-go/go-utils/errors"
+)
+
+// Options of the generated service
+type Options struct {
+	// Name of the service, like "order-api", it's the api prefix and the name of the binary
+	Name string
+	// Module path of the service, like "github.com/example/order-api"
+	Module string
+	// MySQL adds the mysql client configured by the config file
+	MySQL bool
+	// Redis adds the redis client configured by the config file
+	Redis bool
+}
+
+var nameReg = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+func (opts Options) validate() error {
+	if !nameReg.MatchString(opts.Name) {
+		return errors.New("the name should be lowercase letters, digits and dashes, like order-api")
+	}
+	if opts.Module == "" || strings.ContainsAny(opts.Module, " \t\n\"") {
+		return errors.New("invalid module path")
+	}
+	return nil
+}
+
+// Files renders the files of the service, by their paths relative to the service dir
+func Files(opts Options) (map[string][]byte, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	for name, text := range templates {
+		var buf bytes.Buffer
+		if err := template.Must(template.New(name).Parse(text)).Execute(&buf, opts); err != nil {
+			return nil, fmt.Errorf("render %s: %s", name, err)
+		}
+		data := buf.Bytes()
+		if strings.HasSuffix(name, ".go") {
+			formatted, err := format.Source(data)
+			if err != nil {
+				return nil, fmt.Errorf("format %s: %s", name, err)
+			}
+			data = formatted
+		}
+		files[name] = data
+	}
+	return files, nil
+}
+
+// Generate writes the service into dir, which must not exist or be empty, and returns the paths written
+func Generate(dir string, opts Options) ([]string, error) {
+	files, err := Files(opts)
+	if err != nil {
+		return nil, err
+	}
+	if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("%s is not empty", dir)
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var written []string
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return written, err
+		}
+		if err := ioutil.WriteFile(path, files[name], 0644); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
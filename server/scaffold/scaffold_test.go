@@ -0,0 +1,67 @@
+package scaffold
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFiles(t *testing.T) {
+	for _, opts := range []Options{
+		{Name: "order-api", Module: "github.com/example/order-api"},
+		{Name: "order-api", Module: "github.com/example/order-api", MySQL: true, Redis: true},
+	} {
+		files, err := Files(opts)
+		if err != nil {
+			t.Fatalf("render %+v failed: %s", opts, err)
+		}
+		if len(files) != len(templates) {
+			t.Fatalf("expect %d files, got %d", len(templates), len(files))
+		}
+		main := string(files["main.go"])
+		if strings.Contains(main, "mysql.New") != opts.MySQL || strings.Contains(main, "redis.NewClient") != opts.Redis {
+			t.Fatalf("unexpected clients of %+v:\n%s", opts, main)
+		}
+		if !strings.Contains(main, `server.APIPrefix("/order-api")`) {
+			t.Fatalf("expect the api prefix of the name:\n%s", main)
+		}
+		if !strings.HasPrefix(string(files["go.mod"]), "module github.com/example/order-api\n") {
+			t.Fatalf("unexpected go.mod:\n%s", files["go.mod"])
+		}
+	}
+
+	for _, opts := range []Options{
+		{Name: "Order", Module: "github.com/example/order"},
+		{Name: "order", Module: ""},
+		{Name: "order", Module: "bad module"},
+	} {
+		if _, err := Files(opts); err == nil {
+			t.Fatalf("expect error of %+v", opts)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scaffold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := Options{Name: "demo", Module: "example.com/demo", Redis: true}
+	written, err := Generate(filepath.Join(dir, "demo"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != len(templates) {
+		t.Fatalf("unexpected files %v", written)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "demo", "controller.go")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Generate(filepath.Join(dir, "demo"), opts); err == nil {
+		t.Fatal("expect the non-empty dir rejected")
+	}
+}
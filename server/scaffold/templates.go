@@ -0,0 +1,215 @@
+package scaffold
+
+// templates of the files by their paths, rendered with the Options
+var templates = map[string]string{
+	"go.mod":        goMod,
+	"main.go":       mainGo,
+	"config.go":     configGo,
+	"controller.go": controllerGo,
+	"config.json":   configJSON,
+	"README.md":     readme,
+	".gitignore":    gitignore,
+}
+
+const goMod = `module {{.Module}}
+
+go 1.21
+`
+
+const mainGo = `package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/tools-go/go-utils/middleware"
+{{- if .MySQL}}
+	"github.com/tools-go/go-utils/mysql"
+{{- end}}
+	"github.com/tools-go/go-utils/server"
+	"github.com/tools-go/go-utils/trace/glog"
+{{- if .Redis}}
+	"github.com/redis/go-redis/v9"
+{{- end}}
+)
+
+func main() {
+	configPath := flag.String("config", "config.json", "the path of the config file")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("load config failed: %s", err)
+	}
+	glog.SetLogDir(cfg.LogDir)
+	defer glog.Flush()
+	middleware.SetDefaultResponseInterceptor(middleware.NewLogRecorder())
+
+	deps := &dependencies{}
+{{- if .MySQL}}
+	deps.db, err = mysql.New(cfg.MySQL.DSN, mysql.WithMaxConnsCount(cfg.MySQL.MaxConns), mysql.WithMaxIdleConnsCount(cfg.MySQL.MaxConns))
+	if err != nil {
+		log.Fatalf("connect mysql %s failed: %s", mysql.RedactDSN(cfg.MySQL.DSN), err)
+	}
+	defer deps.db.Close()
+{{- end}}
+{{- if .Redis}}
+	deps.redis = redis.NewClient(&redis.Options{Addr: cfg.Redis.Addr, Password: cfg.Redis.Password, DB: cfg.Redis.DB})
+	defer deps.redis.Close()
+{{- end}}
+
+	s := server.New(
+		server.ListenAddr(cfg.Listen),
+		server.APIPrefix("/{{.Name}}"),
+		server.PProf(cfg.Debug),
+		server.Admin(cfg.Debug),
+	)
+	s.Register(server.Healthz)
+	s.Register(newController(deps))
+	// it serves until SIGTERM or SIGINT, then waits for the in-flight requests before closing the clients
+	if err := s.ListenAndServe(); err != nil {
+		log.Fatalf("serve failed: %s", err)
+	}
+}
+`
+
+const configGo = `package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Config of the service, loaded from the json file, the keys match the fields case-insensitively
+type Config struct {
+	Listen string
+	LogDir string
+	// Debug serves the pprof and the admin api under /debug for svcctl
+	Debug bool
+{{- if .MySQL}}
+	MySQL struct {
+		DSN      string
+		MaxConns int
+	}
+{{- end}}
+{{- if .Redis}}
+	Redis struct {
+		Addr     string
+		Password string
+		DB       int
+	}
+{{- end}}
+}
+
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{Listen: ":8080", LogDir: "./logs"}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %s", path, err)
+	}
+{{- if .MySQL}}
+	if cfg.MySQL.MaxConns <= 0 {
+		cfg.MySQL.MaxConns = 10
+	}
+{{- end}}
+	return cfg, nil
+}
+`
+
+const controllerGo = `package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/tools-go/go-utils/middleware"
+{{- if .MySQL}}
+	"github.com/tools-go/go-utils/mysql"
+{{- end}}
+	"github.com/tools-go/go-utils/trace"
+{{- if .Redis}}
+	"github.com/redis/go-redis/v9"
+{{- end}}
+)
+
+// dependencies are the clients shared by the controllers
+type dependencies struct {
+{{- if .MySQL}}
+	db *mysql.Client
+{{- end}}
+{{- if .Redis}}
+	redis *redis.Client
+{{- end}}
+}
+
+type controller struct {
+	deps *dependencies
+}
+
+func newController(deps *dependencies) *controller {
+	return &controller{deps: deps}
+}
+
+func (c *controller) Register(router *mux.Router) {
+	chain := middleware.Chain(middleware.RecoverWithTrace("{{.Name}}"))
+	router.Methods("GET").Path("/hello").HandlerFunc(chain.HandlerFunc(c.hello))
+}
+
+func (c *controller) hello(w http.ResponseWriter, r *http.Request) {
+	tracer := trace.GetTraceFromRequest(r)
+	tracer.Info("event=[hello]")
+{{- if .MySQL}}
+	if err := c.deps.db.DB().PingContext(r.Context()); err != nil {
+		tracer.Errorf("event=[ping mysql] err=[%s]", err)
+		http.Error(w, "mysql unavailable", http.StatusServiceUnavailable)
+		return
+	}
+{{- end}}
+{{- if .Redis}}
+	if err := c.deps.redis.Ping(r.Context()).Err(); err != nil {
+		tracer.Errorf("event=[ping redis] err=[%s]", err)
+		http.Error(w, "redis unavailable", http.StatusServiceUnavailable)
+		return
+	}
+{{- end}}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"hello": "{{.Name}}"})
+}
+`
+
+const configJSON = `{
+  "listen": ":8080",
+  "logDir": "./logs",
+  "debug": false{{if .MySQL}},
+  "mysql": {
+    "dsn": "root:@tcp(127.0.0.1:3306)/{{.Name}}?parseTime=true",
+    "maxConns": 10
+  }{{end}}{{if .Redis}},
+  "redis": {
+    "addr": "127.0.0.1:6379",
+    "db": 0
+  }{{end}}
+}
+`
+
+const readme = `# {{.Name}}
+
+Generated by newservice.
+
+    go mod tidy
+    go build -o {{.Name}} .
+    ./{{.Name}} -config config.json
+    curl http://127.0.0.1:8080/{{.Name}}/hello
+
+The service stops gracefully on SIGTERM or SIGINT. With "debug" on in the config, the pprof and the admin
+api are served under /debug, for svcctl to flip the log level, toggle the maintenance mode and dump the profiles.
+`
+
+const gitignore = `/{{.Name}}
+/logs/
+`
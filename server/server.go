@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 	"net/http/pprof"
+	"strings"
 	"time"
 
 	"github.com/facebookgo/httpdown"
@@ -28,6 +29,8 @@ type options struct {
 	listenAddr      string
 	prefix          string
 	debug           bool
+	admin           bool
+	adminAuthorizer func(r *http.Request) bool
 	notfoundHandler http.Handler
 }
 
@@ -71,10 +74,12 @@ func debug(router *mux.Router) {
 }
 
 type server struct {
-	listenAddr string
-	prefix     string
-	rrouter    *mux.Router
-	router     *mux.Router
+	listenAddr  string
+	prefix      string
+	rrouter     *mux.Router
+	router      *mux.Router
+	started     time.Time
+	maintenance int32
 }
 
 // New func for server creating
@@ -90,11 +95,15 @@ func New(ops ...Option) Server {
 		listenAddr: opts.listenAddr,
 		prefix:     opts.prefix,
 		rrouter:    mux.NewRouter(),
+		started:    time.Now(),
 	}
 
 	if opts.debug == true {
 		debug(s.rrouter)
 	}
+	if opts.admin {
+		s.admin(s.rrouter, opts.adminAuthorizer)
+	}
 
 	if opts.notfoundHandler != nil {
 		s.rrouter.NotFoundHandler = opts.notfoundHandler
@@ -118,6 +127,11 @@ func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if s == nil {
 		panic("nil server")
 	}
+	if s.underMaintenance() && !strings.HasPrefix(r.URL.Path, "/debug/") {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "under maintenance", http.StatusServiceUnavailable)
+		return
+	}
 	s.rrouter.ServeHTTP(w, r)
 }
 
@@ -127,7 +141,7 @@ func (s *server) ListenAndServe() error {
 	}
 	httpServer := &http.Server{
 		Addr:    s.listenAddr,
-		Handler: s.rrouter,
+		Handler: s,
 	}
 
 	hd := &httpdown.HTTP{
@@ -0,0 +1,208 @@
+// Package servertest runs the Controllers in a real server on a random port for the contract tests,
+// with a client injecting the trace ids, the golden file assertions and the fakes for the deterministic handlers
+package servertest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tools-go/go-utils/httputils"
+	"github.com/tools-go/go-utils/server"
+	"github.com/tools-go/go-utils/trace"
+	"github.com/tools-go/go-utils/utils/clock"
+)
+
+// Option for the Server
+type Option func(s *Server)
+
+// WithControllers registers the controllers under test
+func WithControllers(ctrls ...server.Controller) Option {
+	return func(s *Server) {
+		s.ctrls = append(s.ctrls, ctrls...)
+	}
+}
+
+// WithServerOptions sets the options of the server, like server.APIPrefix
+func WithServerOptions(opts ...server.Option) Option {
+	return func(s *Server) {
+		s.opts = append(s.opts, opts...)
+	}
+}
+
+// WithIDs sets the generator of the request ids injected by the client, the default is FakeIDs("req")
+func WithIDs(ids func() string) Option {
+	return func(s *Server) {
+		s.ids = ids
+	}
+}
+
+// Server is a server started for a test, it's closed when the test finishes
+type Server struct {
+	// URL is the base url of the server, like http://127.0.0.1:34567
+	URL string
+
+	ctrls []server.Controller
+	opts  []server.Option
+	ids   func() string
+	srv   *httptest.Server
+}
+
+// Start starts a server with the controllers on a random port
+func Start(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+	s := &Server{ids: FakeIDs("req")}
+	for _, opt := range opts {
+		opt(s)
+	}
+	svr := server.New(s.opts...)
+	for _, ctrl := range s.ctrls {
+		svr.Register(ctrl)
+	}
+	s.srv = httptest.NewServer(trace.Handler("servertest", svr))
+	s.URL = s.srv.URL
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+// NewRequest creates a request to the path of the server, body is sent as json unless it's an io.Reader
+func (s *Server) NewRequest(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	headers := map[string]string{}
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reader = b
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+		headers["Content-Type"] = "application/json"
+	}
+	return httputils.NewRequest(ctx, method, s.URL+path, headers, nil, reader)
+}
+
+// Do sends the request, the trace id of the request context is injected as the request id,
+// or a new one from the ids if the context has no trace, the test fails if the request can't be sent
+func (s *Server) Do(t testing.TB, req *http.Request) *httputils.Response {
+	t.Helper()
+	if req.Header.Get("x-request-id") == "" {
+		id := s.ids()
+		if tracer, ok := traceOf(req.Context()); ok {
+			id = tracer.ID()
+		}
+		req.Header.Set("x-request-id", id)
+	}
+	resp, err := httputils.ClientDo(s.srv.Client(), req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+	}
+	return resp
+}
+
+// traceOf returns the trace in ctx, GetTraceFromContext can't tell whether it's a default one
+func traceOf(ctx context.Context) (trace.Trace, bool) {
+	tracer := trace.GetTraceFromContext(ctx)
+	return tracer, tracer.Name() != "default-trace"
+}
+
+// Request creates and sends a request, like Do
+func (s *Server) Request(t testing.TB, method, path string, body interface{}) *httputils.Response {
+	t.Helper()
+	req, err := s.NewRequest(context.Background(), method, path, body)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return s.Do(t, req)
+}
+
+// Get sends a GET request to the path
+func (s *Server) Get(t testing.TB, path string) *httputils.Response {
+	t.Helper()
+	return s.Request(t, http.MethodGet, path, nil)
+}
+
+// Post sends a POST request with the body to the path
+func (s *Server) Post(t testing.TB, path string, body interface{}) *httputils.Response {
+	t.Helper()
+	return s.Request(t, http.MethodPost, path, body)
+}
+
+// GoldenHeaders are the response headers saved in the golden files, the others are mostly volatile
+var GoldenHeaders = []string{"Content-Type", "X-Request-Id"}
+
+// UpdateEnv is the environment variable to set for rewriting the golden files, like UPDATE_GOLDEN=1 go test ./...
+const UpdateEnv = "UPDATE_GOLDEN"
+
+// Golden formats the response as saved in the golden files, the json bodies are indented
+func Golden(resp *httputils.Response) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d %s\n", resp.Status, http.StatusText(resp.Status))
+	for _, h := range GoldenHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			fmt.Fprintf(&buf, "%s: %s\n", h, v)
+		}
+	}
+	buf.WriteString("\n")
+	var indented bytes.Buffer
+	if json.Indent(&indented, resp.Body, "", "  ") == nil {
+		buf.Write(indented.Bytes())
+	} else {
+		buf.Write(resp.Body)
+	}
+	if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// AssertGolden compares the response with testdata/<name>.golden, the file is rewritten if UPDATE_GOLDEN is set
+func AssertGolden(t testing.TB, resp *httputils.Response, name string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	got := Golden(resp)
+	if os.Getenv(UpdateEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file failed, run with %s=1 to create it: %v", UpdateEnv, err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Fatalf("response mismatched with %s:\n--- got\n%s--- expected\n%s", path, got, expected)
+	}
+}
+
+// FakeIDs returns a generator of the ids prefix-1, prefix-2, ..., safe for the concurrent use
+func FakeIDs(prefix string) func() string {
+	var n int64
+	return func() string {
+		return fmt.Sprintf("%s-%d", prefix, atomic.AddInt64(&n, 1))
+	}
+}
+
+// FakeClock is a clock moving only when told, for the handlers taking a clock.Clock
+type FakeClock = clock.FakeClock
+
+// NewFakeClock creates a FakeClock at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return clock.NewFakeClock(now)
+}
@@ -0,0 +1,52 @@
+package servertest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tools-go/go-utils/server"
+	"github.com/tools-go/go-utils/server/reply"
+	"github.com/tools-go/go-utils/trace"
+)
+
+type orders struct {
+	now func() time.Time
+	ids func() string
+}
+
+func (o *orders) Register(router *mux.Router) {
+	router.Path("/orders").Methods(http.MethodPost).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reply.Reply(w, r, http.StatusCreated, map[string]interface{}{
+			"id":         o.ids(),
+			"created_at": o.now().UTC().Format(time.RFC3339),
+			"trace":      trace.GetTraceFromRequest(r).ID(),
+		})
+	})
+}
+
+func TestServer(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	s := Start(t,
+		WithControllers(&orders{now: clock.Now, ids: FakeIDs("order")}),
+		WithServerOptions(server.APIPrefix("/api")),
+	)
+
+	AssertGolden(t, s.Post(t, "/api/orders", map[string]string{"item": "book"}), "create_order")
+
+	clock.Advance(time.Hour)
+	ctx := trace.WithTraceForContext(context.Background(), "caller", "upstream-1")
+	req, err := s.NewRequest(ctx, http.MethodPost, "/api/orders", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := s.Do(t, req)
+	if resp.Header.Get("X-Request-Id") != "upstream-1" {
+		t.Fatalf("expect the trace id injected, got %s", Golden(resp))
+	}
+	if resp := s.Get(t, "/api/orders"); resp.Status != http.StatusMethodNotAllowed && resp.Status != http.StatusNotFound {
+		t.Fatalf("unexpected status %d", resp.Status)
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"git.xiaojukeji.com/devops/MachineAlliance/self/commons/trace/dlog"
+	"github.com/gorilla/mux"
+)
+
+// timelinePage renders the timeline as a gantt of the spans, followed by the logs
+var timelinePage = template.Must(template.New("timeline").Funcs(template.FuncMap{
+	"ms": func(v float64) string { return strconv.FormatFloat(v, 'f', 1, 64) + "ms" },
+	"pct": func(v, total float64) string {
+		if total <= 0 {
+			return "0"
+		}
+		return strconv.FormatFloat(v*100/total, 'f', 2, 64)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>trace {{.TraceID}}</title>
+<style>
+body { font: 13px monospace; margin: 16px; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 24px; }
+td { padding: 2px 6px; vertical-align: top; white-space: nowrap; }
+td.track { width: 60%; }
+div.bar { background: #4a90d9; height: 14px; min-width: 2px; }
+td.msg { white-space: pre-wrap; }
+tr.WARNING { background: #fff6d5; }
+tr.ERROR, tr.FATAL { background: #fde2e1; }
+</style>
+</head>
+<body>
+<h3>trace {{.TraceID}} started at {{.Start.Format "2006-01-02 15:04:05.000000"}}, {{ms .DurationMS}}</h3>
+<table>
+{{- range .Spans}}
+<tr><td>{{range .Ancestors}}&nbsp;&nbsp;{{end}}{{.Name}}</td><td class="track"><div class="bar" style="margin-left: {{pct .StartMS $.DurationMS}}%; width: {{pct .DurationMS $.DurationMS}}%"></div></td><td>+{{ms .StartMS}}</td><td>{{ms .DurationMS}}</td><td>{{.Logs}} logs</td></tr>
+{{- end}}
+</table>
+<table>
+{{- range .Logs}}
+<tr class="{{.Level}}"><td>+{{ms .OffsetMS}}</td><td>{{.Level}}</td><td>{{.Span}}</td><td>{{.Caller}}</td><td class="msg">{{.Message}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// traceTimeline replies the timeline of the trace in the ring buffer of dlog, in json or ?format=html
+func (s *server) traceTimeline(w http.ResponseWriter, r *http.Request) {
+	timeline, err := dlog.TraceTimeline(mux.Vars(r)["id"])
+	if err == dlog.ErrTraceNotFound {
+		replyError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		replyError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		replyJSON(w, http.StatusOK, timeline)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		timelinePage.Execute(w, timeline)
+	default:
+		replyError(w, http.StatusBadRequest, "format should be json or html")
+	}
+}
@@ -0,0 +1,79 @@
+package sessions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidCookie is returned when a cookie is tampered, or encrypted by an unknown key
+var ErrInvalidCookie = errors.New("invalid cookie")
+
+// Codec encrypts and authenticates the cookie values by AES-GCM.
+// The first key encrypts the new values, and all the keys decrypt, so the keys can be rotated
+// by prepending the new one and removing the old one after the cookies expire.
+type Codec struct {
+	aeads []cipher.AEAD
+}
+
+// NewCodec creates a codec of the keys, each key is 16, 24 or 32 bytes,
+// e.g. loaded from the secrets provider
+func NewCodec(keys ...[]byte) (*Codec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no cookie key")
+	}
+	c := &Codec{}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cookie key %d: %s", i, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+// Encode encrypts v as json, the cookie name is authenticated too, so a value can not be moved to another cookie
+func (c *Codec) Encode(name string, v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	aead := c.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, data, []byte(name))), nil
+}
+
+// Decode decrypts the value of the cookie into v
+func (c *Codec) Decode(name, value string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return ErrInvalidCookie
+	}
+	for _, aead := range c.aeads {
+		n := aead.NonceSize()
+		if len(data) < n {
+			return ErrInvalidCookie
+		}
+		plain, err := aead.Open(nil, data[:n], data[n:], []byte(name))
+		if err != nil {
+			continue
+		}
+		if err := json.Unmarshal(plain, v); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidCookie, err)
+		}
+		return nil
+	}
+	return ErrInvalidCookie
+}
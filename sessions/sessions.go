@@ -0,0 +1,370 @@
+// Package sessions keeps the server side sessions of the gin services.
+//
+// The session ID is kept in a cookie encrypted by the Codec, and the session data is kept in a Store,
+// like the memory or redis. A session expires after it is idle for the idle timeout,
+// or after the absolute timeout since it was created, no matter it is active or not.
+package sessions
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tools-go/go-utils/dtrace"
+	"github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/ginmiddleware"
+)
+
+// the gin context key of the current session
+const sessionKey = "sessions.session"
+
+// the session value key of the CSRF token
+const csrfKey = "_csrf"
+
+// Session is the session of the current request, it is not safe for the concurrent use
+type Session struct {
+	id       string
+	created  time.Time
+	lastSeen time.Time
+	values   map[string]string
+
+	isNew     bool
+	hasCookie bool
+	dirty     bool
+	destroyed bool
+}
+
+// record is the stored data of a session
+type record struct {
+	Created  time.Time         `json:"created"`
+	LastSeen time.Time         `json:"lastSeen"`
+	Values   map[string]string `json:"values,omitempty"`
+}
+
+func newSession(id string) *Session {
+	if id == "" {
+		id = randomString(32)
+	}
+	now := time.Now()
+	return &Session{id: id, created: now, lastSeen: now, values: map[string]string{}, isNew: true}
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ID returns the session ID
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Created returns the time the session was created
+func (s *Session) Created() time.Time {
+	return s.created
+}
+
+// IsNew reports whether the session was created by the current request
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+// Get returns the value of key, empty if absent
+func (s *Session) Get(key string) string {
+	return s.values[key]
+}
+
+// Set sets the value of key
+func (s *Session) Set(key, value string) {
+	s.values[key] = value
+	s.dirty = true
+}
+
+// Delete deletes the value of key
+func (s *Session) Delete(key string) {
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.dirty = true
+	}
+}
+
+// Encode sets the value of key as the json of v
+func (s *Session) Encode(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.Set(key, string(data))
+	return nil
+}
+
+// Decode decodes the json value of key into v, a NotFound error is returned if absent
+func (s *Session) Decode(key string, v interface{}) error {
+	data, ok := s.values[key]
+	if !ok {
+		return errors.NewNotFoundError("session value " + key)
+	}
+	return json.Unmarshal([]byte(data), v)
+}
+
+// CSRFToken returns the synchronizer token of the session, it is created on the first call,
+// and renewed when the session is regenerated
+func (s *Session) CSRFToken() string {
+	token := s.values[csrfKey]
+	if token == "" {
+		token = randomString(32)
+		s.Set(csrfKey, token)
+	}
+	return token
+}
+
+// VerifyCSRF reports whether token is the synchronizer token of the session
+func (s *Session) VerifyCSRF(token string) bool {
+	expected := s.values[csrfKey]
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
+// Get returns the session of the request established by the middleware of Manager, nil if absent
+func Get(c *gin.Context) *Session {
+	s, _ := c.Value(sessionKey).(*Session)
+	return s
+}
+
+type options struct {
+	cookieName      string
+	cookiePath      string
+	cookieDomain    string
+	secure          bool
+	sameSite        http.SameSite
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+}
+
+// Option is the option of Manager
+type Option func(opts *options)
+
+// WithCookieName sets the name of the session cookie, "session" by default
+func WithCookieName(name string) Option {
+	return func(opts *options) {
+		opts.cookieName = name
+	}
+}
+
+// WithCookiePath sets the path of the session cookie, "/" by default
+func WithCookiePath(path string) Option {
+	return func(opts *options) {
+		opts.cookiePath = path
+	}
+}
+
+// WithCookieDomain sets the domain of the session cookie
+func WithCookieDomain(domain string) Option {
+	return func(opts *options) {
+		opts.cookieDomain = domain
+	}
+}
+
+// WithSecure sends the session cookie over https only
+func WithSecure(secure bool) Option {
+	return func(opts *options) {
+		opts.secure = secure
+	}
+}
+
+// WithSameSite sets the SameSite of the session cookie, Lax by default
+func WithSameSite(sameSite http.SameSite) Option {
+	return func(opts *options) {
+		opts.sameSite = sameSite
+	}
+}
+
+// WithIdleTimeout expires the sessions idle for d, 30 minutes by default
+func WithIdleTimeout(d time.Duration) Option {
+	return func(opts *options) {
+		opts.idleTimeout = d
+	}
+}
+
+// WithAbsoluteTimeout expires the sessions d after they are created, 24 hours by default
+func WithAbsoluteTimeout(d time.Duration) Option {
+	return func(opts *options) {
+		opts.absoluteTimeout = d
+	}
+}
+
+// Manager loads and saves the sessions
+type Manager struct {
+	store Store
+	codec *Codec
+	opts  options
+}
+
+// New creates a manager of the store, codec encrypts the session cookies
+func New(store Store, codec *Codec, opts ...Option) *Manager {
+	m := &Manager{
+		store: store,
+		codec: codec,
+		opts: options{
+			cookieName:      "session",
+			cookiePath:      "/",
+			sameSite:        http.SameSiteLaxMode,
+			idleTimeout:     30 * time.Minute,
+			absoluteTimeout: 24 * time.Hour,
+		},
+	}
+	for _, opt := range opts {
+		opt(&m.opts)
+	}
+	return m
+}
+
+// Middleware loads the session of the request before the handler, the handler gets it by Get,
+// and the changed session is saved after the handler. The last seen time of an unchanged session
+// is saved at most once every quarter of the idle timeout, to save the writes of the store.
+func (m *Manager) Middleware() ginmiddleware.Middleware {
+	return func(next gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			tracer := dtrace.GetTraceFromContext(c)
+			s, err := m.load(c)
+			if err != nil {
+				tracer.Errorf("load session failed: %s", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, errors.ErrSwitch(errors.NewServerError("load session failed")))
+				return
+			}
+			// the cookie is set before the handler writes the response
+			if !s.hasCookie {
+				m.setCookie(c, s)
+			}
+			c.Set(sessionKey, s)
+			next(c)
+
+			if err := m.save(c, s); err != nil {
+				tracer.Errorf("save session failed: %s", err)
+			}
+		}
+	}
+}
+
+func (m *Manager) load(c *gin.Context) (*Session, error) {
+	cookie, err := c.Request.Cookie(m.opts.cookieName)
+	if err != nil {
+		return newSession(""), nil
+	}
+	var id string
+	if err := m.codec.Decode(m.opts.cookieName, cookie.Value, &id); err != nil || id == "" {
+		return newSession(""), nil
+	}
+	data, err := m.store.Load(c, id)
+	if errors.IsNotFoundError(err) {
+		// the ID was issued by us but never saved, reuse it to avoid resetting the cookie on every request,
+		// the handlers should Regenerate the session after the login, so a planted ID is useless
+		s := newSession(id)
+		s.hasCookie = true
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := &record{}
+	if err := json.Unmarshal(data, r); err != nil {
+		dtrace.GetTraceFromContext(c).Warnf("drop the corrupted session: %s", err)
+		return newSession(""), nil
+	}
+	now := time.Now()
+	if now.Sub(r.LastSeen) > m.opts.idleTimeout || now.Sub(r.Created) > m.opts.absoluteTimeout {
+		if err := m.store.Delete(c, id); err != nil {
+			return nil, err
+		}
+		return newSession(""), nil
+	}
+	if r.Values == nil {
+		r.Values = map[string]string{}
+	}
+	return &Session{id: id, created: r.Created, lastSeen: r.LastSeen, values: r.Values, hasCookie: true}, nil
+}
+
+func (m *Manager) save(c *gin.Context, s *Session) error {
+	now := time.Now()
+	if s.destroyed || !s.dirty && (s.isNew || now.Sub(s.lastSeen) < m.opts.idleTimeout/4) {
+		return nil
+	}
+	ttl := m.opts.idleTimeout
+	if rest := m.opts.absoluteTimeout - now.Sub(s.created); rest < ttl {
+		ttl = rest
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(&record{Created: s.created, LastSeen: now, Values: s.values})
+	if err != nil {
+		return err
+	}
+	if err := m.store.Save(c, s.id, data, ttl); err != nil {
+		return err
+	}
+	s.lastSeen, s.dirty = now, false
+	return nil
+}
+
+// Regenerate changes the ID of the session and renews its CSRF token, the values are kept.
+// It should be called when the privilege changes, like the login, to prevent the session fixation,
+// and before the handler writes the response, as the cookie is reset.
+func (m *Manager) Regenerate(c *gin.Context) error {
+	s := Get(c)
+	if s == nil {
+		return errors.NewNotFoundError("session")
+	}
+	if !s.isNew {
+		if err := m.store.Delete(c, s.id); err != nil {
+			return err
+		}
+	}
+	s.id = randomString(32)
+	delete(s.values, csrfKey)
+	s.dirty = true
+	m.setCookie(c, s)
+	return nil
+}
+
+// Destroy deletes the session and its cookie, like the logout
+func (m *Manager) Destroy(c *gin.Context) error {
+	s := Get(c)
+	if s == nil {
+		return nil
+	}
+	if err := m.store.Delete(c, s.id); err != nil {
+		return err
+	}
+	s.values = map[string]string{}
+	s.destroyed = true
+	http.SetCookie(c.Writer, m.cookie("", -1))
+	return nil
+}
+
+func (m *Manager) setCookie(c *gin.Context, s *Session) {
+	value, err := m.codec.Encode(m.opts.cookieName, s.id)
+	if err != nil {
+		dtrace.GetTraceFromContext(c).Errorf("encode session cookie failed: %s", err)
+		return
+	}
+	maxAge := int(time.Until(s.created.Add(m.opts.absoluteTimeout)).Round(time.Second) / time.Second)
+	http.SetCookie(c.Writer, m.cookie(value, maxAge))
+}
+
+func (m *Manager) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     m.opts.cookieName,
+		Value:    value,
+		Path:     m.opts.cookiePath,
+		Domain:   m.opts.cookieDomain,
+		MaxAge:   maxAge,
+		Secure:   m.opts.secure,
+		HttpOnly: true,
+		SameSite: m.opts.sameSite,
+	}
+}
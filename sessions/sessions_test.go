@@ -0,0 +1,202 @@
+package sessions
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/tools-go/go-utils/errors"
+)
+
+func TestCodecRotation(t *testing.T) {
+	oldKey, newKey := []byte("0123456789abcdef"), []byte("fedcba9876543210")
+	old, _ := NewCodec(oldKey)
+	value, err := old.Encode("session", "id1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, _ := NewCodec(newKey, oldKey)
+	var id string
+	if err := rotated.Decode("session", value, &id); err != nil || id != "id1" {
+		t.Fatalf("unexpected %s %v", id, err)
+	}
+	if err := rotated.Decode("other", value, &id); err != ErrInvalidCookie {
+		t.Fatalf("expect invalid cookie of another name, got %v", err)
+	}
+	fresh, _ := rotated.Encode("session", "id2")
+	if err := old.Decode("session", fresh, &id); err != ErrInvalidCookie {
+		t.Fatalf("expect the new key unknown to the old codec, got %v", err)
+	}
+	if _, err := NewCodec([]byte("short")); err == nil {
+		t.Fatal("expect invalid key")
+	}
+}
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	if _, err := store.Load(ctx, "s1"); !errors.IsNotFoundError(err) {
+		t.Fatalf("expect not found, got %v", err)
+	}
+	if err := store.Save(ctx, "s1", []byte("data"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := store.Load(ctx, "s1"); err != nil || string(data) != "data" {
+		t.Fatalf("unexpected %s %v", data, err)
+	}
+	if err := store.Delete(ctx, "s1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(ctx, "s1"); !errors.IsNotFoundError(err) {
+		t.Fatalf("expect not found, got %v", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	testStore(t, store)
+	store.Save(context.Background(), "s2", []byte("data"), time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if _, err := store.Load(context.Background(), "s2"); !errors.IsNotFoundError(err) {
+		t.Fatalf("expect expired, got %v", err)
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	store := NewRedisStore(client, "session:")
+	testStore(t, store)
+
+	store.Save(context.Background(), "s2", []byte("data"), time.Minute)
+	if !mr.Exists("session:s2") || mr.TTL("session:s2") != time.Minute {
+		t.Fatal("expect the prefixed key with ttl")
+	}
+}
+
+type client struct {
+	t       *testing.T
+	handler http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func (cl *client) get(path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for _, c := range cl.cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	cl.handler.ServeHTTP(w, req)
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge < 0 {
+			delete(cl.cookies, c.Name)
+		} else {
+			cl.cookies[c.Name] = c
+		}
+	}
+	return w
+}
+
+func newTestServer(t *testing.T, m *Manager) *client {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	handle := func(path string, fn gin.HandlerFunc) {
+		r.GET(path, m.Middleware().HandlerFunc(fn))
+	}
+	handle("/visit", func(c *gin.Context) {
+		c.String(http.StatusOK, Get(c).Get("user"))
+	})
+	handle("/login", func(c *gin.Context) {
+		if err := m.Regenerate(c); err != nil {
+			t.Fatal(err)
+		}
+		Get(c).Set("user", c.Query("user"))
+		c.String(http.StatusOK, Get(c).ID())
+	})
+	handle("/logout", func(c *gin.Context) {
+		if err := m.Destroy(c); err != nil {
+			t.Fatal(err)
+		}
+	})
+	handle("/csrf", func(c *gin.Context) {
+		c.String(http.StatusOK, Get(c).CSRFToken())
+	})
+	return &client{t: t, handler: r, cookies: map[string]*http.Cookie{}}
+}
+
+func TestManager(t *testing.T) {
+	codec, _ := NewCodec([]byte("0123456789abcdef"))
+	store := NewMemoryStore()
+	cl := newTestServer(t, New(store, codec))
+
+	// an anonymous visit sets the cookie once, but saves nothing
+	cl.get("/visit")
+	anonymous := cl.cookies["session"]
+	if anonymous == nil || !anonymous.HttpOnly || anonymous.MaxAge != 86400 {
+		t.Fatalf("unexpected cookie %+v", anonymous)
+	}
+	if w := cl.get("/visit"); len(w.Result().Cookies()) != 0 {
+		t.Fatal("expect the cookie not reset")
+	}
+
+	csrf := cl.get("/csrf").Body.String()
+	if csrf == "" || cl.get("/csrf").Body.String() != csrf {
+		t.Fatal("expect a stable csrf token")
+	}
+
+	id := cl.get("/login?user=alice").Body.String()
+	if cl.cookies["session"].Value == anonymous.Value {
+		t.Fatal("expect the session regenerated")
+	}
+	if w := cl.get("/visit"); w.Body.String() != "alice" {
+		t.Fatalf("unexpected user %s", w.Body.String())
+	}
+	if cl.get("/csrf").Body.String() == csrf {
+		t.Fatal("expect the csrf token renewed")
+	}
+
+	// the old cookie is useless
+	planted := &client{t: t, handler: cl.handler, cookies: map[string]*http.Cookie{"session": anonymous}}
+	if w := planted.get("/visit"); w.Body.String() != "" {
+		t.Fatalf("unexpected user %s", w.Body.String())
+	}
+
+	cl.get("/logout")
+	if _, err := store.Load(context.Background(), id); !errors.IsNotFoundError(err) {
+		t.Fatalf("expect destroyed, got %v", err)
+	}
+	if w := cl.get("/visit"); w.Body.String() != "" {
+		t.Fatalf("unexpected user %s", w.Body.String())
+	}
+}
+
+func TestManagerExpiry(t *testing.T) {
+	codec, _ := NewCodec([]byte("0123456789abcdef"))
+	for _, opt := range []Option{WithIdleTimeout(50 * time.Millisecond), WithAbsoluteTimeout(50 * time.Millisecond)} {
+		cl := newTestServer(t, New(NewMemoryStore(), codec, opt, WithCookieName("sid")))
+		cl.get("/login?user=alice")
+		if w := cl.get("/visit"); w.Body.String() != "alice" {
+			t.Fatalf("unexpected user %s", w.Body.String())
+		}
+		time.Sleep(60 * time.Millisecond)
+		if w := cl.get("/visit"); w.Body.String() != "" {
+			t.Fatalf("expect expired, got %s", w.Body.String())
+		}
+	}
+
+	// the idle timeout is extended by the visits
+	cl := newTestServer(t, New(NewMemoryStore(), codec, WithIdleTimeout(80*time.Millisecond)))
+	cl.get("/login?user=alice")
+	for i := 0; i < 4; i++ {
+		time.Sleep(30 * time.Millisecond)
+		if w := cl.get("/visit"); w.Body.String() != "alice" {
+			t.Fatalf("expect alive, got %q", w.Body.String())
+		}
+	}
+}
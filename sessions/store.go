@@ -0,0 +1,98 @@
+package sessions
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tools-go/go-utils/errors"
+)
+
+// Store keeps the session data by the session IDs
+type Store interface {
+	// Load returns the data of the session, a NotFound error of the errors package is returned
+	// if the session does not exist or is expired
+	Load(ctx context.Context, id string) ([]byte, error)
+	// Save saves the data of the session, which expires after ttl
+	Save(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	Delete(ctx context.Context, id string) error
+}
+
+type memoryItem struct {
+	data    []byte
+	expires time.Time
+}
+
+type memoryStore struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+	saves int
+}
+
+// the expired sessions of the memory store are swept every sweepInterval saves
+const sweepInterval = 1000
+
+// NewMemoryStore creates a store in the memory, which is for the tests and the single instance services
+func NewMemoryStore() Store {
+	return &memoryStore{items: map[string]memoryItem{}}
+}
+
+func (s *memoryStore) Load(ctx context.Context, id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[id]
+	if !ok || time.Now().After(item.expires) {
+		delete(s.items, id)
+		return nil, errors.NewNotFoundError("session")
+	}
+	return item.data, nil
+}
+
+func (s *memoryStore) Save(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[id] = memoryItem{data: data, expires: time.Now().Add(ttl)}
+	if s.saves++; s.saves%sweepInterval == 0 {
+		now := time.Now()
+		for id, item := range s.items {
+			if now.After(item.expires) {
+				delete(s.items, id)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, id)
+	return nil
+}
+
+type redisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisStore creates a store in redis, the keys are prefix + session ID, like "session:<id>"
+func NewRedisStore(client redis.UniversalClient, prefix string) Store {
+	return &redisStore{client: client, prefix: prefix}
+}
+
+func (s *redisStore) Load(ctx context.Context, id string) ([]byte, error) {
+	data, err := s.client.Get(ctx, s.prefix+id).Bytes()
+	if err == redis.Nil {
+		return nil, errors.NewNotFoundError("session")
+	}
+	return data, err
+}
+
+func (s *redisStore) Save(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, s.prefix+id, data, ttl).Err()
+}
+
+func (s *redisStore) Delete(ctx context.Context, id string) error {
+	return s.client.Del(ctx, s.prefix+id).Err()
+}
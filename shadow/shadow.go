@@ -0,0 +1,264 @@
+// Package shadow mirrors a sample of the requests to a shadow upstream for the migration testing,
+// the shadow responses are discarded, only the differences of the status and the latency are recorded.
+//
+// By default only the GET and HEAD requests are mirrored, so the writes are never applied twice,
+// and the credentials like the Authorization and Cookie headers are not sent to the shadow.
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tools-go/go-utils/httputils"
+	"github.com/tools-go/go-utils/middleware"
+	"github.com/tools-go/go-utils/trace"
+)
+
+// Diff is the comparison of a request served by the primary and the shadow
+type Diff struct {
+	Method        string
+	URL           string
+	Status        int
+	ShadowStatus  int
+	Latency       time.Duration
+	ShadowLatency time.Duration
+	// Err is the error of sending to the shadow
+	Err error
+}
+
+// Mismatched reports whether the shadow failed or replied a different status
+func (d Diff) Mismatched() bool {
+	return d.Err != nil || d.Status != d.ShadowStatus
+}
+
+// Stats of the Mirror
+type Stats struct {
+	Mirrored   int64
+	Dropped    int64 // the sampled requests dropped as too many are in flight or the bodies are too large
+	Errors     int64
+	Mismatches int64 // the requests with different status, including the errors
+}
+
+// Config of the Mirror
+type Config struct {
+	// Upstream is the base url of the shadow, like http://shadow.internal:8080
+	Upstream string
+	// Percent of the requests mirrored, 0 to 100
+	Percent float64
+	// Filter selects the requests to mirror, only the GET and HEAD requests are selected if it's nil.
+	// Select the writes only if the shadow has its own storage, or they will be applied twice.
+	Filter func(r *http.Request) bool
+	// ForwardCredentials forwards the credential headers, like Authorization and Cookie, to the shadow,
+	// they are removed by default
+	ForwardCredentials bool
+	// Client sends the mirrored requests, the default is httputils.DefaultHTTPClient with a 5s timeout
+	Client *http.Client
+	// MaxBody is the max size of the bodies to mirror, the default is 1MB
+	MaxBody int64
+	// MaxInflight is the max count of the mirrored requests in flight, the others are dropped, the default is 16
+	MaxInflight int
+	// Observer is called with the diff of every mirrored request
+	Observer func(ctx context.Context, d Diff)
+}
+
+// credentialHeaders are removed from the mirrored requests unless Config.ForwardCredentials is set
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// ReadOnly selects the GET and HEAD requests, it's the default Filter
+func ReadOnly(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// Mirror mirrors the requests to the shadow
+type Mirror struct {
+	cfg      Config
+	upstream *url.URL
+	inflight chan struct{}
+
+	mu   sync.Mutex
+	rand *rand.Rand
+
+	mirrored, dropped, errors, mismatches int64
+}
+
+// New creates a Mirror
+func New(cfg Config) (*Mirror, error) {
+	upstream, err := url.Parse(cfg.Upstream)
+	if err != nil || upstream.Scheme == "" || upstream.Host == "" {
+		return nil, fmt.Errorf("invalid shadow upstream %q", cfg.Upstream)
+	}
+	if cfg.Filter == nil {
+		cfg.Filter = ReadOnly
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Transport: httputils.DefaultHTTPClient.Transport, Timeout: 5 * time.Second}
+	}
+	if cfg.MaxBody <= 0 {
+		cfg.MaxBody = 1 << 20
+	}
+	if cfg.MaxInflight <= 0 {
+		cfg.MaxInflight = 16
+	}
+	return &Mirror{
+		cfg:      cfg,
+		upstream: upstream,
+		inflight: make(chan struct{}, cfg.MaxInflight),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// Stats returns the counters
+func (m *Mirror) Stats() Stats {
+	return Stats{
+		Mirrored:   atomic.LoadInt64(&m.mirrored),
+		Dropped:    atomic.LoadInt64(&m.dropped),
+		Errors:     atomic.LoadInt64(&m.errors),
+		Mismatches: atomic.LoadInt64(&m.mismatches),
+	}
+}
+
+func (m *Mirror) sampled(r *http.Request) bool {
+	// never mirror the mirrored requests, in case the shadow mirrors too
+	if r.Header.Get("X-Shadow") != "" {
+		return false
+	}
+	if !m.cfg.Filter(r) {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rand.Float64()*100 < m.cfg.Percent
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Middleware serves the requests as usual, and mirrors the sampled ones to the shadow asynchronously
+// after they are served, so the primary is never slowed down by the shadow
+func (m *Mirror) Middleware() middleware.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !m.sampled(r) {
+				next(w, r)
+				return
+			}
+			var body []byte
+			if r.Body != nil && r.Body != http.NoBody {
+				var err error
+				body, err = ioutil.ReadAll(io.LimitReader(r.Body, m.cfg.MaxBody+1))
+				// the body is restored for the primary, whether it's mirrored or not
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+				if err != nil || int64(len(body)) > m.cfg.MaxBody {
+					atomic.AddInt64(&m.dropped, 1)
+					next(w, r)
+					return
+				}
+			}
+			req, err := m.shadowRequest(r, body)
+			if err != nil {
+				atomic.AddInt64(&m.dropped, 1)
+				next(w, r)
+				return
+			}
+
+			sw := &statusWriter{ResponseWriter: w}
+			start := time.Now()
+			next(sw, r)
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			diff := Diff{Method: r.Method, URL: r.URL.String(), Status: sw.status, Latency: time.Since(start)}
+
+			select {
+			case m.inflight <- struct{}{}:
+			default:
+				atomic.AddInt64(&m.dropped, 1)
+				return
+			}
+			go func() {
+				defer func() { <-m.inflight }()
+				m.send(req, diff)
+			}()
+		}
+	}
+}
+
+// shadowRequest copies r to the shadow, detached from the context of r which is done after it's served
+func (m *Mirror) shadowRequest(r *http.Request, body []byte) (*http.Request, error) {
+	u := *r.URL
+	u.Scheme, u.Host = m.upstream.Scheme, m.upstream.Host
+	u.Path = strings.TrimSuffix(m.upstream.Path, "/") + r.URL.Path
+	if r.URL.RawPath != "" {
+		u.RawPath = strings.TrimSuffix(m.upstream.Path, "/") + r.URL.RawPath
+	}
+	tracer := trace.GetTraceFromRequest(r)
+	req, err := http.NewRequest(r.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(trace.WithTraceForContext2(context.Background(), tracer))
+	for k, vs := range r.Header {
+		req.Header[k] = append([]string(nil), vs...)
+	}
+	if !m.cfg.ForwardCredentials {
+		for _, k := range credentialHeaders {
+			req.Header.Del(k)
+		}
+	}
+	req.Header.Set("X-Shadow", "1")
+	return req, nil
+}
+
+func (m *Mirror) send(req *http.Request, diff Diff) {
+	atomic.AddInt64(&m.mirrored, 1)
+	start := time.Now()
+	resp, err := httputils.ClientDo(m.cfg.Client, req, true)
+	if err == nil {
+		io.Copy(ioutil.Discard, resp.BodyStream)
+		resp.BodyStream.Close()
+		diff.ShadowStatus = resp.Status
+	}
+	diff.ShadowLatency = time.Since(start)
+	diff.Err = err
+
+	if err != nil {
+		atomic.AddInt64(&m.errors, 1)
+	}
+	if diff.Mismatched() {
+		atomic.AddInt64(&m.mismatches, 1)
+		trace.GetTraceFromContext(req.Context()).Warnf("event=[shadow-mismatch] method=[%s] url=[%s] status=[%d] shadow_status=[%d] latency=[%s] shadow_latency=[%s] err=[%v]",
+			diff.Method, diff.URL, diff.Status, diff.ShadowStatus, diff.Latency, diff.ShadowLatency, diff.Err)
+	}
+	if m.cfg.Observer != nil {
+		m.cfg.Observer(req.Context(), diff)
+	}
+}
@@ -0,0 +1,105 @@
+package shadow
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	received := make(chan string, 10)
+	shadowSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- r.Method + " " + r.URL.RequestURI() + " " + string(body) + " " + r.Header.Get("X-Shadow") + r.Header.Get("Authorization") + r.Header.Get("Cookie")
+		if r.URL.Path == "/v2/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer shadowSrv.Close()
+
+	diffs := make(chan Diff, 10)
+	m, err := New(Config{
+		Upstream: shadowSrv.URL + "/v2",
+		Percent:  100,
+		MaxBody:  16,
+		Filter:   func(r *http.Request) bool { return r.URL.Path != "/skip" },
+		Observer: func(ctx context.Context, d Diff) { diffs <- d },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.Middleware().HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	})
+
+	for _, c := range []struct {
+		method, path, body string
+		mirrored           string
+		mismatched         bool
+	}{
+		{http.MethodPost, "/orders?dry=1", "hello", "POST /v2/orders?dry=1 hello 1", false},
+		{http.MethodGet, "/broken", "", "GET /v2/broken  1", true},
+		{http.MethodGet, "/skip", "", "", false},
+		{http.MethodPost, "/large", strings.Repeat("x", 17), "", false},
+	} {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(c.method, c.path, strings.NewReader(c.body)))
+		if w.Body.String() != c.body {
+			t.Fatalf("%s: expect the primary served with the body, got %q", c.path, w.Body.String())
+		}
+		if c.mirrored == "" {
+			continue
+		}
+		select {
+		case got := <-received:
+			if got != c.mirrored {
+				t.Fatalf("%s: unexpected mirrored %q", c.path, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expect mirrored", c.path)
+		}
+		d := <-diffs
+		if d.Mismatched() != c.mismatched || d.Status != http.StatusOK {
+			t.Fatalf("%s: unexpected diff %+v", c.path, d)
+		}
+	}
+
+	stats := m.Stats()
+	if stats.Mirrored != 2 || stats.Mismatches != 1 || stats.Dropped != 1 || stats.Errors != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if _, err := New(Config{Upstream: "shadow:8080"}); err == nil {
+		t.Fatal("expect invalid upstream")
+	}
+
+	// only the reads are mirrored by default, without the credentials
+	m, err = New(Config{Upstream: shadowSrv.URL, Percent: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h = m.Middleware().HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodGet} {
+		r := httptest.NewRequest(method, "/orders", nil)
+		r.Header.Set("Authorization", "Bearer token")
+		r.AddCookie(&http.Cookie{Name: "session", Value: "s"})
+		h(httptest.NewRecorder(), r)
+	}
+	select {
+	case got := <-received:
+		if got != "GET /orders  1" {
+			t.Fatalf("expect only the GET mirrored without credentials, got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect GET mirrored")
+	}
+	if stats := m.Stats(); stats.Mirrored != 1 {
+		t.Fatalf("expect only the GET mirrored, got %+v", stats)
+	}
+}
@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tools-go/go-utils/errors"
+	"github.com/tools-go/go-utils/utils/urlutil"
+)
+
+// the content type and the user metadata are kept in the sidecar files under this dir
+const localMetaDir = ".storage-meta"
+
+type localMeta struct {
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	ETag        string            `json:"etag,omitempty"`
+}
+
+type localBlob struct {
+	root string
+}
+
+// NewLocalBlob creates a Blob backed by the directory root, which is useful for tests and single node deployments.
+// Keys are mapped to the files under root and can not escape it, the server side encryption options are ignored,
+// and PresignURL returns ErrNotSupported.
+func NewLocalBlob(root string) (Blob, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &localBlob{root: filepath.Clean(root)}, nil
+}
+
+func (b *localBlob) path(key string) (string, error) {
+	if key == "" || strings.HasSuffix(key, "/") {
+		return "", errors.NewBadRequestError(fmt.Sprintf("invalid object key %q", key))
+	}
+	p, err := urlutil.SafeJoin(filepath.ToSlash(b.root), key)
+	if err != nil {
+		return "", errors.NewBadRequestError(err.Error())
+	}
+	// compare the cleaned paths, so the keys like a/../.storage-meta/x can't reach the meta dir
+	rel, err := filepath.Rel(b.root, filepath.FromSlash(p))
+	if err != nil {
+		return "", errors.NewBadRequestError(err.Error())
+	}
+	if rel = filepath.ToSlash(rel); rel == "." || rel == localMetaDir || strings.HasPrefix(rel, localMetaDir+"/") {
+		return "", errors.NewBadRequestError(fmt.Sprintf("invalid object key %q", key))
+	}
+	return filepath.FromSlash(p), nil
+}
+
+func (b *localBlob) metaPath(key string) string {
+	return filepath.Join(b.root, localMetaDir, filepath.FromSlash(key)+".json")
+}
+
+// writeFile writes to a temporary file and renames it, so the readers never see a partial object
+func writeFile(name string, r io.Reader) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	h := md5.New()
+	_, err = io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (b *localBlob) Put(ctx context.Context, key string, r io.Reader, size int64, ops ...PutOption) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if size >= 0 {
+		r = io.LimitReader(r, size)
+	}
+	etag, err := writeFile(p, r)
+	if err != nil {
+		return err
+	}
+	return b.putMeta(key, etag, newPutOptions(ops))
+}
+
+func (b *localBlob) putMeta(key, etag string, opts *putOptions) error {
+	data, err := json.Marshal(localMeta{ContentType: opts.contentType, Metadata: opts.metadata, ETag: etag})
+	if err != nil {
+		return err
+	}
+	_, err = writeFile(b.metaPath(key), strings.NewReader(string(data)))
+	return err
+}
+
+func (b *localBlob) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, nil, errors.NewNotFoundError(key)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := b.stat(key, f.Stat)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+func (b *localBlob) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return b.stat(key, func() (os.FileInfo, error) { return os.Stat(p) })
+}
+
+func (b *localBlob) stat(key string, statFn func() (os.FileInfo, error)) (*ObjectInfo, error) {
+	fi, err := statFn()
+	if os.IsNotExist(err) || err == nil && fi.IsDir() {
+		return nil, errors.NewNotFoundError(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info := &ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		LastModified: fi.ModTime(),
+		ContentType:  "application/octet-stream",
+	}
+	if data, err := ioutil.ReadFile(b.metaPath(key)); err == nil {
+		var meta localMeta
+		if json.Unmarshal(data, &meta) == nil {
+			info.ETag = meta.ETag
+			info.Metadata = meta.Metadata
+			if meta.ContentType != "" {
+				info.ContentType = meta.ContentType
+			}
+		}
+	}
+	return info, nil
+}
+
+func (b *localBlob) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.Walk(b.root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(b.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if fi.IsDir() {
+			if key == localMetaDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(key, prefix) || strings.HasPrefix(fi.Name(), ".") && strings.Contains(fi.Name(), ".tmp") {
+			return nil
+		}
+		info, err := b.stat(key, func() (os.FileInfo, error) { return fi, nil })
+		if err != nil {
+			return err
+		}
+		objects = append(objects, *info)
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, err
+}
+
+func (b *localBlob) Delete(ctx context.Context, key string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	// deleting a nonexistent object is not an error, the same as S3
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(b.metaPath(key))
+	return nil
+}
+
+func (b *localBlob) PresignURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (b *localBlob) NewMultipartUpload(ctx context.Context, key string, ops ...PutOption) (MultipartUpload, error) {
+	if _, err := b.path(key); err != nil {
+		return nil, err
+	}
+	// the parts are kept in the meta dir, so that List never sees them
+	if err := os.MkdirAll(filepath.Join(b.root, localMetaDir), 0755); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(filepath.Join(b.root, localMetaDir), "upload-")
+	if err != nil {
+		return nil, err
+	}
+	return &localUpload{blob: b, key: key, dir: dir, opts: newPutOptions(ops), parts: map[int]string{}}, nil
+}
+
+type localUpload struct {
+	blob  *localBlob
+	key   string
+	dir   string
+	opts  *putOptions
+	parts map[int]string
+}
+
+func (u *localUpload) UploadPart(ctx context.Context, number int, r io.Reader, size int64) error {
+	if number < 1 {
+		return errors.NewBadRequestError(fmt.Sprintf("invalid part number %d", number))
+	}
+	if size >= 0 {
+		r = io.LimitReader(r, size)
+	}
+	name := filepath.Join(u.dir, fmt.Sprintf("%05d", number))
+	if _, err := writeFile(name, r); err != nil {
+		return err
+	}
+	u.parts[number] = name
+	return nil
+}
+
+func (u *localUpload) Complete(ctx context.Context) error {
+	defer os.RemoveAll(u.dir)
+	numbers := make([]int, 0, len(u.parts))
+	for n := range u.parts {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	readers := make([]io.Reader, 0, len(numbers))
+	for _, n := range numbers {
+		f, err := os.Open(u.parts[n])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	return u.blob.Put(ctx, u.key, io.MultiReader(readers...), -1, func(opts *putOptions) { *opts = *u.opts })
+}
+
+func (u *localUpload) Abort(ctx context.Context) error {
+	return os.RemoveAll(u.dir)
+}
@@ -0,0 +1,361 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tools-go/go-utils/errors"
+)
+
+// now is replaced by the tests
+var now = time.Now
+
+// S3Config is the config of the S3 compatible backend
+type S3Config struct {
+	// Endpoint is the service address like "https://s3.us-east-1.amazonaws.com"
+	// or "https://oss-cn-hangzhou.aliyuncs.com"
+	Endpoint     string
+	Region       string
+	Bucket       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// PathStyle puts the bucket into the path instead of the host, required by MinIO and Ceph in most cases
+	PathStyle bool
+	// Client is the http client to send the requests, http.DefaultClient is used if it's nil
+	Client *http.Client
+}
+
+type s3Blob struct {
+	endpoint  *url.URL
+	bucket    string
+	pathStyle bool
+	client    *http.Client
+	signer    *signer
+}
+
+// NewS3Blob creates a Blob of the S3 compatible storages, the requests are signed with the signature version 4
+func NewS3Blob(cfg S3Config) (Blob, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.NewBadRequestError("endpoint and bucket are required")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("invalid endpoint %q", cfg.Endpoint))
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	return &s3Blob{
+		endpoint:  endpoint,
+		bucket:    cfg.Bucket,
+		pathStyle: cfg.PathStyle,
+		client:    cfg.Client,
+		signer: &signer{
+			accessKey:    cfg.AccessKey,
+			secretKey:    cfg.SecretKey,
+			sessionToken: cfg.SessionToken,
+			region:       cfg.Region,
+			service:      "s3",
+		},
+	}, nil
+}
+
+func (b *s3Blob) objectURL(key string, query url.Values) *url.URL {
+	u := *b.endpoint
+	if b.pathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + b.bucket + "/" + key
+	} else {
+		u.Host = b.bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+	// send the path exactly as it's signed
+	u.RawPath = canonicalURI(&u)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// do signs and sends the request, and converts the error responses into errors,
+// body is not signed if it's not a []byte
+func (b *s3Blob) do(ctx context.Context, method, key string, query url.Values, header http.Header, body interface{}, size int64) (*http.Response, error) {
+	var (
+		reader      io.Reader
+		payloadHash = emptyPayloadHash
+	)
+	switch x := body.(type) {
+	case nil:
+	case []byte:
+		reader, size, payloadHash = bytes.NewReader(x), int64(len(x)), hashHex(x)
+	case io.Reader:
+		reader, payloadHash = x, unsignedPayload
+	}
+	req, err := http.NewRequest(method, b.objectURL(key, query).String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if reader != nil {
+		req.ContentLength = size
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	b.signer.sign(req, payloadHash, now())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, responseError(resp, key)
+	}
+	return resp, nil
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func responseError(resp *http.Response, key string) error {
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	e := &s3Error{}
+	if xml.Unmarshal(data, e) != nil || e.Code == "" {
+		e.Code, e.Message = resp.Status, strings.TrimSpace(string(data))
+	}
+	msg := fmt.Sprintf("%s: %s", e.Code, e.Message)
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return errors.NewNotFoundError(key)
+	case http.StatusForbidden:
+		return errors.NewForbiddenError(msg)
+	case http.StatusBadRequest:
+		return errors.NewBadRequestError(msg)
+	case http.StatusConflict:
+		return errors.NewConflictError(key)
+	}
+	return errors.NewServerError(msg)
+}
+
+func putHeader(opts *putOptions) http.Header {
+	header := http.Header{}
+	header.Set("Content-Type", opts.contentType)
+	for k, v := range opts.metadata {
+		header.Set("X-Amz-Meta-"+k, v)
+	}
+	if opts.sse != SSENone {
+		header.Set("X-Amz-Server-Side-Encryption", string(opts.sse))
+		if opts.sse == SSEKMS && opts.kmsKeyID != "" {
+			header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", opts.kmsKeyID)
+		}
+	}
+	return header
+}
+
+// Put uploads r in a single request, S3 requires the content length, so r is read
+// into memory if size is negative, use Upload for the large streams of unknown size
+func (b *s3Blob) Put(ctx context.Context, key string, r io.Reader, size int64, ops ...PutOption) error {
+	var body interface{} = r
+	if size < 0 {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		body = data
+	}
+	resp, err := b.do(ctx, http.MethodPut, key, nil, putHeader(newPutOptions(ops)), body, size)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func objectInfo(key string, header http.Header) *ObjectInfo {
+	info := &ObjectInfo{
+		Key:         key,
+		ETag:        strings.Trim(header.Get("ETag"), `"`),
+		ContentType: header.Get("Content-Type"),
+	}
+	info.Size, _ = strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	info.LastModified, _ = http.ParseTime(header.Get("Last-Modified"))
+	for k := range header {
+		if strings.HasPrefix(k, "X-Amz-Meta-") {
+			if info.Metadata == nil {
+				info.Metadata = map[string]string{}
+			}
+			info.Metadata[strings.ToLower(strings.TrimPrefix(k, "X-Amz-Meta-"))] = header.Get(k)
+		}
+	}
+	return info
+}
+
+func (b *s3Blob) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	resp, err := b.do(ctx, http.MethodGet, key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, objectInfo(key, resp.Header), nil
+}
+
+func (b *s3Blob) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	resp, err := b.do(ctx, http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return objectInfo(key, resp.Header), nil
+}
+
+type listResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+// List uses the ListObjectsV2 API, and follows the continuation tokens until all the objects are returned
+func (b *s3Blob) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var (
+		objects []ObjectInfo
+		token   string
+	)
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		result := &listResult{}
+		if err := b.doXML(ctx, http.MethodGet, "", query, nil, result); err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          c.Key,
+				Size:         c.Size,
+				ETag:         strings.Trim(c.ETag, `"`),
+				LastModified: c.LastModified,
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// doXML sends the request and decodes the xml response into result
+func (b *s3Blob) doXML(ctx context.Context, method, key string, query url.Values, body []byte, result interface{}) error {
+	var reqBody interface{}
+	if body != nil {
+		reqBody = body
+	}
+	resp, err := b.do(ctx, method, key, query, nil, reqBody, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// CompleteMultipartUpload may fail with a 200 response
+	if e := (&s3Error{}); xml.Unmarshal(data, e) == nil && e.Code != "" {
+		return errors.NewServerError(fmt.Sprintf("%s: %s", e.Code, e.Message))
+	}
+	if result == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, result)
+}
+
+func (b *s3Blob) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, key, nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (b *s3Blob) PresignURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > 7*24*time.Hour {
+		return "", errors.NewBadRequestError("expires should be in (0, 7 days]")
+	}
+	return b.signer.presign(http.MethodGet, b.objectURL(key, nil), expires, now()).String(), nil
+}
+
+func (b *s3Blob) NewMultipartUpload(ctx context.Context, key string, ops ...PutOption) (MultipartUpload, error) {
+	resp, err := b.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, putHeader(newPutOptions(ops)), nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	result := &struct {
+		UploadID string `xml:"UploadId"`
+	}{}
+	if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, err
+	}
+	return &s3Upload{blob: b, key: key, uploadID: result.UploadID}, nil
+}
+
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3Upload struct {
+	blob     *s3Blob
+	key      string
+	uploadID string
+	parts    []s3Part
+}
+
+func (u *s3Upload) UploadPart(ctx context.Context, number int, r io.Reader, size int64) error {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {u.uploadID}}
+	resp, err := u.blob.do(ctx, http.MethodPut, u.key, query, nil, r, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	u.parts = append(u.parts, s3Part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	return nil
+}
+
+func (u *s3Upload) Complete(ctx context.Context) error {
+	sort.Slice(u.parts, func(i, j int) bool { return u.parts[i].PartNumber < u.parts[j].PartNumber })
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: u.parts})
+	if err != nil {
+		return err
+	}
+	return u.blob.doXML(ctx, http.MethodPost, u.key, url.Values{"uploadId": {u.uploadID}}, body, nil)
+}
+
+func (u *s3Upload) Abort(ctx context.Context) error {
+	resp, err := u.blob.do(ctx, http.MethodDelete, u.key, url.Values{"uploadId": {u.uploadID}}, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,147 @@
+// Package storage provides a Blob abstraction over object storages,
+// with an S3 compatible backend(AWS S3, Aliyun OSS, MinIO, Ceph...) and a local filesystem backend.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"github.com/tools-go/go-utils/errors"
+)
+
+// DefaultPartSize is the default part size of the multipart uploads
+const DefaultPartSize = 8 << 20
+
+// ErrNotSupported is returned if the operation is not supported by the backend
+var ErrNotSupported = errors.New("operation not supported by the storage backend")
+
+// ObjectInfo is the metadata of an object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	ContentType  string
+	LastModified time.Time
+	Metadata     map[string]string
+}
+
+// Blob is the interface of the object storages, keys are slash separated paths like "logs/2020/01/app.log".
+// Get of a nonexistent key returns a NotFound error of the errors package.
+type Blob interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, ops ...PutOption) error
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// List returns the objects with the prefix, in lexicographical order of the keys
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	// PresignURL returns an url which can be used to GET the object without credentials before expires
+	PresignURL(ctx context.Context, key string, expires time.Duration) (string, error)
+	// NewMultipartUpload starts a multipart upload for large objects
+	NewMultipartUpload(ctx context.Context, key string, ops ...PutOption) (MultipartUpload, error)
+}
+
+// MultipartUpload uploads an object in parts, parts are numbered from 1,
+// and all the parts except the last one should be at least 5MB for S3.
+type MultipartUpload interface {
+	UploadPart(ctx context.Context, number int, r io.Reader, size int64) error
+	Complete(ctx context.Context) error
+	Abort(ctx context.Context) error
+}
+
+// SSE is the server side encryption algorithm
+type SSE string
+
+// server side encryption algorithms
+const (
+	SSENone SSE = ""
+	SSES3   SSE = "AES256"
+	SSEKMS  SSE = "aws:kms"
+)
+
+type putOptions struct {
+	contentType string
+	metadata    map[string]string
+	sse         SSE
+	kmsKeyID    string
+}
+
+// PutOption for Put and NewMultipartUpload
+type PutOption func(opts *putOptions)
+
+// WithContentType set the content type of the object
+func WithContentType(contentType string) PutOption {
+	return func(opts *putOptions) {
+		opts.contentType = contentType
+	}
+}
+
+// WithMetadata set the user defined metadata of the object
+func WithMetadata(metadata map[string]string) PutOption {
+	return func(opts *putOptions) {
+		opts.metadata = metadata
+	}
+}
+
+// WithSSE enables the server side encryption, kmsKeyID is only used by SSEKMS,
+// and the default kms key of the bucket will be used if it's empty
+func WithSSE(sse SSE, kmsKeyID string) PutOption {
+	return func(opts *putOptions) {
+		opts.sse = sse
+		opts.kmsKeyID = kmsKeyID
+	}
+}
+
+func newPutOptions(ops []PutOption) *putOptions {
+	opts := &putOptions{}
+	for _, op := range ops {
+		op(opts)
+	}
+	if opts.contentType == "" {
+		opts.contentType = "application/octet-stream"
+	}
+	return opts
+}
+
+// Upload puts r with the multipart upload if it's larger than partSize,
+// or a single Put otherwise, a failed multipart upload will be aborted
+func Upload(ctx context.Context, blob Blob, key string, r io.Reader, partSize int64, ops ...PutOption) error {
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+	buf := make([]byte, partSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return blob.Put(ctx, key, bytes.NewReader(buf[:n]), int64(n), ops...)
+	}
+	if err != nil {
+		return err
+	}
+
+	upload, err := blob.NewMultipartUpload(ctx, key, ops...)
+	if err != nil {
+		return err
+	}
+	for number := 1; n > 0; number++ {
+		if err = upload.UploadPart(ctx, number, bytes.NewReader(buf[:n]), int64(n)); err != nil {
+			break
+		}
+		// the part buffer is reused, UploadPart must not keep the reader
+		n, err = io.ReadFull(r, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = upload.Complete(ctx)
+	}
+	if err != nil {
+		upload.Abort(ctx)
+		return err
+	}
+	return nil
+}
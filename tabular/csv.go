@@ -0,0 +1,176 @@
+package tabular
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/leopoldxx/go-utils/errors"
+)
+
+const bom = "\xef\xbb\xbf"
+
+// CSVEncoder writes structs as CSV rows, the header is written before the first row
+type CSVEncoder struct {
+	w      io.Writer
+	cw     *csv.Writer
+	opts   *options
+	typ    reflect.Type
+	fields []field
+	record []string
+	rows   int
+}
+
+// NewCSVEncoder creates a CSVEncoder writing to w
+func NewCSVEncoder(w io.Writer, ops ...Option) *CSVEncoder {
+	opts := newOptions(ops)
+	cw := csv.NewWriter(w)
+	cw.Comma = opts.comma
+	return &CSVEncoder{w: w, cw: cw, opts: opts}
+}
+
+// Encode writes v as a row, v should be a struct or a pointer to struct,
+// and all the rows should be of the same type
+func (e *CSVEncoder) Encode(v interface{}) error {
+	rv, err := structValue(v)
+	if err != nil {
+		return err
+	}
+	if e.typ == nil {
+		if err := e.writeHeader(rv.Type()); err != nil {
+			return err
+		}
+	} else if rv.Type() != e.typ {
+		return errors.NewBadRequestError(fmt.Sprintf("tabular: expect %s, got %s", e.typ, rv.Type()))
+	}
+	if e.opts.maxRows > 0 && e.rows >= e.opts.maxRows {
+		return ErrTooLarge
+	}
+	for i, f := range e.fields {
+		s, err := formatValue(rv.FieldByIndex(f.index), e.opts.timeLayout)
+		if err != nil {
+			return fmt.Errorf("tabular: column %s: %s", f.name, err)
+		}
+		e.record[i] = s
+	}
+	e.rows++
+	return e.cw.Write(e.record)
+}
+
+func (e *CSVEncoder) writeHeader(t reflect.Type) error {
+	e.typ = t
+	e.fields = structFields(t)
+	e.record = make([]string, len(e.fields))
+	if e.opts.bom {
+		if _, err := io.WriteString(e.w, bom); err != nil {
+			return err
+		}
+	}
+	for i, f := range e.fields {
+		e.record[i] = f.name
+	}
+	return e.cw.Write(e.record)
+}
+
+// Flush writes the buffered rows to the underlying writer
+func (e *CSVEncoder) Flush() error {
+	e.cw.Flush()
+	return e.cw.Error()
+}
+
+// CSVDecoder reads CSV rows into structs, the columns are matched by the header,
+// unknown columns are ignored and missing columns are left as zero values
+type CSVDecoder struct {
+	cr     *csv.Reader
+	opts   *options
+	header []string
+	typ    reflect.Type
+	// columns[i] is the field of the i-th column, nil if unknown
+	columns []*field
+	rows    int
+}
+
+// NewCSVDecoder creates a CSVDecoder reading from r
+func NewCSVDecoder(r io.Reader, ops ...Option) *CSVDecoder {
+	opts := newOptions(ops)
+	cr := csv.NewReader(skipBOM(r))
+	cr.Comma = opts.comma
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	return &CSVDecoder{cr: cr, opts: opts}
+}
+
+// Header returns the header of the CSV, it is read by the first Decode
+func (d *CSVDecoder) Header() []string {
+	return d.header
+}
+
+// Decode reads the next row into v, v should be a pointer to struct,
+// io.EOF is returned at the end of the input
+func (d *CSVDecoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.NewBadRequestError(fmt.Sprintf("tabular: expect a pointer to struct, got %T", v))
+	}
+	rv = rv.Elem()
+
+	if d.header == nil {
+		record, err := d.cr.Read()
+		if err != nil {
+			return err
+		}
+		d.header = append([]string(nil), record...)
+	}
+	if d.typ != rv.Type() {
+		d.bind(rv.Type())
+	}
+
+	record, err := d.cr.Read()
+	if err != nil {
+		if e, ok := err.(*csv.ParseError); ok {
+			return errors.NewBadRequestError(fmt.Sprintf("tabular: %s", e))
+		}
+		return err
+	}
+	if d.opts.maxRows > 0 && d.rows >= d.opts.maxRows {
+		return errors.NewBadRequestError(fmt.Sprintf("tabular: more than %d rows", d.opts.maxRows))
+	}
+	d.rows++
+	line, _ := d.cr.FieldPos(0)
+
+	rv.Set(reflect.Zero(rv.Type()))
+	for i, s := range record {
+		if i >= len(d.columns) || d.columns[i] == nil {
+			continue
+		}
+		f := d.columns[i]
+		if err := parseValue(s, rv.FieldByIndex(f.index), d.opts.timeLayout); err != nil {
+			return errors.NewBadRequestError(fmt.Sprintf("tabular: line %d column %s: %s", line, f.name, err))
+		}
+	}
+	return nil
+}
+
+func (d *CSVDecoder) bind(t reflect.Type) {
+	d.typ = t
+	fields := structFields(t)
+	d.columns = make([]*field, len(d.header))
+	for i, name := range d.header {
+		for j := range fields {
+			if fields[j].name == name {
+				d.columns[i] = &fields[j]
+				break
+			}
+		}
+	}
+}
+
+func skipBOM(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(len(bom)); err == nil && string(b) == bom {
+		br.Discard(len(bom))
+	}
+	return br
+}
@@ -0,0 +1,139 @@
+package tabular
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/leopoldxx/go-utils/errors"
+	"github.com/leopoldxx/go-utils/trace"
+)
+
+// content types of the downloads
+const (
+	CSVContentType  = "text/csv; charset=utf-8"
+	XLSXContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// the size of the buffer in front of the response, errors before the buffer
+// is flushed for the first time can still be replied with a status code
+const downloadBufferSize = 32 << 10
+
+// ServeCSV streams a CSV download of filename, the rows are written by fn.
+// The download is aborted if fn fails after the response started,
+// so that the client never takes a truncated file as a complete one.
+func ServeCSV(w http.ResponseWriter, r *http.Request, filename string, fn func(enc *CSVEncoder) error, ops ...Option) {
+	serve(w, r, filename, CSVContentType, ops, func(dw *downloadWriter) error {
+		enc := NewCSVEncoder(dw, ops...)
+		if err := fn(enc); err != nil {
+			return err
+		}
+		return enc.Flush()
+	})
+}
+
+// ServeXLSX streams an xlsx download of filename, the sheets are written by fn,
+// the errors are handled the same as ServeCSV
+func ServeXLSX(w http.ResponseWriter, r *http.Request, filename string, fn func(x *XLSXWriter) error, ops ...Option) {
+	serve(w, r, filename, XLSXContentType, ops, func(dw *downloadWriter) error {
+		x := NewXLSXWriter(dw, ops...)
+		if err := fn(x); err != nil {
+			return err
+		}
+		return x.Close()
+	})
+}
+
+func serve(w http.ResponseWriter, r *http.Request, filename, contentType string, ops []Option, fn func(dw *downloadWriter) error) {
+	opts := newOptions(ops)
+	tracer := trace.GetTraceFromRequest(r)
+	dw := &downloadWriter{w: w, max: opts.maxBytes}
+	dw.buf = bufio.NewWriterSize(&responseWriter{dw}, downloadBufferSize)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", contentDisposition(filename))
+	err := fn(dw)
+	if err == nil {
+		err = dw.buf.Flush()
+	}
+	if err == nil {
+		tracer.Infof("download %s finished, %d bytes", filename, dw.n)
+		return
+	}
+
+	tracer.Errorf("download %s failed after %d bytes: %s", filename, dw.n, err)
+	if !dw.started {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+	abort(w)
+}
+
+func statusOf(err error) int {
+	switch {
+	case err == ErrTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case errors.IsBadRequestError(err):
+		return http.StatusBadRequest
+	case errors.IsNotFoundError(err):
+		return http.StatusNotFound
+	case errors.IsForbiddenError(err):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// abort closes the connection without finishing the response
+func abort(w http.ResponseWriter) {
+	if hj, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			conn.Close()
+			return
+		}
+	}
+	// a response can not be aborted by panic when the recover middleware is used,
+	// as it recovers the panic and ends the response normally, so it is the last resort
+	panic(http.ErrAbortHandler)
+}
+
+// contentDisposition supports the non-ASCII file names by RFC 6266
+func contentDisposition(filename string) string {
+	ascii := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, ascii, url.PathEscape(filename))
+}
+
+// downloadWriter counts and limits the bytes of a download
+type downloadWriter struct {
+	w       http.ResponseWriter
+	buf     *bufio.Writer
+	max     int64
+	n       int64
+	started bool
+}
+
+func (dw *downloadWriter) Write(p []byte) (int, error) {
+	if dw.max > 0 && dw.n+int64(len(p)) > dw.max {
+		return 0, ErrTooLarge
+	}
+	n, err := dw.buf.Write(p)
+	dw.n += int64(n)
+	return n, err
+}
+
+// responseWriter is the underlying writer of the buffer
+type responseWriter struct {
+	dw *downloadWriter
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	rw.dw.started = true
+	return rw.dw.w.Write(p)
+}
@@ -0,0 +1,235 @@
+// Package tabular encodes and decodes tabular data, like CSV and Excel(xlsx) files,
+// and streams them as HTTP downloads.
+//
+// Struct fields are mapped to columns by the "csv" tag, the field name is used if the tag is absent:
+//
+//	type User struct {
+//		ID      int64     `csv:"id"`
+//		Name    string    `csv:"name"`
+//		Created time.Time `csv:"created"`
+//		Secret  string    `csv:"-"`
+//	}
+package tabular
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leopoldxx/go-utils/errors"
+)
+
+// DefaultTimeLayout is the layout of the time values
+const DefaultTimeLayout = time.RFC3339
+
+var (
+	// ErrTooLarge is returned when the output exceeds the limit of WithMaxBytes or WithMaxRows
+	ErrTooLarge = errors.New("tabular: output exceeds the limit")
+
+	timeType            = reflect.TypeOf(time.Time{})
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+type options struct {
+	comma      rune
+	timeLayout string
+	bom        bool
+	maxRows    int
+	maxBytes   int64
+}
+
+// Option of the encoders, decoders and download handlers
+type Option func(opts *options)
+
+// WithComma set the field delimiter of CSV, default is ','
+func WithComma(comma rune) Option {
+	return func(opts *options) {
+		opts.comma = comma
+	}
+}
+
+// WithTimeLayout set the layout of the time values in CSV, default is RFC3339
+func WithTimeLayout(layout string) Option {
+	return func(opts *options) {
+		opts.timeLayout = layout
+	}
+}
+
+// WithBOM writes the UTF-8 BOM before CSV, so that Excel can detect the encoding
+func WithBOM() Option {
+	return func(opts *options) {
+		opts.bom = true
+	}
+}
+
+// WithMaxRows limits the data rows to encode or decode, 0 means no limit
+func WithMaxRows(n int) Option {
+	return func(opts *options) {
+		opts.maxRows = n
+	}
+}
+
+// WithMaxBytes limits the size of a download, 0 means no limit
+func WithMaxBytes(n int64) Option {
+	return func(opts *options) {
+		opts.maxBytes = n
+	}
+}
+
+func newOptions(ops []Option) *options {
+	opts := &options{comma: ',', timeLayout: DefaultTimeLayout}
+	for _, op := range ops {
+		op(opts)
+	}
+	return opts
+}
+
+// field is a column mapped from a struct field
+type field struct {
+	name  string
+	index []int
+	typ   reflect.Type
+}
+
+var fieldsCache sync.Map // map[reflect.Type][]field
+
+// structFields returns the columns of a struct type, the fields of the embedded structs are flattened
+func structFields(t reflect.Type) []field {
+	if fields, ok := fieldsCache.Load(t); ok {
+		return fields.([]field)
+	}
+	fields := appendFields(nil, t, nil)
+	fieldsCache.Store(t, fields)
+	return fields
+}
+
+func appendFields(fields []field, t reflect.Type, index []int) []field {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("csv")
+		if tag == "-" {
+			continue
+		}
+		idx := append(append(make([]int, 0, len(index)+1), index...), i)
+		if sf.Anonymous && tag == "" && sf.Type.Kind() == reflect.Struct && sf.Type != timeType {
+			fields = appendFields(fields, sf.Type, idx)
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{name: name, index: idx, typ: sf.Type})
+	}
+	return fields
+}
+
+// structValue returns the struct value that v points to or is
+func structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, errors.NewBadRequestError(fmt.Sprintf("tabular: expect a struct, got %T", v))
+	}
+	return rv, nil
+}
+
+// formatValue converts v to text, nil pointers are converted to ""
+func formatValue(v reflect.Value, layout string) (string, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		if t.IsZero() {
+			return "", nil
+		}
+		return t.Format(layout), nil
+	}
+	if v.Type().Implements(textMarshalerType) {
+		b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(b), err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	}
+	return "", fmt.Errorf("unsupported type %s", v.Type())
+}
+
+// parseValue sets the text s to v, "" leaves v as the zero value
+func parseValue(s string, v reflect.Value, layout string) error {
+	if s == "" {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return parseValue(s, v.Elem(), layout)
+	}
+	if v.Type() == timeType {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package tabular
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leopoldxx/go-utils/errors"
+)
+
+type Base struct {
+	ID int64 `csv:"id"`
+}
+
+type user struct {
+	Base
+	Name    string    `csv:"name"`
+	Score   *float64  `csv:"score"`
+	Active  bool      `csv:"active"`
+	Created time.Time `csv:"created"`
+	Secret  string    `csv:"-"`
+}
+
+func TestCSV(t *testing.T) {
+	score := 9.5
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	users := []user{
+		{Base: Base{ID: 1}, Name: "foo, bar", Score: &score, Active: true, Created: created, Secret: "x"},
+		{Base: Base{ID: 2}, Name: "\"quoted\""},
+	}
+
+	buf := &bytes.Buffer{}
+	enc := NewCSVEncoder(buf, WithBOM())
+	for i := range users {
+		if err := enc.Encode(&users[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "\xef\xbb\xbfid,name,score,active,created\n" +
+		"1,\"foo, bar\",9.5,true,2020-01-02T03:04:05Z\n" +
+		"2,\"\"\"quoted\"\"\",,false,\n"
+	if buf.String() != expected {
+		t.Fatalf("unexpected csv:\n%q", buf.String())
+	}
+
+	dec := NewCSVDecoder(strings.NewReader(buf.String()))
+	var decoded []user
+	for {
+		var u user
+		err := dec.Decode(&u)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded = append(decoded, u)
+	}
+	if len(decoded) != 2 || decoded[0].ID != 1 || decoded[0].Name != "foo, bar" || *decoded[0].Score != 9.5 ||
+		!decoded[0].Active || !decoded[0].Created.Equal(created) || decoded[0].Secret != "" {
+		t.Fatalf("unexpected first row: %+v", decoded[0])
+	}
+	if decoded[1].Name != `"quoted"` || decoded[1].Score != nil || !decoded[1].Created.IsZero() {
+		t.Fatalf("unexpected second row: %+v", decoded[1])
+	}
+}
+
+func TestCSVDecodeErrors(t *testing.T) {
+	dec := NewCSVDecoder(strings.NewReader("name;extra;id\nfoo;x;1\nbar;y;abc\n"), WithComma(';'))
+	var u user
+	if err := dec.Decode(&u); err != nil || u.Name != "foo" || u.ID != 1 {
+		t.Fatalf("unexpected row: %+v, %v", u, err)
+	}
+	err := dec.Decode(&u)
+	if !errors.IsBadRequestError(err) || !strings.Contains(err.Error(), "line 3 column id") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dec = NewCSVDecoder(strings.NewReader("id\n1\n2\n"), WithMaxRows(1))
+	if err := dec.Decode(&u); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&u); !errors.IsBadRequestError(err) {
+		t.Fatalf("expect too many rows, got %v", err)
+	}
+}
+
+func readZip(t *testing.T, data []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+	return files
+}
+
+func TestXLSX(t *testing.T) {
+	buf := &bytes.Buffer{}
+	x := NewXLSXWriter(buf)
+	sheet, err := x.AddSheet("report", []Column{{Name: "name", Width: 20}, {Name: "amount", Type: Number}, {Name: "time"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sheet.WriteRow("a<b>&c", "12.5", time.Date(1900, 3, 1, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sheet.WriteRow(nil, 3, true); err != nil {
+		t.Fatal(err)
+	}
+	users, err := x.AddStructSheet("users", user{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.WriteStruct(user{Base: Base{ID: 7}, Name: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sheet.WriteRow("finished"); err == nil {
+		t.Fatal("expect the finished sheet can not be written")
+	}
+	if _, err := x.AddSheet("Users", nil); !errors.IsConflictError(err) {
+		t.Fatalf("expect conflict, got %v", err)
+	}
+	if _, err := x.AddSheet("a/b", nil); !errors.IsBadRequestError(err) {
+		t.Fatalf("expect invalid name, got %v", err)
+	}
+	if err := x.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readZip(t, buf.Bytes())
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("missing %s", name)
+		}
+	}
+	if !strings.Contains(files["xl/workbook.xml"], `<sheet name="users" sheetId="2" r:id="rId2"/>`) {
+		t.Fatalf("unexpected workbook: %s", files["xl/workbook.xml"])
+	}
+	sheet1 := files["xl/worksheets/sheet1.xml"]
+	for _, cell := range []string{
+		`<col min="1" max="1" width="20" customWidth="1"/>`,
+		`<c r="A1" s="2" t="inlineStr"><is><t xml:space="preserve">name</t></is></c>`,
+		`<t xml:space="preserve">a&lt;b&gt;&amp;c</t>`,
+		`<c r="B2" s="0"><v>12.5</v></c>`,
+		`<c r="C2" s="1"><v>61.5</v></c>`,
+		`<row r="3"><c r="B3" s="0"><v>3</v></c><c r="C3" s="0" t="b"><v>1</v></c></row>`,
+	} {
+		if !strings.Contains(sheet1, cell) {
+			t.Fatalf("expect %s in sheet1:\n%s", cell, sheet1)
+		}
+	}
+	if !strings.Contains(files["xl/worksheets/sheet2.xml"], `<c r="A2" s="0"><v>7</v></c>`) {
+		t.Fatalf("unexpected sheet2: %s", files["xl/worksheets/sheet2.xml"])
+	}
+}
+
+func TestCellRef(t *testing.T) {
+	for col, ref := range map[int]string{0: "A1", 25: "Z1", 26: "AA1", 701: "ZZ1", 702: "AAA1"} {
+		if got := cellRef(col, 1); got != ref {
+			t.Fatalf("expect %s, got %s", ref, got)
+		}
+	}
+}
+
+func TestServeCSV(t *testing.T) {
+	handler := func(max int64, rows int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ServeCSV(w, r, "用户.csv", func(enc *CSVEncoder) error {
+				for i := 0; i < rows; i++ {
+					if err := enc.Encode(user{Base: Base{ID: int64(i)}, Name: strings.Repeat("x", 100)}); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, WithMaxBytes(max))
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler(1<<20, 3)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), "\n") != 4 {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Disposition") != `attachment; filename="__.csv"; filename*=UTF-8''%E7%94%A8%E6%88%B7.csv` {
+		t.Fatalf("unexpected disposition: %s", w.Header().Get("Content-Disposition"))
+	}
+
+	// exceeds the limit before the response starts
+	w = httptest.NewRecorder()
+	handler(1000, 100)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expect 413, got %d", w.Code)
+	}
+
+	// exceeds the limit after the response started, the connection is closed
+	server := httptest.NewServer(handler(downloadBufferSize*2, 1000))
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := ioutil.ReadAll(resp.Body); err == nil {
+		t.Fatal("expect the truncated download fails")
+	}
+}
@@ -0,0 +1,362 @@
+package tabular
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leopoldxx/go-utils/errors"
+)
+
+// ColumnType is the cell type of an xlsx column
+type ColumnType int
+
+// column types, Auto detects the cell type by the value
+const (
+	Auto ColumnType = iota
+	String
+	Number
+	Bool
+	Time
+)
+
+// max rows of an Excel sheet, including the header
+const maxSheetRows = 1048576
+
+// Column of an xlsx sheet
+type Column struct {
+	Name string
+	Type ColumnType
+	// Width is the column width in characters, 0 means the default width
+	Width float64
+}
+
+// the cell styles defined in stylesXML
+const (
+	styleDefault = iota
+	styleTime
+	styleHeader
+)
+
+// XLSXWriter streams an Excel workbook, the sheets are written one by one,
+// so only the current row is kept in memory
+type XLSXWriter struct {
+	zw     *zip.Writer
+	opts   *options
+	sheets []string
+	cur    *Sheet
+	closed bool
+}
+
+// Sheet of an XLSXWriter, it is valid until the next AddSheet or Close
+type Sheet struct {
+	w       *XLSXWriter
+	buf     *bufio.Writer
+	columns []Column
+	fields  []field
+	rows    int
+	err     error
+}
+
+// NewXLSXWriter creates an XLSXWriter writing to w, Close must be called to finish the workbook
+func NewXLSXWriter(w io.Writer, ops ...Option) *XLSXWriter {
+	return &XLSXWriter{zw: zip.NewWriter(w), opts: newOptions(ops)}
+}
+
+// AddSheet finishes the current sheet and starts a new one, the header row is written by columns
+func (x *XLSXWriter) AddSheet(name string, columns []Column) (*Sheet, error) {
+	if x.closed {
+		return nil, errors.NewBadRequestError("tabular: workbook is closed")
+	}
+	if err := validSheetName(name); err != nil {
+		return nil, err
+	}
+	for _, s := range x.sheets {
+		if strings.EqualFold(s, name) {
+			return nil, errors.NewConflictError("sheet " + name)
+		}
+	}
+	if err := x.finishSheet(); err != nil {
+		return nil, err
+	}
+	x.sheets = append(x.sheets, name)
+	fw, err := x.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(x.sheets)))
+	if err != nil {
+		return nil, err
+	}
+	sheet := &Sheet{w: x, buf: bufio.NewWriter(fw), columns: columns}
+	sheet.writeString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	var cols strings.Builder
+	for i, col := range columns {
+		if col.Width > 0 {
+			fmt.Fprintf(&cols, `<col min="%d" max="%d" width="%s" customWidth="1"/>`, i+1, i+1, strconv.FormatFloat(col.Width, 'f', -1, 64))
+		}
+	}
+	if cols.Len() > 0 {
+		sheet.writeString("<cols>" + cols.String() + "</cols>")
+	}
+	sheet.writeString("<sheetData>")
+
+	header := make([]interface{}, len(columns))
+	for i, col := range columns {
+		header[i] = col.Name
+	}
+	sheet.writeRow(header, styleHeader)
+	x.cur = sheet
+	return sheet, sheet.err
+}
+
+// AddStructSheet is like AddSheet, but the columns are the fields of the struct type of v,
+// the rows can be written by WriteStruct
+func (x *XLSXWriter) AddStructSheet(name string, v interface{}) (*Sheet, error) {
+	rv, err := structValue(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := structFields(rv.Type())
+	columns := make([]Column, len(fields))
+	for i, f := range fields {
+		columns[i] = Column{Name: f.name}
+	}
+	sheet, err := x.AddSheet(name, columns)
+	if err != nil {
+		return nil, err
+	}
+	sheet.fields = fields
+	return sheet, nil
+}
+
+func (x *XLSXWriter) finishSheet() error {
+	if x.cur == nil {
+		return nil
+	}
+	sheet := x.cur
+	x.cur = nil
+	sheet.writeString("</sheetData></worksheet>")
+	if sheet.err != nil {
+		return sheet.err
+	}
+	return sheet.buf.Flush()
+}
+
+// Close finishes the workbook, it does not close the underlying writer
+func (x *XLSXWriter) Close() error {
+	if x.closed {
+		return nil
+	}
+	x.closed = true
+	if err := x.finishSheet(); err != nil {
+		return err
+	}
+	if len(x.sheets) == 0 {
+		return errors.NewBadRequestError("tabular: workbook has no sheets")
+	}
+
+	var types, rels, sheets strings.Builder
+	for i, name := range x.sheets {
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escape(name), i+1, i+1)
+	}
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(x.sheets)+1)
+
+	files := []struct{ name, content string }{
+		{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			types.String() + `</Types>`},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+			`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` +
+			sheets.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			rels.String() + `</Relationships>`},
+		{"xl/styles.xml", stylesXML},
+	}
+	for _, f := range files {
+		fw, err := x.zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, f.content); err != nil {
+			return err
+		}
+	}
+	return x.zw.Close()
+}
+
+// WriteRow writes a row of values, nil values are written as empty cells
+func (s *Sheet) WriteRow(values ...interface{}) error {
+	if s.w.cur != s {
+		return errors.NewBadRequestError("tabular: sheet is finished")
+	}
+	if s.w.opts.maxRows > 0 && s.rows > s.w.opts.maxRows || s.rows >= maxSheetRows {
+		return ErrTooLarge
+	}
+	s.writeRow(values, styleDefault)
+	return s.err
+}
+
+// WriteStruct writes the fields of v as a row, the sheet should be added by AddStructSheet
+func (s *Sheet) WriteStruct(v interface{}) error {
+	if s.fields == nil {
+		return errors.NewBadRequestError("tabular: sheet is not added by AddStructSheet")
+	}
+	rv, err := structValue(v)
+	if err != nil {
+		return err
+	}
+	values := make([]interface{}, len(s.fields))
+	for i, f := range s.fields {
+		values[i] = rv.FieldByIndex(f.index).Interface()
+	}
+	return s.WriteRow(values...)
+}
+
+func (s *Sheet) writeRow(values []interface{}, style int) {
+	s.rows++
+	s.writeString(fmt.Sprintf(`<row r="%d">`, s.rows))
+	for i, v := range values {
+		typ := Auto
+		if i < len(s.columns) {
+			typ = s.columns[i].Type
+		}
+		s.writeCell(cellRef(i, s.rows), v, typ, style)
+	}
+	s.writeString("</row>")
+}
+
+func (s *Sheet) writeCell(ref string, v interface{}, typ ColumnType, style int) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return
+	}
+	if typ == Auto {
+		typ = detectType(rv)
+	}
+	switch typ {
+	case Number:
+		if f, ok := toFloat(rv); ok && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			s.writeString(fmt.Sprintf(`<c r="%s" s="%d"><v>%s</v></c>`, ref, style, strconv.FormatFloat(f, 'f', -1, 64)))
+			return
+		}
+	case Bool:
+		if rv.Kind() == reflect.Bool {
+			b := 0
+			if rv.Bool() {
+				b = 1
+			}
+			s.writeString(fmt.Sprintf(`<c r="%s" s="%d" t="b"><v>%d</v></c>`, ref, style, b))
+			return
+		}
+	case Time:
+		if t, ok := rv.Interface().(time.Time); ok {
+			if t.IsZero() {
+				return
+			}
+			s.writeString(fmt.Sprintf(`<c r="%s" s="%d"><v>%s</v></c>`, ref, styleTime, strconv.FormatFloat(excelTime(t), 'f', -1, 64)))
+			return
+		}
+	}
+	// the values that do not match the column type are written as strings
+	text, err := formatValue(rv, s.w.opts.timeLayout)
+	if err != nil {
+		text = fmt.Sprint(rv.Interface())
+	}
+	s.writeString(fmt.Sprintf(`<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, escape(text)))
+}
+
+func (s *Sheet) writeString(str string) {
+	if s.err != nil {
+		return
+	}
+	_, s.err = s.buf.WriteString(str)
+}
+
+func detectType(v reflect.Value) ColumnType {
+	if v.Type() == timeType {
+		return Time
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return Bool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return Number
+	}
+	return String
+}
+
+func toFloat(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		f, err := strconv.ParseFloat(v.String(), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// excelTime converts t to the serial date of Excel, the days since 1899-12-30 in the local time of t
+func excelTime(t time.Time) float64 {
+	_, offset := t.Zone()
+	secs := t.Unix() + int64(offset) + 2209161600
+	return float64(secs)/86400 + float64(t.Nanosecond())/86400e9
+}
+
+// cellRef returns the reference of a cell like "A1", col is 0-based and row is 1-based
+func cellRef(col, row int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+	return name + strconv.Itoa(row)
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func validSheetName(name string) error {
+	if name == "" || len([]rune(name)) > 31 || strings.ContainsAny(name, `[]:*?/\`) {
+		return errors.NewBadRequestError(fmt.Sprintf("tabular: invalid sheet name %q", name))
+	}
+	return nil
+}
+
+const stylesXML = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="3">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`</cellXfs></styleSheet>`
@@ -0,0 +1,24 @@
+package fields
+
+// The tags of the fields, shared by the services so the logs of the same kind are searched by the same keys
+const (
+	TagEvent = "event"
+	TagCost  = "cost" // the duration of the operation
+
+	TagHTTPMethod = "http.method"
+	TagHTTPPath   = "http.path"
+	TagHTTPQuery  = "http.query" // with the values of the sensitive keys masked
+
+	TagSQLQuery = "sql.query"
+	TagSQLArgs  = "sql.args"
+	TagSQLRows  = "sql.rows" // the rows returned or affected
+
+	TagRedisCmd = "redis.cmd"
+	TagRedisKey = "redis.key"
+)
+
+// Masked replaces the values of the sensitive query keys
+const Masked = "***"
+
+// sensitiveKeys are masked in the queries, matched by the lowercase keys containing them
+var sensitiveKeys = []string{"password", "passwd", "secret", "token", "auth", "sign", "credential", "key"}
@@ -0,0 +1,119 @@
+// Package fields renders the common values of the services as the tags of the trace logs, like
+//
+//	tracer.Info(fields.SQL(query, args, rows, time.Since(start)))
+//
+// logs sql.query=[select * from orders where id = ?] sql.args=[42] sql.rows=[1] cost=[1.2ms], with the
+// tags of constants.go, so the naming doesn't drift across the services. The fields are rendered only
+// when the logs are formatted.
+//
+// The services may Register the fields of their events, and SetLintMode in the development to check
+// the logs against them.
+package fields
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxArgLen truncates the long sql args, like the blobs
+const maxArgLen = 64
+
+// Fields are the tags and their values, rendered like key=[value] key2=[value2]
+type Fields []Field
+
+// Field is a tag and its value
+type Field struct {
+	Key   string
+	Value string
+}
+
+func (fs Fields) String() string {
+	lint(fs)
+	var buffer bytes.Buffer
+	for i, f := range fs {
+		if i > 0 {
+			buffer.WriteString(" ")
+		}
+		buffer.WriteString(f.Key)
+		buffer.WriteString("=[")
+		buffer.WriteString(f.Value)
+		buffer.WriteString("]")
+	}
+	return buffer.String()
+}
+
+// HTTPRequest returns the method, the path and the query of req, the values of the sensitive query keys
+// like token and password are masked
+func HTTPRequest(req *http.Request) Fields {
+	fs := Fields{{TagHTTPMethod, req.Method}, {TagHTTPPath, req.URL.Path}}
+	if req.URL.RawQuery != "" {
+		fs = append(fs, Field{TagHTTPQuery, SanitizeQuery(req.URL.Query())})
+	}
+	return fs
+}
+
+// SanitizeQuery encodes the query sorted by the keys, with the values of the sensitive keys masked
+func SanitizeQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buffer bytes.Buffer
+	for _, k := range keys {
+		for _, v := range query[k] {
+			if buffer.Len() > 0 {
+				buffer.WriteString("&")
+			}
+			buffer.WriteString(url.QueryEscape(k))
+			buffer.WriteString("=")
+			if sensitive(k) {
+				buffer.WriteString(Masked)
+			} else {
+				buffer.WriteString(url.QueryEscape(v))
+			}
+		}
+	}
+	return buffer.String()
+}
+
+func sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// SQL returns the query, the args, the rows returned or affected and the cost, the long args are truncated.
+// The whitespaces in query are collapsed, so the multiline queries are logged in one line.
+func SQL(query string, args []interface{}, rows int64, cost time.Duration) Fields {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		s := fmt.Sprint(arg)
+		if b, ok := arg.([]byte); ok {
+			s = fmt.Sprintf("%d bytes", len(b))
+		} else if len(s) > maxArgLen {
+			s = s[:maxArgLen] + "..."
+		}
+		strs[i] = s
+	}
+	return Fields{
+		{TagSQLQuery, strings.Join(strings.Fields(query), " ")},
+		{TagSQLArgs, strings.Join(strs, ", ")},
+		{TagSQLRows, fmt.Sprint(rows)},
+		{TagCost, cost.String()},
+	}
+}
+
+// Redis returns the command, the key and the cost
+func Redis(cmd, key string, cost time.Duration) Fields {
+	return Fields{{TagRedisCmd, strings.ToUpper(cmd)}, {TagRedisKey, key}, {TagCost, cost.String()}}
+}
@@ -0,0 +1,53 @@
+package fields
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFields(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/orders?page=2&access_token=abc&Password=x&id=1&id=2", nil)
+	for _, c := range []struct {
+		field    fmt.Stringer
+		expected string
+	}{
+		{HTTPRequest(req), `http.method=[GET] http.path=[/api/v1/orders] http.query=[Password=***&access_token=***&id=1&id=2&page=2]`},
+		{HTTPRequest(httptest.NewRequest("POST", "/login", nil)), `http.method=[POST] http.path=[/login]`},
+		{SQL("select *\n\tfrom orders where id = ? and data = ?", []interface{}{42, []byte("blob")}, 1, 1500*time.Microsecond), `sql.query=[select * from orders where id = ? and data = ?] sql.args=[42, 4 bytes] sql.rows=[1] cost=[1.5ms]`},
+		{Redis("get", "session:1", time.Millisecond), `redis.cmd=[GET] redis.key=[session:1] cost=[1ms]`},
+	} {
+		if s := fmt.Sprint(c.field); s != c.expected {
+			t.Fatalf("expect %s, got %s", c.expected, s)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	Register("order.paid", Schema{"order.id": TypeInt, "amount": TypeFloat})
+	for _, c := range []struct {
+		fields   Fields
+		expected string
+	}{
+		{Fields{{TagEvent, "order.paid"}, {"order.id", "42"}, {"amount", "9.5"}, {TagCost, "1ms"}}, ""},
+		{Fields{{TagEvent, "order.paid"}, {"order.id", "o-42"}, {"user", "u1"}}, `fields of order.paid: field "order.id" is not int: "o-42", undeclared field "user"`},
+		{Fields{{TagEvent, "order.refunded"}}, `fields of order.refunded: undeclared event`},
+		{Fields{{"anything", "x"}, {TagSQLRows, "many"}}, `fields: field "sql.rows" is not int: "many"`},
+		{SQL("select 1", nil, 1, time.Millisecond), ""},
+	} {
+		err := Lint(c.fields)
+		if (err == nil && c.expected != "") || (err != nil && err.Error() != c.expected) {
+			t.Fatalf("%s: expect %q, got %v", c.fields, c.expected, err)
+		}
+	}
+
+	SetLintMode(LintStrict)
+	defer SetLintMode(LintOff)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect the strict mode panic")
+		}
+	}()
+	_ = Fields{{TagEvent, "order.paid"}, {"order.id", "x"}}.String()
+}
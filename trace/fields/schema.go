@@ -0,0 +1,160 @@
+package fields
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Type is the type of the values of a field, checked by parsing the rendered values
+type Type int
+
+const (
+	TypeString Type = iota
+	TypeInt
+	TypeFloat
+	TypeBool
+	TypeDuration // like 1.5ms
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeInt:
+		return "int"
+	case TypeFloat:
+		return "float"
+	case TypeBool:
+		return "bool"
+	case TypeDuration:
+		return "duration"
+	}
+	return "string"
+}
+
+func (t Type) check(value string) bool {
+	var err error
+	switch t {
+	case TypeInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case TypeFloat:
+		_, err = strconv.ParseFloat(value, 64)
+	case TypeBool:
+		_, err = strconv.ParseBool(value)
+	case TypeDuration:
+		_, err = time.ParseDuration(value)
+	}
+	return err == nil
+}
+
+// Schema is the fields of the logs of an event and their types
+type Schema map[string]Type
+
+// commonSchema is of the tags of constants.go, they are declared for all the events
+var commonSchema = Schema{
+	TagEvent:      TypeString,
+	TagCost:       TypeDuration,
+	TagHTTPMethod: TypeString,
+	TagHTTPPath:   TypeString,
+	TagHTTPQuery:  TypeString,
+	TagSQLQuery:   TypeString,
+	TagSQLArgs:    TypeString,
+	TagSQLRows:    TypeInt,
+	TagRedisCmd:   TypeString,
+	TagRedisKey:   TypeString,
+}
+
+var registry = struct {
+	sync.RWMutex
+	schemas map[string]Schema
+}{schemas: map[string]Schema{}}
+
+// Register declares the fields of the logs of the event, the Fields with the tag event of it, besides
+// the common tags. It's called at the init of the services, the later calls add the fields to the event.
+func Register(event string, schema Schema) {
+	registry.Lock()
+	defer registry.Unlock()
+	s := registry.schemas[event]
+	if s == nil {
+		s = Schema{}
+		registry.schemas[event] = s
+	}
+	for key, t := range schema {
+		s[key] = t
+	}
+}
+
+// LintMode is how the Fields are checked against the schemas when they are rendered
+type LintMode int32
+
+const (
+	LintOff    LintMode = iota // not checked, the default for the production
+	LintWarn                   // the problems are written to stderr
+	LintStrict                 // the problems panic, for the development and the tests
+)
+
+var lintMode int32
+
+// SetLintMode sets the mode checking the Fields, like LintWarn in the development builds, so the schema
+// drift is caught before it breaks the parsers downstream
+func SetLintMode(mode LintMode) {
+	atomic.StoreInt32(&lintMode, int32(mode))
+}
+
+// Lint checks fs against the schema of its event: the undeclared fields, the values not of the declared
+// types, and the events not registered. The Fields without an event are checked by the common tags only.
+func Lint(fs Fields) error {
+	event := ""
+	for _, f := range fs {
+		if f.Key == TagEvent {
+			event = f.Value
+		}
+	}
+	registry.RLock()
+	schema, ok := registry.schemas[event]
+	registry.RUnlock()
+	var problems []string
+	if event != "" && !ok {
+		problems = append(problems, "undeclared event")
+	}
+	for _, f := range fs {
+		t, declared := commonSchema[f.Key]
+		if !declared && schema != nil {
+			t, declared = schema[f.Key]
+		}
+		switch {
+		case !declared && ok:
+			problems = append(problems, fmt.Sprintf("undeclared field %q", f.Key))
+		case declared && !t.check(f.Value):
+			problems = append(problems, fmt.Sprintf("field %q is not %s: %q", f.Key, t, f.Value))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	if event != "" {
+		return fmt.Errorf("fields of %s: %s", event, strings.Join(problems, ", "))
+	}
+	return fmt.Errorf("fields: %s", strings.Join(problems, ", "))
+}
+
+// lint checks fs by the lint mode
+func lint(fs Fields) {
+	mode := LintMode(atomic.LoadInt32(&lintMode))
+	if mode == LintOff {
+		return
+	}
+	err := Lint(fs)
+	if err == nil {
+		return
+	}
+	if mode == LintStrict {
+		panic(err)
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+}
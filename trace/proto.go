@@ -0,0 +1,40 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Proto returns the field of the message for the logs, like t.Info("call done", trace.Proto("req", req)).
+// It's rendered like req=[{"name":"x","ids":[1,2]}] in the compact protobuf JSON mapping, only when the
+// log is formatted, instead of the %+v of the generated structs with their internal fields.
+func Proto(key string, msg proto.Message) fmt.Stringer {
+	return protoField{key: key, msg: msg}
+}
+
+type protoField struct {
+	key string
+	msg proto.Message
+}
+
+func (f protoField) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(f.key)
+	buffer.WriteString("=[")
+	data, err := protojson.Marshal(f.msg)
+	if err == nil {
+		// protojson randomizes the spaces between the tokens, compact them for the stable logs
+		err = json.Compact(&buffer, data)
+	}
+	if err != nil {
+		buffer.WriteString("!ERROR(")
+		buffer.WriteString(err.Error())
+		buffer.WriteString(")")
+	}
+	buffer.WriteString("]")
+	return buffer.String()
+}
@@ -0,0 +1,65 @@
+package trace
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxQueries bounds the queries kept for a request, the ones beyond it are only counted
+const maxQueries = 1000
+
+// Query is the summary of a database query run for a request, see AddQuery
+type Query struct {
+	Hash     string // of the normalized statement, the same for the statements differing in the values only
+	Rows     int64  // returned or affected
+	Duration time.Duration
+}
+
+// queryList collects the queries of a request, shared by the contexts derived from the request one
+type queryList struct {
+	sync.Mutex
+	queries  []Query
+	count    int
+	duration time.Duration
+}
+
+const queryListID key = 27114
+
+// WithQueries returns a context collecting the queries run with it and the contexts derived from it,
+// HandleFunc sets it for each request
+func WithQueries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, queryListID, &queryList{})
+}
+
+// CollectsQueries tells whether ctx collects the queries, so the summaries are not computed for nothing
+func CollectsQueries(ctx context.Context) bool {
+	_, ok := ctx.Value(queryListID).(*queryList)
+	return ok
+}
+
+// AddQuery appends the query to the list of the request of ctx, it's ignored without the list
+func AddQuery(ctx context.Context, q Query) {
+	list, ok := ctx.Value(queryListID).(*queryList)
+	if !ok {
+		return
+	}
+	list.Lock()
+	defer list.Unlock()
+	list.count++
+	list.duration += q.Duration
+	if len(list.queries) < maxQueries {
+		list.queries = append(list.queries, q)
+	}
+}
+
+// Queries returns the queries of the request of ctx, the count and the total duration of all of them
+func Queries(ctx context.Context) ([]Query, int, time.Duration) {
+	list, ok := ctx.Value(queryListID).(*queryList)
+	if !ok {
+		return nil, 0, 0
+	}
+	list.Lock()
+	defer list.Unlock()
+	return append([]Query(nil), list.queries...), list.count, list.duration
+}
@@ -55,10 +55,14 @@ func HandleFunc(name string, handler func(w http.ResponseWriter, r *http.Request
 		}(r)
 
 		tracer.Infof("event=[request-in] remote=[%s] route=[%s] method=[%s] url=[%s]", ip, lastRoute, r.Method, r.URL.String())
-		defer tracer.Info("event=[request-out]")
 
 		ctx := context.WithValue(r.Context(), tracerLogHandlerID, tracer)
 		ctx = context.WithValue(ctx, realIPValueID, ip)
+		ctx = WithQueries(ctx)
+		defer func() {
+			_, count, duration := Queries(ctx)
+			tracer.Infof("event=[request-out] db_queries=[%d] db_cost=[%v]", count, duration)
+		}()
 
 		handler(w, r.WithContext(ctx))
 	}
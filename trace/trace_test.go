@@ -15,12 +15,17 @@
 package trace_test
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/leopoldxx/go-utils/trace"
+	"github.com/tools-go/go-utils/utils/clock"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 func TestTrace(t *testing.T) {
@@ -65,3 +70,54 @@ func TestTraceHandler(t *testing.T) {
 
 	ts.Close()
 }
+
+func TestDeterministicTrace(t *testing.T) {
+	c := clock.NewFakeClock(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	trace.SetClock(c)
+	trace.SetIDGenerator(func() string { return "fixed" })
+	defer trace.SetClock(nil)
+	defer trace.SetIDGenerator(nil)
+
+	t1 := trace.New("t1")
+	c.Advance(42 * time.Millisecond)
+	if s := t1.String(); s != "tname=[t1] tid=[fixed] tduration=[42] " || !t1.Start().Equal(c.Now().Add(-42*time.Millisecond)) {
+		t.Fatalf("unexpected trace %q", s)
+	}
+	trace.SetIDGenerator(nil)
+	if id := trace.New("t2").ID(); len(id) != 36 {
+		t.Fatalf("expect the uuid restored, got %q", id)
+	}
+}
+
+func TestProto(t *testing.T) {
+	msg, _ := structpb.NewStruct(map[string]interface{}{"name": "x", "ids": []interface{}{1, 2}})
+	for field, expected := range map[fmt.Stringer]string{
+		trace.Proto("req", msg):                        `req=[{"ids":[1,2],"name":"x"}]`,
+		trace.Proto("id", wrapperspb.String("abc")):    `id=["abc"]`,
+		trace.Proto("empty", &wrapperspb.Int64Value{}): `empty=["0"]`,
+	} {
+		if s := fmt.Sprint(field); s != expected {
+			t.Fatalf("expect %s, got %s", expected, s)
+		}
+	}
+}
+
+func TestQueries(t *testing.T) {
+	var count int
+	h := trace.HandleFunc("req", func(w http.ResponseWriter, r *http.Request) {
+		trace.AddQuery(r.Context(), trace.Query{Hash: "a1", Rows: 1, Duration: time.Millisecond})
+		trace.AddQuery(r.Context(), trace.Query{Hash: "b2", Rows: 3, Duration: 2 * time.Millisecond})
+		var duration time.Duration
+		_, count, duration = trace.Queries(r.Context())
+		if duration != 3*time.Millisecond {
+			t.Errorf("unexpected duration %v", duration)
+		}
+	})
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders", nil))
+	if count != 2 {
+		t.Fatalf("expect 2 queries of the request, got %d", count)
+	}
+	if _, count, _ := trace.Queries(context.TODO()); count != 0 {
+		t.Fatalf("expect no queries out of a request, got %d", count)
+	}
+}
@@ -0,0 +1,110 @@
+// Package archive packs and unpacks zip and tar.gz archives.
+//
+// Extraction is safe for untrusted archives: the entries can not escape the destination
+// directory(zip-slip), links and special files are rejected, and the count and the total
+// size of the files are limited by the actually written bytes, not by the headers.
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Format of an archive
+type Format int
+
+// supported formats
+const (
+	Zip Format = iota
+	TarGz
+)
+
+func (f Format) String() string {
+	switch f {
+	case Zip:
+		return "zip"
+	case TarGz:
+		return "tar.gz"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// default limits of the extraction
+const (
+	DefaultMaxFiles = 10000
+	DefaultMaxSize  = 1 << 30
+)
+
+var (
+	// ErrUnknownFormat is returned when the format can not be detected by the file name
+	ErrUnknownFormat = errors.New("unknown archive format")
+	// ErrLimitExceeded is returned when the extracted files exceed WithMaxFiles or WithMaxSize
+	ErrLimitExceeded = errors.New("archive limit exceeded")
+	// ErrUnsupportedEntry is returned when extracting links or special files
+	ErrUnsupportedEntry = errors.New("unsupported archive entry")
+)
+
+// FormatOf detects the format by the file name
+func FormatOf(name string) (Format, error) {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return Zip, nil
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return TarGz, nil
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnknownFormat, name)
+}
+
+// Progress is reported after each entry is packed or extracted
+type Progress struct {
+	// Name of the current entry
+	Name  string
+	Files int
+	Bytes int64
+}
+
+type options struct {
+	maxFiles int
+	maxSize  int64
+	progress func(p Progress)
+}
+
+// Option of packing and extraction
+type Option func(opts *options)
+
+// WithMaxFiles limits the count of the extracted entries, default is DefaultMaxFiles
+func WithMaxFiles(n int) Option {
+	return func(opts *options) {
+		opts.maxFiles = n
+	}
+}
+
+// WithMaxSize limits the total bytes of the extracted files, default is DefaultMaxSize
+func WithMaxSize(n int64) Option {
+	return func(opts *options) {
+		opts.maxSize = n
+	}
+}
+
+// WithProgress set the progress callback
+func WithProgress(fn func(p Progress)) Option {
+	return func(opts *options) {
+		opts.progress = fn
+	}
+}
+
+func newOptions(ops []Option) *options {
+	opts := &options{maxFiles: DefaultMaxFiles, maxSize: DefaultMaxSize}
+	for _, op := range ops {
+		op(opts)
+	}
+	return opts
+}
+
+func (opts *options) report(name string, files int, bytes int64) {
+	if opts.progress != nil {
+		opts.progress(Progress{Name: name, Files: files, Bytes: bytes})
+	}
+}
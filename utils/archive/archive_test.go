@@ -0,0 +1,166 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tools-go/go-utils/utils/urlutil"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPackExtract(t *testing.T) {
+	for _, format := range []Format{Zip, TarGz} {
+		t.Run(format.String(), func(t *testing.T) {
+			src, dest := t.TempDir(), t.TempDir()
+			writeFiles(t, src, map[string]string{
+				"a.txt":         "hello",
+				"sub/b.txt":     "world",
+				"sub/deep/c.go": "package c",
+			})
+			os.Symlink(filepath.Join(src, "a.txt"), filepath.Join(src, "link"))
+
+			var packed []Progress
+			buf := &bytes.Buffer{}
+			aw, err := NewWriter(buf, format, WithProgress(func(p Progress) {
+				packed = append(packed, p)
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := aw.AddDir(src, "bundle"); err != nil {
+				t.Fatal(err)
+			}
+			if err := aw.Add(Entry{Name: "stream.log", Size: -1}, strings.NewReader("streamed")); err != nil {
+				t.Fatal(err)
+			}
+			if err := aw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			// bundle, a.txt, sub, b.txt, deep, c.go and stream.log, the symlink is skipped
+			if len(packed) != 7 || packed[6].Name != "stream.log" || packed[6].Bytes != 27 {
+				t.Fatalf("unexpected progress: %+v", packed)
+			}
+
+			var extracted int
+			progress := WithProgress(func(p Progress) { extracted = p.Files })
+			if format == Zip {
+				err = ExtractZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()), dest, progress)
+			} else {
+				err = ExtractTarGz(buf, dest, progress)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if extracted != 7 {
+				t.Fatalf("expect 7 entries extracted, got %d", extracted)
+			}
+			for name, content := range map[string]string{
+				"bundle/a.txt":         "hello",
+				"bundle/sub/deep/c.go": "package c",
+				"stream.log":           "streamed",
+			} {
+				b, err := ioutil.ReadFile(filepath.Join(dest, name))
+				if err != nil || string(b) != content {
+					t.Fatalf("unexpected %s: %q, %v", name, b, err)
+				}
+			}
+			if info, err := os.Stat(filepath.Join(dest, "bundle/sub/b.txt")); err != nil || info.Mode().Perm() != 0640 {
+				t.Fatalf("unexpected mode: %v, %v", info, err)
+			}
+		})
+	}
+}
+
+func TestPackFile(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	writeFiles(t, src, map[string]string{"x/y.txt": "y"})
+	file := filepath.Join(out, "bundle.tgz")
+	if err := PackFile(file, src); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(out, "dest")
+	if err := ExtractFile(file, dest); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dest, "x/y.txt")); err != nil || string(b) != "y" {
+		t.Fatalf("unexpected content: %q, %v", b, err)
+	}
+	if err := PackFile(filepath.Join(out, "bundle.rar"), src); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("expect unknown format, got %v", err)
+	}
+}
+
+func zipOf(t *testing.T, files map[string]string) *bytes.Reader {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	for _, name := range []string{"../evil.txt", "a/../../evil.txt", `..\evil.txt`, "/etc/evil.txt"} {
+		dest := t.TempDir()
+		r := zipOf(t, map[string]string{name: "evil"})
+		if err := ExtractZip(r, r.Size(), dest); !errors.Is(err, urlutil.ErrUnsafePath) {
+			t.Fatalf("expect unsafe path for %s, got %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(dest), "evil.txt")); err == nil {
+			t.Fatalf("%s escaped from dest", name)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "/etc/passwd"})
+	tw.Close()
+	gw.Close()
+	if err := ExtractTarGz(buf, t.TempDir()); !errors.Is(err, ErrUnsupportedEntry) {
+		t.Fatalf("expect symlink rejected, got %v", err)
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	r := zipOf(t, map[string]string{"a": "1", "b": "2", "c": "3"})
+	if err := ExtractZip(r, r.Size(), t.TempDir(), WithMaxFiles(2)); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expect too many files, got %v", err)
+	}
+
+	// a highly compressed file
+	r = zipOf(t, map[string]string{"bomb": strings.Repeat("0", 1<<20)})
+	if r.Size() > 10<<10 {
+		t.Fatalf("unexpected zip size %d", r.Size())
+	}
+	if err := ExtractZip(r, r.Size(), t.TempDir(), WithMaxSize(1<<16)); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expect too large, got %v", err)
+	}
+	if err := ExtractZip(r, r.Size(), t.TempDir(), WithMaxSize(1<<20)); err != nil {
+		t.Fatalf("expect exactly the limit is allowed, got %v", err)
+	}
+}
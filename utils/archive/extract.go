@@ -0,0 +1,187 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tools-go/go-utils/utils/urlutil"
+)
+
+// extractor writes the entries under dest and enforces the limits
+type extractor struct {
+	dest  string
+	opts  *options
+	files int
+	bytes int64
+}
+
+// target returns the local path of the entry, it is guaranteed to be inside dest
+func (e *extractor) target(name string) (string, error) {
+	// some zip tools on windows use backslashes as separators
+	name = strings.Replace(name, `\`, "/", -1)
+	if strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("%w: %q", urlutil.ErrUnsafePath, name)
+	}
+	joined, err := urlutil.SafeJoin(filepath.ToSlash(e.dest), name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.FromSlash(joined), nil
+}
+
+func (e *extractor) count() error {
+	e.files++
+	if e.opts.maxFiles > 0 && e.files > e.opts.maxFiles {
+		return fmt.Errorf("%w: more than %d files", ErrLimitExceeded, e.opts.maxFiles)
+	}
+	return nil
+}
+
+func (e *extractor) mkdir(name string) error {
+	if err := e.count(); err != nil {
+		return err
+	}
+	dir, err := e.target(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	e.opts.report(name, e.files, e.bytes)
+	return nil
+}
+
+func (e *extractor) write(name string, mode os.FileMode, r io.Reader) error {
+	if err := e.count(); err != nil {
+		return err
+	}
+	file, err := e.target(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	// only the permission bits are kept, setuid and the like are dropped
+	mode = mode.Perm() & 0777
+	if mode == 0 {
+		mode = 0644
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	// the size in the header can not be trusted, so read one more byte than the quota to detect a bomb
+	var quota int64 = -1
+	if e.opts.maxSize > 0 {
+		quota = e.opts.maxSize - e.bytes
+		r = io.LimitReader(r, quota+1)
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	e.bytes += n
+	if err != nil {
+		return err
+	}
+	if quota >= 0 && n > quota {
+		return fmt.Errorf("%w: more than %d bytes", ErrLimitExceeded, e.opts.maxSize)
+	}
+	e.opts.report(name, e.files, e.bytes)
+	return nil
+}
+
+// ExtractZip extracts the zip archive into dest
+func ExtractZip(r io.ReaderAt, size int64, dest string, ops ...Option) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	e := &extractor{dest: dest, opts: newOptions(ops)}
+	for _, f := range zr.File {
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = e.mkdir(f.Name)
+		case mode.IsRegular():
+			err = extractZipFile(e, f)
+		default:
+			err = fmt.Errorf("%w: %s(%s)", ErrUnsupportedEntry, f.Name, mode.Type())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipFile(e *extractor, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return e.write(f.Name, f.Mode(), rc)
+}
+
+// ExtractTarGz extracts the tar.gz archive read from r into dest
+func ExtractTarGz(r io.Reader, dest string, ops ...Option) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	e := &extractor{dest: dest, opts: newOptions(ops)}
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = e.mkdir(h.Name)
+		case tar.TypeReg:
+			err = e.write(h.Name, os.FileMode(h.Mode), tr)
+		case tar.TypeXGlobalHeader:
+		default:
+			err = fmt.Errorf("%w: %s(type %c)", ErrUnsupportedEntry, h.Name, h.Typeflag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ExtractFile extracts the archive file into dest, the format is detected by the file name
+func ExtractFile(file, dest string, ops ...Option) error {
+	format, err := FormatOf(file)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if format == TarGz {
+		return ExtractTarGz(f, dest, ops...)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return ExtractZip(f, info.Size(), dest, ops...)
+}
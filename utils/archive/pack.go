@@ -0,0 +1,220 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Entry is the header of a file added to an archive
+type Entry struct {
+	// Name is the slash separated path in the archive
+	Name    string
+	Mode    os.FileMode
+	ModTime time.Time
+	// Size of the content, -1 means unknown, and the content is spooled to a
+	// temporary file before added to a tar.gz archive, as tar needs the size first
+	Size int64
+}
+
+// Writer streams the entries into an archive
+type Writer struct {
+	format Format
+	opts   *options
+	zw     *zip.Writer
+	gw     *gzip.Writer
+	tw     *tar.Writer
+	files  int
+	bytes  int64
+}
+
+// NewWriter creates a Writer of format writing to w, Close must be called to finish the archive
+func NewWriter(w io.Writer, format Format, ops ...Option) (*Writer, error) {
+	aw := &Writer{format: format, opts: newOptions(ops)}
+	switch format {
+	case Zip:
+		aw.zw = zip.NewWriter(w)
+	case TarGz:
+		aw.gw = gzip.NewWriter(w)
+		aw.tw = tar.NewWriter(aw.gw)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+	return aw, nil
+}
+
+// Add adds a file with the content read from r, a directory is added if the mode is a directory
+func (aw *Writer) Add(entry Entry, r io.Reader) error {
+	name := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(entry.Name)), "/")
+	if name == "" {
+		return fmt.Errorf("invalid entry name %q", entry.Name)
+	}
+	if entry.ModTime.IsZero() {
+		entry.ModTime = time.Now()
+	}
+	if entry.Mode == 0 {
+		entry.Mode = 0644
+	}
+
+	var n int64
+	var err error
+	if entry.Mode.IsDir() {
+		err = aw.addDir(name+"/", entry)
+	} else {
+		n, err = aw.addFile(name, entry, r)
+	}
+	if err != nil {
+		return err
+	}
+	aw.files++
+	aw.bytes += n
+	aw.opts.report(name, aw.files, aw.bytes)
+	return nil
+}
+
+func (aw *Writer) addDir(name string, entry Entry) error {
+	if aw.zw != nil {
+		h := &zip.FileHeader{Name: name, Modified: entry.ModTime}
+		h.SetMode(entry.Mode)
+		_, err := aw.zw.CreateHeader(h)
+		return err
+	}
+	return aw.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name,
+		Mode:     int64(entry.Mode.Perm()),
+		ModTime:  entry.ModTime,
+	})
+}
+
+func (aw *Writer) addFile(name string, entry Entry, r io.Reader) (int64, error) {
+	if aw.zw != nil {
+		h := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: entry.ModTime}
+		h.SetMode(entry.Mode)
+		fw, err := aw.zw.CreateHeader(h)
+		if err != nil {
+			return 0, err
+		}
+		return io.Copy(fw, r)
+	}
+
+	if entry.Size < 0 {
+		spool, err := ioutil.TempFile("", "archive-spool-")
+		if err != nil {
+			return 0, err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		if entry.Size, err = io.Copy(spool, r); err != nil {
+			return 0, err
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		r = spool
+	}
+	err := aw.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(entry.Mode.Perm()),
+		ModTime:  entry.ModTime,
+		Size:     entry.Size,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(aw.tw, r)
+}
+
+// AddFile adds the local file as name
+func (aw *Writer) AddFile(name, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return aw.Add(Entry{Name: name, Mode: info.Mode(), ModTime: info.ModTime(), Size: info.Size()}, f)
+}
+
+// AddDir adds the files under dir recursively, the names in the archive are prefixed by prefix.
+// Only the directories and the regular files are added, the symlinks are skipped.
+func (aw *Writer) AddDir(dir, prefix string) error {
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		name := path.Join(prefix, filepath.ToSlash(rel))
+		switch {
+		case rel == ".":
+			if prefix == "" {
+				return nil
+			}
+			return aw.Add(Entry{Name: name, Mode: info.Mode(), ModTime: info.ModTime()}, nil)
+		case info.IsDir():
+			return aw.Add(Entry{Name: name, Mode: info.Mode(), ModTime: info.ModTime()}, nil)
+		case info.Mode().IsRegular():
+			return aw.AddFile(name, file)
+		}
+		return nil
+	})
+}
+
+// Close finishes the archive, it does not close the underlying writer
+func (aw *Writer) Close() error {
+	if aw.zw != nil {
+		return aw.zw.Close()
+	}
+	if err := aw.tw.Close(); err != nil {
+		return err
+	}
+	return aw.gw.Close()
+}
+
+// Pack writes the files under dir into an archive of format
+func Pack(w io.Writer, format Format, dir string, ops ...Option) error {
+	aw, err := NewWriter(w, format, ops...)
+	if err != nil {
+		return err
+	}
+	if err := aw.AddDir(dir, ""); err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// PackFile packs dir into the archive file, the format is detected by the file name
+func PackFile(file, dir string, ops ...Option) (err error) {
+	format, err := FormatOf(file)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(file)
+		}
+	}()
+	return Pack(f, format, dir, ops...)
+}
@@ -0,0 +1,172 @@
+// Package clock abstracts the time, so the code depending on it can be tested with a FakeClock
+// moving only when told, instead of sleeping in the tests
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits
+type Clock interface {
+	Now() time.Time
+	// After waits for d and then sends the current time on the returned channel, like time.After
+	After(d time.Duration) <-chan time.Time
+	// Ticker returns a ticker sending the time every d, like time.NewTicker
+	Ticker(d time.Duration) Ticker
+	// Sleep pauses the current goroutine for d, like time.Sleep
+	Sleep(d time.Duration)
+}
+
+// Ticker sends the time periodically
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// New returns the real clock
+func New() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) Ticker(d time.Duration) Ticker          { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// FakeClock is a clock moving only by Advance and Set, the waiters and the tickers fire as it moves
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+type waiter struct {
+	until  time.Time
+	period time.Duration // of the tickers, 0 for the others
+	c      chan time.Time
+}
+
+// NewFakeClock creates a FakeClock at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the time of the clock
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) wait(d, period time.Duration) *waiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &waiter{until: c.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- c.now
+		if period <= 0 {
+			return w
+		}
+		w.until = c.now.Add(period)
+	}
+	c.waiters = append(c.waiters, w)
+	return w
+}
+
+// After returns a channel receiving the time once the clock is advanced by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.wait(d, 0).c
+}
+
+// Sleep blocks until the clock is advanced by d
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// Ticker returns a ticker firing every d the clock is advanced, the ticks are dropped for the slow
+// receivers like time.Ticker
+func (c *FakeClock) Ticker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.Ticker")
+	}
+	return &fakeTicker{clock: c, w: c.wait(d, d)}
+}
+
+type fakeTicker struct {
+	clock *FakeClock
+	w     *waiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.c }
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.clock.remove(t.w)
+}
+
+func (c *FakeClock) remove(w *waiter) {
+	for i := range c.waiters {
+		if c.waiters[i] == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Advance moves the clock forward by d, firing the waiters due in order
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set sets the time of the clock, firing the waiters due in order
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+	sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].until.Before(c.waiters[j].until) })
+	kept := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.until.After(now) {
+			kept = append(kept, w)
+			continue
+		}
+		select {
+		case w.c <- w.until:
+		default:
+		}
+		if w.period > 0 {
+			// a ticker fires once per Set however far it goes, like a slow receiver of time.Ticker
+			for !w.until.After(now) {
+				w.until = w.until.Add(w.period)
+			}
+			kept = append(kept, w)
+		}
+	}
+	c.waiters = kept
+}
+
+// Waiters returns the count of the pending waiters and tickers, handy for waiting until a goroutine
+// is sleeping before advancing the clock
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// BlockUntil waits until there are at least n waiters and tickers
+func (c *FakeClock) BlockUntil(n int) {
+	for c.Waiters() < n {
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,63 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := NewFakeClock(start)
+	after := c.After(time.Minute)
+	ticker := c.Ticker(10 * time.Second)
+	slept := make(chan struct{})
+	go func() {
+		c.Sleep(30 * time.Second)
+		close(slept)
+	}()
+	c.BlockUntil(3)
+
+	c.Advance(10 * time.Second)
+	if got := <-ticker.C(); !got.Equal(start.Add(10 * time.Second)) {
+		t.Fatalf("unexpected tick %s", got)
+	}
+	select {
+	case <-after:
+		t.Fatal("expect not fired before a minute")
+	case <-slept:
+		t.Fatal("expect sleeping")
+	default:
+	}
+
+	c.Advance(time.Minute)
+	<-slept
+	if got := <-after; !got.Equal(start.Add(time.Minute)) {
+		t.Fatalf("unexpected after %s", got)
+	}
+	// the ticks are dropped for the slow receivers
+	if got := <-ticker.C(); !got.Equal(start.Add(20 * time.Second)) {
+		t.Fatalf("unexpected tick %s", got)
+	}
+	select {
+	case got := <-ticker.C():
+		t.Fatalf("unexpected tick %s", got)
+	default:
+	}
+	ticker.Stop()
+	if c.Waiters() != 0 || !c.Now().Equal(start.Add(70*time.Second)) {
+		t.Fatalf("unexpected clock %s with %d waiters", c.Now(), c.Waiters())
+	}
+	<-c.After(0)
+}
+
+func TestRealClock(t *testing.T) {
+	c := New()
+	start := c.Now()
+	<-c.After(time.Millisecond)
+	ticker := c.Ticker(time.Millisecond)
+	<-ticker.C()
+	ticker.Stop()
+	if c.Now().Sub(start) < 2*time.Millisecond {
+		t.Fatal("expect the time passed")
+	}
+}
@@ -0,0 +1,211 @@
+// Package glob implements doublestar style path matching.
+//
+// Besides the syntax supported by path.Match ('*', '?', '[a-z]', '[^abc]' and '\\' escapes),
+// a path segment of "**" matches zero or more whole segments and
+// "{a,b}" matches any of the comma separated alternatives.
+package glob
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const separator = "/"
+
+// Match reports whether name matches the pattern,
+// the only possible error is path.ErrBadPattern
+func Match(pattern, name string) (bool, error) {
+	alternatives, err := expandBraces(pattern)
+	if err != nil {
+		return false, err
+	}
+	for _, alt := range alternatives {
+		ok, err := matchSegments(strings.Split(alt, separator), strings.Split(name, separator))
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Validate reports whether the pattern is well formed
+func Validate(pattern string) error {
+	_, err := compile(pattern)
+	return err
+}
+
+// compile expands the braces of the pattern, and splits the alternatives into the validated segments
+func compile(pattern string) ([][]string, error) {
+	alternatives, err := expandBraces(pattern)
+	if err != nil {
+		return nil, err
+	}
+	compiled := make([][]string, 0, len(alternatives))
+	for _, alt := range alternatives {
+		segments := strings.Split(alt, separator)
+		for _, seg := range segments {
+			if seg == "**" {
+				continue
+			}
+			if _, err := path.Match(seg, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+			}
+		}
+		compiled = append(compiled, segments)
+	}
+	return compiled, nil
+}
+
+func matchSegments(patterns, names []string) (bool, error) {
+	for len(patterns) > 0 {
+		p := patterns[0]
+		if p == "**" {
+			// collapse consecutive "**"
+			for len(patterns) > 1 && patterns[1] == "**" {
+				patterns = patterns[1:]
+			}
+			if len(patterns) == 1 {
+				return true, nil
+			}
+			for i := 0; i <= len(names); i++ {
+				ok, err := matchSegments(patterns[1:], names[i:])
+				if err != nil || ok {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(names) == 0 {
+			return false, nil
+		}
+		ok, err := path.Match(p, names[0])
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+		patterns, names = patterns[1:], names[1:]
+	}
+	return len(names) == 0, nil
+}
+
+// expandBraces expands "{a,b}" alternatives, nested braces are supported
+func expandBraces(pattern string) ([]string, error) {
+	start := -1
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				return nil, path.ErrBadPattern
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			prefix, suffix := pattern[:start], pattern[i+1:]
+			var result []string
+			for _, alt := range splitAlternatives(pattern[start+1 : i]) {
+				expanded, err := expandBraces(prefix + alt + suffix)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, expanded...)
+			}
+			return result, nil
+		}
+	}
+	if depth != 0 {
+		return nil, path.ErrBadPattern
+	}
+	return []string{pattern}, nil
+}
+
+func splitAlternatives(s string) []string {
+	var (
+		result []string
+		depth  int
+		last   int
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				result = append(result, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(result, s[last:])
+}
+
+// Matcher matches paths against a list of patterns,
+// it is useful for skip lists and include/exclude filters
+type Matcher struct {
+	patterns []string
+	// the segments of the alternatives of all the patterns, the braces are expanded once by NewMatcher
+	alternatives [][]string
+}
+
+// NewMatcher validates the patterns and creates a Matcher
+func NewMatcher(patterns ...string) (*Matcher, error) {
+	m := &Matcher{patterns: append([]string{}, patterns...)}
+	for _, p := range patterns {
+		alternatives, err := compile(p)
+		if err != nil {
+			return nil, err
+		}
+		m.alternatives = append(m.alternatives, alternatives...)
+	}
+	return m, nil
+}
+
+// MustNewMatcher is like NewMatcher but panics on invalid patterns
+func MustNewMatcher(patterns ...string) *Matcher {
+	m, err := NewMatcher(patterns...)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Match reports whether name matches any of the patterns
+func (m *Matcher) Match(name string) bool {
+	if m == nil {
+		return false
+	}
+	names := strings.Split(name, separator)
+	for _, segments := range m.alternatives {
+		// patterns have been validated, errors can be ignored
+		if ok, _ := matchSegments(segments, names); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Patterns returns the patterns of the matcher
+func (m *Matcher) Patterns() []string {
+	if m == nil {
+		return nil
+	}
+	return append([]string{}, m.patterns...)
+}
@@ -0,0 +1,77 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		name    string
+		expect  bool
+	}{
+		{"/healthz", "/healthz", true},
+		{"/api/*", "/api/users", true},
+		{"/api/*", "/api/users/1", false},
+		{"/api/**", "/api/users/1", true},
+		{"/api/**", "/api", true},
+		{"/static/**/*.js", "/static/app.js", true},
+		{"/static/**/*.js", "/static/js/vendor/app.js", true},
+		{"/static/**/*.js", "/static/js/vendor/app.css", false},
+		{"**/*.go", "trace/trace.go", true},
+		{"**", "any/thing/at/all", true},
+		{"/debug/pprof/**/**", "/debug/pprof/heap", true},
+		{"conf/*.{yaml,yml,toml}", "conf/app.yml", true},
+		{"conf/*.{yaml,yml,toml}", "conf/app.json", false},
+		{"{/healthz,/metrics/{a,b}}", "/metrics/b", true},
+		{"log/[a-c]?.log", "log/b1.log", true},
+		{"log/[^a-c]?.log", "log/b1.log", false},
+		{"file\\*", "file*", true},
+		{"file\\*", "files", false},
+	}
+
+	for _, tc := range testCases {
+		got, err := Match(tc.pattern, tc.name)
+		if err != nil {
+			t.Fatalf("match %q with %q failed: %s", tc.name, tc.pattern, err)
+		}
+		if got != tc.expect {
+			t.Fatalf("match %q with %q: expect %v, got %v", tc.name, tc.pattern, tc.expect, got)
+		}
+	}
+}
+
+func TestInvalidPattern(t *testing.T) {
+	for _, p := range []string{"[a-", "{a,b", "a}", "/x/[", "**/["} {
+		if _, err := Match(p, "/x/y"); err == nil {
+			if err := Validate(p); err == nil {
+				t.Fatalf("expect %q to be an invalid pattern", p)
+			}
+		}
+		if _, err := NewMatcher(p); err == nil {
+			t.Fatalf("expect matcher with %q failed", p)
+		}
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	m := MustNewMatcher("/healthz", "/static/**", "*.ico")
+	for name, expect := range map[string]bool{
+		"/healthz":        true,
+		"/static/a/b.png": true,
+		"favicon.ico":     true,
+		"/api/users":      false,
+	} {
+		if m.Match(name) != expect {
+			t.Fatalf("matcher %v match %q: expect %v", m.Patterns(), name, expect)
+		}
+	}
+
+	// the braces are expanded once when the matcher is created
+	m = MustNewMatcher("/{a,b}/{c,d}/*.{js,css}")
+	if len(m.alternatives) != 8 || !m.Match("/b/c/x.css") || m.Match("/b/e/x.css") {
+		t.Fatalf("unexpected alternatives of the braces: %v", m.alternatives)
+	}
+	var nilMatcher *Matcher
+	if nilMatcher.Match("/healthz") {
+		t.Fatal("nil matcher should not match anything")
+	}
+}
@@ -0,0 +1,245 @@
+// Package imaging probes, decodes, transforms and encodes the uploaded images.
+//
+// JPEG, PNG, GIF and WebP(decoding only) are supported. The EXIF orientation of JPEG
+// is applied when decoding, so the thumbnails of the photos taken by phones are upright.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register the webp decoder
+)
+
+// Format of an image, it is the name registered by the decoder
+type Format string
+
+// supported formats
+const (
+	JPEG Format = "jpeg"
+	PNG  Format = "png"
+	GIF  Format = "gif"
+	WebP Format = "webp"
+)
+
+// defaults of the options
+const (
+	DefaultQuality   = 85
+	DefaultMaxPixels = 50 << 20
+)
+
+// the EXIF orientation is searched in the head of the image
+const probeSize = 64 << 10
+
+var (
+	// ErrUnsupportedFormat is returned when encoding an unsupported format
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrTooLarge is returned when the pixels of an image exceed WithMaxPixels
+	ErrTooLarge = errors.New("image too large")
+)
+
+// Info is the metadata of an image
+type Info struct {
+	Format Format
+	// Width and Height are the dimensions after the orientation is applied
+	Width  int
+	Height int
+	// Orientation is the EXIF orientation from 1 to 8, 1 means upright
+	Orientation int
+}
+
+type options struct {
+	quality   int
+	maxPixels int
+}
+
+// Option of decoding and encoding
+type Option func(opts *options)
+
+// WithQuality set the quality of JPEG from 1 to 100, default is DefaultQuality
+func WithQuality(quality int) Option {
+	return func(opts *options) {
+		opts.quality = quality
+	}
+}
+
+// WithMaxPixels limits the width*height of the images to decode, default is DefaultMaxPixels,
+// it protects the service from the decompression bombs
+func WithMaxPixels(n int) Option {
+	return func(opts *options) {
+		opts.maxPixels = n
+	}
+}
+
+func newOptions(ops []Option) *options {
+	opts := &options{quality: DefaultQuality, maxPixels: DefaultMaxPixels}
+	for _, op := range ops {
+		op(opts)
+	}
+	return opts
+}
+
+// Probe reads the format, the dimensions and the orientation of the image without decoding the pixels
+func Probe(r io.Reader) (Info, error) {
+	info, _, err := probe(r)
+	return info, err
+}
+
+// probe returns the info and a reader replaying the consumed bytes followed by the rest of r
+func probe(r io.Reader) (Info, io.Reader, error) {
+	head := make([]byte, probeSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Info{}, nil, err
+	}
+	head = head[:n]
+	replay := io.MultiReader(bytes.NewReader(head), r)
+
+	// the config may be after a large EXIF block, so read from the whole stream
+	var consumed bytes.Buffer
+	cfg, format, err := image.DecodeConfig(io.TeeReader(replay, &consumed))
+	if err != nil {
+		return Info{}, nil, err
+	}
+	info := Info{Format: Format(format), Width: cfg.Width, Height: cfg.Height, Orientation: 1}
+	if info.Format == JPEG {
+		info.Orientation = jpegOrientation(head)
+	}
+	if info.Orientation >= 5 {
+		info.Width, info.Height = info.Height, info.Width
+	}
+	return info, io.MultiReader(&consumed, replay), nil
+}
+
+// Decode decodes the image and applies the EXIF orientation
+func Decode(r io.Reader, ops ...Option) (image.Image, Info, error) {
+	opts := newOptions(ops)
+	info, r, err := probe(r)
+	if err != nil {
+		return nil, info, err
+	}
+	if opts.maxPixels > 0 && info.Width*info.Height > opts.maxPixels {
+		return nil, info, fmt.Errorf("%w: %dx%d", ErrTooLarge, info.Width, info.Height)
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, info, err
+	}
+	return Orient(img, info.Orientation), info, nil
+}
+
+// Encode encodes img in format, WebP is not supported
+func Encode(w io.Writer, img image.Image, format Format, ops ...Option) error {
+	opts := newOptions(ops)
+	switch format {
+	case JPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: opts.quality})
+	case PNG:
+		return png.Encode(w, img)
+	case GIF:
+		return gif.Encode(w, img, nil)
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+}
+
+// Resize scales img to width x height, if one of them is 0, it is calculated by the aspect ratio
+func Resize(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	switch {
+	case width <= 0 && height <= 0:
+		return img
+	case width <= 0:
+		width = (b.Dx()*height + b.Dy()/2) / b.Dy()
+	case height <= 0:
+		height = (b.Dy()*width + b.Dx()/2) / b.Dx()
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// Fit scales img down to fit in maxWidth x maxHeight by the aspect ratio, small images are not enlarged
+func Fit(img image.Image, maxWidth, maxHeight int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= maxWidth && b.Dy() <= maxHeight {
+		return img
+	}
+	if b.Dx()*maxHeight > b.Dy()*maxWidth {
+		return Resize(img, maxWidth, 0)
+	}
+	return Resize(img, 0, maxHeight)
+}
+
+// Crop returns the part of img in rect
+func Crop(img image.Image, rect image.Rectangle) image.Image {
+	rect = rect.Add(img.Bounds().Min).Intersect(img.Bounds())
+	dst := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst
+}
+
+// Thumbnail scales img to cover width x height, and crops the center part of it
+func Thumbnail(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	if b.Dx()*height > b.Dy()*width {
+		img = Resize(img, 0, height)
+	} else {
+		img = Resize(img, width, 0)
+	}
+	b = img.Bounds()
+	x, y := (b.Dx()-width)/2, (b.Dy()-height)/2
+	return Crop(img, image.Rect(x, y, x+width, y+height))
+}
+
+// Orient transforms img by the EXIF orientation, so that it is upright
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirror horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirror vertical
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 270 clockwise
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
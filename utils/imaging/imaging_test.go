@@ -0,0 +1,134 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// testImage is 40x20, the left half is red and the right half is blue
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			c := color.NRGBA{R: 255, A: 255}
+			if x >= 20 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// withOrientation inserts an EXIF segment with the orientation after the SOI of the JPEG
+func withOrientation(jpg []byte, orientation uint16) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	copy(tiff, "MM")
+	binary.BigEndian.PutUint16(tiff[2:], 42)
+	binary.BigEndian.PutUint32(tiff[4:], 8)
+	binary.BigEndian.PutUint16(tiff[8:], 1)
+	binary.BigEndian.PutUint16(tiff[10:], exifOrientationTag)
+	binary.BigEndian.PutUint16(tiff[12:], 3) // SHORT
+	binary.BigEndian.PutUint32(tiff[14:], 1)
+	binary.BigEndian.PutUint16(tiff[18:], orientation)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+
+	seg := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(app1)+2))
+	out := append([]byte{}, jpg[:2]...)
+	out = append(append(out, seg...), app1...)
+	return append(out, jpg[2:]...)
+}
+
+func TestProbeAndDecode(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := Encode(buf, testImage(), JPEG, WithQuality(95)); err != nil {
+		t.Fatal(err)
+	}
+	jpg := withOrientation(buf.Bytes(), 6)
+
+	info, err := Probe(bytes.NewReader(jpg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info != (Info{Format: JPEG, Width: 20, Height: 40, Orientation: 6}) {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+
+	img, _, err := Decode(bytes.NewReader(jpg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 20 || b.Dy() != 40 {
+		t.Fatalf("unexpected bounds: %v", b)
+	}
+	// rotated 90 clockwise, the red half is on the top
+	if r, _, b, _ := img.At(10, 5).RGBA(); r>>8 < 200 || b>>8 > 50 {
+		t.Fatalf("expect red on the top, got %v", img.At(10, 5))
+	}
+	if r, _, b, _ := img.At(10, 35).RGBA(); b>>8 < 200 || r>>8 > 50 {
+		t.Fatalf("expect blue on the bottom, got %v", img.At(10, 35))
+	}
+
+	if _, _, err := Decode(bytes.NewReader(jpg), WithMaxPixels(100)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expect too large, got %v", err)
+	}
+}
+
+func TestProbePNG(t *testing.T) {
+	buf := &bytes.Buffer{}
+	png.Encode(buf, testImage())
+	info, err := Probe(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info != (Info{Format: PNG, Width: 40, Height: 20, Orientation: 1}) {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+	if _, err := Probe(bytes.NewReader([]byte("not an image"))); err == nil {
+		t.Fatal("expect unknown format")
+	}
+}
+
+func TestOrient(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	src.SetNRGBA(0, 0, color.NRGBA{R: 1, A: 255})
+	// where the top-left pixel goes for each orientation
+	for orientation, expected := range map[int]image.Point{
+		1: {0, 0}, 2: {2, 0}, 3: {2, 1}, 4: {0, 1}, 5: {0, 0}, 6: {1, 0}, 7: {1, 2}, 8: {0, 2},
+	} {
+		dst := Orient(src, orientation).(*image.NRGBA)
+		if dst.NRGBAAt(expected.X, expected.Y).R != 1 {
+			t.Fatalf("orientation %d: expect the pixel at %v", orientation, expected)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	img := testImage()
+	if b := Resize(img, 20, 0).Bounds(); b.Dx() != 20 || b.Dy() != 10 {
+		t.Fatalf("unexpected resize: %v", b)
+	}
+	if b := Fit(img, 10, 10).Bounds(); b.Dx() != 10 || b.Dy() != 5 {
+		t.Fatalf("unexpected fit: %v", b)
+	}
+	if Fit(img, 100, 100) != image.Image(img) {
+		t.Fatal("expect small image not enlarged")
+	}
+	thumb := Thumbnail(img, 10, 10)
+	if b := thumb.Bounds(); b.Dx() != 10 || b.Dy() != 10 {
+		t.Fatalf("unexpected thumbnail: %v", b)
+	}
+	crop := Crop(img, image.Rect(30, 0, 50, 10))
+	if b := crop.Bounds(); b.Dx() != 10 || b.Dy() != 10 {
+		t.Fatalf("unexpected crop: %v", b)
+	}
+	if err := Encode(&bytes.Buffer{}, img, WebP); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("expect webp encoding unsupported, got %v", err)
+	}
+}
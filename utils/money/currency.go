@@ -0,0 +1,46 @@
+package money
+
+import (
+	"strings"
+	"sync"
+)
+
+// Currency is an ISO 4217 currency
+type Currency struct {
+	Code string
+	// Digits is the count of the decimal digits of the minor unit, 2 for USD and 0 for JPY
+	Digits int
+}
+
+var (
+	currenciesMu sync.RWMutex
+	currencies   = map[string]Currency{}
+)
+
+func init() {
+	for digits, codes := range map[int]string{
+		0: "BIF CLP DJF GNF ISK JPY KMF KRW PYG RWF UGX UYI VND VUV XAF XOF XPF",
+		2: "AED ARS AUD BDT BGN BRL CAD CHF CNY COP CZK DKK EGP EUR GBP HKD HUF IDR ILS INR KES KZT " +
+			"MAD MXN MYR NGN NOK NZD PEN PHP PKR PLN QAR RON RUB SAR SEK SGD THB TRY TWD UAH USD ZAR",
+		3: "BHD IQD JOD KWD LYD OMR TND",
+	} {
+		for _, code := range strings.Fields(codes) {
+			currencies[code] = Currency{Code: code, Digits: digits}
+		}
+	}
+}
+
+// RegisterCurrency adds or replaces a currency, e.g. for the virtual currencies like points
+func RegisterCurrency(c Currency) {
+	currenciesMu.Lock()
+	defer currenciesMu.Unlock()
+	currencies[strings.ToUpper(c.Code)] = Currency{Code: strings.ToUpper(c.Code), Digits: c.Digits}
+}
+
+// LookupCurrency returns the currency of the code, case insensitive
+func LookupCurrency(code string) (Currency, bool) {
+	currenciesMu.RLock()
+	defer currenciesMu.RUnlock()
+	c, ok := currencies[strings.ToUpper(code)]
+	return c, ok
+}
@@ -0,0 +1,424 @@
+// Package money does the exact arithmetic of the monetary amounts.
+//
+// A Money is an int64 count of the minor units(cents) of its currency, so the operations never
+// lose precision like float64 does, and the results that can not be represented, like a
+// third of a cent, are rounded explicitly by a RoundingMode.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrCurrencyMismatch is returned when operating the amounts of different currencies
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	// ErrOverflow is returned when the result exceeds int64 minor units
+	ErrOverflow = errors.New("amount overflow")
+	// ErrInvalidAmount is returned when parsing an invalid amount
+	ErrInvalidAmount = errors.New("invalid amount")
+	// ErrUnknownCurrency is returned for an unknown currency code
+	ErrUnknownCurrency = errors.New("unknown currency")
+)
+
+// RoundingMode decides how to round the results between two minor units
+type RoundingMode int
+
+// rounding modes
+const (
+	// HalfUp rounds half away from zero, 0.125 -> 0.13, -0.125 -> -0.13
+	HalfUp RoundingMode = iota
+	// HalfEven rounds half to the even one, also known as the banker's rounding, 0.125 -> 0.12
+	HalfEven
+	// Down rounds towards zero, i.e. truncates, 0.129 -> 0.12
+	Down
+	// Up rounds away from zero, 0.121 -> 0.13
+	Up
+	// Floor rounds towards negative infinity
+	Floor
+	// Ceil rounds towards positive infinity
+	Ceil
+)
+
+// Money is an amount of a currency, the zero value is an invalid currency with 0 amount
+type Money struct {
+	amount   int64
+	currency Currency
+}
+
+// New creates a Money of amount minor units, like New(1250, "USD") for $12.50
+func New(amount int64, code string) (Money, error) {
+	c, ok := LookupCurrency(code)
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %s", ErrUnknownCurrency, code)
+	}
+	return Money{amount: amount, currency: c}, nil
+}
+
+// MustNew is like New but panics on unknown currencies
+func MustNew(amount int64, code string) Money {
+	m, err := New(amount, code)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Parse parses the decimal amount like "12.5", "-0.01", "1,234.56" in major units,
+// the digits more than the currency has are rejected
+func Parse(s, code string) (Money, error) {
+	c, ok := LookupCurrency(code)
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %s", ErrUnknownCurrency, code)
+	}
+	amount, err := parseMinor(s, c.Digits)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{amount: amount, currency: c}, nil
+}
+
+// ParseRound is like Parse, but the extra digits are rounded by mode
+func ParseRound(s, code string, mode RoundingMode) (Money, error) {
+	r, ok := new(big.Rat).SetString(strings.Replace(strings.TrimSpace(s), ",", "", -1))
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	return FromRat(r, code, mode)
+}
+
+// FromFloat converts f in major units, by its shortest decimal representation,
+// so that 0.1 is exactly 10 cents
+func FromFloat(f float64, code string, mode RoundingMode) (Money, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Money{}, fmt.Errorf("%w: %v", ErrInvalidAmount, f)
+	}
+	return ParseRound(strconv.FormatFloat(f, 'f', -1, 64), code, mode)
+}
+
+// FromRat converts r in major units
+func FromRat(r *big.Rat, code string, mode RoundingMode) (Money, error) {
+	c, ok := LookupCurrency(code)
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %s", ErrUnknownCurrency, code)
+	}
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(pow10Big(c.Digits)))
+	q, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	// compare 2*|rem| with the denominator to decide the rounding
+	half := new(big.Int).Abs(rem)
+	half.Lsh(half, 1)
+	adj := roundAdjust(mode, q.Sign() < 0 || scaled.Sign() < 0, rem.Sign() != 0, half.Cmp(scaled.Denom()), q.Bit(0) == 1)
+	q.Add(q, big.NewInt(adj))
+	if !q.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+	return Money{amount: q.Int64(), currency: c}, nil
+}
+
+func pow10Big(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// parseMinor parses s into minor units without any rounding
+func parseMinor(s string, digits int) (int64, error) {
+	raw := s
+	s = strings.Replace(strings.TrimSpace(s), ",", "", -1)
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg, s = true, s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	if intPart == "" && fracPart == "" || !allDigits(intPart) || !allDigits(fracPart) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, raw)
+	}
+	if len(strings.TrimRight(fracPart, "0")) > digits {
+		return 0, fmt.Errorf("%w: %q has more than %d decimal digits", ErrInvalidAmount, raw, digits)
+	}
+	fracPart = (fracPart + strings.Repeat("0", digits))[:digits]
+
+	var amount uint64
+	for _, ch := range intPart + fracPart {
+		hi, lo := bits.Mul64(amount, 10)
+		sum, carry := bits.Add64(lo, uint64(ch-'0'), 0)
+		if hi != 0 || carry != 0 || sum > math.MaxInt64+1 {
+			return 0, ErrOverflow
+		}
+		amount = sum
+	}
+	if neg {
+		return -int64(amount), nil
+	}
+	if amount > math.MaxInt64 {
+		return 0, ErrOverflow
+	}
+	return int64(amount), nil
+}
+
+func allDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Amount returns the amount in minor units
+func (m Money) Amount() int64 {
+	return m.amount
+}
+
+// Currency returns the currency of m
+func (m Money) Currency() Currency {
+	return m.currency
+}
+
+// IsZero reports whether the amount is 0
+func (m Money) IsZero() bool {
+	return m.amount == 0
+}
+
+// Sign returns -1, 0 or 1 by the sign of the amount
+func (m Money) Sign() int {
+	switch {
+	case m.amount < 0:
+		return -1
+	case m.amount > 0:
+		return 1
+	}
+	return 0
+}
+
+// Add returns m+o
+func (m Money) Add(o Money) (Money, error) {
+	if m.currency != o.currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.currency.Code, o.currency.Code)
+	}
+	sum := m.amount + o.amount
+	if (sum > m.amount) != (o.amount > 0) {
+		return Money{}, ErrOverflow
+	}
+	return Money{amount: sum, currency: m.currency}, nil
+}
+
+// Sub returns m-o
+func (m Money) Sub(o Money) (Money, error) {
+	if o.amount == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+	return m.Add(o.Neg())
+}
+
+// Neg returns -m
+func (m Money) Neg() Money {
+	return Money{amount: -m.amount, currency: m.currency}
+}
+
+// Abs returns |m|
+func (m Money) Abs() Money {
+	if m.amount < 0 {
+		return m.Neg()
+	}
+	return m
+}
+
+// Mul returns m*n
+func (m Money) Mul(n int64) (Money, error) {
+	return m.MulFrac(n, 1, Down)
+}
+
+// MulFrac returns m*num/den rounded by mode, like MulFrac(15, 100, HalfUp) for a 15% tax
+func (m Money) MulFrac(num, den int64, mode RoundingMode) (Money, error) {
+	if den == 0 {
+		return Money{}, fmt.Errorf("%w: zero denominator", ErrInvalidAmount)
+	}
+	neg := (m.amount < 0) != (num < 0) != (den < 0)
+	hi, lo := bits.Mul64(abs64(m.amount), abs64(num))
+	d := abs64(den)
+	if hi >= d {
+		return Money{}, ErrOverflow
+	}
+	q, rem := bits.Div64(hi, lo, d)
+	// 2*rem compared with d, without overflow
+	cmp := 0
+	switch {
+	case rem > d-rem:
+		cmp = 1
+	case rem < d-rem:
+		cmp = -1
+	}
+	if q > math.MaxInt64 {
+		return Money{}, ErrOverflow
+	}
+	amount := int64(q)
+	if neg {
+		amount = -amount
+	}
+	adj := roundAdjust(mode, neg, rem != 0, cmp, q&1 == 1)
+	if adj > 0 && amount == math.MaxInt64 || adj < 0 && amount == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+	return Money{amount: amount + adj, currency: m.currency}, nil
+}
+
+func abs64(n int64) uint64 {
+	if n < 0 {
+		return uint64(-n)
+	}
+	return uint64(n)
+}
+
+// roundAdjust returns -1, 0 or 1 to add to the truncated quotient, inexact tells whether
+// there is a remainder, and half compares twice the remainder with the divisor
+func roundAdjust(mode RoundingMode, neg, inexact bool, half int, odd bool) int64 {
+	if !inexact {
+		return 0
+	}
+	away := int64(1)
+	if neg {
+		away = -1
+	}
+	switch mode {
+	case HalfUp:
+		if half >= 0 {
+			return away
+		}
+	case HalfEven:
+		if half > 0 || half == 0 && odd {
+			return away
+		}
+	case Up:
+		return away
+	case Floor:
+		if neg {
+			return -1
+		}
+	case Ceil:
+		if !neg {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Allocate splits m by the ratios without losing any minor unit,
+// the remainders are given to the first parts one unit each
+func (m Money) Allocate(ratios ...int) ([]Money, error) {
+	var total int64
+	for _, r := range ratios {
+		if r < 0 {
+			return nil, fmt.Errorf("%w: negative ratio %d", ErrInvalidAmount, r)
+		}
+		total += int64(r)
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("%w: zero ratios", ErrInvalidAmount)
+	}
+	parts := make([]Money, len(ratios))
+	left := m.amount
+	for i, r := range ratios {
+		part, err := m.MulFrac(int64(r), total, Down)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = part
+		left -= part.amount
+	}
+	unit := int64(1)
+	if left < 0 {
+		unit = -1
+	}
+	for i := 0; left != 0; i = (i + 1) % len(parts) {
+		if ratios[i] == 0 {
+			continue
+		}
+		parts[i].amount += unit
+		left -= unit
+	}
+	return parts, nil
+}
+
+// Split splits m into n parts as even as possible
+func (m Money) Split(n int) ([]Money, error) {
+	ratios := make([]int, n)
+	for i := range ratios {
+		ratios[i] = 1
+	}
+	return m.Allocate(ratios...)
+}
+
+// Cmp compares m and o, -1 if m < o, 0 if m == o, 1 if m > o
+func (m Money) Cmp(o Money) (int, error) {
+	if m.currency != o.currency {
+		return 0, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.currency.Code, o.currency.Code)
+	}
+	switch {
+	case m.amount < o.amount:
+		return -1, nil
+	case m.amount > o.amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Rat returns the amount in major units
+func (m Money) Rat() *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(m.amount), pow10Big(m.currency.Digits))
+}
+
+// Decimal formats the amount in major units, like "-12.50"
+func (m Money) Decimal() string {
+	u := abs64(m.amount)
+	s := strconv.FormatUint(u, 10)
+	if d := m.currency.Digits; d > 0 {
+		if len(s) <= d {
+			s = strings.Repeat("0", d-len(s)+1) + s
+		}
+		s = s[:len(s)-d] + "." + s[len(s)-d:]
+	}
+	if m.amount < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// String formats m like "12.50 USD"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.currency.Code
+}
+
+type jsonMoney struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON encodes m as {"amount":"12.50","currency":"USD"}, the amount is a string to keep the precision
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonMoney{Amount: m.Decimal(), Currency: m.currency.Code})
+}
+
+// UnmarshalJSON decodes the json encoded by MarshalJSON
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var j jsonMoney
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	parsed, err := Parse(j.Amount, j.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
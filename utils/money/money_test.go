@@ -0,0 +1,144 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	for _, c := range []struct {
+		s, code string
+		amount  int64
+		decimal string
+	}{
+		{"12.5", "USD", 1250, "12.50"},
+		{"-0.01", "usd", -1, "-0.01"},
+		{"1,234.56", "EUR", 123456, "1234.56"},
+		{".5", "CNY", 50, "0.50"},
+		{"100", "JPY", 100, "100"},
+		{"1.2340", "KWD", 1234, "1.234"},
+		{"92233720368547758.07", "USD", math.MaxInt64, "92233720368547758.07"},
+		{"-92233720368547758.08", "USD", math.MinInt64, "-92233720368547758.08"},
+	} {
+		m, err := Parse(c.s, c.code)
+		if err != nil {
+			t.Fatalf("%s: %v", c.s, err)
+		}
+		if m.Amount() != c.amount || m.Decimal() != c.decimal {
+			t.Fatalf("%s: unexpected %d %s", c.s, m.Amount(), m.Decimal())
+		}
+	}
+	for _, s := range []string{"", "-", "1.2.3", "abc", "1e5", "0.001"} {
+		if _, err := Parse(s, "USD"); !errors.Is(err, ErrInvalidAmount) {
+			t.Fatalf("%q: expect invalid, got %v", s, err)
+		}
+	}
+	if _, err := Parse("92233720368547758.08", "USD"); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expect overflow, got %v", err)
+	}
+	if _, err := Parse("1", "XYZ"); !errors.Is(err, ErrUnknownCurrency) {
+		t.Fatalf("expect unknown currency, got %v", err)
+	}
+}
+
+func TestRounding(t *testing.T) {
+	for _, c := range []struct {
+		s      string
+		mode   RoundingMode
+		amount int64
+	}{
+		{"0.125", HalfUp, 13},
+		{"-0.125", HalfUp, -13},
+		{"0.125", HalfEven, 12},
+		{"0.135", HalfEven, 14},
+		{"0.129", Down, 12},
+		{"-0.129", Down, -12},
+		{"0.121", Up, 13},
+		{"-0.121", Floor, -13},
+		{"0.121", Floor, 12},
+		{"-0.129", Ceil, -12},
+		{"-0.004", HalfUp, 0},
+		{"-0.005", HalfUp, -1},
+	} {
+		m, err := ParseRound(c.s, "USD", c.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Amount() != c.amount {
+			t.Fatalf("%s mode %d: expect %d, got %d", c.s, c.mode, c.amount, m.Amount())
+		}
+	}
+
+	m, err := FromFloat(0.1+0.2, "USD", HalfUp)
+	if err != nil || m.Amount() != 30 {
+		t.Fatalf("unexpected %v, %v", m, err)
+	}
+	m, _ = FromRat(big.NewRat(1, 3), "USD", HalfUp)
+	if m.Amount() != 33 {
+		t.Fatalf("unexpected %v", m)
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	a := MustNew(1000, "USD")
+	b := MustNew(-250, "USD")
+	if sum, err := a.Add(b); err != nil || sum.Amount() != 750 {
+		t.Fatalf("unexpected sum %v, %v", sum, err)
+	}
+	if diff, err := a.Sub(b); err != nil || diff.String() != "12.50 USD" {
+		t.Fatalf("unexpected diff %v, %v", diff, err)
+	}
+	if _, err := a.Add(MustNew(1, "EUR")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Fatalf("expect mismatch, got %v", err)
+	}
+	if _, err := MustNew(math.MaxInt64, "USD").Add(MustNew(1, "USD")); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expect overflow, got %v", err)
+	}
+	if _, err := MustNew(math.MaxInt64, "USD").Mul(2); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expect overflow, got %v", err)
+	}
+
+	// 15% of 9.99 is 1.4985
+	tax, _ := MustNew(999, "USD").MulFrac(15, 100, HalfUp)
+	if tax.Amount() != 150 {
+		t.Fatalf("unexpected tax %v", tax)
+	}
+	tax, _ = MustNew(-999, "USD").MulFrac(15, 100, Down)
+	if tax.Amount() != -149 {
+		t.Fatalf("unexpected tax %v", tax)
+	}
+	if c, _ := a.Cmp(b); c != 1 || a.Sign() != 1 || b.Abs().Amount() != 250 {
+		t.Fatal("unexpected comparison")
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	parts, err := MustNew(100, "USD").Split(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parts[0].Amount() != 34 || parts[1].Amount() != 33 || parts[2].Amount() != 33 {
+		t.Fatalf("unexpected parts %v", parts)
+	}
+	parts, _ = MustNew(-5, "USD").Allocate(70, 0, 30)
+	if parts[0].Amount() != -4 || parts[1].Amount() != 0 || parts[2].Amount() != -1 {
+		t.Fatalf("unexpected parts %v", parts)
+	}
+	if _, err := MustNew(5, "USD").Allocate(0, 0); err == nil {
+		t.Fatal("expect zero ratios rejected")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	b, err := json.Marshal(MustNew(-5, "JPY"))
+	if err != nil || string(b) != `{"amount":"-5","currency":"JPY"}` {
+		t.Fatalf("unexpected json %s, %v", b, err)
+	}
+	var m Money
+	if err := json.Unmarshal([]byte(`{"amount":"0.07","currency":"EUR"}`), &m); err != nil || m.String() != "0.07 EUR" {
+		t.Fatalf("unexpected money %v, %v", m, err)
+	}
+}
@@ -0,0 +1,186 @@
+// Package phone parses the phone numbers into the E.164 format, detects their regions and masks them.
+//
+// Only the numbering plans of the common regions are built in, the numbers of the other
+// country codes are rejected, as their lengths can not be validated.
+package phone
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidNumber is returned when the number can not be parsed
+	ErrInvalidNumber = errors.New("invalid phone number")
+	// ErrUnknownRegion is returned when the region or the country code is not supported
+	ErrUnknownRegion = errors.New("unknown phone region")
+)
+
+// Number is a parsed phone number
+type Number struct {
+	CountryCode int
+	// National is the national significant number, without the trunk prefix
+	National string
+	// Region is the detected region code, like "CN"
+	Region string
+}
+
+// Parse parses raw, which is in the international format like "+86 138-0013-8000" or "0086...",
+// or in the national format of defaultRegion like "(415) 555-0100" for "US".
+// Spaces, dashes, dots and parentheses are ignored.
+func Parse(raw, defaultRegion string) (Number, error) {
+	digits, plus, err := clean(raw)
+	if err != nil {
+		return Number{}, err
+	}
+	def, hasDefault := LookupRegion(defaultRegion)
+	switch {
+	case plus:
+		return parseInternational(raw, digits)
+	case hasDefault && def.IntlPrefix != "" && strings.HasPrefix(digits, def.IntlPrefix):
+		return parseInternational(raw, digits[len(def.IntlPrefix):])
+	case !hasDefault && strings.HasPrefix(digits, "00"):
+		return parseInternational(raw, digits[2:])
+	case !hasDefault:
+		return Number{}, fmt.Errorf("%w: %q", ErrUnknownRegion, defaultRegion)
+	}
+
+	return newNumber(raw, def.CountryCode, digits)
+}
+
+// MustParse is like Parse but panics on errors
+func MustParse(raw, defaultRegion string) Number {
+	n, err := Parse(raw, defaultRegion)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// Normalize returns the E.164 format of raw
+func Normalize(raw, defaultRegion string) (string, error) {
+	n, err := Parse(raw, defaultRegion)
+	if err != nil {
+		return "", err
+	}
+	return n.E164(), nil
+}
+
+func clean(raw string) (string, bool, error) {
+	var b strings.Builder
+	plus := false
+	for i, r := range strings.TrimSpace(raw) {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			plus = true
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')' || r == '\u00a0':
+		default:
+			return "", false, fmt.Errorf("%w: %q", ErrInvalidNumber, raw)
+		}
+	}
+	if b.Len() == 0 {
+		return "", false, fmt.Errorf("%w: %q", ErrInvalidNumber, raw)
+	}
+	return b.String(), plus, nil
+}
+
+func parseInternational(raw, digits string) (Number, error) {
+	// the country codes are prefix free, so at most one of the 1 to 3 digit prefixes matches
+	for n := 1; n <= 3 && n < len(digits); n++ {
+		cc, _ := strconv.Atoi(digits[:n])
+		if _, ok := countryCodes[cc]; ok {
+			return newNumber(raw, cc, digits[n:])
+		}
+	}
+	return Number{}, fmt.Errorf("%w: %q", ErrUnknownRegion, raw)
+}
+
+func newNumber(raw string, countryCode int, national string) (Number, error) {
+	region := regionOf(countryCode, national)
+	// strip the trunk prefix of the national format, or the one written in the international format
+	// like +44 (0)20..., unless the rest is too short, e.g. the russian 8 800... toll free numbers
+	if p := region.TrunkPrefix; p != "" && strings.HasPrefix(national, p) && len(national)-len(p) >= region.MinLen {
+		national = national[len(p):]
+		region = regionOf(countryCode, national)
+	}
+	if len(national) < region.MinLen || len(national) > region.MaxLen {
+		return Number{}, fmt.Errorf("%w: %q has %d digits, expect %d to %d for %s",
+			ErrInvalidNumber, raw, len(national), region.MinLen, region.MaxLen, region.Code)
+	}
+	return Number{CountryCode: countryCode, National: national, Region: region.Code}, nil
+}
+
+// IsValid reports whether n is parsed successfully
+func (n Number) IsValid() bool {
+	return n.CountryCode > 0 && n.National != ""
+}
+
+// E164 formats n like "+8613800138000"
+func (n Number) E164() string {
+	if !n.IsValid() {
+		return ""
+	}
+	return "+" + strconv.Itoa(n.CountryCode) + n.National
+}
+
+// String is the E.164 format of n
+func (n Number) String() string {
+	return n.E164()
+}
+
+// Mask hides the middle digits of n, like "+86 138****8000", it is safe to be logged
+func (n Number) Mask() string {
+	if !n.IsValid() {
+		return ""
+	}
+	return "+" + strconv.Itoa(n.CountryCode) + " " + maskDigits(n.National)
+}
+
+// maskDigits keeps at most the first 3 and the last 4 digits, and hides at least a third of them
+func maskDigits(s string) string {
+	hidden := (len(s) + 2) / 3
+	if hidden < len(s)-7 {
+		hidden = len(s) - 7
+	}
+	tail := (len(s) - hidden + 1) / 2
+	head := len(s) - hidden - tail
+	return s[:head] + strings.Repeat("*", hidden) + s[len(s)-tail:]
+}
+
+// MarshalText encodes n in E.164
+func (n Number) MarshalText() ([]byte, error) {
+	return []byte(n.E164()), nil
+}
+
+// UnmarshalText decodes a number in the international format
+func (n *Number) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*n = Number{}
+		return nil
+	}
+	parsed, err := Parse(string(text), "")
+	if err != nil {
+		return err
+	}
+	*n = parsed
+	return nil
+}
+
+var candidateRegexp = regexp.MustCompile(`\+?\d[\d \-().]{5,}\d`)
+
+// MaskText masks all the phone numbers found in the text, like the logs and the messages,
+// the national numbers are parsed by defaultRegion
+func MaskText(text, defaultRegion string) string {
+	return candidateRegexp.ReplaceAllStringFunc(text, func(s string) string {
+		n, err := Parse(s, defaultRegion)
+		if err != nil {
+			return s
+		}
+		return n.Mask()
+	})
+}
@@ -0,0 +1,98 @@
+package phone
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		raw, region string
+		e164        string
+		detected    string
+	}{
+		{"+86 138-0013-8000", "", "+8613800138000", "CN"},
+		{"008613800138000", "", "+8613800138000", "CN"},
+		{"138 0013 8000", "CN", "+8613800138000", "CN"},
+		{"010 1234 5678", "cn", "+861012345678", "CN"},
+		{"(415) 555-0100", "US", "+14155550100", "US"},
+		{"1-415-555-0100", "US", "+14155550100", "US"},
+		{"011 44 20 7946 0958", "US", "+442079460958", "GB"},
+		{"+1 416 555 0100", "", "+14165550100", "CA"},
+		{"416.555.0100", "US", "+14165550100", "CA"},
+		{"+44 (0)20 7946 0958", "", "+442079460958", "GB"},
+		{"020 7946 0958", "GB", "+442079460958", "GB"},
+		{"+852 9123 4567", "CN", "+85291234567", "HK"},
+		{"8 800 555 3535", "RU", "+78005553535", "RU"},
+		{"+7 800 555 3535", "", "+78005553535", "RU"},
+		{"06 1234 5678", "IT", "+390612345678", "IT"},
+	} {
+		n, err := Parse(c.raw, c.region)
+		if err != nil {
+			t.Fatalf("%s: %v", c.raw, err)
+		}
+		if n.E164() != c.e164 || n.Region != c.detected {
+			t.Fatalf("%s: unexpected %s %s", c.raw, n.E164(), n.Region)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, c := range []struct {
+		raw, region string
+		err         error
+	}{
+		{"", "CN", ErrInvalidNumber},
+		{"+86 138 0013 800a", "", ErrInvalidNumber},
+		{"86+13800138000", "", ErrInvalidNumber},
+		{"+86 1380013", "", ErrInvalidNumber},
+		{"+1 415 555 01000", "", ErrInvalidNumber},
+		{"13800138000", "", ErrUnknownRegion},
+		{"13800138000", "XX", ErrUnknownRegion},
+		{"+999 1234 5678", "", ErrUnknownRegion},
+	} {
+		if _, err := Parse(c.raw, c.region); !errors.Is(err, c.err) {
+			t.Fatalf("%q: expect %v, got %v", c.raw, c.err, err)
+		}
+	}
+}
+
+func TestMask(t *testing.T) {
+	for _, c := range []struct {
+		raw, masked string
+	}{
+		{"+8613800138000", "+86 138****8000"},
+		{"+14155550100", "+1 415****100"},
+		{"+85291234567", "+852 91***567"},
+		{"+4930123456", "+49 30***456"},
+	} {
+		if m := MustParse(c.raw, "").Mask(); m != c.masked {
+			t.Fatalf("%s: unexpected %s", c.raw, m)
+		}
+	}
+	if (Number{}).Mask() != "" {
+		t.Fatal("expect empty mask of the zero number")
+	}
+
+	text := "call 138 0013 8000 or +1 (415) 555-0100, order 20240101"
+	if m := MaskText(text, "CN"); m != "call +86 138****8000 or +1 415****100, order 20240101" {
+		t.Fatalf("unexpected %s", m)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var v struct {
+		Phone Number `json:"phone"`
+	}
+	if err := json.Unmarshal([]byte(`{"phone":"+86 138 0013 8000"}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(v)
+	if string(data) != `{"phone":"+8613800138000"}` {
+		t.Fatalf("unexpected %s", data)
+	}
+	if err := json.Unmarshal([]byte(`{"phone":"13800138000"}`), &v); !errors.Is(err, ErrUnknownRegion) {
+		t.Fatalf("expect unknown region, got %v", err)
+	}
+}
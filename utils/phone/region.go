@@ -0,0 +1,87 @@
+package phone
+
+import "strings"
+
+// Region is the numbering plan of a country or region
+type Region struct {
+	// Code is the ISO 3166-1 alpha-2 code, like "CN"
+	Code        string
+	CountryCode int
+	// MinLen and MaxLen are the lengths of the national significant numbers
+	MinLen int
+	MaxLen int
+	// TrunkPrefix is dialed before the national numbers inside the region, like "0" in GB
+	TrunkPrefix string
+	// IntlPrefix is dialed before the international numbers, like "00" or "011"
+	IntlPrefix string
+}
+
+var (
+	regions = map[string]*Region{}
+	// countryCodes maps a country code to its main region, e.g. 1 to US, 7 to RU
+	countryCodes = map[int]*Region{}
+)
+
+func init() {
+	for _, r := range []Region{
+		{"US", 1, 10, 10, "1", "011"},
+		{"CA", 1, 10, 10, "1", "011"},
+		{"CN", 86, 10, 11, "0", "00"},
+		{"HK", 852, 8, 8, "", "001"},
+		{"MO", 853, 8, 8, "", "00"},
+		{"TW", 886, 8, 9, "0", "002"},
+		{"JP", 81, 9, 10, "0", "010"},
+		{"KR", 82, 8, 10, "0", "001"},
+		{"SG", 65, 8, 8, "", "000"},
+		{"MY", 60, 8, 10, "0", "00"},
+		{"TH", 66, 8, 9, "0", "001"},
+		{"VN", 84, 9, 10, "0", "00"},
+		{"ID", 62, 8, 12, "0", "001"},
+		{"PH", 63, 8, 10, "0", "00"},
+		{"IN", 91, 10, 10, "0", "00"},
+		{"AU", 61, 9, 9, "0", "0011"},
+		{"NZ", 64, 8, 10, "0", "00"},
+		{"GB", 44, 9, 10, "0", "00"},
+		{"DE", 49, 6, 13, "0", "00"},
+		{"FR", 33, 9, 9, "0", "00"},
+		// the leading 0 of the italian landlines is a part of the national number
+		{"IT", 39, 6, 11, "", "00"},
+		{"ES", 34, 9, 9, "", "00"},
+		{"NL", 31, 9, 9, "0", "00"},
+		{"RU", 7, 10, 10, "8", "810"},
+		{"AE", 971, 8, 9, "0", "00"},
+		{"BR", 55, 10, 11, "0", "00"},
+		{"MX", 52, 10, 10, "", "00"},
+	} {
+		r := r
+		regions[r.Code] = &r
+		if _, ok := countryCodes[r.CountryCode]; !ok {
+			countryCodes[r.CountryCode] = &r
+		}
+	}
+}
+
+// LookupRegion returns the numbering plan of the region code, case insensitive
+func LookupRegion(code string) (*Region, bool) {
+	r, ok := regions[strings.ToUpper(code)]
+	return r, ok
+}
+
+// canadianAreaCodes distinguish CA from US in the North American Numbering Plan
+var canadianAreaCodes = map[string]bool{}
+
+func init() {
+	for _, code := range strings.Fields("204 226 236 249 250 263 289 306 343 354 365 367 368 382 387 403 416 418 428 " +
+		"431 437 438 450 468 474 506 514 519 548 579 581 584 587 600 604 613 639 647 672 683 705 709 742 753 " +
+		"778 780 782 807 819 825 867 873 879 902 905") {
+		canadianAreaCodes[code] = true
+	}
+}
+
+// regionOf detects the region of a national number of the country code
+func regionOf(countryCode int, national string) *Region {
+	if countryCode == 1 && len(national) >= 3 && canadianAreaCodes[national[:3]] {
+		return regions["CA"]
+	}
+	return countryCodes[countryCode]
+}
@@ -33,3 +33,17 @@ func TestRotate(t *testing.T) {
 	}
 
 }
+
+func TestRotateAllocs(t *testing.T) {
+	// the split items and the joined string
+	if n := testing.AllocsPerRun(1000, func() { Rotate("api.v1.orders.list", ".") }); n > 2 {
+		t.Fatalf("rotate allocates %v times per run, expected at most 2", n)
+	}
+}
+
+func BenchmarkRotate(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Rotate("api.v1.orders.list", ".")
+	}
+}
@@ -0,0 +1,186 @@
+package semver
+
+import (
+	"fmt"
+	"strings"
+)
+
+type operator string
+
+// supported constraint operators
+const (
+	opEQ    operator = "="
+	opNE    operator = "!="
+	opGT    operator = ">"
+	opGE    operator = ">="
+	opLT    operator = "<"
+	opLE    operator = "<="
+	opTilde operator = "~"
+	opCaret operator = "^"
+)
+
+// operators ordered so that the longer prefix matches first
+var operators = []operator{opNE, opGE, opLE, "==", opEQ, opGT, opLT, opTilde, opCaret}
+
+type condition struct {
+	op      operator
+	version *Version
+}
+
+func (c condition) match(v *Version) bool {
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case opEQ:
+		return cmp == 0
+	case opNE:
+		return cmp != 0
+	case opGT:
+		return cmp > 0
+	case opGE:
+		return cmp >= 0
+	case opLT:
+		return cmp < 0
+	case opLE:
+		return cmp <= 0
+	case opTilde:
+		// ~1.2.3 := >=1.2.3 <1.3.0
+		return cmp >= 0 && v.Major == c.version.Major && v.Minor == c.version.Minor
+	case opCaret:
+		// ^1.2.3 := >=1.2.3 <2.0.0, ^0.2.3 := >=0.2.3 <0.3.0, ^0.0.3 := >=0.0.3 <0.0.4
+		if cmp < 0 || v.Major != c.version.Major {
+			return false
+		}
+		if c.version.Major > 0 {
+			return true
+		}
+		if v.Minor != c.version.Minor {
+			return false
+		}
+		if c.version.Minor > 0 {
+			return true
+		}
+		return v.Patch == c.version.Patch
+	}
+	return false
+}
+
+func (c condition) String() string {
+	return string(c.op) + c.version.String()
+}
+
+// Constraint is a set of version conditions, like ">=1.2.0 <2.0.0 || ^3.1.0".
+// Conditions separated by spaces or commas must all match,
+// groups separated by "||" match if any of them matches.
+type Constraint struct {
+	groups [][]condition
+}
+
+// ParseConstraint parses a constraint expression
+func ParseConstraint(raw string) (*Constraint, error) {
+	c := &Constraint{}
+	for _, group := range strings.Split(raw, "||") {
+		fields := strings.FieldsFunc(group, func(r rune) bool {
+			return r == ' ' || r == ','
+		})
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid constraint %q: empty condition group", raw)
+		}
+		conds := make([]condition, 0, len(fields))
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			// allow a space between the operator and the version, like ">= 1.2.0"
+			if isOperator(field) && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+			cond, err := parseCondition(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid constraint %q: %s", raw, err)
+			}
+			conds = append(conds, cond)
+		}
+		c.groups = append(c.groups, conds)
+	}
+	return c, nil
+}
+
+// MustParseConstraint is like ParseConstraint but panics if the constraint can not be parsed
+func MustParseConstraint(raw string) *Constraint {
+	c, err := ParseConstraint(raw)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func isOperator(s string) bool {
+	for _, op := range operators {
+		if s == string(op) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCondition(s string) (condition, error) {
+	op := opEQ
+	for _, candidate := range operators {
+		if strings.HasPrefix(s, string(candidate)) {
+			op = candidate
+			s = s[len(candidate):]
+			break
+		}
+	}
+	if op == "==" {
+		op = opEQ
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return condition{}, err
+	}
+	return condition{op: op, version: v}, nil
+}
+
+// Check reports whether the version satisfies the constraint
+func (c *Constraint) Check(v *Version) bool {
+	for _, group := range c.groups {
+		matched := true
+		for _, cond := range group {
+			if !cond.match(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the normalized constraint expression
+func (c *Constraint) String() string {
+	groups := make([]string, 0, len(c.groups))
+	for _, group := range c.groups {
+		conds := make([]string, 0, len(group))
+		for _, cond := range group {
+			conds = append(conds, cond.String())
+		}
+		groups = append(groups, strings.Join(conds, " "))
+	}
+	return strings.Join(groups, " || ")
+}
+
+// Satisfies reports whether the version string satisfies the constraint expression,
+// an unparsable version or constraint never satisfies
+func Satisfies(version, constraint string) bool {
+	v, err := Parse(version)
+	if err != nil {
+		return false
+	}
+	c, err := ParseConstraint(constraint)
+	if err != nil {
+		return false
+	}
+	return c.Check(v)
+}
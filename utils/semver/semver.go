@@ -0,0 +1,213 @@
+// Package semver parses and compares semantic versions (https://semver.org),
+// e.g. for feature gating against versions reported by dependencies.
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed semantic version
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	PreRelease []string
+	Build      []string
+}
+
+// Parse will parse a version string like "v1.2.3-rc.1+build.5",
+// the leading "v" is optional, and minor/patch can be omitted("1.2" == "1.2.0")
+func Parse(raw string) (*Version, error) {
+	s := strings.TrimSpace(raw)
+	s = strings.TrimPrefix(s, "v")
+	if len(s) == 0 {
+		return nil, fmt.Errorf("invalid version %q: empty", raw)
+	}
+
+	v := &Version{}
+	if idx := strings.IndexByte(s, '+'); idx >= 0 {
+		build := s[idx+1:]
+		s = s[:idx]
+		ids, err := splitIdentifiers(build, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q: build %s", raw, err)
+		}
+		v.Build = ids
+	}
+	if idx := strings.IndexByte(s, '-'); idx >= 0 {
+		pre := s[idx+1:]
+		s = s[:idx]
+		ids, err := splitIdentifiers(pre, true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q: pre-release %s", raw, err)
+		}
+		v.PreRelease = ids
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("invalid version %q: too many parts", raw)
+	}
+	nums := [3]uint64{}
+	for i, p := range parts {
+		n, err := parseNumber(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q: %s", raw, err)
+		}
+		nums[i] = n
+	}
+	v.Major, v.Minor, v.Patch = nums[0], nums[1], nums[2]
+	return v, nil
+}
+
+// MustParse is like Parse but panics if the version can not be parsed
+func MustParse(raw string) *Version {
+	v, err := Parse(raw)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func parseNumber(s string) (uint64, error) {
+	if len(s) == 0 {
+		return 0, fmt.Errorf("empty number")
+	}
+	if len(s) > 1 && s[0] == '0' {
+		return 0, fmt.Errorf("number %q has leading zero", s)
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
+
+func splitIdentifiers(s string, checkNumber bool) ([]string, error) {
+	ids := strings.Split(s, ".")
+	for _, id := range ids {
+		if len(id) == 0 {
+			return nil, fmt.Errorf("has empty identifier")
+		}
+		for _, c := range id {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+				return nil, fmt.Errorf("identifier %q has invalid character", id)
+			}
+		}
+		if checkNumber && isNumeric(id) && len(id) > 1 && id[0] == '0' {
+			return nil, fmt.Errorf("identifier %q has leading zero", id)
+		}
+	}
+	return ids, nil
+}
+
+func isNumeric(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// String will return the canonical format of the version, without the leading "v"
+func (v *Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.PreRelease) > 0 {
+		s += "-" + strings.Join(v.PreRelease, ".")
+	}
+	if len(v.Build) > 0 {
+		s += "+" + strings.Join(v.Build, ".")
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 when v is less than, equal to or greater than o.
+// Build metadata is ignored as the spec requires.
+func (v *Version) Compare(o *Version) int {
+	if c := compareUint(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	return comparePreRelease(v.PreRelease, o.PreRelease)
+}
+
+// LessThan reports whether v < o
+func (v *Version) LessThan(o *Version) bool {
+	return v.Compare(o) < 0
+}
+
+// GreaterThan reports whether v > o
+func (v *Version) GreaterThan(o *Version) bool {
+	return v.Compare(o) > 0
+}
+
+// Equal reports whether v == o, ignoring build metadata
+func (v *Version) Equal(o *Version) bool {
+	return v.Compare(o) == 0
+}
+
+// Compare two version strings, invalid versions are always less than valid ones
+func Compare(a, b string) int {
+	va, erra := Parse(a)
+	vb, errb := Parse(b)
+	switch {
+	case erra != nil && errb != nil:
+		return strings.Compare(a, b)
+	case erra != nil:
+		return -1
+	case errb != nil:
+		return 1
+	}
+	return va.Compare(vb)
+}
+
+func compareUint(a, b uint64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func comparePreRelease(a, b []string) int {
+	// a version without pre-release has higher precedence
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	if len(a) == 0 {
+		return 1
+	}
+	if len(b) == 0 {
+		return -1
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		an, bn := isNumeric(a[i]), isNumeric(b[i])
+		switch {
+		case an && bn:
+			x, _ := strconv.ParseUint(a[i], 10, 64)
+			y, _ := strconv.ParseUint(b[i], 10, 64)
+			if c := compareUint(x, y); c != 0 {
+				return c
+			}
+		case an:
+			return -1
+		case bn:
+			return 1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareUint(uint64(len(a)), uint64(len(b)))
+}
@@ -0,0 +1,103 @@
+package semver
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		raw    string
+		expect string
+		ok     bool
+	}{
+		{"1.2.3", "1.2.3", true},
+		{"v1.2.3", "1.2.3", true},
+		{"1.2", "1.2.0", true},
+		{"2", "2.0.0", true},
+		{"1.2.3-rc.1+build.5", "1.2.3-rc.1+build.5", true},
+		{"1.2.3+exp.sha.5114f85", "1.2.3+exp.sha.5114f85", true},
+		{"", "", false},
+		{"1.2.3.4", "", false},
+		{"01.2.3", "", false},
+		{"1.2.3-01", "", false},
+		{"1.2.3-", "", false},
+		{"1.a.3", "", false},
+	}
+
+	for _, tc := range testCases {
+		v, err := Parse(tc.raw)
+		if (err == nil) != tc.ok {
+			t.Fatalf("parse %q: expect ok=%v, got err %v", tc.raw, tc.ok, err)
+		}
+		if err == nil && v.String() != tc.expect {
+			t.Fatalf("parse %q: expect %s, got %s", tc.raw, tc.expect, v)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// ordered from the spec example
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.1.0",
+		"2.0.0",
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		if Compare(ordered[i], ordered[i+1]) != -1 {
+			t.Fatalf("expect %s < %s", ordered[i], ordered[i+1])
+		}
+		if Compare(ordered[i+1], ordered[i]) != 1 {
+			t.Fatalf("expect %s > %s", ordered[i+1], ordered[i])
+		}
+	}
+	if Compare("1.0.0+a", "1.0.0+b") != 0 {
+		t.Fatal("build metadata should be ignored")
+	}
+	if Compare("invalid", "1.0.0") != -1 {
+		t.Fatal("invalid version should be less than a valid one")
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	testCases := []struct {
+		constraint string
+		version    string
+		expect     bool
+	}{
+		{">=1.2.0 <2.0.0", "1.2.0", true},
+		{">=1.2.0 <2.0.0", "1.9.9", true},
+		{">=1.2.0 <2.0.0", "2.0.0", false},
+		{">=1.2.0, <2.0.0", "1.1.9", false},
+		{">= 1.2.0 < 2.0.0", "1.5.0", true},
+		{"1.2.3", "1.2.3", true},
+		{"==1.2.3", "1.2.4", false},
+		{"!=1.2.3", "1.2.4", true},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "2.0.0", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.0.3", "0.0.4", false},
+		{"<1.0.0 || >=3.0.0", "3.1.0", true},
+		{"<1.0.0 || >=3.0.0", "2.0.0", false},
+		{">1.0.0", "1.0.1-rc.1", true},
+	}
+	for _, tc := range testCases {
+		if got := Satisfies(tc.version, tc.constraint); got != tc.expect {
+			t.Fatalf("%s satisfies %q: expect %v, got %v", tc.version, tc.constraint, tc.expect, got)
+		}
+	}
+
+	for _, invalid := range []string{"", ">=", ">=1.x", "1.0.0 ||"} {
+		if _, err := ParseConstraint(invalid); err == nil {
+			t.Fatalf("expect %q to be an invalid constraint", invalid)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Package urlutil helps to build urls from a base address, path segments and
+// query structs, and to bind query strings back into structs.
+//
+// Struct fields are mapped with the `url` tag:
+//
+//	type ListOptions struct {
+//		Page    int       `url:"page"`
+//		Tags    []string  `url:"tag,omitempty"`
+//		Since   time.Time `url:"since,omitempty"`
+//		Ignored string    `url:"-"`
+//	}
+//
+// Fields without a tag use their name, embedded structs are flattened.
+package urlutil
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ErrUnsafePath is returned when a path segment tries to escape the base path
+var ErrUnsafePath = errors.New("unsafe path segment")
+
+// JoinPath escapes every segment and appends them to the base path.
+// Segments like "." and ".." are rejected, and a "/" inside a segment is escaped,
+// so the result can never escape from the base path.
+func JoinPath(base string, segments ...string) (string, error) {
+	escaped := make([]string, 0, len(segments)+1)
+	escaped = append(escaped, strings.TrimSuffix(base, "/"))
+	for _, seg := range segments {
+		if seg == "" || seg == "." || seg == ".." {
+			return "", fmt.Errorf("%w: %q", ErrUnsafePath, seg)
+		}
+		escaped = append(escaped, url.PathEscape(seg))
+	}
+	return strings.Join(escaped, "/"), nil
+}
+
+// SafeJoin joins a relative path under the root directory, the result is cleaned and
+// guaranteed to be inside root, otherwise ErrUnsafePath will be returned.
+// It is useful for mapping url paths to local files.
+func SafeJoin(root, rel string) (string, error) {
+	root = path.Clean(root)
+	joined := path.Join(root, rel)
+	switch {
+	case joined == root:
+	case root == ".":
+		if joined == ".." || strings.HasPrefix(joined, "../") || path.IsAbs(joined) {
+			return "", fmt.Errorf("%w: %q", ErrUnsafePath, rel)
+		}
+	case !strings.HasPrefix(joined, strings.TrimSuffix(root, "/")+"/"):
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, rel)
+	}
+	return joined, nil
+}
+
+// Build will create a url from the base address, the path segments and the query,
+// query can be a url.Values, a map[string]string or a tagged struct.
+// Query values already present in base will be kept.
+func Build(base string, segments []string, query interface{}) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	if len(segments) > 0 {
+		p, err := JoinPath(u.EscapedPath(), segments...)
+		if err != nil {
+			return "", err
+		}
+		u.RawPath = p
+		if u.Path, err = url.PathUnescape(p); err != nil {
+			return "", err
+		}
+	}
+	if query != nil {
+		values, err := Values(query)
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		for k, vs := range values {
+			for _, v := range vs {
+				q.Add(k, v)
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
+}
+
+// Values converts the query object into url.Values,
+// supported objects are url.Values, map[string]string, map[string][]string and tagged structs
+func Values(query interface{}) (url.Values, error) {
+	switch q := query.(type) {
+	case nil:
+		return url.Values{}, nil
+	case url.Values:
+		return q, nil
+	case map[string][]string:
+		return url.Values(q), nil
+	case map[string]string:
+		values := url.Values{}
+		for k, v := range q {
+			values.Set(k, v)
+		}
+		return values, nil
+	}
+	return encodeStruct(query)
+}
+
+// ParseQuery parses the raw query string and binds the values into the struct pointed by v
+func ParseQuery(rawQuery string, v interface{}) error {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return err
+	}
+	return Decode(values, v)
+}
@@ -0,0 +1,154 @@
+package urlutil
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Paging struct {
+	Page int `url:"page"`
+	Size int `url:"size,omitempty"`
+}
+
+type listOptions struct {
+	Paging
+	Name    string        `url:"name"`
+	Tags    []string      `url:"tag,omitempty"`
+	Since   time.Time     `url:"since,omitempty"`
+	Timeout time.Duration `url:"timeout,omitempty"`
+	Deleted *bool         `url:"deleted,omitempty"`
+	Ignored string        `url:"-"`
+	Raw     string
+	private string
+}
+
+func TestBuild(t *testing.T) {
+	deleted := false
+	since := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	opts := &listOptions{
+		Paging:  Paging{Page: 2},
+		Name:    "a b",
+		Tags:    []string{"x", "y"},
+		Since:   since,
+		Timeout: time.Second,
+		Deleted: &deleted,
+		Ignored: "ignored",
+		Raw:     "raw",
+		private: "private",
+	}
+
+	u, err := Build("http://127.0.0.1:8080/api/v1?debug=true", []string{"users", "a/b"}, opts)
+	if err != nil {
+		t.Fatal("build url failed:", err)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatal("parse url failed:", err)
+	}
+	if parsed.EscapedPath() != "/api/v1/users/a%2Fb" {
+		t.Fatalf("unexpected path: %s", parsed.EscapedPath())
+	}
+	expect := url.Values{
+		"debug":   {"true"},
+		"page":    {"2"},
+		"name":    {"a b"},
+		"tag":     {"x", "y"},
+		"since":   {"2018-01-02T03:04:05Z"},
+		"timeout": {"1s"},
+		"deleted": {"false"},
+		"Raw":     {"raw"},
+	}
+	if !reflect.DeepEqual(parsed.Query(), expect) {
+		t.Fatalf("unexpected query: %v", parsed.Query())
+	}
+
+	var decoded listOptions
+	if err := ParseQuery(parsed.RawQuery, &decoded); err != nil {
+		t.Fatal("parse query failed:", err)
+	}
+	opts.Ignored, opts.private = "", ""
+	if !reflect.DeepEqual(&decoded, opts) {
+		t.Fatalf("unexpected decoded options: %+v", decoded)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	var opts listOptions
+	if err := ParseQuery("page=abc", &opts); err == nil {
+		t.Fatal("expect decode failed for invalid int")
+	}
+	if err := Decode(url.Values{}, opts); err == nil {
+		t.Fatal("expect decode failed for non-pointer target")
+	}
+}
+
+type filter struct {
+	Keyword string `url:"q"`
+}
+
+func TestEmbeddedUnexported(t *testing.T) {
+	// the unexported embedded pointers are ignored, the exported fields of the embedded values are promoted
+	type query struct {
+		*filter
+		Paging
+	}
+	v, err := Values(&query{filter: &filter{Keyword: "x"}, Paging: Paging{Page: 1}})
+	if err != nil || !reflect.DeepEqual(v, url.Values{"page": {"1"}}) {
+		t.Fatalf("unexpected values: %v, %v", v, err)
+	}
+	var q query
+	if err := ParseQuery("q=x&page=3", &q); err != nil || q.filter != nil || q.Page != 3 {
+		t.Fatalf("unexpected decoded query: %+v, %v", q, err)
+	}
+
+	type values struct {
+		filter
+	}
+	var vs values
+	if err := ParseQuery("q=x", &vs); err != nil || vs.Keyword != "x" {
+		t.Fatalf("unexpected decoded values: %+v, %v", vs, err)
+	}
+}
+
+func TestJoinPath(t *testing.T) {
+	p, err := JoinPath("/api/", "files", "../etc/passwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != "/api/files/..%2Fetc%2Fpasswd" {
+		t.Fatalf("unexpected path: %s", p)
+	}
+	for _, seg := range []string{"..", ".", ""} {
+		if _, err := JoinPath("/api", seg); !errors.Is(err, ErrUnsafePath) {
+			t.Fatalf("expect unsafe path error for %q, got %v", seg, err)
+		}
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	testCases := []struct {
+		root   string
+		rel    string
+		expect string
+		ok     bool
+	}{
+		{"/var/www", "index.html", "/var/www/index.html", true},
+		{"/var/www", "/a/../b.html", "/var/www/b.html", true},
+		{"/var/www", "../etc/passwd", "", false},
+		{"/var/www", "a/../../www2/x", "", false},
+		{"/var/www", "", "/var/www", true},
+		{"files", "a/b.txt", "files/a/b.txt", true},
+		{"files", "../b.txt", "", false},
+		{".", "a/../b.txt", "b.txt", true},
+		{".", "../b.txt", "", false},
+	}
+	for _, tc := range testCases {
+		got, err := SafeJoin(tc.root, tc.rel)
+		if (err == nil) != tc.ok || got != tc.expect {
+			t.Fatalf("safe join %q %q: expect %q(ok=%v), got %q, %v", tc.root, tc.rel, tc.expect, tc.ok, got, err)
+		}
+	}
+}
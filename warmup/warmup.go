@@ -0,0 +1,199 @@
+// Package warmup runs the warmup of the components at startup, like filling the db pools,
+// loading the caches and parsing the templates, before the service reports ready
+package warmup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tools-go/go-utils/trace"
+)
+
+// Result is the result of warming up a component
+type Result struct {
+	Name     string
+	Required bool
+	Duration time.Duration
+	Err      error
+}
+
+// Observer is called with the result of every component, it's used to export the metrics
+type Observer func(ctx context.Context, r Result)
+
+type options struct {
+	concurrency int
+	timeout     time.Duration
+	observer    Observer
+}
+
+// Option for the Coordinator
+type Option func(opts *options)
+
+// WithConcurrency sets how many components warm up in parallel, the default is 4
+func WithConcurrency(n int) Option {
+	return func(opts *options) {
+		opts.concurrency = n
+	}
+}
+
+// WithTimeout sets the timeout of warming up a component, there is no timeout by default
+func WithTimeout(timeout time.Duration) Option {
+	return func(opts *options) {
+		opts.timeout = timeout
+	}
+}
+
+// WithObserver sets the observer of the results
+func WithObserver(o Observer) Option {
+	return func(opts *options) {
+		opts.observer = o
+	}
+}
+
+type component struct {
+	name     string
+	warm     func(ctx context.Context) error
+	required bool
+}
+
+// Coordinator warms up the registered components, and reports ready when the required ones are done
+type Coordinator struct {
+	opts options
+
+	mu         sync.Mutex
+	components []component
+	results    []Result
+
+	ready int32
+}
+
+// New creates a Coordinator
+func New(ops ...Option) *Coordinator {
+	opts := options{concurrency: 4}
+	for _, op := range ops {
+		op(&opts)
+	}
+	if opts.concurrency < 1 {
+		opts.concurrency = 1
+	}
+	return &Coordinator{opts: opts}
+}
+
+// Register registers a required component, the service is not ready if it fails
+func (c *Coordinator) Register(name string, warm func(ctx context.Context) error) {
+	c.register(component{name: name, warm: warm, required: true})
+}
+
+// RegisterOptional registers a component whose failure is logged but doesn't block the readiness,
+// like a cache which can be loaded lazily
+func (c *Coordinator) RegisterOptional(name string, warm func(ctx context.Context) error) {
+	c.register(component{name: name, warm: warm})
+}
+
+func (c *Coordinator) register(comp component) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.components = append(c.components, comp)
+}
+
+// Run warms up the components with bounded parallelism, and flips the readiness if all the required ones succeed,
+// it returns the errors of the failed required components
+func (c *Coordinator) Run(ctx context.Context) error {
+	tracer := trace.GetTraceFromContext(ctx)
+	c.mu.Lock()
+	components := append([]component(nil), c.components...)
+	c.mu.Unlock()
+
+	start := time.Now()
+	results := make([]Result, len(components))
+	sem := make(chan struct{}, c.opts.concurrency)
+	var wg sync.WaitGroup
+	for i, comp := range components {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, comp component) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = c.warm(ctx, comp)
+		}(i, comp)
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, r := range results {
+		if r.Err != nil && r.Required {
+			failed = append(failed, fmt.Sprintf("%s: %s", r.Name, r.Err))
+		}
+	}
+	sorted := append([]Result(nil), results...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Duration > sorted[j].Duration })
+	c.mu.Lock()
+	c.results = sorted
+	c.mu.Unlock()
+
+	if len(failed) > 0 {
+		tracer.Errorf("event=[warmup-failed] duration=[%s] failed=[%s]", time.Since(start), strings.Join(failed, "; "))
+		return fmt.Errorf("warmup failed: %s", strings.Join(failed, "; "))
+	}
+	atomic.StoreInt32(&c.ready, 1)
+	tracer.Infof("event=[warmup-done] duration=[%s] components=[%d]", time.Since(start), len(results))
+	return nil
+}
+
+func (c *Coordinator) warm(ctx context.Context, comp component) (r Result) {
+	tracer := trace.GetTraceFromContext(ctx)
+	r = Result{Name: comp.name, Required: comp.required}
+	if c.opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	defer func() {
+		if e := recover(); e != nil {
+			r.Err = fmt.Errorf("panic: %v", e)
+		}
+		r.Duration = time.Since(start)
+		if r.Err != nil {
+			tracer.Warnf("event=[warmup] component=[%s] required=[%v] duration=[%s] err=[%s]", r.Name, r.Required, r.Duration, r.Err)
+		} else {
+			tracer.Infof("event=[warmup] component=[%s] required=[%v] duration=[%s]", r.Name, r.Required, r.Duration)
+		}
+		if c.opts.observer != nil {
+			c.opts.observer(ctx, r)
+		}
+	}()
+	r.Err = comp.warm(ctx)
+	return r
+}
+
+// Ready reports whether the required components are warmed up
+func (c *Coordinator) Ready() bool {
+	return atomic.LoadInt32(&c.ready) == 1
+}
+
+// Results returns the results of the last run, the slowest first
+func (c *Coordinator) Results() []Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Result(nil), c.results...)
+}
+
+// Readyz replies 200 if ready, or 503 before the warmup is done, it's used as the readiness probe
+func (c *Coordinator) Readyz(w http.ResponseWriter, r *http.Request) {
+	if !c.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("warming up"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(http.StatusText(http.StatusOK)))
+}
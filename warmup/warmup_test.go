@@ -0,0 +1,81 @@
+package warmup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	var running, maxRunning int32
+	var mu sync.Mutex
+	observed := map[string]Result{}
+	c := New(WithConcurrency(2), WithTimeout(50*time.Millisecond), WithObserver(func(ctx context.Context, r Result) {
+		mu.Lock()
+		observed[r.Name] = r
+		mu.Unlock()
+	}))
+	slow := func(ctx context.Context) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			old := atomic.LoadInt32(&maxRunning)
+			if n <= old || atomic.CompareAndSwapInt32(&maxRunning, old, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	c.Register("db", slow)
+	c.Register("templates", slow)
+	c.Register("config", slow)
+	c.RegisterOptional("cache", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	w := httptest.NewRecorder()
+	c.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable || c.Ready() {
+		t.Fatal("expect not ready before the warmup")
+	}
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	c.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK || !c.Ready() {
+		t.Fatal("expect ready after the warmup")
+	}
+	if maxRunning > 2 {
+		t.Fatalf("expect at most 2 in parallel, got %d", maxRunning)
+	}
+	results := c.Results()
+	if len(results) != 4 || results[0].Name != "cache" || results[0].Err != context.DeadlineExceeded {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if len(observed) != 4 || observed["db"].Duration < 10*time.Millisecond {
+		t.Fatalf("unexpected observed %+v", observed)
+	}
+}
+
+func TestRunFailed(t *testing.T) {
+	c := New()
+	c.Register("db", func(ctx context.Context) error { return errors.New("connection refused") })
+	c.Register("templates", func(ctx context.Context) error { panic("bad template") })
+	err := c.Run(context.Background())
+	if err == nil || c.Ready() {
+		t.Fatal("expect the warmup failed")
+	}
+	for _, r := range c.Results() {
+		if r.Err == nil {
+			t.Fatalf("%s: expect error", r.Name)
+		}
+	}
+}